@@ -0,0 +1,126 @@
+package cpucache
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+// maxWorkingSet caps the largest buffer a benchmark allocates. Server
+// parts report L3 sizes in the hundreds of megabytes, and a multiple of
+// that does not fit on a laptop.
+const maxWorkingSet = 256 << 20
+
+var sinkInt int
+
+// levels returns working-set sizes that sit inside each cache level and
+// one that spills past the last, all derived from the detected sizes.
+func levels() []struct {
+	name string
+	size int
+} {
+	s := Detect()
+	return []struct {
+		name string
+		size int
+	}{
+		{"L1", s.L1D / 2},
+		{"L2", s.L2 / 2},
+		{"L3", min(s.L3/2, maxWorkingSet/2)},
+		{"DRAM", min(s.L3*4, maxWorkingSet)},
+	}
+}
+
+// BenchmarkTraverse reads one word per cache line over buffers sized to
+// each detected level. The sequential walk is carried by the hardware
+// prefetcher almost regardless of size; the random walk is a dependent
+// pointer chase, so each load pays the full latency of whichever level the
+// buffer lives in.
+func BenchmarkTraverse(b *testing.B) {
+	line := Detect().Line / 8
+	for _, lv := range levels() {
+		n := lv.size / 8 / line // lines in the buffer
+		buf := make([]int, n*line)
+
+		// Link every line into a single cycle, in address order or in a
+		// random order.
+		seq := make([]int, n)
+		for i := range seq {
+			seq[i] = i
+		}
+		rnd := make([]int, n)
+		copy(rnd, seq)
+		rand.Shuffle(n, func(i, j int) { rnd[i], rnd[j] = rnd[j], rnd[i] })
+
+		for _, order := range []struct {
+			name string
+			idx  []int
+		}{{"sequential", seq}, {"random", rnd}} {
+			for i, l := range order.idx {
+				buf[l*line] = order.idx[(i+1)%n] * line
+			}
+			b.Run(fmt.Sprintf("%s-%dKiB/%s", lv.name, lv.size>>10, order.name), func(b *testing.B) {
+				p := 0
+				loads := 0
+				for b.Loop() {
+					for range n {
+						p = buf[p]
+					}
+					loads += n
+				}
+				sinkInt = p
+				b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(loads), "ns/load")
+			})
+		}
+	}
+}
+
+// BenchmarkTranspose compares the naive and tiled transposes on matrices
+// sized against the detected L2. The tile side comes from the detected L1D;
+// a quarter and four times that side are run alongside it for comparison.
+// Sides are kept off powers of two so the naive column walk does not also
+// hit the same cache set on every write.
+func BenchmarkTranspose(b *testing.B) {
+	s := Detect()
+	tile := Tile(s.L1D, 8)
+	for _, ws := range []struct {
+		name  string
+		bytes int
+	}{
+		{"in-L2", s.L2 / 4},
+		{"4xL2", s.L2 * 4},
+		{"max", maxWorkingSet / 4},
+	} {
+		n := side(ws.bytes/2/8, tile)
+		src := make([]float64, n*n)
+		dst := make([]float64, n*n)
+		for i := range src {
+			src[i] = float64(i)
+		}
+		name := fmt.Sprintf("%s-n=%d-%dKiB", ws.name, n, 2*8*n*n>>10)
+		b.Run(name+"/naive", func(b *testing.B) {
+			b.SetBytes(int64(8 * n * n))
+			for b.Loop() {
+				Transpose(dst, src, n)
+			}
+		})
+		for _, t := range []int{tile / 4, tile, tile * 4} {
+			b.Run(fmt.Sprintf("%s/tile=%d", name, t), func(b *testing.B) {
+				b.SetBytes(int64(8 * n * n))
+				for b.Loop() {
+					TransposeBlocked(dst, src, n, t)
+				}
+			})
+		}
+	}
+}
+
+// side returns a matrix side close to sqrt(elems) that is a multiple of
+// tile plus half a tile, which keeps it away from powers of two.
+func side(elems, tile int) int {
+	n := tile
+	for (n+tile)*(n+tile) <= elems {
+		n += tile
+	}
+	return n + tile/2
+}
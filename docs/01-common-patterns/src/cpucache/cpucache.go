@@ -0,0 +1,152 @@
+// Package cpucache reports the data cache sizes of the machine it runs on,
+// so cache-sensitive benchmarks can size their working sets from the real
+// hardware instead of from numbers copied out of a datasheet. Sizes are
+// read from sysfs on Linux, from CPUID on amd64 elsewhere, and fall back to
+// typical values when neither is available.
+package cpucache
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+)
+
+// Cache describes one cache level as seen by a single core.
+type Cache struct {
+	Level    int
+	Type     string // "Data", "Instruction" or "Unified"
+	Size     int    // bytes
+	LineSize int    // bytes
+}
+
+// Sizes holds the caches a data-oriented benchmark cares about.
+type Sizes struct {
+	L1D    int
+	L2     int
+	L3     int
+	Line   int
+	Source string // "sysfs", "cpuid" or "default"
+}
+
+// Defaults are used for any level that cannot be detected. They match a
+// common desktop or server core: 32 KiB L1D, 1 MiB L2, 32 MiB L3.
+var Defaults = Sizes{L1D: 32 << 10, L2: 1 << 20, L3: 32 << 20, Line: 64, Source: "default"}
+
+var (
+	detectOnce sync.Once
+	detected   Sizes
+)
+
+// Detect returns the cache sizes of the current machine. The result is
+// computed once and cached.
+func Detect() Sizes {
+	detectOnce.Do(func() {
+		detected = detect()
+	})
+	return detected
+}
+
+func detect() Sizes {
+	if cs := fromSysfs(os.DirFS("/sys/devices/system/cpu/cpu0/cache")); len(cs) > 0 {
+		return summarize(cs, "sysfs")
+	}
+	if cs := fromCPUID(); len(cs) > 0 {
+		return summarize(cs, "cpuid")
+	}
+	return Defaults
+}
+
+// summarize picks the data and unified levels out of cs and fills in
+// anything missing from Defaults.
+func summarize(cs []Cache, source string) Sizes {
+	s := Sizes{Source: source}
+	for _, c := range cs {
+		if c.Type == "Instruction" {
+			continue
+		}
+		switch c.Level {
+		case 1:
+			s.L1D = c.Size
+		case 2:
+			s.L2 = c.Size
+		case 3:
+			s.L3 = c.Size
+		}
+		if c.Level == 1 && c.LineSize > 0 {
+			s.Line = c.LineSize
+		}
+	}
+	if s.L1D == 0 {
+		s.L1D = Defaults.L1D
+	}
+	if s.L2 == 0 {
+		s.L2 = Defaults.L2
+	}
+	if s.L3 == 0 {
+		s.L3 = Defaults.L3
+	}
+	if s.Line == 0 {
+		s.Line = Defaults.Line
+	}
+	return s
+}
+
+// fromSysfs reads the index* directories of a Linux
+// /sys/devices/system/cpu/cpuN/cache tree.
+func fromSysfs(fsys fs.FS) []Cache {
+	dirs, err := fs.Glob(fsys, "index*")
+	if err != nil {
+		return nil
+	}
+	var cs []Cache
+	for _, d := range dirs {
+		level, err := strconv.Atoi(readAttr(fsys, d, "level"))
+		if err != nil {
+			continue
+		}
+		size, err := parseSize(readAttr(fsys, d, "size"))
+		if err != nil {
+			continue
+		}
+		line, _ := strconv.Atoi(readAttr(fsys, d, "coherency_line_size"))
+		cs = append(cs, Cache{
+			Level:    level,
+			Type:     readAttr(fsys, d, "type"),
+			Size:     size,
+			LineSize: line,
+		})
+	}
+	return cs
+}
+
+func readAttr(fsys fs.FS, dir, name string) string {
+	b, err := fs.ReadFile(fsys, path.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return string(bytes.TrimSpace(b))
+}
+
+// parseSize parses sysfs sizes such as "48K" or "2048K". The suffixes are
+// binary multiples.
+func parseSize(s string) (int, error) {
+	mult := 1
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			mult, s = 1<<10, s[:n-1]
+		case 'M':
+			mult, s = 1<<20, s[:n-1]
+		case 'G':
+			mult, s = 1<<30, s[:n-1]
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	return v * mult, nil
+}
@@ -0,0 +1,123 @@
+package cpucache
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int
+	}{
+		{"48K", 48 << 10},
+		{"2048K", 2 << 20},
+		{"32M", 32 << 20},
+		{"1G", 1 << 30},
+		{"512", 512},
+	} {
+		got, err := parseSize(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", tc.in, got, err, tc.want)
+		}
+	}
+	for _, in := range []string{"", "K", "12X"} {
+		if _, err := parseSize(in); err == nil {
+			t.Errorf("parseSize(%q) succeeded", in)
+		}
+	}
+}
+
+func TestFromSysfs(t *testing.T) {
+	index := func(level, typ, size string) map[string]*fstest.MapFile {
+		return map[string]*fstest.MapFile{
+			"level":               {Data: []byte(level + "\n")},
+			"type":                {Data: []byte(typ + "\n")},
+			"size":                {Data: []byte(size + "\n")},
+			"coherency_line_size": {Data: []byte("64\n")},
+		}
+	}
+	fsys := fstest.MapFS{}
+	for i, f := range []map[string]*fstest.MapFile{
+		index("1", "Data", "48K"),
+		index("1", "Instruction", "32K"),
+		index("2", "Unified", "2048K"),
+		index("3", "Unified", "30M"),
+	} {
+		for name, file := range f {
+			fsys[fmt.Sprintf("index%d/%s", i, name)] = file
+		}
+	}
+
+	got := summarize(fromSysfs(fsys), "sysfs")
+	want := Sizes{L1D: 48 << 10, L2: 2 << 20, L3: 30 << 20, Line: 64, Source: "sysfs"}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestSummarizeDefaults(t *testing.T) {
+	got := summarize([]Cache{{Level: 1, Type: "Data", Size: 64 << 10}}, "cpuid")
+	want := Defaults
+	want.L1D, want.Source = 64<<10, "cpuid"
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	s := Detect()
+	t.Logf("%+v", s)
+	if s.L1D <= 0 || s.L2 < s.L1D || s.L3 < s.L2 {
+		t.Errorf("implausible sizes %+v", s)
+	}
+	if s.Line <= 0 || s.Line&(s.Line-1) != 0 {
+		t.Errorf("line size %d is not a power of two", s.Line)
+	}
+	if cs := fromCPUID(); len(cs) > 0 {
+		t.Logf("cpuid: %+v", summarize(cs, "cpuid"))
+	}
+}
+
+func TestTile(t *testing.T) {
+	for _, tc := range []struct{ cache, elem, want int }{
+		{32 << 10, 8, 32},
+		{48 << 10, 8, 32},
+		{64 << 10, 8, 32},
+		{128 << 10, 8, 64},
+		{32 << 10, 4, 32},
+		{8, 8, 1},
+	} {
+		if got := Tile(tc.cache, tc.elem); got != tc.want {
+			t.Errorf("Tile(%d, %d) = %d, want %d", tc.cache, tc.elem, got, tc.want)
+		}
+	}
+}
+
+func TestTransposeBlocked(t *testing.T) {
+	for _, n := range []int{1, 7, 32, 100} {
+		src := make([]float64, n*n)
+		for i := range src {
+			src[i] = rand.Float64()
+		}
+		want := make([]float64, n*n)
+		Transpose(want, src, n)
+		for i := range n {
+			for j := range n {
+				if want[j*n+i] != src[i*n+j] {
+					t.Fatalf("n=%d: Transpose wrong at (%d, %d)", n, i, j)
+				}
+			}
+		}
+		for _, b := range []int{1, 8, 32, 128} {
+			got := make([]float64, n*n)
+			TransposeBlocked(got, src, n, b)
+			if !slices.Equal(got, want) {
+				t.Errorf("n=%d b=%d: blocked transpose differs", n, b)
+			}
+		}
+	}
+}
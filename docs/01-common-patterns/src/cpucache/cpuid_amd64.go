@@ -0,0 +1,60 @@
+package cpucache
+
+// cpuid executes the CPUID instruction with the given leaf in EAX and
+// subleaf in ECX.
+func cpuid(leaf, sub uint32) (eax, ebx, ecx, edx uint32)
+
+// fromCPUID enumerates caches with the deterministic cache parameters
+// leaf: 4 on Intel, 0x8000001D on AMD (which requires TOPOEXT). Both leaves
+// share the same register layout.
+func fromCPUID() []Cache {
+	maxLeaf, b, c, d := cpuid(0, 0)
+	var leaf uint32
+	switch {
+	case b == 0x756e6547 && d == 0x49656e69 && c == 0x6c65746e: // GenuineIntel
+		if maxLeaf < 4 {
+			return nil
+		}
+		leaf = 4
+	case b == 0x68747541 && d == 0x69746e65 && c == 0x444d4163: // AuthenticAMD
+		maxExt, _, _, _ := cpuid(0x80000000, 0)
+		if maxExt < 0x8000001d {
+			return nil
+		}
+		if _, _, ext, _ := cpuid(0x80000001, 0); ext&(1<<22) == 0 {
+			return nil
+		}
+		leaf = 0x8000001d
+	default:
+		return nil
+	}
+
+	var cs []Cache
+	for sub := uint32(0); sub < 16; sub++ {
+		a, b, c, _ := cpuid(leaf, sub)
+		var typ string
+		switch a & 0x1f {
+		case 0:
+			return cs
+		case 1:
+			typ = "Data"
+		case 2:
+			typ = "Instruction"
+		case 3:
+			typ = "Unified"
+		default:
+			continue
+		}
+		ways := int(b>>22) + 1
+		partitions := int(b>>12&0x3ff) + 1
+		line := int(b&0xfff) + 1
+		sets := int(c) + 1
+		cs = append(cs, Cache{
+			Level:    int(a >> 5 & 7),
+			Type:     typ,
+			Size:     ways * partitions * line * sets,
+			LineSize: line,
+		})
+	}
+	return cs
+}
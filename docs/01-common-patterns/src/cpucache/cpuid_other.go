@@ -0,0 +1,5 @@
+//go:build !amd64
+
+package cpucache
+
+func fromCPUID() []Cache { return nil }
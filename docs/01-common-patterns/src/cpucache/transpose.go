@@ -0,0 +1,46 @@
+package cpucache
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Transpose writes the transpose of the n×n row-major matrix src into dst.
+// Reads walk src by row and writes walk dst by column, so once a column of
+// dst no longer fits in cache every write misses.
+func Transpose(dst, src []float64, n int) {
+	for i := range n {
+		for j, v := range src[i*n : i*n+n] {
+			dst[j*n+i] = v
+		}
+	}
+}
+
+// TransposeBlocked computes the same result as Transpose one b×b tile at a
+// time. A source tile and a destination tile together stay resident in L1
+// when b is chosen by Tile, so each line fetched is used b times before it
+// is evicted.
+func TransposeBlocked(dst, src []float64, n, b int) {
+	for ii := 0; ii < n; ii += b {
+		iEnd := min(ii+b, n)
+		for jj := 0; jj < n; jj += b {
+			jEnd := min(jj+b, n)
+			for i := ii; i < iEnd; i++ {
+				for j, v := range src[i*n+jj : i*n+jEnd] {
+					dst[(jj+j)*n+i] = v
+				}
+			}
+		}
+	}
+}
+
+// Tile returns the largest power-of-two tile side b such that two b×b tiles
+// of elem-byte elements fit in half of a cache of the given size, leaving
+// the other half for everything else the loop touches.
+func Tile(cache, elem int) int {
+	b := int(math.Sqrt(float64(cache / 2 / (2 * elem))))
+	if b < 1 {
+		return 1
+	}
+	return 1 << (bits.Len(uint(b)) - 1)
+}
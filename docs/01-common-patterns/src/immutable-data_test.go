@@ -0,0 +1,158 @@
+package perf
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// HotConfig is the kind of state every request reads and an operator
+// occasionally replaces: a few scalars and a feature map, never modified
+// after it is published.
+type HotConfig struct {
+	LogLevel string
+	Timeout  time.Duration
+	Limit    int
+	Features map[string]bool
+}
+
+func newHotConfig(gen int) *HotConfig {
+	return &HotConfig{
+		LogLevel: "info",
+		Timeout:  5 * time.Second,
+		Limit:    gen,
+		Features: map[string]bool{"beta": gen%2 == 0},
+	}
+}
+
+// configStore is one way of publishing the current config.
+type configStore interface {
+	Load() *HotConfig
+	Store(*HotConfig)
+}
+
+// store-start
+type pointerStore struct{ p atomic.Pointer[HotConfig] }
+
+func (s *pointerStore) Load() *HotConfig   { return s.p.Load() }
+func (s *pointerStore) Store(c *HotConfig) { s.p.Store(c) }
+
+type valueStore struct{ v atomic.Value }
+
+func (s *valueStore) Load() *HotConfig   { return s.v.Load().(*HotConfig) }
+func (s *valueStore) Store(c *HotConfig) { s.v.Store(c) }
+
+type rwMutexStore struct {
+	mu sync.RWMutex
+	c  *HotConfig
+}
+
+func (s *rwMutexStore) Load() *HotConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.c
+}
+
+func (s *rwMutexStore) Store(c *HotConfig) {
+	s.mu.Lock()
+	s.c = c
+	s.mu.Unlock()
+}
+
+// store-end
+
+var hotSink atomic.Int64
+
+// BenchmarkHotConfig reads the config from 1, 8 and 64 goroutines while a
+// writer replaces it never, every millisecond, every 100µs, or as fast as
+// it can. ns/op is wall time per read across all readers, so its inverse
+// is the aggregate read rate; writes/s is the rate the writer achieved.
+func BenchmarkHotConfig(b *testing.B) {
+	stores := []struct {
+		name string
+		new  func() configStore
+	}{
+		{"atomic.Pointer", func() configStore { return &pointerStore{} }},
+		{"atomic.Value", func() configStore { return &valueStore{} }},
+		{"RWMutex", func() configStore { return &rwMutexStore{} }},
+	}
+	writes := []struct {
+		name  string
+		every time.Duration // 0: never, -1: back to back
+	}{
+		{"never", 0},
+		{"1ms", time.Millisecond},
+		{"100us", 100 * time.Microsecond},
+		{"continuous", -1},
+	}
+	for _, w := range writes {
+		for _, readers := range []int{1, 8, 64} {
+			for _, st := range stores {
+				name := fmt.Sprintf("writes=%s/readers=%d/%s", w.name, readers, st.name)
+				b.Run(name, func(b *testing.B) {
+					benchHotConfig(b, st.new(), readers, w.every)
+				})
+			}
+		}
+	}
+}
+
+func benchHotConfig(b *testing.B, s configStore, readers int, every time.Duration) {
+	s.Store(newHotConfig(0))
+
+	stop := make(chan struct{})
+	var nwrites atomic.Int64
+	var writer sync.WaitGroup
+	if every != 0 {
+		writer.Add(1)
+		go func() {
+			defer writer.Done()
+			for gen := 1; ; gen++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				s.Store(newHotConfig(gen))
+				nwrites.Add(1)
+				if every > 0 {
+					time.Sleep(every)
+				}
+			}
+		}()
+		for nwrites.Load() == 0 {
+			runtime.Gosched() // let the writer start before timing
+		}
+	}
+
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for r := range readers {
+		n := b.N / readers
+		if r < b.N%readers {
+			n++
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var sum int64
+			for range n {
+				c := s.Load()
+				if c.Features["beta"] {
+					sum += int64(c.Limit)
+				}
+				sum += int64(c.Timeout)
+			}
+			hotSink.Add(sum)
+		}()
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	close(stop)
+	writer.Wait()
+	b.ReportMetric(float64(nwrites.Load())/b.Elapsed().Seconds(), "writes/s")
+}
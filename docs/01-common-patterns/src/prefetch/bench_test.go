@@ -0,0 +1,88 @@
+package prefetch
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/astavonin/go-optimization-guide/docs/01-common-patterns/src/cpucache"
+)
+
+var sink uint64
+
+var distances = []int{1, 2, 4, 8, 16, 32, 64}
+
+// sizes returns a working set that fits in L2 and one well past the last
+// level cache, capped so the benchmark runs on small machines.
+func sizes() []struct {
+	name  string
+	nodes int
+} {
+	c := cpucache.Detect()
+	return []struct {
+		name  string
+		nodes int
+	}{
+		{"L2", c.L2 / 2 / 64},
+		{"DRAM", min(c.L3*4, 256<<20) / 64},
+	}
+}
+
+// BenchmarkList walks a randomly linked list plainly and with jump-pointer
+// prefetching at each distance. The plain walk is one dependent miss per
+// node; prefetching d hops ahead lets up to d misses overlap.
+func BenchmarkList(b *testing.B) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, sz := range sizes() {
+		head, _ := NewList(sz.nodes, rng)
+		prefix := fmt.Sprintf("%s-%dKiB", sz.name, sz.nodes*64>>10)
+		b.Run(prefix+"/plain", func(b *testing.B) {
+			for b.Loop() {
+				sink = Walk(head)
+			}
+			perNode(b, sz.nodes)
+		})
+		for _, d := range distances {
+			SetJumps(head, d)
+			b.Run(fmt.Sprintf("%s/d=%d", prefix, d), func(b *testing.B) {
+				for b.Loop() {
+					sink = WalkPrefetch(head)
+				}
+				perNode(b, sz.nodes)
+			})
+		}
+	}
+}
+
+// BenchmarkIndex sums nodes through a shuffled index, with and without a
+// prefetch d entries ahead. Here the addresses are known in advance and
+// the loads do not depend on each other.
+func BenchmarkIndex(b *testing.B) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, sz := range sizes() {
+		_, slab := NewList(sz.nodes, rng)
+		index := make([]*Node, len(slab))
+		for i, j := range rng.Perm(len(slab)) {
+			index[i] = &slab[j]
+		}
+		prefix := fmt.Sprintf("%s-%dKiB", sz.name, sz.nodes*64>>10)
+		b.Run(prefix+"/plain", func(b *testing.B) {
+			for b.Loop() {
+				sink = Sum(index)
+			}
+			perNode(b, sz.nodes)
+		})
+		for _, d := range distances {
+			b.Run(fmt.Sprintf("%s/d=%d", prefix, d), func(b *testing.B) {
+				for b.Loop() {
+					sink = SumPrefetch(index, d)
+				}
+				perNode(b, sz.nodes)
+			})
+		}
+	}
+}
+
+func perNode(b *testing.B, nodes int) {
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N)/float64(nodes), "ns/node")
+}
@@ -0,0 +1,97 @@
+// Package prefetch issues software prefetch hints and measures when they
+// pay off on pointer-chasing walks. Go has no prefetch intrinsic, so Touch
+// is a one-instruction assembly function: PREFETCHT0 on amd64, PRFM
+// PLDL1KEEP on arm64, and a no-op elsewhere. Assembly is never inlined, so
+// each hint also costs a call.
+package prefetch
+
+import (
+	"math/rand/v2"
+	"unsafe"
+)
+
+// Node is one element of a linked list and fills exactly one 64-byte cache
+// line, so every hop in a walk is a separate line fetch.
+type Node struct {
+	Next *Node
+	Jump *Node // set by SetJumps; a node further down the list
+	Val  [6]uint64
+}
+
+// NewList returns the head of an n-node list whose nodes are linked in a
+// random order through one contiguous slab. Hardware prefetchers follow
+// strides, not pointers, so they cannot help with this walk; the slab is
+// returned as well so callers can build an index over the same nodes.
+func NewList(n int, rng *rand.Rand) (*Node, []Node) {
+	slab := make([]Node, n)
+	order := rng.Perm(n)
+	for i, idx := range order {
+		slab[idx].Val[0] = uint64(i)
+		if i+1 < n {
+			slab[idx].Next = &slab[order[i+1]]
+		}
+	}
+	return &slab[order[0]], slab
+}
+
+// SetJumps points every node's Jump at the node d hops ahead, or at nil
+// near the tail. These jump pointers are what make prefetching a linked
+// list possible: the address d hops ahead is otherwise unknown until the
+// walk gets there.
+func SetJumps(head *Node, d int) {
+	lead := head
+	for range d {
+		if lead == nil {
+			break
+		}
+		lead = lead.Next
+	}
+	for n := head; n != nil; n = n.Next {
+		n.Jump = lead
+		if lead != nil {
+			lead = lead.Next
+		}
+	}
+}
+
+// Walk sums every node in the list.
+func Walk(head *Node) uint64 {
+	var sum uint64
+	for n := head; n != nil; n = n.Next {
+		sum += n.Val[0]
+	}
+	return sum
+}
+
+// WalkPrefetch is Walk with a prefetch of each node's Jump target, which
+// keeps up to d line fetches in flight instead of one.
+func WalkPrefetch(head *Node) uint64 {
+	var sum uint64
+	for n := head; n != nil; n = n.Next {
+		Touch(unsafe.Pointer(n.Jump))
+		sum += n.Val[0]
+	}
+	return sum
+}
+
+// Sum adds up the nodes referenced by an index. The loads are independent,
+// so an out-of-order core already overlaps several of them without help.
+func Sum(index []*Node) uint64 {
+	var sum uint64
+	for _, n := range index {
+		sum += n.Val[0]
+	}
+	return sum
+}
+
+// SumPrefetch is Sum with a prefetch of the node d entries ahead.
+func SumPrefetch(index []*Node, d int) uint64 {
+	var sum uint64
+	for i, n := range index {
+		if i+d < len(index) {
+			Touch(unsafe.Pointer(index[i+d]))
+		}
+		sum += n.Val[0]
+	}
+	return sum
+}
@@ -0,0 +1,57 @@
+package prefetch
+
+import (
+	"math/rand/v2"
+	"testing"
+	"unsafe"
+)
+
+func TestWalk(t *testing.T) {
+	const n = 1000
+	head, slab := NewList(n, rand.New(rand.NewPCG(1, 2)))
+	if got := unsafe.Sizeof(Node{}); got != 64 {
+		t.Fatalf("Node is %d bytes, want 64", got)
+	}
+
+	want := uint64(n * (n - 1) / 2)
+	if got := Walk(head); got != want {
+		t.Fatalf("Walk = %d, want %d", got, want)
+	}
+	for _, d := range []int{0, 1, 7, n - 1, n, 2 * n} {
+		SetJumps(head, d)
+		i := 0
+		for node := head; node != nil; node = node.Next {
+			var exp *Node
+			if i+d < n {
+				exp = node
+				for range d {
+					exp = exp.Next
+				}
+			}
+			if node.Jump != exp {
+				t.Fatalf("d=%d: node %d jumps to the wrong node", d, i)
+			}
+			i++
+		}
+		if got := WalkPrefetch(head); got != want {
+			t.Fatalf("d=%d: WalkPrefetch = %d, want %d", d, got, want)
+		}
+	}
+
+	index := make([]*Node, n)
+	for i := range slab {
+		index[i] = &slab[i]
+	}
+	for _, d := range []int{1, 8, n} {
+		if got := SumPrefetch(index, d); got != Sum(index) || got != want {
+			t.Fatalf("d=%d: SumPrefetch = %d, want %d", d, got, want)
+		}
+	}
+}
+
+func TestTouch(t *testing.T) {
+	// Prefetch hints never fault.
+	Touch(nil)
+	x := 1
+	Touch(unsafe.Pointer(&x))
+}
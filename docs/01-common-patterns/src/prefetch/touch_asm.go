@@ -0,0 +1,11 @@
+//go:build amd64 || arm64
+
+package prefetch
+
+import "unsafe"
+
+// Touch hints that the cache line holding p will be read soon. It never
+// faults, so p may point anywhere, including nil.
+//
+//go:noescape
+func Touch(p unsafe.Pointer)
@@ -0,0 +1,8 @@
+//go:build !amd64 && !arm64
+
+package prefetch
+
+import "unsafe"
+
+// Touch does nothing on this architecture.
+func Touch(p unsafe.Pointer) {}
@@ -0,0 +1,69 @@
+//go:build linux
+
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestBuckets(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 10000 {
+		d := time.Duration(rng.Int64N(int64(time.Hour)))
+		i := bucket(d)
+		lo, hi := lowerBound(i), lowerBound(i+1)
+		if d < lo || d >= hi {
+			t.Fatalf("%v in bucket %d, [%v, %v)", d, i, lo, hi)
+		}
+		if hi-lo > max(lo/subBuckets, 1) {
+			t.Fatalf("bucket %d is [%v, %v), wider than 1/%d of its bound", i, lo, hi, subBuckets)
+		}
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	var h hist
+	for i := 1; i <= 1000; i++ {
+		h.record(time.Duration(i) * time.Microsecond)
+	}
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{{0.5, 500 * time.Microsecond}, {0.99, 990 * time.Microsecond}, {1, time.Millisecond}} {
+		got := h.quantile(tc.q)
+		if got > tc.want || got < tc.want-tc.want/subBuckets {
+			t.Errorf("quantile(%v) = %v, want within a bucket below %v", tc.q, got, tc.want)
+		}
+	}
+	var empty hist
+	if q := empty.quantile(0.99); q != 0 {
+		t.Errorf("empty histogram: %v", q)
+	}
+}
+
+// TestModels runs every model briefly and checks that the clients see no
+// errors, and that only the line-per-string handler allocates per
+// request.
+func TestModels(t *testing.T) {
+	cfg := config{conns: 8, size: 64, warmup: 50 * time.Millisecond, duration: 200 * time.Millisecond}
+	for _, m := range models {
+		t.Run(m.name, func(t *testing.T) {
+			r, err := run(m, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.requests == 0 || r.errors != 0 || r.lat.n != r.requests {
+				t.Fatalf("%d requests, %d latencies, %d errors", r.requests, r.lat.n, r.errors)
+			}
+			allocs := r.perRequest(r.allocs)
+			if m.name == "net" && allocs < 1.5 {
+				t.Errorf("%.2f allocations per request, want about 2: a string and its copy", allocs)
+			}
+			if m.name != "net" && allocs > 0.1 {
+				t.Errorf("%.2f allocations per request, want 0", allocs)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+//go:build linux
+
+// Command abbench compares the two ways this section builds a TCP
+// server, a goroutine per connection and an epoll event loop, on the same
+// load and in the same process. It starts each server on a loopback port,
+// drives it with closed-loop echo clients, and prints a row per run:
+//
+//	go run ./abbench -conns 1000 -size 64 -duration 10s
+//	go run ./abbench -models net-buf,epoll -conns 10000 -rounds 3
+//
+// The models are net (echo-net.go's handler: a bufio.Reader, a string per
+// line and a read deadline per line), net-buf (a goroutine per
+// connection reading into a buffer it keeps, so nothing is allocated per
+// message) and epoll (one reactor.Loop, as echo-epoll.go). Each row has
+// the requests per second, latency percentiles over the measured
+// interval, and the heap allocations and GC cycles per request. The
+// clients allocate nothing after they connect, so those are the server's.
+//
+// -rounds repeats the whole set, interleaved, so that a change in the
+// machine's state during the runs, another process or a thermal limit,
+// shows as rows that disagree instead of as a difference between models.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	modelsF  = flag.String("models", "net,net-buf,epoll", "Comma-separated models to run")
+	conns    = flag.Int("conns", 100, "Concurrent client connections")
+	size     = flag.Int("size", 64, "Message size in bytes, newline included")
+	warmup   = flag.Duration("warmup", time.Second, "Load before measuring starts")
+	duration = flag.Duration("duration", 5*time.Second, "Measured interval per run")
+	rounds   = flag.Int("rounds", 1, "Times to run every model, interleaved")
+)
+
+func main() {
+	flag.Parse()
+	var ms []model
+	for _, name := range strings.Split(*modelsF, ",") {
+		m, err := findModel(strings.TrimSpace(name))
+		if err != nil {
+			log.Fatal(err)
+		}
+		ms = append(ms, m)
+	}
+	cfg := config{conns: *conns, size: *size, warmup: *warmup, duration: *duration}
+
+	fmt.Printf("%d connections, %d-byte messages, %v per run, GOMAXPROCS=%d\n",
+		cfg.conns, cfg.size, cfg.duration, runtime.GOMAXPROCS(0))
+	for _, m := range ms {
+		fmt.Printf("  %-8s %s\n", m.name, m.doc)
+	}
+	fmt.Println()
+
+	var results []*result
+	for range *rounds {
+		for _, m := range ms {
+			r, err := run(m, cfg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			results = append(results, r)
+		}
+	}
+	printResults(results)
+	for _, r := range results {
+		if r.errors > 0 {
+			fmt.Fprintf(os.Stderr, "%s: %d client errors\n", r.model, r.errors)
+		}
+	}
+}
+
+func printResults(results []*result) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "model\treq/s\tp50\tp99\tp99.9\tallocs/req\tB/req\tGCs/Mreq\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%.0f\t%v\t%v\t%v\t%.2f\t%.0f\t%.1f\t\n", r.model, r.perSecond(),
+			round(r.lat.quantile(0.50)), round(r.lat.quantile(0.99)), round(r.lat.quantile(0.999)),
+			r.perRequest(r.allocs), r.perRequest(r.bytes), 1e6*r.perRequest(r.gcs))
+	}
+}
+
+// round keeps the digits a histogram bucket can vouch for.
+func round(d time.Duration) time.Duration {
+	if d >= time.Millisecond {
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(100 * time.Nanosecond)
+}
@@ -0,0 +1,161 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// config is one run's load: conns clients, each sending size-byte
+// messages back to back.
+type config struct {
+	conns    int
+	size     int
+	warmup   time.Duration
+	duration time.Duration
+}
+
+type result struct {
+	model    string
+	requests uint64
+	errors   uint64
+	elapsed  time.Duration
+	lat      hist
+	allocs   uint64 // heap objects allocated during the measured interval
+	bytes    uint64
+	gcs      uint64
+}
+
+func (r *result) perSecond() float64 { return float64(r.requests) / r.elapsed.Seconds() }
+
+func (r *result) perRequest(v uint64) float64 { return float64(v) / float64(max(r.requests, 1)) }
+
+// client is one closed-loop connection: it sends a message, waits for the
+// echo, and sends the next. It allocates nothing once started, so the
+// process's allocation counters during a run are the server's.
+type client struct {
+	conn net.Conn
+	msg  []byte
+	resp []byte
+	lat  hist
+	n    uint64
+	errs uint64
+}
+
+func (c *client) loop(measuring, stopped *atomic.Bool) {
+	for !stopped.Load() {
+		start := time.Now()
+		if _, err := c.conn.Write(c.msg); err != nil {
+			c.fail(stopped)
+			return
+		}
+		if _, err := io.ReadFull(c.conn, c.resp); err != nil {
+			c.fail(stopped)
+			return
+		}
+		if measuring.Load() {
+			c.lat.record(time.Since(start))
+			c.n++
+		}
+	}
+}
+
+// fail counts an error, unless it is the run's end closing the connection.
+func (c *client) fail(stopped *atomic.Bool) {
+	if !stopped.Load() {
+		c.errs++
+	}
+}
+
+var sampleNames = []string{
+	"/gc/heap/allocs:objects",
+	"/gc/heap/allocs:bytes",
+	"/gc/cycles/total:gc-cycles",
+}
+
+func readCounters() [3]uint64 {
+	samples := make([]metrics.Sample, len(sampleNames))
+	for i, name := range sampleNames {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+	var out [3]uint64
+	for i, s := range samples {
+		out[i] = s.Value.Uint64()
+	}
+	return out
+}
+
+// run starts m's server, connects cfg.conns clients to it, and measures
+// the interval after the warmup. Clients and server share the process
+// and its GOMAXPROCS, as they share a machine in any loopback test; what
+// the comparison shows is the difference between the models under the
+// same load, not either one's capacity on its own.
+func run(m model, cfg config) (*result, error) {
+	addr, stop, err := m.start()
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+
+	msg := append(bytes.Repeat([]byte("x"), max(cfg.size, 1)-1), '\n')
+	clients := make([]*client, cfg.conns)
+	for i := range clients {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			for _, c := range clients[:i] {
+				c.conn.Close()
+			}
+			return nil, fmt.Errorf("%s: connection %d: %w", m.name, i, err)
+		}
+		clients[i] = &client{conn: conn, msg: msg, resp: make([]byte, len(msg))}
+	}
+
+	var measuring, stopped atomic.Bool
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.loop(&measuring, &stopped)
+		}()
+	}
+
+	time.Sleep(cfg.warmup)
+	runtime.GC() // start from the same heap, whatever ran before
+	before := readCounters()
+	start := time.Now()
+	measuring.Store(true)
+	time.Sleep(cfg.duration)
+	measuring.Store(false)
+	elapsed := time.Since(start)
+	after := readCounters()
+
+	stopped.Store(true)
+	for _, c := range clients {
+		c.conn.Close()
+	}
+	wg.Wait()
+
+	r := &result{
+		model:   m.name,
+		elapsed: elapsed,
+		allocs:  after[0] - before[0],
+		bytes:   after[1] - before[1],
+		gcs:     after[2] - before[2],
+	}
+	for _, c := range clients {
+		r.requests += c.n
+		r.errors += c.errs
+		r.lat.merge(&c.lat)
+	}
+	return r, nil
+}
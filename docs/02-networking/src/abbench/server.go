@@ -0,0 +1,143 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/reactor"
+)
+
+// A model is one way of serving the echo protocol: newline-terminated
+// messages, each written back as it was received.
+type model struct {
+	name string
+	doc  string
+	// start serves on a loopback port until stop is called; stop returns
+	// once every goroutine the server started has exited.
+	start func() (addr string, stop func(), err error)
+}
+
+var models = []model{
+	{"net", "goroutine per connection, bufio.Reader.ReadString, as echo-net.go", startNet(lineEcho)},
+	{"net-buf", "goroutine per connection, one fixed buffer per connection", startNet(bufEcho)},
+	{"epoll", "one reactor loop, as echo-epoll.go", startEpoll},
+}
+
+func findModel(name string) (model, error) {
+	for _, m := range models {
+		if m.name == name {
+			return m, nil
+		}
+	}
+	return model{}, fmt.Errorf("unknown model %q", name)
+}
+
+// startNet returns a start function for a goroutine-per-connection server
+// that runs handle on each connection.
+func startNet(handle func(net.Conn)) func() (string, func(), error) {
+	return func() (string, func(), error) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return "", nil, err
+		}
+		var (
+			wg    sync.WaitGroup
+			mu    sync.Mutex
+			conns = map[net.Conn]struct{}{}
+		)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				conns[conn] = struct{}{}
+				mu.Unlock()
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					handle(conn)
+					conn.Close()
+					mu.Lock()
+					delete(conns, conn)
+					mu.Unlock()
+				}()
+			}
+		}()
+		stop := func() {
+			ln.Close()
+			mu.Lock()
+			for c := range conns {
+				c.Close()
+			}
+			mu.Unlock()
+			wg.Wait()
+		}
+		return ln.Addr().String(), stop, nil
+	}
+}
+
+// lineEcho is echo-net.go's handler without its logging: a read deadline
+// pushed back for every line, a string per line, and a []byte conversion
+// of it per write.
+func lineEcho(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write([]byte(line)); err != nil {
+			return
+		}
+	}
+}
+
+// bufEcho writes back each read as it is, from a buffer the connection
+// keeps: the goroutine model with nothing allocated per message, to
+// separate what the model costs from what lineEcho's allocations do.
+func bufEcho(conn net.Conn) {
+	buf := make([]byte, 16<<10)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// echo is the reactor handler: every chunk straight back.
+type echo struct{}
+
+func (echo) OnOpen(c *reactor.Conn)              {}
+func (echo) OnData(c *reactor.Conn, data []byte) { c.Write(data) }
+func (echo) OnClose(c *reactor.Conn, err error)  {}
+
+func startEpoll() (string, func(), error) {
+	l, err := reactor.Listen("127.0.0.1:0", echo{}, reactor.Config{})
+	if err != nil {
+		return "", nil, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- l.Run() }()
+	stop := func() {
+		l.Close()
+		if err := <-done; err != nil && !errors.Is(err, reactor.ErrClosed) {
+			fmt.Printf("epoll: %v\n", err)
+		}
+	}
+	return l.Addr().String(), stop, nil
+}
@@ -0,0 +1,249 @@
+// Package admin serves the example servers' control address: runtime
+// knobs that can be changed during a load test, next to the drain and
+// chaos endpoints.
+//
+//	GET  /knobs  every knob's value and help text as JSON
+//	POST /knobs  set the knobs given as query parameters
+//
+// A POST is all or nothing: every value is parsed and range-checked before
+// any is applied, and the whole set is applied under one lock, so two
+// requests never interleave and a typo in one parameter changes nothing.
+// Knobs store their value in an atomic, so the hot path reads them with a
+// single load and picks up a change on its next use:
+//
+//	curl -X POST 'localhost:9100/knobs?flush_every=1&gc_percent=400'
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Knob is one tunable parameter.
+type Knob interface {
+	// String returns the current value.
+	String() string
+	// Parse validates s and returns a function that applies it.
+	Parse(s string) (apply func(), err error)
+}
+
+// Int is an integer knob limited to [Min, Max].
+type Int struct {
+	v        atomic.Int64
+	Min, Max int64
+}
+
+// NewInt returns an Int set to v.
+func NewInt(v, min, max int64) *Int {
+	k := &Int{Min: min, Max: max}
+	k.v.Store(v)
+	return k
+}
+
+// Load returns the current value.
+func (k *Int) Load() int64 { return k.v.Load() }
+
+func (k *Int) String() string { return strconv.FormatInt(k.v.Load(), 10) }
+
+func (k *Int) Parse(s string) (func(), error) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if v < k.Min || v > k.Max {
+		return nil, fmt.Errorf("%d not in [%d, %d]", v, k.Min, k.Max)
+	}
+	return func() { k.v.Store(v) }, nil
+}
+
+// Duration is a time.Duration knob limited to [Min, Max].
+type Duration struct {
+	v        atomic.Int64
+	Min, Max time.Duration
+}
+
+// NewDuration returns a Duration set to v.
+func NewDuration(v, min, max time.Duration) *Duration {
+	k := &Duration{Min: min, Max: max}
+	k.v.Store(int64(v))
+	return k
+}
+
+// Load returns the current value.
+func (k *Duration) Load() time.Duration { return time.Duration(k.v.Load()) }
+
+func (k *Duration) String() string { return k.Load().String() }
+
+func (k *Duration) Parse(s string) (func(), error) {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, err
+	}
+	if v < k.Min || v > k.Max {
+		return nil, fmt.Errorf("%v not in [%v, %v]", v, k.Min, k.Max)
+	}
+	return func() { k.v.Store(int64(v)) }, nil
+}
+
+// Sampler thins out a log line that would otherwise be written for every
+// connection or request. Its value is N: one call in N is let through,
+// and 0 silences the line.
+type Sampler struct {
+	Int
+	n atomic.Uint64
+}
+
+// NewSampler returns a Sampler that lets one call in every `every`
+// through.
+func NewSampler(every int64) *Sampler {
+	s := &Sampler{Int: Int{Min: 0, Max: 1 << 30}}
+	s.v.Store(every)
+	return s
+}
+
+// Sample reports whether this call should log.
+func (s *Sampler) Sample() bool {
+	every := s.v.Load()
+	return every > 0 && (s.n.Add(1)-1)%uint64(every) == 0
+}
+
+// gcPercent is the GOGC knob. The runtime only reports the old value when
+// setting a new one, so the knob keeps its own copy.
+type gcPercent struct {
+	mu sync.Mutex
+	v  int
+}
+
+// GCPercent returns a knob for debug.SetGCPercent; -1 turns the GC off.
+func GCPercent() Knob {
+	// Read the current setting by swapping it out and back.
+	v := debug.SetGCPercent(100)
+	debug.SetGCPercent(v)
+	return &gcPercent{v: v}
+}
+
+func (k *gcPercent) String() string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return strconv.Itoa(k.v)
+}
+
+func (k *gcPercent) Parse(s string) (func(), error) {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, err
+	}
+	if v < -1 {
+		return nil, errors.New("must be -1 (off) or a percentage")
+	}
+	return func() {
+		k.mu.Lock()
+		k.v = v
+		k.mu.Unlock()
+		debug.SetGCPercent(v)
+	}, nil
+}
+
+type entry struct {
+	knob Knob
+	help string
+}
+
+// Server is the control address's handler: /knobs plus whatever else is
+// mounted with Handle.
+type Server struct {
+	mux *http.ServeMux
+
+	mu    sync.Mutex // serializes POST /knobs
+	knobs map[string]entry
+}
+
+// New returns a Server with no knobs.
+func New() *Server {
+	s := &Server{mux: http.NewServeMux(), knobs: make(map[string]entry)}
+	s.mux.HandleFunc("/knobs", s.serveKnobs)
+	return s
+}
+
+// Knob registers k under name. Names are query parameters, so they should
+// be snake_case.
+func (s *Server) Knob(name, help string, k Knob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, dup := s.knobs[name]; dup {
+		panic("admin: duplicate knob " + name)
+	}
+	s.knobs[name] = entry{knob: k, help: help}
+}
+
+// Handle mounts h at pattern, as for http.ServeMux.
+func (s *Server) Handle(pattern string, h http.Handler) { s.mux.Handle(pattern, h) }
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) { s.mux.ServeHTTP(w, r) }
+
+// ListenAndServe serves s on addr.
+func (s *Server) ListenAndServe(addr string) error { return http.ListenAndServe(addr, s) }
+
+// knobView is the JSON form of one knob.
+type knobView struct {
+	Value string `json:"value"`
+	Help  string `json:"help"`
+}
+
+func (s *Server) serveKnobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := s.set(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	view := make(map[string]knobView, len(s.knobs))
+	for name, e := range s.knobs {
+		view[name] = knobView{Value: e.knob.String(), Help: e.help}
+	}
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// set parses every parameter of r and, only if all are valid, applies them.
+func (s *Server) set(r *http.Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type change struct {
+		name, old, new string
+		apply          func()
+	}
+	var changes []change
+	for name, vals := range r.URL.Query() {
+		e, ok := s.knobs[name]
+		if !ok {
+			return fmt.Errorf("unknown knob %q", name)
+		}
+		v := vals[len(vals)-1]
+		apply, err := e.knob.Parse(v)
+		if err != nil {
+			return fmt.Errorf("%s=%q: %w", name, v, err)
+		}
+		changes = append(changes, change{name: name, old: e.knob.String(), new: v, apply: apply})
+	}
+	for _, c := range changes {
+		c.apply()
+		log.Printf("admin: %s %s -> %s", c.name, c.old, c.new)
+	}
+	return nil
+}
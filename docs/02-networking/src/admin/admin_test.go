@@ -0,0 +1,142 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime/debug"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestIntParse(t *testing.T) {
+	k := NewInt(10, 1, 100)
+	for _, bad := range []string{"0", "101", "ten", ""} {
+		if _, err := k.Parse(bad); err == nil {
+			t.Errorf("Parse(%q) accepted", bad)
+		}
+	}
+	apply, err := k.Parse("42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Load() != 10 {
+		t.Fatal("Parse applied the value")
+	}
+	apply()
+	if k.Load() != 42 || k.String() != "42" {
+		t.Errorf("after apply: %d %q", k.Load(), k.String())
+	}
+}
+
+func TestDurationParse(t *testing.T) {
+	k := NewDuration(0, 0, time.Second)
+	if _, err := k.Parse("2s"); err == nil {
+		t.Error("2s accepted above a 1s maximum")
+	}
+	apply, err := k.Parse("250us")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply()
+	if k.Load() != 250*time.Microsecond {
+		t.Errorf("value %v", k.Load())
+	}
+}
+
+func TestSampler(t *testing.T) {
+	s := NewSampler(3)
+	var got []bool
+	for range 7 {
+		got = append(got, s.Sample())
+	}
+	want := []bool{true, false, false, true, false, false, true}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("samples %v, want %v", got, want)
+		}
+	}
+	apply, _ := s.Parse("0")
+	apply()
+	for range 5 {
+		if s.Sample() {
+			t.Fatal("sampled with every=0")
+		}
+	}
+}
+
+func TestGCPercent(t *testing.T) {
+	orig := debug.SetGCPercent(100)
+	debug.SetGCPercent(orig)
+	defer debug.SetGCPercent(orig)
+
+	k := GCPercent()
+	if k.String() != strconv.Itoa(orig) {
+		t.Errorf("initial value %s, want %d", k.String(), orig)
+	}
+	apply, err := k.Parse("250")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply()
+	if got := debug.SetGCPercent(250); got != 250 || k.String() != "250" {
+		t.Errorf("runtime has %d, knob %s; want 250", got, k.String())
+	}
+	if _, err := k.Parse("-2"); err == nil {
+		t.Error("-2 accepted")
+	}
+}
+
+func TestKnobs(t *testing.T) {
+	s := New()
+	every := NewInt(10, 1, 1000)
+	deadline := NewDuration(0, 0, time.Second)
+	s.Knob("flush_every", "messages per flush", every)
+	s.Knob("flush_deadline", "max time a reply waits", deadline)
+	s.Handle("/other", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) }))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	post := func(query string) (int, map[string]knobView) {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/knobs"+query, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var view map[string]knobView
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&view); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, view
+	}
+
+	code, view := post("?flush_every=1&flush_deadline=2ms")
+	if code != http.StatusOK || every.Load() != 1 || deadline.Load() != 2*time.Millisecond {
+		t.Fatalf("POST: %d, every %d, deadline %v", code, every.Load(), deadline.Load())
+	}
+	if v := view["flush_deadline"]; v.Value != "2ms" || v.Help != "max time a reply waits" {
+		t.Errorf("view %+v", view)
+	}
+	// One bad parameter rejects the whole request.
+	for _, q := range []string{"?flush_every=20&flush_deadline=5s", "?flush_every=20&nope=1"} {
+		if code, _ := post(q); code != http.StatusBadRequest {
+			t.Errorf("POST %s: %d, want 400", q, code)
+		}
+		if every.Load() != 1 {
+			t.Fatalf("POST %s applied flush_every=%d", q, every.Load())
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("mounted handler: %d", resp.StatusCode)
+	}
+}
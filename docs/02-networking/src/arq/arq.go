@@ -0,0 +1,441 @@
+// Package arq is a small reliable transport over UDP, written to show
+// what QUIC and TCP do for a stream of bytes and why it is hard to do
+// well.
+//
+// A Conn numbers each datagram it sends and keeps it until the peer
+// acknowledges it. The peer acknowledges every datagram with the next
+// sequence number it expects plus a bitmap of the 64 after that it holds
+// out of order (selective acknowledgement), and puts the bytes back in
+// order before Read returns them. Datagrams are sent again in two cases:
+// when their retransmission timer fires, after an RTO estimated from
+// measured round trips the way RFC 6298 does it for TCP, or as soon as
+// three later datagrams are acknowledged without them (fast retransmit).
+// The timers are kept on a timingwheel.Wheel, one per datagram in
+// flight. How much may be in flight is a congestion window in datagrams,
+// which grows by one per acknowledgement until the first loss (slow
+// start), then by one per round trip, and halves once per round trip
+// with losses.
+//
+// It leaves out what a real transport cannot: connection setup and
+// teardown, flow control (a Conn buffers whatever the peer sends until
+// Read takes it), path MTU discovery, pacing, encryption, and streams.
+// The last is the one QUIC exists for: in one ordered stream, a lost
+// datagram holds up everything behind it.
+package arq
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/timingwheel"
+)
+
+// Config tunes a Conn. Zero values select the defaults.
+type Config struct {
+	MaxPayload int           // bytes per datagram; defaults to 1200, QUIC's minimum
+	Window     int           // most datagrams in flight or held out of order; defaults to 256
+	MinRTO     time.Duration // floor for the retransmission timeout; defaults to 20ms
+	MaxRTO     time.Duration // ceiling, after backoff; defaults to 1s
+	// Wheel drives the retransmission timers. It defaults to a wheel with
+	// a 1ms tick that the Conn runs itself; Conns sharing one save a
+	// goroutine each. Its tick bounds how precisely timers fire.
+	Wheel *timingwheel.Wheel
+}
+
+func (c *Config) setDefaults() {
+	if c.MaxPayload <= 0 {
+		c.MaxPayload = 1200
+	}
+	if c.Window <= 0 {
+		c.Window = 256
+	}
+	if c.MinRTO <= 0 {
+		c.MinRTO = 20 * time.Millisecond
+	}
+	if c.MaxRTO <= 0 {
+		c.MaxRTO = time.Second
+	}
+}
+
+// Stats count a Conn's datagrams and describe its congestion state.
+type Stats struct {
+	Sent            uint64 // DATA datagrams, retransmissions included
+	Retransmits     uint64 // of those, sent again
+	Timeouts        uint64 // retransmissions after the timer fired
+	FastRetransmits uint64 // retransmissions after three later acks
+	Received        uint64 // DATA datagrams received
+	Duplicates      uint64 // of those, already received
+	SRTT            time.Duration
+	RTO             time.Duration
+	Cwnd            int // congestion window, in datagrams
+}
+
+// Conn is one end of a reliable byte stream over a net.PacketConn. Its
+// methods are safe for concurrent use.
+type Conn struct {
+	pc   net.PacketConn
+	cfg  Config
+	stop context.CancelFunc // the Conn's own wheel, if it runs one
+
+	mu     sync.Mutex
+	peer   net.Addr
+	closed bool
+	// canSend wakes Write when the window opens; canRead wakes Read when
+	// bytes arrive in order.
+	canSend, canRead sync.Cond
+
+	// Sender: out[seq%Window] holds the datagram seq until it is acked.
+	out            []*outPacket
+	una, next      uint32 // oldest unacknowledged, next to send
+	cwnd, ssthresh float64
+	recover        uint32 // losses before it belong to the last window cut
+	srtt, rttvar   time.Duration
+	rto            time.Duration
+	free           [][]byte
+
+	// Receiver: in[seq%Window] holds datagrams that arrived ahead of
+	// expected, and ready the bytes Read has not taken yet.
+	in       [][]byte
+	expected uint32
+	ready    []byte
+	ackBuf   []byte
+
+	stats Stats
+}
+
+type outPacket struct {
+	seq    uint32
+	buf    []byte // header and payload, as sent
+	sentAt time.Time
+	retx   bool // sent more than once; its ack says nothing about RTT
+	fast   bool // fast-retransmitted already
+	timer  *timingwheel.Timer
+	acked  bool
+}
+
+// New returns a Conn that sends to peer over pc and owns pc from then on.
+// A nil peer makes it wait for the first datagram and answer whoever
+// sent it, which is how a server side starts.
+func New(pc net.PacketConn, peer net.Addr, cfg Config) *Conn {
+	cfg.setDefaults()
+	c := &Conn{
+		pc:       pc,
+		cfg:      cfg,
+		peer:     peer,
+		out:      make([]*outPacket, cfg.Window),
+		in:       make([][]byte, cfg.Window),
+		cwnd:     2,
+		ssthresh: float64(cfg.Window),
+		rto:      200 * time.Millisecond, // until the first sample, as in RFC 6298 but shorter
+	}
+	c.rto = max(c.rto, cfg.MinRTO)
+	c.canSend.L = &c.mu
+	c.canRead.L = &c.mu
+	if c.cfg.Wheel == nil {
+		var ctx context.Context
+		ctx, c.stop = context.WithCancel(context.Background())
+		c.cfg.Wheel = timingwheel.New(time.Millisecond, 1024)
+		go c.cfg.Wheel.Run(ctx)
+	}
+	go c.receive()
+	return c
+}
+
+// Write sends p, blocking while the congestion window is full. It
+// returns once the last datagram is sent, not when it is acknowledged.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for len(p) > 0 {
+		for !c.closed && (c.peer == nil || int(c.next-c.una) >= c.window()) {
+			c.canSend.Wait()
+		}
+		if c.closed {
+			return n, net.ErrClosed
+		}
+		chunk := p[:min(len(p), c.cfg.MaxPayload)]
+		pkt := &outPacket{seq: c.next, buf: appendData(c.buffer(), c.next, chunk)}
+		c.out[c.next%uint32(len(c.out))] = pkt
+		c.next++
+		c.send(pkt)
+		p = p[len(chunk):]
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+// window is how many datagrams may be in flight.
+func (c *Conn) window() int { return min(int(c.cwnd), len(c.out)) }
+
+func (c *Conn) buffer() []byte {
+	if n := len(c.free); n > 0 {
+		b := c.free[n-1]
+		c.free = c.free[:n-1]
+		return b[:0]
+	}
+	return make([]byte, 0, dataHeader+c.cfg.MaxPayload)
+}
+
+// send transmits pkt and arms its timer. c.mu is held.
+func (c *Conn) send(pkt *outPacket) {
+	pkt.sentAt = time.Now()
+	c.stats.Sent++
+	c.pc.WriteTo(pkt.buf, c.peer) // a lost write is a lost datagram
+	if pkt.timer == nil {
+		pkt.timer = c.cfg.Wheel.AfterFunc(c.rto, func() { c.timeout(pkt) })
+	} else {
+		pkt.timer.Reset(c.rto)
+	}
+}
+
+// timeout retransmits pkt, backs the RTO off and cuts the window, once
+// per round trip however many timers fire in it.
+func (c *Conn) timeout(pkt *outPacket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || pkt.acked {
+		return
+	}
+	c.stats.Timeouts++
+	c.stats.Retransmits++
+	if !before(pkt.seq, c.recover) {
+		c.ssthresh = max(c.cwnd/2, 2)
+		c.cwnd = c.ssthresh
+		c.recover = c.next
+		c.rto = min(2*c.rto, c.cfg.MaxRTO)
+		// TCP restarts its one timer here. Restarting everyone else's
+		// does the same: when a stall delays every ack, only this
+		// datagram goes again, not the whole window. Those really lost
+		// are left to SACK or to the backed-off timer.
+		for s := c.una; before(s, c.next); s++ {
+			if p := c.out[s%uint32(len(c.out))]; p != nil && p != pkt {
+				p.timer.Reset(c.rto)
+			}
+		}
+	}
+	pkt.retx = true
+	c.send(pkt)
+}
+
+// before reports whether a comes before b, allowing for wraparound.
+func before(a, b uint32) bool { return int32(a-b) < 0 }
+
+func (c *Conn) receive() {
+	buf := make([]byte, 64<<10)
+	for {
+		n, from, err := c.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		typ, seq, rest, err := parse(buf[:n])
+		if err != nil {
+			continue
+		}
+		c.mu.Lock()
+		if c.peer == nil {
+			c.peer = from
+			c.canSend.Broadcast()
+		}
+		switch typ {
+		case typeData:
+			c.onData(seq, rest)
+		case typeAck:
+			c.onAck(seq, rest)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// onData files a datagram, delivers what is now in order, and acks.
+func (c *Conn) onData(seq uint32, payload []byte) {
+	c.stats.Received++
+	switch off := seq - c.expected; {
+	case before(seq, c.expected):
+		c.stats.Duplicates++
+	case off >= uint32(len(c.in)):
+		// Beyond the window; the sender will try again.
+	case c.in[seq%uint32(len(c.in))] != nil:
+		c.stats.Duplicates++
+	default:
+		c.in[seq%uint32(len(c.in))] = append([]byte(nil), payload...)
+		delivered := false
+		for {
+			i := c.expected % uint32(len(c.in))
+			if c.in[i] == nil {
+				break
+			}
+			c.ready = append(c.ready, c.in[i]...)
+			c.in[i] = nil
+			c.expected++
+			delivered = true
+		}
+		if delivered {
+			c.canRead.Broadcast()
+		}
+	}
+	var sack uint64
+	for i := range min(64, len(c.in)-1) {
+		if c.in[(c.expected+1+uint32(i))%uint32(len(c.in))] != nil {
+			sack |= 1 << i
+		}
+	}
+	c.ackBuf = appendAck(c.ackBuf[:0], c.expected, sack)
+	c.pc.WriteTo(c.ackBuf, c.peer)
+}
+
+// onAck releases what the peer holds, updates the RTT estimate and the
+// window, and fast-retransmits holes with three acked datagrams above.
+func (c *Conn) onAck(next uint32, rest []byte) {
+	if before(c.next, next) {
+		return // acks something never sent
+	}
+	sack := uint64(0)
+	for _, b := range rest[:8] {
+		sack = sack<<8 | uint64(b)
+	}
+	newly := 0
+	for s := c.una; before(s, next); s++ {
+		newly += c.ack(s)
+	}
+	for i := range 64 {
+		if sack&(1<<i) != 0 {
+			if s := next + 1 + uint32(i); before(s, c.next) {
+				newly += c.ack(s)
+			}
+		}
+	}
+	for c.una != c.next && c.out[c.una%uint32(len(c.out))] == nil {
+		c.una++
+	}
+	if newly == 0 {
+		return
+	}
+	for range newly {
+		if c.cwnd < c.ssthresh {
+			c.cwnd++
+		} else {
+			c.cwnd += 1 / c.cwnd
+		}
+	}
+	c.cwnd = min(c.cwnd, float64(len(c.out)))
+
+	// A hole with three acked datagrams above it is taken as lost rather
+	// than waiting out its timer.
+	above := 0
+	for s := c.next - 1; !before(s, c.una); s-- {
+		pkt := c.out[s%uint32(len(c.out))]
+		if pkt == nil {
+			above++
+			continue
+		}
+		if above >= 3 && !pkt.fast {
+			pkt.fast, pkt.retx = true, true
+			c.stats.FastRetransmits++
+			c.stats.Retransmits++
+			if !before(s, c.recover) {
+				c.ssthresh = max(c.cwnd/2, 2)
+				c.cwnd = c.ssthresh
+				c.recover = c.next
+			}
+			c.send(pkt)
+		}
+		if s == c.una {
+			break
+		}
+	}
+	c.canSend.Broadcast()
+}
+
+// ack releases datagram s and returns 1 if it was still held.
+func (c *Conn) ack(s uint32) int {
+	i := s % uint32(len(c.out))
+	pkt := c.out[i]
+	if pkt == nil || pkt.seq != s {
+		return 0
+	}
+	pkt.acked = true
+	pkt.timer.Stop()
+	if !pkt.retx {
+		c.sample(time.Since(pkt.sentAt))
+	}
+	c.out[i] = nil
+	c.free = append(c.free, pkt.buf)
+	return 1
+}
+
+// sample updates SRTT, RTTVAR and RTO as RFC 6298 does.
+func (c *Conn) sample(r time.Duration) {
+	if c.srtt == 0 {
+		c.srtt, c.rttvar = r, r/2
+	} else {
+		c.rttvar = (3*c.rttvar + (c.srtt - r).Abs()) / 4
+		c.srtt = (7*c.srtt + r) / 8
+	}
+	c.rto = min(max(c.srtt+4*c.rttvar, c.cfg.MinRTO), c.cfg.MaxRTO)
+}
+
+// Read returns bytes received in order, blocking until there are some.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.ready) == 0 && !c.closed {
+		c.canRead.Wait()
+	}
+	if len(c.ready) == 0 {
+		return 0, net.ErrClosed
+	}
+	n := copy(p, c.ready)
+	c.ready = c.ready[:copy(c.ready, c.ready[n:])]
+	return n, nil
+}
+
+// Flush blocks until everything written has been acknowledged.
+func (c *Conn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for !c.closed && c.una != c.next {
+		c.canSend.Wait()
+	}
+	if c.closed {
+		return net.ErrClosed
+	}
+	return nil
+}
+
+// Close stops the Conn and closes its PacketConn. Nothing tells the peer.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
+	for _, pkt := range c.out {
+		if pkt != nil && pkt.timer != nil {
+			pkt.timer.Stop()
+		}
+	}
+	c.canSend.Broadcast()
+	c.canRead.Broadcast()
+	c.mu.Unlock()
+	if c.stop != nil {
+		c.stop()
+	}
+	return c.pc.Close()
+}
+
+// Stats returns c's counters.
+func (c *Conn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.stats
+	st.SRTT, st.RTO, st.Cwnd = c.srtt, c.rto, int(c.cwnd)
+	return st
+}
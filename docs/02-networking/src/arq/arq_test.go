@@ -0,0 +1,242 @@
+package arq
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	p := appendData(nil, 0xfffffffe, []byte("hello"))
+	typ, seq, rest, err := parse(p)
+	if err != nil || typ != typeData || seq != 0xfffffffe || string(rest) != "hello" {
+		t.Errorf("data: %d %x %q %v", typ, seq, rest, err)
+	}
+	p = appendAck(nil, 7, 1<<63|5)
+	if len(p) != ackSize {
+		t.Errorf("ack is %d bytes", len(p))
+	}
+	typ, seq, rest, err = parse(p)
+	if err != nil || typ != typeAck || seq != 7 || !bytes.Equal(rest, []byte{0x80, 0, 0, 0, 0, 0, 0, 5}) {
+		t.Errorf("ack: %d %d %x %v", typ, seq, rest, err)
+	}
+	for _, short := range [][]byte{nil, {typeData, 0, 0, 0}, p[:ackSize-1]} {
+		if _, _, _, err := parse(short); err != errShort {
+			t.Errorf("parse(%x) = %v", short, err)
+		}
+	}
+}
+
+// relay forwards datagrams between one client and a server, dropping
+// each with probability loss and holding the rest for delay, in each
+// direction. It stands in for netem, which not every kernel has.
+type relay struct {
+	loss   float64
+	delay  time.Duration
+	client atomic.Pointer[net.Addr] // where replies go: the last sender
+}
+
+// newRelay starts a relay to server for the duration of the test and
+// returns the address the client should send to.
+func newRelay(tb testing.TB, server net.Addr, loss float64, delay time.Duration, seed uint64) net.Addr {
+	tb.Helper()
+	front, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	back, err := net.DialUDP("udp", nil, server.(*net.UDPAddr))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	// A window of datagrams arrives at once; drops should be the relay's.
+	front.(*net.UDPConn).SetReadBuffer(4 << 20)
+	back.SetReadBuffer(4 << 20)
+	r := &relay{loss: loss, delay: delay}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.forward(rand.New(rand.NewPCG(seed, 1)), func(b []byte) (int, error) {
+			n, from, err := front.ReadFrom(b)
+			if err == nil {
+				r.client.Store(&from)
+			}
+			return n, err
+		}, func(b []byte) { back.Write(b) })
+	}()
+	go func() {
+		defer wg.Done()
+		r.forward(rand.New(rand.NewPCG(seed, 2)), back.Read, func(b []byte) {
+			if to := r.client.Load(); to != nil {
+				front.WriteTo(b, *to)
+			}
+		})
+	}()
+	tb.Cleanup(func() {
+		front.Close()
+		back.Close()
+		wg.Wait()
+	})
+	return front.LocalAddr()
+}
+
+func (r *relay) forward(rng *rand.Rand, read func([]byte) (int, error), write func([]byte)) {
+	// One goroutine releases delayed datagrams in the order they came;
+	// a timer each would reorder them.
+	type held struct {
+		p   []byte
+		due time.Time
+	}
+	q := make(chan held, 1<<14)
+	defer close(q)
+	go func() {
+		for h := range q {
+			time.Sleep(time.Until(h.due))
+			write(h.p)
+		}
+	}()
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := read(buf)
+		if err != nil {
+			return
+		}
+		if rng.Float64() < r.loss {
+			continue
+		}
+		if r.delay == 0 {
+			write(buf[:n])
+			continue
+		}
+		q <- held{append([]byte(nil), buf[:n]...), time.Now().Add(r.delay)}
+	}
+}
+
+// pair returns two Conns that talk through a relay.
+func pair(tb testing.TB, cfg Config, loss float64, delay time.Duration) (client, server *Conn) {
+	tb.Helper()
+	listen := func() net.PacketConn {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			tb.Fatal(err)
+		}
+		pc.(*net.UDPConn).SetReadBuffer(4 << 20)
+		return pc
+	}
+	spc, cpc := listen(), listen()
+	server = New(spc, nil, cfg)
+	client = New(cpc, newRelay(tb, spc.LocalAddr(), loss, delay, 1), cfg)
+	tb.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestTransfer(t *testing.T) {
+	for _, loss := range []float64{0, 0.01, 0.1} {
+		t.Run(fmt.Sprintf("loss=%g", loss), func(t *testing.T) {
+			client, server := pair(t, Config{}, loss, time.Millisecond)
+			msg := make([]byte, 2<<20)
+			rand.NewChaCha8([32]byte{1}).Read(msg)
+
+			errc := make(chan error, 1)
+			go func() {
+				// Uneven writes, so datagram and write boundaries differ.
+				rest := msg
+				for len(rest) > 0 {
+					n := min(len(rest), 7000)
+					if _, err := client.Write(rest[:n]); err != nil {
+						errc <- err
+						return
+					}
+					rest = rest[n:]
+				}
+				errc <- client.Flush()
+			}()
+			got := make([]byte, len(msg))
+			if _, err := io.ReadFull(server, got); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, msg) {
+				t.Fatal("received bytes differ")
+			}
+
+			st := client.Stats()
+			t.Logf("client %+v", st)
+			t.Logf("server %+v", server.Stats())
+			if loss > 0 && st.Retransmits == 0 {
+				t.Error("no retransmissions under loss")
+			}
+			if loss == 0 && st.Retransmits != 0 {
+				t.Errorf("%d retransmissions without loss", st.Retransmits)
+			}
+		})
+	}
+}
+
+// TestBothWays sends in both directions at once over one pair, so DATA and
+// ACKs share each socket.
+func TestBothWays(t *testing.T) {
+	a, b := pair(t, Config{MaxPayload: 500, Window: 32}, 0.05, 0)
+	// The server learns its peer from the first datagram.
+	if _, err := a.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	one := make([]byte, 1)
+	if _, err := io.ReadFull(b, one); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := bytes.Repeat([]byte("0123456789"), 50_000)
+	var wg sync.WaitGroup
+	for _, c := range []*Conn{a, b} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Write(msg)
+		}()
+	}
+	for _, c := range []*Conn{b, a} {
+		got := make([]byte, len(msg))
+		if _, err := io.ReadFull(c, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatal("received bytes differ")
+		}
+	}
+	wg.Wait()
+}
+
+func TestClose(t *testing.T) {
+	client, server := pair(t, Config{}, 0, 0)
+	done := make(chan error, 1)
+	go func() {
+		_, err := server.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	server.Close()
+	if err := <-done; err != net.ErrClosed {
+		t.Errorf("Read after Close: %v", err)
+	}
+	// Nothing acknowledges now, so the client's window fills and Write
+	// blocks until it is closed too.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		client.Close()
+	}()
+	if _, err := client.Write(make([]byte, 1<<20)); err != net.ErrClosed {
+		t.Errorf("Write after Close: %v", err)
+	}
+}
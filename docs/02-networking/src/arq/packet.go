@@ -0,0 +1,46 @@
+package arq
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Packet types. A DATA packet is the type, a sequence number and the
+// payload. An ACK is the type, the next sequence number the receiver
+// expects, and a bitmap of the 64 after it that have arrived out of order.
+const (
+	typeData byte = iota
+	typeAck
+)
+
+const (
+	dataHeader = 1 + 4
+	ackSize    = 1 + 4 + 8
+)
+
+var errShort = errors.New("arq: short packet")
+
+func appendData(b []byte, seq uint32, payload []byte) []byte {
+	b = append(b, typeData)
+	b = binary.BigEndian.AppendUint32(b, seq)
+	return append(b, payload...)
+}
+
+func appendAck(b []byte, next uint32, sack uint64) []byte {
+	b = append(b, typeAck)
+	b = binary.BigEndian.AppendUint32(b, next)
+	return binary.BigEndian.AppendUint64(b, sack)
+}
+
+// parse splits a packet into its type, sequence number and the rest: the
+// payload of a DATA packet or the SACK bitmap of an ACK.
+func parse(p []byte) (typ byte, seq uint32, rest []byte, err error) {
+	if len(p) < dataHeader {
+		return 0, 0, nil, errShort
+	}
+	typ, seq, rest = p[0], binary.BigEndian.Uint32(p[1:5]), p[5:]
+	if typ == typeAck && len(rest) < 8 {
+		return 0, 0, nil, errShort
+	}
+	return typ, seq, rest, nil
+}
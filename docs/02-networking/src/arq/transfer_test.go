@@ -0,0 +1,208 @@
+//go:build linux
+
+package arq
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/internal/testcert"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/vnet"
+)
+
+// host is where sockets are opened: the test's own namespace or a vnet
+// namespace.
+type host interface {
+	Listen(network, address string) (net.Listener, error)
+	ListenPacket(network, address string) (net.PacketConn, error)
+	Dial(network, address string) (net.Conn, error)
+}
+
+type local struct{}
+
+func (local) Listen(network, address string) (net.Listener, error) {
+	return net.Listen(network, address)
+}
+
+func (local) ListenPacket(network, address string) (net.PacketConn, error) {
+	return net.ListenPacket(network, address)
+}
+
+func (local) Dial(network, address string) (net.Conn, error) { return net.Dial(network, address) }
+
+// path connects a client to a server across a lossy link, either netem
+// between two namespaces or the relay on loopback, which carries UDP
+// only.
+type path struct {
+	client, server host
+	serverIP       netip.Addr
+	// via returns where the client should send datagrams meant for
+	// server; nil when the link itself is lossy.
+	via func(server net.Addr) net.Addr
+}
+
+func relayPath(tb testing.TB, rtt time.Duration, loss float64) path {
+	return path{
+		client: local{}, server: local{}, serverIP: netip.MustParseAddr("127.0.0.1"),
+		via: func(server net.Addr) net.Addr { return newRelay(tb, server, loss, rtt/2, 1) },
+	}
+}
+
+func netemPath(tb testing.TB, rtt time.Duration, loss float64) path {
+	n := vnet.New(tb, vnet.Link{RTT: rtt, Loss: loss * 100})
+	return path{client: n.Client, server: n.Hosts[0], serverIP: n.Hosts[0].Addr}
+}
+
+func (p path) listenPacket(tb testing.TB, h host) net.PacketConn {
+	tb.Helper()
+	pc, err := h.ListenPacket("udp", ":0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	pc.(*net.UDPConn).SetReadBuffer(4 << 20)
+	tb.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+// serverAddr is the address the client sends to, for a server listening
+// on pc.
+func (p path) serverAddr(pc net.PacketConn) net.Addr {
+	a := &net.UDPAddr{IP: p.serverIP.AsSlice(), Port: pc.LocalAddr().(*net.UDPAddr).Port}
+	if p.via != nil {
+		return p.via(a)
+	}
+	return a
+}
+
+// A transport opens a byte stream across a path and returns its two
+// ends, and a function reporting the sender's retransmissions if the
+// transport counts them.
+type transport func(tb testing.TB, p path) (w io.Writer, r io.Reader, retx func() uint64)
+
+var transports = map[string]transport{
+	"arq": func(tb testing.TB, p path) (io.Writer, io.Reader, func() uint64) {
+		spc := p.listenPacket(tb, p.server)
+		server := New(spc, nil, Config{})
+		client := New(p.listenPacket(tb, p.client), p.serverAddr(spc), Config{})
+		tb.Cleanup(func() {
+			client.Close()
+			server.Close()
+		})
+		return client, server, func() uint64 { return client.Stats().Retransmits }
+	},
+	"quic": func(tb testing.TB, p path) (io.Writer, io.Reader, func() uint64) {
+		spc := p.listenPacket(tb, p.server)
+		cert, _ := testcert.Must(tb)
+		ln, err := quic.Listen(spc, &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"arq-bench"}}, nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { ln.Close() })
+		roots := x509.NewCertPool()
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		roots.AddCert(leaf)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		conn, err := quic.Dial(ctx, p.listenPacket(tb, p.client), p.serverAddr(spc),
+			&tls.Config{RootCAs: roots, ServerName: "localhost", NextProtos: []string{"arq-bench"}}, nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { conn.CloseWithError(0, "") })
+		w, err := conn.OpenStreamSync(ctx)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		// A stream is announced by its first frame.
+		if _, err := w.Write([]byte{0}); err != nil {
+			tb.Fatal(err)
+		}
+		sconn, err := ln.Accept(ctx)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		r, err := sconn.AcceptStream(ctx)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := io.ReadFull(r, make([]byte, 1)); err != nil {
+			tb.Fatal(err)
+		}
+		return w, r, nil
+	},
+	"tcp": func(tb testing.TB, p path) (io.Writer, io.Reader, func() uint64) {
+		if p.via != nil {
+			tb.Skip("tcp: the relay carries UDP only; loss needs netem")
+		}
+		ln, err := p.server.Listen("tcp", ":0")
+		if err != nil {
+			tb.Fatal(err)
+		}
+		defer ln.Close()
+		addr := netip.AddrPortFrom(p.serverIP, uint16(ln.Addr().(*net.TCPAddr).Port))
+		client, err := p.client.Dial("tcp", addr.String())
+		if err != nil {
+			tb.Fatal(err)
+		}
+		server, err := ln.Accept()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() {
+			client.Close()
+			server.Close()
+		})
+		return client, server, nil
+	},
+}
+
+// benchTransfer times sending size bytes one way over each transport.
+func benchTransfer(b *testing.B, newPath func(testing.TB, time.Duration, float64) path) {
+	const (
+		size = 1 << 20
+		rtt  = 10 * time.Millisecond
+	)
+	for _, loss := range []float64{0, 0.01, 0.05} {
+		for _, name := range []string{"tcp", "arq", "quic"} {
+			b.Run(fmt.Sprintf("%s/loss=%g", name, loss), func(b *testing.B) {
+				w, r, retx := transports[name](b, newPath(b, rtt, loss))
+				msg := make([]byte, size)
+				buf := make([]byte, size)
+				errc := make(chan error, 1)
+				b.SetBytes(size)
+				for b.Loop() {
+					go func() {
+						_, err := w.Write(msg)
+						errc <- err
+					}()
+					if _, err := io.ReadFull(r, buf); err != nil {
+						b.Fatal(err)
+					}
+					if err := <-errc; err != nil {
+						b.Fatal(err)
+					}
+				}
+				if retx != nil {
+					b.ReportMetric(float64(retx())/float64(b.N), "retx/op")
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkTransfer compares arq and QUIC across the relay, which drops
+// and delays datagrams on loopback.
+func BenchmarkTransfer(b *testing.B) { benchTransfer(b, relayPath) }
+
+// BenchmarkTransferNetem compares TCP, arq and QUIC across a netem link.
+// It skips on kernels without sch_netem.
+func BenchmarkTransferNetem(b *testing.B) { benchTransfer(b, netemPath) }
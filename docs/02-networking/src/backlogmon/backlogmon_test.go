@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleNetstat = `TcpExt: SyncookiesSent SyncookiesRecv ListenOverflows ListenDrops
+TcpExt: 3 0 120 125
+IpExt: InNoRoutes InOctets
+IpExt: 0 123456
+`
+
+func TestParseNetstat(t *testing.T) {
+	got, err := parseNetstat(strings.NewReader(sampleNetstat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]uint64{
+		"TcpExt.SyncookiesSent":  3,
+		"TcpExt.ListenOverflows": 120,
+		"TcpExt.ListenDrops":     125,
+		"IpExt.InOctets":         123456,
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %d, want %d", k, got[k], v)
+		}
+	}
+}
+
+func TestParseNetstatMismatch(t *testing.T) {
+	if _, err := parseNetstat(strings.NewReader("TcpExt: A B\nTcpExt: 1\n")); err == nil {
+		t.Fatal("expected error for mismatched lines")
+	}
+}
+
+func TestParseNetstatBlank(t *testing.T) {
+	got, err := parseNetstat(strings.NewReader("\nTcpExt: A B\nTcpExt: 1 2\n\n  \nIpExt: C\nIpExt: 3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["TcpExt.B"] != 2 || got["IpExt.C"] != 3 {
+		t.Errorf("got %v", got)
+	}
+	if _, err := parseNetstat(strings.NewReader("TcpExt: A B\n\nTcpExt: 1 2\n")); err == nil {
+		t.Error("expected error for a blank line in place of the values")
+	}
+}
+
+func TestCorrelate(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	mon := []monitorSample{
+		{At: t0, QueueLen: 10, Backlog: 128},
+		{At: t0.Add(500 * time.Millisecond), QueueLen: 128, Backlog: 128, Overflows: 40},
+		{At: t0.Add(time.Second), QueueLen: 0, Backlog: 128},
+	}
+	conns := []connectSample{
+		{At: t0, Latency: time.Millisecond},
+		{At: t0.Add(100 * time.Millisecond), Latency: 1030 * time.Millisecond},
+		{At: t0.Add(200 * time.Millisecond), Failed: true},
+		{At: t0.Add(time.Second), Latency: 2 * time.Millisecond},
+	}
+	got := correlate(mon, conns)
+	if len(got) != 2 {
+		t.Fatalf("got %d buckets, want 2", len(got))
+	}
+	b := got[0]
+	if b.MaxQueue != 128 || b.Overflows != 40 || b.Connects != 3 || b.Failed != 1 || b.Retransmits != 1 {
+		t.Errorf("unexpected first bucket %+v", b)
+	}
+	if got[1].P99 != 2*time.Millisecond {
+		t.Errorf("second bucket p99 = %v, want 2ms", got[1].P99)
+	}
+}
+
+func TestPearson(t *testing.T) {
+	if r := pearson([]float64{1, 2, 3}, []float64{2, 4, 6}); math.Abs(r-1) > 1e-9 {
+		t.Errorf("pearson = %v, want 1", r)
+	}
+	if r := pearson([]float64{1, 1}, []float64{2, 3}); !math.IsNaN(r) {
+		t.Errorf("pearson of constant series = %v, want NaN", r)
+	}
+}
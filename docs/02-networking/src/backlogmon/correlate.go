@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// monitorSample is one row of backlogmon output.
+type monitorSample struct {
+	At        time.Time
+	QueueLen  uint64
+	Backlog   uint64
+	Overflows uint64 // ListenOverflows since the previous sample
+	Drops     uint64 // ListenDrops since the previous sample
+}
+
+// connectSample is one row of loadgen -connect-log output.
+type connectSample struct {
+	At      time.Time
+	Latency time.Duration
+	Failed  bool
+}
+
+// bucket aggregates both sides over one second.
+type bucket struct {
+	Second      time.Time
+	MaxQueue    uint64
+	Backlog     uint64
+	Overflows   uint64
+	Drops       uint64
+	Connects    int
+	Failed      int
+	Retransmits int // connects slower than the 1s initial SYN/ACK RTO
+	P50, P99    time.Duration
+}
+
+func readCSV(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > 0 {
+		rows = rows[1:] // header
+	}
+	return rows, nil
+}
+
+func parseMillis(s string) (time.Time, error) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	return time.UnixMilli(ms), err
+}
+
+func readMonitorCSV(path string) ([]monitorSample, error) {
+	rows, err := readCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]monitorSample, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 5 {
+			return nil, fmt.Errorf("%s: short row %v", path, row)
+		}
+		var s monitorSample
+		var vals [4]uint64
+		if s.At, err = parseMillis(row[0]); err != nil {
+			return nil, err
+		}
+		for i := range vals {
+			if vals[i], err = strconv.ParseUint(row[i+1], 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		s.QueueLen, s.Backlog, s.Overflows, s.Drops = vals[0], vals[1], vals[2], vals[3]
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func readConnectCSV(path string) ([]connectSample, error) {
+	rows, err := readCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]connectSample, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 3 {
+			return nil, fmt.Errorf("%s: short row %v", path, row)
+		}
+		var s connectSample
+		if s.At, err = parseMillis(row[0]); err != nil {
+			return nil, err
+		}
+		us, err := strconv.ParseInt(row[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		s.Latency = time.Duration(us) * time.Microsecond
+		s.Failed = row[2] != "ok"
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// correlate joins both series on wall-clock seconds. Connect samples are
+// bucketed by when the connect started.
+func correlate(mon []monitorSample, conns []connectSample) []bucket {
+	byKey := make(map[int64]*bucket)
+	get := func(t time.Time) *bucket {
+		sec := t.Unix()
+		b, ok := byKey[sec]
+		if !ok {
+			b = &bucket{Second: time.Unix(sec, 0)}
+			byKey[sec] = b
+		}
+		return b
+	}
+
+	for _, s := range mon {
+		b := get(s.At)
+		b.MaxQueue = max(b.MaxQueue, s.QueueLen)
+		b.Backlog = max(b.Backlog, s.Backlog)
+		b.Overflows += s.Overflows
+		b.Drops += s.Drops
+	}
+	lat := make(map[int64][]time.Duration)
+	for _, c := range conns {
+		b := get(c.At)
+		b.Connects++
+		if c.Failed {
+			b.Failed++
+			continue
+		}
+		if c.Latency >= time.Second {
+			b.Retransmits++
+		}
+		lat[c.At.Unix()] = append(lat[c.At.Unix()], c.Latency)
+	}
+
+	out := make([]bucket, 0, len(byKey))
+	for sec, b := range byKey {
+		if l := lat[sec]; len(l) > 0 {
+			sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+			b.P50 = l[(len(l)-1)/2]
+			b.P99 = l[(len(l)-1)*99/100]
+		}
+		out = append(out, *b)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Second.Before(out[j].Second) })
+	return out
+}
+
+// pearson returns the correlation coefficient of x and y, or NaN when either
+// series is constant.
+func pearson(x, y []float64) float64 {
+	n := float64(len(x))
+	var sx, sy, sxx, syy, sxy float64
+	for i := range x {
+		sx += x[i]
+		sy += y[i]
+		sxx += x[i] * x[i]
+		syy += y[i] * y[i]
+		sxy += x[i] * y[i]
+	}
+	den := math.Sqrt(n*sxx-sx*sx) * math.Sqrt(n*syy-sy*sy)
+	if den == 0 {
+		return math.NaN()
+	}
+	return (n*sxy - sx*sy) / den
+}
+
+func printCorrelation(w io.Writer, buckets []bucket) {
+	fmt.Fprintf(w, "%-8s %9s %8s %9s %7s %8s %6s %8s %12s %12s\n",
+		"second", "max_queue", "backlog", "overflows", "drops", "connects", "failed", ">=1s", "p50", "p99")
+
+	var overflows, p99s []float64
+	var t0 time.Time
+	for i, b := range buckets {
+		if i == 0 {
+			t0 = b.Second
+		}
+		fmt.Fprintf(w, "%-8d %9d %8d %9d %7d %8d %6d %8d %12v %12v\n",
+			int(b.Second.Sub(t0)/time.Second), b.MaxQueue, b.Backlog, b.Overflows, b.Drops,
+			b.Connects, b.Failed, b.Retransmits, b.P50, b.P99)
+		if b.Connects > 0 {
+			overflows = append(overflows, float64(b.Overflows))
+			p99s = append(p99s, float64(b.P99))
+		}
+	}
+	fmt.Fprintf(w, "\ncorrelation(overflows, connect p99) = %.2f\n", pearson(overflows, p99s))
+}
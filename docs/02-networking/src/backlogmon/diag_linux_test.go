@@ -0,0 +1,25 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestNetlinkError(t *testing.T) {
+	for _, errno := range []syscall.Errno{syscall.EPERM, syscall.ENOENT, syscall.EINVAL} {
+		// struct nlmsgerr: the negated errno, then the failed request's
+		// header, which netlinkError ignores.
+		data := binary.NativeEndian.AppendUint32(nil, uint32(-int32(errno)))
+		data = append(data, make([]byte, syscall.SizeofNlMsghdr)...)
+		if err := netlinkError(data); !errors.Is(err, errno) {
+			t.Errorf("errno %d: got %v", errno, err)
+		}
+	}
+	if err := netlinkError([]byte{1}); err == nil {
+		t.Error("a truncated message decoded")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+type listenQueue struct {
+	Port    int
+	Len     uint32
+	Backlog uint32
+}
+
+func listenQueues(port int) ([]listenQueue, error) {
+	return nil, errors.New("listen queue inspection needs NETLINK_SOCK_DIAG (Linux)")
+}
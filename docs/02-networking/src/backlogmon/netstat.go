@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Kernel counters that explain failed or slow connects on the server side.
+var netstatCounters = []string{
+	"TcpExt.ListenOverflows",      // accept queue full when the final ACK arrived
+	"TcpExt.ListenDrops",          // any drop of a connection attempt on a listener
+	"TcpExt.TCPReqQFullDrop",      // SYN dropped because the SYN queue was full
+	"TcpExt.TCPReqQFullDoCookies", // SYN queue full, answered with a syncookie
+	"TcpExt.SyncookiesSent",
+}
+
+// parseNetstat parses the "Prefix: names..." / "Prefix: values..." line pairs
+// used by /proc/net/netstat and /proc/net/snmp into "Prefix.Name" keys.
+func parseNetstat(r io.Reader) (map[string]uint64, error) {
+	out := make(map[string]uint64)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		names := strings.Fields(sc.Text())
+		if len(names) == 0 {
+			continue // a blank line between pairs
+		}
+		if !sc.Scan() {
+			return nil, fmt.Errorf("netstat: header %q without values", names[0])
+		}
+		values := strings.Fields(sc.Text())
+		if len(names) != len(values) || names[0] != values[0] {
+			return nil, fmt.Errorf("netstat: mismatched lines for %q", names[0])
+		}
+		prefix := strings.TrimSuffix(names[0], ":")
+		for i := 1; i < len(names); i++ {
+			v, err := strconv.ParseUint(values[i], 10, 64)
+			if err != nil {
+				// A few counters (e.g. Tcp.MaxConn) are signed; they
+				// are not used here.
+				continue
+			}
+			out[prefix+"."+names[i]] = v
+		}
+	}
+	return out, sc.Err()
+}
+
+func readNetstat() (map[string]uint64, error) {
+	f, err := os.Open("/proc/net/netstat")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseNetstat(f)
+}
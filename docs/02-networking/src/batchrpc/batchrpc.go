@@ -0,0 +1,188 @@
+// Package batchrpc packs many small requests into each frame of the codec
+// package's length-prefixed protocol, and matches the responses to their
+// requests by correlation ID.
+//
+// A frame's payload is a sequence of entries, each a 4-byte big-endian ID,
+// a 4-byte big-endian length and that many bytes:
+//
+//	frame: length(4) entry entry ...
+//	entry: id(4) length(4) body
+//
+// A Client's calls go through one writer goroutine. Calls made while it is
+// busy queue up, and it sends them as frames of up to Config.MaxBatch
+// entries, one write per frame. Batching therefore needs no timer and adds
+// no delay: a lone call goes out at once in a frame of its own, and under
+// load the frames fill up by themselves. MaxBatch 1 is the classic one
+// request per frame protocol, for comparison.
+//
+// A server answers either in order or out of order. Ordered, it runs the
+// handler on the connection's reading goroutine and answers each frame with
+// one frame of responses, in the same order. Nothing is copied or handed
+// off, but one slow request holds up every request behind it. Unordered, it
+// runs every request on its own goroutine and packs the responses into
+// frames as they finish, the same way the client packs requests. The
+// client does not care which: it matches every response by its ID.
+package batchrpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+)
+
+// ErrClosed is returned by calls on a closed Client, and to calls still
+// waiting when it is closed.
+var ErrClosed = errors.New("batchrpc: closed")
+
+// Config tunes a Client or a server. Zero values select the defaults.
+type Config struct {
+	// MaxBatch caps the entries packed into one frame; defaults to 64.
+	// 1 sends every request, or response, in a frame of its own.
+	MaxBatch int
+	// MaxFrame bounds a frame's payload; defaults to
+	// codec.DefaultMaxSize. A frame is closed early rather than grown
+	// past it, so a single entry must fit.
+	MaxFrame int
+	// Ordered makes a server answer each frame's requests in order, on
+	// the connection's reading goroutine. Clients ignore it.
+	Ordered bool
+}
+
+func (c *Config) setDefaults() {
+	if c.MaxBatch <= 0 {
+		c.MaxBatch = 64
+	}
+	if c.MaxFrame <= 0 {
+		c.MaxFrame = codec.DefaultMaxSize
+	}
+}
+
+// Stats counts what a Client sent. Requests/Frames is the batch size it
+// achieved; every frame is one write.
+type Stats struct {
+	Requests int64
+	Frames   int64
+}
+
+const (
+	frameHeader = 4
+	entryHeader = 8
+)
+
+func appendEntry(dst []byte, id uint32, body []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, id)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(body)))
+	return append(dst, body...)
+}
+
+// parseEntries calls fn for every entry in a frame's payload. body aliases
+// the frame.
+func parseEntries(frame []byte, fn func(id uint32, body []byte)) error {
+	for len(frame) > 0 {
+		if len(frame) < entryHeader {
+			return codec.ErrInvalid
+		}
+		id := binary.BigEndian.Uint32(frame)
+		n := binary.BigEndian.Uint32(frame[4:])
+		if n > uint32(len(frame)-entryHeader) {
+			return codec.ErrInvalid
+		}
+		end := entryHeader + int(n)
+		fn(id, frame[entryHeader:end:end])
+		frame = frame[end:]
+	}
+	return nil
+}
+
+// batcher queues entries from any goroutine and packs them into frames for
+// one writer goroutine. The queue holds finished frames, length prefix
+// included, followed by the open one, so the writer sends it as is.
+type batcher struct {
+	max, maxFrame int
+
+	mu    sync.Mutex
+	buf   []byte
+	open  int // offset of the open frame's prefix, or -1
+	n     int // entries in the open frame
+	ready chan struct{}
+
+	entries, frames atomic.Int64
+}
+
+func newBatcher(cfg Config) *batcher {
+	return &batcher{max: cfg.MaxBatch, maxFrame: cfg.MaxFrame, open: -1, ready: make(chan struct{}, 1)}
+}
+
+// add queues one entry and wakes the writer.
+func (b *batcher) add(id uint32, body []byte) error {
+	if entryHeader+len(body) > b.maxFrame {
+		return codec.ErrTooLarge
+	}
+	b.mu.Lock()
+	if b.open < 0 || b.n == b.max || len(b.buf)-b.open-frameHeader+entryHeader+len(body) > b.maxFrame {
+		b.open, b.n = len(b.buf), 0
+		b.buf = append(b.buf, 0, 0, 0, 0)
+	}
+	b.buf = appendEntry(b.buf, id, body)
+	b.n++
+	binary.BigEndian.PutUint32(b.buf[b.open:], uint32(len(b.buf)-b.open-frameHeader))
+	b.mu.Unlock()
+	b.entries.Add(1)
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// take returns the queued frames and closes the open one, leaving spare as
+// the new queue.
+func (b *batcher) take(spare []byte) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := b.buf
+	b.buf, b.open = spare[:0], -1
+	return out
+}
+
+// run writes the queued frames to w, one write per frame, until a write
+// fails or done is closed and the queue is empty.
+func (b *batcher) run(w io.Writer, done <-chan struct{}) error {
+	var spare []byte
+	for stop := false; ; {
+		select {
+		case <-b.ready:
+		case <-done:
+			stop = true
+		}
+		// Waking the writer puts it next in line on the caller's P, ahead
+		// of the callers that were about to add to the batch. Yielding
+		// once lets them.
+		runtime.Gosched()
+		buf := b.take(spare)
+		for p := buf; len(p) > 0; {
+			n := frameHeader + int(binary.BigEndian.Uint32(p))
+			// Counted before the write: once the frame is written, its replies
+			// can come back and their callers read Stats before this
+			// goroutine runs again.
+			b.frames.Add(1)
+			if _, err := w.Write(p[:n]); err != nil {
+				return err
+			}
+			p = p[n:]
+		}
+		if stop {
+			return nil
+		}
+		spare = buf
+	}
+}
+
+func (b *batcher) stats() Stats {
+	return Stats{Requests: b.entries.Load(), Frames: b.frames.Load()}
+}
@@ -0,0 +1,178 @@
+package batchrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/transport"
+)
+
+// start connects a Client to a server answering with h over loopback TCP.
+func start(tb testing.TB, h Handler, cfg Config) *Client {
+	tb.Helper()
+	client, server, err := transport.Pair("tcp")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ServeConn(server, h, cfg)
+	}()
+	c := NewClient(client, cfg)
+	tb.Cleanup(func() {
+		c.Close()
+		<-done
+	})
+	return c
+}
+
+func upper(req []byte) []byte { return bytes.ToUpper(req) }
+
+func TestCall(t *testing.T) {
+	const callers, calls = 32, 200
+	for _, cfg := range []Config{
+		{MaxBatch: 1, Ordered: true},
+		{MaxBatch: 64, Ordered: true},
+		{MaxBatch: 1},
+		{MaxBatch: 64},
+	} {
+		t.Run(fmt.Sprintf("batch=%d/ordered=%v", cfg.MaxBatch, cfg.Ordered), func(t *testing.T) {
+			c := start(t, upper, cfg)
+			var wg sync.WaitGroup
+			for g := range callers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := range calls {
+						req := fmt.Sprintf("caller %d call %d", g, i)
+						resp, err := c.Call(context.Background(), []byte(req))
+						if err != nil || string(resp) != string(upper([]byte(req))) {
+							t.Errorf("%s: got %q, %v", req, resp, err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			s := c.Stats()
+			t.Logf("%d requests in %d frames", s.Requests, s.Frames)
+			if s.Requests != callers*calls {
+				t.Errorf("sent %d requests, want %d", s.Requests, callers*calls)
+			}
+			if cfg.MaxBatch == 1 && s.Frames != s.Requests {
+				t.Errorf("%d frames for %d requests with MaxBatch 1", s.Frames, s.Requests)
+			}
+		})
+	}
+}
+
+// TestUnordered checks that a slow request holds up only itself when the
+// server answers out of order.
+func TestUnordered(t *testing.T) {
+	release := make(chan struct{})
+	c := start(t, func(req []byte) []byte {
+		if string(req) == "slow" {
+			<-release
+		}
+		return req
+	}, Config{})
+
+	slow := make(chan error, 1)
+	go func() {
+		_, err := c.Call(context.Background(), []byte("slow"))
+		slow <- err
+	}()
+	for range 10 {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := c.Call(ctx, []byte("fast"))
+		cancel()
+		if err != nil {
+			t.Fatalf("fast call behind a slow one: %v", err)
+		}
+	}
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	release := make(chan struct{})
+	c := start(t, func(req []byte) []byte {
+		if string(req) == "wait" {
+			<-release
+		}
+		return req
+	}, Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call(ctx, []byte("wait")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the deadline", err)
+	}
+	close(release) // its response arrives for no one
+	if resp, err := c.Call(context.Background(), []byte("next")); err != nil || string(resp) != "next" {
+		t.Fatalf("call after a canceled one: %q, %v", resp, err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	c := NewClient(client, Config{})
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Call(context.Background(), []byte("never answered"))
+		errc <- err
+	}()
+	// Read the request so the call is waiting for its response.
+	if _, err := server.Read(make([]byte, 64)); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if err := <-errc; err != ErrClosed {
+		t.Errorf("waiting call got %v, want ErrClosed", err)
+	}
+	if _, err := c.Call(context.Background(), nil); err != ErrClosed {
+		t.Errorf("call after Close got %v, want ErrClosed", err)
+	}
+	if err := c.Close(); err != ErrClosed {
+		t.Errorf("second Close: %v, want ErrClosed", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	c := start(t, upper, Config{MaxFrame: 64})
+	if _, err := c.Call(context.Background(), make([]byte, 64)); err != codec.ErrTooLarge {
+		t.Errorf("oversized request: %v, want ErrTooLarge", err)
+	}
+	if _, err := c.Call(context.Background(), make([]byte, 56)); err != nil {
+		t.Errorf("request filling a frame: %v", err)
+	}
+}
+
+func TestParseEntries(t *testing.T) {
+	var frame []byte
+	frame = appendEntry(frame, 7, []byte("seven"))
+	frame = appendEntry(frame, 8, nil)
+	var got []string
+	err := parseEntries(frame, func(id uint32, body []byte) {
+		got = append(got, fmt.Sprintf("%d:%s", id, body))
+	})
+	if err != nil || len(got) != 2 || got[0] != "7:seven" || got[1] != "8:" {
+		t.Fatalf("parsed %q, %v", got, err)
+	}
+	for _, bad := range [][]byte{frame[:3], frame[:12], append(frame[:len(frame):len(frame)], 0)} {
+		if err := parseEntries(bad, func(uint32, []byte) {}); err != codec.ErrInvalid {
+			t.Errorf("%x: %v, want ErrInvalid", bad, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package batchrpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func echo(req []byte) []byte { return req }
+
+// BenchmarkCall runs 64 concurrent callers per CPU against one connection
+// and reports the time per call, so the batched and the one request per
+// frame protocols compare at the same offered concurrency.
+func BenchmarkCall(b *testing.B) {
+	for _, size := range []int{16, 128, 1024} {
+		for _, ordered := range []bool{true, false} {
+			for _, batch := range []int{1, 64} {
+				mode := "unordered"
+				if ordered {
+					mode = "ordered"
+				}
+				b.Run(fmt.Sprintf("size=%d/%s/batch=%d", size, mode, batch), func(b *testing.B) {
+					c := start(b, echo, Config{MaxBatch: batch, Ordered: ordered})
+					req := make([]byte, size)
+					b.SetBytes(int64(size))
+					b.ReportAllocs()
+					b.SetParallelism(64)
+					b.RunParallel(func(pb *testing.PB) {
+						for pb.Next() {
+							if _, err := c.Call(context.Background(), req); err != nil {
+								b.Error(err)
+								return
+							}
+						}
+					})
+					s := c.Stats()
+					b.ReportMetric(float64(s.Requests)/float64(max(s.Frames, 1)), "req/frame")
+				})
+			}
+		}
+	}
+}
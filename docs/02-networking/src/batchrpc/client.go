@@ -0,0 +1,146 @@
+package batchrpc
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+)
+
+// Client sends calls on one connection. It is safe for concurrent use, and
+// only batches calls that are made concurrently.
+type Client struct {
+	nc   net.Conn
+	conn *codec.Conn // read by the reader goroutine only
+	out  *batcher
+	done chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+
+	mu      sync.Mutex
+	next    uint32
+	pending map[uint32]chan result
+	err     error // set once the connection is unusable
+}
+
+type result struct {
+	body []byte
+	err  error
+}
+
+// NewClient starts a client on conn. Close it to stop its goroutines.
+func NewClient(conn net.Conn, cfg Config) *Client {
+	cfg.setDefaults()
+	c := &Client{
+		nc:      conn,
+		conn:    codec.NewConn(conn, codec.NewLengthPrefixed(cfg.MaxFrame), 0),
+		out:     newBatcher(cfg),
+		done:    make(chan struct{}),
+		pending: make(map[uint32]chan result),
+	}
+	c.wg.Add(2)
+	go c.read()
+	go func() {
+		defer c.wg.Done()
+		if err := c.out.run(conn, c.done); err != nil {
+			c.fail(err)
+		}
+	}()
+	return c
+}
+
+// Call sends req and waits for its response. req is copied into the queue
+// before Call waits, so it may be reused as soon as Call returns, even when
+// ctx ends the wait first. A call canceled that way may still reach the
+// server, whose response is then dropped.
+func (c *Client) Call(ctx context.Context, req []byte) ([]byte, error) {
+	ch := make(chan result, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.next++
+	id := c.next
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	if err := c.out.add(id, req); err != nil {
+		c.forget(id)
+		return nil, err
+	}
+	select {
+	case r := <-ch:
+		return r.body, r.err
+	case <-ctx.Done():
+		c.forget(id)
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) forget(id uint32) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// read delivers responses until the connection fails. Each body is copied
+// out of the read buffer, which the next read overwrites.
+func (c *Client) read() {
+	defer c.wg.Done()
+	deliver := func(id uint32, body []byte) {
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ok {
+			ch <- result{body: bytes.Clone(body)}
+		}
+	}
+	for {
+		msgs, err := c.conn.Next()
+		for _, m := range msgs {
+			if err == nil {
+				err = parseEntries(m.Payload, deliver)
+			}
+		}
+		if err != nil {
+			c.fail(err)
+			return
+		}
+	}
+}
+
+// fail fails every waiting call with err, and every later one with the
+// first error the connection saw.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	for id, ch := range c.pending {
+		ch <- result{err: c.err}
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	c.nc.Close()
+}
+
+// Close closes the connection and fails the calls still waiting with
+// ErrClosed.
+func (c *Client) Close() error {
+	err := ErrClosed
+	c.once.Do(func() {
+		err = nil
+		c.fail(ErrClosed)
+		close(c.done)
+		c.wg.Wait()
+	})
+	return err
+}
+
+// Stats reports the requests the client sent and the frames they took.
+func (c *Client) Stats() Stats { return c.out.stats() }
@@ -0,0 +1,120 @@
+package batchrpc
+
+import (
+	"bytes"
+	"net"
+	"sync"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+)
+
+// Handler answers one request. In ordered mode it runs on the connection's
+// reading goroutine and req is only valid until it returns; the response
+// is copied out before the next request, so it may alias req. In unordered
+// mode every call has its own goroutine and its own copy of req.
+type Handler func(req []byte) []byte
+
+// Serve answers calls on every connection from ln until ln is closed.
+func Serve(ln net.Listener, h Handler, cfg Config) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go ServeConn(conn, h, cfg)
+	}
+}
+
+// ServeConn answers calls on conn until it fails or is closed, and closes
+// it.
+func ServeConn(conn net.Conn, h Handler, cfg Config) error {
+	defer conn.Close()
+	cfg.setDefaults()
+	c := codec.NewConn(conn, codec.NewLengthPrefixed(cfg.MaxFrame), 0)
+	if cfg.Ordered {
+		return serveOrdered(c, h, cfg)
+	}
+	return serveUnordered(c, conn, h, cfg)
+}
+
+// serveOrdered answers each frame with one frame of responses in request
+// order, and flushes once per read, so a frame of 64 requests costs one
+// read and one write.
+func serveOrdered(c *codec.Conn, h Handler, cfg Config) error {
+	var resp []byte
+	var err error
+	answer := func(id uint32, req []byte) {
+		out := h(req)
+		if err != nil {
+			return
+		}
+		if len(resp) > 0 && len(resp)+entryHeader+len(out) > cfg.MaxFrame {
+			err = c.Send(codec.Message{Payload: resp})
+			resp = resp[:0]
+		}
+		resp = appendEntry(resp, id, out)
+	}
+	for {
+		msgs, rerr := c.Next()
+		for _, m := range msgs {
+			resp = resp[:0]
+			if perr := parseEntries(m.Payload, answer); perr != nil && err == nil {
+				err = perr
+			}
+			if err == nil {
+				err = c.Send(codec.Message{Payload: resp})
+			}
+		}
+		if err == nil {
+			err = c.Flush()
+		}
+		if err == nil {
+			err = rerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// serveUnordered runs every request on its own goroutine. Responses are
+// batched as they finish, and a slow one holds up nothing but itself.
+func serveUnordered(c *codec.Conn, conn net.Conn, h Handler, cfg Config) error {
+	out := newBatcher(cfg)
+	done := make(chan struct{})
+	werr := make(chan error, 1)
+	go func() {
+		err := out.run(conn, done)
+		if err != nil {
+			conn.Close() // unblocks the reader
+		}
+		werr <- err
+	}()
+	var wg sync.WaitGroup
+	answer := func(id uint32, req []byte) {
+		req = bytes.Clone(req)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if out.add(id, h(req)) != nil {
+				conn.Close()
+			}
+		}()
+	}
+	var err error
+	for err == nil {
+		var msgs []codec.Message
+		msgs, err = c.Next()
+		for _, m := range msgs {
+			if perr := parseEntries(m.Payload, answer); perr != nil && err == nil {
+				err = perr
+			}
+		}
+	}
+	wg.Wait()
+	close(done)
+	if e := <-werr; e != nil && err == nil {
+		err = e
+	}
+	return err
+}
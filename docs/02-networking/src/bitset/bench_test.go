@@ -0,0 +1,223 @@
+package bitset
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand/v2"
+	"runtime"
+	"testing"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/pow2"
+)
+
+var (
+	sinkBool bool
+	sinkInt  int
+)
+
+// fdSet is the interface the three representations are benchmarked
+// through. Each is driven by the same method values, so all of them pay
+// the same indirect call per operation.
+type fdSet interface {
+	add(int)
+	remove(int)
+	has(int) bool
+}
+
+type (
+	mapSet  map[int]bool
+	boolSet []bool
+)
+
+func (m mapSet) add(i int)       { m[i] = true }
+func (m mapSet) remove(i int)    { delete(m, i) }
+func (m mapSet) has(i int) bool  { return m[i] }
+func (b boolSet) add(i int)      { b[i] = true }
+func (b boolSet) remove(i int)   { b[i] = false }
+func (b boolSet) has(i int) bool { return b[i] }
+func (s *Set) add(i int)         { s.Add(i) }
+func (s *Set) remove(i int)      { s.Remove(i) }
+func (s *Set) has(i int) bool    { return s.Has(i) }
+
+var fdCounts = []int{1_000, 100_000, 500_000}
+
+// populate adds every other fd below n, the density of a server whose
+// connections have churned for a while.
+func populate(s fdSet, n int) {
+	for fd := 0; fd < n; fd += 2 {
+		s.add(fd)
+	}
+}
+
+// footprint returns the heap bytes retained by build's result.
+func footprint(build func() fdSet) (fdSet, uint64) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	s := build()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	return s, after.HeapAlloc - before.HeapAlloc
+}
+
+// BenchmarkMembership runs the reactor's per-event operations, a lookup
+// for every ready fd and an occasional add or remove as connections come
+// and go, against fds drawn uniformly from [0, n).
+func BenchmarkMembership(b *testing.B) {
+	for _, n := range fdCounts {
+		rng := rand.New(rand.NewPCG(1, 2))
+		ops := make([]int, 1<<16)
+		for i := range ops {
+			ops[i] = rng.IntN(n)
+		}
+		for _, impl := range []struct {
+			name  string
+			build func() fdSet
+		}{
+			{"bitset", func() fdSet { return New(n) }},
+			{"bool", func() fdSet { return make(boolSet, n) }},
+			{"map", func() fdSet { return make(mapSet) }},
+		} {
+			s, size := footprint(func() fdSet {
+				s := impl.build()
+				populate(s, n)
+				return s
+			})
+			b.Run(fmt.Sprintf("fds=%d/%s", n, impl.name), func(b *testing.B) {
+				membership(b, ops, s.has, s.add, s.remove)
+				b.ReportMetric(float64(size), "B/set")
+			})
+			runtime.KeepAlive(s)
+		}
+	}
+}
+
+// membership does fifteen lookups for each add/remove pair.
+func membership(b *testing.B, ops []int, has func(int) bool, add, remove func(int)) {
+	m, i := pow2.MaskFor(len(ops)), 0
+	for b.Loop() {
+		fd := ops[m.Index(i)]
+		switch i & 15 {
+		case 0:
+			remove(fd)
+		case 1:
+			add(fd)
+		default:
+			sinkBool = has(fd)
+		}
+		i++
+	}
+}
+
+// BenchmarkIterate visits the members of a sparse set, 1% of n, which is
+// what walking the currently ready or write-blocked fds looks like.
+func BenchmarkIterate(b *testing.B) {
+	for _, n := range fdCounts {
+		rng := rand.New(rand.NewPCG(1, 2))
+		s := New(n)
+		bs := make(boolSet, n)
+		m := make(mapSet)
+		for range n / 100 {
+			fd := rng.IntN(n)
+			s.Add(fd)
+			bs.add(fd)
+			m.add(fd)
+		}
+		b.Run(fmt.Sprintf("fds=%d/bitset", n), func(b *testing.B) {
+			for b.Loop() {
+				sum := 0
+				for fd := s.Next(0); fd >= 0; fd = s.Next(fd + 1) {
+					sum += fd
+				}
+				sinkInt = sum
+			}
+		})
+		b.Run(fmt.Sprintf("fds=%d/bool", n), func(b *testing.B) {
+			for b.Loop() {
+				sum := 0
+				for fd, ok := range bs {
+					if ok {
+						sum += fd
+					}
+				}
+				sinkInt = sum
+			}
+		})
+		b.Run(fmt.Sprintf("fds=%d/map", n), func(b *testing.B) {
+			for b.Loop() {
+				sum := 0
+				for fd := range m {
+					sum += fd
+				}
+				sinkInt = sum
+			}
+		})
+	}
+}
+
+// BenchmarkBits compares math/bits with the loops it replaces. The
+// compiler turns OnesCount64 and TrailingZeros64 into single POPCNT and
+// TZCNT instructions where the CPU has them.
+func BenchmarkBits(b *testing.B) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	words := make([]uint64, 1024)
+	for i := range words {
+		words[i] = rng.Uint64() & rng.Uint64() // about 16 bits set
+	}
+	b.Run("popcount/naive", func(b *testing.B) {
+		for b.Loop() {
+			n := 0
+			for _, w := range words {
+				for ; w != 0; w >>= 1 {
+					n += int(w & 1)
+				}
+			}
+			sinkInt = n
+		}
+	})
+	b.Run("popcount/kernighan", func(b *testing.B) {
+		for b.Loop() {
+			n := 0
+			for _, w := range words {
+				for ; w != 0; w &= w - 1 {
+					n++
+				}
+			}
+			sinkInt = n
+		}
+	})
+	b.Run("popcount/bits", func(b *testing.B) {
+		for b.Loop() {
+			n := 0
+			for _, w := range words {
+				n += bits.OnesCount64(w)
+			}
+			sinkInt = n
+		}
+	})
+	b.Run("trailing-zeros/naive", func(b *testing.B) {
+		for b.Loop() {
+			n := 0
+			for _, w := range words {
+				tz := 0
+				for w != 0 && w&1 == 0 {
+					w >>= 1
+					tz++
+				}
+				n += tz
+			}
+			sinkInt = n
+		}
+	})
+	b.Run("trailing-zeros/bits", func(b *testing.B) {
+		for b.Loop() {
+			n := 0
+			for _, w := range words {
+				if w != 0 {
+					n += bits.TrailingZeros64(w)
+				}
+			}
+			sinkInt = n
+		}
+	})
+}
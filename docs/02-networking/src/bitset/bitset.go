@@ -0,0 +1,87 @@
+// Package bitset is a dense set of small non-negative integers, one bit
+// each. File descriptors are the intended members: the kernel hands out
+// the lowest free number, so the fds of a busy process are packed near
+// zero and a set of 100k of them fits in 12.5 KiB, where a map[int]bool of
+// the same size needs megabytes and a hash per lookup.
+package bitset
+
+import "math/bits"
+
+// Set is a set of non-negative integers. The zero value is an empty set.
+// A Set grows as members are added and never shrinks.
+type Set struct {
+	words []uint64
+}
+
+// New returns a set with room for members below n before it has to grow.
+func New(n int) *Set {
+	return &Set{words: make([]uint64, (n+63)/64)}
+}
+
+// Add inserts i.
+func (s *Set) Add(i int) {
+	w := i >> 6
+	if w >= len(s.words) {
+		s.grow(w)
+	}
+	s.words[w] |= 1 << (i & 63)
+}
+
+func (s *Set) grow(w int) {
+	grown := make([]uint64, max(w+1, 2*len(s.words)))
+	copy(grown, s.words)
+	s.words = grown
+}
+
+// Remove deletes i. Removing a non-member is a no-op.
+func (s *Set) Remove(i int) {
+	if w := i >> 6; w < len(s.words) {
+		s.words[w] &^= 1 << (i & 63)
+	}
+}
+
+// Has reports whether i is in the set.
+func (s *Set) Has(i int) bool {
+	w := i >> 6
+	return w < len(s.words) && s.words[w]&(1<<(i&63)) != 0
+}
+
+// Len returns the number of members. It counts every word, so it costs
+// one POPCNT per 64 possible members.
+func (s *Set) Len() int {
+	n := 0
+	for _, w := range s.words {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// Next returns the smallest member that is at least i, or -1 if there is
+// none. Iterate with
+//
+//	for i := s.Next(0); i >= 0; i = s.Next(i + 1) { ... }
+//
+// Empty words are skipped 64 members at a time, and the first member in a
+// word is found with a single TZCNT.
+func (s *Set) Next(i int) int {
+	w := i >> 6
+	if w >= len(s.words) {
+		return -1
+	}
+	word := s.words[w] >> (i & 63) << (i & 63)
+	for {
+		if word != 0 {
+			return w<<6 + bits.TrailingZeros64(word)
+		}
+		w++
+		if w >= len(s.words) {
+			return -1
+		}
+		word = s.words[w]
+	}
+}
+
+// Clear removes every member and keeps the storage.
+func (s *Set) Clear() {
+	clear(s.words)
+}
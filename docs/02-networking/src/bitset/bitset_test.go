@@ -0,0 +1,87 @@
+package bitset
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	var s Set
+	if s.Has(0) || s.Len() != 0 || s.Next(0) != -1 {
+		t.Fatal("zero Set is not empty")
+	}
+	s.Remove(1000) // beyond the storage
+
+	members := []int{0, 1, 63, 64, 65, 127, 128, 4095, 100_000}
+	for _, i := range members {
+		s.Add(i)
+	}
+	s.Add(64) // duplicate
+	if got := s.Len(); got != len(members) {
+		t.Fatalf("Len = %d, want %d", got, len(members))
+	}
+	for _, i := range members {
+		if !s.Has(i) {
+			t.Errorf("Has(%d) = false", i)
+		}
+	}
+	for _, i := range []int{2, 62, 66, 4094, 4096, 99_999, 100_001, 1 << 30} {
+		if s.Has(i) {
+			t.Errorf("Has(%d) = true", i)
+		}
+	}
+
+	var got []int
+	for i := s.Next(0); i >= 0; i = s.Next(i + 1) {
+		got = append(got, i)
+	}
+	if !slices.Equal(got, members) {
+		t.Fatalf("iteration = %v, want %v", got, members)
+	}
+	if n := s.Next(66); n != 127 {
+		t.Errorf("Next(66) = %d, want 127", n)
+	}
+	if n := s.Next(100_001); n != -1 {
+		t.Errorf("Next past the last member = %d, want -1", n)
+	}
+
+	s.Remove(64)
+	if s.Has(64) || !s.Has(63) || !s.Has(65) {
+		t.Error("Remove(64) touched its neighbours")
+	}
+	s.Clear()
+	if s.Len() != 0 || s.Next(0) != -1 {
+		t.Error("Clear left members behind")
+	}
+}
+
+// TestAgainstMap applies the same random operations to a Set and a map.
+func TestAgainstMap(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	s := New(100)
+	m := map[int]bool{}
+	for range 100_000 {
+		i := rng.IntN(5000)
+		switch rng.IntN(3) {
+		case 0:
+			s.Add(i)
+			m[i] = true
+		case 1:
+			s.Remove(i)
+			delete(m, i)
+		case 2:
+			if s.Has(i) != m[i] {
+				t.Fatalf("Has(%d) = %v, map says %v", i, s.Has(i), m[i])
+			}
+		}
+	}
+	if s.Len() != len(m) {
+		t.Fatalf("Len = %d, map has %d", s.Len(), len(m))
+	}
+	for i := s.Next(0); i >= 0; i = s.Next(i + 1) {
+		if !m[i] {
+			t.Fatalf("Next returned non-member %d", i)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package bloom
+
+import (
+	"fmt"
+	"net/netip"
+	"runtime"
+	"testing"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/cuckoo"
+)
+
+var sinkBool bool
+
+// set is what the accept path needs from a set of recent addresses.
+type set interface {
+	Add(netip.Addr) bool
+	Contains(netip.Addr) bool
+}
+
+// mapSet is the exact baseline.
+type mapSet map[netip.Addr]struct{}
+
+func (m mapSet) Add(a netip.Addr) bool {
+	_, ok := m[a]
+	m[a] = struct{}{}
+	return !ok
+}
+
+func (m mapSet) Contains(a netip.Addr) bool { _, ok := m[a]; return ok }
+
+var sets = []struct {
+	name string
+	new  func(n int) set
+}{
+	{"map", func(n int) set { return make(mapSet, n) }},
+	{"bloom", func(n int) set { return New[netip.Addr](n, 0.01) }},
+	{"cuckoo", func(n int) set { return cuckoo.New[netip.Addr](n) }},
+}
+
+// heapAfter returns the bytes of heap that build leaves in use.
+func heapAfter(build func() set) (set, uint64) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	s := build()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	return s, after.HeapAlloc - before.HeapAlloc
+}
+
+// BenchmarkContains looks up addresses in sets of n, half of them present,
+// in random order: the check on every accepted connection. It reports the
+// heap each set takes per address.
+func BenchmarkContains(b *testing.B) {
+	for _, n := range []int{10_000, 1_000_000} {
+		keys := addrs(n, 0)
+		probes := append(addrs(n/2, 0), addrs(n/2, n)...)
+		for _, s := range sets {
+			set, bytes := heapAfter(func() set {
+				set := s.new(n)
+				for _, a := range keys {
+					set.Add(a)
+				}
+				return set
+			})
+			b.Run(fmt.Sprintf("n=%d/%s", n, s.name), func(b *testing.B) {
+				i := 0
+				for b.Loop() {
+					sinkBool = set.Contains(probes[i%len(probes)])
+					i++
+				}
+				b.ReportMetric(float64(bytes)/float64(n), "B/key")
+			})
+		}
+	}
+}
+
+// BenchmarkAdd adds n new addresses to an empty set, sized for n up front.
+func BenchmarkAdd(b *testing.B) {
+	const n = 1_000_000
+	keys := addrs(n, 0)
+	for _, s := range sets {
+		b.Run(s.name, func(b *testing.B) {
+			set := s.new(n)
+			i := 0
+			for b.Loop() {
+				if i == n {
+					b.StopTimer()
+					set, i = s.new(n), 0
+					b.StartTimer()
+				}
+				sinkBool = set.Add(keys[i])
+				i++
+			}
+		})
+	}
+}
+
+// BenchmarkWindowAdd is the accept path's call: record the address and
+// learn whether it was seen within the window.
+func BenchmarkWindowAdd(b *testing.B) {
+	keys := addrs(1_000_000, 0)
+	w := NewWindow[netip.Addr](len(keys), 0.01, 1<<62)
+	i := 0
+	for b.Loop() {
+		sinkBool = w.Add(keys[i%len(keys)])
+		i++
+	}
+}
@@ -0,0 +1,137 @@
+// Package bloom is a Bloom filter for "have we seen this key" checks on hot
+// paths, such as a client address on every accepted connection, with a
+// memory cost that does not depend on the size of the key.
+//
+// A Bloom filter answers Contains with "no" or "probably": a key that was
+// added is always found, and a key that was not is found with a small,
+// configurable probability, the false-positive rate. At 1% it takes 1.3
+// bytes per key, against 50 or more for a map entry. Keys cannot be removed;
+// Window forgets them in bulk by rotating two filters, and the cuckoo
+// package has a filter with deletion.
+//
+// Filter is blocked: all of a key's bits fall in one 64-byte block, so a
+// lookup touches one cache line instead of one per bit. With millions of
+// keys the filter is larger than the CPU caches and each line is a memory
+// access, so this is what keeps a lookup in tens of nanoseconds. The price
+// is a higher false-positive rate for the same size, which New makes up
+// for with 5 to 10% more bits, more for lower rates.
+package bloom
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"sync/atomic"
+)
+
+const blockWords = 8 // uint64s in a block: one cache line
+
+// Filter is a Bloom filter over keys of type K. Add and Contains are safe
+// for concurrent use and do not lock.
+type Filter[K comparable] struct {
+	seed   maphash.Seed
+	blocks []block
+	k      int // bits per key
+}
+
+type block [blockWords]atomic.Uint64
+
+// New returns a Filter sized for n keys at false-positive rate fp. Past n
+// keys the rate rises: at 2n it is roughly the square root of fp.
+func New[K comparable](n int, fp float64) *Filter[K] {
+	n = max(n, 1)
+	fp = min(max(fp, 1e-9), 0.5)
+	// Start from the standard sizing, m = -n ln(fp) / ln(2)^2 bits with
+	// k = m/n ln(2) bits per key, and add blocks until the blocked rate
+	// is down to fp.
+	k := max(int(math.Round(-math.Log2(fp))), 1)
+	m := -float64(n) * math.Log(fp) / (math.Ln2 * math.Ln2)
+	nb := max(int(math.Ceil(m/blockBits)), 1)
+	for blockedRate(float64(n)/float64(nb), k) > fp {
+		nb += nb/32 + 1
+	}
+	return &Filter[K]{seed: maphash.MakeSeed(), blocks: make([]block, nb), k: k}
+}
+
+const blockBits = 64 * blockWords
+
+// blockedRate is the false-positive rate of a blocked filter with load
+// keys per block on average and k bits per key. Block loads are Poisson
+// distributed, and a block that got more than its share of keys has more
+// bits set: the rate is the average over the loads of the classic
+// (1 - e^(-jk/B))^k for a block of B bits holding j keys.
+func blockedRate(load float64, k int) float64 {
+	rate, p := 0.0, math.Exp(-load) // p is P(j keys in a block)
+	for j := 0; j < int(load+10*math.Sqrt(load)+10); j++ {
+		if j > 0 {
+			p *= load / float64(j)
+		}
+		rate += p * math.Pow(1-math.Pow(1-1.0/blockBits, float64(j*k)), float64(k))
+	}
+	return rate
+}
+
+// locate returns the key's block and the bits that place its bits in the
+// block, nine per position.
+func (f *Filter[K]) locate(key K) (b *block, bits64 uint64) {
+	h := maphash.Comparable(f.seed, key)
+	// The high bits pick the block with a multiply instead of a modulo.
+	// The positions come from a remix of the hash: deriving them from
+	// the same bits, as double hashing does, gives keys in a block far
+	// fewer distinct patterns and doubled the false positives at 0.1%.
+	i, _ := bits.Mul64(h, uint64(len(f.blocks)))
+	return &f.blocks[i], mix(h)
+}
+
+// mix is the murmur3 64-bit finalizer.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// next returns the word and bit of the next position drawn from x, and
+// the remaining bits, remixed when seven positions have used them up.
+func next(x uint64, i int) (w int, bit, rest uint64) {
+	if i > 0 && i%7 == 0 {
+		x = mix(x)
+	}
+	return int(x>>6) % blockWords, 1 << (x & 63), x >> 9
+}
+
+// Add adds key, and reports whether it was new: false means it was
+// already in the filter, or a false positive.
+func (f *Filter[K]) Add(key K) bool {
+	b, x := f.locate(key)
+	added := false
+	for i := range f.k {
+		var w int
+		var bit uint64
+		w, bit, x = next(x, i)
+		if b[w].Load()&bit == 0 {
+			b[w].Or(bit)
+			added = true
+		}
+	}
+	return added
+}
+
+// Contains reports whether key was probably added.
+func (f *Filter[K]) Contains(key K) bool {
+	b, x := f.locate(key)
+	for i := range f.k {
+		var w int
+		var bit uint64
+		w, bit, x = next(x, i)
+		if b[w].Load()&bit == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Bytes returns the size of the filter's bit array.
+func (f *Filter[K]) Bytes() int { return len(f.blocks) * blockWords * 8 }
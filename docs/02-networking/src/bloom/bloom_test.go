@@ -0,0 +1,127 @@
+package bloom
+
+import (
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/workload"
+)
+
+// addrs returns n distinct IPv4 addresses in random order, starting at the
+// offset-th.
+func addrs(n, offset int) []netip.Addr {
+	r := workload.Rand(1, uint64(offset))
+	out := make([]netip.Addr, n)
+	for i := range out {
+		// Distinct: a bijection of the index, spread over the space.
+		v := uint32(offset+i) * 0x9e3779b1
+		out[i] = netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+	}
+	r.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	return out
+}
+
+// falsePositives returns the share of 200,000 addresses never added that
+// f reports as present.
+func falsePositives(contains func(netip.Addr) bool, n int) float64 {
+	fp := 0
+	probes := addrs(200_000, n)
+	for _, a := range probes {
+		if contains(a) {
+			fp++
+		}
+	}
+	return float64(fp) / float64(len(probes))
+}
+
+func TestFalsePositives(t *testing.T) {
+	for _, rate := range []float64{0.01, 0.001} {
+		for _, n := range []int{1000, 1_000_000} {
+			f := New[netip.Addr](n, rate)
+			keys := addrs(n, 0)
+			for _, a := range keys {
+				f.Add(a)
+			}
+			for _, a := range keys {
+				if !f.Contains(a) {
+					t.Fatalf("fp=%v n=%d: %v was added but is not found", rate, n, a)
+				}
+			}
+			got := falsePositives(f.Contains, n)
+			t.Logf("fp=%v n=%d: %d bytes (%.1f bits per key), measured rate %.4f", rate, n, f.Bytes(), float64(8*f.Bytes())/float64(n), got)
+			// Each filter has its own seed, and a small one has few
+			// blocks, so the rate varies from run to run.
+			if got > 1.5*rate {
+				t.Errorf("fp=%v n=%d: false-positive rate %.4f", rate, n, got)
+			}
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	f := New[string](100, 0.01)
+	if !f.Add("a") {
+		t.Error("first Add of a key reported it present")
+	}
+	if f.Add("a") {
+		t.Error("second Add of a key reported it new")
+	}
+	if !f.Contains("a") || f.Contains("b") {
+		t.Error("Contains disagrees with Add")
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	f := New[netip.Addr](100_000, 0.01)
+	keys := addrs(100_000, 0)
+	var wg sync.WaitGroup
+	for g := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := g; i < len(keys); i += 4 {
+				f.Add(keys[i])
+				f.Contains(keys[(i+1)%len(keys)])
+			}
+		}()
+	}
+	wg.Wait()
+	for _, a := range keys {
+		if !f.Contains(a) {
+			t.Fatalf("%v lost by a concurrent Add", a)
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	var now int64
+	w := NewWindow[string](100, 0.01, time.Minute)
+	w.now = func() int64 { return now }
+	w.gens.Load().start = 0
+
+	if !w.Add("a") || w.Add("a") {
+		t.Fatal("Add does not report new keys")
+	}
+	now += int64(50 * time.Second)
+	w.Add("b")
+	now += int64(20 * time.Second) // rotated: a and b are in the old filter
+	if !w.Contains("a") || !w.Contains("b") {
+		t.Fatal("keys forgotten within one period")
+	}
+	if w.Add("b") {
+		t.Error("a key in the old filter was reported new")
+	}
+	now += int64(60 * time.Second) // rotated again: b was re-added
+	if w.Contains("a") {
+		t.Error("a key was remembered for more than two periods")
+	}
+	if !w.Contains("b") {
+		t.Error("a key added again was forgotten")
+	}
+	now += int64(5 * time.Minute) // idle: everything goes
+	if w.Contains("b") {
+		t.Error("a key survived two idle periods")
+	}
+}
@@ -0,0 +1,71 @@
+package bloom
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Window remembers keys for a limited time with two filters: new keys go
+// into the current one, lookups check both, and every period the older
+// filter is dropped and an empty one becomes current. A key is therefore
+// remembered for at least one period after it was last added, and at most
+// two.
+type Window[K comparable] struct {
+	n      int
+	fp     float64
+	period int64
+	gens   atomic.Pointer[generations[K]]
+	now    func() int64 // nanoseconds; replaced in tests
+}
+
+type generations[K comparable] struct {
+	cur, prev *Filter[K]
+	start     int64 // when cur became current
+}
+
+// NewWindow returns a Window that remembers keys for period to 2*period,
+// sized for n keys added per period at false-positive rate fp. It holds
+// two filters of that size, about 2.4 MB for a million keys at 1%.
+func NewWindow[K comparable](n int, fp float64, period time.Duration) *Window[K] {
+	w := &Window[K]{n: n, fp: fp, period: int64(period), now: func() int64 { return time.Now().UnixNano() }}
+	w.gens.Store(&generations[K]{cur: New[K](n, fp), prev: New[K](n, fp), start: w.now()})
+	return w
+}
+
+// current returns the generations at now, rotating them first when the
+// period is up. Of several goroutines that notice at once, one rotates;
+// a key another adds to the filter being retired at that moment is
+// remembered for one period less.
+func (w *Window[K]) current() *generations[K] {
+	g := w.gens.Load()
+	now := w.now()
+	if now-g.start < w.period {
+		return g
+	}
+	next := &generations[K]{cur: New[K](w.n, w.fp), prev: g.cur, start: now}
+	if now-g.start >= 2*w.period {
+		next.prev = New[K](w.n, w.fp) // idle for two periods: forget all
+	}
+	if w.gens.CompareAndSwap(g, next) {
+		return next
+	}
+	return w.gens.Load()
+}
+
+// Add records key and reports whether it was new in the window.
+func (w *Window[K]) Add(key K) bool {
+	g := w.current()
+	return g.cur.Add(key) && !g.prev.Contains(key)
+}
+
+// Contains reports whether key was probably added within the window.
+func (w *Window[K]) Contains(key K) bool {
+	g := w.current()
+	return g.cur.Contains(key) || g.prev.Contains(key)
+}
+
+// Bytes returns the size of both filters.
+func (w *Window[K]) Bytes() int {
+	g := w.gens.Load()
+	return g.cur.Bytes() + g.prev.Bytes()
+}
@@ -0,0 +1,220 @@
+// Package breaker protects a client and the service it calls from each
+// other when the service is failing: a circuit Breaker that stops calls
+// while most of them fail, a RetryBudget that caps retries at a fraction
+// of requests, and a Transport that applies both to an http.Client.
+//
+// Retries are what turn a short overload into a lasting one. A client
+// that retries every failed request up to three times offers the server
+// four times its normal load at exactly the moment the server has the
+// least capacity to spare. If that keeps the server failing, the extra
+// load never goes away. The retrystorm experiment shows this. A budget
+// bounds the amplification; the breaker removes the load altogether until
+// the service recovers.
+//
+// The Breaker counts outcomes over a rolling window split into buckets,
+// rotated lazily as calls arrive, so an idle Breaker costs nothing. Once
+// the window holds at least MinRequests calls and FailureRate of them
+// failed, it opens and rejects every call with ErrOpen for OpenFor. Then
+// it lets Probes calls through at a time (half-open). Probes successes
+// close it again, and any probe failure reopens it.
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned when the Breaker rejects a call.
+var ErrOpen = errors.New("breaker: circuit open")
+
+// State is the Breaker's state.
+type State int
+
+const (
+	Closed   State = iota // calls flow, outcomes are counted
+	Open                  // calls are rejected
+	HalfOpen              // a few probe calls are let through
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Config tunes a Breaker. Zero fields take the defaults noted.
+type Config struct {
+	Window      time.Duration // span of the rolling outcome counts; 10s
+	Buckets     int           // slices the window is counted in; 10
+	MinRequests int           // calls in the window before it may open; 20
+	FailureRate float64       // fraction of failed calls that opens it; 0.5
+	OpenFor     time.Duration // time open before probing; 5s
+	Probes      int           // probes at once, and successes that close it; 3
+}
+
+// Stats is a snapshot of a Breaker.
+type Stats struct {
+	State           State
+	Calls, Failures int // in the window; zero unless closed
+	Opens, Rejected int64
+}
+
+// Breaker is a circuit breaker. It is safe for concurrent use.
+type Breaker struct {
+	cfg Config
+	now func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	gen      uint64 // bumped on every change of state; outcomes of older calls are dropped
+	buckets  []bucket
+	head     int       // bucket for the current slice
+	headEnd  time.Time // when the current slice ends
+	openedAt time.Time
+	probing  int // half-open calls in flight
+	probeOK  int // half-open successes so far
+
+	opens, rejected int64
+}
+
+type bucket struct{ calls, failures int }
+
+// New returns a closed Breaker.
+func New(cfg Config) *Breaker {
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.Buckets <= 0 {
+		cfg.Buckets = 10
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 20
+	}
+	if cfg.FailureRate <= 0 || cfg.FailureRate > 1 {
+		cfg.FailureRate = 0.5
+	}
+	if cfg.OpenFor <= 0 {
+		cfg.OpenFor = 5 * time.Second
+	}
+	if cfg.Probes <= 0 {
+		cfg.Probes = 3
+	}
+	return &Breaker{cfg: cfg, now: time.Now, buckets: make([]bucket, cfg.Buckets)}
+}
+
+// Allow asks to make a call. If the Breaker lets it through, the caller
+// makes the call and reports its outcome by calling done exactly once.
+// Otherwise Allow returns ErrOpen.
+func (b *Breaker) Allow() (done func(ok bool), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		now := b.now()
+		if now.Sub(b.openedAt) < b.cfg.OpenFor {
+			b.rejected++
+			return nil, ErrOpen
+		}
+		b.set(HalfOpen, now)
+		fallthrough
+	case HalfOpen:
+		if b.probing >= b.cfg.Probes {
+			b.rejected++
+			return nil, ErrOpen
+		}
+		b.probing++
+	}
+	gen := b.gen
+	return func(ok bool) { b.record(gen, ok) }, nil
+}
+
+func (b *Breaker) record(gen uint64, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if gen != b.gen {
+		return // allowed in a state the Breaker has since left
+	}
+	now := b.now()
+	switch b.state {
+	case Closed:
+		b.advance(now)
+		bk := &b.buckets[b.head]
+		bk.calls++
+		if ok {
+			return
+		}
+		bk.failures++
+		calls, failures := b.sum()
+		if calls >= b.cfg.MinRequests && float64(failures) >= b.cfg.FailureRate*float64(calls) {
+			b.set(Open, now)
+		}
+	case HalfOpen:
+		b.probing--
+		if !ok {
+			b.set(Open, now)
+			return
+		}
+		if b.probeOK++; b.probeOK >= b.cfg.Probes {
+			b.set(Closed, now)
+		}
+	}
+}
+
+// set changes state. b.mu is held.
+func (b *Breaker) set(s State, now time.Time) {
+	b.state = s
+	b.gen++
+	switch s {
+	case Open:
+		b.opens++
+		b.openedAt = now
+	case HalfOpen:
+		b.probing, b.probeOK = 0, 0
+	case Closed:
+		clear(b.buckets)
+		b.headEnd = time.Time{}
+	}
+}
+
+// advance rotates the buckets up to now, clearing the ones that fall out
+// of the window. b.mu is held.
+func (b *Breaker) advance(now time.Time) {
+	slice := b.cfg.Window / time.Duration(len(b.buckets))
+	if b.headEnd.IsZero() || now.Sub(b.headEnd) >= b.cfg.Window {
+		clear(b.buckets)
+		b.headEnd = now.Add(slice)
+		return
+	}
+	for !now.Before(b.headEnd) {
+		b.head = (b.head + 1) % len(b.buckets)
+		b.buckets[b.head] = bucket{}
+		b.headEnd = b.headEnd.Add(slice)
+	}
+}
+
+func (b *Breaker) sum() (calls, failures int) {
+	for _, bk := range b.buckets {
+		calls += bk.calls
+		failures += bk.failures
+	}
+	return calls, failures
+}
+
+// Stats returns the Breaker's state and counters.
+func (b *Breaker) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := Stats{State: b.state, Opens: b.opens, Rejected: b.rejected}
+	if b.state == Closed {
+		b.advance(b.now())
+		s.Calls, s.Failures = b.sum()
+	}
+	return s
+}
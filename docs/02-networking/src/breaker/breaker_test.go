@@ -0,0 +1,172 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// clock is a manual time source for a Breaker.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTest(cfg Config) (*Breaker, *clock) {
+	c := &clock{t: time.Unix(1000, 0)}
+	b := New(cfg)
+	b.now = c.now
+	return b, c
+}
+
+// call makes one call through b with the given outcome and reports whether
+// b let it through.
+func call(t *testing.T, b *Breaker, ok bool) bool {
+	t.Helper()
+	done, err := b.Allow()
+	if err != nil {
+		if !errors.Is(err, ErrOpen) {
+			t.Fatalf("Allow: %v", err)
+		}
+		return false
+	}
+	done(ok)
+	return true
+}
+
+var cfg = Config{Window: 10 * time.Second, Buckets: 10, MinRequests: 10, FailureRate: 0.5, OpenFor: 5 * time.Second, Probes: 2}
+
+func TestOpens(t *testing.T) {
+	b, _ := newTest(cfg)
+	for i := range 9 {
+		call(t, b, false)
+		if s := b.Stats(); s.State != Closed {
+			t.Fatalf("open after %d failures, below MinRequests", i+1)
+		}
+	}
+	call(t, b, false)
+	if s := b.Stats(); s.State != Open || s.Opens != 1 {
+		t.Fatalf("stats %+v after 10 failures, want open", s)
+	}
+	if call(t, b, true) {
+		t.Fatal("open breaker let a call through")
+	}
+	if s := b.Stats(); s.Rejected != 1 {
+		t.Errorf("rejected %d, want 1", s.Rejected)
+	}
+}
+
+func TestFailureRate(t *testing.T) {
+	b, _ := newTest(cfg)
+	for range 30 {
+		call(t, b, true)
+	}
+	for range 29 {
+		call(t, b, false)
+	}
+	if s := b.Stats(); s.State != Closed || s.Calls != 59 || s.Failures != 29 {
+		t.Fatalf("stats %+v with 29 of 59 failed, want closed", s)
+	}
+	call(t, b, false)
+	if s := b.Stats(); s.State != Open {
+		t.Fatalf("stats %+v with 30 of 60 failed, want open", s)
+	}
+}
+
+func TestWindowForgets(t *testing.T) {
+	b, c := newTest(cfg)
+	for range 8 {
+		call(t, b, false)
+	}
+	c.advance(5 * time.Second)
+	for range 8 {
+		call(t, b, true)
+	}
+	c.advance(6 * time.Second) // the failures are now out of the window
+	for range 4 {
+		call(t, b, false)
+	}
+	if s := b.Stats(); s.State != Closed || s.Calls != 12 || s.Failures != 4 {
+		t.Fatalf("stats %+v, want 12 calls and 4 failures in the window", s)
+	}
+	c.advance(time.Minute)
+	if s := b.Stats(); s.Calls != 0 {
+		t.Errorf("%d calls in the window after a minute idle", s.Calls)
+	}
+}
+
+func open(t *testing.T, b *Breaker) {
+	t.Helper()
+	for b.Stats().State == Closed {
+		call(t, b, false)
+	}
+}
+
+func TestHalfOpen(t *testing.T) {
+	b, c := newTest(cfg)
+	open(t, b)
+	c.advance(cfg.OpenFor - time.Millisecond)
+	if call(t, b, true) {
+		t.Fatal("let a call through before OpenFor")
+	}
+	c.advance(time.Millisecond)
+
+	// Probes calls at once, and no more.
+	var probes []func(bool)
+	for range cfg.Probes {
+		done, err := b.Allow()
+		if err != nil {
+			t.Fatalf("probe: %v", err)
+		}
+		probes = append(probes, done)
+	}
+	if s := b.Stats(); s.State != HalfOpen {
+		t.Fatalf("state %v while probing", s.State)
+	}
+	if call(t, b, true) {
+		t.Fatal("let more than Probes calls through")
+	}
+	for _, done := range probes {
+		done(true)
+	}
+	if s := b.Stats(); s.State != Closed {
+		t.Fatalf("state %v after %d good probes, want closed", s.State, cfg.Probes)
+	}
+
+	// A failed probe reopens it, and the other probe's outcome is dropped.
+	open(t, b)
+	c.advance(cfg.OpenFor)
+	bad, _ := b.Allow()
+	good, _ := b.Allow()
+	bad(false)
+	good(true)
+	if s := b.Stats(); s.State != Open || s.Opens != 3 {
+		t.Fatalf("stats %+v after a failed probe, want open for the third time", s)
+	}
+}
+
+func TestStaleOutcome(t *testing.T) {
+	b, c := newTest(cfg)
+	slow, _ := b.Allow() // a call that outlives the next open and half-open
+	open(t, b)
+	c.advance(cfg.OpenFor)
+	probe, _ := b.Allow()
+	slow(false)
+	if s := b.Stats(); s.State != HalfOpen {
+		t.Fatalf("a call from before the open changed the state to %v", s.State)
+	}
+	probe(true)
+}
+
+func BenchmarkAllow(b *testing.B) {
+	br := New(Config{})
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			done, err := br.Allow()
+			if err != nil {
+				b.Fatal(err)
+			}
+			done(true)
+		}
+	})
+}
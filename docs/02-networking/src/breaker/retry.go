@@ -0,0 +1,49 @@
+package breaker
+
+import "sync/atomic"
+
+// RetryBudget caps retries at a fraction of requests. Every request
+// deposits Ratio tokens, up to Burst, and every retry withdraws one. Over
+// any stretch of time a client retries at most Ratio times as often as it
+// sends requests, plus Burst, however many of its requests fail. A budget
+// of 0.1 adds at most 10% to the load a failing server sees, where three
+// unbudgeted retries add 300%.
+type RetryBudget struct {
+	ratio, burst int64        // in milli-tokens
+	tokens       atomic.Int64 // in milli-tokens
+	denied       atomic.Int64
+}
+
+// NewRetryBudget returns a budget that starts full.
+func NewRetryBudget(ratio float64, burst int) *RetryBudget {
+	b := &RetryBudget{ratio: int64(ratio * 1000), burst: int64(burst) * 1000}
+	b.tokens.Store(b.burst)
+	return b
+}
+
+// Request records a request, which earns the budget Ratio of a retry.
+func (b *RetryBudget) Request() {
+	for {
+		t := b.tokens.Load()
+		if t >= b.burst || b.tokens.CompareAndSwap(t, min(t+b.ratio, b.burst)) {
+			return
+		}
+	}
+}
+
+// Retry reports whether a retry may go ahead, and spends one if so.
+func (b *RetryBudget) Retry() bool {
+	for {
+		t := b.tokens.Load()
+		if t < 1000 {
+			b.denied.Add(1)
+			return false
+		}
+		if b.tokens.CompareAndSwap(t, t-1000) {
+			return true
+		}
+	}
+}
+
+// Denied returns how many retries the budget has refused.
+func (b *RetryBudget) Denied() int64 { return b.denied.Load() }
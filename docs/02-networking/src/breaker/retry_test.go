@@ -0,0 +1,48 @@
+package breaker
+
+import "testing"
+
+func TestRetryBudget(t *testing.T) {
+	b := NewRetryBudget(0.1, 5)
+	for i := range 5 {
+		if !b.Retry() {
+			t.Fatalf("retry %d of a full burst of 5 denied", i+1)
+		}
+	}
+	if b.Retry() {
+		t.Fatal("retry allowed with the burst spent")
+	}
+	for range 9 {
+		b.Request()
+	}
+	if b.Retry() {
+		t.Fatal("retry allowed after 9 requests at 0.1")
+	}
+	b.Request()
+	if !b.Retry() {
+		t.Fatal("retry denied after 10 requests at 0.1")
+	}
+	for range 1000 {
+		b.Request()
+	}
+	n := 0
+	for b.Retry() {
+		n++
+	}
+	if n != 5 {
+		t.Errorf("%d retries after 1000 requests, want the burst of 5", n)
+	}
+	if d := b.Denied(); d != 3 {
+		t.Errorf("denied %d, want 3", d)
+	}
+}
+
+func BenchmarkRetryBudget(b *testing.B) {
+	rb := NewRetryBudget(0.1, 10)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rb.Request()
+			rb.Retry()
+		}
+	})
+}
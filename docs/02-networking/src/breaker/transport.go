@@ -0,0 +1,121 @@
+package breaker
+
+import (
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Transport is an http.RoundTripper that retries failed requests and
+// consults a Breaker before every attempt:
+//
+//	client := &http.Client{Transport: &breaker.Transport{
+//		Base:    &http.Transport{ResponseHeaderTimeout: 100 * time.Millisecond},
+//		Breaker: breaker.New(breaker.Config{}),
+//		Budget:  breaker.NewRetryBudget(0.1, 10),
+//		Retries: 3,
+//	}}
+//
+// An attempt failed if it returned an error or a 5xx status. Only requests
+// that can be sent twice are retried: idempotent methods whose body, if
+// any, can be rebuilt with GetBody. A request the Breaker rejects fails at
+// once with ErrOpen and is not retried. One Breaker and one RetryBudget
+// should be shared by everything that calls the same service.
+type Transport struct {
+	Base    http.RoundTripper // nil means http.DefaultTransport
+	Breaker *Breaker          // nil lets every attempt through
+	Budget  *RetryBudget      // nil allows every retry up to Retries
+	Retries int               // attempts after the first
+	Backoff time.Duration     // wait before the first retry, doubled for each further one, jittered
+
+	attempts, retries, rejected atomic.Int64
+}
+
+// TransportStats counts what a Transport has done.
+type TransportStats struct {
+	Attempts int64 // requests sent to the server, retries included
+	Retries  int64
+	Denied   int64 // retries the budget refused
+	Rejected int64 // attempts the Breaker refused
+}
+
+// Stats returns the Transport's counters. Denied counts the whole budget,
+// which may be shared with other Transports.
+func (t *Transport) Stats() TransportStats {
+	s := TransportStats{Attempts: t.attempts.Load(), Retries: t.retries.Load(), Rejected: t.rejected.Load()}
+	if t.Budget != nil {
+		s.Denied = t.Budget.Denied()
+	}
+	return s
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Budget != nil {
+		t.Budget.Request()
+	}
+	backoff := t.Backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.try(req)
+		if !failed(resp, err) || err == ErrOpen || attempt == t.Retries || !replayable(req) ||
+			req.Context().Err() != nil || t.Budget != nil && !t.Budget.Retry() {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // lets the connection be reused
+			resp.Body.Close()
+		}
+		if backoff > 0 {
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-time.After(backoff/2 + rand.N(backoff/2+1)):
+			}
+			backoff *= 2
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		t.retries.Add(1)
+	}
+}
+
+func (t *Transport) try(req *http.Request) (*http.Response, error) {
+	var done func(bool)
+	if t.Breaker != nil {
+		var err error
+		if done, err = t.Breaker.Allow(); err != nil {
+			t.rejected.Add(1)
+			return nil, err
+		}
+	}
+	t.attempts.Add(1)
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if done != nil {
+		done(!failed(resp, err))
+	}
+	return resp, err
+}
+
+func failed(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= 500
+}
+
+// replayable reports whether req may be sent again.
+func replayable(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package breaker
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// failing serves 503 to the first n requests and 200 after that.
+func failing(t *testing.T, n int64) (*httptest.Server, *atomic.Int64) {
+	var hits atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if hits.Add(1) <= n {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func get(t *testing.T, c *http.Client, url string) (int, error) {
+	t.Helper()
+	resp, err := c.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestTransportRetries(t *testing.T) {
+	srv, hits := failing(t, 2)
+	tr := &Transport{Retries: 3}
+	c := &http.Client{Transport: tr}
+	if code, err := get(t, c, srv.URL); err != nil || code != http.StatusOK {
+		t.Fatalf("got %d, %v; want 200 on the third attempt", code, err)
+	}
+	if s := tr.Stats(); hits.Load() != 3 || s.Attempts != 3 || s.Retries != 2 {
+		t.Errorf("%d hits, stats %+v; want 3 attempts", hits.Load(), s)
+	}
+
+	srv, hits = failing(t, 10)
+	if code, _ := get(t, c, srv.URL); code != http.StatusServiceUnavailable || hits.Load() != 4 {
+		t.Errorf("got %d after %d hits, want 503 after 1+3", code, hits.Load())
+	}
+}
+
+func TestTransportReplay(t *testing.T) {
+	srv, hits := failing(t, 1)
+	c := &http.Client{Transport: &Transport{Retries: 3}}
+
+	// POST is not idempotent: one attempt.
+	resp, err := c.Post(srv.URL, "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || hits.Load() != 1 {
+		t.Fatalf("POST: %d after %d hits, want one attempt", resp.StatusCode, hits.Load())
+	}
+
+	// PUT with a rebuildable body is retried with the body intact.
+	srv, hits = failing(t, 1)
+	req, _ := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader("body"))
+	if resp, err = c.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || hits.Load() != 2 {
+		t.Errorf("PUT: %d after %d hits, want a successful retry", resp.StatusCode, hits.Load())
+	}
+}
+
+func TestTransportBudget(t *testing.T) {
+	srv, hits := failing(t, 1<<62)
+	tr := &Transport{Retries: 3, Budget: NewRetryBudget(0.1, 5)}
+	c := &http.Client{Transport: tr}
+	for range 100 {
+		get(t, c, srv.URL)
+	}
+	// 100 requests earn 10 retries, plus the burst of 5.
+	if n := hits.Load(); n > 115 {
+		t.Errorf("%d attempts for 100 requests, want at most 115", n)
+	}
+	if s := tr.Stats(); s.Denied == 0 {
+		t.Errorf("stats %+v: the budget denied nothing", s)
+	}
+}
+
+func TestTransportBreaker(t *testing.T) {
+	srv, hits := failing(t, 1<<62)
+	br := New(Config{MinRequests: 10})
+	tr := &Transport{Retries: 3, Breaker: br}
+	c := &http.Client{Transport: tr}
+	var rejected int
+	for range 100 {
+		if _, err := get(t, c, srv.URL); errors.Is(err, ErrOpen) {
+			rejected++
+		}
+	}
+	if n := hits.Load(); n != 10 {
+		t.Errorf("%d attempts reached the server, want 10 before the breaker opened", n)
+	}
+	if s := br.Stats(); s.State != Open || rejected == 0 || int64(rejected) != tr.Stats().Rejected {
+		t.Errorf("breaker %+v, %d requests rejected, transport %+v", s, rejected, tr.Stats())
+	}
+}
@@ -0,0 +1,185 @@
+package budget
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Allocation budgets hold a server's heap allocations per request, counted
+// over one end of an in-process transport (see the transport package)
+// with the server on the other. Unlike latency, the count is the same on
+// every machine and every run, so the budget can sit at what a healthy
+// handler allocates and fail the test on the first extra allocation:
+//
+//	func TestAllocBudget(t *testing.T) {
+//		client, server, _ := transport.Pair("pipe")
+//		go serve(server)
+//		budget.CheckAllocs(t, budget.LineConn(client, 64), 0)
+//	}
+//
+// testing.AllocsPerRun counts every allocation in the process, so the
+// client side must allocate nothing itself: the Sessions from LineConn
+// and HTTPConn do not, which leaves the server's allocations alone. The
+// Sessions from Line and HTTP allocate, and do not suit a budget.
+
+// connTimeout bounds the round trips of a LineConn or HTTPConn Session
+// together. A per-round-trip deadline would allocate on a net.Pipe.
+const connTimeout = time.Minute
+
+// LineConn returns a Session over conn to an echo server that answers each
+// newline-terminated message with the same line, sending messages of size
+// bytes including the newline. Its round trips allocate nothing.
+func LineConn(conn net.Conn, size int) Session {
+	conn.SetDeadline(time.Now().Add(connTimeout))
+	msg := []byte(strings.Repeat("x", max(size-1, 0)) + "\n")
+	return &lineSession{conn: conn, r: bufio.NewReader(conn), msg: msg, fixed: true}
+}
+
+// HTTPConn returns a Session over conn that sends GET requests for path
+// on one kept-alive HTTP/1.1 connection. It reads the responses itself
+// rather than with net/http, so that its round trips allocate nothing;
+// it understands a response with a Content-Length, which net/http sets
+// for bodies under 2 KiB written by a handler that returns.
+func HTTPConn(conn net.Conn, path string) Session {
+	conn.SetDeadline(time.Now().Add(connTimeout))
+	return &rawHTTPSession{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		req:  []byte("GET " + path + " HTTP/1.1\r\nHost: budget\r\n\r\n"),
+	}
+}
+
+type rawHTTPSession struct {
+	conn net.Conn
+	r    *bufio.Reader
+	req  []byte
+}
+
+var contentLength = []byte("content-length:")
+
+func (s *rawHTTPSession) RoundTrip() error {
+	if _, err := s.conn.Write(s.req); err != nil {
+		return err
+	}
+	status, err := s.r.ReadSlice('\n')
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(status, []byte("HTTP/1.1 200 ")) {
+		return fmt.Errorf("status %q", bytes.TrimSpace(status))
+	}
+	n := -1
+	for {
+		line, err := s.r.ReadSlice('\n')
+		if err != nil {
+			return err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			break
+		}
+		if len(line) > len(contentLength) && bytes.EqualFold(line[:len(contentLength)], contentLength) {
+			v := bytes.TrimSpace(line[len(contentLength):])
+			if n, err = atoi(v); err != nil {
+				return err
+			}
+		}
+	}
+	if n < 0 {
+		return errNoLength
+	}
+	_, err = s.r.Discard(n)
+	return err
+}
+
+func (s *rawHTTPSession) Close() error { return s.conn.Close() }
+
+var errNoLength = errors.New("budget: response without Content-Length")
+
+// atoi parses a decimal length without the string conversion
+// strconv.Atoi would need.
+func atoi(b []byte) (int, error) {
+	if len(b) == 0 || len(b) > 9 {
+		return 0, fmt.Errorf("budget: bad Content-Length %q", b)
+	}
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("budget: bad Content-Length %q", b)
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, nil
+}
+
+// AllocsPerRequest returns the average heap allocations of a round trip
+// on s over runs round trips, after one to warm up, as counted by
+// testing.AllocsPerRun. It fails tb if a round trip fails.
+func AllocsPerRequest(tb testing.TB, s Session, runs int) float64 {
+	tb.Helper()
+	var err error
+	allocs := testing.AllocsPerRun(runs, func() {
+		if err == nil {
+			err = s.RoundTrip()
+		}
+	})
+	if err != nil {
+		tb.Fatalf("budget: round trip: %v", err)
+	}
+	return allocs
+}
+
+// CheckAllocs fails tb if a round trip on s allocates more than max on
+// average over 1000 of them, and logs the count either way. It is not
+// scaled: an allocation count does not depend on the machine. It is
+// skipped under the race detector, which allocates on its own.
+func CheckAllocs(tb testing.TB, s Session, max float64) {
+	tb.Helper()
+	if raceEnabled {
+		tb.Skip("allocation budgets are off under the race detector")
+	}
+	allocs := AllocsPerRequest(tb, s, 1000)
+	tb.Logf("budget: %.2f allocations per request (budget %v)", allocs, max)
+	if allocs > max {
+		tb.Errorf("budget: %.2f allocations per request exceeds %v", allocs, max)
+	}
+}
+
+// ConnListener is a net.Listener that accepts conn once and then blocks
+// until it is closed, so that an http.Server can serve one end of a
+// transport pair.
+type ConnListener struct {
+	conn      net.Conn
+	once      sync.Once
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewConnListener returns a listener that accepts conn.
+func NewConnListener(conn net.Conn) *ConnListener {
+	return &ConnListener{conn: conn, closed: make(chan struct{})}
+}
+
+func (l *ConnListener) Accept() (net.Conn, error) {
+	var c net.Conn
+	l.once.Do(func() { c = l.conn })
+	if c != nil {
+		return c, nil
+	}
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *ConnListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *ConnListener) Addr() net.Addr { return l.conn.LocalAddr() }
@@ -0,0 +1,76 @@
+package budget
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/transport"
+)
+
+// pipeEcho serves newline-delimited echo on the server end of a net.Pipe,
+// copying each line into copies fresh buffers first, and returns the
+// client end.
+func pipeEcho(tb testing.TB, copies int) net.Conn {
+	tb.Helper()
+	client, server, err := transport.Pair("pipe")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { client.Close() })
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		for {
+			line, err := r.ReadSlice('\n')
+			if err != nil {
+				return
+			}
+			for range copies {
+				line = append([]byte(nil), line...)
+			}
+			if _, err := server.Write(line); err != nil {
+				return
+			}
+		}
+	}()
+	return client
+}
+
+// TestAllocsPerRequest checks that the count is the server's: the client
+// end of a LineConn adds nothing of its own.
+func TestAllocsPerRequest(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+	for _, copies := range []int{0, 1, 3} {
+		s := LineConn(pipeEcho(t, copies), 64)
+		if got := AllocsPerRequest(t, s, 200); got != float64(copies) {
+			t.Errorf("server copying %d times: %v allocations per request", copies, got)
+		}
+	}
+	CheckAllocs(t, LineConn(pipeEcho(t, 0), 64), 0)
+}
+
+func TestHTTPConn(t *testing.T) {
+	client, server, err := transport.Pair("pipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})}
+	go srv.Serve(NewConnListener(server))
+	defer srv.Close()
+
+	s := HTTPConn(client, "/")
+	for range 3 {
+		if err := s.RoundTrip(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := AllocsPerRequest(t, s, 100); got == 0 {
+		t.Error("no allocations counted for a net/http request")
+	}
+}
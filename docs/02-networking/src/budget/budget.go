@@ -0,0 +1,295 @@
+// Package budget holds the example servers to a latency budget in ordinary
+// go test runs, so that a change which makes the optimized examples slower
+// fails a test instead of going unnoticed until someone reruns the
+// benchmarks by hand.
+//
+// Absolute numbers do not travel between machines, so Run calibrates
+// first: it drives the server flat out from all connections to find what
+// this machine can sustain, then offers a fixed fraction of that open
+// loop and records each round trip from when it was due. Check fails the
+// test when the p99 exceeds the budget:
+//
+//	func TestLatencyBudget(t *testing.T) {
+//		addr := startServer(t)
+//		res := budget.Run(t, budget.Line(addr, 64), budget.Load{})
+//		budget.Check(t, res, 20*time.Millisecond)
+//	}
+//
+// Budgets should be generous, several times the p99 of a healthy run, so
+// they catch a regression by an order of magnitude, not noise. The tests
+// are skipped with -short or LATENCY_BUDGET=off, and LATENCY_BUDGET_SCALE
+// multiplies every budget for slow or shared machines. The race detector
+// triples them.
+//
+// With fewer than two CPUs the load, the server and the runtime take
+// turns on one, and the p99 measures how they were scheduled more than
+// the server: the same healthy run varies by tens of milliseconds. Check
+// then fails only on errors, which still catch a server that stops
+// replying, and logs the p99 without holding it to the budget.
+// LATENCY_BUDGET=on holds it anyway.
+package budget
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// timeout bounds a single round trip, so a server that stops replying
+// fails the test instead of hanging it.
+const timeout = 5 * time.Second
+
+// Session is one client connection. RoundTrip sends a request and waits
+// for its complete reply.
+type Session interface {
+	RoundTrip() error
+	Close() error
+}
+
+// Dialer opens a Session.
+type Dialer func() (Session, error)
+
+// Line dials an echo server that answers each newline-terminated message
+// with the same line, sending messages of size bytes including the
+// newline.
+func Line(addr string, size int) Dialer {
+	msg := []byte(strings.Repeat("x", max(size-1, 0)) + "\n")
+	return func() (Session, error) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return &lineSession{conn: conn, r: bufio.NewReader(conn), msg: msg}, nil
+	}
+}
+
+type lineSession struct {
+	conn  net.Conn
+	r     *bufio.Reader
+	msg   []byte
+	fixed bool // LineConn set one deadline for the whole session
+}
+
+func (s *lineSession) RoundTrip() error {
+	if !s.fixed {
+		s.conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if _, err := s.conn.Write(s.msg); err != nil {
+		return err
+	}
+	_, err := s.r.ReadSlice('\n')
+	return err
+}
+
+func (s *lineSession) Close() error { return s.conn.Close() }
+
+// HTTP dials a server and issues GET requests for url over one kept-alive
+// connection per Session.
+func HTTP(url string) Dialer {
+	return func() (Session, error) {
+		tr := &http.Transport{MaxIdleConnsPerHost: 1}
+		return &httpSession{client: &http.Client{Transport: tr, Timeout: timeout}, url: url}, nil
+	}
+}
+
+type httpSession struct {
+	client *http.Client
+	url    string
+}
+
+func (s *httpSession) RoundTrip() error {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSession) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// Load shapes a run. Zero fields take the defaults noted.
+type Load struct {
+	Conns       int           // client connections; 16
+	Calibrate   time.Duration // closed-loop run that measures capacity; 300ms
+	Duration    time.Duration // open-loop run that is measured; 1s
+	Utilization float64       // fraction of the measured capacity offered; 0.5
+}
+
+// Result is one run.
+type Result struct {
+	Capacity float64 // round trips per second the calibration sustained
+	Rate     float64 // round trips per second offered
+	N        int     // round trips measured
+	Errors   int
+	P50, P99 time.Duration
+	Max      time.Duration
+}
+
+func (r *Result) String() string {
+	return fmt.Sprintf("%.0f/s of %.0f/s capacity, n=%d errors=%d p50=%v p99=%v max=%v",
+		r.Rate, r.Capacity, r.N, r.Errors, r.P50, r.P99, r.Max)
+}
+
+// Run calibrates and loads the server behind dial, and returns what it
+// measured. It skips tb when budgets are turned off.
+func Run(tb testing.TB, dial Dialer, load Load) *Result {
+	tb.Helper()
+	if testing.Short() || os.Getenv("LATENCY_BUDGET") == "off" {
+		tb.Skip("latency budgets are off (-short or LATENCY_BUDGET=off)")
+	}
+	if load.Conns <= 0 {
+		load.Conns = 16
+	}
+	if load.Calibrate <= 0 {
+		load.Calibrate = 300 * time.Millisecond
+	}
+	if load.Duration <= 0 {
+		load.Duration = time.Second
+	}
+	if load.Utilization <= 0 {
+		load.Utilization = 0.5
+	}
+
+	sessions := make([]Session, load.Conns)
+	for i := range sessions {
+		s, err := dial()
+		if err != nil {
+			tb.Fatalf("budget: dial: %v", err)
+		}
+		defer s.Close()
+		sessions[i] = s
+	}
+
+	// Calibrate: every connection sends its next request as soon as the
+	// last reply arrives.
+	var mu sync.Mutex
+	var done int
+	ctx, cancel := context.WithTimeout(context.Background(), load.Calibrate)
+	start := time.Now()
+	each(sessions, func(_ int, s Session) {
+		n := 0
+		for ctx.Err() == nil {
+			if err := s.RoundTrip(); err != nil {
+				tb.Errorf("budget: calibration: %v", err)
+				break
+			}
+			n++
+		}
+		mu.Lock()
+		done += n
+		mu.Unlock()
+	})
+	cancel()
+	if done == 0 {
+		tb.Fatal("budget: no round trips completed during calibration")
+	}
+	res := &Result{Capacity: float64(done) / time.Since(start).Seconds()}
+
+	// Measure: each connection sends on a fixed schedule, staggered
+	// against the others, and a round trip counts from when it was due,
+	// so a server that falls behind cannot slow its own load down.
+	res.Rate = res.Capacity * load.Utilization
+	interval := time.Duration(float64(load.Conns) / res.Rate * float64(time.Second))
+	start = time.Now()
+	end := start.Add(load.Duration)
+	var samples []time.Duration
+	each(sessions, func(i int, s Session) {
+		var lat []time.Duration
+		errs := 0
+		due := start.Add(interval * time.Duration(i) / time.Duration(load.Conns))
+		for ; due.Before(end); due = due.Add(interval) {
+			time.Sleep(time.Until(due))
+			if err := s.RoundTrip(); err != nil {
+				errs++
+				break
+			}
+			lat = append(lat, time.Since(due))
+		}
+		mu.Lock()
+		samples = append(samples, lat...)
+		res.Errors += errs
+		mu.Unlock()
+	})
+
+	res.N = len(samples)
+	if res.N > 0 {
+		slices.Sort(samples)
+		res.P50 = samples[(res.N-1)/2]
+		res.P99 = samples[(res.N-1)*99/100]
+		res.Max = samples[res.N-1]
+	}
+	return res
+}
+
+// each runs f on every session concurrently and waits for them all.
+func each(sessions []Session, f func(int, Session)) {
+	var wg sync.WaitGroup
+	for i, s := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f(i, s)
+		}()
+	}
+	wg.Wait()
+}
+
+// Check fails tb if res has errors or its p99 exceeds p99, scaled for the
+// environment, and logs res either way. The p99 is only held to the
+// budget where Enforced says so.
+func Check(tb testing.TB, res *Result, p99 time.Duration) {
+	tb.Helper()
+	limit := time.Duration(float64(p99) * Scale())
+	tb.Logf("budget: %v (p99 budget %v)", res, limit)
+	if res.Errors > 0 || res.N == 0 {
+		tb.Errorf("budget: %d of %d round trips failed", res.Errors, res.N+res.Errors)
+	}
+	if res.P99 <= limit {
+		return
+	}
+	if !Enforced() {
+		tb.Logf("budget: p99 %v exceeds %v, not enforced with GOMAXPROCS=%d", res.P99, limit, runtime.GOMAXPROCS(0))
+		return
+	}
+	tb.Errorf("budget: p99 %v exceeds %v", res.P99, limit)
+}
+
+// Enforced reports whether Check holds the p99 to its budget: with two
+// CPUs or more, or when LATENCY_BUDGET=on.
+func Enforced() bool {
+	return runtime.GOMAXPROCS(0) >= 2 || os.Getenv("LATENCY_BUDGET") == "on"
+}
+
+// Scale returns the factor applied to budgets: LATENCY_BUDGET_SCALE,
+// times 3 under the race detector.
+func Scale() float64 {
+	s := 1.0
+	if v, err := strconv.ParseFloat(os.Getenv("LATENCY_BUDGET_SCALE"), 64); err == nil && v > 0 {
+		s = v
+	}
+	if raceEnabled {
+		s *= 3
+	}
+	return s
+}
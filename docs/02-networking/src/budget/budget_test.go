@@ -0,0 +1,135 @@
+package budget
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// lineEcho serves newline-delimited echo on a loopback listener for the
+// duration of the test.
+func lineEcho(tb testing.TB, delay time.Duration) string {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadSlice('\n')
+					if err != nil {
+						return
+					}
+					time.Sleep(delay)
+					if _, err := conn.Write(line); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestRunLine(t *testing.T) {
+	addr := lineEcho(t, 0)
+	res := Run(t, Line(addr, 64), Load{Conns: 4, Calibrate: 100 * time.Millisecond, Duration: 200 * time.Millisecond})
+	if res.Capacity <= 0 || res.Rate != res.Capacity/2 {
+		t.Fatalf("capacity %v, rate %v: want rate at half capacity", res.Capacity, res.Rate)
+	}
+	if res.N == 0 || res.P50 > res.P99 || res.P99 > res.Max {
+		t.Fatalf("inconsistent result %v", res)
+	}
+	Check(t, res, time.Second)
+}
+
+// TestRunMeasuresFromDue checks that latency includes the time a request
+// waited behind a slow server, not only its own service time: one
+// connection to a server that takes 5ms per reply, offered at full
+// capacity, falls behind its schedule.
+func TestRunMeasuresFromDue(t *testing.T) {
+	addr := lineEcho(t, 5*time.Millisecond)
+	res := Run(t, Line(addr, 8), Load{Conns: 1, Calibrate: 50 * time.Millisecond, Duration: 200 * time.Millisecond, Utilization: 2})
+	if res.Max < 20*time.Millisecond {
+		t.Fatalf("max %v at twice capacity: the backlog was not counted", res.Max)
+	}
+}
+
+func TestRunHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	res := Run(t, HTTP(srv.URL), Load{Conns: 4, Calibrate: 100 * time.Millisecond, Duration: 200 * time.Millisecond})
+	Check(t, res, time.Second)
+}
+
+// TestCheckEnforced checks that a p99 over budget fails the test only
+// where budgets are enforced, and that errors fail it everywhere.
+func TestCheckEnforced(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	slow := &Result{N: 100, P99: time.Second}
+	broken := &Result{N: 99, Errors: 1}
+	for _, tt := range []struct {
+		procs int
+		env   string
+		res   *Result
+		fail  bool
+	}{
+		{1, "", slow, false},
+		{1, "on", slow, true},
+		{2, "", slow, true},
+		{1, "", broken, true},
+	} {
+		runtime.GOMAXPROCS(tt.procs)
+		t.Setenv("LATENCY_BUDGET", tt.env)
+		rec := &recorder{TB: t}
+		Check(rec, tt.res, time.Millisecond)
+		if rec.failed != tt.fail {
+			t.Errorf("GOMAXPROCS=%d LATENCY_BUDGET=%q %v: failed %v, want %v", tt.procs, tt.env, tt.res, rec.failed, tt.fail)
+		}
+	}
+}
+
+// recorder notes a failure instead of failing the test it wraps.
+type recorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recorder) Errorf(format string, args ...any) { r.failed = true }
+
+func TestScale(t *testing.T) {
+	race := 1.0
+	if raceEnabled {
+		race = 3
+	}
+	for _, tt := range []struct {
+		env  string
+		want float64
+	}{
+		{"", 1},
+		{"4", 4},
+		{"0.5", 0.5},
+		{"-1", 1},
+		{"x", 1},
+	} {
+		t.Setenv("LATENCY_BUDGET_SCALE", tt.env)
+		if got := Scale(); got != tt.want*race {
+			t.Errorf("LATENCY_BUDGET_SCALE=%q: Scale() = %v, want %v", tt.env, got, tt.want*race)
+		}
+	}
+}
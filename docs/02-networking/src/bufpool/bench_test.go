@@ -0,0 +1,209 @@
+package bufpool
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+var sizes = []int{64, 1 << 10, 4 << 10, 64 << 10, 1 << 20}
+
+// sink keeps the compiler from eliding buffers in the make baseline.
+var sink []byte
+
+// BenchmarkGetPut is the single-goroutine cost of getting, touching and
+// returning one buffer, per strategy and size.
+func BenchmarkGetPut(b *testing.B) {
+	for _, size := range sizes {
+		b.Run(fmt.Sprintf("size=%d/make", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				buf := make([]byte, size)
+				buf[0] = 1
+				sink = buf
+			}
+		})
+		b.Run(fmt.Sprintf("size=%d/syncpool-slice", size), func(b *testing.B) {
+			p := sync.Pool{New: func() any { return make([]byte, size) }}
+			b.ReportAllocs()
+			for b.Loop() {
+				buf := p.Get().([]byte)
+				buf[0] = 1
+				p.Put(buf) // boxes the slice header: one allocation per Put
+			}
+		})
+		b.Run(fmt.Sprintf("size=%d/pool", size), func(b *testing.B) {
+			p := New(64, 1<<20)
+			b.ReportAllocs()
+			for b.Loop() {
+				buf := p.Get(size)
+				(*buf)[0] = 1
+				p.Put(buf)
+			}
+		})
+		b.Run(fmt.Sprintf("size=%d/freelist", size), func(b *testing.B) {
+			f := NewFreelist(size, 16)
+			b.ReportAllocs()
+			for b.Loop() {
+				buf := f.Get()
+				(*buf)[0] = 1
+				f.Put(buf)
+			}
+		})
+	}
+}
+
+// BenchmarkBurst takes 256 buffers at once, returns them and then lets gcs
+// GC cycles pass before the next burst, as a server does between traffic
+// spikes. sync.Pool moves idle buffers to its victim cache at the first GC
+// and frees them at the second; the freelist keeps them.
+func BenchmarkBurst(b *testing.B) {
+	const burst, size = 256, 16 << 10
+	for _, gcs := range []int{0, 1, 2} {
+		run := func(b *testing.B, get func() *[]byte, put func(*[]byte), misses func() uint64) {
+			bufs := make([]*[]byte, burst)
+			cycle := func() {
+				for i := range bufs {
+					bufs[i] = get()
+				}
+				for _, buf := range bufs {
+					put(buf)
+				}
+				for range gcs {
+					runtime.GC()
+				}
+			}
+			cycle() // warm up so only losses to GC count as misses
+			start := misses()
+			for b.Loop() {
+				cycle()
+			}
+			b.ReportMetric(100*(1-float64(misses()-start)/float64(b.N*burst)), "hit%")
+		}
+		b.Run(fmt.Sprintf("gcs=%d/pool", gcs), func(b *testing.B) {
+			p := New(size, size)
+			run(b, func() *[]byte { return p.Get(size) }, p.Put, p.Misses)
+		})
+		b.Run(fmt.Sprintf("gcs=%d/freelist", gcs), func(b *testing.B) {
+			f := NewFreelist(size, burst)
+			run(b, f.Get, f.Put, f.Misses)
+		})
+	}
+}
+
+// BenchmarkHandoff gets a buffer on one goroutine and puts it back on
+// another, as a reader that hands a message to a writer goroutine does.
+// sync.Pool caches per P, so with GOMAXPROCS > 1 the getter keeps finding
+// its own P empty and steals from the putter's. Run with -cpu 1,4.
+func BenchmarkHandoff(b *testing.B) {
+	const size = 4 << 10
+	run := func(b *testing.B, get func() *[]byte, put func(*[]byte), misses func() uint64) {
+		ch := make(chan *[]byte, 128)
+		done := make(chan struct{})
+		go func() {
+			for buf := range ch {
+				put(buf)
+			}
+			close(done)
+		}()
+		start := misses()
+		for b.Loop() {
+			buf := get()
+			(*buf)[0] = 1
+			ch <- buf
+		}
+		close(ch)
+		<-done
+		b.ReportMetric(100*(1-float64(misses()-start)/float64(b.N)), "hit%")
+	}
+	b.Run("pool", func(b *testing.B) {
+		p := New(size, size)
+		run(b, func() *[]byte { return p.Get(size) }, p.Put, p.Misses)
+	})
+	b.Run("freelist", func(b *testing.B) {
+		f := NewFreelist(size, 256)
+		run(b, f.Get, f.Put, f.Misses)
+	})
+}
+
+// BenchmarkParallel is the contended case: every goroutine gets and puts
+// its own buffers. sync.Pool stays P-local; the freelist shares one mutex.
+func BenchmarkParallel(b *testing.B) {
+	const size = 4 << 10
+	b.Run("pool", func(b *testing.B) {
+		p := New(size, size)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				buf := p.Get(size)
+				(*buf)[0] = 1
+				p.Put(buf)
+			}
+		})
+	})
+	b.Run("freelist", func(b *testing.B) {
+		f := NewFreelist(size, 256)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				buf := f.Get()
+				(*buf)[0] = 1
+				f.Put(buf)
+			}
+		})
+	})
+}
+
+// BenchmarkReadBuffers serves one read per iteration on one of 10,000
+// connections in turn, the way an event loop does, with each way of
+// giving the read a buffer. It reports the heap still in use after the
+// last read: the memory that idle connections hold between events.
+func BenchmarkReadBuffers(b *testing.B) {
+	const conns, size = 10_000, 4 << 10
+	msg := make([]byte, 64)
+	type conn struct{ buf *[]byte }
+	run := func(b *testing.B, get func() *[]byte, put func(*[]byte)) {
+		cs := make([]conn, conns)
+		var before runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		b.ReportAllocs()
+		i := 0
+		for b.Loop() {
+			c := &cs[i%conns]
+			if c.buf == nil {
+				c.buf = get()
+			}
+			n := copy(*c.buf, msg) // the read
+			sink = (*c.buf)[:n]    // the echo
+			if put != nil {
+				put(c.buf)
+				c.buf = nil
+			}
+			i++
+		}
+		var after runtime.MemStats
+		sink = nil
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(int64(after.HeapInuse)-int64(before.HeapInuse))/(1<<20), "held-MiB")
+		runtime.KeepAlive(cs)
+	}
+	b.Run("shared", func(b *testing.B) {
+		shared := make([]byte, size)
+		run(b, func() *[]byte { return &shared }, func(*[]byte) {})
+	})
+	b.Run("make", func(b *testing.B) {
+		run(b, func() *[]byte { buf := make([]byte, size); return &buf }, func(*[]byte) {})
+	})
+	b.Run("conn", func(b *testing.B) {
+		run(b, func() *[]byte { buf := make([]byte, size); return &buf }, nil)
+	})
+	b.Run("pool", func(b *testing.B) {
+		p := New(size, size)
+		run(b, func() *[]byte { return p.Get(size) }, p.Put)
+	})
+	b.Run("freelist", func(b *testing.B) {
+		f := NewFreelist(size, 512)
+		run(b, f.Get, f.Put)
+	})
+}
@@ -0,0 +1,86 @@
+// Package bufpool recycles byte buffers for the network examples.
+//
+// Pool is a set of sync.Pools, one per power-of-two size class. It suits
+// buffers that live for one request or one read: sync.Pool keeps a private
+// slot per P, so Get and Put on the same goroutine never contend, and idle
+// buffers are released after two GC cycles. Buffers are handed out as
+// *[]byte because storing a slice header in a sync.Pool allocates on every
+// Put.
+//
+// Freelist holds a bounded number of fixed-size buffers that survive GC. It
+// suits owners that need a buffer back after long idle periods (connections
+// that wake rarely) and that would otherwise miss in a Pool emptied by GC.
+//
+// BenchmarkBurst, BenchmarkHandoff and BenchmarkGetPut in this package
+// measure the trade-offs.
+package bufpool
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/pow2"
+)
+
+// Pool hands out buffers in power-of-two size classes between a minimum
+// and a maximum size. The zero value is not usable; use New.
+type Pool struct {
+	minShift int
+	classes  []sync.Pool
+	misses   atomic.Uint64
+}
+
+// New returns a Pool with classes from min to max bytes, both rounded up to
+// a power of two.
+func New(min, max int) *Pool {
+	lo, hi := pow2.Log2Ceil(min), pow2.Log2Ceil(max)
+	p := &Pool{minShift: lo, classes: make([]sync.Pool, hi-lo+1)}
+	for i := range p.classes {
+		size := 1 << (lo + i)
+		p.classes[i].New = func() any {
+			p.misses.Add(1)
+			b := make([]byte, size)
+			return &b
+		}
+	}
+	return p
+}
+
+// class returns the index of the smallest class that holds n bytes, or -1
+// if n is larger than the largest class.
+func (p *Pool) class(n int) int {
+	i := max(pow2.Log2Ceil(n)-p.minShift, 0)
+	if i >= len(p.classes) {
+		return -1
+	}
+	return i
+}
+
+// Get returns a buffer of length n. Its capacity is the size class, so it
+// can be resliced up to cap without allocating. Sizes beyond the largest
+// class are allocated directly and dropped by Put.
+func (p *Pool) Get(n int) *[]byte {
+	i := p.class(n)
+	if i < 0 {
+		p.misses.Add(1)
+		b := make([]byte, n)
+		return &b
+	}
+	b := p.classes[i].Get().(*[]byte)
+	*b = (*b)[:n]
+	return b
+}
+
+// Put returns b to its size class. Buffers whose capacity is not exactly a
+// class size, such as ones that were grown by append, are dropped.
+func (p *Pool) Put(b *[]byte) {
+	c := cap(*b)
+	i := p.class(c)
+	if i < 0 || c != 1<<(p.minShift+i) {
+		return
+	}
+	p.classes[i].Put(b)
+}
+
+// Misses returns how many Gets had to allocate.
+func (p *Pool) Misses() uint64 { return p.misses.Load() }
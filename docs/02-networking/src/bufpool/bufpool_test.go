@@ -0,0 +1,144 @@
+package bufpool
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestPoolClasses(t *testing.T) {
+	p := New(100, 5000)
+	for _, tt := range []struct{ n, cap int }{
+		{0, 128}, {1, 128}, {128, 128}, {129, 256}, {4096, 4096}, {8192, 8192}, {8193, 8193},
+	} {
+		b := p.Get(tt.n)
+		if len(*b) != tt.n || cap(*b) != tt.cap {
+			t.Errorf("Get(%d): len %d cap %d, want len %d cap %d", tt.n, len(*b), cap(*b), tt.n, tt.cap)
+		}
+		p.Put(b)
+	}
+}
+
+func TestPoolDropsForeignBuffers(t *testing.T) {
+	p := New(64, 1024)
+	b := p.Get(64)
+	*b = append(*b, make([]byte, 100)...) // grown past its class
+	p.Put(b)
+	odd := make([]byte, 100)
+	p.Put(&odd)
+
+	before := p.Misses()
+	for range 3 {
+		if got := p.Get(100); cap(*got) != 128 {
+			t.Fatalf("Get(100) returned cap %d, want 128", cap(*got))
+		}
+	}
+	if p.Misses() == before {
+		t.Fatal("foreign buffers were pooled")
+	}
+}
+
+func TestFreelist(t *testing.T) {
+	f := NewFreelist(512, 2)
+	a, b, c := f.Get(), f.Get(), f.Get()
+	if f.Misses() != 3 {
+		t.Fatalf("Misses = %d, want 3", f.Misses())
+	}
+	*a = (*a)[:10]
+	f.Put(a)
+	f.Put(b)
+	f.Put(c)
+	if f.Len() != 2 {
+		t.Fatalf("Len = %d, want 2 (bounded)", f.Len())
+	}
+	if got := f.Get(); got != b || len(*got) != 512 {
+		t.Fatalf("Get did not return the last freed full-length buffer")
+	}
+	if got := f.Get(); got != a || len(*got) != 512 {
+		t.Fatalf("Get did not restore the length of a truncated buffer")
+	}
+	if f.Misses() != 3 {
+		t.Fatalf("Misses = %d after reuse, want 3", f.Misses())
+	}
+}
+
+// TestFreelistModel runs random Gets and Puts against a model stack of
+// idle buffers: Get returns the top one or allocates, and Put keeps a
+// buffer of the right capacity while there is room.
+func TestFreelistModel(t *testing.T) {
+	for seed := range uint64(100) {
+		rnd := rand.New(rand.NewPCG(seed, 1))
+		f := NewFreelist(64, rnd.IntN(5))
+		var idle, held []*[]byte
+		misses := uint64(0)
+		for step := range 300 {
+			if rnd.IntN(2) == 0 || len(held) == 0 {
+				b := f.Get()
+				if n := len(idle); n > 0 {
+					if b != idle[n-1] {
+						t.Fatalf("seed %d step %d: Get returned %p, want the last put %p", seed, step, b, idle[n-1])
+					}
+					idle = idle[:n-1]
+				} else {
+					misses++
+				}
+				if len(*b) != 64 {
+					t.Fatalf("seed %d step %d: Get returned %d bytes", seed, step, len(*b))
+				}
+				held = append(held, b)
+				continue
+			}
+			i := rnd.IntN(len(held))
+			b := held[i]
+			held = append(held[:i], held[i+1:]...)
+			switch rnd.IntN(4) {
+			case 0:
+				*b = (*b)[:rnd.IntN(64)] // shortened: kept, at full length again
+			case 1:
+				nb := make([]byte, 128) // replaced by a bigger one: dropped
+				b = &nb
+			}
+			f.Put(b)
+			if cap(*b) == 64 && len(idle) < f.max {
+				idle = append(idle, b)
+			}
+			if f.Len() != len(idle) || f.Misses() != misses {
+				t.Fatalf("seed %d step %d: Len %d, Misses %d; want %d and %d", seed, step, f.Len(), f.Misses(), len(idle), misses)
+			}
+		}
+	}
+}
+
+// TestFreelistExclusive has goroutines take buffers, stamp them, and check
+// the stamp before giving them back. A buffer handed to two goroutines at
+// once shows up as a changed stamp, and as a race under -race.
+func TestFreelistExclusive(t *testing.T) {
+	f := NewFreelist(8, 4)
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 2000 {
+				b := f.Get()
+				stamp := byte(g<<5 | i&31)
+				for j := range *b {
+					(*b)[j] = stamp
+				}
+				runtime.Gosched()
+				for j, v := range *b {
+					if v != stamp {
+						t.Errorf("goroutine %d: byte %d is %d, want its stamp %d", g, j, v, stamp)
+						return
+					}
+				}
+				f.Put(b)
+			}
+		}()
+	}
+	wg.Wait()
+	if f.Len() > 4 {
+		t.Errorf("Len = %d, above the max of 4", f.Len())
+	}
+}
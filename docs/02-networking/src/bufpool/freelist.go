@@ -0,0 +1,62 @@
+package bufpool
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Freelist keeps up to a fixed number of equal-sized buffers. Unlike Pool it
+// never releases them, so the memory it holds is the high-water mark of
+// concurrent use, capped at max buffers.
+type Freelist struct {
+	size   int
+	max    int
+	mu     sync.Mutex
+	free   []*[]byte
+	misses atomic.Uint64
+}
+
+// NewFreelist returns a Freelist of size-byte buffers that retains at most
+// max of them.
+func NewFreelist(size, max int) *Freelist {
+	return &Freelist{size: size, max: max, free: make([]*[]byte, 0, max)}
+}
+
+// Get returns a buffer of length size, the most recently freed one if any.
+func (f *Freelist) Get() *[]byte {
+	f.mu.Lock()
+	if n := len(f.free); n > 0 {
+		b := f.free[n-1]
+		f.free = f.free[:n-1]
+		f.mu.Unlock()
+		return b
+	}
+	f.mu.Unlock()
+	f.misses.Add(1)
+	b := make([]byte, f.size)
+	return &b
+}
+
+// Put returns b to the list, or drops it when the list is full or b was
+// resized.
+func (f *Freelist) Put(b *[]byte) {
+	if cap(*b) != f.size {
+		return
+	}
+	*b = (*b)[:f.size]
+	f.mu.Lock()
+	if len(f.free) < f.max {
+		f.free = append(f.free, b)
+	}
+	f.mu.Unlock()
+}
+
+// Len returns the number of idle buffers held.
+func (f *Freelist) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.free)
+}
+
+// Misses returns how many Gets had to allocate.
+func (f *Freelist) Misses() uint64 { return f.misses.Load() }
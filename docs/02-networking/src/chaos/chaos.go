@@ -0,0 +1,263 @@
+// Package chaos injects faults into the example servers at run time, so
+// resilience and tail-latency claims can be checked against a misbehaving
+// peer without external tooling.
+//
+// An Injector wraps accepted connections and is driven over HTTP from the
+// servers' control address:
+//
+//	GET    /chaos  current Config and fault counters as JSON
+//	POST   /chaos  update the fields given as query parameters
+//	DELETE /chaos  stop injecting
+//
+// For example, to delay 1% of reads by up to 50ms and reset one connection
+// in a thousand:
+//
+//	curl -X POST 'localhost:9100/chaos?read_delay_p=0.01&read_delay=50ms&reset_p=0.001'
+//
+// or to flip one bit in one read of ten thousand, the corruption TCP's
+// checksum misses, which the codec's length-crc framing is there to catch:
+//
+//	curl -X POST 'localhost:9100/chaos?flip_bit_p=0.0001'
+//
+// Faults apply to connections accepted before and after the change. A
+// zero Config injects nothing and costs one atomic load per Read and Write.
+package chaos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrReset is returned by a Read that reset the connection.
+var ErrReset = errors.New("chaos: connection reset")
+
+// Config selects the faults to inject. Probabilities are per call.
+type Config struct {
+	ReadDelayP float64       // fraction of Reads delayed before reading
+	ReadDelay  time.Duration // delays are uniform in [0, ReadDelay)
+	DropWriteP float64       // fraction of Writes reported written but discarded
+	ResetP     float64       // fraction of Reads that reset the connection instead
+	FlipBitP   float64       // fraction of Reads that flip one random bit of what they read
+	GCEvery    time.Duration // force a GC this often (0 disables)
+}
+
+// Counters count the faults injected so far.
+type Counters struct {
+	Delayed int64 `json:"delayed"`
+	Dropped int64 `json:"dropped"`
+	Resets  int64 `json:"resets"`
+	Flipped int64 `json:"flipped"`
+	GCs     int64 `json:"gcs"`
+}
+
+// Injector applies the current Config to the connections it wraps.
+type Injector struct {
+	cfg atomic.Pointer[Config]
+
+	delayed, dropped, resets, flipped, gcs atomic.Int64
+
+	mu     sync.Mutex
+	stopGC chan struct{} // closes to stop the running GC loop
+}
+
+// New returns an Injector that injects nothing until configured.
+func New() *Injector {
+	in := &Injector{}
+	in.cfg.Store(&Config{})
+	return in
+}
+
+// Config returns the faults currently injected.
+func (in *Injector) Config() Config { return *in.cfg.Load() }
+
+// Set replaces the Config. Connections pick it up on their next call.
+func (in *Injector) Set(c Config) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	old := in.cfg.Swap(&c)
+	if old.GCEvery == c.GCEvery {
+		return
+	}
+	if in.stopGC != nil {
+		close(in.stopGC)
+		in.stopGC = nil
+	}
+	if c.GCEvery > 0 {
+		in.stopGC = make(chan struct{})
+		go in.forceGC(c.GCEvery, in.stopGC)
+	}
+}
+
+func (in *Injector) forceGC(every time.Duration, stop <-chan struct{}) {
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+			runtime.GC()
+			in.gcs.Add(1)
+		}
+	}
+}
+
+// Counters returns how many faults have been injected.
+func (in *Injector) Counters() Counters {
+	return Counters{Delayed: in.delayed.Load(), Dropped: in.dropped.Load(), Resets: in.resets.Load(), Flipped: in.flipped.Load(), GCs: in.gcs.Load()}
+}
+
+// Conn wraps c so that Reads and Writes are subject to the Config.
+func (in *Injector) Conn(c net.Conn) net.Conn { return &conn{Conn: c, in: in} }
+
+// Listen wraps every connection ln accepts.
+func (in *Injector) Listen(ln net.Listener) net.Listener { return &listener{Listener: ln, in: in} }
+
+type listener struct {
+	net.Listener
+	in *Injector
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.in.Conn(c), nil
+}
+
+type conn struct {
+	net.Conn
+	in *Injector
+}
+
+// hit reports whether an event with probability p happens.
+func hit(p float64) bool { return p > 0 && rand.Float64() < p }
+
+func (c *conn) Read(p []byte) (int, error) {
+	cfg := c.in.cfg.Load()
+	if hit(cfg.ResetP) {
+		c.in.resets.Add(1)
+		c.reset()
+		return 0, ErrReset
+	}
+	if cfg.ReadDelay > 0 && hit(cfg.ReadDelayP) {
+		c.in.delayed.Add(1)
+		time.Sleep(rand.N(cfg.ReadDelay))
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 && hit(cfg.FlipBitP) {
+		c.in.flipped.Add(1)
+		p[rand.IntN(n)] ^= 1 << rand.IntN(8)
+	}
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	if hit(c.in.cfg.Load().DropWriteP) {
+		c.in.dropped.Add(1)
+		return len(p), nil
+	}
+	return c.Conn.Write(p)
+}
+
+// reset closes the connection with an RST instead of a FIN: with a zero
+// linger time the kernel discards unsent data and aborts.
+func (c *conn) reset() {
+	if tc, ok := c.Conn.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	c.Conn.Close()
+}
+
+// status is the JSON view of the Injector. Durations are strings, as they
+// are given in query parameters.
+type status struct {
+	ReadDelayP float64  `json:"read_delay_p"`
+	ReadDelay  string   `json:"read_delay"`
+	DropWriteP float64  `json:"drop_write_p"`
+	ResetP     float64  `json:"reset_p"`
+	FlipBitP   float64  `json:"flip_bit_p"`
+	GCEvery    string   `json:"gc_every"`
+	Injected   Counters `json:"injected"`
+}
+
+// ServeHTTP implements the control protocol described in the package
+// documentation.
+func (in *Injector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		cfg, err := update(in.Config(), r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		in.Set(cfg)
+	case http.MethodDelete:
+		in.Set(Config{})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := in.Config()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status{
+		ReadDelayP: cfg.ReadDelayP,
+		ReadDelay:  cfg.ReadDelay.String(),
+		DropWriteP: cfg.DropWriteP,
+		ResetP:     cfg.ResetP,
+		FlipBitP:   cfg.FlipBitP,
+		GCEvery:    cfg.GCEvery.String(),
+		Injected:   in.Counters(),
+	})
+}
+
+// update applies the query parameters of r to cfg. Unknown parameters are
+// rejected so that a typo does not silently inject nothing.
+func update(cfg Config, r *http.Request) (Config, error) {
+	for key, vals := range r.URL.Query() {
+		v := vals[len(vals)-1]
+		var err error
+		switch key {
+		case "read_delay_p":
+			cfg.ReadDelayP, err = probability(v)
+		case "read_delay":
+			cfg.ReadDelay, err = time.ParseDuration(v)
+		case "drop_write_p":
+			cfg.DropWriteP, err = probability(v)
+		case "reset_p":
+			cfg.ResetP, err = probability(v)
+		case "flip_bit_p":
+			cfg.FlipBitP, err = probability(v)
+		case "gc_every":
+			cfg.GCEvery, err = time.ParseDuration(v)
+		default:
+			err = errors.New("unknown parameter")
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("%s=%q: %w", key, v, err)
+		}
+	}
+	if cfg.ReadDelay < 0 || cfg.GCEvery < 0 {
+		return cfg, errors.New("durations must not be negative")
+	}
+	return cfg, nil
+}
+
+func probability(s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err == nil && (p < 0 || p > 1) {
+		err = errors.New("not in [0, 1]")
+	}
+	return p, err
+}
@@ -0,0 +1,198 @@
+package chaos
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math/bits"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// pair returns a client connection and the server side of it wrapped by in.
+func pair(t *testing.T, in *Injector) (client, server net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = in.Listen(ln).Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestPassThrough(t *testing.T) {
+	in := New()
+	client, server := pair(t, in)
+	go client.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+	if _, err := server.Write([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+	if c := in.Counters(); c != (Counters{}) {
+		t.Errorf("counters %+v with no faults configured", c)
+	}
+}
+
+func TestDropWrite(t *testing.T) {
+	in := New()
+	in.Set(Config{DropWriteP: 1})
+	client, server := pair(t, in)
+	if n, err := server.Write([]byte("lost")); n != 4 || err != nil {
+		t.Fatalf("dropped write returned %d, %v; want 4, nil", n, err)
+	}
+	client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, err := client.Read(make([]byte, 4)); !isTimeout(err) {
+		t.Fatalf("client read %d bytes, %v; want a timeout", n, err)
+	}
+	if c := in.Counters(); c.Dropped != 1 {
+		t.Errorf("dropped %d, want 1", c.Dropped)
+	}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+func TestReset(t *testing.T) {
+	in := New()
+	in.Set(Config{ResetP: 1})
+	client, server := pair(t, in)
+	if _, err := client.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Read(make([]byte, 1)); !errors.Is(err, ErrReset) {
+		t.Fatalf("server read error %v, want ErrReset", err)
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := client.Read(make([]byte, 1))
+	if runtime.GOOS == "linux" && !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("client read error %v, want ECONNRESET", err)
+	}
+	if c := in.Counters(); c.Resets != 1 {
+		t.Errorf("resets %d, want 1", c.Resets)
+	}
+}
+
+func TestReadDelay(t *testing.T) {
+	in := New()
+	in.Set(Config{ReadDelayP: 1, ReadDelay: time.Millisecond})
+	client, server := pair(t, in)
+	go client.Write([]byte("abc"))
+	for range 3 {
+		if _, err := server.Read(make([]byte, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c := in.Counters(); c.Delayed != 3 {
+		t.Errorf("delayed %d, want 3", c.Delayed)
+	}
+}
+
+func TestFlipBit(t *testing.T) {
+	in := New()
+	in.Set(Config{FlipBitP: 1})
+	client, server := pair(t, in)
+	sent := []byte("abcdefgh")
+	go client.Write(sent)
+	got := make([]byte, len(sent))
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatal(err)
+	}
+	flipped := 0
+	for i := range got {
+		flipped += bits.OnesCount8(got[i] ^ sent[i])
+	}
+	// One bit per Read, and ReadFull may have taken more than one.
+	if c := in.Counters(); flipped == 0 || int64(flipped) > c.Flipped {
+		t.Errorf("%d bits differ in %q after %d flips", flipped, got, c.Flipped)
+	}
+}
+
+func TestGCEvery(t *testing.T) {
+	in := New()
+	in.Set(Config{GCEvery: 2 * time.Millisecond})
+	deadline := time.Now().Add(5 * time.Second)
+	for in.Counters().GCs < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if in.Counters().GCs < 2 {
+		t.Fatal("no forced GCs")
+	}
+	in.Set(Config{})
+	time.Sleep(5 * time.Millisecond) // let a GC that was already due finish
+	n := in.Counters().GCs
+	time.Sleep(20 * time.Millisecond)
+	if got := in.Counters().GCs; got != n {
+		t.Errorf("%d GCs after disabling, want 0", got-n)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	in := New()
+	srv := httptest.NewServer(in)
+	defer srv.Close()
+
+	do := func(method, query string) (int, status) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+"/chaos"+query, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var st status
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, st
+	}
+
+	code, st := do(http.MethodPost, "?read_delay_p=0.25&read_delay=50ms&reset_p=0.001")
+	if code != http.StatusOK || st.ReadDelayP != 0.25 || st.ReadDelay != "50ms" || st.ResetP != 0.001 {
+		t.Fatalf("POST: %d %+v", code, st)
+	}
+	// Fields not given are kept.
+	if _, st = do(http.MethodPost, "?drop_write_p=0.5"); st.ReadDelayP != 0.25 || st.DropWriteP != 0.5 {
+		t.Errorf("second POST: %+v", st)
+	}
+	if _, st = do(http.MethodPost, "?flip_bit_p=0.0001"); st.FlipBitP != 0.0001 {
+		t.Errorf("flip_bit_p: %+v", st)
+	}
+	for _, bad := range []string{"?reset_p=2", "?flip_bit_p=-1", "?read_delay=fast", "?resetp=0.1", "?gc_every=-1s"} {
+		if code, _ := do(http.MethodPost, bad); code != http.StatusBadRequest {
+			t.Errorf("POST %s: status %d, want 400", bad, code)
+		}
+	}
+	if _, st = do(http.MethodGet, ""); st.DropWriteP != 0.5 {
+		t.Errorf("GET after rejected POSTs: %+v", st)
+	}
+	if _, st = do(http.MethodDelete, ""); in.Config() != (Config{}) || st.ReadDelay != "0s" {
+		t.Errorf("DELETE left %+v", in.Config())
+	}
+}
@@ -0,0 +1,68 @@
+// Package clock is the time source of the timeout logic in these
+// examples: the timing wheel, the read-guard reaper, the rate limiters
+// and the connection manager's pings.
+//
+// Code that reads the time or waits for it through a Clock can be tested
+// with a Fake, which stands still until the test advances it. A test then
+// says "30 seconds pass" instead of sleeping, and a timeout fires exactly
+// when the test decides, however loaded the machine is:
+//
+//	clk := clock.NewFake(time.Unix(0, 0))
+//	go reaper.Run(ctx, time.Second) // reaper built with Policy{Clock: clk}
+//	clk.BlockUntil(1)               // wait for Run to start its ticker
+//	clk.Advance(time.Second)        // one tick, delivered at once
+//
+// Real is the time package itself and costs an interface call.
+package clock
+
+import "time"
+
+// Clock tells the time and makes timers and tickers that follow it.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a time.Timer behind an interface, with the channel as a method.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a time.Ticker behind an interface, with the channel as a
+// method.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+// Or returns c, or Real if c is nil, so that a nil Clock in a config
+// means the system clock.
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                   { return time.Now() }
+func (realClock) NewTimer(d time.Duration) Timer   { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
@@ -0,0 +1,98 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var t0 = time.Unix(1000, 0)
+
+func fired(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-c:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	f := NewFake(t0)
+	tm := f.NewTimer(10 * time.Second)
+	f.Advance(9 * time.Second)
+	if _, ok := fired(tm.C()); ok {
+		t.Fatal("timer fired a second early")
+	}
+	f.Advance(5 * time.Second)
+	if at, ok := fired(tm.C()); !ok || !at.Equal(t0.Add(10*time.Second)) {
+		t.Fatalf("timer: %v, %v; want it fired at its expiry", at, ok)
+	}
+	if got := f.Now(); !got.Equal(t0.Add(14 * time.Second)) {
+		t.Errorf("Now %v after advancing 14s", got)
+	}
+	if tm.Stop() || f.Pending() != 0 {
+		t.Error("a fired timer is still pending")
+	}
+	if tm.Reset(time.Second) {
+		t.Error("Reset of a fired timer reported it pending")
+	}
+	if !tm.Stop() {
+		t.Error("Stop of a reset timer reported it not pending")
+	}
+	f.Advance(time.Minute)
+	if _, ok := fired(tm.C()); ok {
+		t.Error("stopped timer fired")
+	}
+	if _, ok := fired(f.NewTimer(0).C()); !ok {
+		t.Error("a timer for 0 did not fire at once")
+	}
+}
+
+func TestFakeTickerOrder(t *testing.T) {
+	f := NewFake(t0)
+	tk := f.NewTicker(3 * time.Second)
+	defer tk.Stop()
+	tm := f.NewTimer(4 * time.Second)
+	var order []time.Duration
+	for range 3 {
+		f.Advance(3 * time.Second)
+		if at, ok := fired(tk.C()); ok {
+			order = append(order, at.Sub(t0))
+		}
+		if at, ok := fired(tm.C()); ok {
+			order = append(order, -at.Sub(t0))
+		}
+	}
+	want := []time.Duration{3 * time.Second, 6 * time.Second, -4 * time.Second, 9 * time.Second}
+	if len(order) != len(want) {
+		t.Fatalf("fired %v, want %v (timers negative)", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("fired %v, want %v (timers negative)", order, want)
+		}
+	}
+
+	// Ticks nobody receives are replaced by the latest one.
+	f.Advance(30 * time.Second)
+	if at, ok := fired(tk.C()); !ok || !at.Equal(t0.Add(39*time.Second)) {
+		t.Errorf("after 10 missed ticks got %v, %v; want the last of them", at, ok)
+	}
+	if _, ok := fired(tk.C()); ok {
+		t.Error("ticker buffered more than one tick")
+	}
+}
+
+func TestBlockUntil(t *testing.T) {
+	f := NewFake(t0)
+	done := make(chan time.Time)
+	go func() {
+		tm := f.NewTimer(time.Hour)
+		done <- <-tm.C()
+	}()
+	f.BlockUntil(1)
+	f.Advance(time.Hour)
+	if at := <-done; !at.Equal(t0.Add(time.Hour)) {
+		t.Errorf("goroutine woke at %v", at)
+	}
+}
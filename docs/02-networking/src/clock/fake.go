@@ -0,0 +1,166 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock that moves only when Advance is called. Its timers and
+// tickers fire during Advance, in order of expiry, each seeing Now at its
+// own expiry. Like the real ones, their channels hold one value. Unlike
+// time.Ticker, which drops a tick while the last one is unreceived, a fake
+// replaces the old value with the new one. A goroutine that fell behind
+// then catches up to the time the test advanced to, whether or not it
+// received the ticks in between, and the test needs no sleep to let it.
+type Fake struct {
+	mu      sync.Mutex
+	cond    sync.Cond
+	now     time.Time
+	waiters []*waiter // pending timers and tickers
+}
+
+// waiter is a pending fake timer or ticker.
+type waiter struct {
+	f      *Fake
+	c      chan time.Time
+	when   time.Time
+	period time.Duration // 0 for a timer
+}
+
+// NewFake returns a fake clock reading start.
+func NewFake(start time.Time) *Fake {
+	f := &Fake{now: start}
+	f.cond.L = &f.mu
+	return f
+}
+
+// Now returns the fake time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// NewTimer returns a timer that fires once the clock has advanced by d.
+// A timer for d <= 0 fires at once.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	w := &waiter{f: f, c: make(chan time.Time, 1)}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.schedule(w, d)
+	return w
+}
+
+// NewTicker returns a ticker that fires every time the clock passes a
+// multiple of d after now.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	w := &waiter{f: f, c: make(chan time.Time, 1), period: d}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.schedule(w, d)
+	return fakeTicker{w}
+}
+
+// Advance moves the clock forward by d and fires every timer and ticker
+// that comes due on the way.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	end := f.now.Add(d)
+	for {
+		next := -1
+		for i, w := range f.waiters {
+			if !w.when.After(end) && (next < 0 || w.when.Before(f.waiters[next].when)) {
+				next = i
+			}
+		}
+		if next < 0 {
+			break
+		}
+		w := f.waiters[next]
+		f.now = w.when
+		f.unschedule(w)
+		w.fire()
+	}
+	f.now = end
+}
+
+// Pending returns the number of timers and tickers waiting on the clock.
+func (f *Fake) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil waits until at least n timers and tickers are waiting on the
+// clock. It is how a test knows that the goroutine under test has reached
+// its wait before advancing past it.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// schedule adds w to fire d from now, or fires it at once for d <= 0.
+// f.mu is held.
+func (f *Fake) schedule(w *waiter, d time.Duration) {
+	w.when = f.now.Add(d)
+	if d <= 0 {
+		w.fire()
+		return
+	}
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+}
+
+// unschedule removes w and reports whether it was pending. f.mu is held.
+func (f *Fake) unschedule(w *waiter) bool {
+	for i, o := range f.waiters {
+		if o == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fire delivers the fake time to w's channel, replacing a value nobody
+// received, and re-arms a ticker. f.mu is held, and only fire sends, so
+// the channel has room once it is drained.
+func (w *waiter) fire() {
+	select {
+	case <-w.c:
+	default:
+	}
+	w.c <- w.f.now
+	if w.period > 0 {
+		w.f.schedule(w, w.period)
+	}
+}
+
+func (w *waiter) C() <-chan time.Time { return w.c }
+
+func (w *waiter) Stop() bool {
+	w.f.mu.Lock()
+	defer w.f.mu.Unlock()
+	return w.f.unschedule(w)
+}
+
+func (w *waiter) Reset(d time.Duration) bool {
+	w.f.mu.Lock()
+	defer w.f.mu.Unlock()
+	pending := w.f.unschedule(w)
+	w.f.schedule(w, d)
+	return pending
+}
+
+// fakeTicker is a waiter whose Stop, like time.Ticker's, returns nothing.
+type fakeTicker struct{ w *waiter }
+
+func (t fakeTicker) C() <-chan time.Time { return t.w.c }
+func (t fakeTicker) Stop()               { t.w.Stop() }
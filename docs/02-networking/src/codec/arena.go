@@ -0,0 +1,208 @@
+package codec
+
+import (
+	"bytes"
+	"unsafe"
+)
+
+// arenaChunk is the smallest block an Arena allocates for bytes.
+const arenaChunk = 16 << 10
+
+// Arena hands out the memory for one connection's parsed requests from a
+// few large blocks, and takes all of it back at once with Reset.
+//
+// A parsed request cannot always alias the read buffer: values that are
+// unescaped need bytes of their own, and a request kept until its response
+// is flushed outlives the next read. Allocating each field on the heap
+// makes every request a dozen small objects for the GC to find and free.
+// An Arena makes them slices of blocks it reuses, so a connection in a
+// steady state allocates nothing. A block that runs out is replaced by a
+// larger one rather than grown, so what was handed out from it stays
+// where it is.
+//
+// Everything handed out is valid until Reset. After that its memory is
+// reused, and a string kept past Reset silently changes. This is memory
+// safe, since the GC still sees every block, but nothing catches the
+// mistake: the price of giving the GC nothing to do. An Arena is not safe
+// for concurrent use.
+type Arena struct {
+	buf    []byte
+	reqs   []Request
+	params []Param
+}
+
+// Reset makes all of the arena's memory available again. It keeps the
+// latest blocks, which are the largest, so an arena sized by its busiest
+// batch allocates no more.
+func (a *Arena) Reset() {
+	a.buf = a.buf[:0]
+	clear(a.reqs) // drop the Params slices, as a courtesy to the GC
+	a.reqs = a.reqs[:0]
+	a.params = a.params[:0]
+}
+
+// alloc returns n bytes of arena memory, with no room to grow.
+func (a *Arena) alloc(n int) []byte {
+	if cap(a.buf)-len(a.buf) < n {
+		a.buf = make([]byte, 0, max(2*cap(a.buf), n, arenaChunk))
+	}
+	off := len(a.buf)
+	a.buf = a.buf[:off+n]
+	return a.buf[off : off+n : off+n]
+}
+
+// shrink gives back the tail of the last alloc that went unused.
+func (a *Arena) shrink(unused int) { a.buf = a.buf[:len(a.buf)-unused] }
+
+// newRequest returns a zeroed Request from the arena.
+func (a *Arena) newRequest() *Request {
+	if len(a.reqs) == cap(a.reqs) {
+		a.reqs = make([]Request, 0, max(2*cap(a.reqs), 64))
+	}
+	a.reqs = a.reqs[:len(a.reqs)+1]
+	return &a.reqs[len(a.reqs)-1]
+}
+
+// Param is one key=value pair of a Request.
+type Param struct {
+	Key, Value string
+}
+
+// Request is a parsed request line: a method, a path and parameters.
+type Request struct {
+	Method, Path string
+	Params       []Param
+}
+
+// Get returns the value of the first parameter named key.
+func (r *Request) Get(key string) (string, bool) {
+	for _, p := range r.Params {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// ParseRequest parses a request line of the form
+//
+//	METHOD PATH key=value key=value ...
+//
+// with fields separated by single spaces and values percent-encoded. With
+// a nil arena the Request and each of its strings is a heap allocation of
+// its own. With an arena they come from it and are valid until its Reset.
+// Either way the Request does not alias p.
+func ParseRequest(p []byte, a *Arena) (*Request, error) {
+	method, rest, ok := cut(p)
+	path, rest, _ := cut(rest)
+	if !ok || len(method) == 0 || len(path) == 0 {
+		return nil, ErrInvalid
+	}
+	var r *Request
+	if a != nil {
+		r = a.newRequest()
+	} else {
+		r = new(Request)
+	}
+	r.Method, r.Path = a.str(method), a.str(path)
+
+	params := a.paramSlice()
+	start := len(params)
+	for len(rest) > 0 {
+		var field []byte
+		field, rest, _ = cut(rest)
+		eq := bytes.IndexByte(field, '=')
+		if eq <= 0 {
+			return nil, ErrInvalid
+		}
+		v, err := a.unescape(field[eq+1:])
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, Param{Key: a.str(field[:eq]), Value: v})
+	}
+	if a != nil {
+		a.params = params
+		r.Params = params[start:len(params):len(params)]
+	} else {
+		r.Params = params
+	}
+	return r, nil
+}
+
+// paramSlice returns the slice ParseRequest appends parameters to: the
+// arena's, or nil for the heap.
+func (a *Arena) paramSlice() []Param {
+	if a == nil {
+		return nil
+	}
+	return a.params
+}
+
+// str copies b into a string: a heap allocation with a nil arena, arena
+// memory otherwise.
+func (a *Arena) str(b []byte) string {
+	if a == nil {
+		return string(b)
+	}
+	if len(b) == 0 {
+		return ""
+	}
+	dst := a.alloc(len(b))
+	copy(dst, b)
+	return unsafe.String(unsafe.SliceData(dst), len(dst))
+}
+
+// unescape decodes %XX sequences in b into a new string. Decoding only
+// shortens, so the arena reserves len(b) and gives back what is left.
+func (a *Arena) unescape(b []byte) (string, error) {
+	if bytes.IndexByte(b, '%') < 0 {
+		return a.str(b), nil
+	}
+	var dst []byte
+	if a != nil {
+		dst = a.alloc(len(b))
+	} else {
+		dst = make([]byte, len(b))
+	}
+	n := 0
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		if c == '%' {
+			if i+2 >= len(b) || unhex(b[i+1]) < 0 || unhex(b[i+2]) < 0 {
+				if a != nil {
+					a.shrink(len(dst))
+				}
+				return "", ErrInvalid
+			}
+			c = byte(unhex(b[i+1])<<4 | unhex(b[i+2]))
+			i += 2
+		}
+		dst[n] = c
+		n++
+	}
+	if a != nil {
+		a.shrink(len(dst) - n)
+	}
+	return unsafe.String(unsafe.SliceData(dst), n), nil
+}
+
+// cut splits b at its first space.
+func cut(b []byte) (before, after []byte, found bool) {
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		return b[:i], b[i+1:], true
+	}
+	return b, nil, false
+}
+
+func unhex(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c - 'a' + 10)
+	case 'A' <= c && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+	return -1
+}
@@ -0,0 +1,116 @@
+package codec
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestParseRequest(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want Request
+	}{
+		{"GET /", Request{Method: "GET", Path: "/"}},
+		{"GET /users/42 id=7 name=J%C3%BCrgen empty=", Request{
+			Method: "GET", Path: "/users/42",
+			Params: []Param{{"id", "7"}, {"name", "Jürgen"}, {"empty", ""}},
+		}},
+		{"PUT /k v=%41%2b%2B x=a%20b", Request{Method: "PUT", Path: "/k", Params: []Param{{"v", "A++"}, {"x", "a b"}}}},
+	} {
+		for _, a := range []*Arena{nil, new(Arena)} {
+			t.Run(fmt.Sprintf("%s/arena=%v", tc.in, a != nil), func(t *testing.T) {
+				in := []byte(tc.in)
+				r, err := ParseRequest(in, a)
+				if err != nil {
+					t.Fatal(err)
+				}
+				clear(in) // the request must not alias its input
+				if r.Method != tc.want.Method || r.Path != tc.want.Path || !slices.Equal(r.Params, tc.want.Params) {
+					t.Errorf("got %+v, want %+v", *r, tc.want)
+				}
+			})
+		}
+	}
+}
+
+func TestParseRequestInvalid(t *testing.T) {
+	a := new(Arena)
+	for _, in := range []string{"", "GET", "GET ", " /", "GET / novalue", "GET / =v", "GET /  a=b", "GET / a=%4", "GET / a=%zz", "GET / a=%"} {
+		for _, a := range []*Arena{nil, a} {
+			if r, err := ParseRequest([]byte(in), a); err != ErrInvalid {
+				t.Errorf("ParseRequest(%q, arena=%v) = %+v, %v; want ErrInvalid", in, a != nil, r, err)
+			}
+		}
+	}
+}
+
+// TestArenaRequests parses a batch large enough to replace every block,
+// checks that the requests from the replaced blocks are intact, and that
+// once Reset the arena serves the same batch without allocating.
+func TestArenaRequests(t *testing.T) {
+	var a Arena
+	batch := func() []*Request {
+		var reqs []*Request
+		for i := range 500 {
+			r, err := ParseRequest(fmt.Appendf(nil, "GET /item/%d id=%d pad=%0200d", i, i, i), &a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reqs = append(reqs, r)
+		}
+		return reqs
+	}
+	for i, r := range batch() {
+		if id, _ := r.Get("id"); r.Path != fmt.Sprintf("/item/%d", i) || id != fmt.Sprint(i) {
+			t.Fatalf("request %d: %+v", i, *r)
+		}
+	}
+	a.Reset()
+	in := []byte("GET /item/1 id=1 name=a%20b pad=00000000000000000000000000")
+	allocs := testing.AllocsPerRun(100, func() {
+		for range 500 {
+			if _, err := ParseRequest(in, &a); err != nil {
+				t.Fatal(err)
+			}
+		}
+		a.Reset()
+	})
+	if allocs != 0 {
+		t.Errorf("%.1f allocations per batch after the first, want 0", allocs)
+	}
+}
+
+// TestConnArena checks that Flush, and only Flush, resets the arena.
+func TestConnArena(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			if _, err := client.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	cc := NewConn(server, NewLine(0), 0)
+	defer cc.Close()
+	for range 3 {
+		if _, err := ParseRequest([]byte("GET / a=b"), cc.Arena()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cc.Send(Message{Payload: []byte("ok")}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(cc.Arena().reqs); n != 3 {
+		t.Fatalf("%d requests in the arena before Flush, want 3", n)
+	}
+	if err := cc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if a := cc.Arena(); len(a.reqs) != 0 || len(a.params) != 0 || len(a.buf) != 0 {
+		t.Errorf("arena not reset by Flush: %d requests, %d params, %d bytes", len(a.reqs), len(a.params), len(a.buf))
+	}
+}
@@ -0,0 +1,234 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"runtime/metrics"
+	"testing"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/bufpool"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/transport"
+)
+
+const (
+	benchMessages = 1000
+	benchSize     = 64
+)
+
+// benchPayload returns a JSON payload of exactly size bytes so every codec
+// carries the same message.
+func benchPayload(size int) []byte {
+	prefix := `{"id":1,"data":"`
+	p := []byte(prefix)
+	for len(p) < size-2 {
+		p = append(p, 'x')
+	}
+	return append(p, '"', '}')
+}
+
+func encodeStream(b *testing.B, c Codec, n int) []byte {
+	b.Helper()
+	payload := benchPayload(benchSize)
+	var stream []byte
+	for range n {
+		var err error
+		if stream, err = c.Encode(stream, Message{Payload: payload}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return stream
+}
+
+// BenchmarkDecode measures decoding a batch of 1000 64-byte messages
+// already in memory.
+func BenchmarkDecode(b *testing.B) {
+	for _, name := range Names {
+		b.Run(name, func(b *testing.B) {
+			c, _ := New(name, 0)
+			stream := encodeStream(b, c, benchMessages)
+			b.SetBytes(int64(len(stream)))
+			b.ReportAllocs()
+			for b.Loop() {
+				buf := stream
+				msgs, err := c.Decode(&buf)
+				if err != nil || len(msgs) != benchMessages {
+					b.Fatalf("decoded %d messages: %v", len(msgs), err)
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*benchMessages), "ns/msg")
+		})
+	}
+}
+
+// BenchmarkEcho pushes batches of 1000 messages through an echo server
+// built on Conn, over each transport: net.Pipe isolates the codec and Conn
+// from the kernel, a unix socketpair adds the syscalls and wakeups, and
+// loopback TCP adds the network stack.
+func BenchmarkEcho(b *testing.B) {
+	for _, name := range Names {
+		for _, tr := range transport.Names {
+			b.Run(name+"/"+tr, func(b *testing.B) {
+				conn, server, err := transport.Pair(tr)
+				if errors.Is(err, errors.ErrUnsupported) {
+					b.Skip(err)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer conn.Close()
+				sc, _ := New(name, 0)
+				go serveEcho(NewConn(server, sc, 64<<10))
+
+				c, _ := New(name, 0)
+				stream := encodeStream(b, c, benchMessages)
+				reply := make([]byte, len(stream))
+				b.SetBytes(int64(len(stream)))
+				b.ReportAllocs()
+				for b.Loop() {
+					errc := make(chan error, 1)
+					go func() {
+						_, err := conn.Write(stream)
+						errc <- err
+					}()
+					if _, err := io.ReadFull(conn, reply); err != nil {
+						b.Fatal(err)
+					}
+					if err := <-errc; err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*benchMessages), "ns/msg")
+			})
+		}
+	}
+}
+
+func serveEcho(cc *Conn) {
+	defer cc.Close()
+	for {
+		msgs, err := cc.Next()
+		if err != nil {
+			return
+		}
+		for _, m := range msgs {
+			if err := cc.Send(m); err != nil {
+				panic(fmt.Sprint("encode: ", err))
+			}
+		}
+		if err := cc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// BenchmarkParseRequest parses batches of 1000 request lines and writes a
+// response for each, the way a server handles a read's worth of pipelined
+// requests: with every field on the heap, or from an arena reset after
+// each batch, as Conn.Flush does. gc-ns/req is the GC's CPU time per
+// request, from runtime/metrics, and includes the background workers and
+// assists the allocations caused.
+func BenchmarkParseRequest(b *testing.B) {
+	reqs := make([][]byte, benchMessages)
+	for i := range reqs {
+		reqs[i] = fmt.Appendf(nil, "GET /users/%d id=%d name=J%%C3%%BCrgen trace=4bf92f3577b34da6a3ce929d0e0e%04d sort=desc limit=50", i, i, i)
+	}
+	for _, mode := range []string{"heap", "arena"} {
+		b.Run(mode, func(b *testing.B) {
+			var a *Arena
+			if mode == "arena" {
+				a = new(Arena)
+			}
+			out := make([]byte, 0, 64<<10)
+			sample := []metrics.Sample{{Name: "/cpu/classes/gc/total:cpu-seconds"}}
+			metrics.Read(sample)
+			gcStart := sample[0].Value.Float64()
+			var ms runtime.MemStats
+			runtime.ReadMemStats(&ms)
+			cycles := ms.NumGC
+			b.ReportAllocs()
+			for b.Loop() {
+				out = out[:0]
+				for _, line := range reqs {
+					r, err := ParseRequest(line, a)
+					if err != nil {
+						b.Fatal(err)
+					}
+					id, _ := r.Get("id")
+					out = append(out, r.Method...)
+					out = append(out, ' ')
+					out = append(out, r.Path...)
+					out = append(out, ' ')
+					out = append(out, id...)
+					out = append(out, '\n')
+				}
+				if a != nil {
+					a.Reset()
+				}
+			}
+			reqs := float64(b.N * benchMessages)
+			metrics.Read(sample)
+			runtime.ReadMemStats(&ms)
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/reqs, "ns/req")
+			b.ReportMetric((sample[0].Value.Float64()-gcStart)*1e9/reqs, "gc-ns/req")
+			b.ReportMetric(float64(ms.NumGC-cycles)*1e6/reqs, "GCs/Mreq")
+		})
+	}
+}
+
+// BenchmarkConnChurn opens a connection per op over net.Pipe, echoes ten
+// 64-byte lines on it and closes it, as a server sees clients that
+// connect for a few requests. With new, the server's Conn allocates a
+// 4 KiB read buffer and grows a write buffer for every connection; with
+// pooled, it takes both from a bufpool.Pool and releases them when the
+// connection closes. allocs/op and B/op include the client's side and
+// net.Pipe's, which are the same in both; gc-ns/conn is the GC's CPU time
+// per connection and GCs/kconn the collections per thousand.
+func BenchmarkConnChurn(b *testing.B) {
+	const size = 4 << 10
+	line, _ := NewLine(0).Encode(nil, Message{Payload: benchPayload(benchSize - 1)})
+	for _, mode := range []string{"new", "pooled"} {
+		b.Run(mode, func(b *testing.B) {
+			pool := bufpool.New(size, size)
+			reply := make([]byte, len(line))
+			sample := []metrics.Sample{{Name: "/cpu/classes/gc/total:cpu-seconds"}}
+			metrics.Read(sample)
+			gcStart := sample[0].Value.Float64()
+			var ms runtime.MemStats
+			runtime.ReadMemStats(&ms)
+			cycles := ms.NumGC
+			b.ReportAllocs()
+			for b.Loop() {
+				client, server := net.Pipe()
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					var cc *Conn
+					if mode == "pooled" {
+						cc = NewPooledConn(server, NewLine(0), size, pool)
+						defer cc.Release()
+					} else {
+						cc = NewConn(server, NewLine(0), size)
+					}
+					serveEcho(cc)
+				}()
+				for range 10 {
+					if _, err := client.Write(line); err != nil {
+						b.Fatal(err)
+					}
+					if _, err := io.ReadFull(client, reply); err != nil {
+						b.Fatal(err)
+					}
+				}
+				client.Close()
+				<-done
+			}
+			metrics.Read(sample)
+			runtime.ReadMemStats(&ms)
+			b.ReportMetric((sample[0].Value.Float64()-gcStart)*1e9/float64(b.N), "gc-ns/conn")
+			b.ReportMetric(float64(ms.NumGC-cycles)*1e3/float64(b.N), "GCs/kconn")
+		})
+	}
+}
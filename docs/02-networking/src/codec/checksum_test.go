@@ -0,0 +1,129 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/chaos"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/transport"
+)
+
+// TestChecksumBitFlips flips every bit of a checksummed frame in turn.
+// None may decode to a message: the flip is caught by the checksum, or by
+// the size limit if it lands high in the length, or leaves the frame
+// waiting for bytes its new length claims.
+func TestChecksumBitFlips(t *testing.T) {
+	c := NewLengthChecksummed(1024)
+	frame, err := c.Encode(nil, Message{Payload: benchPayload(benchSize)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 8 * len(frame) {
+		buf := bytes.Clone(frame)
+		buf[i/8] ^= 1 << (i % 8)
+		msgs, err := c.Decode(&buf)
+		if len(msgs) > 0 {
+			t.Fatalf("bit %d: decoded %q", i, msgs[0].Payload)
+		}
+		switch {
+		case i < 8*lengthHeader && err == nil:
+			if len(buf) != len(frame) {
+				t.Errorf("bit %d: consumed %d bytes of an incomplete frame", i, len(frame)-len(buf))
+			}
+		case i < 8*lengthHeader && (errors.Is(err, ErrChecksum) || errors.Is(err, ErrTooLarge)):
+		case !errors.Is(err, ErrChecksum):
+			t.Errorf("bit %d: %v, want ErrChecksum", i, err)
+		}
+	}
+}
+
+// TestChecksumChaos echoes frames through a connection that flips a bit in
+// every read, and checks that the server never takes a corrupted frame
+// for a message. Without the trailer, the same faults reach the
+// application as wrong payloads.
+func TestChecksumChaos(t *testing.T) {
+	payload := benchPayload(benchSize)
+	// echo sends frames until the server fails, and returns how many
+	// payloads the server accepted that differ from what was sent, and
+	// the error it stopped with.
+	echo := func(t *testing.T, codecName string) (corrupt int, err error) {
+		client, server, err := transport.Pair("pipe")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		faults := chaos.New()
+		faults.Set(chaos.Config{FlipBitP: 1})
+		dec, _ := New(codecName, 1024)
+		cc := NewConn(faults.Conn(server), dec, 0)
+		defer cc.Close()
+
+		enc, _ := New(codecName, 1024)
+		frame, _ := enc.Encode(nil, Message{Payload: payload})
+		go func() {
+			for {
+				if _, err := client.Write(frame); err != nil {
+					return
+				}
+			}
+		}()
+		for range 100 {
+			msgs, err := cc.Next()
+			for _, m := range msgs {
+				if !bytes.Equal(m.Payload, payload) {
+					corrupt++
+				}
+			}
+			if err != nil {
+				return corrupt, err
+			}
+		}
+		return corrupt, nil
+	}
+
+	for range 20 {
+		corrupt, err := echo(t, "length-crc")
+		if corrupt > 0 {
+			t.Fatalf("length-crc: %d corrupted payloads accepted", corrupt)
+		}
+		if !errors.Is(err, ErrChecksum) && !errors.Is(err, ErrTooLarge) {
+			t.Fatalf("length-crc: %v, want a checksum or size error", err)
+		}
+	}
+	var corrupt int
+	for range 20 {
+		n, _ := echo(t, "length")
+		corrupt += n
+	}
+	if corrupt == 0 {
+		t.Error("length: no corrupted payloads got through; the faults are not reaching the codec")
+	}
+}
+
+// BenchmarkChecksum measures what the CRC32C trailer adds to encoding and
+// decoding one frame, by payload size. The checksum runs over the frame
+// twice, once in Encode and once in Decode, as it would on the sender and
+// the receiver.
+func BenchmarkChecksum(b *testing.B) {
+	for _, size := range []int{64, 1 << 10, 16 << 10, 64 << 10} {
+		payload := benchPayload(size)
+		for _, name := range []string{"length", "length-crc"} {
+			b.Run(fmt.Sprintf("%s/%dB", name, size), func(b *testing.B) {
+				c, _ := New(name, size)
+				frame := make([]byte, 0, size+lengthHeader+crcTrailer)
+				buf := new([]byte)
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				for b.Loop() {
+					frame, _ = c.Encode(frame[:0], Message{Payload: payload})
+					*buf = frame
+					if msgs, err := c.Decode(buf); err != nil || len(msgs) != 1 {
+						b.Fatalf("%d messages, %v", len(msgs), err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package codec
+
+import "encoding/binary"
+
+// The top two bits of a chunked frame's length word say where the frame
+// falls in its message; the rest is the payload length. A whole message
+// sets neither, so its frame is the same bytes the length codec writes.
+const (
+	chunkMore  = 1 << 31 // more frames of this message follow
+	chunkCont  = 1 << 30 // the frame continues an earlier one
+	chunkFlags = chunkMore | chunkCont
+)
+
+var chunkBits = [...]uint32{
+	Whole:    0,
+	Begin:    chunkMore,
+	Continue: chunkMore | chunkCont,
+	End:      chunkCont,
+}
+
+// ChunkSize is the most payload Encode puts in each frame of a message it
+// splits. A frame is then 4 KiB, the smallest read buffer among the
+// example servers, so the frames of a split message never make a Conn
+// grow its buffer, and a receiver limited to 4 KiB messages takes them.
+const ChunkSize = 4<<10 - lengthHeader
+
+// Chunked is LengthPrefixed for messages of any size. A message longer
+// than ChunkSize goes as a Begin frame, any number of Continue frames and
+// an End frame, and Decode returns each frame as it completes, a Message
+// whose Chunk says which part it is. Neither end holds more than one frame
+// of a message, so an echo server moves a message of many megabytes
+// through the same few kilobytes of buffer that carry a short one,
+// sending each part on as it arrives.
+//
+// maxSize bounds a frame, not a message: the length of a split message
+// is not known until its End frame, and a handler that collects one has
+// to bound it itself. Decode fails with ErrInvalid on frames out of
+// order, such as a Continue with no Begin before it or a Whole message
+// inside a split one.
+type Chunked struct {
+	max   int
+	chunk int
+	open  bool // Decode is inside a split message
+	msgs  []Message
+}
+
+// NewChunked returns a chunked codec accepting frames of up to maxSize
+// payload bytes, up to 1 GiB less one byte, which the length word leaves
+// room for. Encode splits messages into frames of ChunkSize, or maxSize if
+// that is smaller.
+func NewChunked(maxSize int) *Chunked {
+	m := min(limit(maxSize), chunkCont-1)
+	return &Chunked{max: m, chunk: min(m, ChunkSize)}
+}
+
+// Decode implements Codec.
+func (c *Chunked) Decode(buf *[]byte) ([]Message, error) {
+	c.msgs = c.msgs[:0]
+	b := *buf
+	for len(b) >= lengthHeader {
+		h := binary.BigEndian.Uint32(b)
+		n, part := h&^chunkFlags, chunkOf(h)
+		if n > uint32(c.max) {
+			*buf = b
+			return c.msgs, ErrTooLarge
+		}
+		if (part == Whole || part == Begin) == c.open {
+			*buf = b
+			return c.msgs, ErrInvalid
+		}
+		end := lengthHeader + int(n)
+		if len(b) < end {
+			break
+		}
+		c.open = part == Begin || part == Continue
+		c.msgs = append(c.msgs, Message{Payload: b[lengthHeader:end:end], Chunk: part})
+		b = b[end:]
+	}
+	*buf = b
+	return c.msgs, nil
+}
+
+// chunkOf returns the part of a message a length word marks.
+func chunkOf(h uint32) Chunk {
+	switch h & chunkFlags {
+	case chunkMore:
+		return Begin
+	case chunkMore | chunkCont:
+		return Continue
+	case chunkCont:
+		return End
+	}
+	return Whole
+}
+
+// Encode implements Codec. A part of a message is framed as it is, so a
+// server echoes what Decode returned part by part; a Whole message longer
+// than the frame size is split. Encode keeps no state, so the parts'
+// order is the caller's to keep.
+func (c *Chunked) Encode(dst []byte, m Message) ([]byte, error) {
+	p := m.Payload
+	if m.Chunk > End {
+		return dst, ErrInvalid
+	}
+	if m.Chunk != Whole || len(p) <= c.chunk {
+		if len(p) > c.max {
+			return dst, ErrTooLarge
+		}
+		return appendChunk(dst, p, m.Chunk), nil
+	}
+	dst = appendChunk(dst, p[:c.chunk], Begin)
+	for p = p[c.chunk:]; len(p) > c.chunk; p = p[c.chunk:] {
+		dst = appendChunk(dst, p[:c.chunk], Continue)
+	}
+	return appendChunk(dst, p, End), nil
+}
+
+func appendChunk(dst, p []byte, part Chunk) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(p))|chunkBits[part])
+	return append(dst, p...)
+}
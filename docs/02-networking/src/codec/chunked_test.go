@@ -0,0 +1,255 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"runtime"
+	"runtime/metrics"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/transport"
+)
+
+// TestChunked encodes messages on both sides of the split, decodes them
+// from random reads and puts the parts back together.
+func TestChunked(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	sizes := []int{0, 1, ChunkSize, ChunkSize + 1, 3 * ChunkSize, 1 << 20}
+	c := NewChunked(0)
+	var stream []byte
+	var want [][]byte
+	for i, n := range sizes {
+		m := make([]byte, n)
+		for j := range m {
+			m[j] = byte(i + j)
+		}
+		want = append(want, m)
+		var err error
+		if stream, err = c.Encode(stream, Message{Payload: m}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewStream(NewChunked(0))
+	var got [][]byte
+	var cur []byte
+	parts := 0
+	for rest := stream; len(rest) > 0; {
+		n := min(len(rest), 1+rng.IntN(10000))
+		msgs, err := s.Feed(rest[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+		for _, m := range msgs {
+			if len(m.Payload) > ChunkSize {
+				t.Fatalf("a %d-byte frame", len(m.Payload))
+			}
+			cur = append(cur, m.Payload...)
+			parts++
+			if m.Chunk == Whole || m.Chunk == End {
+				got = append(got, cur)
+				cur = nil
+			}
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%d messages, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("message %d (%d bytes) differs", i, len(want[i]))
+		}
+	}
+	// 1, 1, 1, 2, 3 and 257 frames.
+	if parts != 265 {
+		t.Errorf("%d frames, want 265", parts)
+	}
+
+	// A message that fits one frame is framed as the length codec does.
+	short := []byte("hello")
+	a, _ := NewChunked(0).Encode(nil, Message{Payload: short})
+	b, _ := NewLengthPrefixed(0).Encode(nil, Message{Payload: short})
+	if !bytes.Equal(a, b) {
+		t.Errorf("chunked %q, length %q", a, b)
+	}
+}
+
+// TestChunkedOrder checks that frames out of order are a protocol error,
+// and that the codecs which cannot carry parts refuse to encode them.
+func TestChunkedOrder(t *testing.T) {
+	frame := func(parts ...Chunk) []byte {
+		var b []byte
+		for _, p := range parts {
+			b = appendChunk(b, []byte("x"), p)
+		}
+		return b
+	}
+	for _, tc := range []struct {
+		parts []Chunk
+		want  error
+	}{
+		{[]Chunk{Whole, Begin, Continue, Continue, End, Whole}, nil},
+		{[]Chunk{Begin, End, Begin, End}, nil},
+		{[]Chunk{Continue}, ErrInvalid},
+		{[]Chunk{End}, ErrInvalid},
+		{[]Chunk{Begin, Whole}, ErrInvalid},
+		{[]Chunk{Begin, Begin}, ErrInvalid},
+		{[]Chunk{Begin, End, End}, ErrInvalid},
+	} {
+		buf := frame(tc.parts...)
+		msgs, err := NewChunked(0).Decode(&buf)
+		if err != tc.want {
+			t.Errorf("%v: %v, want %v", tc.parts, err, tc.want)
+		}
+		if err != nil && len(msgs) != len(tc.parts)-1 {
+			t.Errorf("%v: %d messages before the error", tc.parts, len(msgs))
+		}
+	}
+
+	for _, name := range Names {
+		_, err := must(New(name, 0)).Encode(nil, Message{Payload: []byte("{}"), Chunk: End})
+		if want := map[bool]error{true: nil, false: ErrInvalid}[name == "chunked"]; err != want {
+			t.Errorf("%s: encoding an End part: %v, want %v", name, err, want)
+		}
+	}
+	if _, err := NewChunked(0).Encode(nil, Message{Chunk: End + 1}); err != ErrInvalid {
+		t.Errorf("unknown part: %v", err)
+	}
+	if _, err := NewChunked(16).Encode(nil, Message{Payload: make([]byte, 17), Chunk: Continue}); err != ErrTooLarge {
+		t.Errorf("oversized part: %v", err)
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// TestChunkedEcho echoes a 4 MiB message through serveEcho, which knows
+// nothing of chunks, and checks it comes back whole while the server's
+// buffers stay at the size they started at.
+func TestChunkedEcho(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	cc := NewConn(server, NewChunked(0), 0)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serveEcho(cc)
+	}()
+
+	msg := bytes.Repeat([]byte("0123456789abcdef"), 256<<10)
+	stream, _ := NewChunked(0).Encode(nil, Message{Payload: msg})
+	go client.Write(stream)
+	reply := NewConn(client, NewChunked(0), 0)
+	var got []byte
+	for len(got) < len(msg) {
+		msgs, err := reply.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range msgs {
+			got = append(got, m.Payload...)
+		}
+	}
+	client.Close()
+	<-done
+	if !bytes.Equal(got, msg) {
+		t.Fatal("the echo differs")
+	}
+	if cap(cc.rbuf) != DefaultReadSize || cap(cc.wbuf) > 2*DefaultReadSize {
+		t.Errorf("server buffers grew to %d and %d bytes", cap(cc.rbuf), cap(cc.wbuf))
+	}
+}
+
+// BenchmarkLargeMessage echoes one message of 1 to 32 MiB per op over
+// loopback TCP through serveEcho: with length, which buffers the message
+// whole on the way in and again on the way out, under a limit that lets
+// it, and with chunked, which passes it through in 4 KiB frames. buf-B is
+// what the server's read and write buffers grew to, and peak-heap-B the
+// most heap the process held above what it held before, sampled every
+// millisecond; both include the collectable garbage of buffers that grew.
+// The client writes a message encoded in advance and discards the reply,
+// so neither counts its memory.
+func BenchmarkLargeMessage(b *testing.B) {
+	for _, size := range []int{1 << 20, 8 << 20, 32 << 20} {
+		for _, name := range []string{"length", "chunked"} {
+			b.Run(fmt.Sprintf("%s/%dMiB", name, size>>20), func(b *testing.B) {
+				conn, server, err := transport.Pair("tcp")
+				if errors.Is(err, errors.ErrUnsupported) {
+					b.Skip(err)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer conn.Close()
+				stream, err := must(New(name, size)).Encode(nil, Message{Payload: benchPayload(size)})
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				runtime.GC()
+				var peak atomic.Uint64
+				sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+				metrics.Read(sample)
+				base := sample[0].Value.Uint64()
+				stop := make(chan struct{})
+				sampled := make(chan struct{})
+				go func() {
+					defer close(sampled)
+					s := []metrics.Sample{{Name: sample[0].Name}}
+					tick := time.NewTicker(time.Millisecond)
+					defer tick.Stop()
+					for {
+						select {
+						case <-stop:
+							return
+						case <-tick.C:
+						}
+						metrics.Read(s)
+						if v := s[0].Value.Uint64(); v > peak.Load() {
+							peak.Store(v)
+						}
+					}
+				}()
+
+				cc := NewConn(server, must(New(name, size)), 0)
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					serveEcho(cc)
+				}()
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				for b.Loop() {
+					errc := make(chan error, 1)
+					go func() {
+						_, err := conn.Write(stream)
+						errc <- err
+					}()
+					if _, err := io.CopyN(io.Discard, conn, int64(len(stream))); err != nil {
+						b.Fatal(err)
+					}
+					if err := <-errc; err != nil {
+						b.Fatal(err)
+					}
+				}
+				conn.Close()
+				<-done
+				close(stop)
+				<-sampled
+				b.ReportMetric(float64(cap(cc.rbuf)+cap(cc.wbuf)), "buf-B")
+				b.ReportMetric(float64(max(peak.Load(), base)-base), "peak-heap-B")
+			})
+		}
+	}
+}
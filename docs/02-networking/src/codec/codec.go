@@ -0,0 +1,92 @@
+// Package codec splits a byte stream into messages for the echo examples.
+// All codecs are zero-copy: decoded payloads are slices of the caller's
+// buffer, so the servers can swap framing formats without changing how
+// they read, and codecs can be benchmarked over identical transports.
+package codec
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Codecs return these sentinels unwrapped so that rejecting a message never
+// allocates; callers that want context add it once per connection, not per
+// message.
+var (
+	// ErrTooLarge is returned when a message exceeds the codec's limit.
+	ErrTooLarge = errors.New("codec: message too large")
+	// ErrInvalid is returned for a message that is not well formed.
+	ErrInvalid = errors.New("codec: invalid message")
+	// ErrChecksum is returned for a frame whose checksum does not match
+	// its contents.
+	ErrChecksum = errors.New("codec: checksum mismatch")
+)
+
+// Message is one decoded message. Payload excludes framing.
+//
+// The chunked codec moves a message too large for one frame as a run of
+// frames, and decodes each as a Message of its own: Chunk says which part
+// of the message Payload is. The other codecs only carry whole messages,
+// and their Encode fails with ErrInvalid for a part.
+type Message struct {
+	Payload []byte
+	Chunk   Chunk
+}
+
+// Chunk places a Message within a message split across frames. The zero
+// value is a whole message, so code that never streams can ignore it.
+type Chunk uint8
+
+const (
+	Whole    Chunk = iota // a complete message
+	Begin                 // the first part of a split message
+	Continue              // a part after Begin, before End
+	End                   // the last part
+)
+
+// Codec frames messages on a byte stream.
+//
+// Decode consumes every complete message at the front of *buf and advances
+// *buf past them, leaving an incomplete tail for the next call. The returned
+// payloads alias the buffer, and the returned slice is reused by the next
+// Decode, so both are only valid until the caller reads into the buffer or
+// decodes again. A Codec keeps that scratch state and must not be shared
+// between connections.
+//
+// Encode appends m with framing to dst.
+type Codec interface {
+	Decode(buf *[]byte) ([]Message, error)
+	Encode(dst []byte, m Message) ([]byte, error)
+}
+
+// DefaultMaxSize bounds messages when a constructor is given a limit <= 0.
+const DefaultMaxSize = 64 << 10
+
+// New returns a fresh codec by name: "line", "length", "length-crc",
+// "chunked" or "jsonl".
+func New(name string, maxSize int) (Codec, error) {
+	switch name {
+	case "line":
+		return NewLine(maxSize), nil
+	case "length":
+		return NewLengthPrefixed(maxSize), nil
+	case "length-crc":
+		return NewLengthChecksummed(maxSize), nil
+	case "chunked":
+		return NewChunked(maxSize), nil
+	case "jsonl":
+		return NewJSONLines(maxSize), nil
+	default:
+		return nil, fmt.Errorf("codec: unknown codec %q", name)
+	}
+}
+
+// Names lists the codecs New accepts.
+var Names = []string{"line", "length", "length-crc", "chunked", "jsonl"}
+
+func limit(maxSize int) int {
+	if maxSize <= 0 {
+		return DefaultMaxSize
+	}
+	return maxSize
+}
@@ -0,0 +1,187 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/bufpool"
+)
+
+var samples = [][]byte{
+	[]byte(`{"id":1,"op":"ping"}`),
+	[]byte(`{"id":2,"op":"echo","data":"hello"}`),
+	[]byte(`[]`),
+}
+
+// roundTrip encodes msgs, then decodes them again feeding the stream in
+// chunks of step bytes, the way reads deliver it.
+func roundTrip(t *testing.T, c Codec, msgs [][]byte, step int) [][]byte {
+	t.Helper()
+	var stream []byte
+	for _, m := range msgs {
+		var err error
+		if stream, err = c.Encode(stream, Message{Payload: m}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got [][]byte
+	var buf []byte
+	for off := 0; off < len(stream); off += step {
+		buf = append(buf, stream[off:min(off+step, len(stream))]...)
+		out, err := c.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range out {
+			got = append(got, bytes.Clone(m.Payload))
+		}
+	}
+	if len(buf) != 0 {
+		t.Fatalf("%d bytes left undecoded", len(buf))
+	}
+	return got
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, name := range Names {
+		for _, step := range []int{1, 3, 7, 1024} {
+			t.Run(fmt.Sprintf("%s/step=%d", name, step), func(t *testing.T) {
+				c, err := New(name, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := roundTrip(t, c, samples, step)
+				if len(got) != len(samples) {
+					t.Fatalf("decoded %d messages, want %d", len(got), len(samples))
+				}
+				for i := range got {
+					if !bytes.Equal(got[i], samples[i]) {
+						t.Errorf("message %d = %q, want %q", i, got[i], samples[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeZeroCopy(t *testing.T) {
+	buf := []byte("abc\ndef\nxy")
+	backing := buf
+	msgs, err := NewLine(0).Decode(&buf)
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("Decode = %d messages, %v", len(msgs), err)
+	}
+	if &msgs[1].Payload[0] != &backing[4] {
+		t.Fatal("payload does not alias the input buffer")
+	}
+	if string(buf) != "xy" {
+		t.Fatalf("remaining = %q, want %q", buf, "xy")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		c     Codec
+		input []byte
+		want  error
+	}{
+		{"line complete", NewLine(4), []byte("toolong\n"), ErrTooLarge},
+		{"line partial", NewLine(4), []byte("toolong"), ErrTooLarge},
+		{"length header", NewLengthPrefixed(4), []byte{0, 0, 1, 0}, ErrTooLarge},
+		{"jsonl invalid", NewJSONLines(0), []byte("{\"a\":1}\n{oops}\n"), ErrInvalid},
+	}
+	for _, tt := range tests {
+		buf := tt.input
+		if _, err := tt.c.Decode(&buf); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+		// Rejecting a message is a hot path under attack; it must not
+		// allocate. json.Valid builds a SyntaxError internally for
+		// invalid input, so only the framing checks are held to this.
+		if tt.want == ErrInvalid {
+			continue
+		}
+		pbuf := new([]byte)
+		allocs := testing.AllocsPerRun(100, func() {
+			*pbuf = tt.input
+			tt.c.Decode(pbuf)
+		})
+		if allocs != 0 {
+			t.Errorf("%s: error path allocates %v times", tt.name, allocs)
+		}
+	}
+
+	if _, err := NewLine(0).Encode(nil, Message{Payload: []byte("a\nb")}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("line Encode with newline: got %v", err)
+	}
+	if _, err := NewJSONLines(0).Encode(nil, Message{Payload: []byte("{\n}")}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("jsonl Encode with newline: got %v", err)
+	}
+}
+
+func TestConnGrowsForLargeMessage(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	cc := NewConn(server, NewLine(0), 16)
+	defer cc.Close()
+
+	big := strings.Repeat("x", 100)
+	go client.Write([]byte("a\n" + big + "\n"))
+
+	var got []string
+	for len(got) < 2 {
+		msgs, err := cc.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range msgs {
+			got = append(got, string(m.Payload))
+		}
+	}
+	if got[0] != "a" || got[1] != big {
+		t.Fatalf("got %q", got)
+	}
+}
+
+// TestPooledConn checks that Conns on pooled buffers echo, grow for a
+// message longer than their buffer, and can be released twice.
+func TestPooledConn(t *testing.T) {
+	pool := bufpool.New(16, 1024)
+	big := strings.Repeat("x", 100)
+	for range 3 {
+		client, server := net.Pipe()
+		cc := NewPooledConn(server, NewLine(0), 16, pool)
+		reply := make(chan string, 1)
+		go func() {
+			defer client.Close()
+			client.Write([]byte("a\n" + big + "\n"))
+			b, _ := io.ReadAll(client)
+			reply <- string(b)
+		}()
+		for n := 0; n < 2; {
+			msgs, err := cc.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range msgs {
+				cc.Send(m)
+				n++
+			}
+		}
+		if err := cc.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		cc.Close()
+		cc.Release()
+		cc.Release()
+		if got, want := <-reply, "a\n"+big+"\n"; got != want {
+			t.Fatalf("echoed %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// decodeAll runs c over input fed in chunks of step bytes and returns copies
+// of the decoded payloads, the undecoded tail and the first error.
+func decodeAll(c Codec, input []byte, step int) ([][]byte, []byte, error) {
+	var out [][]byte
+	var buf []byte
+	for off := 0; off < len(input); off += step {
+		buf = append(buf, input[off:min(off+step, len(input))]...)
+		msgs, err := c.Decode(&buf)
+		for _, m := range msgs {
+			out = append(out, bytes.Clone(m.Payload))
+		}
+		if err != nil {
+			return out, buf, err
+		}
+	}
+	return out, buf, nil
+}
+
+// FuzzLengthPrefixed checks that the length-prefixed decoder never panics,
+// gives the same result however the stream is split across reads, and
+// accounts for every byte: decoded frames re-encode to exactly the bytes
+// they were decoded from.
+func FuzzLengthPrefixed(f *testing.F) {
+	c := NewLengthPrefixed(1024)
+	for _, seed := range [][][]byte{
+		{[]byte("hello")},
+		{[]byte(""), []byte("a"), []byte("\x00\x00\x00\x04")},
+		{bytes.Repeat([]byte("x"), 1024)},
+	} {
+		var stream []byte
+		for _, m := range seed {
+			stream, _ = c.Encode(stream, Message{Payload: m})
+		}
+		f.Add(stream, uint8(1))
+		f.Add(stream[:len(stream)-1], uint8(3))
+	}
+	f.Add([]byte("\x00\x00\x04\x01"), uint8(2)) // one byte over the limit
+	f.Add([]byte("\xff\xff\xff\xff"), uint8(1))
+
+	f.Fuzz(func(t *testing.T, input []byte, step uint8) {
+		whole, tail, err := decodeAll(NewLengthPrefixed(1024), input, max(len(input), 1))
+		if err != nil && !errors.Is(err, ErrTooLarge) {
+			t.Fatalf("unexpected error %v", err)
+		}
+		// A split input stops at the oversized header without having
+		// buffered what follows it, so tails only match without errors.
+		chunked, ctail, cerr := decodeAll(NewLengthPrefixed(1024), input, max(int(step), 1))
+		if !errors.Is(cerr, err) || len(chunked) != len(whole) || (err == nil && !bytes.Equal(ctail, tail)) {
+			t.Fatalf("step %d: %d messages, tail %q, %v; whole input: %d messages, tail %q, %v",
+				step, len(chunked), ctail, cerr, len(whole), tail, err)
+		}
+
+		var re []byte
+		for i, m := range whole {
+			if !bytes.Equal(m, chunked[i]) {
+				t.Fatalf("message %d differs between whole and chunked decoding", i)
+			}
+			re, _ = c.Encode(re, Message{Payload: m})
+		}
+		if !bytes.Equal(append(re, tail...), input) {
+			t.Fatal("decoded frames and tail do not reassemble the input")
+		}
+		if err == nil && len(tail) >= lengthHeader+1024 {
+			t.Fatalf("%d undecoded bytes hold a complete frame", len(tail))
+		}
+	})
+}
+
+// FuzzLine applies the same checks to the newline codec.
+func FuzzLine(f *testing.F) {
+	f.Add([]byte("ping\npong\n"), uint8(1))
+	f.Add([]byte("no newline"), uint8(4))
+	f.Add(append(bytes.Repeat([]byte("x"), 65), '\n'), uint8(7))
+
+	f.Fuzz(func(t *testing.T, input []byte, step uint8) {
+		whole, tail, err := decodeAll(NewLine(64), input, max(len(input), 1))
+		if err != nil && !errors.Is(err, ErrTooLarge) {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err != nil {
+			return // where an oversized line is noticed depends on the split
+		}
+		chunked, ctail, cerr := decodeAll(NewLine(64), input, max(int(step), 1))
+		if cerr != nil || len(chunked) != len(whole) || !bytes.Equal(ctail, tail) {
+			t.Fatalf("step %d: %d messages, %v; whole input: %d messages", step, len(chunked), cerr, len(whole))
+		}
+		var re []byte
+		for _, m := range whole {
+			re = append(append(re, m...), '\n')
+		}
+		if !bytes.Equal(append(re, tail...), input) {
+			t.Fatal("decoded lines and tail do not reassemble the input")
+		}
+	})
+}
+
+// FuzzParseRequest checks that ParseRequest never panics and that the heap
+// and the arena produce the same request, which stays intact after its
+// input is overwritten and more requests are parsed into the same arena.
+func FuzzParseRequest(f *testing.F) {
+	for _, seed := range []string{
+		"GET /", "GET /users/42 id=7 name=J%C3%BCrgen", "PUT /k v=%41%2b", "GET / a=%4", "GET  /", "GET / =x", "GET / a=b ",
+	} {
+		f.Add([]byte(seed))
+	}
+	var a Arena
+	f.Fuzz(func(t *testing.T, input []byte) {
+		a.Reset()
+		heap, herr := ParseRequest(input, nil)
+		in := bytes.Clone(input)
+		fromArena, aerr := ParseRequest(in, &a)
+		if (herr == nil) != (aerr == nil) {
+			t.Fatalf("heap error %v, arena error %v", herr, aerr)
+		}
+		if herr != nil {
+			return
+		}
+		clear(in)
+		for range 4 {
+			ParseRequest(input, &a)
+		}
+		if heap.Method != fromArena.Method || heap.Path != fromArena.Path || !slices.Equal(heap.Params, fromArena.Params) {
+			t.Fatalf("heap %+v, arena %+v", *heap, *fromArena)
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package codec
+
+import "encoding/json"
+
+// JSONLines frames messages as newline-terminated JSON values (JSON Lines).
+// Each line is validated without being unmarshalled, so payloads are still
+// slices of the read buffer; handlers that need the fields decode them
+// themselves.
+type JSONLines struct {
+	line Line
+}
+
+// NewJSONLines returns a JSON Lines codec accepting lines up to maxSize
+// bytes.
+func NewJSONLines(maxSize int) *JSONLines {
+	return &JSONLines{line: Line{max: limit(maxSize)}}
+}
+
+// Decode implements Codec. An invalid line is a protocol error: the valid
+// messages before it are returned with ErrInvalid and the stream cannot be
+// resumed.
+func (c *JSONLines) Decode(buf *[]byte) ([]Message, error) {
+	msgs, err := c.line.Decode(buf)
+	for i, m := range msgs {
+		if !json.Valid(m.Payload) {
+			return msgs[:i], ErrInvalid
+		}
+	}
+	return msgs, err
+}
+
+// Encode implements Codec. The payload must be a single-line JSON value;
+// only the newline is checked, since validating again would double the cost
+// of echoing a decoded message.
+func (c *JSONLines) Encode(dst []byte, m Message) ([]byte, error) {
+	return c.line.Encode(dst, m)
+}
@@ -0,0 +1,51 @@
+package codec
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/budget"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/transport"
+)
+
+// TestLatencyBudget holds the Conn read/decode/encode/flush loop to a p99
+// budget under half its measured capacity. The budget only catches gross
+// regressions, such as replies that wait for a later batch to flush.
+func TestLatencyBudget(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveEcho(NewConn(conn, NewLine(0), 64<<10))
+		}
+	}()
+
+	res := budget.Run(t, budget.Line(ln.Addr().String(), benchSize), budget.Load{})
+	budget.Check(t, res, 20*time.Millisecond)
+}
+
+// TestAllocBudget holds the same loop to no heap allocations per message,
+// over every transport: the payloads are slices of the
+// read buffer and the replies are encoded into the write buffer.
+func TestAllocBudget(t *testing.T) {
+	for _, name := range transport.Names {
+		t.Run(name, func(t *testing.T) {
+			client, server, err := transport.Pair(name)
+			if err != nil {
+				t.Skip(err)
+			}
+			go serveEcho(NewConn(server, NewLine(0), 64<<10))
+			s := budget.LineConn(client, benchSize)
+			defer s.Close()
+			budget.CheckAllocs(t, s, 0)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package codec
+
+import "bytes"
+
+// Line frames messages as newline-terminated lines, the format of the echo
+// servers. Payloads exclude the newline.
+type Line struct {
+	max  int
+	msgs []Message
+}
+
+// NewLine returns a line codec accepting lines up to maxSize bytes.
+func NewLine(maxSize int) *Line { return &Line{max: limit(maxSize)} }
+
+// Decode implements Codec.
+func (c *Line) Decode(buf *[]byte) ([]Message, error) {
+	c.msgs = c.msgs[:0]
+	b := *buf
+	for {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			break
+		}
+		if i > c.max {
+			*buf = b
+			return c.msgs, ErrTooLarge
+		}
+		c.msgs = append(c.msgs, Message{Payload: b[:i:i]})
+		b = b[i+1:]
+	}
+	*buf = b
+	if len(b) > c.max {
+		return c.msgs, ErrTooLarge
+	}
+	return c.msgs, nil
+}
+
+// Encode implements Codec.
+func (c *Line) Encode(dst []byte, m Message) ([]byte, error) {
+	if m.Chunk != Whole {
+		return dst, ErrInvalid
+	}
+	if len(m.Payload) > c.max {
+		return dst, ErrTooLarge
+	}
+	if bytes.IndexByte(m.Payload, '\n') >= 0 {
+		return dst, ErrInvalid
+	}
+	dst = append(dst, m.Payload...)
+	return append(dst, '\n'), nil
+}
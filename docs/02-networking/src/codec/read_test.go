@@ -0,0 +1,129 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+// streamFrames opens a loopback connection whose peer writes length-prefixed
+// frames of the given payload size as fast as it can, in large batches so
+// the writer is never the bottleneck.
+func streamFrames(b *testing.B, payload int) net.Conn {
+	b.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+
+	c := NewLengthPrefixed(payload)
+	frame, _ := c.Encode(nil, Message{Payload: make([]byte, payload)})
+	var batch []byte
+	for len(batch) < 1<<20 {
+		batch = append(batch, frame...)
+	}
+
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, err := conn.Write(batch); err != nil {
+				return
+			}
+		}
+	}()
+	conn, err := ln.Accept()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readStrategy consumes one frame per b.Loop iteration from conn.
+type readStrategy struct {
+	name string
+	run  func(b *testing.B, conn net.Conn, payload int)
+}
+
+var readStrategies = []readStrategy{
+	// One io.ReadFull for the header and one for the body, straight
+	// from the socket: two syscalls per frame.
+	{"ReadFull", func(b *testing.B, conn net.Conn, payload int) {
+		var hdr [lengthHeader]byte
+		body := make([]byte, payload)
+		for b.Loop() {
+			if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+				b.Fatal(err)
+			}
+			n := binary.BigEndian.Uint32(hdr[:])
+			if _, err := io.ReadFull(conn, body[:n]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}},
+	// The same ReadFull calls through a bufio.Reader, which copies
+	// each frame out of its buffer.
+	bufioStrategy(4 << 10),
+	bufioStrategy(16 << 10),
+	bufioStrategy(64 << 10),
+	// conn.Read into a large buffer and decode in place (codec.Conn).
+	rawStrategy(4 << 10),
+	rawStrategy(16 << 10),
+	rawStrategy(64 << 10),
+}
+
+func bufioStrategy(size int) readStrategy {
+	return readStrategy{fmt.Sprintf("bufio-%dK", size>>10), func(b *testing.B, conn net.Conn, payload int) {
+		r := bufio.NewReaderSize(conn, size)
+		var hdr [lengthHeader]byte
+		body := make([]byte, payload)
+		for b.Loop() {
+			if _, err := io.ReadFull(r, hdr[:]); err != nil {
+				b.Fatal(err)
+			}
+			n := binary.BigEndian.Uint32(hdr[:])
+			if _, err := io.ReadFull(r, body[:n]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}}
+}
+
+func rawStrategy(size int) readStrategy {
+	return readStrategy{fmt.Sprintf("read-%dK", size>>10), func(b *testing.B, conn net.Conn, payload int) {
+		cc := NewConn(conn, NewLengthPrefixed(payload), size)
+		var msgs []Message
+		for b.Loop() {
+			for len(msgs) == 0 {
+				var err error
+				if msgs, err = cc.Next(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			msgs = msgs[1:]
+		}
+	}}
+}
+
+// BenchmarkReadStrategy compares ways of reading length-prefixed frames
+// from a socket, for small messages and for bulk transfer.
+func BenchmarkReadStrategy(b *testing.B) {
+	for _, payload := range []int{64, 64 << 10} {
+		for _, s := range readStrategies {
+			b.Run(fmt.Sprintf("%dB/%s", payload, s.name), func(b *testing.B) {
+				conn := streamFrames(b, payload)
+				b.SetBytes(int64(lengthHeader + payload))
+				b.ReportAllocs()
+				s.run(b, conn, payload)
+			})
+		}
+	}
+}
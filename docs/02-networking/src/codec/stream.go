@@ -0,0 +1,67 @@
+package codec
+
+// Stream decodes messages from bytes pushed to it as they arrive, for an
+// event loop that reads a connection with non-blocking calls into a
+// buffer it shares or pools. Conn owns its read buffer and blocks until a
+// message is complete; a loop can do neither. A read ends wherever the
+// kernel's queue did, often in the middle of a message, and the loop
+// moves on to the next connection.
+//
+// Feed decodes the complete messages in place and copies only the
+// incomplete tail into the Stream, so a connection holds a buffer of its
+// own only while a message is split across reads. The codec bounds that
+// buffer: a tail that cannot fit in a message of the codec's maximum size
+// fails with ErrTooLarge, however slowly it trickles in.
+type Stream struct {
+	codec Codec
+	buf   []byte // undecoded bytes carried over from earlier reads, from off
+	off   int
+	in    []byte // what Decode is given; a field, because a local would escape
+}
+
+// NewStream returns a Stream that decodes with c. The codec's scratch
+// state becomes the Stream's, so c must not be used elsewhere.
+func NewStream(c Codec) *Stream { return &Stream{codec: c} }
+
+// Feed decodes every message completed by p. Payloads are slices of p or
+// of the Stream's buffer, and like the returned slice are valid until the
+// next Feed or until p is reused. An error is a protocol error: the
+// messages before it are returned with it, and the stream cannot be
+// resumed.
+func (s *Stream) Feed(p []byte) ([]Message, error) {
+	// Messages from the last Feed may still point into buf until now.
+	if s.off > 0 {
+		n := copy(s.buf, s.buf[s.off:])
+		s.buf, s.off = s.buf[:n], 0
+	}
+
+	// Nothing carried over: decode p where it is and keep its tail.
+	if len(s.buf) == 0 {
+		s.in = p
+		msgs, err := s.codec.Decode(&s.in)
+		if err == nil && len(s.in) > 0 {
+			s.buf = append(s.buf, s.in...)
+		}
+		s.in = nil
+		return msgs, err
+	}
+
+	// Complete the carried-over message. The rest of p is appended with
+	// it, rather than decoded in place, because the next message may begin
+	// in the buffer's tail; it is one read's worth of copying, paid only
+	// on reads that follow a split.
+	s.buf = append(s.buf, p...)
+	s.in = s.buf
+	msgs, err := s.codec.Decode(&s.in)
+	if len(s.in) == 0 {
+		// Give the buffer up; msgs keep it alive for as long as they need.
+		s.buf, s.off = nil, 0
+	} else {
+		s.off = len(s.buf) - len(s.in)
+	}
+	s.in = nil
+	return msgs, err
+}
+
+// Buffered returns the bytes of an incomplete message the Stream holds.
+func (s *Stream) Buffered() int { return len(s.buf) - s.off }
@@ -0,0 +1,107 @@
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+// TestStream splits an encoded stream at random points, as non-blocking
+// reads do, reuses one read buffer for every piece, and checks that Feed
+// returns every message intact and in order.
+func TestStream(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, name := range Names {
+		t.Run(name, func(t *testing.T) {
+			enc, _ := New(name, 0)
+			var want [][]byte
+			var stream []byte
+			for i := range 500 {
+				m := benchPayload(10 + rng.IntN(3000))
+				m[len(m)-3] = byte('0' + i%10) // make them differ
+				want = append(want, m)
+				stream, _ = enc.Encode(stream, Message{Payload: m})
+			}
+			for _, maxRead := range []int{1, 7, 100, 4096, 1 << 20} {
+				dec, _ := New(name, 0)
+				s := NewStream(dec)
+				var got [][]byte
+				rbuf := make([]byte, maxRead)
+				for rest := stream; len(rest) > 0; {
+					n := copy(rbuf, rest[:min(len(rest), 1+rng.IntN(maxRead))])
+					rest = rest[n:]
+					msgs, err := s.Feed(rbuf[:n])
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, m := range msgs {
+						got = append(got, bytes.Clone(m.Payload))
+					}
+					clear(rbuf) // the next read overwrites it
+				}
+				if len(got) != len(want) {
+					t.Fatalf("reads of up to %d: %d messages, want %d", maxRead, len(got), len(want))
+				}
+				for i := range got {
+					if !bytes.Equal(got[i], want[i]) {
+						t.Fatalf("reads of up to %d: message %d differs", maxRead, i)
+					}
+				}
+				if s.Buffered() != 0 || s.buf != nil {
+					t.Errorf("reads of up to %d: %d bytes still buffered, buffer kept: %v", maxRead, s.Buffered(), s.buf != nil)
+				}
+			}
+		})
+	}
+}
+
+// TestStreamLimit checks that a message over the limit fails however
+// slowly it arrives, so a peer cannot grow a connection's buffer by
+// trickling bytes in.
+func TestStreamLimit(t *testing.T) {
+	for _, name := range Names {
+		t.Run(name, func(t *testing.T) {
+			c, _ := New(name, 100)
+			var frame []byte
+			if strings.HasPrefix(name, "length") {
+				frame = fmt.Appendf(nil, "\x00\x00\x00\x65%0101d", 0) // 101 bytes
+			} else {
+				frame = bytes.Repeat([]byte("1"), 200)
+			}
+			s := NewStream(c)
+			for i := range frame {
+				if _, err := s.Feed(frame[i : i+1]); err != nil {
+					if err != ErrTooLarge {
+						t.Fatalf("byte %d: %v, want ErrTooLarge", i, err)
+					}
+					if s.Buffered() > 100+lengthHeader {
+						t.Errorf("%d bytes buffered before the error", s.Buffered())
+					}
+					return
+				}
+			}
+			t.Fatal("no error for an oversized message")
+		})
+	}
+}
+
+// TestStreamAllocs checks that reads that end on message boundaries,
+// the common case for small requests, never make the Stream allocate.
+func TestStreamAllocs(t *testing.T) {
+	c := NewLengthPrefixed(0)
+	var read []byte
+	for range 10 {
+		read, _ = c.Encode(read, Message{Payload: benchPayload(64)})
+	}
+	s := NewStream(NewLengthPrefixed(0))
+	allocs := testing.AllocsPerRun(100, func() {
+		if msgs, err := s.Feed(read); err != nil || len(msgs) != 10 {
+			t.Fatalf("%d messages, %v", len(msgs), err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("%.1f allocations per read, want 0", allocs)
+	}
+}
@@ -0,0 +1,149 @@
+package conclimit
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+)
+
+// Config tunes an Adaptive limit. Zero fields take the defaults noted.
+type Config struct {
+	Initial    int           // limit to start from; 20
+	Min, Max   int           // bounds on the limit; 1 and 1000
+	Window     time.Duration // shortest span of one sample; 100ms
+	MinSamples int           // finished requests a sample needs; 10
+	Algorithm  Algorithm     // how the limit moves; a new Gradient
+	Clock      clock.Clock   // times requests and windows; the system clock
+}
+
+// Sample is what the requests finished in one window saw.
+type Sample struct {
+	RTT      time.Duration // mean latency, failed requests included
+	MinRTT   time.Duration // lowest latency of a request that succeeded
+	Count    int           // requests finished
+	Dropped  int           // requests that finished with ok false
+	InFlight int           // most requests in flight at once
+}
+
+// Algorithm computes the next limit from the current one and a sample.
+// Adaptive calls it with its lock held, so an Algorithm may keep state,
+// but one value must not be shared between limiters.
+type Algorithm interface {
+	Update(limit float64, s Sample) float64
+}
+
+// Adaptive is a limit moved by an Algorithm. Acquire takes no lock; done
+// takes one briefly to add the request to the current window, and the
+// last request of a window hands the sample to the Algorithm.
+type Adaptive struct {
+	cfg Config
+	now func() time.Time
+
+	limit    atomic.Int64
+	inFlight atomic.Int64
+	rejected atomic.Int64
+
+	mu          sync.Mutex
+	estimate    float64 // the limit before rounding
+	windowStart time.Time
+	sum         time.Duration
+	win         Sample
+}
+
+// NewAdaptive returns an Adaptive limit starting at cfg.Initial.
+func NewAdaptive(cfg Config) *Adaptive {
+	if cfg.Min <= 0 {
+		cfg.Min = 1
+	}
+	if cfg.Max <= 0 {
+		cfg.Max = 1000
+	}
+	if cfg.Initial <= 0 {
+		cfg.Initial = 20
+	}
+	cfg.Initial = min(max(cfg.Initial, cfg.Min), cfg.Max)
+	if cfg.Window <= 0 {
+		cfg.Window = 100 * time.Millisecond
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = 10
+	}
+	if cfg.Algorithm == nil {
+		cfg.Algorithm = &Gradient{}
+	}
+	a := &Adaptive{cfg: cfg, now: clock.Or(cfg.Clock).Now, estimate: float64(cfg.Initial)}
+	a.limit.Store(int64(cfg.Initial))
+	a.windowStart = a.now()
+	return a
+}
+
+func (a *Adaptive) Acquire() (func(ok bool), error) {
+	if !acquire(&a.inFlight, a.limit.Load()) {
+		a.rejected.Add(1)
+		return nil, ErrLimited
+	}
+	start := a.now()
+	return func(ok bool) {
+		inFlight := int(a.inFlight.Add(-1)) + 1 // this request included
+		end := a.now()
+		a.record(end, end.Sub(start), inFlight, ok)
+	}, nil
+}
+
+// record adds one finished request to the window and, once the window is
+// long enough and holds enough requests, updates the limit.
+func (a *Adaptive) record(now time.Time, rtt time.Duration, inFlight int, ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	w := &a.win
+	w.Count++
+	w.InFlight = max(w.InFlight, inFlight)
+	a.sum += rtt
+	if !ok {
+		w.Dropped++
+	} else if w.MinRTT == 0 || rtt < w.MinRTT {
+		w.MinRTT = rtt
+	}
+	if now.Sub(a.windowStart) < a.cfg.Window || w.Count < a.cfg.MinSamples {
+		return
+	}
+	w.RTT = a.sum / time.Duration(w.Count)
+	next := a.cfg.Algorithm.Update(a.estimate, *w)
+	// A window that used less than half of the limit says little about
+	// it. Raising the limit would leave it far above anything tested after
+	// a quiet period, and the next burst would get all of it; lowering it
+	// would shrink it on the jitter of requests that never queued. The
+	// Algorithm still sees the sample, which is the best view it gets of
+	// latency without a queue.
+	if float64(w.InFlight) < a.estimate/2 {
+		next = a.estimate
+	}
+	if math.IsNaN(next) {
+		next = a.estimate
+	}
+	a.estimate = min(max(next, float64(a.cfg.Min)), float64(a.cfg.Max))
+	a.limit.Store(int64(a.estimate))
+	a.win, a.sum, a.windowStart = Sample{}, 0, now
+}
+
+func (a *Adaptive) Limit() int    { return int(a.limit.Load()) }
+func (a *Adaptive) InFlight() int { return int(a.inFlight.Load()) }
+
+// Rejected returns how many requests Acquire has refused.
+func (a *Adaptive) Rejected() int64 { return a.rejected.Load() }
+
+// ServeHTTP reports the limit, the requests in flight and the rejections
+// so far as JSON.
+func (a *Adaptive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Limit    int   `json:"limit"`
+		InFlight int   `json:"in_flight"`
+		Rejected int64 `json:"rejected"`
+	}{a.Limit(), a.InFlight(), a.Rejected()})
+}
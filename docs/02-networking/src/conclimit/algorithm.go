@@ -0,0 +1,80 @@
+package conclimit
+
+import (
+	"math"
+	"time"
+)
+
+// AIMD raises the limit by a constant after every good window and cuts it
+// by a factor after a bad one, as TCP Reno does with its congestion
+// window. A window is bad if a request in it was dropped or, with Latency
+// set, if its mean latency exceeded Latency. Without Latency, AIMD only
+// backs off once requests fail, so the limit settles where timeouts
+// start: simple, but with the queue as long as the timeout allows.
+type AIMD struct {
+	Increase float64       // added per good window; 1
+	Backoff  float64       // factor per bad window; 0.9
+	Latency  time.Duration // mean latency that makes a window bad; 0 for none
+}
+
+func (g *AIMD) Update(limit float64, s Sample) float64 {
+	inc, backoff := g.Increase, g.Backoff
+	if inc <= 0 {
+		inc = 1
+	}
+	if backoff <= 0 || backoff >= 1 {
+		backoff = 0.9
+	}
+	if s.Dropped > 0 || (g.Latency > 0 && s.RTT > g.Latency) {
+		return limit * backoff
+	}
+	return limit + inc
+}
+
+// Gradient compares each window's latency with a baseline, the latency of
+// requests that did not queue, and scales the limit by their ratio, in the
+// manner of TCP Vegas and Netflix's gradient limiter. While latency stays
+// within Tolerance times the baseline, the limit grows by its square root
+// per window, room for a short queue that keeps the backend busy. Beyond
+// that, the limit shrinks in proportion to the excess, and by at most half
+// per window.
+//
+// The baseline is the lowest latency seen. A backend that got slower for
+// good would leave it too low and the limit too tight, so it drifts up by
+// Drift per window until a window's lowest latency pulls it back down.
+type Gradient struct {
+	Tolerance float64 // latency over baseline treated as no queue; 1.5
+	Smoothing float64 // weight of each new value in the limit; 0.2
+	Drift     float64 // fraction the baseline rises per window; 0.001
+
+	baseline time.Duration
+}
+
+func (g *Gradient) Update(limit float64, s Sample) float64 {
+	tolerance, smoothing, drift := g.Tolerance, g.Smoothing, g.Drift
+	if tolerance < 1 {
+		tolerance = 1.5
+	}
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = 0.2
+	}
+	if drift <= 0 {
+		drift = 0.001
+	}
+	switch {
+	case s.MinRTT > 0 && (g.baseline == 0 || s.MinRTT < g.baseline):
+		g.baseline = s.MinRTT
+	case g.baseline > 0:
+		g.baseline += time.Duration(float64(g.baseline) * drift)
+	}
+	if g.baseline == 0 {
+		// Nothing has succeeded yet to compare with.
+		if s.Dropped > 0 {
+			return limit * (1 - smoothing/2)
+		}
+		return limit
+	}
+	gradient := min(max(tolerance*float64(g.baseline)/float64(s.RTT), 0.5), 1)
+	next := limit*gradient + math.Sqrt(limit)
+	return limit*(1-smoothing) + next*smoothing
+}
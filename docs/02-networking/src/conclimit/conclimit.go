@@ -0,0 +1,96 @@
+// Package conclimit caps how many requests a server works on at once.
+//
+// A Static limit is a semaphore: the right number for one capacity and
+// the wrong one when the capacity changes. Set it for a healthy backend
+// and, once the backend slows down, requests queue behind it until they
+// time out. Set it for a degraded backend and a healthy one sits idle.
+// An Adaptive limit measures instead. It watches the latency of the
+// requests it lets through, one window at a time, and an Algorithm moves
+// the limit: up while latency stays near its baseline, down when requests
+// start queueing. Requests over the limit are refused at once, which is
+// cheaper for everyone than a response that comes too late.
+//
+//	l := conclimit.NewAdaptive(conclimit.Config{Algorithm: &conclimit.Gradient{}})
+//	done, err := l.Acquire()
+//	if err != nil {
+//		return err // conclimit.ErrLimited: shed the request
+//	}
+//	resp, err := serve(req)
+//	done(err == nil)
+//
+// Handler puts a Limiter in front of an http.Handler.
+package conclimit
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrLimited is returned by Acquire when the limit is reached.
+var ErrLimited = errors.New("conclimit: too many requests in flight")
+
+// Limiter admits requests up to a limit on how many are in flight.
+type Limiter interface {
+	// Acquire admits a request or returns ErrLimited. An admitted request
+	// calls done exactly once when it finishes, with ok false if it failed
+	// in a way that suggests overload, such as a timeout.
+	Acquire() (done func(ok bool), err error)
+	// Limit returns the current limit.
+	Limit() int
+	// InFlight returns how many admitted requests have not finished.
+	InFlight() int
+}
+
+// Static is a fixed limit.
+type Static struct {
+	limit    int64
+	inFlight atomic.Int64
+	rejected atomic.Int64
+}
+
+// NewStatic returns a limit of n requests in flight.
+func NewStatic(n int) *Static { return &Static{limit: int64(max(n, 1))} }
+
+func (s *Static) Acquire() (func(ok bool), error) {
+	if !acquire(&s.inFlight, s.limit) {
+		s.rejected.Add(1)
+		return nil, ErrLimited
+	}
+	return func(bool) { s.inFlight.Add(-1) }, nil
+}
+
+func (s *Static) Limit() int    { return int(s.limit) }
+func (s *Static) InFlight() int { return int(s.inFlight.Load()) }
+
+// Rejected returns how many requests Acquire has refused.
+func (s *Static) Rejected() int64 { return s.rejected.Load() }
+
+// acquire increments n if it is below limit.
+func acquire(n *atomic.Int64, limit int64) bool {
+	for {
+		cur := n.Load()
+		if cur >= limit {
+			return false
+		}
+		if n.CompareAndSwap(cur, cur+1) {
+			return true
+		}
+	}
+}
+
+// Handler admits requests to next through l. Refused requests get a 503
+// with Retry-After, and a request whose client went away before it was
+// answered counts as failed.
+func Handler(l Limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		done, err := l.Acquire()
+		if err != nil {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { done(r.Context().Err() == nil) }()
+		next.ServeHTTP(w, r)
+	})
+}
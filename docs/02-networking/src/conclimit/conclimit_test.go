@@ -0,0 +1,135 @@
+package conclimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+)
+
+func TestStatic(t *testing.T) {
+	s := NewStatic(2)
+	d1, err1 := s.Acquire()
+	_, err2 := s.Acquire()
+	if err1 != nil || err2 != nil {
+		t.Fatalf("Acquire within the limit: %v, %v", err1, err2)
+	}
+	if _, err := s.Acquire(); err != ErrLimited {
+		t.Fatalf("third Acquire: %v, want ErrLimited", err)
+	}
+	d1(true)
+	if _, err := s.Acquire(); err != nil {
+		t.Fatalf("Acquire after a release: %v", err)
+	}
+	if s.InFlight() != 2 || s.Rejected() != 1 {
+		t.Errorf("InFlight %d Rejected %d, want 2 and 1", s.InFlight(), s.Rejected())
+	}
+}
+
+// window starts n requests at once, lets them take rtt, or the length
+// of a window if longer, and returns the limit once they have finished.
+func window(a *Adaptive, c *clock.Fake, n int, rtt time.Duration, ok bool) int {
+	var dones []func(bool)
+	for range n {
+		if d, err := a.Acquire(); err == nil {
+			dones = append(dones, d)
+		}
+	}
+	c.Advance(max(rtt, a.cfg.Window))
+	for _, d := range dones {
+		d(ok)
+	}
+	return a.Limit()
+}
+
+// newAdaptiveT returns a limit on a fake clock, which lets a test decide
+// how long each request takes.
+func newAdaptiveT(alg Algorithm) (*Adaptive, *clock.Fake) {
+	c := clock.NewFake(time.Unix(0, 0))
+	return NewAdaptive(Config{Initial: 10, Max: 100, MinSamples: 5, Algorithm: alg, Clock: c}), c
+}
+
+func TestAIMD(t *testing.T) {
+	a, c := newAdaptiveT(&AIMD{}) // windows of 5 requests, limit 10
+	if got := window(a, c, 5, time.Millisecond, true); got != 11 {
+		t.Fatalf("limit %d after a good window, want 11", got)
+	}
+	if got := window(a, c, 6, time.Millisecond, false); got != 9 {
+		t.Fatalf("limit %d after a window of drops, want 11*0.9", got)
+	}
+	// A window that never had more than half of the limit in flight
+	// leaves it alone.
+	for range 5 {
+		window(a, c, 1, time.Millisecond, true)
+	}
+	if got := a.Limit(); got != 9 {
+		t.Fatalf("limit %d after an idle window, want it unchanged", got)
+	}
+	window(a, c, 12, time.Millisecond, true)
+	if a.Rejected() != 3 {
+		t.Errorf("Rejected %d, want the 3 requests over the limit of 9", a.Rejected())
+	}
+}
+
+func TestGradient(t *testing.T) {
+	g := &Gradient{}
+	limit := 20.0
+	// At the baseline latency the limit grows.
+	for range 5 {
+		limit = g.Update(limit, Sample{RTT: 10 * time.Millisecond, MinRTT: 10 * time.Millisecond, Count: 20, InFlight: 20})
+	}
+	if limit <= 20 {
+		t.Fatalf("limit %.1f at the baseline latency, want it above 20", limit)
+	}
+	// At four times the baseline it shrinks, but by no more than half a
+	// window's smoothing share at a time.
+	before := limit
+	limit = g.Update(limit, Sample{RTT: 40 * time.Millisecond, MinRTT: 35 * time.Millisecond, Count: 20, InFlight: 20})
+	if limit >= before || limit < before*0.9 {
+		t.Fatalf("limit %.1f after latency quadrupled from %.1f, want a cut of at most 10%%", limit, before)
+	}
+	for range 50 {
+		limit = g.Update(limit, Sample{RTT: 40 * time.Millisecond, MinRTT: 35 * time.Millisecond, Count: 20, InFlight: 20})
+	}
+	if limit > 10 {
+		t.Errorf("limit %.1f after 50 slow windows, want it down to a few", limit)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s := NewStatic(1)
+	hold, _ := s.Acquire()
+	h := Handler(s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("over the limit: %d, Retry-After %q; want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	hold(true)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || s.InFlight() != 0 {
+		t.Fatalf("within the limit: %d with %d in flight after", rec.Code, s.InFlight())
+	}
+}
+
+func BenchmarkAcquire(b *testing.B) {
+	for _, l := range []struct {
+		name string
+		l    Limiter
+	}{
+		{"static", NewStatic(1 << 20)},
+		{"adaptive", NewAdaptive(Config{Initial: 1 << 20, Max: 1 << 20})},
+	} {
+		b.Run(l.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					done, _ := l.l.Acquire()
+					done(true)
+				}
+			})
+		})
+	}
+}
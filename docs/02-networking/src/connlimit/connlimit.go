@@ -0,0 +1,229 @@
+// Package connlimit caps how many connections a server holds open.
+//
+// Every connection costs the server something whether or not it sends
+// anything: a goroutine and its buffers, or an fd, a registration and a
+// client struct in an event loop. A server that accepts every connection
+// it is offered keeps taking on that cost under overload, until memory or
+// file descriptors run out and it fails every client at once. A limit
+// keeps the connections it has served at full speed and pushes back on
+// the rest, in one of two ways:
+//
+//   - Pause stops accepting at the limit. New connections complete their
+//     handshake in the kernel and wait in the listen backlog; once that is
+//     full the kernel drops further SYNs, and clients retransmit them with
+//     backoff. The server spends nothing on them, and a client sees
+//     latency rather than an error. It suits short bursts.
+//   - Reject keeps accepting and closes each connection over the limit at
+//     once, after a Busy reply where the protocol has a way to say so. A
+//     client learns in one round trip and can go elsewhere, at the cost of
+//     an accept and a close for each.
+//
+// A Limit is the semaphore both share. Listener puts one in front of a
+// net.Listener for the goroutine-per-connection servers; an event loop
+// calls Acquire before it accepts, or after, and Release as it closes a
+// connection.
+package connlimit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+)
+
+// Limit is a counting semaphore over open connections.
+type Limit struct {
+	slots chan struct{}
+}
+
+// New returns a limit of n open connections, at least one.
+func New(n int) *Limit { return &Limit{slots: make(chan struct{}, max(n, 1))} }
+
+// Acquire takes a slot if one is free and reports whether it did.
+func (l *Limit) Acquire() bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait takes a slot, waiting for one to be released, and reports false
+// if done is closed first.
+func (l *Limit) Wait(done <-chan struct{}) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Release frees a slot taken by Acquire or Wait.
+func (l *Limit) Release() {
+	select {
+	case <-l.slots:
+	default:
+		panic("connlimit: Release without Acquire")
+	}
+}
+
+// Open returns how many slots are taken.
+func (l *Limit) Open() int { return len(l.slots) }
+
+// Max returns the limit.
+func (l *Limit) Max() int { return cap(l.slots) }
+
+// Mode is what a server does with connections over its limit.
+type Mode int
+
+const (
+	Pause  Mode = iota // leave them in the listen backlog
+	Reject             // accept them and close them at once
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Pause:
+		return "pause"
+	case Reject:
+		return "reject"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// Set parses "pause" or "reject", so that a Mode can be a flag.Value.
+func (m *Mode) Set(s string) error {
+	switch s {
+	case "pause":
+		*m = Pause
+	case "reject":
+		*m = Reject
+	default:
+		return fmt.Errorf("connlimit: unknown mode %q, want pause or reject", s)
+	}
+	return nil
+}
+
+// Busy is the payload a framed server sends a connection it rejects before
+// closing it, the counterpart of an HTTP 503. It is a JSON object so that
+// it is a valid message for every codec, including jsonl.
+var Busy = []byte(`{"error":"busy","status":503}`)
+
+// ErrBusy is what a client reports on receiving Busy.
+var ErrBusy = errors.New("connlimit: server busy")
+
+// IsBusy reports whether a message's payload is Busy.
+func IsBusy(payload []byte) bool { return string(payload) == string(Busy) }
+
+// BusyReply returns Busy framed by c, ready to write to a connection.
+func BusyReply(c codec.Codec) []byte {
+	b, err := c.Encode(nil, codec.Message{Payload: Busy})
+	if err != nil {
+		panic(err) // Busy fits every codec
+	}
+	return b
+}
+
+// Listener limits the connections accepted from a net.Listener and still
+// open. Accepted connections are wrapped so that closing one releases its
+// slot, once however often it is closed; a handler that needs the
+// underlying *net.TCPConn should wrap before it, as the chaos listener
+// does.
+type Listener struct {
+	net.Listener
+	limit *Limit
+	mode  Mode
+	reply []byte
+
+	done      chan struct{}
+	closeOnce sync.Once
+	rejected  atomic.Int64
+	waits     atomic.Int64
+}
+
+// NewListener wraps ln. In Reject mode a connection over the limit is
+// sent reply, if it is not nil, and closed; without a reply it is reset.
+func NewListener(ln net.Listener, l *Limit, mode Mode, reply []byte) *Listener {
+	return &Listener{Listener: ln, limit: l, mode: mode, reply: reply, done: make(chan struct{})}
+}
+
+// Accept returns the next connection under the limit. In Pause mode it
+// does not call the wrapped Accept until a slot is free, so connections
+// wait in the kernel; Close stops the wait.
+func (ln *Listener) Accept() (net.Conn, error) {
+	if ln.mode == Pause {
+		if !ln.limit.Acquire() {
+			ln.waits.Add(1)
+			if !ln.limit.Wait(ln.done) {
+				return nil, net.ErrClosed
+			}
+		}
+		c, err := ln.Listener.Accept()
+		if err != nil {
+			ln.limit.Release()
+			return nil, err
+		}
+		return &conn{Conn: c, limit: ln.limit}, nil
+	}
+	for {
+		c, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if ln.limit.Acquire() {
+			return &conn{Conn: c, limit: ln.limit}, nil
+		}
+		ln.rejected.Add(1)
+		reject(c, ln.reply)
+	}
+}
+
+// Close closes the wrapped listener and ends a paused Accept.
+func (ln *Listener) Close() error {
+	ln.closeOnce.Do(func() { close(ln.done) })
+	return ln.Listener.Close()
+}
+
+// Rejected returns how many connections were closed over the limit.
+func (ln *Listener) Rejected() int64 { return ln.rejected.Load() }
+
+// Waits returns how many times Accept found the limit reached and waited.
+func (ln *Listener) Waits() int64 { return ln.waits.Load() }
+
+// rejectTimeout bounds the write of a reply. A fresh socket has its whole
+// send buffer free, so the write does not block unless the reply is huge.
+const rejectTimeout = 100 * time.Millisecond
+
+// reject sends reply to c and closes it, or resets it without one, as
+// ratelimit.Listener does: the client learns at once, and the server keeps
+// no TIME_WAIT entry.
+func reject(c net.Conn, reply []byte) {
+	if reply == nil {
+		if tc, ok := c.(*net.TCPConn); ok {
+			tc.SetLinger(0)
+		}
+		c.Close()
+		return
+	}
+	c.SetWriteDeadline(time.Now().Add(rejectTimeout))
+	c.Write(reply)
+	c.Close()
+}
+
+type conn struct {
+	net.Conn
+	limit *Limit
+	once  sync.Once
+}
+
+func (c *conn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.limit.Release)
+	return err
+}
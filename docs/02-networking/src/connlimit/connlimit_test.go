@@ -0,0 +1,172 @@
+package connlimit
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+)
+
+func TestLimit(t *testing.T) {
+	l := New(2)
+	if !l.Acquire() || !l.Acquire() {
+		t.Fatal("slots under the limit refused")
+	}
+	if l.Acquire() {
+		t.Fatal("slot over the limit taken")
+	}
+	done := make(chan struct{})
+	close(done)
+	if l.Wait(done) {
+		t.Fatal("Wait took a slot after done")
+	}
+	l.Release()
+	if !l.Wait(nil) || l.Open() != 2 {
+		t.Fatalf("Wait after a Release: open %d", l.Open())
+	}
+	if New(0).Max() != 1 {
+		t.Error("a limit of 0 should be 1")
+	}
+}
+
+func TestMode(t *testing.T) {
+	var m Mode
+	if err := m.Set("reject"); err != nil || m != Reject || m.String() != "reject" {
+		t.Errorf("Set(reject): %v, %v", m, err)
+	}
+	if err := m.Set("queue"); err == nil {
+		t.Error("Set(queue) accepted")
+	}
+}
+
+func listen(t *testing.T, n int, mode Mode, reply []byte) *Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(ln, New(n), mode, reply)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func dial(t *testing.T, ln net.Listener) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// accept runs Accept in the background.
+func accept(ln net.Listener) <-chan net.Conn {
+	ch := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			close(ch)
+			return
+		}
+		ch <- c
+	}()
+	return ch
+}
+
+func TestPause(t *testing.T) {
+	ln := listen(t, 1, Pause, nil)
+	dial(t, ln)
+	dial(t, ln) // handshakes in the kernel and waits in the backlog
+	first := <-accept(ln)
+	second := accept(ln)
+	select {
+	case <-second:
+		t.Fatal("accepted over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.Close()
+	first.Close() // releases once
+	select {
+	case c := <-second:
+		if c == nil {
+			t.Fatal("Accept failed")
+		}
+		c.Close()
+	case <-time.After(time.Second):
+		t.Fatal("not accepted after a slot was released")
+	}
+	if ln.Waits() != 1 || ln.limit.Open() != 0 {
+		t.Errorf("waits %d, open %d", ln.Waits(), ln.limit.Open())
+	}
+
+	// Close ends a paused Accept.
+	ln.limit.Acquire()
+	waiting := accept(ln)
+	ln.Close()
+	select {
+	case c := <-waiting:
+		if c != nil {
+			t.Fatal("accepted after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not end the wait")
+	}
+}
+
+// A rejected client gets Busy even if it sent its request first.
+func TestReject(t *testing.T) {
+	c := codec.NewLine(0)
+	ln := listen(t, 1, Reject, BusyReply(c))
+	dial(t, ln)
+	kept := <-accept(ln)
+	accept(ln) // rejects the next one and waits on
+
+	over := dial(t, ln)
+	over.Write([]byte("hello\n"))
+	cc := codec.NewConn(over, c, 0)
+	over.SetReadDeadline(time.Now().Add(time.Second))
+	msgs, err := cc.Next()
+	if err != nil || len(msgs) != 1 || !IsBusy(msgs[0].Payload) {
+		t.Fatalf("rejected client read %q, %v; want Busy", msgs, err)
+	}
+	if _, err := cc.Next(); err == nil {
+		t.Error("rejected connection left open")
+	}
+	if ln.Rejected() != 1 {
+		t.Errorf("rejected %d", ln.Rejected())
+	}
+	kept.Close()
+}
+
+func TestRejectReset(t *testing.T) {
+	ln := listen(t, 1, Reject, nil)
+	dial(t, ln)
+	kept := <-accept(ln)
+	accept(ln)
+	// On loopback the reset can beat the end of the client's connect.
+	over, err := net.Dial("tcp", ln.Addr().String())
+	if err == nil {
+		defer over.Close()
+		over.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = over.Read(make([]byte, 1))
+	}
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("got %v, want a reset", err)
+	}
+	kept.Close()
+}
+
+func TestBusyReply(t *testing.T) {
+	for _, name := range codec.Names {
+		c, _ := codec.New(name, 0)
+		buf := BusyReply(c)
+		msgs, err := c.Decode(&buf)
+		if err != nil || len(msgs) != 1 || !IsBusy(msgs[0].Payload) {
+			t.Errorf("%s: decoded %q, %v", name, msgs, err)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Package connmgr keeps one client connection to a server usable for as
+// long as the client runs, through server restarts, resets and hangs.
+//
+// A Manager dials lazily and redials after a failure. Three mechanisms
+// keep the redialing in check:
+//
+//   - Liveness pings. Run pings the connection after it has been idle for
+//     PingInterval. A peer that went away without a FIN or RST is found
+//     by the ping instead of by the next real request.
+//   - Jittered exponential backoff. The first redial after a connection
+//     that worked is immediate. Each further consecutive failure doubles
+//     the wait, up to MaxBackoff, and the wait is drawn at random from its
+//     upper half. Clients that lost the server at the same moment then do
+//     not come back at the same moment.
+//   - A circuit breaker. After Threshold consecutive failures the Manager
+//     stops dialing for OpenFor and fails calls at once with ErrOpen.
+//     After that one call is let through as a probe, and its result closes
+//     the circuit or opens it again.
+//
+// A failure is a failed dial, a failed call or a failed ping. A successful
+// dial alone does not count as success, so a server that accepts
+// connections and then resets them still opens the circuit.
+//
+//	m := connmgr.New(dial, connmgr.Config{})
+//	go m.Run(ctx)
+//	defer m.Close()
+//	err := m.Do(ctx, func(c connmgr.Conn) error {
+//		return c.(*myConn).roundTrip(req)
+//	})
+package connmgr
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+)
+
+var (
+	// ErrOpen is returned by Do while the circuit is open.
+	ErrOpen = errors.New("connmgr: circuit open")
+	// ErrClosed is returned by Do after Close.
+	ErrClosed = errors.New("connmgr: closed")
+)
+
+// DialError wraps an error from the Dialer, so that callers can tell a
+// server that could not be reached from a request that failed.
+type DialError struct{ Err error }
+
+func (e *DialError) Error() string { return "connmgr: dial: " + e.Err.Error() }
+func (e *DialError) Unwrap() error { return e.Err }
+
+// Conn is a connection the Manager keeps alive. Ping sends something the
+// server must answer and waits for the answer until ctx is done.
+type Conn interface {
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Dialer opens a Conn.
+type Dialer func(ctx context.Context) (Conn, error)
+
+// Config tunes a Manager. Zero fields take the defaults noted.
+type Config struct {
+	PingInterval time.Duration // idle time before a liveness ping; 1s
+	PingTimeout  time.Duration // how long a ping may take; 1s
+	MinBackoff   time.Duration // wait after the second consecutive failure; 50ms
+	MaxBackoff   time.Duration // cap on the wait; 5s
+	Threshold    int           // consecutive failures that open the circuit; 5
+	OpenFor      time.Duration // how long an open circuit rejects calls; 5s
+	Clock        clock.Clock   // schedules pings and times backoff and the circuit; the system clock
+}
+
+// Stats counts what a Manager has done.
+type Stats struct {
+	Dials        int64 // dial attempts
+	DialErrors   int64
+	Drops        int64 // connections closed after a failed call or ping
+	Pings        int64
+	PingFailures int64
+	Opens        int64 // times the circuit opened, including after a failed probe
+	Rejected     int64 // calls failed with ErrOpen
+}
+
+// Add adds o to s, for totals over many Managers.
+func (s *Stats) Add(o Stats) {
+	s.Dials += o.Dials
+	s.DialErrors += o.DialErrors
+	s.Drops += o.Drops
+	s.Pings += o.Pings
+	s.PingFailures += o.PingFailures
+	s.Opens += o.Opens
+	s.Rejected += o.Rejected
+}
+
+// Manager owns one connection. Do and the pings in Run take turns on it,
+// so a Conn never sees two calls at once.
+type Manager struct {
+	dial Dialer
+	cfg  Config
+
+	mu        sync.Mutex // held while the connection is dialed, used or pinged
+	conn      Conn
+	lastUse   time.Time
+	failures  int       // consecutive failures
+	next      time.Time // no dial before this
+	openUntil time.Time // circuit open until this; zero when closed
+	closed    bool
+
+	dials, dialErrors, drops, pings, pingFailures, opens, rejected atomic.Int64
+}
+
+// New returns a Manager that dials with dial. It does not dial until the
+// first Do or the first check in Run.
+func New(dial Dialer, cfg Config) *Manager {
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = time.Second
+	}
+	if cfg.PingTimeout <= 0 {
+		cfg.PingTimeout = time.Second
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 50 * time.Millisecond
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = max(5*time.Second, cfg.MinBackoff)
+	}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 5
+	}
+	if cfg.OpenFor <= 0 {
+		cfg.OpenFor = 5 * time.Second
+	}
+	cfg.Clock = clock.Or(cfg.Clock)
+	return &Manager{dial: dial, cfg: cfg}
+}
+
+// Do calls f with the connection, dialing first if there is none. Any
+// error from f is taken to mean the connection is broken: it is closed
+// and the next call redials. While the Manager is backing off, Do waits
+// for the backoff to end or ctx to be done. While the circuit is open it
+// returns ErrOpen without waiting.
+func (m *Manager) Do(ctx context.Context, f func(Conn) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	if m.conn == nil {
+		if m.cfg.Clock.Now().Before(m.openUntil) {
+			m.rejected.Add(1)
+			return ErrOpen
+		}
+		if wait := m.next.Sub(m.cfg.Clock.Now()); wait > 0 {
+			t := m.cfg.Clock.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C():
+			}
+		}
+		if err := m.connect(ctx); err != nil {
+			return err
+		}
+	}
+	err := f(m.conn)
+	m.lastUse = m.cfg.Clock.Now()
+	if err != nil {
+		m.drop()
+		return err
+	}
+	m.succeeded()
+	return nil
+}
+
+// Run pings the connection when it has been idle for PingInterval, and
+// redials a lost connection in the background once the backoff or open
+// circuit allows, until ctx is done.
+func (m *Manager) Run(ctx context.Context) {
+	tick := m.cfg.Clock.NewTicker(m.cfg.PingInterval / 2)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C():
+		}
+		m.check(ctx)
+	}
+}
+
+func (m *Manager) check(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.cfg.Clock.Now()
+	switch {
+	case m.closed:
+	case m.conn != nil:
+		if now.Sub(m.lastUse) < m.cfg.PingInterval {
+			return
+		}
+		m.pings.Add(1)
+		pctx, cancel := context.WithTimeout(ctx, m.cfg.PingTimeout)
+		err := m.conn.Ping(pctx)
+		cancel()
+		m.lastUse = m.cfg.Clock.Now()
+		if err != nil {
+			m.pingFailures.Add(1)
+			m.drop()
+			return
+		}
+		m.succeeded()
+	case now.Before(m.openUntil), now.Before(m.next):
+	default:
+		m.connect(ctx)
+	}
+}
+
+// Stats returns the Manager's counters.
+func (m *Manager) Stats() Stats {
+	return Stats{
+		Dials:        m.dials.Load(),
+		DialErrors:   m.dialErrors.Load(),
+		Drops:        m.drops.Load(),
+		Pings:        m.pings.Load(),
+		PingFailures: m.pingFailures.Load(),
+		Opens:        m.opens.Load(),
+		Rejected:     m.rejected.Load(),
+	}
+}
+
+// Close closes the connection, if any, and makes every later Do fail with
+// ErrClosed. It waits for a call in progress to return.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	if m.conn == nil {
+		return nil
+	}
+	err := m.conn.Close()
+	m.conn = nil
+	return err
+}
+
+// connect dials once. m.mu is held.
+func (m *Manager) connect(ctx context.Context) error {
+	m.dials.Add(1)
+	conn, err := m.dial(ctx)
+	if err != nil {
+		m.dialErrors.Add(1)
+		m.failed()
+		return &DialError{Err: err}
+	}
+	m.conn = conn
+	m.lastUse = m.cfg.Clock.Now()
+	return nil
+}
+
+// drop closes a connection that failed. m.mu is held.
+func (m *Manager) drop() {
+	m.drops.Add(1)
+	m.conn.Close()
+	m.conn = nil
+	m.failed()
+}
+
+// failed records a consecutive failure and sets when the next dial may
+// happen. m.mu is held.
+func (m *Manager) failed() {
+	m.failures++
+	now := m.cfg.Clock.Now()
+	if m.failures >= m.cfg.Threshold {
+		m.opens.Add(1)
+		m.openUntil = now.Add(m.cfg.OpenFor)
+		m.next = m.openUntil
+		return
+	}
+	m.next = now.Add(backoff(m.failures, m.cfg.MinBackoff, m.cfg.MaxBackoff))
+}
+
+// succeeded closes the circuit. m.mu is held.
+func (m *Manager) succeeded() {
+	m.failures = 0
+	m.openUntil = time.Time{}
+}
+
+// backoff returns the wait after the nth consecutive failure: none after
+// the first, then lo doubling per failure up to hi, drawn uniformly from
+// the upper half of that.
+func backoff(n int, lo, hi time.Duration) time.Duration {
+	if n <= 1 {
+		return 0
+	}
+	d := hi
+	if shift := n - 2; shift < 32 && lo<<shift < hi {
+		d = lo << shift
+	}
+	return d - rand.N(d/2+1)
+}
@@ -0,0 +1,399 @@
+package connmgr
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/chaos"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+)
+
+// server is a line echo server whose connections go through a chaos
+// Injector, and which can be stopped and restarted on the same port.
+type server struct {
+	t      *testing.T
+	faults *chaos.Injector
+	addr   string
+
+	mu    sync.Mutex
+	ln    net.Listener
+	conns []net.Conn
+}
+
+func startServer(t *testing.T) *server {
+	s := &server{t: t, faults: chaos.New(), addr: "127.0.0.1:0"}
+	s.start()
+	s.addr = s.ln.Addr().String()
+	t.Cleanup(s.stop)
+	return s
+}
+
+func (s *server) start() {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+	ln = s.faults.Listen(ln)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, c)
+			s.mu.Unlock()
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					line, err := r.ReadBytes('\n')
+					if err != nil {
+						return
+					}
+					if _, err := c.Write(line); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+}
+
+// stop closes the listener and every connection, like a server that exits.
+func (s *server) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln != nil {
+		s.ln.Close()
+		s.ln = nil
+	}
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+// lineConn is the client side: each call writes a line and reads it back.
+type lineConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (s *server) dial(ctx context.Context) (Conn, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &lineConn{Conn: c, r: bufio.NewReader(c)}, nil
+}
+
+func (c *lineConn) roundTrip(deadline time.Time) error {
+	c.SetDeadline(deadline)
+	if _, err := c.Write([]byte("hello\n")); err != nil {
+		return err
+	}
+	_, err := c.r.ReadBytes('\n')
+	return err
+}
+
+func (c *lineConn) Ping(ctx context.Context) error {
+	deadline, _ := ctx.Deadline()
+	return c.roundTrip(deadline)
+}
+
+func call(ctx context.Context, m *Manager) error {
+	return m.Do(ctx, func(c Conn) error {
+		return c.(*lineConn).roundTrip(time.Now().Add(time.Second))
+	})
+}
+
+// fast keeps the tests short: no wait before the second redial beyond a
+// few milliseconds, and a circuit that stays open for 100ms.
+var fast = Config{
+	PingInterval: 20 * time.Millisecond,
+	PingTimeout:  50 * time.Millisecond,
+	MinBackoff:   5 * time.Millisecond,
+	MaxBackoff:   20 * time.Millisecond,
+	Threshold:    3,
+	OpenFor:      100 * time.Millisecond,
+}
+
+func TestReconnectAfterReset(t *testing.T) {
+	s := startServer(t)
+	m := New(s.dial, fast)
+	defer m.Close()
+	ctx := context.Background()
+
+	if err := call(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	// The server is already blocked in a Read that started before the
+	// change, so the call after it is the one that gets the reset.
+	s.faults.Set(chaos.Config{ResetP: 1})
+	if err := call(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := call(ctx, m); err == nil {
+		t.Fatal("call through a reset succeeded")
+	}
+	s.faults.Set(chaos.Config{})
+	if err := call(ctx, m); err != nil {
+		t.Fatalf("call after the reset: %v", err)
+	}
+	if st := m.Stats(); st.Dials != 2 || st.Drops != 1 || st.Opens != 0 {
+		t.Errorf("stats %+v, want 2 dials, 1 drop and a closed circuit", st)
+	}
+}
+
+// withFakeClock returns fast on a fake clock. Backoff and the open
+// circuit then last until the test advances the clock past them.
+func withFakeClock() (Config, *clock.Fake) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	cfg := fast
+	cfg.Clock = clk
+	return cfg, clk
+}
+
+func TestCircuitOpens(t *testing.T) {
+	s := startServer(t)
+	cfg, clk := withFakeClock()
+	m := New(s.dial, cfg)
+	defer m.Close()
+	ctx := context.Background()
+
+	// The server accepts every connection and resets it on the first read.
+	s.faults.Set(chaos.Config{ResetP: 1})
+	for i := range fast.Threshold {
+		clk.Advance(fast.MaxBackoff)
+		if err := call(ctx, m); err == nil || errors.Is(err, ErrOpen) {
+			t.Fatalf("call %d: %v, want a reset", i, err)
+		}
+	}
+	start := time.Now()
+	if err := call(ctx, m); !errors.Is(err, ErrOpen) {
+		t.Fatalf("call after %d failures: %v, want ErrOpen", fast.Threshold, err)
+	}
+	if d := time.Since(start); d > 10*time.Millisecond {
+		t.Errorf("open circuit took %v to reject", d)
+	}
+	dials := m.Stats().Dials
+
+	// The probe after OpenFor fails and opens the circuit again.
+	clk.Advance(fast.OpenFor - time.Nanosecond)
+	if err := call(ctx, m); !errors.Is(err, ErrOpen) {
+		t.Fatalf("call just before OpenFor: %v, want ErrOpen", err)
+	}
+	clk.Advance(time.Nanosecond)
+	if err := call(ctx, m); err == nil || errors.Is(err, ErrOpen) {
+		t.Fatalf("probe: %v, want a reset", err)
+	}
+	if err := call(ctx, m); !errors.Is(err, ErrOpen) {
+		t.Fatalf("after a failed probe: %v, want ErrOpen", err)
+	}
+
+	// The next probe succeeds and closes it.
+	s.faults.Set(chaos.Config{})
+	clk.Advance(fast.OpenFor)
+	if err := call(ctx, m); err != nil {
+		t.Fatalf("probe after recovery: %v", err)
+	}
+	if err := call(ctx, m); err != nil {
+		t.Fatalf("call after recovery: %v", err)
+	}
+	st := m.Stats()
+	if st.Dials != dials+2 || st.Opens != 2 || st.Rejected != 3 {
+		t.Errorf("stats %+v, want %d dials, 2 opens, 3 rejected", st, dials+2)
+	}
+}
+
+func TestServerDown(t *testing.T) {
+	s := startServer(t)
+	cfg, clk := withFakeClock()
+	m := New(s.dial, cfg)
+	defer m.Close()
+	ctx := context.Background()
+
+	if err := call(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	s.stop()
+	var dialErrs int
+	for i := 0; i < fast.Threshold+2; i++ {
+		clk.Advance(fast.MaxBackoff)
+		err := call(ctx, m)
+		var de *DialError
+		if errors.As(err, &de) {
+			dialErrs++
+		}
+		if err == nil {
+			t.Fatalf("call %d to a stopped server succeeded", i)
+		}
+	}
+	// One call fails on the dead connection, the rest of the threshold on
+	// refused dials, and then the circuit rejects without dialing.
+	if want := fast.Threshold - 1; dialErrs != want {
+		t.Errorf("%d dial errors, want %d", dialErrs, want)
+	}
+
+	s.start()
+	clk.Advance(fast.OpenFor)
+	if err := call(ctx, m); err != nil {
+		t.Fatalf("call after restart: %v", err)
+	}
+}
+
+func TestPingFindsHungServer(t *testing.T) {
+	s := startServer(t)
+	m := New(s.dial, fast)
+	defer m.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	if err := call(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	// The server stops answering but keeps the connection open. Only the
+	// ping can notice; the Manager then redials in the background.
+	s.faults.Set(chaos.Config{DropWriteP: 1})
+	waitFor(t, "a failed ping", func() bool { return m.Stats().PingFailures > 0 })
+	s.faults.Set(chaos.Config{})
+	waitFor(t, "a background redial", func() bool { return m.Stats().Dials >= 2 })
+	waitFor(t, "a good ping", func() bool {
+		st := m.Stats()
+		return st.Pings > st.PingFailures+1
+	})
+	if err := call(ctx, m); err != nil {
+		t.Fatalf("call after the server recovered: %v", err)
+	}
+}
+
+// pingConn is a Conn that reports each ping's time on the Manager's
+// clock.
+type pingConn struct {
+	clk   clock.Clock
+	pings chan time.Time
+}
+
+func (c *pingConn) Ping(context.Context) error {
+	c.pings <- c.clk.Now()
+	return nil
+}
+
+func (c *pingConn) Close() error { return nil }
+
+// TestPingAfterIdle checks on a fake clock that Run pings a connection
+// once it has been idle for PingInterval, and not on the check before.
+func TestPingAfterIdle(t *testing.T) {
+	cfg, clk := withFakeClock()
+	conn := &pingConn{clk: clk, pings: make(chan time.Time, 1)}
+	m := New(func(context.Context) (Conn, error) { return conn, nil }, cfg)
+	defer m.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	start := clk.Now()
+	if err := m.Do(ctx, func(Conn) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	clk.BlockUntil(1)
+	clk.Advance(cfg.PingInterval / 2)
+	clk.Advance(cfg.PingInterval / 2)
+	if at := (<-conn.pings).Sub(start); at != cfg.PingInterval {
+		t.Errorf("pinged %v after the last call, want %v", at, cfg.PingInterval)
+	}
+}
+
+// TestFlapping switches the server between resetting every connection and
+// working every 50ms, under a client calling every 2ms. The breaker and
+// backoff must keep dials well below calls, and the client must recover
+// once the server settles.
+func TestFlapping(t *testing.T) {
+	s := startServer(t)
+	cfg := fast
+	cfg.OpenFor = 30 * time.Millisecond
+	m := New(s.dial, cfg)
+	defer m.Close()
+	ctx := context.Background()
+
+	var calls, ok int
+	end := time.Now().Add(time.Second)
+	for phase := 0; time.Now().Before(end); phase++ {
+		if phase%2 == 0 {
+			s.faults.Set(chaos.Config{ResetP: 1})
+		} else {
+			s.faults.Set(chaos.Config{})
+		}
+		for flip := time.Now().Add(50 * time.Millisecond); time.Now().Before(flip); {
+			calls++
+			if call(ctx, m) == nil {
+				ok++
+			}
+			time.Sleep(2 * time.Millisecond)
+		}
+	}
+	s.faults.Set(chaos.Config{})
+	st := m.Stats()
+	t.Logf("%d calls, %d ok, %+v", calls, ok, st)
+	if ok == 0 {
+		t.Error("no call succeeded in the good phases")
+	}
+	if st.Opens == 0 || st.Rejected == 0 {
+		t.Error("the circuit never opened")
+	}
+	if st.Dials*2 > int64(calls) {
+		t.Errorf("%d dials for %d calls", st.Dials, calls)
+	}
+	time.Sleep(cfg.OpenFor)
+	if err := call(ctx, m); err != nil {
+		t.Errorf("call after the server settled: %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	const lo, hi = 10 * time.Millisecond, time.Second
+	if d := backoff(1, lo, hi); d != 0 {
+		t.Errorf("backoff after one failure = %v, want 0", d)
+	}
+	for n, ceil := range map[int]time.Duration{2: lo, 3: 2 * lo, 5: 8 * lo, 9: hi, 100: hi} {
+		for range 100 {
+			if d := backoff(n, lo, hi); d < ceil/2 || d > ceil {
+				t.Fatalf("backoff(%d) = %v, want [%v, %v]", n, d, ceil/2, ceil)
+			}
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
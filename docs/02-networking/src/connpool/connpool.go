@@ -0,0 +1,136 @@
+// Package connpool keeps idle client connections for reuse, so that a
+// client making many short requests opens a handful of connections instead
+// of one per request.
+//
+// Every connection a client closes first sits in TIME_WAIT for a minute
+// and holds its local port for that long. A client that dials once per
+// request therefore runs out of ephemeral ports at a few hundred requests
+// per second to one server address; see the portexhaust experiment. Reusing
+// connections removes the problem at its source:
+//
+//	p := connpool.New(func(ctx context.Context) (net.Conn, error) {
+//		return d.DialContext(ctx, "tcp", addr)
+//	}, 64)
+//	conn, err := p.Get(ctx)
+//	...
+//	if err != nil {
+//		conn.Close() // a failed connection is never returned
+//	} else {
+//		p.Put(conn)
+//	}
+package connpool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned by Get after Close.
+var ErrClosed = errors.New("connpool: closed")
+
+// Pool is a LIFO stack of idle connections. Reusing the most recently
+// returned connection first lets the rest age out under IdleTimeout when
+// load drops. A Pool is safe for concurrent use.
+type Pool struct {
+	dial    func(context.Context) (net.Conn, error)
+	maxIdle int
+
+	// IdleTimeout closes connections that have been idle longer, instead
+	// of handing them out: servers drop idle connections, and a request
+	// written to one that is already gone fails. Zero keeps them forever.
+	// Set it before the first Get.
+	IdleTimeout time.Duration
+
+	mu     sync.Mutex
+	idle   []idleConn
+	closed bool
+
+	dials, reuses, expired atomic.Uint64
+}
+
+type idleConn struct {
+	conn  net.Conn
+	since time.Time
+}
+
+// Stats counts what a Pool has done.
+type Stats struct {
+	Dials   uint64 // connections opened
+	Reuses  uint64 // Gets served from the idle stack
+	Expired uint64 // idle connections closed by IdleTimeout
+	Idle    int    // connections idle now
+}
+
+// New returns a Pool that opens connections with dial and keeps up to
+// maxIdle of them idle.
+func New(dial func(context.Context) (net.Conn, error), maxIdle int) *Pool {
+	return &Pool{dial: dial, maxIdle: maxIdle}
+}
+
+// Get returns an idle connection, or dials a new one if there is none.
+func (p *Pool) Get(ctx context.Context) (net.Conn, error) {
+	p.mu.Lock()
+	for len(p.idle) > 0 {
+		ic := p.idle[len(p.idle)-1]
+		p.idle[len(p.idle)-1] = idleConn{}
+		p.idle = p.idle[:len(p.idle)-1]
+		if p.IdleTimeout > 0 && time.Since(ic.since) > p.IdleTimeout {
+			p.expired.Add(1)
+			ic.conn.Close()
+			continue
+		}
+		p.mu.Unlock()
+		p.reuses.Add(1)
+		return ic.conn, nil
+	}
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.dials.Add(1)
+	return conn, nil
+}
+
+// Put returns a healthy connection for reuse, or closes it if the pool is
+// full or closed. A connection that saw an error, or that has a response
+// still unread, must be closed instead.
+func (p *Pool) Put(conn net.Conn) {
+	p.mu.Lock()
+	if p.closed || len(p.idle) >= p.maxIdle {
+		p.mu.Unlock()
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle, idleConn{conn, time.Now()})
+	p.mu.Unlock()
+}
+
+// Close closes the idle connections. Connections handed out earlier are
+// closed when they are Put back.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle, p.closed = nil, true
+	p.mu.Unlock()
+	for _, ic := range idle {
+		ic.conn.Close()
+	}
+	return nil
+}
+
+// Stats returns the pool's counters.
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	idle := len(p.idle)
+	p.mu.Unlock()
+	return Stats{Dials: p.dials.Load(), Reuses: p.reuses.Load(), Expired: p.expired.Load(), Idle: idle}
+}
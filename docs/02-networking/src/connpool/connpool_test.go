@@ -0,0 +1,107 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipes dials net.Pipe connections and remembers the server ends, so a
+// test can tell which connections were closed.
+type pipes struct {
+	mu      sync.Mutex
+	servers []net.Conn
+}
+
+func (ps *pipes) dial(context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	ps.mu.Lock()
+	ps.servers = append(ps.servers, server)
+	ps.mu.Unlock()
+	return client, nil
+}
+
+// closed reports whether the client end of the i'th connection was closed.
+func (ps *pipes) closed(i int) bool {
+	ps.servers[i].SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err := ps.servers[i].Read(make([]byte, 1))
+	return err != nil && !errors.Is(err, net.ErrClosed) && !isTimeout(err)
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+func TestReuse(t *testing.T) {
+	ps := &pipes{}
+	p := New(ps.dial, 2)
+	defer p.Close()
+	ctx := context.Background()
+
+	a, _ := p.Get(ctx)
+	b, _ := p.Get(ctx)
+	c, _ := p.Get(ctx)
+	p.Put(a)
+	p.Put(b)
+	p.Put(c) // over maxIdle: closed
+	if !ps.closed(2) {
+		t.Fatal("connection beyond maxIdle was not closed")
+	}
+
+	// LIFO: the most recently returned connection comes back first.
+	if got, _ := p.Get(ctx); got != b {
+		t.Fatal("Get did not return the most recently Put connection")
+	}
+	if got, _ := p.Get(ctx); got != a {
+		t.Fatal("Get did not return the remaining idle connection")
+	}
+	if s := p.Stats(); s.Dials != 3 || s.Reuses != 2 || s.Idle != 0 {
+		t.Fatalf("stats %+v, want 3 dials and 2 reuses", s)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	ps := &pipes{}
+	p := New(ps.dial, 4)
+	p.IdleTimeout = 10 * time.Millisecond
+	defer p.Close()
+	ctx := context.Background()
+
+	a, _ := p.Get(ctx)
+	p.Put(a)
+	time.Sleep(20 * time.Millisecond)
+	if got, _ := p.Get(ctx); got == a {
+		t.Fatal("Get returned a connection idle past IdleTimeout")
+	}
+	if !ps.closed(0) {
+		t.Fatal("expired connection was not closed")
+	}
+	if s := p.Stats(); s.Expired != 1 || s.Dials != 2 {
+		t.Fatalf("stats %+v, want 1 expired and 2 dials", s)
+	}
+}
+
+func TestClose(t *testing.T) {
+	ps := &pipes{}
+	p := New(ps.dial, 4)
+	ctx := context.Background()
+
+	a, _ := p.Get(ctx)
+	b, _ := p.Get(ctx)
+	p.Put(a)
+	p.Close()
+	if !ps.closed(0) {
+		t.Fatal("Close left an idle connection open")
+	}
+	p.Put(b)
+	if !ps.closed(1) {
+		t.Fatal("Put after Close did not close the connection")
+	}
+	if _, err := p.Get(ctx); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Get after Close: %v, want ErrClosed", err)
+	}
+}
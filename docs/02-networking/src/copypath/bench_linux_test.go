@@ -0,0 +1,98 @@
+package copypath
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/internal/threadcpu"
+)
+
+const benchChunk = 1 << 20
+
+// stream returns a TCP connection whose peer writes zeros until closed.
+func stream(tb testing.TB) net.Conn {
+	r, w := pair(tb, "tcp")
+	go func() {
+		buf := make([]byte, 256<<10)
+		for {
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// measureCPU locks the benchmark to one thread and reports the CPU the
+// copying thread used per MiB. Throughput alone hides the difference when
+// the peers doing the writing and reading are the bottleneck.
+func measureCPU(b *testing.B, loop func()) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	start := threadcpu.Now(b)
+	loop()
+	b.ReportMetric(float64(threadcpu.Now(b)-start)/float64(b.N), "cpu-ns/MiB")
+}
+
+// BenchmarkProxy shovels 1 MiB per operation the way a TCP proxy does, once
+// on each path io.Copy can take.
+func BenchmarkProxy(b *testing.B) {
+	copyBuffer := func(size int) func(io.Writer, io.Reader) (int64, error) {
+		buf := make([]byte, size)
+		return func(dst io.Writer, src io.Reader) (int64, error) {
+			return io.CopyBuffer(dst, src, buf)
+		}
+	}
+	socket := []struct {
+		name string
+		wrap func(io.Reader) io.Reader
+		copy func(io.Writer, io.Reader) (int64, error)
+	}{
+		{"splice", func(r io.Reader) io.Reader { return r }, io.Copy},
+		// CopyBuffer ignores its buffer when a fast path exists.
+		{"splice/CopyBuffer-256K", func(r io.Reader) io.Reader { return r }, copyBuffer(256 << 10)},
+		{"copy", func(r io.Reader) io.Reader { return opaqueReader{r} }, io.Copy},
+		{"copy/CopyBuffer-256K", func(r io.Reader) io.Reader { return opaqueReader{r} }, copyBuffer(256 << 10)},
+	}
+	for _, s := range socket {
+		b.Run("tcp/"+s.name, func(b *testing.B) {
+			src, dst := stream(b), sink(b, "tcp")
+			b.SetBytes(benchChunk)
+			measureCPU(b, func() {
+				for b.Loop() {
+					if _, err := s.copy(dst, s.wrap(io.LimitReader(src, benchChunk))); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+
+	for _, wrapped := range []bool{false, true} {
+		name := "sendfile"
+		if wrapped {
+			name = "copy"
+		}
+		b.Run(fmt.Sprintf("file/%s", name), func(b *testing.B) {
+			f, dst := tempFile(b, benchChunk), sink(b, "tcp")
+			var src io.Reader = f
+			if wrapped {
+				src = opaqueReader{f}
+			}
+			b.SetBytes(benchChunk)
+			measureCPU(b, func() {
+				for b.Loop() {
+					if _, err := f.Seek(0, io.SeekStart); err != nil {
+						b.Fatal(err)
+					}
+					if _, err := io.Copy(dst, src); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
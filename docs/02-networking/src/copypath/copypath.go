@@ -0,0 +1,67 @@
+// Package copypath reports which kernel path io.Copy took between two
+// endpoints. io.Copy prefers src.WriteTo and then dst.ReadFrom; on Linux the
+// net and os packages implement those with sendfile(2) and splice(2), so
+// bytes never enter user space. The fast path is silently lost as soon as
+// either side is wrapped in a type the standard library does not recognize,
+// such as a byte-counting reader in a proxy, and io.Copy falls back to read
+// and write through a 32 KiB buffer.
+package copypath
+
+import (
+	"fmt"
+	"io"
+)
+
+// Path is the way bytes moved during a copy.
+type Path int
+
+const (
+	// Unknown means the path could not be observed on this platform.
+	Unknown Path = iota
+	// UserCopy means read(2) and write(2) through a user-space buffer.
+	UserCopy
+	// Sendfile means sendfile(2) from a file to a socket.
+	Sendfile
+	// Splice means splice(2) between descriptors through a kernel pipe.
+	Splice
+)
+
+func (p Path) String() string {
+	switch p {
+	case UserCopy:
+		return "copy"
+	case Sendfile:
+		return "sendfile"
+	case Splice:
+		return "splice"
+	}
+	return "unknown"
+}
+
+// copyBufferSize is the buffer io.Copy allocates when neither side offers a
+// fast path. A user-space copy never moves more than this per write.
+const copyBufferSize = 32 << 10
+
+// Copy is io.Copy that also reports the path the bytes took.
+func Copy(dst io.Writer, src io.Reader) (int64, Path, error) {
+	var n int64
+	var err error
+	p := observe(func() int64 {
+		n, err = io.Copy(dst, src)
+		return n
+	})
+	return n, p, err
+}
+
+// Must copies like Copy and returns an error if the copy did not take want.
+// Tests use it to pin a proxy to its fast path.
+func Must(want Path, dst io.Writer, src io.Reader) (int64, error) {
+	n, got, err := Copy(dst, src)
+	if err != nil {
+		return n, err
+	}
+	if got != want && got != Unknown {
+		return n, fmt.Errorf("copypath: copied %d bytes by %v, want %v", n, got, want)
+	}
+	return n, nil
+}
@@ -0,0 +1,65 @@
+package copypath
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"strconv"
+)
+
+// ioCounters are the per-thread fields of /proc/thread-self/io. The kernel
+// accounts read(2), write(2) and sendfile(2) there, but not splice(2).
+type ioCounters struct {
+	rchar, wchar int64 // bytes passed to read and write calls
+	syscw        int64 // write-like system calls
+}
+
+func readCounters() (ioCounters, bool) {
+	b, err := os.ReadFile("/proc/thread-self/io")
+	if err != nil {
+		return ioCounters{}, false
+	}
+	var c ioCounters
+	for line := range bytes.Lines(b) {
+		k, v, ok := bytes.Cut(bytes.TrimSpace(line), []byte(": "))
+		if !ok {
+			continue
+		}
+		n, _ := strconv.ParseInt(string(v), 10, 64)
+		switch string(k) {
+		case "rchar":
+			c.rchar = n
+		case "wchar":
+			c.wchar = n
+		case "syscw":
+			c.syscw = n
+		}
+	}
+	return c, true
+}
+
+// observe runs f on a locked OS thread and classifies the path from that
+// thread's I/O counters. f returns the number of bytes it copied.
+func observe(f func() int64) Path {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	before, ok := readCounters()
+	n := f()
+	after, ok2 := readCounters()
+	if !ok || !ok2 || n == 0 {
+		return Unknown
+	}
+
+	wchar, syscw := after.wchar-before.wchar, after.syscw-before.syscw
+	switch {
+	case wchar < n/2:
+		// Most bytes moved without being accounted to this thread.
+		return Splice
+	case syscw > 0 && wchar/syscw > copyBufferSize:
+		// Writes larger than any io.Copy buffer: one sendfile call
+		// moves megabytes.
+		return Sendfile
+	}
+	return UserCopy
+}
@@ -0,0 +1,139 @@
+package copypath
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"io"
+	"net"
+	"os"
+	"testing"
+)
+
+const testSize = 4 << 20
+
+// pair returns both ends of a stream connection on network ("tcp" or "unix").
+func pair(tb testing.TB, network string) (net.Conn, net.Conn) {
+	tb.Helper()
+	addr := "127.0.0.1:0"
+	if network == "unix" {
+		addr = tb.TempDir() + "/s"
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer ln.Close()
+	a, err := net.Dial(network, ln.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	b, err := ln.Accept()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { a.Close(); b.Close() })
+	return a, b
+}
+
+// source returns a connection that yields n bytes and then EOF.
+func source(tb testing.TB, network string, n int) net.Conn {
+	r, w := pair(tb, network)
+	go func() {
+		w.Write(make([]byte, n))
+		w.Close()
+	}()
+	return r
+}
+
+// sink returns a connection whose peer discards everything.
+func sink(tb testing.TB, network string) net.Conn {
+	w, r := pair(tb, network)
+	go io.Copy(io.Discard, r)
+	return w
+}
+
+func tempFile(tb testing.TB, n int) *os.File {
+	tb.Helper()
+	f, err := os.CreateTemp(tb.TempDir(), "src")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := f.Write(make([]byte, n)); err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { f.Close() })
+	return f
+}
+
+// opaqueReader and opaqueWriter hide every method but Read and Write, like
+// any wrapper type that does not forward WriteTo and ReadFrom.
+type opaqueReader struct{ io.Reader }
+type opaqueWriter struct{ io.Writer }
+
+func TestPaths(t *testing.T) {
+	tests := []struct {
+		name string
+		want Path
+		ends func(t *testing.T) (io.Writer, io.Reader)
+	}{
+		{"file to tcp", Sendfile, func(t *testing.T) (io.Writer, io.Reader) {
+			return sink(t, "tcp"), tempFile(t, testSize)
+		}},
+		{"file to unix", Sendfile, func(t *testing.T) (io.Writer, io.Reader) {
+			return sink(t, "unix"), tempFile(t, testSize)
+		}},
+		{"tcp to tcp", Splice, func(t *testing.T) (io.Writer, io.Reader) {
+			return sink(t, "tcp"), source(t, "tcp", testSize)
+		}},
+		{"unix to tcp", Splice, func(t *testing.T) (io.Writer, io.Reader) {
+			return sink(t, "tcp"), source(t, "unix", testSize)
+		}},
+		{"tcp to unix", Splice, func(t *testing.T) (io.Writer, io.Reader) {
+			return sink(t, "unix"), source(t, "tcp", testSize)
+		}},
+		// net splices into TCP, and from TCP into Unix sockets, but
+		// never between two Unix sockets.
+		{"unix to unix", UserCopy, func(t *testing.T) (io.Writer, io.Reader) {
+			return sink(t, "unix"), source(t, "unix", testSize)
+		}},
+		{"limited tcp to tcp", Splice, func(t *testing.T) (io.Writer, io.Reader) {
+			return sink(t, "tcp"), io.LimitReader(source(t, "tcp", testSize), testSize)
+		}},
+		{"bufio reader", Splice, func(t *testing.T) (io.Writer, io.Reader) {
+			return sink(t, "tcp"), bufio.NewReader(source(t, "tcp", testSize))
+		}},
+		{"empty bufio writer", Splice, func(t *testing.T) (io.Writer, io.Reader) {
+			return bufio.NewWriter(sink(t, "tcp")), source(t, "tcp", testSize)
+		}},
+		{"wrapped reader", UserCopy, func(t *testing.T) (io.Writer, io.Reader) {
+			return sink(t, "tcp"), opaqueReader{source(t, "tcp", testSize)}
+		}},
+		{"wrapped writer", UserCopy, func(t *testing.T) (io.Writer, io.Reader) {
+			return opaqueWriter{sink(t, "tcp")}, source(t, "tcp", testSize)
+		}},
+		{"wrapped file", UserCopy, func(t *testing.T) (io.Writer, io.Reader) {
+			return sink(t, "tcp"), opaqueReader{tempFile(t, testSize)}
+		}},
+		{"tee reader", UserCopy, func(t *testing.T) (io.Writer, io.Reader) {
+			return sink(t, "tcp"), io.TeeReader(source(t, "tcp", testSize), sha256.New())
+		}},
+		{"multi writer", UserCopy, func(t *testing.T) (io.Writer, io.Reader) {
+			return io.MultiWriter(sink(t, "tcp"), sha256.New()), source(t, "tcp", testSize)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst, src := tt.ends(t)
+			n, err := Must(tt.want, dst, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != testSize {
+				t.Fatalf("copied %d bytes, want %d", n, testSize)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+// Package cuckoo is a cuckoo filter: a probabilistic set, like a Bloom
+// filter, that also supports deletion.
+//
+// It stores a 16-bit fingerprint of each key in one of two buckets of four
+// slots. The second bucket is derived from the first and the fingerprint
+// alone, so a fingerprint can be moved between its buckets without the key,
+// which is how Insert makes room when both are full. A lookup reads two
+// buckets, 16 bytes, and answers "no" or "probably", with a false-positive
+// rate of about 8 in 65,536 (0.012%) at 2 bytes per slot. The number of
+// buckets is a power of two, so the table can be up to twice as large as
+// the capacity needs.
+//
+// Deleting a key that was never inserted can remove another key's
+// fingerprint, so only delete keys known to be present. Insert stores a
+// key again each time it is called, up to eight copies. Add stores only
+// keys not already present, so a filter used through Add and Delete holds
+// one copy per key.
+package cuckoo
+
+import (
+	"hash/maphash"
+	"sync"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/pow2"
+)
+
+const (
+	slots    = 4   // fingerprints per bucket
+	maxKicks = 500 // relocations before Insert gives up
+)
+
+type bucket [slots]uint16 // 0 marks an empty slot
+
+// Filter is a cuckoo filter over keys of type K. It is safe for concurrent
+// use; every operation takes a mutex.
+type Filter[K comparable] struct {
+	seed maphash.Seed
+
+	mu      sync.Mutex
+	buckets []bucket
+	mask    pow2.Mask
+	n       int
+	victim  uint16 // a fingerprint that found no slot, or 0
+	victimI int    // one of its buckets
+	kick    uint64 // pseudo-random state for picking a slot to evict
+}
+
+// New returns a Filter that holds at least capacity keys. Filters fill to
+// about 95% of their slots before Insert starts failing, which New allows
+// for.
+func New[K comparable](capacity int) *Filter[K] {
+	nb := pow2.NextPow2(max((capacity*100/95+slots-1)/slots, 1))
+	return &Filter[K]{seed: maphash.MakeSeed(), buckets: make([]bucket, nb), mask: pow2.MaskFor(nb), kick: 1}
+}
+
+// locate returns the key's fingerprint and first bucket.
+func (f *Filter[K]) locate(key K) (fp uint16, i int) {
+	h := maphash.Comparable(f.seed, key)
+	fp = uint16(h >> 48)
+	if fp == 0 {
+		fp = 1
+	}
+	return fp, f.mask.Index(int(h))
+}
+
+// alt returns the other bucket of a fingerprint in bucket i. It is its own
+// inverse: alt(alt(i)) == i.
+func (f *Filter[K]) alt(i int, fp uint16) int {
+	return f.mask.Index(i ^ int(uint32(fp)*0x5bd1e995))
+}
+
+func (b *bucket) count(fp uint16) int {
+	n := 0
+	for _, s := range b {
+		if s == fp {
+			n++
+		}
+	}
+	return n
+}
+
+func (b *bucket) put(fp uint16) bool {
+	for j, s := range b {
+		if s == 0 {
+			b[j] = fp
+			return true
+		}
+	}
+	return false
+}
+
+func (b *bucket) remove(fp uint16) bool {
+	for j, s := range b {
+		if s == fp {
+			b[j] = 0
+			return true
+		}
+	}
+	return false
+}
+
+// Insert stores key and reports whether there was room. When there was not,
+// the filter is full: a fingerprint was evicted to make room and is kept
+// aside, so nothing is lost, but later Inserts fail until a Delete frees a
+// slot.
+func (f *Filter[K]) Insert(key K) bool {
+	fp, i := f.locate(key)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.insert(fp, i)
+}
+
+func (f *Filter[K]) insert(fp uint16, i int) bool {
+	if f.victim != 0 {
+		return false
+	}
+	j := f.alt(i, fp)
+	if f.buckets[i].count(fp)+f.buckets[j].count(fp) >= 2*slots {
+		return false // the most copies two buckets can hold
+	}
+	if f.buckets[i].put(fp) || f.buckets[j].put(fp) {
+		f.n++
+		return true
+	}
+	// Both full: evict a random fingerprint from one of them and move it
+	// to its other bucket, and so on until one lands in a free slot.
+	if f.kick&1 == 0 {
+		i = j
+	}
+	for range maxKicks {
+		f.kick ^= f.kick << 13
+		f.kick ^= f.kick >> 7
+		f.kick ^= f.kick << 17
+		s := int(f.kick % slots)
+		fp, f.buckets[i][s] = f.buckets[i][s], fp
+		i = f.alt(i, fp)
+		if f.buckets[i].put(fp) {
+			f.n++
+			return true
+		}
+	}
+	f.victim, f.victimI = fp, i
+	f.n++
+	return false
+}
+
+// Contains reports whether key was probably inserted.
+func (f *Filter[K]) Contains(key K) bool {
+	fp, i := f.locate(key)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.contains(fp, i)
+}
+
+func (f *Filter[K]) contains(fp uint16, i int) bool {
+	j := f.alt(i, fp)
+	if f.buckets[i].count(fp) > 0 || f.buckets[j].count(fp) > 0 {
+		return true
+	}
+	return f.victim == fp && (f.victimI == i || f.victimI == j)
+}
+
+// Delete removes one copy of key and reports whether it found one.
+func (f *Filter[K]) Delete(key K) bool {
+	fp, i := f.locate(key)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.delete(fp, i)
+}
+
+func (f *Filter[K]) delete(fp uint16, i int) bool {
+	j := f.alt(i, fp)
+	switch {
+	case f.buckets[i].remove(fp), f.buckets[j].remove(fp):
+	case f.victim == fp && (f.victimI == i || f.victimI == j):
+		f.victim = 0
+		f.n--
+		return true
+	default:
+		return false
+	}
+	f.n--
+	// A slot is free again: give the evicted fingerprint another try.
+	if f.victim != 0 {
+		vfp := f.victim
+		f.victim = 0
+		f.n--
+		f.insert(vfp, f.victimI)
+	}
+	return true
+}
+
+// Add inserts key unless it is probably present, and reports whether it
+// was new. It returns false, too, if the filter is full.
+func (f *Filter[K]) Add(key K) bool {
+	fp, i := f.locate(key)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.contains(fp, i) {
+		return false
+	}
+	return f.insert(fp, i)
+}
+
+// Len returns the number of fingerprints stored, copies included.
+func (f *Filter[K]) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.n
+}
+
+// Bytes returns the size of the table.
+func (f *Filter[K]) Bytes() int { return len(f.buckets) * slots * 2 }
@@ -0,0 +1,114 @@
+package cuckoo
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// key returns the i-th test key, 8 bytes like a packed address and port.
+func key(i int) [8]byte {
+	var k [8]byte
+	binary.LittleEndian.PutUint64(k[:], uint64(i)*0x9e3779b97f4a7c15)
+	return k
+}
+
+func TestInsertContainsDelete(t *testing.T) {
+	const n = 100_000
+	f := New[[8]byte](n)
+	for i := range n {
+		if !f.Insert(key(i)) {
+			t.Fatalf("Insert %d of %d failed with capacity %d", i, n, n)
+		}
+	}
+	if f.Len() != n {
+		t.Fatalf("Len = %d, want %d", f.Len(), n)
+	}
+	for i := range n {
+		if !f.Contains(key(i)) {
+			t.Fatalf("key %d inserted but not found", i)
+		}
+	}
+	fp := 0
+	for i := n; i < 11*n; i++ {
+		if f.Contains(key(i)) {
+			fp++
+		}
+	}
+	rate := float64(fp) / (10 * n)
+	t.Logf("%d keys in %d bytes (%.1f bits per key), false-positive rate %.5f", n, f.Bytes(), float64(8*f.Bytes())/n, rate)
+	if rate > 0.0003 {
+		t.Errorf("false-positive rate %.5f", rate)
+	}
+
+	// Delete the even keys; the odd ones stay.
+	for i := 0; i < n; i += 2 {
+		if !f.Delete(key(i)) {
+			t.Fatalf("Delete of key %d found nothing", i)
+		}
+	}
+	for i := 1; i < n; i += 2 {
+		if !f.Contains(key(i)) {
+			t.Fatalf("key %d lost when others were deleted", i)
+		}
+	}
+	gone := 0
+	for i := 0; i < n; i += 2 {
+		if !f.Contains(key(i)) {
+			gone++
+		}
+	}
+	if gone < n/2*99/100 {
+		t.Errorf("only %d of %d deleted keys are gone", gone, n/2)
+	}
+	if f.Len() != n/2 {
+		t.Errorf("Len = %d after deleting half, want %d", f.Len(), n/2)
+	}
+}
+
+// TestFull fills a filter past its capacity: Inserts start failing, but no
+// key that was inserted is lost, and a Delete makes room again.
+func TestFull(t *testing.T) {
+	f := New[[8]byte](1000)
+	var in []int
+	for i := 0; ; i++ {
+		if !f.Insert(key(i)) {
+			in = append(in, i) // kept aside as the victim
+			break
+		}
+		in = append(in, i)
+	}
+	t.Logf("full at %d keys, %.1f%% of %d slots", len(in), 100*float64(len(in))/float64(f.Bytes()/2), f.Bytes()/2)
+	if len(in) < f.Bytes()/2*90/100 {
+		t.Errorf("full at %d of %d slots", len(in), f.Bytes()/2)
+	}
+	for _, i := range in {
+		if !f.Contains(key(i)) {
+			t.Fatalf("key %d lost when the filter filled", i)
+		}
+	}
+	if f.Insert(key(-1)) {
+		t.Fatal("Insert into a full filter succeeded")
+	}
+	f.Delete(key(in[0]))
+	if !f.Insert(key(-1)) {
+		t.Fatal("Insert failed after a Delete made room")
+	}
+	for _, i := range in[1:] {
+		if !f.Contains(key(i)) {
+			t.Fatalf("key %d lost after the victim was reinserted", i)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	f := New[string](16)
+	if !f.Add("a") || f.Add("a") {
+		t.Fatal("Add does not report new keys")
+	}
+	if f.Len() != 1 {
+		t.Errorf("Len = %d after adding a key twice", f.Len())
+	}
+	if !f.Delete("a") || f.Contains("a") {
+		t.Error("a key added twice survived one Delete")
+	}
+}
@@ -0,0 +1,142 @@
+// Package deadline carries a request's deadline from one service to the
+// next, so that every hop stops working on a request once the client that
+// sent it has stopped waiting.
+//
+// A deadline travels as the time remaining, as gRPC's grpc-timeout does,
+// not as a point in time, so the hops' clocks need not agree. Each hop
+// starts its own timer when the request arrives and passes on what is left
+// of it. Time on the wire between hops is not counted, so a callee gives up
+// slightly later than its caller. Over HTTP the deadline is the Header,
+// set by Transport and applied by Handler or Extract. On a framed protocol,
+// AppendBudget and ParseBudget put it at the front of each message.
+//
+// Without propagation, only the first hop knows the deadline. A backend
+// behind a proxy keeps working on a request long after the client has
+// given up on it, and that wasted work delays the requests that could
+// still succeed. The deadlinehops experiment measures it.
+package deadline
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Header holds the time left for a request, in Go duration syntax and
+// rounded down to the microsecond, for example "87.25ms". "0s" means the
+// deadline passed before the request was sent.
+const Header = "Request-Timeout"
+
+// Remaining returns the time left before ctx's deadline, and false if ctx
+// has none. It is negative once the deadline has passed.
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(d), true
+}
+
+// Inject sets Header in h to the time left before ctx's deadline, or
+// removes it if ctx has none.
+func Inject(ctx context.Context, h http.Header) {
+	d, ok := Remaining(ctx)
+	if !ok {
+		h.Del(Header)
+		return
+	}
+	h.Set(Header, max(d, 0).Truncate(time.Microsecond).String())
+}
+
+// Extract returns a copy of ctx that ends when the deadline in h passes,
+// or ctx itself if h has none. A deadline that has already passed gives a
+// context that is already done. The caller must call the CancelFunc.
+func Extract(ctx context.Context, h http.Header) (context.Context, context.CancelFunc, error) {
+	v := h.Get(Header)
+	if v == "" {
+		return ctx, func() {}, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return ctx, func() {}, fmt.Errorf("deadline: bad %s %q", Header, v)
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return ctx, cancel, nil
+}
+
+// Handler applies each request's Header to its context. It answers a
+// request whose deadline has already passed with 504 Gateway Timeout, and
+// one with a malformed Header with 400 Bad Request, without calling next.
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel, err := Extract(r.Context(), r.Header)
+		defer cancel()
+		switch {
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case ctx.Err() != nil:
+			http.Error(w, "deadline exceeded", http.StatusGatewayTimeout)
+		default:
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+	})
+}
+
+// Transport is an http.RoundTripper that sets Header on every request
+// whose context has a deadline.
+type Transport struct {
+	Base http.RoundTripper // nil means http.DefaultTransport
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if _, ok := req.Context().Deadline(); ok {
+		req = req.Clone(req.Context()) // a RoundTripper must not change the request
+		Inject(req.Context(), req.Header)
+	}
+	return base.RoundTrip(req)
+}
+
+// BudgetSize is the length of the prefix AppendBudget writes.
+const BudgetSize = 4
+
+// ErrShort is returned by ParseBudget for a message shorter than
+// BudgetSize.
+var ErrShort = errors.New("deadline: message too short for a budget")
+
+// AppendBudget appends the time left before ctx's deadline to dst as a
+// big-endian count of microseconds. Zero means no deadline, so a deadline
+// that has passed is sent as 1µs, and anything over 71 minutes as the
+// largest value.
+func AppendBudget(dst []byte, ctx context.Context) []byte {
+	var us uint32
+	if d, ok := Remaining(ctx); ok {
+		us = uint32(min(max(d.Microseconds(), 1), math.MaxUint32))
+	}
+	return binary.BigEndian.AppendUint32(dst, us)
+}
+
+// ParseBudget splits the budget written by AppendBudget from the front of
+// msg. It returns zero for no deadline.
+func ParseBudget(msg []byte) (budget time.Duration, rest []byte, err error) {
+	if len(msg) < BudgetSize {
+		return 0, msg, ErrShort
+	}
+	return time.Duration(binary.BigEndian.Uint32(msg)) * time.Microsecond, msg[BudgetSize:], nil
+}
+
+// WithBudget returns a copy of ctx that ends after budget, or one that
+// only ends with ctx if budget is zero.
+func WithBudget(ctx context.Context, budget time.Duration) (context.Context, context.CancelFunc) {
+	if budget == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, budget)
+}
@@ -0,0 +1,129 @@
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHeader(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	h := http.Header{}
+	Inject(ctx, h)
+	sent, err := time.ParseDuration(h.Get(Header))
+	if err != nil || sent > 250*time.Millisecond || sent < 200*time.Millisecond {
+		t.Fatalf("%s: %q (%v), want just under 250ms", Header, h.Get(Header), err)
+	}
+
+	got, cancel2, err := Extract(context.Background(), h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel2()
+	if d, ok := Remaining(got); !ok || d > sent {
+		t.Errorf("extracted %v (deadline %v), want at most the %v sent", d, ok, sent)
+	}
+
+	Inject(context.Background(), h)
+	if v := h.Get(Header); v != "" {
+		t.Errorf("%s %q left by a context without a deadline", Header, v)
+	}
+	if got, _, _ := Extract(ctx, h); got != ctx {
+		t.Error("Extract without a header changed the context")
+	}
+
+	h.Set(Header, "soon")
+	if _, _, err := Extract(context.Background(), h); err == nil {
+		t.Error("no error for a malformed header")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var left time.Duration
+	h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		left, _ = Remaining(r.Context())
+	}))
+	for _, c := range []struct {
+		value string
+		code  int
+	}{
+		{"", http.StatusOK},
+		{"50ms", http.StatusOK},
+		{"0s", http.StatusGatewayTimeout},
+		{"-1s", http.StatusBadRequest},
+	} {
+		left = 0
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.value != "" {
+			r.Header.Set(Header, c.value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Errorf("%s %q: status %d, want %d", Header, c.value, w.Code, c.code)
+		}
+		if c.value == "50ms" && (left <= 0 || left > 50*time.Millisecond) {
+			t.Errorf("handler saw %v left of 50ms", left)
+		}
+	}
+}
+
+// TestTransport sends a request through a client Transport to a server
+// behind Handler and checks that the server sees the client's deadline.
+func TestTransport(t *testing.T) {
+	seen := make(chan time.Duration, 1)
+	srv := httptest.NewServer(Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, _ := Remaining(r.Context())
+		seen <- d
+	})))
+	defer srv.Close()
+	c := &http.Client{Transport: &Transport{}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if d := <-seen; d <= 0 || d > time.Second {
+		t.Errorf("server saw %v left of 1s", d)
+	}
+	if v := req.Header.Get(Header); v != "" {
+		t.Errorf("Transport changed the caller's request: %s %q", Header, v)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 80*time.Millisecond)
+	defer cancel()
+	msg := AppendBudget([]byte("x"), ctx)[1:]
+	msg = append(msg, "body"...)
+	budget, rest, err := ParseBudget(msg)
+	if err != nil || budget <= 70*time.Millisecond || budget > 80*time.Millisecond || string(rest) != "body" {
+		t.Fatalf("ParseBudget = %v, %q, %v; want about 80ms and the body", budget, rest, err)
+	}
+
+	none, _, _ := ParseBudget(AppendBudget(nil, context.Background()))
+	if none != 0 {
+		t.Errorf("budget %v without a deadline, want 0", none)
+	}
+	expired, stop := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer stop()
+	if b, _, _ := ParseBudget(AppendBudget(nil, expired)); b != time.Microsecond {
+		t.Errorf("budget %v for a passed deadline, want 1µs", b)
+	}
+	if _, _, err := ParseBudget([]byte{0, 0}); err != ErrShort {
+		t.Errorf("ParseBudget of 2 bytes: %v, want ErrShort", err)
+	}
+
+	bctx, bcancel := WithBudget(context.Background(), 0)
+	defer bcancel()
+	if _, ok := bctx.Deadline(); ok {
+		t.Error("WithBudget(0) set a deadline")
+	}
+}
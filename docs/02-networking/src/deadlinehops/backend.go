@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/deadline"
+)
+
+// A request to the backend is one length-prefixed frame:
+//
+//	budget  4 bytes, written by deadline.AppendBudget; 0 without propagation
+//	work    4 bytes, microseconds of work the request takes
+//	audit   8 bytes, the client's deadline in Unix nanoseconds
+//	body    echoed back
+//
+// The audit deadline is for the backend's counters only: it tells them
+// whether work was done after the client gave up, whether or not the
+// deadline was propagated. The reply is a status byte and the body.
+const (
+	requestHeader = deadline.BudgetSize + 4 + 8
+	maxFrame      = 64 << 10
+
+	statusOK      byte = 0
+	statusExpired byte = 1 // the deadline passed before the work was done
+)
+
+func appendRequest(dst []byte, ctx context.Context, propagate bool, work time.Duration, audit time.Time, body []byte) []byte {
+	if propagate {
+		dst = deadline.AppendBudget(dst, ctx)
+	} else {
+		dst = binary.BigEndian.AppendUint32(dst, 0)
+	}
+	dst = binary.BigEndian.AppendUint32(dst, uint32(work.Microseconds()))
+	dst = binary.BigEndian.AppendUint64(dst, uint64(audit.UnixNano()))
+	return append(dst, body...)
+}
+
+// backend serves requests with fixed capacity: workers requests at a time,
+// each taking the work it asks for. Requests beyond that wait. With a
+// budget, a request is dropped if its deadline passes while it waits and
+// cut short if it passes while it is being served. Without one, every
+// request is served in full.
+type backend struct {
+	ln    net.Listener
+	slots chan struct{}
+	ctx   context.Context // ends on Close, so shutdown does not wait for the queue
+	stop  context.CancelFunc
+	wg    sync.WaitGroup
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+
+	started, done, cut, dropped, queued atomic.Int64
+	busy, wasted                        atomic.Int64 // nanoseconds of work; wasted is work cut short or finished after the audit deadline
+}
+
+func listenBackend(workers int) (*backend, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	b := &backend{ln: ln, slots: make(chan struct{}, workers), conns: map[net.Conn]struct{}{}}
+	b.ctx, b.stop = context.WithCancel(context.Background())
+	b.wg.Add(1)
+	go b.accept()
+	return b, nil
+}
+
+func (b *backend) Addr() string { return b.ln.Addr().String() }
+
+func (b *backend) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			conn.Close()
+			return
+		}
+		b.conns[conn] = struct{}{}
+		b.wg.Add(1)
+		b.mu.Unlock()
+		go b.serve(conn)
+	}
+}
+
+// serve handles one connection's requests in order. The proxy sends one
+// request at a time on a connection, so there is nothing to pipeline.
+func (b *backend) serve(conn net.Conn) {
+	defer b.wg.Done()
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, conn)
+		b.mu.Unlock()
+		conn.Close()
+	}()
+	c := codec.NewConn(conn, codec.NewLengthPrefixed(maxFrame), 4<<10)
+	var reply []byte
+	for {
+		msgs, err := c.Next()
+		if err != nil {
+			return
+		}
+		for _, m := range msgs {
+			if len(m.Payload) < requestHeader {
+				return
+			}
+			reply = append(reply[:0], b.handle(m.Payload))
+			reply = append(reply, m.Payload[requestHeader:]...)
+			if c.Send(codec.Message{Payload: reply}) != nil {
+				return
+			}
+		}
+		if c.Flush() != nil {
+			return
+		}
+	}
+}
+
+func (b *backend) handle(p []byte) byte {
+	budget, p, _ := deadline.ParseBudget(p)
+	work := time.Duration(binary.BigEndian.Uint32(p)) * time.Microsecond
+	audit := time.Unix(0, int64(binary.BigEndian.Uint64(p[4:])))
+	ctx, cancel := deadline.WithBudget(b.ctx, budget)
+	defer cancel()
+
+	b.queued.Add(1)
+	select {
+	case b.slots <- struct{}{}:
+		b.queued.Add(-1)
+	case <-ctx.Done():
+		b.queued.Add(-1)
+		b.dropped.Add(1)
+		return statusExpired
+	}
+	defer func() { <-b.slots }()
+	b.started.Add(1)
+
+	// The work is a sleep: what matters here is that a worker is held, not
+	// what it does.
+	start := time.Now()
+	t := time.NewTimer(work)
+	status := statusOK
+	select {
+	case <-t.C:
+		b.done.Add(1)
+	case <-ctx.Done():
+		t.Stop()
+		b.cut.Add(1)
+		status = statusExpired
+	}
+	end := time.Now()
+	b.busy.Add(int64(end.Sub(start)))
+	if status != statusOK || end.After(audit) {
+		b.wasted.Add(int64(end.Sub(start)))
+	}
+	return status
+}
+
+// Close stops accepting, abandons the requests in progress and closes
+// every connection.
+func (b *backend) Close() error {
+	err := b.ln.Close()
+	b.stop()
+	b.mu.Lock()
+	b.closed = true
+	for conn := range b.conns {
+		conn.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+	return err
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestPropagation checks the experiment's claim on a small scale: slow
+// requests that outlive their deadline swamp a backend that is not told
+// about it, and cost it at most the deadline each when it is.
+func TestPropagation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs for seconds")
+	}
+	cfg := config{Rate: 100, Duration: 2 * time.Second, Timeout: 50 * time.Millisecond,
+		Workers: 2, Work: time.Millisecond, Slow: 0.2, SlowWork: 300 * time.Millisecond}
+	total := func(propagate bool) second {
+		c := cfg
+		c.Propagate = propagate
+		if err := c.validate(); err != nil {
+			t.Fatal(err)
+		}
+		var tot second
+		if err := run(context.Background(), c, func(s second) {
+			tot.Offered += s.Offered
+			tot.OK += s.OK
+			tot.Cut += s.Cut
+			tot.Busy += s.Busy
+			tot.Wasted += s.Wasted
+		}); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("propagate=%v: %+v", propagate, tot)
+		return tot
+	}
+	off, on := total(false), total(true)
+	if off.Cut != 0 {
+		t.Errorf("backend cut %d requests short without a deadline", off.Cut)
+	}
+	if on.OK*10 < on.Offered*7 {
+		t.Errorf("propagate: %d of %d ok, want the fast requests to succeed", on.OK, on.Offered)
+	}
+	if off.OK*2 > on.OK {
+		t.Errorf("%d ok without propagation and %d with it, want the backend swamped without it", off.OK, on.OK)
+	}
+	// A request cut at its deadline wastes at most the client's timeout,
+	// plus the time between hops that the budget does not count.
+	if on.Cut == 0 || on.Wasted > time.Duration(on.Cut+1)*cfg.Timeout*3/2 {
+		t.Errorf("propagate: wasted %v on %d requests cut at a %v deadline", on.Wasted, on.Cut, cfg.Timeout)
+	}
+}
@@ -0,0 +1,75 @@
+// Command deadlinehops measures the work a backend wastes when request
+// deadlines stop at the first hop. A client sends HTTP requests through a
+// proxy to a backend, each with a -timeout deadline:
+//
+//	client --HTTP--> proxy --length-prefixed frames over TCP--> backend
+//
+//	go run ./deadlinehops -propagate=false
+//
+// The backend serves -workers requests at a time. Most take -work, and a
+// -slow fraction take -slow-work, longer than the client will wait. With
+// -propagate, the client sends its deadline in the deadline.Header, the
+// proxy applies it to its own context and sends the time left in front of
+// the frame, and the backend drops a request whose deadline passes in its
+// queue and stops work on one whose deadline passes while it is served.
+// Without it, the proxy gives up when the client does, but the backend
+// serves every request in full, and its workers spend most of their time
+// on slow requests that nobody is waiting for any more.
+//
+// Every second it prints how the client's requests ended, and for the
+// backend the requests it started, finished, cut short and dropped, its
+// busy worker time and how much of that was wasted on requests whose
+// client had given up.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+	var cfg config
+	flag.BoolVar(&cfg.Propagate, "propagate", true, "Send the client's deadline through every hop")
+	flag.IntVar(&cfg.Rate, "rate", 300, "Requests per second")
+	flag.DurationVar(&cfg.Duration, "duration", 10*time.Second, "Test duration")
+	flag.DurationVar(&cfg.Timeout, "timeout", 100*time.Millisecond, "Client deadline per request")
+	flag.IntVar(&cfg.Workers, "workers", 8, "Requests the backend serves at once")
+	flag.DurationVar(&cfg.Work, "work", 2*time.Millisecond, "Backend time for a normal request")
+	flag.Float64Var(&cfg.Slow, "slow", 0.05, "Fraction of requests that take -slow-work")
+	flag.DurationVar(&cfg.SlowWork, "slow-work", 500*time.Millisecond, "Backend time for a slow request")
+	flag.Parse()
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	fmt.Printf("propagate=%v rate=%d/s timeout=%v workers=%d work=%v, %.0f%% %v\n",
+		cfg.Propagate, cfg.Rate, cfg.Timeout, cfg.Workers, cfg.Work, cfg.Slow*100, cfg.SlowWork)
+	var total second
+	err := run(ctx, cfg, func(s second) {
+		fmt.Printf("t=%-3d offered=%-5d ok=%-5d timeout=%-5d failed=%-3d | started=%-5d done=%-5d cut=%-4d dropped=%-5d busy=%-6v wasted=%-6v queue=%d\n",
+			s.T, s.Offered, s.OK, s.TimedOut, s.Failed, s.Started, s.Done, s.Cut, s.Drops,
+			s.Busy.Round(time.Millisecond), s.Wasted.Round(time.Millisecond), s.Queue)
+		total.Offered += s.Offered
+		total.OK += s.OK
+		total.TimedOut += s.TimedOut
+		total.Busy += s.Busy
+		total.Wasted += s.Wasted
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("total: offered=%d ok=%d (%.1f%%) timeout=%d busy=%v wasted=%v (%.1f%%)\n",
+		total.Offered, total.OK, 100*float64(total.OK)/float64(max(total.Offered, 1)), total.TimedOut,
+		total.Busy.Round(time.Millisecond), total.Wasted.Round(time.Millisecond),
+		100*total.Wasted.Seconds()/max(total.Busy.Seconds(), 1e-9))
+}
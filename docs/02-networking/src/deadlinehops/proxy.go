@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/connpool"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/deadline"
+)
+
+// Headers the client sets for the proxy. Work is how long the backend
+// spends on the request, in microseconds; Audit is the client's deadline in
+// Unix nanoseconds, forwarded for the backend's counters.
+const (
+	workHeader  = "X-Work-Us"
+	auditHeader = "X-Audit-Deadline"
+)
+
+// proxy is the middle hop: an HTTP server that forwards every request to
+// the backend over a pooled TCP connection. With propagate, it takes the
+// request's deadline from deadline.Header and sends what is left of it to
+// the backend. Without it, the request's context ends only when the client
+// goes away, and the backend is not told at all.
+type proxy struct {
+	srv       *http.Server
+	ln        net.Listener
+	pool      *connpool.Pool
+	propagate bool
+
+	expired atomic.Int64 // requests that arrived with no time left
+}
+
+func listenProxy(backend string, propagate bool) (*proxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	p := &proxy{
+		ln:        ln,
+		propagate: propagate,
+		pool: connpool.New(func(ctx context.Context) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", backend)
+		}, 256),
+	}
+	p.srv = &http.Server{Handler: p}
+	go p.srv.Serve(ln)
+	return p, nil
+}
+
+func (p *proxy) URL() string { return "http://" + p.ln.Addr().String() + "/" }
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if p.propagate {
+		var cancel context.CancelFunc
+		var err error
+		if ctx, cancel, err = deadline.Extract(ctx, r.Header); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+		if ctx.Err() != nil {
+			p.expired.Add(1)
+			http.Error(w, "deadline exceeded", http.StatusGatewayTimeout)
+			return
+		}
+	}
+	work, err1 := strconv.ParseInt(r.Header.Get(workHeader), 10, 64)
+	audit, err2 := strconv.ParseInt(r.Header.Get(auditHeader), 10, 64)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "missing "+workHeader+" or "+auditHeader, http.StatusBadRequest)
+		return
+	}
+	ok, err := p.call(ctx, time.Duration(work)*time.Microsecond, time.Unix(0, audit))
+	switch {
+	case err != nil && ctx.Err() != nil, err == nil && !ok:
+		http.Error(w, "deadline exceeded", http.StatusGatewayTimeout)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	default:
+		w.Write([]byte("ok\n"))
+	}
+}
+
+var errReply = errors.New("malformed reply from the backend")
+
+// call sends one request to the backend and reports whether the backend
+// served it in full. When ctx ends first, the connection is closed under
+// the backend, which does not notice until it replies.
+func (p *proxy) call(ctx context.Context, work time.Duration, audit time.Time) (bool, error) {
+	conn, err := p.pool.Get(ctx)
+	if err != nil {
+		return false, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	buf := binary.BigEndian.AppendUint32(make([]byte, 0, 64), 0)
+	buf = appendRequest(buf, ctx, p.propagate, work, audit, []byte("ping"))
+	binary.BigEndian.PutUint32(buf, uint32(len(buf)-4))
+	_, err = conn.Write(buf)
+	if err == nil {
+		_, err = io.ReadFull(conn, buf[:4])
+	}
+	if n := binary.BigEndian.Uint32(buf); err == nil && (n < 1 || n > 64) {
+		err = errReply
+	}
+	if err == nil {
+		_, err = io.ReadFull(conn, buf[:binary.BigEndian.Uint32(buf)])
+	}
+	if !stop() || err != nil {
+		conn.Close() // may have a reply still in flight
+		if err == nil {
+			err = ctx.Err()
+		}
+		return false, err
+	}
+	p.pool.Put(conn)
+	return buf[0] == statusOK, nil
+}
+
+func (p *proxy) Close() error {
+	err := p.srv.Close()
+	p.pool.Close()
+	return err
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/deadline"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/internal/openloop"
+)
+
+// config is one run, as set by the flags.
+type config struct {
+	Propagate bool
+	Rate      int // requests per second
+	Duration  time.Duration
+	Timeout   time.Duration // client deadline per request
+	Workers   int           // requests the backend serves at once
+	Work      time.Duration // backend time for a normal request
+	Slow      float64       // fraction of requests that take SlowWork
+	SlowWork  time.Duration
+}
+
+func (c *config) validate() error {
+	switch {
+	case c.Rate <= 0 || c.Duration <= 0 || c.Timeout <= 0:
+		return errors.New("-rate, -duration and -timeout must be positive")
+	case c.Workers <= 0 || c.Work <= 0 || c.SlowWork <= 0:
+		return errors.New("-workers, -work and -slow-work must be positive")
+	case c.Slow < 0 || c.Slow > 1:
+		return errors.New("-slow must be between 0 and 1")
+	}
+	return nil
+}
+
+// second is one line of the report. Offered counts the requests the
+// client started in second T, and OK, TimedOut and Failed those that
+// ended in it, as the client saw them. The rest is the chain's side of
+// the second: requests the backend started work on, finished, and cut
+// short at their deadline, those the backend or the proxy dropped
+// unserved, and the worker time spent in all and on requests nobody was
+// waiting for any more. Queue is the backend's backlog at the end.
+type second struct {
+	T                         int
+	Offered, OK, TimedOut     int64
+	Failed                    int64
+	Started, Done, Cut, Drops int64
+	Busy, Wasted              time.Duration
+	Queue                     int64
+}
+
+// run starts the backend and the proxy, offers them load for
+// cfg.Duration, rounded up to whole seconds, and calls onSecond once a
+// second.
+func run(ctx context.Context, cfg config, onSecond func(second)) error {
+	be, err := listenBackend(cfg.Workers)
+	if err != nil {
+		return err
+	}
+	defer be.Close()
+	px, err := listenProxy(be.Addr(), cfg.Propagate)
+	if err != nil {
+		return err
+	}
+	defer px.Close()
+
+	var tr http.RoundTripper = &http.Transport{MaxIdleConns: 4096, MaxIdleConnsPerHost: 4096}
+	if cfg.Propagate {
+		tr = &deadline.Transport{Base: tr}
+	}
+	c := &http.Client{Transport: tr}
+	defer c.CloseIdleConnections()
+	url := px.URL()
+
+	seconds := int((cfg.Duration + time.Second - 1) / time.Second)
+	var mu sync.Mutex
+	var cur second
+	do := func() {
+		work := cfg.Work
+		if rand.Float64() < cfg.Slow {
+			work = cfg.SlowWork
+		}
+		rctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(rctx, "GET", url, nil)
+		dl, _ := rctx.Deadline()
+		req.Header.Set(workHeader, strconv.FormatInt(work.Microseconds(), 10))
+		req.Header.Set(auditHeader, strconv.FormatInt(dl.UnixNano(), 10))
+		resp, err := c.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err == nil && resp.StatusCode == http.StatusOK:
+			cur.OK++
+		case rctx.Err() != nil, err == nil && resp.StatusCode == http.StatusGatewayTimeout:
+			cur.TimedOut++
+		default:
+			cur.Failed++
+		}
+	}
+
+	var last second // backend counters at the previous report
+	openloop.Run(ctx, seconds, openloop.Rate(cfg.Rate), do, func(t int, offered int64) {
+		mu.Lock()
+		s := cur
+		cur = second{}
+		mu.Unlock()
+		s.T, s.Offered = t, offered
+		s.Started, s.Done, s.Cut = be.started.Load(), be.done.Load(), be.cut.Load()
+		s.Drops = be.dropped.Load() + px.expired.Load()
+		s.Busy, s.Wasted = time.Duration(be.busy.Load()), time.Duration(be.wasted.Load())
+		s.Queue = be.queued.Load()
+		onSecond(second{
+			T: s.T, Offered: s.Offered, OK: s.OK, TimedOut: s.TimedOut, Failed: s.Failed,
+			Started: s.Started - last.Started, Done: s.Done - last.Done,
+			Cut: s.Cut - last.Cut, Drops: s.Drops - last.Drops,
+			Busy: s.Busy - last.Busy, Wasted: s.Wasted - last.Wasted, Queue: s.Queue,
+		})
+		last = s
+	})
+	return nil
+}
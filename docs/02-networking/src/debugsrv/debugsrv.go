@@ -0,0 +1,153 @@
+// Package debugsrv serves the example servers' debug address: what Go
+// exposes about a running process, on a port of its own so that it never
+// shares a listener or a mux with the traffic being measured.
+//
+//	/debug/pprof/    net/http/pprof: profile, heap, allocs, block, mutex,
+//	                 goroutine, threadcreate and trace
+//	/debug/vars      expvar as JSON, with whatever the server publishes
+//	/debug/metrics   every runtime/metrics value as text, one per line
+//	/metrics         metrics.Default in the Prometheus text format
+//
+// Every server takes it as -debug-addr, so grabbing a profile from an
+// example is one flag and one command:
+//
+//	go run echo-net.go -debug-addr localhost:6060
+//	go tool pprof 'http://localhost:6060/debug/pprof/profile?seconds=10'
+//	go tool pprof http://localhost:6060/debug/pprof/mutex
+package debugsrv
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/metrics"
+	"slices"
+	"strings"
+	"time"
+
+	prom "github.com/astavonin/go-optimization-guide/docs/02-networking/src/metrics"
+)
+
+// BlockRate and MutexFraction are what Listen turns block and mutex
+// profiling on at. The mutex fraction is left alone if the program has
+// set one already; the block rate cannot be read back, so it is always
+// set. On
+// echo-net.go under load they cost less CPU per request than the noise
+// between runs, and ten seconds leave enough samples to rank the call
+// sites.
+const (
+	BlockRate     = 10 * time.Microsecond
+	MutexFraction = 100
+)
+
+// Usage is the help text of a -debug-addr flag.
+const Usage = "Serve pprof, expvar and runtime/metrics under /debug/ on this address, e.g. localhost:6060 (empty disables)"
+
+// Handler returns a mux with the pprof, expvar and metrics endpoints, and
+// the Prometheus endpoint a scrape config points at.
+// It does not touch the block and mutex profile rates: the block and
+// mutex profiles stay empty until they are set.
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/metrics", serveMetrics)
+	mux.Handle("/metrics", prom.Default)
+	return mux
+}
+
+// Listen binds addr, turns on block and mutex profiling, and serves
+// Handler on it in the background for the life of the process. The bind
+// happens before it returns, so a busy port fails the server at startup
+// rather than in a log line later.
+func Listen(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("debugsrv: %w", err)
+	}
+	runtime.SetBlockProfileRate(int(BlockRate))
+	if runtime.SetMutexProfileFraction(-1) == 0 {
+		runtime.SetMutexProfileFraction(MutexFraction)
+	}
+	log.Printf("Debug endpoints on http://%s/debug/pprof/", ln.Addr())
+	go func() {
+		if err := http.Serve(ln, Handler()); err != nil {
+			log.Printf("debugsrv: %v", err)
+		}
+	}()
+	return nil
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	WriteMetrics(w)
+}
+
+// WriteMetrics writes every metric runtime/metrics supports, sorted by
+// name, as "name value" lines. A histogram is summarised by its count
+// and its 50th, 99th and 100th percentiles, each the upper bound of the
+// bucket it falls in.
+func WriteMetrics(w io.Writer) error {
+	descs := metrics.All()
+	samples := make([]metrics.Sample, len(descs))
+	for i, d := range descs {
+		samples[i].Name = d.Name
+	}
+	metrics.Read(samples)
+	slices.SortFunc(samples, func(a, b metrics.Sample) int { return strings.Compare(a.Name, b.Name) })
+	for _, s := range samples {
+		var err error
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			_, err = fmt.Fprintf(w, "%s %d\n", s.Name, s.Value.Uint64())
+		case metrics.KindFloat64:
+			_, err = fmt.Fprintf(w, "%s %g\n", s.Name, s.Value.Float64())
+		case metrics.KindFloat64Histogram:
+			h := s.Value.Float64Histogram()
+			var n uint64
+			for _, c := range h.Counts {
+				n += c
+			}
+			_, err = fmt.Fprintf(w, "%s count=%d p50=%g p99=%g max=%g\n", s.Name, n,
+				quantile(h, n, 0.5), quantile(h, n, 0.99), quantile(h, n, 1))
+		default:
+			// A kind added after this was written; skip it.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quantile returns the upper bound of the bucket holding the q-th of the
+// n values in h, or its lower bound for the last bucket, which is open
+// above. It returns 0 for an empty histogram.
+func quantile(h *metrics.Float64Histogram, n uint64, q float64) float64 {
+	if n == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(n)))
+	var seen uint64
+	for i, c := range h.Counts {
+		seen += c
+		if seen >= rank && c > 0 {
+			if math.IsInf(h.Buckets[i+1], 1) {
+				return h.Buckets[i]
+			}
+			return h.Buckets[i+1]
+		}
+	}
+	return h.Buckets[len(h.Buckets)-1]
+}
@@ -0,0 +1,89 @@
+package debugsrv
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"runtime/metrics"
+	"strings"
+	"testing"
+
+	prom "github.com/astavonin/go-optimization-guide/docs/02-networking/src/metrics"
+)
+
+func get(t *testing.T, srv *httptest.Server, path string) string {
+	t.Helper()
+	resp, err := srv.Client().Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", path, resp.Status)
+	}
+	return string(body)
+}
+
+func TestHandler(t *testing.T) {
+	prom.Default.Counter("debugsrv_test_total", "").Inc()
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+
+	for path, want := range map[string]string{
+		"/debug/pprof/":                  "goroutine",
+		"/debug/pprof/goroutine?debug=1": "goroutine profile:",
+		"/debug/pprof/mutex?debug=1":     "--- mutex:",
+		"/debug/vars":                    `"memstats"`,
+		"/debug/metrics":                 "/gc/cycles/total:gc-cycles ",
+		"/metrics":                       "# TYPE debugsrv_test_total counter\ndebugsrv_test_total 1\n",
+	} {
+		if body := get(t, srv, path); !strings.Contains(body, want) {
+			t.Errorf("GET %s: no %q in\n%.300s", path, want, body)
+		}
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	var b strings.Builder
+	if err := WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	if !strings.Contains(out, "/sched/latencies:seconds count=") {
+		t.Errorf("no histogram summary in\n%s", out)
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) < len(metrics.All())/2 {
+		t.Errorf("%d lines for %d metrics", len(lines), len(metrics.All()))
+	}
+	for i := 1; i < len(lines); i++ {
+		if lines[i-1] > lines[i] {
+			t.Fatalf("not sorted: %q before %q", lines[i-1], lines[i])
+		}
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	h := &metrics.Float64Histogram{Counts: []uint64{5, 4, 1}, Buckets: []float64{0, 1, 2, math.Inf(1)}}
+	for _, tc := range []struct {
+		q    float64
+		want float64
+	}{
+		{0.5, 1},
+		{0.9, 2},
+		{0.99, 2}, // the last bucket is open, so its lower bound
+		{1, 2},
+	} {
+		if got := quantile(h, 10, tc.q); got != tc.want {
+			t.Errorf("quantile(%v) = %v, want %v", tc.q, got, tc.want)
+		}
+	}
+	if got := quantile(h, 0, 0.5); got != 0 {
+		t.Errorf("empty histogram: %v", got)
+	}
+}
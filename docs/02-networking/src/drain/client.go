@@ -0,0 +1,69 @@
+package drain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Client talks to a server's control address.
+type Client struct {
+	Addr string // host:port of the control listener
+	HTTP *http.Client
+}
+
+func (c *Client) do(ctx context.Context, method string) (Status, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+c.Addr+"/drain", nil)
+	if err != nil {
+		return Status{}, err
+	}
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return Status{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Status{}, fmt.Errorf("drain: %s %s: %s", method, c.Addr, resp.Status)
+	}
+	var s Status
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	return s, err
+}
+
+// Begin asks the server to start draining.
+func (c *Client) Begin(ctx context.Context) (Status, error) {
+	return c.do(ctx, http.MethodPost)
+}
+
+// Status fetches the server's drain state.
+func (c *Client) Status(ctx context.Context) (Status, error) {
+	return c.do(ctx, http.MethodGet)
+}
+
+// WaitDone polls Status every interval until the server reports the drain
+// as done or ctx expires. A server that exits as soon as it is drained
+// stops answering; the last Status seen is returned with the error.
+func (c *Client) WaitDone(ctx context.Context, interval time.Duration) (Status, error) {
+	var last Status
+	for {
+		s, err := c.Status(ctx)
+		if err != nil {
+			return last, err
+		}
+		last = s
+		if s.Done {
+			return s, nil
+		}
+		select {
+		case <-ctx.Done():
+			return last, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Package drain implements an out-of-band shutdown protocol for the example
+// servers, so graceful shutdown can be triggered and observed from a load
+// generator instead of by sending signals by hand.
+//
+// A server exposes a Controller on a separate control address:
+//
+//	POST /drain  begin draining (idempotent)
+//	GET  /drain  current Status as JSON
+//
+// Draining means: stop accepting, let in-flight work finish, and close idle
+// connections after a grace period. The Controller counts in-flight units
+// (connections, requests, streams: whatever the server calls Acquire for)
+// and records how long it took for them to reach zero.
+package drain
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Status is the drain state reported over the control channel.
+type Status struct {
+	Draining bool          `json:"draining"`
+	Active   int64         `json:"active"`
+	Started  time.Time     `json:"started,omitzero"`
+	Done     bool          `json:"done"`
+	Duration time.Duration `json:"duration_ns"` // time from Begin until Active reached zero
+}
+
+// Controller coordinates draining for one server.
+type Controller struct {
+	// Grace is how long idle tracked connections may stay open after Begin.
+	Grace time.Duration
+
+	active   atomic.Int64
+	draining chan struct{}
+	idle     chan struct{} // signalled when active may have reached zero
+
+	mu       sync.Mutex
+	started  time.Time
+	finished time.Time
+	hooks    []func()
+	conns    map[net.Conn]struct{}
+}
+
+// New returns a Controller with a 5 second grace period.
+func New() *Controller {
+	return &Controller{
+		Grace:    5 * time.Second,
+		draining: make(chan struct{}),
+		idle:     make(chan struct{}, 1),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// OnDrain registers f to run (once, in its own goroutine) when draining
+// begins, typically to close a listener.
+func (c *Controller) OnDrain(f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.started.IsZero() {
+		go f()
+		return
+	}
+	c.hooks = append(c.hooks, f)
+}
+
+// Begin starts draining. Calling it more than once has no effect.
+func (c *Controller) Begin() {
+	c.mu.Lock()
+	if !c.started.IsZero() {
+		c.mu.Unlock()
+		return
+	}
+	c.started = time.Now()
+	close(c.draining)
+	hooks := c.hooks
+	c.hooks = nil
+	deadline := c.started.Add(c.Grace)
+	for conn := range c.conns {
+		conn.SetReadDeadline(deadline)
+	}
+	c.mu.Unlock()
+
+	for _, f := range hooks {
+		go f()
+	}
+	c.signalIdle()
+}
+
+// Draining returns a channel that is closed once draining has begun.
+func (c *Controller) Draining() <-chan struct{} { return c.draining }
+
+// IsDraining reports whether Begin has been called.
+func (c *Controller) IsDraining() bool {
+	select {
+	case <-c.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Acquire marks one unit of work as in flight.
+func (c *Controller) Acquire() { c.active.Add(1) }
+
+// Release marks one unit of work as finished.
+func (c *Controller) Release() {
+	if c.active.Add(-1) == 0 {
+		c.signalIdle()
+	}
+}
+
+func (c *Controller) signalIdle() {
+	select {
+	case c.idle <- struct{}{}:
+	default:
+	}
+}
+
+// Track counts conn as in flight until the returned function is called.
+// Once draining begins, a blocked Read on conn fails after Grace, so idle
+// connections do not hold the drain open.
+func (c *Controller) Track(conn net.Conn) (release func()) {
+	c.Acquire()
+	c.mu.Lock()
+	c.conns[conn] = struct{}{}
+	if !c.started.IsZero() {
+		conn.SetReadDeadline(c.started.Add(c.Grace))
+	}
+	c.mu.Unlock()
+	return func() {
+		c.mu.Lock()
+		delete(c.conns, conn)
+		c.mu.Unlock()
+		c.Release()
+	}
+}
+
+// Wait blocks until draining has begun and no work is in flight, or ctx is
+// done. It returns how long the drain took.
+func (c *Controller) Wait(ctx context.Context) (time.Duration, error) {
+	select {
+	case <-c.draining:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	for c.active.Load() > 0 {
+		select {
+		case <-c.idle:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.finished.IsZero() {
+		c.finished = time.Now()
+	}
+	return c.finished.Sub(c.started), nil
+}
+
+// Status returns the current drain state.
+func (c *Controller) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := Status{Active: c.active.Load(), Started: c.started, Draining: !c.started.IsZero()}
+	if s.Draining && s.Active == 0 {
+		if c.finished.IsZero() {
+			c.finished = time.Now()
+		}
+		s.Done = true
+		s.Duration = c.finished.Sub(c.started)
+	}
+	return s
+}
+
+// ServeHTTP implements the control protocol.
+func (c *Controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		c.Begin()
+	case http.MethodGet:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.Status())
+}
+
+// Handler counts each request served by h as in flight.
+func (c *Controller) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Acquire()
+		defer c.Release()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ListenAndServe serves the control protocol on addr at /drain.
+func ListenAndServe(addr string, c *Controller) error {
+	mux := http.NewServeMux()
+	mux.Handle("/drain", c)
+	return http.ListenAndServe(addr, mux)
+}
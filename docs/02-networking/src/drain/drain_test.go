@@ -0,0 +1,97 @@
+package drain
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDrainLifecycle(t *testing.T) {
+	c := New()
+	hooked := make(chan struct{})
+	c.OnDrain(func() { close(hooked) })
+
+	c.Acquire()
+	if c.IsDraining() || c.Status().Draining {
+		t.Fatal("draining before Begin")
+	}
+	c.Begin()
+	c.Begin() // idempotent
+	<-hooked
+
+	s := c.Status()
+	if !s.Draining || s.Done || s.Active != 1 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+
+	done := make(chan time.Duration)
+	go func() {
+		d, err := c.Wait(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		done <- d
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.Release()
+	if d := <-done; d < 10*time.Millisecond {
+		t.Fatalf("drain took %v, want >= 10ms", d)
+	}
+	if s := c.Status(); !s.Done || s.Active != 0 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+}
+
+func TestTrackUnblocksIdleConn(t *testing.T) {
+	c := New()
+	c.Grace = 10 * time.Millisecond
+
+	server, client := net.Pipe()
+	defer client.Close()
+	release := c.Track(server)
+
+	readErr := make(chan error)
+	go func() {
+		_, err := server.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	c.Begin()
+	select {
+	case err := <-readErr:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Fatalf("got %v, want timeout", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("idle read not interrupted after grace")
+	}
+	release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := c.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestControlProtocol(t *testing.T) {
+	c := New()
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	cl := &Client{Addr: strings.TrimPrefix(srv.URL, "http://")}
+	ctx := context.Background()
+	s, err := cl.Status(ctx)
+	if err != nil || s.Draining {
+		t.Fatalf("Status = %+v, %v", s, err)
+	}
+	if _, err := cl.Begin(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s, err = cl.WaitDone(ctx, time.Millisecond)
+	if err != nil || !s.Done {
+		t.Fatalf("WaitDone = %+v, %v", s, err)
+	}
+}
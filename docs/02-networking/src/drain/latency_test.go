@@ -0,0 +1,27 @@
+package drain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/budget"
+)
+
+// TestLatencyBudget holds an HTTP server whose requests are counted by
+// Handler to a p99 budget, so the in-flight accounting stays off the
+// request's critical path.
+func TestLatencyBudget(t *testing.T) {
+	c := New()
+	srv := httptest.NewServer(c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})))
+	defer srv.Close()
+
+	res := budget.Run(t, budget.HTTP(srv.URL), budget.Load{})
+	budget.Check(t, res, 50*time.Millisecond)
+	if s := c.Status(); s.Active != 0 {
+		t.Fatalf("%d requests still in flight", s.Active)
+	}
+}
@@ -1,150 +1,1312 @@
 package main
 
 import (
+	"crypto/sha256"
+	"expvar"
+	"flag"
+	"fmt"
 	"log"
+	"math"
+	"math/bits"
 	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/bufpool"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/connlimit"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/metrics"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/netaddr"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/poller"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/ratelimit"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/srvconfig"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/timingwheel"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	edge        = flag.Bool("et", false, "Register fds edge-triggered (EPOLLET or EV_CLEAR) and drain reads and writes until EAGAIN")
+	every       = flag.Duration("stats", 0, "Print wakeups, events and syscalls per second at this interval (0 disables)")
+	metricsAddr = flag.String("metrics", "", "Serve the counters as expvar JSON at /debug/vars on this address, e.g. localhost:6062 (empty disables)")
+
+	// Connections over the per-address limit are reset as they are
+	// accepted, before they cost a registration.
+	acceptRate  = flag.Float64("accept-rate", 0, "New connections per second allowed from each client address (0 disables)")
+	acceptBurst = flag.Int("accept-burst", 20, "Connections a client address may open at once before -accept-rate applies")
+
+	// cfg holds the listen address, the read buffer size, the socket
+	// buffers, -idle, -write-timeout, -max-conns and -reactors (see the
+	// srvconfig package). -idle closes connections with no events for
+	// that long, and -write-timeout those whose queued replies have not
+	// moved for that long. The goroutine-per-connection servers get the
+	// same from read and write deadlines. Here nothing blocks, so without
+	// them a client that vanished without a FIN, or stopped reading, keeps
+	// its fd, registration and queue forever. With -reactors above one,
+	// each loop has a poller, a wheel and a listening socket of its own,
+	// all bound to the address with SO_REUSEPORT, and the kernel spreads
+	// new connections over them by their address hash. -max-conns is
+	// split evenly between the loops, and each pauses or rejects on its
+	// own count, as it gets its own share of the connections.
+	cfg = srvconfig.Register(flag.CommandLine, srvconfig.Config{
+		Addr:        ":9000",
+		ReadBuffer:  4096,
+		IdleTimeout: 5 * time.Minute,
+		Reactors:    1,
+	}, srvconfig.Addr|srvconfig.ReadBuffer|srvconfig.SocketBuffers|srvconfig.IdleTimeout|srvconfig.WriteTimeout|srvconfig.Reactors|srvconfig.MaxConns)
+
+	// With -workers the loop only waits, accepts and dispatches. Each fd is
+	// armed one-shot, handed to a worker when it is reported, and re-armed
+	// by the worker once it is done, so handling that takes -work of CPU
+	// does not hold up every other client.
+	workers = flag.Int("workers", 0, "Serve clients on this many worker goroutines per loop, with fds armed one-shot (0 serves them on the loop)")
+	work    = flag.Duration("work", 0, "CPU time spent on each read before echoing it, standing in for request handling")
+
+	// SIGINT or SIGTERM closes the listener and gives each client until
+	// -drain-timeout to receive its queued replies and close its end. A
+	// second signal closes the rest at once.
+	drainTimeout = flag.Duration("drain-timeout", 5*time.Second, "On SIGINT or SIGTERM, how long clients get to receive their replies before they are closed (0 closes them at once)")
+
+	// With -codec the server echoes whole messages. A read that ends in
+	// the middle of one leaves its start in the client's codec.Stream until
+	// a later read completes it.
+	codecName = flag.String("codec", "", "Echo whole messages framed by this codec: line, length, length-crc, chunked or jsonl (empty echoes bytes as they arrive)")
+
+	// -spin polls with a zero timeout for that long before each blocking
+	// Wait, trading a core for the wakeup latency; -busy-poll asks the
+	// kernel to spin on the NIC queue under each socket read the same way.
+	spinFor  = flag.Duration("spin", 0, "Poll without blocking for this long before each blocking Wait (0 always blocks)")
+	busyPoll = flag.Duration("busy-poll", 0, "Set SO_BUSY_POLL to this on accepted sockets, on Linux (0 leaves it unset)")
+
+	bufMode = flag.String("bufs", "pool", "Read buffers: pool (a sync.Pool, taken for each read), freelist (a bounded free list, taken for each read), conn (one per connection), shared (one for the loop)")
 )
 
+// acceptBatch caps the connections accepted per listener event, so that a
+// connection storm cannot keep the loop from the clients it already has.
+// The listener is level-triggered in every mode: what a batch leaves in
+// the queue is reported again by the next Wait.
+const acceptBatch = 64
+
+// wakeBuckets covers 1 to poller.MaxEvents events per wakeup in powers of
+// two.
+const wakeBuckets = 9
+
+// counters are kept by the event loops, and by the workers with -workers,
+// and read by the stats printer and the metrics endpoint.
+var counters struct {
+	rejected, overLimit, acceptPauses     atomic.Uint64
+	wakeups, events                       atomic.Uint64
+	perWakeup                             [wakeBuckets]atomic.Uint64
+	reads, emptyReads, fullWrites, ctls   atomic.Uint64
+	bufAllocs, halfCloses, resets, reaped atomic.Uint64
+	writeTimeouts                         atomic.Uint64
+	frames, splits, protoErrors           atomic.Uint64
+	spinPolls, spinHits, spinBlocked      atomic.Uint64
+}
+
+// echoMetrics holds the open and accepted connections, the bytes each way
+// and the writes, which the counters above leave to it, and the handling
+// time of each read, or with -codec of each message. The other echo
+// servers register the same set, and -debug-addr serves it at /metrics.
+var echoMetrics = metrics.NewEcho(metrics.Default)
+
+// stats is a snapshot of the counters, which -metrics publishes through
+// expvar. Everything but Conns only grows, so a scraper takes rates from
+// the difference between two snapshots, as printStats does.
+type stats struct {
+	Conns    int64  `json:"conns"`    // connections open
+	Accepts  uint64 `json:"accepts"`  // connections accepted
+	Rejected uint64 `json:"rejected"` // connections reset by -accept-rate
+
+	// With -max-conns. OverLimit counts connections closed at the limit
+	// with -over-limit reject; AcceptPauses counts the times a loop
+	// reached it with -over-limit pause and stopped watching its listener.
+	OverLimit    uint64 `json:"rejected_busy"`
+	AcceptPauses uint64 `json:"accept_pauses"`
+
+	Wakeups uint64 `json:"wakeups"` // Waits that returned, with events or for a timer
+	Events  uint64 `json:"events"`  // events the Waits returned
+	// EventsPerWakeup[i] counts Waits that returned [2^i, 2^(i+1))
+	// events. A loop under load returns many events per Wait; one that
+	// wakes for every event pays a syscall for each.
+	EventsPerWakeup []uint64 `json:"events_per_wakeup"`
+
+	Reads      uint64 `json:"reads"`
+	ReadsEmpty uint64 `json:"reads_eagain"` // reads that found nothing, the end of every edge-triggered drain
+	Writes     uint64 `json:"writes"`
+	WritesFull uint64 `json:"writes_eagain"` // writes the socket had no room for
+	BytesIn    uint64 `json:"bytes_in"`
+	BytesOut   uint64 `json:"bytes_out"`
+	Ctls       uint64 `json:"epoll_ctls"` // interest changes, and re-arms with -workers
+	BufAllocs  uint64 `json:"buffer_allocs"`
+
+	HalfCloses uint64 `json:"closed_fin"`
+	Resets     uint64 `json:"closed_reset"`
+	Reaped     uint64 `json:"closed_idle"`
+	// With -write-timeout: clients reset because their queued replies
+	// did not move for that long.
+	WriteTimeouts uint64 `json:"closed_write_timeout"`
+
+	// With -codec. A split read ended inside a message, whose start
+	// waits in the client's buffer for the rest.
+	Frames      uint64 `json:"frames"`
+	Splits      uint64 `json:"reads_split"`
+	ProtoErrors uint64 `json:"closed_protocol"` // messages over the limit or malformed
+
+	// With -spin. A hit is a Wait that found events while spinning; a
+	// Wait that spun out blocks, and counts in SpinBlocked.
+	SpinPolls   uint64 `json:"spin_polls"`
+	SpinHits    uint64 `json:"spin_hits"`
+	SpinBlocked uint64 `json:"spin_blocked"`
+}
+
+func loadStats() stats {
+	s := stats{
+		Conns: echoMetrics.Conns.Load(), Accepts: echoMetrics.Accepted.Load(), Rejected: counters.rejected.Load(),
+		OverLimit: counters.overLimit.Load(), AcceptPauses: counters.acceptPauses.Load(),
+		Wakeups: counters.wakeups.Load(), Events: counters.events.Load(),
+		EventsPerWakeup: make([]uint64, wakeBuckets),
+		Reads:           counters.reads.Load(), ReadsEmpty: counters.emptyReads.Load(),
+		Writes: echoMetrics.Flushes.Load(), WritesFull: counters.fullWrites.Load(),
+		BytesIn: echoMetrics.BytesIn.Load(), BytesOut: echoMetrics.BytesOut.Load(),
+		Ctls: counters.ctls.Load(), BufAllocs: counters.bufAllocs.Load(),
+		HalfCloses: counters.halfCloses.Load(), Resets: counters.resets.Load(), Reaped: counters.reaped.Load(),
+		WriteTimeouts: counters.writeTimeouts.Load(), Frames: counters.frames.Load(), Splits: counters.splits.Load(), ProtoErrors: counters.protoErrors.Load(),
+		SpinPolls: counters.spinPolls.Load(), SpinHits: counters.spinHits.Load(), SpinBlocked: counters.spinBlocked.Load(),
+	}
+	for i := range s.EventsPerWakeup {
+		s.EventsPerWakeup[i] = counters.perWakeup[i].Load()
+	}
+	return s
+}
+
+// maxPending caps the bytes queued for a client that is not reading its
+// replies. Past it, the loop stops reading from that client until the queue
+// drains, so a slow reader pushes back on its own input instead of growing
+// the server's memory.
+const maxPending = 1 << 20
+
+// client is the per-fd state: the bytes the kernel has not accepted yet,
+// and what the loop is waiting for. With -workers, mu is held by whoever
+// serves or closes the client: the worker handling its event, or the loop
+// reaping or draining it.
+type client struct {
+	mu       sync.Mutex
+	closed   bool
+	buf      *[]byte // read buffer, while the client holds one
+	out      []byte
+	events   poller.Event          // current interest
+	paused   bool                  // edge-triggered: reading stopped with the queue full
+	eof      bool                  // the peer shut down its side: send what is queued, then close
+	draining bool                  // the server is shutting down: send what is queued, then shut down writing
+	shut     bool                  // writing is shut down: discard input until the peer's FIN, then close
+	idle     *timingwheel.Deadline // with -idle, moved on by every event
+	stalled  *timingwheel.Deadline // with -write-timeout, armed while replies are queued
+	frames   *codec.Stream         // with -codec
+}
+
+// loopResult is what an event loop reports as it exits: whether it drained
+// its clients, how many it had when draining began, and how many it
+// closed at the end.
+type loopResult struct {
+	drained        bool
+	draining, left int64
+}
+
+// Stop levels, raised by signals and by errors the loop cannot recover
+// from.
+const (
+	running  = iota
+	drainAll // stop accepting and drain the clients
+	closeAll // close every client now
+)
+
+// server is what the event loops share: the read buffers, the codec, the
+// per-address limit and the stop level. The counters are package-level,
+// for the stats printer and the metrics endpoint.
+type server struct {
+	// getBuf and putBuf give a client a read buffer and take it back.
+	// With -bufs conn the client keeps its buffer for life, so putBuf is
+	// nil. Every mode is safe here, because echo copies what the socket
+	// does not take into the client's queue before the buffer is reused.
+	// The modes differ in what they cost: shared holds one buffer but ties
+	// the reads to a single loop goroutine; conn holds one per connection,
+	// 40 MB for 10,000 idle ones; pool and freelist hold about as many as
+	// are in use at once and pay a Get and a Put per event.
+	getBuf func() *[]byte
+	putBuf func(*[]byte)
+
+	// enc only encodes, which keeps no state, so the loops and the workers
+	// share it; each client decodes with a codec of its own. encBufs holds
+	// the buffers replies are encoded into.
+	enc     codec.Codec
+	encBufs sync.Pool
+	// busy is what a connection over -max-conns gets with -over-limit
+	// reject: the codec's Busy message, or without -codec, a reset.
+	busy  []byte
+	perIP *ratelimit.PerKey[netip.Addr] // with -accept-rate
+
+	// A loop blocks in Wait, where a signal cannot reach it: the runtime
+	// takes signals on a thread of its own. stop raises the stop level and
+	// writes to a pipe each loop watches, which wakes it. Signals come from
+	// a goroutine and errors from the workers as well as the loops, so the
+	// level is atomic. A pipe, rather than an eventfd, works with kqueue
+	// too. The pipes are closed only once every loop is done, so a late
+	// stop never writes to an fd that was reused for a client.
+	wakes               [][2]int
+	stopLevel, exitCode atomic.Int32
+}
+
+func (s *server) stop(level int32) {
+	for {
+		cur := s.stopLevel.Load()
+		if cur >= level || s.stopLevel.CompareAndSwap(cur, level) {
+			break
+		}
+	}
+	for _, wake := range s.wakes {
+		syscall.Write(wake[1], []byte{0})
+	}
+}
+
+// fail is log.Fatal for errors after clients have connected: the loops
+// close them on their way out, rather than leaving each one to the exit.
+func (s *server) fail(v ...any) {
+	log.Println(v...)
+	s.exitCode.Store(1)
+	s.stop(closeAll)
+}
+
 func main() {
-	// Create an epoll file descriptor.
-	epfd, err := syscall.EpollCreate1(0)
-	if err != nil {
-		log.Fatal("EpollCreate1 error:", err)
+	flag.Parse()
+	if err := cfg.Apply(flag.CommandLine); err != nil {
+		log.Fatal(err)
 	}
-	defer syscall.Close(epfd)
 
-	// Start listening on port 9000.
-	ln, err := net.Listen("tcp", ":9000")
-	if err != nil {
-		log.Fatal("Listen error:", err)
+	// Start listening, on port 9000 unless -addr says otherwise. The
+	// listening sockets are raw fds like the clients, so each loop accepts
+	// its own.
+	lfds := make([]int, cfg.Reactors)
+	var err error
+	for i := range lfds {
+		if lfds[i], err = listen(cfg.Addr, cfg.Reactors > 1); err != nil {
+			log.Fatal("Listen error:", err)
+		}
+	}
+	srv := &server{}
+	if *acceptRate > 0 {
+		srv.perIP = ratelimit.NewPerKey[netip.Addr](*acceptRate, *acceptBurst)
 	}
-	defer ln.Close()
 
-	// Use sync.Map to store the mapping from file descriptor to connection.
-	var conns sync.Map // key: int, value: net.Conn
+	// readBufSize is the size of a read buffer, and the most one read
+	// takes.
+	readBufSize := cfg.ReadBuffer
+	switch *bufMode {
+	case "shared":
+		shared := make([]byte, readBufSize)
+		srv.getBuf, srv.putBuf = func() *[]byte { return &shared }, func(*[]byte) {}
+		counters.bufAllocs.Add(1)
+	case "conn":
+		srv.getBuf = func() *[]byte {
+			counters.bufAllocs.Add(1)
+			b := make([]byte, readBufSize)
+			return &b
+		}
+	case "pool":
+		pool := bufpool.New(readBufSize, readBufSize)
+		srv.getBuf = func() *[]byte {
+			b := pool.Get(readBufSize)
+			counters.bufAllocs.Store(pool.Misses())
+			return b
+		}
+		srv.putBuf = pool.Put
+	case "freelist":
+		// Enough buffers for every event one Wait can return.
+		fl := bufpool.NewFreelist(readBufSize, 512)
+		srv.getBuf = func() *[]byte {
+			b := fl.Get()
+			counters.bufAllocs.Store(fl.Misses())
+			return b
+		}
+		srv.putBuf = fl.Put
+	default:
+		log.Fatalf("unknown -bufs %q", *bufMode)
+	}
+	if *codecName != "" {
+		if srv.enc, err = codec.New(*codecName, 0); err != nil {
+			log.Fatal(err)
+		}
+		srv.busy = connlimit.BusyReply(srv.enc)
+	}
+	srv.encBufs.New = func() any { b := make([]byte, 0, readBufSize); return &b }
+	if *workers > 0 && *bufMode == "shared" {
+		log.Fatal("-bufs shared needs the reads on one goroutine, which -workers spreads over many")
+	}
+	if cfg.Reactors > 1 && *bufMode == "shared" {
+		log.Fatal("-bufs shared needs the reads on one goroutine, which -reactors spreads over many")
+	}
+
+	if *every > 0 {
+		go printStats(*every)
+	}
+	if *metricsAddr != "" {
+		expvar.Publish("echo_epoll", expvar.Func(func() any { return loadStats() }))
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, nil); err != nil {
+				log.Printf("metrics listener: %v", err)
+			}
+		}()
+	}
 
-	// Accept new connections in a separate goroutine.
+	srv.wakes = make([][2]int, cfg.Reactors)
+	for i := range srv.wakes {
+		if srv.wakes[i], err = wakePipe(); err != nil {
+			log.Fatal("pipe error:", err)
+		}
+	}
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
+		for level := int32(drainAll); ; level = closeAll {
+			log.Println("Got", <-sigs)
+			srv.stop(level)
+		}
+	}()
+
+	results := make([]loopResult, cfg.Reactors)
+	var wg sync.WaitGroup
+	for i, lfd := range lfds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = newLoop(srv, i, lfd, srv.wakes[i]).run()
+		}()
+	}
+	wg.Wait()
+
+	signal.Stop(sigs)
+	for _, wake := range srv.wakes {
+		syscall.Close(wake[0])
+		syscall.Close(wake[1])
+	}
+	var res loopResult
+	for _, r := range results {
+		res.drained = res.drained || r.drained
+		res.draining += r.draining
+		res.left += r.left
+	}
+	if !res.drained {
+		log.Printf("Shut down: closed %d connections", res.left)
+	} else {
+		log.Printf("Shut down: %d of %d connections finished draining, %d closed", res.draining-res.left, res.draining, res.left)
+	}
+	os.Exit(int(srv.exitCode.Load()))
+}
+
+// loop is one event loop, which serves the clients it accepts on its own
+// listening socket until the stop level tells it to exit. Each loop owns
+// its poller, wheel and clients; the buffers, the codec and the counters
+// are shared.
+type loop struct {
+	srv  *server
+	name string // prefixes the loop's log lines with -reactors
+	p    poller.Poller
+	wake [2]int
+
+	// The wheel holds each client's two deadlines. Moving one later,
+	// which every event does to the idle deadline, only records the new
+	// expiry; the timer underneath fires where it was and re-arms itself
+	// for the time left. The loop advances the wheel between Waits, so
+	// the callbacks run on the loop goroutine like serve does. A tick is
+	// a sixteenth of the shorter timeout: with the default 5 minutes,
+	// about 19s, and a connection is closed within a tick of its
+	// timeout. Four levels of 64 slots reach 16.7 million ticks, so
+	// neither timeout ever outruns the wheel.
+	wheel *timingwheel.Hierarchical
+
+	// acceptPaused is set while the loop has no fd to spare for a new
+	// connection and has stopped watching its listener, lfd, which is -1
+	// once closed. With -workers, clients are closed on the workers too,
+	// so pausing and resuming take pauseMu, as does closing the listener.
+	lfd          int
+	acceptPaused atomic.Bool
+	pauseMu      sync.Mutex
+
+	// clients holds every open client of this loop, for shutdown to drain
+	// and close, and conns counts them. The loop adds them and whoever
+	// closes one removes it.
+	clientsMu sync.Mutex
+	clients   map[int]*client
+	conns     atomic.Int64
+
+	// limit holds a slot for each open client with -max-conns. Only
+	// the loop takes slots; closeClient gives them back, on the loop
+	// or on a worker.
+	limit *connlimit.Limit
+
+	jobs chan job // with -workers; see handle
+}
+
+// job is a client's event, queued for a worker.
+type job struct {
+	fd int
+	c  *client
+	ev poller.Event
+}
+
+// newLoop sets up a loop on the listening socket lfd, woken by wake, and
+// starts its workers.
+func newLoop(srv *server, id int, lfd int, wake [2]int) *loop {
+	l := &loop{srv: srv, lfd: lfd, wake: wake, clients: make(map[int]*client)}
+	if cfg.Reactors > 1 {
+		l.name = fmt.Sprintf("reactor %d: ", id)
+	}
+
+	// Create the poller: epoll on Linux, kqueue on macOS and the BSDs.
+	var err error
+	if l.p, err = poller.New(); err != nil {
+		log.Fatal("poller error:", err)
+	}
+	if err := l.p.Add(wake[0], poller.Read, func(fd int, ev poller.Event) {
+		var b [16]byte
 		for {
-			conn, err := ln.Accept()
-			if err != nil {
-				log.Println("Accept error:", err)
-				continue
+			if n, _ := syscall.Read(fd, b[:]); n <= 0 {
+				return
 			}
+		}
+	}); err != nil {
+		log.Fatal("poller Add error on wake pipe:", err)
+	}
 
-			// Assert the connection as a TCP connection.
-			tcpConn, ok := conn.(*net.TCPConn)
-			if !ok {
-				conn.Close()
-				continue
-			}
+	shortest := cfg.IdleTimeout
+	if cfg.WriteTimeout > 0 && (shortest == 0 || cfg.WriteTimeout < shortest) {
+		shortest = cfg.WriteTimeout
+	}
+	if shortest > 0 {
+		l.wheel = timingwheel.NewHierarchical(max(shortest/16, 10*time.Millisecond), 64, 4)
+	}
+	if cfg.MaxConns > 0 {
+		l.limit = connlimit.New((cfg.MaxConns + cfg.Reactors - 1) / cfg.Reactors)
+	}
+	if *workers > 0 {
+		l.jobs = make(chan job, *workers)
+		for range *workers {
+			go l.worker()
+		}
+	}
+	if err := l.p.Add(lfd, poller.Read, l.accept); err != nil {
+		log.Fatal("poller Add error on listener:", err)
+	}
+	return l
+}
 
-			// Obtain the raw connection to extract the file descriptor.
-			rawConn, err := tcpConn.SyscallConn()
-			if err != nil {
-				log.Println("SyscallConn error:", err)
-				conn.Close()
-				continue
-			}
+func (l *loop) pauseAccept() {
+	l.pauseMu.Lock()
+	defer l.pauseMu.Unlock()
+	if err := l.p.Mod(l.lfd, 0); err != nil {
+		l.srv.fail("poller Mod error on listener:", err)
+		return
+	}
+	l.acceptPaused.Store(true)
+}
 
-			var fd int
-			err = rawConn.Control(func(f uintptr) {
-				fd = int(f)
-			})
-			if err != nil {
-				log.Println("Control error:", err)
-				conn.Close()
-				continue
-			}
+func (l *loop) resumeAccept() {
+	l.pauseMu.Lock()
+	defer l.pauseMu.Unlock()
+	if l.acceptPaused.Load() {
+		l.acceptPaused.Store(false)
+		if err := l.p.Mod(l.lfd, poller.Read); err != nil {
+			l.srv.fail("poller Mod error on listener:", err)
+		}
+	}
+}
 
-			// Set the file descriptor to non-blocking mode.
-			if err = syscall.SetNonblock(fd, true); err != nil {
-				log.Println("SetNonblock error:", err)
-				conn.Close()
-				continue
+func (l *loop) closeListener() {
+	l.pauseMu.Lock()
+	defer l.pauseMu.Unlock()
+	if l.lfd >= 0 {
+		l.acceptPaused.Store(false)
+		l.p.Del(l.lfd)
+		syscall.Close(l.lfd)
+		l.lfd = -1
+	}
+}
+
+// closeClient removes fd from the poller, closes it and drops its
+// client. The fd it frees lets a paused listener accept again.
+func (l *loop) closeClient(fd int, c *client) {
+	if c.idle != nil {
+		c.idle.Stop()
+	}
+	if c.stalled != nil {
+		c.stalled.Stop()
+	}
+	l.p.Del(fd)
+	syscall.Close(fd)
+	c.closed = true
+	l.clientsMu.Lock()
+	delete(l.clients, fd)
+	l.clientsMu.Unlock()
+	// A worker closing the last client of a drain wakes the loop,
+	// which would otherwise wait out the deadline.
+	echoMetrics.Conns.Add(-1)
+	if l.conns.Add(-1) == 0 && l.srv.stopLevel.Load() != running {
+		syscall.Write(l.wake[1], []byte{0})
+	}
+	if l.limit != nil {
+		l.limit.Release()
+	}
+	if l.acceptPaused.Load() {
+		l.resumeAccept()
+	}
+}
+
+// expire runs on the loop when one of c's deadlines, d, passes. A
+// worker that holds the client is serving an event, so the client is
+// not idle and its queue may be moving; the deadline is pushed out
+// rather than waited for. A deadline that passed as a worker closed
+// the client finds it closed. A client whose replies stopped moving
+// is reset: what is queued will not be delivered anyway, and a
+// graceful close would leave the kernel retrying it.
+func (l *loop) expire(fd int, c *client, d *timingwheel.Deadline) {
+	idle := d == c.idle
+	if *workers > 0 {
+		if !c.mu.TryLock() {
+			if idle {
+				d.Set(cfg.IdleTimeout)
+			} else {
+				d.Set(cfg.WriteTimeout)
 			}
+			return
+		}
+		defer c.mu.Unlock()
+	}
+	if c.closed {
+		return
+	}
+	if idle {
+		counters.reaped.Add(1)
+	} else {
+		counters.writeTimeouts.Add(1)
+		syscall.SetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER, &syscall.Linger{Onoff: 1, Linger: 0})
+	}
+	l.closeClient(fd, c)
+}
+
+// queued keeps the write deadline: armed when a reply is first left
+// queued, moved on whenever a write takes some of the queue, and
+// cleared once it is empty. A client that reads its replies never
+// has it fire however large they are; one that stops reading does.
+func (c *client) queued(moved bool) {
+	switch {
+	case c.stalled == nil:
+	case len(c.out) == 0:
+		c.stalled.Clear()
+	case moved:
+		c.stalled.Set(cfg.WriteTimeout)
+	}
+}
 
-			// Register the file descriptor with epoll for read events.
-			event := &syscall.EpollEvent{
-				Events: syscall.EPOLLIN,
-				Fd:     int32(fd),
+// watch changes the events the poller reports for fd, if they differ.
+// An edge-triggered fd keeps the interest it was registered with. A
+// one-shot fd is re-armed with c.events by its worker, once serve
+// returns.
+func (l *loop) watch(fd int, c *client, events poller.Event) error {
+	if *edge || c.events == events {
+		return nil
+	}
+	c.events = events
+	if *workers > 0 {
+		return nil
+	}
+	counters.ctls.Add(1)
+	return l.p.Mod(fd, events)
+}
+
+// write is one write syscall. A full socket is not an error: it
+// takes nothing.
+func write(fd int, p []byte) (int, error) {
+	echoMetrics.Flushes.Inc()
+	nwritten, err := syscall.Write(fd, p)
+	if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK {
+		counters.fullWrites.Add(1)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	echoMetrics.BytesOut.Add(uint64(nwritten))
+	return nwritten, nil
+}
+
+// flush writes as much of c.out as the socket takes, then watches for
+// writability while anything is left and for input while the queue is
+// under maxPending and the client is still read from.
+func (l *loop) flush(fd int, c *client) error {
+	moved := false
+	for len(c.out) > 0 {
+		nwritten, err := write(fd, c.out)
+		if err != nil {
+			return err
+		}
+		if nwritten == 0 {
+			break
+		}
+		c.out, moved = c.out[nwritten:], true
+	}
+	c.queued(moved)
+	var events poller.Event
+	if len(c.out) < maxPending && !c.eof && !c.draining {
+		events |= poller.Read
+	}
+	if len(c.out) > 0 {
+		events |= poller.Write
+	} else {
+		c.out = nil // release the buffer; most clients never need one
+	}
+	return l.watch(fd, c, events)
+}
+
+// echo sends p back to the client. While a queue exists, new data goes
+// behind it to keep the order. Whatever the socket does not take now
+// is queued until the socket is writable.
+func (l *loop) echo(fd int, c *client, p []byte) error {
+	if len(c.out) > 0 {
+		c.out = append(c.out, p...)
+		if len(c.out) >= maxPending {
+			return l.watch(fd, c, poller.Write)
+		}
+		return nil
+	}
+	nwritten, err := write(fd, p)
+	if err != nil || nwritten == len(p) {
+		return err
+	}
+	c.out = append(c.out, p[nwritten:]...)
+	c.queued(true)
+	return l.watch(fd, c, poller.Read|poller.Write)
+}
+
+// reply answers what one read returned: the bytes themselves, or with
+// -codec the messages they complete, encoded again into a buffer taken
+// for the call. echo copies what the socket does not take, so the
+// buffer goes back at once. The replies to the messages before a
+// protocol error are sent before the error is returned. The time
+// since start, when the read returned, is recorded for each
+// request answered: the read, or each message it completed.
+func (l *loop) reply(fd int, c *client, p []byte, start time.Time) error {
+	if c.frames == nil {
+		err := l.echo(fd, c, p)
+		echoMetrics.Latency.ObserveDuration(time.Since(start))
+		return err
+	}
+	msgs, err := c.frames.Feed(p)
+	counters.frames.Add(uint64(len(msgs)))
+	if c.frames.Buffered() > 0 {
+		counters.splits.Add(1)
+	}
+	if len(msgs) > 0 {
+		b := l.srv.encBufs.Get().(*[]byte)
+		out := (*b)[:0]
+		for _, m := range msgs {
+			out, _ = l.srv.enc.Encode(out, m) // decoded under the same limit
+		}
+		werr := l.echo(fd, c, out)
+		echoMetrics.Latency.ObserveN(time.Since(start).Seconds(), len(msgs))
+		*b = out
+		l.srv.encBufs.Put(b)
+		if werr != nil {
+			return werr
+		}
+	}
+	return err
+}
+
+// discard reads and drops what a client sends after its writing was
+// shut down, until its FIN, and then closes it. Input left unread
+// would turn the close into a reset, and a reset throws away replies
+// the kernel has not delivered yet.
+func (l *loop) discard(fd int, c *client) {
+	if c.buf == nil {
+		c.buf = l.srv.getBuf()
+	}
+	for {
+		counters.reads.Add(1)
+		nread, err := syscall.Read(fd, *c.buf)
+		if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK {
+			counters.emptyReads.Add(1)
+			break
+		}
+		if err != nil || nread == 0 {
+			if nread == 0 {
+				counters.halfCloses.Add(1)
 			}
-			if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, event); err != nil {
-				log.Println("EpollCtl error:", err)
-				conn.Close()
-				continue
+			l.closeClient(fd, c)
+			break
+		}
+		echoMetrics.BytesIn.Add(uint64(nread))
+	}
+	if l.srv.putBuf != nil && c.buf != nil {
+		l.srv.putBuf(c.buf)
+		c.buf = nil
+	}
+}
+
+// finish ends a client whose replies have all been written: at once if
+// the peer has shut down its side, and otherwise by shutting down the
+// server's side, which sends a FIN after the replies, and discarding
+// input until the peer answers with its own.
+func (l *loop) finish(fd int, c *client) {
+	if c.eof {
+		l.closeClient(fd, c)
+		return
+	}
+	if err := syscall.Shutdown(fd, syscall.SHUT_WR); err != nil {
+		l.closeClient(fd, c)
+		return
+	}
+	c.shut = true
+	if err := l.watch(fd, c, poller.Read); err != nil {
+		log.Println("poller Mod error on fd", fd, err)
+		l.closeClient(fd, c)
+		return
+	}
+	l.discard(fd, c)
+}
+
+// serve handles the events of one client, on the loop or on a worker.
+func (l *loop) serve(fd int, c *client, ev poller.Event) {
+	if c.idle != nil {
+		c.idle.Set(cfg.IdleTimeout)
+	}
+	if c.shut {
+		l.discard(fd, c)
+		return
+	}
+
+	// Error comes with a reset or an unreachable peer, along with
+	// Hangup and usually Read. Reading would fail with the same error,
+	// after copying nothing; SO_ERROR says what it was and clears it.
+	// A reset is routine, so it is counted rather than logged, and so
+	// is EPIPE: a reply written after the peer closed drew the reset.
+	if ev&poller.Error != 0 {
+		soErr, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+		if err == nil && soErr != 0 {
+			if errno := syscall.Errno(soErr); errno == syscall.ECONNRESET || errno == syscall.EPIPE {
+				counters.resets.Add(1)
+			} else {
+				log.Println("Socket error on fd", fd, errno)
 			}
+			l.closeClient(fd, c)
+			return
+		}
+	}
 
-			// Save the connection in our sync.Map.
-			conns.Store(fd, conn)
+	// The socket has room again: write what is queued. An
+	// edge-triggered fd also reports writability with nothing queued.
+	if ev&poller.Write != 0 && len(c.out) > 0 {
+		if err := l.flush(fd, c); err != nil {
+			log.Println("Write error on fd", fd, err)
+			l.closeClient(fd, c)
+			return
 		}
-	}()
+		if (c.eof || c.draining) && len(c.out) == 0 {
+			l.finish(fd, c)
+			return
+		}
+	}
 
-	// Buffer for epoll events and for reading data.
-	events := make([]syscall.EpollEvent, 128)
-	readBuf := make([]byte, 4096)
+	// An edge-triggered fd reports input once. If reading stopped
+	// for a full queue, the flush that drained it has to resume
+	// reading, or the input already buffered is never read. A
+	// draining client is not read from until its replies are out.
+	readable := ev&(poller.Read|poller.Error) != 0 && !c.eof && !c.draining
+	if c.paused && len(c.out) < maxPending && !c.eof && !c.draining {
+		c.paused, readable = false, true
+	}
 
-	// Event loop.
-	for {
-		n, err := syscall.EpollWait(epfd, events, -1)
+	// Read available data from the connection: once when
+	// level-triggered, since the poller reports the fd again while
+	// data is left, and until EAGAIN when edge-triggered, since it
+	// does not. After a Hangup only the data before the peer's FIN is
+	// left, so it is read to the end at once. The buffer is taken only
+	// for the reads and given back after them.
+	drain := *edge || ev&poller.Hangup != 0
+	if readable && c.buf == nil {
+		c.buf = l.srv.getBuf()
+	}
+	for readable {
+		if len(c.out) >= maxPending {
+			c.paused = *edge
+			break
+		}
+		counters.reads.Add(1)
+		nread, err := syscall.Read(fd, *c.buf)
 		if err != nil {
-			if err == syscall.EINTR {
-				continue
+			// If no data is available, try again.
+			if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK {
+				counters.emptyReads.Add(1)
+				break
+			}
+			log.Println("Read error on fd", fd, err)
+			l.closeClient(fd, c)
+			break
+		}
+		// A zero-byte read is the peer's FIN. The peer may be waiting
+		// for the rest of its replies, as a client that shut down its
+		// write side to mark the end of its input is, so the queue is
+		// sent before the connection is closed. Read interest goes:
+		// EOF stays readable, and a level-triggered fd would be
+		// reported on every Wait.
+		if nread == 0 {
+			c.eof = true
+			counters.halfCloses.Add(1)
+			if len(c.out) == 0 {
+				l.closeClient(fd, c)
+			} else if err := l.watch(fd, c, poller.Write); err != nil {
+				log.Println("poller Mod error on fd", fd, err)
+				l.closeClient(fd, c)
+			}
+			break
+		}
+		echoMetrics.BytesIn.Add(uint64(nread))
+		start := time.Now()
+		if *work > 0 {
+			spin((*c.buf)[:nread], *work)
+		}
+		if err := l.reply(fd, c, (*c.buf)[:nread], start); err != nil {
+			if err == codec.ErrTooLarge || err == codec.ErrInvalid || err == codec.ErrChecksum {
+				counters.protoErrors.Add(1)
+			} else {
+				log.Println("Write error on fd", fd, err)
 			}
-			log.Fatal("EpollWait error:", err)
+			l.closeClient(fd, c)
+			break
 		}
+		readable = drain
+	}
+	if l.srv.putBuf != nil && c.buf != nil {
+		l.srv.putBuf(c.buf)
+		c.buf = nil
+	}
+}
 
-		// Process each event.
-		for i := 0; i < n; i++ {
-			fd := int(events[i].Fd)
+// handle is what the poller calls for a client's events. Without
+// workers it is serve, on the loop. With them, it queues the event for
+// the pool, whose size bounds the goroutines that serve clients at
+// once. When every worker is busy and the queue is full, the loop
+// blocks on the send: it stops taking events until a worker is free,
+// and the clients' data waits in the kernel, which is the
+// backpressure a bounded pool is for.
+func (l *loop) handle(fd int, c *client, ev poller.Event) {
+	if l.jobs == nil {
+		l.serve(fd, c, ev)
+		return
+	}
+	l.jobs <- job{fd, c, ev}
+}
 
-			// Retrieve the connection for this fd.
-			value, ok := conns.Load(fd)
-			if !ok {
-				// Connection was removed.
-				continue
+// worker serves the events handle queues. It re-arms the fd after
+// serving it, with the interest serve left in c.events; a client it
+// closed stays closed, and an event queued for a client that was
+// closed meanwhile is dropped.
+func (l *loop) worker() {
+	for j := range l.jobs {
+		j.c.mu.Lock()
+		if !j.c.closed {
+			l.serve(j.fd, j.c, j.ev)
+		}
+		if !j.c.closed {
+			counters.ctls.Add(1)
+			if err := l.p.Mod(j.fd, j.c.events|poller.OneShot); err != nil {
+				log.Println("poller Mod error on fd", j.fd, err)
+				l.closeClient(j.fd, j.c)
 			}
-			conn := value.(net.Conn)
-
-			// Read available data from the connection.
-			nread, err := syscall.Read(fd, readBuf)
-			if err != nil {
-				// If no data is available, try again.
-				if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK {
-					continue
-				}
-				log.Println("Read error on fd", fd, err)
-				syscall.EpollCtl(epfd, syscall.EPOLL_CTL_DEL, fd, nil)
-				conn.Close()
-				conns.Delete(fd)
-				continue
+		}
+		j.c.mu.Unlock()
+	}
+}
+
+// open sets up a new connection and registers it with its callback.
+// Level-triggered fds start with read interest only and add write
+// interest while output is queued. Edge-triggered ones are registered
+// for both once: the kernel reports each direction when it becomes
+// ready, and the loop keeps track of what it is waiting for itself.
+func (l *loop) open(fd int) {
+	// The net package sets TCP_NODELAY on every TCP connection, and
+	// an echo server, which replies with small writes, wants it too.
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 1); err != nil {
+		log.Println("TCP_NODELAY error on fd", fd, err)
+	}
+	if *busyPoll > 0 {
+		if err := poller.SetBusyPoll(fd, *busyPoll); err != nil {
+			log.Println("SO_BUSY_POLL error on fd", fd, err)
+		}
+	}
+	c := &client{events: poller.Read}
+	if l.srv.enc != nil {
+		dec, _ := codec.New(*codecName, 0)
+		c.frames = codec.NewStream(dec)
+	}
+	if *edge {
+		c.events = poller.Read | poller.Write | poller.Edge
+	}
+	interest := c.events
+	if *workers > 0 {
+		interest |= poller.OneShot
+	}
+	if err := l.p.Add(fd, interest, func(fd int, ev poller.Event) { l.handle(fd, c, ev) }); err != nil {
+		log.Println("poller Add error:", err)
+		syscall.Close(fd)
+		if l.limit != nil {
+			l.limit.Release()
+		}
+		return
+	}
+	echoMetrics.Accepted.Inc()
+	echoMetrics.Conns.Add(1)
+	l.conns.Add(1)
+	l.clientsMu.Lock()
+	l.clients[fd] = c
+	l.clientsMu.Unlock()
+	if cfg.IdleTimeout > 0 {
+		c.idle = l.wheel.NewDeadline(func() { l.expire(fd, c, c.idle) })
+		c.idle.Set(cfg.IdleTimeout)
+	}
+	if cfg.WriteTimeout > 0 {
+		c.stalled = l.wheel.NewDeadline(func() { l.expire(fd, c, c.stalled) })
+	}
+}
+
+// accept takes up to acceptBatch connections off the listener. Each
+// comes non-blocking from a single accept4 on Linux and the BSDs, with
+// no net.Conn and no registration with the runtime's own poller.
+// Connections over the per-address limit are closed with SO_LINGER 0,
+// as ratelimit.Listener does: the reset tells the client at once, and
+// the server keeps no TIME_WAIT entry for them. At -max-conns, pause
+// leaves the rest of the queue to the kernel until closeClient frees
+// a slot, and reject accepts each connection and closes it after
+// busy, as connlimit.Listener does.
+func (l *loop) accept(lfd int, ev poller.Event) {
+	for range acceptBatch {
+		if l.limit != nil && cfg.OverLimit == connlimit.Pause && l.limit.Open() == l.limit.Max() {
+			counters.acceptPauses.Add(1)
+			l.pauseAccept()
+			// A client closed before the pause took effect found
+			// nothing to resume.
+			if l.limit.Open() < l.limit.Max() {
+				l.resumeAccept()
 			}
-			// A zero-byte read indicates that the client closed the connection.
-			if nread == 0 {
-				syscall.EpollCtl(epfd, syscall.EPOLL_CTL_DEL, fd, nil)
-				conn.Close()
-				conns.Delete(fd)
+			return
+		}
+		fd, sa, err := poller.Accept(lfd)
+		switch err {
+		case nil:
+		case syscall.EAGAIN:
+			return
+		case syscall.ECONNABORTED, syscall.EINTR:
+			continue // the peer gave up while queued; try the next one
+		case syscall.EMFILE, syscall.ENFILE:
+			// The connection stays queued, and the listener stays
+			// readable. A level-triggered Wait would return at once
+			// and fail again, so the loop stops watching the listener
+			// until closeClient frees an fd.
+			log.Println("Accept error:", err)
+			l.pauseAccept()
+			return
+		default:
+			log.Println("Accept error:", err)
+			return
+		}
+		if l.srv.perIP != nil {
+			if ap, ok := netaddr.FromSockaddr(sa); ok && !l.srv.perIP.Allow(ap.Addr().Unmap()) {
+				syscall.SetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER, &syscall.Linger{Onoff: 1, Linger: 0})
+				syscall.Close(fd)
+				counters.rejected.Add(1)
 				continue
 			}
+		}
+		if l.limit != nil && !l.limit.Acquire() {
+			if l.srv.busy != nil {
+				syscall.Write(fd, l.srv.busy)
+			} else {
+				syscall.SetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER, &syscall.Linger{Onoff: 1, Linger: 0})
+			}
+			syscall.Close(fd)
+			counters.overLimit.Add(1)
+			continue
+		}
+		l.open(fd)
+	}
+}
 
-			// Write the response back to the client.
-			// In production you may need to handle partial writes and buffer remaining data.
-			nwritten, err := syscall.Write(fd, readBuf)
-			if err != nil {
-				if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK {
-					continue
-				}
-				log.Println("Write error on fd", fd, err)
-				syscall.EpollCtl(epfd, syscall.EPOLL_CTL_DEL, fd, nil)
-				conn.Close()
-				conns.Delete(fd)
-				continue
+// each calls f for every open client, holding its lock with -workers.
+// A client closed since the snapshot was taken is skipped.
+func (l *loop) each(f func(fd int, c *client)) {
+	l.clientsMu.Lock()
+	open := make(map[int]*client, len(l.clients))
+	for fd, c := range l.clients {
+		open[fd] = c
+	}
+	l.clientsMu.Unlock()
+	for fd, c := range open {
+		if *workers > 0 {
+			c.mu.Lock()
+		}
+		if !c.closed {
+			f(fd, c)
+		}
+		if *workers > 0 {
+			c.mu.Unlock()
+		}
+	}
+}
+
+// drainClient stops reading from c, and finishes it once its queued
+// replies are written, like echo-net-trace.go answering what it has
+// read and hanging up. A client whose peer already sent its FIN is
+// on its way out and is left alone. With -workers, the fd is re-armed
+// for its new interest; a worker holding an event for it serves that
+// first, and an extra event finds nothing to do.
+func (l *loop) drainClient(fd int, c *client) {
+	if c.eof || c.shut {
+		return
+	}
+	c.draining = true
+	if len(c.out) == 0 {
+		l.finish(fd, c)
+	} else if err := l.watch(fd, c, poller.Write); err != nil {
+		log.Println("poller Mod error on fd", fd, err)
+		l.closeClient(fd, c)
+	}
+	if *workers > 0 && !c.closed {
+		counters.ctls.Add(1)
+		if err := l.p.Mod(fd, c.events|poller.OneShot); err != nil {
+			log.Println("poller Mod error on fd", fd, err)
+			l.closeClient(fd, c)
+		}
+	}
+}
+
+// run is the event loop: each Wait calls accept when connections are
+// queued and handle for every ready client, all on the loop's goroutine.
+// With a wheel, Wait returns by the next tick at the latest, and the loop
+// advances the wheel by the ticks that have passed, which runs expire for
+// the deadlines due. No timerfd is needed for this: the Wait timeout is
+// the timer, and it works with kqueue too.
+//
+// On SIGINT or SIGTERM the loop closes the listener, drains the clients,
+// and goes on serving them until they are gone or the drain deadline
+// passes. Then, or at once on a second signal or an error, it closes
+// every client left and the poller.
+func (l *loop) run() loopResult {
+	wait := time.Duration(-1)
+	spinner := &poller.Spinner{Spin: *spinFor}
+	var spun poller.Spinner
+	start, ticks := time.Now(), int64(0)
+	var deadline time.Time // set once draining
+	var draining int64     // clients open when draining began
+	for {
+		level := l.srv.stopLevel.Load()
+		if level == drainAll && deadline.IsZero() {
+			deadline = time.Now().Add(*drainTimeout)
+			l.closeListener()
+			draining = l.conns.Load()
+			if *drainTimeout > 0 {
+				log.Printf("%sShutting down: listener closed, draining %d connections for up to %v", l.name, draining, *drainTimeout)
+				l.each(l.drainClient)
 			}
-			if nwritten < len(response) {
-				// Partial write handling can be implemented as needed.
+		}
+		if level == closeAll || !deadline.IsZero() && (l.conns.Load() == 0 || !time.Now().Before(deadline)) {
+			break
+		}
+		if l.wheel != nil {
+			wait = max(time.Until(start.Add(time.Duration(ticks+1)*l.wheel.Tick())), 0)
+		}
+		if !deadline.IsZero() {
+			if left := max(time.Until(deadline), 0); wait < 0 || wait > left {
+				wait = left
 			}
 		}
+		n, err := spinner.Wait(l.p, wait)
+		if err != nil {
+			l.srv.fail("Wait error:", err)
+			continue
+		}
+		if spinner.Spin > 0 {
+			// The loops share the counters, so each adds what its own
+			// spinner counted since the last Wait.
+			counters.spinPolls.Add(spinner.Polls - spun.Polls)
+			counters.spinHits.Add(spinner.Hits - spun.Hits)
+			counters.spinBlocked.Add(spinner.Blocked - spun.Blocked)
+			spun = *spinner
+		}
+		counters.wakeups.Add(1)
+		counters.events.Add(uint64(n))
+		if n > 0 {
+			counters.perWakeup[min(bits.Len(uint(n))-1, wakeBuckets-1)].Add(1)
+		}
+		if l.wheel != nil {
+			if due := int64(time.Since(start)/l.wheel.Tick()) - ticks; due > 0 {
+				ticks += due
+				l.wheel.Advance(int(due))
+			}
+		}
+	}
+
+	l.closeListener()
+	left := l.conns.Load()
+	l.each(l.closeClient)
+	l.p.Del(l.wake[0])
+	if err := l.p.Close(); err != nil {
+		log.Println("poller Close error:", err)
+	}
+	return loopResult{drained: !deadline.IsZero(), draining: draining, left: left}
+}
+
+// listenBacklog asks for the longest accept queue there is. The kernel
+// caps it at net.core.somaxconn on Linux and kern.ipc.somaxconn on macOS
+// and the BSDs, so the queue is as long as the host allows, as with
+// net.Listen; syscall.SOMAXCONN is a stale 128.
+const listenBacklog = math.MaxInt32
+
+// listen opens a non-blocking listening socket on addr. Like
+// net.Listen("tcp", ":port"), an address without a host is an IPv6 socket
+// that takes IPv4 clients as IPv4-mapped addresses, or an IPv4 one on a
+// host without IPv6. reusePort sets SO_REUSEPORT, which lets the loops
+// each bind a socket of their own to the same address; it has to be set
+// before bind, on every one of them. The socket buffers from -so-rcvbuf
+// and -so-sndbuf go on before listen, so accepted sockets inherit them.
+func listen(addr string, reusePort bool) (int, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return -1, err
+	}
+	var fd int
+	var sa syscall.Sockaddr
+	switch ip := tcpAddr.IP; {
+	case ip == nil:
+		sa = &syscall.SockaddrInet6{Port: tcpAddr.Port}
+		fd, err = syscall.Socket(syscall.AF_INET6, syscall.SOCK_STREAM, 0)
+		if err == nil {
+			if err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 0); err != nil {
+				syscall.Close(fd)
+			}
+		}
+		if err != nil {
+			sa = &syscall.SockaddrInet4{Port: tcpAddr.Port}
+			fd, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+		}
+	case ip.To4() != nil:
+		sa4 := &syscall.SockaddrInet4{Port: tcpAddr.Port}
+		copy(sa4.Addr[:], ip.To4())
+		sa = sa4
+		fd, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	default:
+		sa6 := &syscall.SockaddrInet6{Port: tcpAddr.Port}
+		copy(sa6.Addr[:], ip.To16())
+		sa = sa6
+		fd, err = syscall.Socket(syscall.AF_INET6, syscall.SOCK_STREAM, 0)
+	}
+	if err != nil {
+		return -1, err
+	}
+	syscall.CloseOnExec(fd)
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if reusePort {
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+			syscall.Close(fd)
+			return -1, err
+		}
+	}
+	if err := cfg.SetBuffers(uintptr(fd)); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if err := syscall.Listen(fd, listenBacklog); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// wakePipe returns a non-blocking pipe, read end first.
+func wakePipe() ([2]int, error) {
+	var fds [2]int
+	syscall.ForkLock.RLock()
+	err := syscall.Pipe(fds[:])
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return fds, err
+	}
+	for _, fd := range fds {
+		if err := syscall.SetNonblock(fd, true); err != nil {
+			syscall.Close(fds[0])
+			syscall.Close(fds[1])
+			return fds, err
+		}
+	}
+	return fds, nil
+}
+
+// spin stands in for request handling: it hashes p for d.
+func spin(p []byte, d time.Duration) {
+	sum := sha256.Sum256(p)
+	for start := time.Now(); time.Since(start) < d; {
+		sum = sha256.Sum256(sum[:])
+	}
+}
+
+// printStats prints, every interval, the open connections, the rates of
+// accepts, Waits, events, reads, writes, epoll_ctl calls, bytes and
+// buffer allocations, and how many clients have been closed by each cause
+// so far; -max-conns, -codec and -spin each add a line of their own.
+// Edge-triggered mode trades wakeups for reads: each event is drained in
+// one go, and every drain ends with a read that returns EAGAIN.
+func printStats(interval time.Duration) {
+	last := loadStats()
+	for range time.Tick(interval) {
+		cur := loadStats()
+		rate := func(cur, last uint64) float64 { return float64(cur-last) / interval.Seconds() }
+		wakeups, events := rate(cur.Wakeups, last.Wakeups), rate(cur.Events, last.Events)
+		fmt.Printf("conns=%d accepts/s=%.0f wakeups/s=%.0f events/s=%.0f (%.1f per wakeup) reads/s=%.0f eagain/s=%.0f writes/s=%.0f full/s=%.0f epoll_ctl/s=%.0f MB/s in=%.1f out=%.1f buffer allocs/s=%.0f closed: fin=%d reset=%d idle=%d write-timeout=%d\n",
+			cur.Conns, rate(cur.Accepts, last.Accepts), wakeups, events, events/max(wakeups, 1),
+			rate(cur.Reads, last.Reads), rate(cur.ReadsEmpty, last.ReadsEmpty), rate(cur.Writes, last.Writes), rate(cur.WritesFull, last.WritesFull),
+			rate(cur.Ctls, last.Ctls), rate(cur.BytesIn, last.BytesIn)/1e6, rate(cur.BytesOut, last.BytesOut)/1e6,
+			rate(cur.BufAllocs, last.BufAllocs), cur.HalfCloses, cur.Resets, cur.Reaped, cur.WriteTimeouts)
+		if cfg.MaxConns > 0 {
+			fmt.Printf("  over -max-conns: rejected/s=%.0f accept pauses/s=%.0f\n",
+				rate(cur.OverLimit, last.OverLimit), rate(cur.AcceptPauses, last.AcceptPauses))
+		}
+		if *codecName != "" {
+			fmt.Printf("  frames/s=%.0f split reads/s=%.0f closed: protocol=%d\n",
+				rate(cur.Frames, last.Frames), rate(cur.Splits, last.Splits), cur.ProtoErrors)
+		}
+		if *spinFor > 0 {
+			hits, blocked := rate(cur.SpinHits, last.SpinHits), rate(cur.SpinBlocked, last.SpinBlocked)
+			fmt.Printf("  spin polls/s=%.0f hits/s=%.0f blocked/s=%.0f (%.1f%% of Waits found events spinning)\n",
+				rate(cur.SpinPolls, last.SpinPolls), hits, blocked, 100*hits/max(hits+blocked, 1))
+		}
+		last = cur
 	}
-}
\ No newline at end of file
+}
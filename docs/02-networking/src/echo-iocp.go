@@ -0,0 +1,578 @@
+//go:build windows
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/debugsrv"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/timingwheel"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	every   = flag.Duration("stats", 0, "Print wakeups, completions and bytes per second at this interval (0 disables)")
+	accepts = flag.Int("accepts", 16, "AcceptEx calls kept outstanding, the Windows counterpart of the accept queue the loop drains")
+
+	// As in echo-epoll.go, nothing here blocks on a client, so without it
+	// a client that vanished without a FIN keeps its socket forever.
+	idle = flag.Duration("idle", 5*time.Minute, "Close connections with no completions for this long (0 disables)")
+
+	// Ctrl-C, or closing the console, closes the listener and gives each
+	// client until -drain-timeout to receive its replies and close its
+	// end. A second one closes the rest at once.
+	drainTimeout = flag.Duration("drain-timeout", 5*time.Second, "On Ctrl-C, how long clients get to receive their replies before they are closed (0 closes them at once)")
+
+	debugAddr = flag.String("debug-addr", "", debugsrv.Usage)
+)
+
+// readBufSize is the size of a client's buffer, and the most one receive
+// takes.
+const readBufSize = 4096
+
+// maxEntries is the most completions one GetQueuedCompletionStatusEx
+// returns, as poller.MaxEvents is for a Wait.
+const maxEntries = 256
+
+// counters are kept by the event loop and read by the stats printer.
+var counters struct {
+	conns                      atomic.Int64
+	accepts                    atomic.Uint64
+	wakeups, completions       atomic.Uint64
+	recvs, sends               atomic.Uint64
+	bytesIn, bytesOut          atomic.Uint64
+	halfCloses, resets, reaped atomic.Uint64
+}
+
+// The kinds of operation an op is.
+const (
+	opAccept = iota
+	opRecv
+	opSend
+)
+
+// Completion keys. Every socket is associated with keySocket; keyWake is
+// posted by the signal handler, with no OVERLAPPED, to wake the loop.
+const (
+	keySocket = iota
+	keyWake
+)
+
+// acceptAddrLen is the room AcceptEx needs for each of the two addresses
+// it writes: the largest sockaddr plus 16 bytes.
+const acceptAddrLen = int(unsafe.Sizeof(windows.RawSockaddrInet6{})) + 16
+
+// op is one overlapped operation. The OVERLAPPED comes first, so the
+// pointer a completion returns is the op. An op belongs to the kernel
+// from the call that starts it to its completion, and must stay reachable
+// until then: the GC does not move heap objects, but it would free one
+// that nothing in Go points to.
+type op struct {
+	ov   windows.Overlapped
+	kind int
+	c    *client        // opRecv and opSend
+	sock windows.Handle // opAccept: the socket the connection is accepted into
+	addr [2 * acceptAddrLen]byte
+}
+
+// client is the per-socket state. Like the io_uring server, each client
+// has exactly one operation in the kernel at a time, a receive or the
+// send of what it returned, so the echo keeps its order and a client that
+// does not read its replies stops being read from.
+type client struct {
+	op       op // reused for every receive and send
+	sock     windows.Handle
+	buf      []byte // the kernel's while op is in flight
+	n        int    // bytes received into buf
+	sent     int    // bytes of them sent back
+	busy     bool   // op is in flight
+	closed   bool   // the socket is closed; forgotten once op completes
+	draining bool   // the server is shutting down: send what was received, then shut down writing
+	shut     bool   // writing is shut down: discard input until the peer's FIN, then close
+	last     int64  // wheel tick of the last completion
+	timer    *timingwheel.Timer
+}
+
+// Stop levels, raised by signals and by errors the loop cannot recover
+// from.
+const (
+	running  = iota
+	drainAll // stop accepting and drain the clients
+	closeAll // close every client now
+)
+
+// overlappedEntry is OVERLAPPED_ENTRY, one completion.
+type overlappedEntry struct {
+	key      uintptr
+	ov       *windows.Overlapped
+	internal uintptr // the operation's NTSTATUS
+	bytes    uint32
+}
+
+// x/sys/windows has GetQueuedCompletionStatus, which returns one
+// completion per call. The Ex variant returns up to a batch, as
+// epoll_wait does.
+var procGetQueuedCompletionStatusEx = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetQueuedCompletionStatusEx")
+
+// getCompletions waits up to timeout (negative waits forever) for
+// completions on port and returns how many it stored in entries.
+func getCompletions(port windows.Handle, entries []overlappedEntry, timeout time.Duration) (int, error) {
+	ms := uint32(windows.INFINITE)
+	if timeout >= 0 {
+		ms = uint32(min(timeout.Milliseconds(), windows.INFINITE-1))
+	}
+	var n uint32
+	r, _, err := procGetQueuedCompletionStatusEx.Call(uintptr(port),
+		uintptr(unsafe.Pointer(&entries[0])), uintptr(len(entries)),
+		uintptr(unsafe.Pointer(&n)), uintptr(ms), 0)
+	if r == 0 {
+		if err == windows.WAIT_TIMEOUT {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return int(n), nil
+}
+
+func main() {
+	flag.Parse()
+	if *debugAddr != "" {
+		if err := debugsrv.Listen(*debugAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *accepts <= 0 {
+		log.Fatal("-accepts must be positive")
+	}
+
+	// net would start Winsock when it is first used; nothing here uses it.
+	var wsa windows.WSAData
+	if err := windows.WSAStartup(uint32(0x0202), &wsa); err != nil {
+		log.Fatal("WSAStartup error:", err)
+	}
+
+	// Create the completion port. One thread runs the loop, so one may
+	// run at a time.
+	port, err := windows.CreateIoCompletionPort(windows.InvalidHandle, 0, 0, 1)
+	if err != nil {
+		log.Fatal("CreateIoCompletionPort error:", err)
+	}
+
+	// Start listening on port 9000. A socket the Go runtime created would
+	// already belong to its own completion port, and a handle can belong
+	// to one only, so the listener is a raw socket like the clients.
+	family, ls, err := listen(9000)
+	if err != nil {
+		log.Fatal("Listen error:", err)
+	}
+	if _, err := windows.CreateIoCompletionPort(ls, port, keySocket, 0); err != nil {
+		log.Fatal("CreateIoCompletionPort error:", err)
+	}
+	log.Println("Listening on :9000 (IOCP)")
+
+	if *every > 0 {
+		go printStats(*every)
+	}
+
+	// A second signal raises the stop level again; the wake completion
+	// makes the loop look at it.
+	var stopLevel, exitCode atomic.Int32
+	stop := func(level int32) {
+		for {
+			cur := stopLevel.Load()
+			if cur >= level || stopLevel.CompareAndSwap(cur, level) {
+				break
+			}
+		}
+		windows.PostQueuedCompletionStatus(port, 0, keyWake, nil)
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for level := int32(drainAll); ; level = closeAll {
+			log.Println("Got", <-sigs)
+			stop(level)
+		}
+	}()
+	// fail is log.Fatal for errors after clients have connected: the loop
+	// closes them on its way out, rather than leaving each one to the exit.
+	fail := func(v ...any) {
+		log.Println(v...)
+		exitCode.Store(1)
+		stop(closeAll)
+	}
+
+	// clients holds every client with a socket or an operation, which
+	// keeps the ops the kernel owns reachable. pending holds the accepts
+	// in flight for the same reason.
+	clients := map[*client]struct{}{}
+	pending := map[*op]struct{}{}
+
+	var reap func(c *client)
+	var wheel *timingwheel.Wheel
+	if *idle > 0 {
+		wheel = timingwheel.New(max(*idle/16, 10*time.Millisecond), 32)
+	}
+
+	// closeClient closes the socket, which cancels an operation in flight.
+	// The client stays known until that operation's completion arrives,
+	// because until then the kernel may still write into it.
+	closeClient := func(c *client) {
+		if c.closed {
+			return
+		}
+		c.closed = true
+		windows.Closesocket(c.sock)
+		counters.conns.Add(-1)
+		if c.timer != nil {
+			c.timer.Stop()
+		}
+		if !c.busy {
+			delete(clients, c)
+		}
+	}
+
+	// start hands the client's op to the kernel. A call that fails at
+	// once queues no completion, so the client is closed here.
+	start := func(c *client, kind int) {
+		c.op.ov = windows.Overlapped{}
+		c.op.kind = kind
+		buf := windows.WSABuf{Len: uint32(len(c.buf)), Buf: &c.buf[0]}
+		var err error
+		if kind == opRecv {
+			counters.recvs.Add(1)
+			var flags uint32
+			err = windows.WSARecv(c.sock, &buf, 1, nil, &flags, &c.op.ov, nil)
+		} else {
+			counters.sends.Add(1)
+			buf = windows.WSABuf{Len: uint32(c.n - c.sent), Buf: &c.buf[c.sent]}
+			err = windows.WSASend(c.sock, &buf, 1, nil, 0, &c.op.ov, nil)
+		}
+		if err != nil && err != windows.ERROR_IO_PENDING {
+			if err != windows.WSAECONNRESET && err != windows.WSAECONNABORTED {
+				log.Println("WSARecv/WSASend error:", err)
+			}
+			counters.resets.Add(1)
+			closeClient(c)
+			return
+		}
+		c.busy = true
+	}
+
+	// finish ends a client whose replies have all been sent by shutting
+	// down the server's side, which sends a FIN after them, and discarding
+	// input until the peer answers with its own. Input left unread would
+	// turn the close into a reset, and a reset throws away replies the
+	// peer has not received yet.
+	finish := func(c *client) {
+		if err := windows.Shutdown(c.sock, windows.SHUT_WR); err != nil {
+			closeClient(c)
+			return
+		}
+		c.shut = true
+		start(c, opRecv)
+	}
+
+	// drainClient finishes a client waiting for its next request. One
+	// in the middle of a send finishes when the send completes. Its
+	// receive in flight is left in place: whatever it returns is read
+	// after the shutdown, and discarded.
+	drainClient := func(c *client) {
+		if c.closed || c.shut {
+			return
+		}
+		c.draining = true
+		if c.op.kind == opRecv {
+			if err := windows.Shutdown(c.sock, windows.SHUT_WR); err != nil {
+				closeClient(c)
+				return
+			}
+			c.shut = true
+		}
+	}
+
+	// accept starts an AcceptEx into a new socket of the listener's
+	// family. It asks for no data with the connection, so it completes as
+	// soon as the handshake does.
+	listening := true
+	accept := func(o *op) {
+		s, err := windows.WSASocket(int32(family), windows.SOCK_STREAM, windows.IPPROTO_TCP, nil, 0, windows.WSA_FLAG_OVERLAPPED|windows.WSA_FLAG_NO_HANDLE_INHERIT)
+		if err != nil {
+			fail("WSASocket error:", err)
+			return
+		}
+		*o = op{kind: opAccept, sock: s}
+		var n uint32
+		err = windows.AcceptEx(ls, s, &o.addr[0], 0, uint32(acceptAddrLen), uint32(acceptAddrLen), &n, &o.ov)
+		if err != nil && err != windows.ERROR_IO_PENDING {
+			windows.Closesocket(s)
+			fail("AcceptEx error:", err)
+			return
+		}
+		pending[o] = struct{}{}
+	}
+	closeListener := func() {
+		if listening {
+			listening = false
+			windows.Closesocket(ls) // cancels the accepts in flight
+		}
+	}
+
+	// accepted sets up the connection an accept completed with, and
+	// starts reading from it. The socket inherits the listener's options
+	// only once SO_UPDATE_ACCEPT_CONTEXT says which listener that was.
+	accepted := func(s windows.Handle) {
+		err := windows.Setsockopt(s, windows.SOL_SOCKET, windows.SO_UPDATE_ACCEPT_CONTEXT, (*byte)(unsafe.Pointer(&ls)), int32(unsafe.Sizeof(ls)))
+		if err == nil {
+			_, err = windows.CreateIoCompletionPort(s, port, keySocket, 0)
+		}
+		if err != nil {
+			log.Println("Accept setup error:", err)
+			windows.Closesocket(s)
+			return
+		}
+		counters.accepts.Add(1)
+		counters.conns.Add(1)
+		c := &client{sock: s, buf: make([]byte, readBufSize)}
+		c.op.c = c
+		clients[c] = struct{}{}
+		if wheel != nil {
+			c.last = wheel.Now()
+			c.timer = wheel.AfterFunc(*idle, func() { reap(c) })
+		}
+		start(c, opRecv)
+	}
+
+	reap = func(c *client) {
+		if c.closed {
+			return
+		}
+		if quiet := time.Duration(wheel.Now()-c.last) * wheel.Tick(); quiet < *idle {
+			c.timer.Reset(*idle - quiet)
+			return
+		}
+		counters.reaped.Add(1)
+		closeClient(c)
+	}
+
+	// complete handles one completion. status is the operation's NTSTATUS:
+	// a reset, or the cancellation of an operation whose socket was
+	// closed, arrives as a failed completion rather than a failed call.
+	complete := func(o *op, status windows.NTStatus, n int) {
+		if o.kind == opAccept {
+			delete(pending, o)
+			if status != 0 || !listening {
+				windows.Closesocket(o.sock)
+				if listening {
+					log.Println("AcceptEx error:", status.Errno())
+					accept(o)
+				}
+				return
+			}
+			accepted(o.sock)
+			accept(o)
+			return
+		}
+
+		c := o.c
+		c.busy = false
+		if c.closed {
+			delete(clients, c)
+			return
+		}
+		if wheel != nil {
+			c.last = wheel.Now()
+		}
+		if status != 0 {
+			counters.resets.Add(1)
+			closeClient(c)
+			return
+		}
+		switch o.kind {
+		case opRecv:
+			counters.bytesIn.Add(uint64(n))
+			// A zero-byte receive indicates that the client closed the
+			// connection.
+			if n == 0 {
+				counters.halfCloses.Add(1)
+				closeClient(c)
+				return
+			}
+			if c.shut {
+				start(c, opRecv) // discard
+				return
+			}
+			c.n, c.sent = n, 0
+			start(c, opSend)
+		case opSend:
+			counters.bytesOut.Add(uint64(n))
+			c.sent += n
+			if c.sent < c.n {
+				start(c, opSend) // a short send: the rest, before reading more
+				return
+			}
+			if c.draining {
+				finish(c)
+				return
+			}
+			start(c, opRecv)
+		}
+	}
+
+	for range *accepts {
+		accept(new(op))
+	}
+
+	// Event loop: each GetQueuedCompletionStatusEx returns the completions
+	// that are ready, and handling them starts the next operations; the
+	// calls that start them return at once. With a wheel, the wait ends by
+	// the next tick at the latest and the loop advances the wheel, which
+	// runs reap for the timers due, as in echo-epoll.go.
+	//
+	// On Ctrl-C the loop closes the listener, drains the clients, and goes
+	// on serving them until they are gone or the drain deadline passes.
+	// Then, or at once on a second Ctrl-C or an error, it closes every
+	// client left, waits for the cancelled operations to come back, and
+	// closes the port.
+	entries := make([]overlappedEntry, maxEntries)
+	wait := time.Duration(-1)
+	begin, ticks := time.Now(), int64(0)
+	var deadline time.Time // set once draining
+	var draining int64     // clients open when draining began
+	for {
+		level := stopLevel.Load()
+		if level == drainAll && deadline.IsZero() {
+			deadline = time.Now().Add(*drainTimeout)
+			closeListener()
+			draining = counters.conns.Load()
+			if *drainTimeout > 0 {
+				log.Printf("Shutting down: listener closed, draining %d connections for up to %v", draining, *drainTimeout)
+				for c := range clients {
+					drainClient(c)
+				}
+			}
+		}
+		if level == closeAll || !deadline.IsZero() && (counters.conns.Load() == 0 || !time.Now().Before(deadline)) {
+			break
+		}
+		if wheel != nil {
+			wait = max(time.Until(begin.Add(time.Duration(ticks+1)*wheel.Tick())), 0)
+		}
+		if !deadline.IsZero() {
+			if left := max(time.Until(deadline), 0); wait < 0 || wait > left {
+				wait = left
+			}
+		}
+		n, err := getCompletions(port, entries, wait)
+		if err != nil {
+			fail("GetQueuedCompletionStatusEx error:", err)
+			continue
+		}
+		counters.wakeups.Add(1)
+		counters.completions.Add(uint64(n))
+		for _, e := range entries[:n] {
+			if e.key == keyWake {
+				continue
+			}
+			complete((*op)(unsafe.Pointer(e.ov)), windows.NTStatus(e.internal), int(e.bytes))
+		}
+		if wheel != nil {
+			if due := int64(time.Since(begin)/wheel.Tick()) - ticks; due > 0 {
+				ticks += due
+				wheel.Advance(int(due))
+			}
+		}
+	}
+
+	// Closing a socket cancels its operation, but the kernel owns the op
+	// until the cancellation completes. Wait for every one, briefly, before
+	// the port goes away.
+	closeListener()
+	left := counters.conns.Load()
+	for c := range clients {
+		closeClient(c)
+	}
+	for settle := time.Now().Add(time.Second); len(clients)+len(pending) > 0 && time.Now().Before(settle); {
+		n, err := getCompletions(port, entries, time.Until(settle))
+		if err != nil {
+			log.Println("GetQueuedCompletionStatusEx error:", err)
+			break
+		}
+		for _, e := range entries[:n] {
+			if e.key != keyWake {
+				complete((*op)(unsafe.Pointer(e.ov)), windows.NTStatus(e.internal), int(e.bytes))
+			}
+		}
+	}
+	signal.Stop(sigs)
+	windows.CloseHandle(port)
+	if deadline.IsZero() {
+		log.Printf("Shut down: closed %d connections", left)
+	} else {
+		log.Printf("Shut down: %d of %d connections finished draining, %d closed", draining-left, draining, left)
+	}
+	os.Exit(int(exitCode.Load()))
+}
+
+// listen opens an overlapped listening socket on port, on every address.
+// Like net.Listen("tcp", ":port"), it is an IPv6 socket that takes IPv4
+// clients as IPv4-mapped addresses, or an IPv4 one on a host without
+// IPv6. It returns the family, which AcceptEx needs its sockets to match.
+func listen(port int) (int, windows.Handle, error) {
+	const flags = windows.WSA_FLAG_OVERLAPPED | windows.WSA_FLAG_NO_HANDLE_INHERIT
+	family := windows.AF_INET6
+	var sa windows.Sockaddr = &windows.SockaddrInet6{Port: port}
+	s, err := windows.WSASocket(windows.AF_INET6, windows.SOCK_STREAM, windows.IPPROTO_TCP, nil, 0, flags)
+	if err == nil {
+		if err = windows.SetsockoptInt(s, windows.IPPROTO_IPV6, windows.IPV6_V6ONLY, 0); err != nil {
+			windows.Closesocket(s)
+		}
+	}
+	if err != nil {
+		family, sa = windows.AF_INET, &windows.SockaddrInet4{Port: port}
+		if s, err = windows.WSASocket(windows.AF_INET, windows.SOCK_STREAM, windows.IPPROTO_TCP, nil, 0, flags); err != nil {
+			return 0, windows.InvalidHandle, err
+		}
+	}
+	if err := windows.Bind(s, sa); err != nil {
+		windows.Closesocket(s)
+		return 0, windows.InvalidHandle, err
+	}
+	if err := windows.Listen(s, windows.SOMAXCONN); err != nil {
+		windows.Closesocket(s)
+		return 0, windows.InvalidHandle, err
+	}
+	return family, s, nil
+}
+
+// printStats prints, every interval, the open connections and, per
+// second, the accepts, the GetQueuedCompletionStatusEx wakeups and the
+// completions they dequeued, the receives and sends posted, the bytes
+// each way, and the clients closed by FIN, reset and -idle. Each receive
+// and send is a call of its own, as with epoll, but no call waits for
+// readiness first: the wakeup returns the results.
+func printStats(interval time.Duration) {
+	var last [10]uint64
+	for range time.Tick(interval) {
+		cur := [10]uint64{
+			counters.wakeups.Load(), counters.completions.Load(), counters.recvs.Load(), counters.sends.Load(),
+			counters.bytesIn.Load(), counters.bytesOut.Load(), counters.accepts.Load(),
+			counters.halfCloses.Load(), counters.resets.Load(), counters.reaped.Load(),
+		}
+		var d [10]float64
+		for i := range cur {
+			d[i] = float64(cur[i]-last[i]) / interval.Seconds()
+		}
+		last = cur
+		fmt.Printf("conns=%d accepts/s=%.0f wakeups/s=%.0f completions/s=%.0f (%.1f per wakeup) recvs/s=%.0f sends/s=%.0f MB/s in=%.1f out=%.1f closed/s fin=%.0f reset=%.0f idle=%.0f\n",
+			counters.conns.Load(), d[6], d[0], d[1], d[1]/max(d[0], 1), d[2], d[3], d[4]/1e6, d[5]/1e6, d[7], d[8], d[9])
+	}
+}
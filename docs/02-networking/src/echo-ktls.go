@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/debugsrv"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/internal/testcert"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/ktls"
+)
+
+var (
+	addr      = flag.String("addr", ":9443", "Listen address")
+	kernel    = flag.Bool("ktls", true, "Hand the record layer to the kernel after the handshake; false keeps crypto/tls")
+	certFile  = flag.String("cert", "", "Certificate file (self-signed if empty)")
+	keyFile   = flag.String("key", "", "Key file")
+	debugAddr = flag.String("debug-addr", "", debugsrv.Usage)
+)
+
+// fallback is logged once, the first time the kernel turns kTLS down.
+var fallback sync.Once
+
+// handshake returns conn as a TLS connection: kernel TLS if asked for and
+// available, crypto/tls otherwise.
+func handshake(conn *net.TCPConn, cfg *tls.Config) (net.Conn, error) {
+	if *kernel {
+		kc, err := ktls.Server(conn, cfg)
+		switch {
+		case err == nil:
+			return kc, nil
+		case !errors.Is(err, errors.ErrUnsupported):
+			return nil, err
+		}
+		fallback.Do(func() { log.Printf("%v; using crypto/tls", err) })
+	}
+	tc := tls.Server(conn, cfg)
+	return tc, tc.Handshake()
+}
+
+// handle echoes whatever arrives until the client closes.
+func handle(conn *net.TCPConn, cfg *tls.Config) {
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	c, err := handshake(conn, cfg)
+	if err != nil {
+		log.Printf("Handshake failed (%s): %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	defer c.Close()
+	c.SetDeadline(time.Time{})
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := c.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := c.Write(buf[:n]); err != nil {
+			log.Printf("Write failed (%s): %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *debugAddr != "" {
+		if err := debugsrv.Listen(*debugAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	cert, err := loadCert()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	log.Printf("TLS echo server listening on %s, kernel TLS: %v", *addr, *kernel)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Printf("Accept error: %v", err)
+			continue
+		}
+		go handle(conn.(*net.TCPConn), cfg)
+	}
+}
+
+func loadCert() (tls.Certificate, error) {
+	if *certFile != "" {
+		return tls.LoadX509KeyPair(*certFile, *keyFile)
+	}
+	cert, _, err := testcert.New()
+	return cert, err
+}
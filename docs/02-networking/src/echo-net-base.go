@@ -5,14 +5,19 @@ import (
     "encoding/hex"
 
     "bufio"
+    "flag"
     "log"
     "net"
     "os"
     "runtime/trace"
     "sync/atomic"
     "time"
+
+    "github.com/astavonin/go-optimization-guide/docs/02-networking/src/debugsrv"
 )
 
+var debugAddr = flag.String("debug-addr", "", debugsrv.Usage)
+
 func hash(s string) string {
     h := sha256.Sum256([]byte(s))
     return hex.EncodeToString(h[:])
@@ -55,6 +60,13 @@ func handle(conn net.Conn) {
 }
 
 func main() {
+    flag.Parse()
+    if *debugAddr != "" {
+        if err := debugsrv.Listen(*debugAddr); err != nil {
+            log.Fatal(err)
+        }
+    }
+
     // Setup trace output
     traceFile, err := os.Create("trace.out")
     if err != nil {
@@ -0,0 +1,106 @@
+// echo-net-pool.go is echo-net.go with a fixed pool of workers in place of
+// a goroutine per connection. The accept loop sends each connection down a
+// channel, and -workers goroutines take them off it and serve them one at
+// a time, to the end. At most -workers connections are served at once, so
+// the memory held by handlers stays bounded however many clients connect;
+// the rest wait in the channel and then in the listen backlog, and get
+// nothing until a worker is free. That suits short sessions. With
+// long-lived connections, the pool caps the connections served, not the
+// work: -workers idle clients shut everyone else out until they time out.
+//
+//	go run echo-net-pool.go -workers 1000
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/connlimit"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/srvconfig"
+)
+
+// cfg holds the same settings as echo-net.go. The idle timeout defaults to
+// ten seconds rather than five minutes: an idle client holds a worker for
+// as long as it.
+var cfg = srvconfig.Register(flag.CommandLine, srvconfig.Config{
+	Addr:        ":9000",
+	ReadBuffer:  4096,
+	IdleTimeout: 10 * time.Second,
+}, srvconfig.Addr|srvconfig.ReadBuffer|srvconfig.SocketBuffers|srvconfig.IdleTimeout|srvconfig.WriteTimeout|srvconfig.MaxConns)
+
+var (
+	workers = flag.Int("workers", 1000, "Goroutines serving connections, one connection each at a time")
+	queue   = flag.Int("queue", 1000, "Accepted connections waiting for a worker; past it, accepting stops")
+)
+
+func main() {
+	flag.Parse()
+	if err := cfg.Apply(flag.CommandLine); err != nil {
+		panic(err) // Exit on a bad flag or environment variable
+	}
+
+	listener, err := cfg.Listen("tcp")
+	if err != nil {
+		panic(err) // Exit if the port can't be bound
+	}
+	fmt.Println("Echo server listening on", cfg.Addr, "with", *workers, "workers")
+	listener = cfg.Limit(listener, connlimit.BusyReply(codec.NewLine(0)))
+
+	// Start the workers, then feed them. The send blocks while every
+	// worker is busy and the queue is full, and the accept loop stops
+	// with it: new clients wait in the kernel.
+	conns := make(chan net.Conn, *queue)
+	for range max(*workers, 1) {
+		go worker(conns)
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Printf("Accept error: %v\n", err)
+			continue
+		}
+		conns <- conn
+	}
+}
+
+// worker serves connections from conns one after another until the
+// channel is closed.
+func worker(conns <-chan net.Conn) {
+	for conn := range conns {
+		handle(conn)
+	}
+}
+
+// handle echoes data back to the client line-by-line, as in echo-net.go.
+// A client that hangs up is the normal end of a session and is not logged:
+// at tens of thousands of sessions a line per close would be most of the
+// server's output.
+func handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReaderSize(conn, cfg.ReadBuffer)
+	for {
+		if cfg.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(cfg.IdleTimeout))
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				fmt.Printf("Connection closed: %v\n", err)
+			}
+			return
+		}
+		if cfg.WriteTimeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout))
+		}
+		if _, err := conn.Write([]byte(line)); err != nil {
+			fmt.Printf("Write error: %v\n", err)
+			return
+		}
+	}
+}
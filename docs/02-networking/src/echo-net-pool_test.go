@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"runtime/metrics"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// BenchmarkSessions compares echo-net.go's go handle(conn) with this
+// server's pool of workers when n clients are connected at once, each
+// waiting to echo ten 64-byte lines before it hangs up. ns/conn is the
+// time per session to serve the whole wave; p99-ms is how long the slowest
+// sessions took from the start of the wave. goroutines and stack-MB are
+// peaks over the run, and the n clients account for n goroutines and
+// about 4 KiB of stack each in both modes. The connections are
+// net.Pipe: 100k over loopback need 200k file descriptors, and this
+// measures the serving model, not TCP.
+//
+//	go test -bench Sessions echo-net-pool.go echo-net-pool_test.go
+func BenchmarkSessions(b *testing.B) {
+	// A wave of 100k takes longer on one CPU than this server's idle
+	// timeout; echo-net.go's keeps every handler waiting for its client.
+	cfg.IdleTimeout = 5 * time.Minute
+	for _, n := range []int{10_000, 50_000, 100_000} {
+		for _, mode := range []string{"per-conn", "pool"} {
+			b.Run(fmt.Sprintf("conns=%d/%s", n, mode), func(b *testing.B) {
+				serve := func(c net.Conn) { go handle(c) }
+				if mode == "pool" {
+					// A queue for the whole wave stands in for the
+					// listen backlog, so every connection is accepted
+					// before any client sends.
+					conns := make(chan net.Conn, n)
+					defer close(conns)
+					for range *workers {
+						go worker(conns)
+					}
+					serve = func(c net.Conn) { conns <- c }
+				}
+				runtime.GC()
+				var peak peakSampler
+				peak.start()
+				lat := make([]time.Duration, n)
+				servers := make([]net.Conn, n)
+				for b.Loop() {
+					b.StopTimer()
+					var wg sync.WaitGroup
+					var start time.Time
+					ready := make(chan struct{})
+					for i := range servers {
+						client, server := net.Pipe()
+						servers[i] = server
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							<-ready
+							if err := session(client); err != nil {
+								b.Error(err)
+							}
+							lat[i] = time.Since(start)
+						}()
+					}
+					b.StartTimer()
+					start = time.Now()
+					for _, c := range servers {
+						serve(c)
+					}
+					close(ready)
+					wg.Wait()
+				}
+				goroutines, stacks := peak.stop()
+				slices.Sort(lat)
+				b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/conn")
+				b.ReportMetric(float64(lat[n*99/100].Microseconds())/1000, "p99-ms")
+				b.ReportMetric(float64(goroutines), "goroutines")
+				b.ReportMetric(float64(stacks)/(1<<20), "stack-MB")
+				b.ReportMetric(0, "ns/op")
+			})
+		}
+	}
+}
+
+// session echoes ten lines over c and closes it.
+func session(c net.Conn) error {
+	defer c.Close()
+	msg := make([]byte, 64)
+	msg[len(msg)-1] = '\n'
+	for range 10 {
+		if _, err := c.Write(msg); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(c, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// peakSampler records the highest goroutine count and stack bytes seen,
+// sampling every millisecond.
+type peakSampler struct {
+	done               chan struct{}
+	wg                 sync.WaitGroup
+	goroutines, stacks uint64
+}
+
+func (p *peakSampler) start() {
+	p.done = make(chan struct{})
+	s := []metrics.Sample{
+		{Name: "/sched/goroutines:goroutines"},
+		{Name: "/memory/classes/heap/stacks:bytes"},
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		t := time.NewTicker(time.Millisecond)
+		defer t.Stop()
+		for {
+			metrics.Read(s)
+			p.goroutines = max(p.goroutines, s[0].Value.Uint64())
+			p.stacks = max(p.stacks, s[1].Value.Uint64())
+			select {
+			case <-p.done:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+func (p *peakSampler) stop() (goroutines, stacks uint64) {
+	close(p.done)
+	p.wg.Wait()
+	return p.goroutines, p.stacks
+}
@@ -4,90 +4,373 @@ import (
     "crypto/sha256"
     "encoding/hex"
 
-	"bufio"
+	"context"
+	"flag"
 	"log"
 	"net"
-	"os"
+	"net/http"
+	_ "net/http/pprof"
+	"net/netip"
 	"runtime/trace"
-	"sync/atomic"
 	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/admin"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/bloom"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/bufpool"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/chaos"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/connlimit"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/drain"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/flush"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/metrics"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/ratelimit"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/readguard"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/soak"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/srvconfig"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/telemetry"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/tracefile"
+)
+
+// hash is the handler's CPU work per message. It hashes the payload where
+// it lies in the read buffer and formats the digest into an array, so
+// unlike a string conversion and hex.EncodeToString it allocates nothing.
+func hash(p []byte) [2 * sha256.Size]byte {
+    h := sha256.Sum256(p)
+    var out [2 * sha256.Size]byte
+    hex.Encode(out[:], h[:])
+    return out
+}
+
+// echo holds the connection, request, byte and flush metrics the echo
+// servers share, served at /metrics with -debug-addr. The logs below read
+// the open connections from it.
+var echo = metrics.NewEcho(metrics.Default)
+
+// Slow-client protection. Idle connections may wait 5 minutes (-idle) for
+// the next line, but once a line has started it must arrive within 10
+// seconds, at 64 B/s or faster, with no gap longer than 5 seconds between
+// bytes.
+var slowPolicy = readguard.Policy{
+	IdleTimeout:     5 * time.Minute,
+	ProgressTimeout: 5 * time.Second,
+	MessageTimeout:  10 * time.Second,
+	MinRate:         64,
+	Grace:           2 * time.Second,
+}
+
+var reaper = readguard.NewReaper(&slowPolicy)
+
+// maxLineLength caps how much a client can make us buffer for one message.
+const maxLineLength = 4096
+
+// cfg is the listen address, read buffer, idle timeout and connection
+// limit, shared with the other servers (see the srvconfig package).
+// -read-buffer sizes the codec's reads; a message longer than one read
+// still fits, up to maxLineLength. With -max-conns and -over-limit reject,
+// a connection over the limit gets the codec's Busy message.
+var cfg = srvconfig.Register(flag.CommandLine, srvconfig.Config{
+	Addr:        ":9000",
+	ReadBuffer:  maxLineLength,
+	IdleTimeout: slowPolicy.IdleTimeout,
+}, srvconfig.Addr|srvconfig.ReadBuffer|srvconfig.SocketBuffers|srvconfig.IdleTimeout|srvconfig.MaxConns)
+
+var codecName = flag.String("codec", "line", "Message framing: line, length, length-crc, chunked or jsonl")
+
+// connBufs holds the read and write buffers of closed connections for the
+// next ones to take, so that clients connecting for a few messages do not
+// leave two buffers each to the GC (see BenchmarkConnChurn in codec). It
+// is sized in main from -read-buffer, up to the buffer a maximum-length
+// message may grow it to.
+var connBufs *bufpool.Pool
+
+// pprofAddr serves net/http/pprof. Handler goroutines carry "conn" and
+// "size" labels, so profiles taken here can be split with labelprof.
+var pprofAddr = flag.String("pprof", "localhost:6061", "Address for net/http/pprof (empty disables)")
+
+// -trace writes an execution trace. Each connection is a "conn" task, and
+// its reads, hashes, writes into the reply buffer and flushes are regions
+// of it, so go tool trace's task and region views split a connection's
+// time between waiting for the client, the handler's work and the
+// syscalls that send the replies. -trace-max-size and -trace-max-age
+// rotate the trace into numbered files, keeping the newest -trace-keep
+// (see the tracefile package).
+var (
+	traceOut     = flag.String("trace", "trace.out", "Write an execution trace to this file (empty disables)")
+	traceMaxSize srvconfig.Size
+	traceMaxAge  = flag.Duration("trace-max-age", 0, "Start a new trace file once the current one is this old (0 disables)")
+	traceKeep    = flag.Int("trace-keep", 8, "Rotated trace files to keep, the newest (0 keeps all)")
+)
+
+func init() {
+	flag.Var(&traceMaxSize, "trace-max-size", "Start a new trace file once the current one reaches this size, e.g. 64m (0 disables)")
+}
+
+// Soak mode runs the server for hours under steady load and flags
+// goroutines, fds, heap or latency that keep growing (see the soak
+// package).
+var (
+	soakDir      = flag.String("soak", "", "Soak mode: write snapshots and heap profiles to this directory and flag steady growth")
+	soakInterval = flag.Duration("soak-interval", time.Minute, "Time between soak snapshots")
 )
 
-func hash(s string) string {
-    h := sha256.Sum256([]byte(s))
-    return hex.EncodeToString(h[:])
+// -control serves the drain protocol (see the drain package), so the load
+// generator can trigger a graceful shutdown and watch it complete, and the
+// knobs below (see the admin package), which can be changed while a test
+// runs. With -chaos it also serves /chaos, which injects faults into
+// connections.
+var controlAddr = flag.String("control", "localhost:9100", "Address for the drain protocol and the admin knobs")
+
+// Replies are batched: flushed after flushEvery messages or, if
+// flushDeadline is set, once the oldest unflushed reply has waited that
+// long (see the flush package). A deadline of 0 leaves a partial batch
+// until the next one fills; a count of 0 flushes by the deadline alone,
+// and both at 0 flush every reply.
+var (
+	flushEvery    = admin.NewInt(10, 0, 1<<16)
+	flushDeadline = admin.NewDuration(0, 0, time.Second)
+	closeLog      = admin.NewSampler(1)
+)
+
+func flushPolicy() flush.Policy {
+	return flush.Policy{Count: int(flushEvery.Load()), MaxDelay: flushDeadline.Load()}
 }
 
-var activeConns int32
+var ctl = drain.New()
+
+// Each client address may open -accept-rate connections per second, in
+// bursts of -accept-burst. Connections over the limit are reset as they are
+// accepted (see the ratelimit package).
+var (
+	acceptRate  = flag.Float64("accept-rate", 0, "New connections per second allowed from each client address (0 disables)")
+	acceptBurst = flag.Int("accept-burst", 20, "Connections a client address may open at once before -accept-rate applies")
+)
+
+// Connections from addresses not served within -seen-window are admitted
+// at -new-rate per second in total; the others skip that limit. The
+// addresses seen are kept in a Bloom filter (see the bloom package), a
+// few megabytes for millions of them.
+var (
+	newRate    = flag.Float64("new-rate", 0, "New connections per second allowed from addresses not seen within -seen-window (0 disables)")
+	newBurst   = flag.Int("new-burst", 100, "New-address connections allowed at once before -new-rate applies")
+	seenWindow = flag.Duration("seen-window", 10*time.Minute, "How long an address counts as seen after its last connection; remembered for up to twice as long")
+	seenSize   = flag.Int("seen-size", 1_000_000, "Addresses the seen set is sized for per window, at a 1% false-positive rate")
+)
+
+var (
+	chaosOn = flag.Bool("chaos", false, "Wrap connections in a fault injector driven from /chaos on the control address")
+	faults  = chaos.New()
+)
+
+// handleLatency holds the last 4096 per-message handling times. Handlers
+// record into it wait-free; the stats logger below snapshots it.
+var handleLatency = telemetry.NewRing(4096)
 
 func handle(conn net.Conn) {
-	defer conn.Close()
-	atomic.AddInt32(&activeConns, 1)
-	defer atomic.AddInt32(&activeConns, -1)
+	gc := readguard.Wrap(conn, &slowPolicy, reaper, true)
+	defer gc.Close()
+	echo.Conns.Add(1)
+	defer echo.Conns.Add(-1)
+	defer ctl.Track(conn)()
 
-	reader := bufio.NewReader(conn)
-	writer := bufio.NewWriter(conn)
+	// The codec caps messages at maxLineLength instead of buffering
+	// without bound, and decodes in place from the read buffer.
+	c, _ := codec.New(*codecName, maxLineLength)
+	cc := codec.NewPooledConn(echo.Conn(gc), c, cfg.ReadBuffer, connBufs)
+
+	// The connection is one trace task. Regions cost a check of whether
+	// tracing is on, and allocate nothing, when it is not.
+	ctx, task := trace.NewTask(context.Background(), "conn")
+	defer task.End()
+	if trace.IsEnabled() {
+		trace.Log(ctx, "remote", conn.RemoteAddr().String())
+	}
+	labels := telemetry.NewLabeler(ctx, "conn", telemetry.NextConnID())
 
-	const flushInterval = 10
-	count := 0
+	// The flush delay writes from a timer goroutine, so replies are
+	// buffered through the batcher, under its lock. A timed flush that has
+	// already fired may still be waiting for that lock: Close makes it a
+	// no-op before the buffers go back to the pool.
+	batch := flush.NewBatcher(func() error {
+		defer trace.StartRegion(ctx, "flush").End()
+		return cc.Flush()
+	})
+	defer batch.Close(cc.Release)
 
 	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			log.Printf("Connection closed (%s): %v", conn.RemoteAddr(), err)
-			return
+		var msgs []codec.Message
+		var err error
+		trace.WithRegion(ctx, "read", func() { msgs, err = cc.Next() })
+		if err == nil && cc.Buffered() == 0 {
+			gc.MessageDone()
+		}
+		// A message over the limit, or a malformed one, fails Next along
+		// with the complete messages read ahead of it. Those are answered
+		// before the connection closes.
+		if len(msgs) > 0 {
+			if werr := batch.Write(flushPolicy(), func() (int, error) { return reply(ctx, labels, cc, msgs) }); werr != nil {
+				log.Printf("Reply failed (%s): %v", conn.RemoteAddr(), werr)
+				return
+			}
 		}
-		hash(line)
-		_, err = writer.WriteString(line)
 		if err != nil {
-			log.Printf("Write failed (%s): %v", conn.RemoteAddr(), err)
+			if ctl.IsDraining() || len(msgs) > 0 {
+				// Don't drop replies still held by the flush batching.
+				batch.Flush()
+			}
+			if closeLog.Sample() {
+				log.Printf("Connection closed (%s): %v", conn.RemoteAddr(), err)
+			}
 			return
 		}
-		count++
-		if count >= flushInterval {
-			if err := writer.Flush(); err != nil {
+		// While draining, answer what has been read and hang up at the
+		// next message boundary.
+		if ctl.IsDraining() && cc.Buffered() == 0 {
+			if err := batch.Flush(); err != nil {
 				log.Printf("Flush failed (%s): %v", conn.RemoteAddr(), err)
-				return
 			}
-			count = 0
+			return
 		}
 	}
 }
 
+// reply hashes and encodes each of msgs into cc's output buffer, and
+// returns how many it buffered.
+func reply(ctx context.Context, labels *telemetry.Labeler, cc *codec.Conn, msgs []codec.Message) (int, error) {
+	for i, m := range msgs {
+		labels.Message(len(m.Payload))
+		start := time.Now()
+		trace.WithRegion(ctx, "hash", func() { hash(m.Payload) })
+		var err error
+		trace.WithRegion(ctx, "write", func() { err = cc.Send(m) })
+		if err != nil {
+			return i, err
+		}
+		d := time.Since(start)
+		handleLatency.Record(d)
+		echo.Latency.ObserveDuration(d)
+	}
+	return len(msgs), nil
+}
+
 func main() {
-	// Setup trace output
-	traceFile, err := os.Create("trace.out")
-	if err != nil {
-		log.Fatalf("failed to create trace file: %v", err)
+	flag.Parse()
+	if err := cfg.Apply(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	slowPolicy.IdleTimeout = cfg.IdleTimeout
+	connBufs = bufpool.New(cfg.ReadBuffer, max(cfg.ReadBuffer, 2*maxLineLength))
+	if _, err := codec.New(*codecName, maxLineLength); err != nil {
+		log.Fatal(err)
 	}
-	defer traceFile.Close()
 
-	if err := trace.Start(traceFile); err != nil {
-		log.Fatalf("failed to start trace: %v", err)
+	// Setup trace output. A soak runs for hours, over which one trace
+	// would grow to gigabytes, so it traces only with rotation on.
+	rotate := traceMaxSize > 0 || *traceMaxAge > 0
+	if *traceOut != "" && (*soakDir == "" || rotate) {
+		rec, err := tracefile.Start(tracefile.Config{
+			Path:    *traceOut,
+			MaxSize: int64(traceMaxSize),
+			MaxAge:  *traceMaxAge,
+			Keep:    *traceKeep,
+		})
+		if err != nil {
+			log.Fatalf("failed to start trace: %v", err)
+		}
+		defer rec.Stop()
 	}
-	defer trace.Stop()
 
-	ln, err := net.Listen("tcp", ":9000")
+	ln, err := cfg.Listen("tcp")
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	log.Println("Listening on :9000")
+	log.Println("Listening on", cfg.Addr)
+
+	go reaper.Run(context.Background(), time.Second)
 
-	// Periodic connection count logger
+	var mon *soak.Monitor
+	if *soakDir != "" {
+		mon = soak.New(soak.Config{Interval: *soakInterval, Dir: *soakDir, Latency: handleLatency})
+		go func() {
+			if err := mon.Run(context.Background()); err != nil {
+				log.Printf("soak: %v", err)
+			}
+		}()
+	}
+
+	if *pprofAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(*pprofAddr, nil); err != nil {
+				log.Printf("pprof listener: %v", err)
+			}
+		}()
+	}
+
+	ctl.OnDrain(func() {
+		log.Printf("Draining: closing listener, %d connections open", echo.Conns.Load())
+		ln.Close()
+	})
+	adm := admin.New()
+	adm.Handle("/drain", ctl)
+	adm.Knob("flush_every", "replies batched per flush (0 flushes by the deadline alone)", flushEvery)
+	adm.Knob("flush_deadline", "longest a batched reply waits for its flush (0 waits for a full batch)", flushDeadline)
+	adm.Knob("gc_percent", "GOGC; -1 turns the GC off", admin.GCPercent())
+	adm.Knob("log_closes", "log one connection close in this many (0 for none)", closeLog)
+	if *acceptRate > 0 || *newRate > 0 {
+		var perIP *ratelimit.PerKey[netip.Addr]
+		if *acceptRate > 0 {
+			perIP = ratelimit.NewPerKey[netip.Addr](*acceptRate, *acceptBurst)
+		}
+		rl := ratelimit.NewListener(ln, perIP, nil)
+		if *newRate > 0 {
+			seen := bloom.NewWindow[netip.Addr](*seenSize, 0.01, *seenWindow)
+			rl.LimitNew(seen, ratelimit.NewTokenBucket(*newRate, *newBurst))
+		}
+		ln = rl
+	}
+	if *chaosOn {
+		adm.Handle("/chaos", faults)
+		ln = faults.Listen(ln)
+	}
+	// Outermost, so that the fault injector still sees the *net.TCPConn.
+	enc, _ := codec.New(*codecName, maxLineLength)
+	ln = cfg.Limit(ln, connlimit.BusyReply(enc))
+	go func() {
+		if err := adm.ListenAndServe(*controlAddr); err != nil {
+			log.Printf("control listener: %v", err)
+		}
+	}()
+
+	// Periodic connection count and handling latency logger
 	go func() {
 		ticker := time.NewTicker(5 * time.Second)
 		defer ticker.Stop()
+		var samples []time.Duration
 		for range ticker.C {
-			log.Printf("Active connections: %d\n", atomic.LoadInt32(&activeConns))
+			samples = handleLatency.Snapshot(samples[:0])
+			q := telemetry.Quantiles(samples, 0.5, 0.99, 0.999)
+			log.Printf("Active connections: %d, reaped slow clients: %d, handle p50/p99/p99.9: %v/%v/%v\n",
+				echo.Conns.Load(), reaper.Reaped(), q[0], q[1], q[2])
 		}
 	}()
 
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if ctl.IsDraining() {
+				break
+			}
 			log.Printf("Accept error: %v", err)
 			continue
 		}
+		echo.Accepted.Inc()
 		go handle(conn)
 	}
+
+	d, _ := ctl.Wait(context.Background())
+	log.Printf("Drained in %v", d)
+	if mon != nil {
+		for _, d := range mon.Drifts() {
+			log.Printf("soak: still drifting at exit: %v", d)
+		}
+	}
 }
\ No newline at end of file
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"runtime/trace"
+	"testing"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/budget"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/bufpool"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/transport"
+)
+
+// setup gives handle its buffer pool and flushes every reply, for clients
+// that wait for each one.
+func setup(t *testing.T) {
+	connBufs = bufpool.New(cfg.ReadBuffer, max(cfg.ReadBuffer, 2*maxLineLength))
+	for knob, v := range map[interface{ Parse(string) (func(), error) }]string{flushEvery: "1", closeLog: "0"} {
+		apply, err := knob.Parse(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		apply()
+	}
+}
+
+// TestAllocBudget holds handle to no heap allocations per echoed line,
+// with every reply flushed: the read guard, the labels, the hash, the
+// codec and the batcher all work in place once the connection is set up.
+// A regression here, such as a string conversion back in the hash, costs
+// an allocation per message on every connection the server holds.
+// net.Pipe is left out: the read guard sets a read deadline per message,
+// and a net.Pipe deadline allocates a timer where a socket's does not.
+//
+//	go test echo-net-trace.go echo-net-trace_test.go
+func TestAllocBudget(t *testing.T) {
+	setup(t)
+	for _, name := range []string{"unix", "tcp"} {
+		t.Run(name, func(t *testing.T) {
+			client, server, err := transport.Pair(name)
+			if err != nil {
+				t.Skip(err)
+			}
+			go handle(server)
+			s := budget.LineConn(client, 64)
+			defer s.Close()
+			budget.CheckAllocs(t, s, 0)
+		})
+	}
+}
+
+// TestTraceRegions checks that a traced connection writes its task and
+// the four regions of its handler into the trace.
+func TestTraceRegions(t *testing.T) {
+	setup(t)
+	var out bytes.Buffer
+	if err := trace.Start(&out); err != nil {
+		t.Fatal(err)
+	}
+	client, server, err := transport.Pair("unix")
+	if err != nil {
+		trace.Stop()
+		t.Skip(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handle(server)
+	}()
+	s := budget.LineConn(client, 64)
+	for range 20 {
+		if err := s.RoundTrip(); err != nil {
+			trace.Stop()
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+	<-done
+	trace.Stop()
+	// The trace stores each string once, after its length; stack frames
+	// that merely contain "read" or "write" are longer strings.
+	for _, name := range []string{"conn", "remote", "read", "hash", "write", "flush"} {
+		if !bytes.Contains(out.Bytes(), append([]byte{byte(len(name))}, name...)) {
+			t.Errorf("no %q in the trace", name)
+		}
+	}
+}
+
+// TestTooLarge sends two length-prefixed messages and the header of a
+// third over maxLineLength in one write. The codec decodes the two and
+// fails on the third in the same call, and the two must still be echoed
+// before the connection closes.
+func TestTooLarge(t *testing.T) {
+	setup(t)
+	defer func(name string) { *codecName = name }(*codecName)
+	*codecName = "length"
+	client, server, err := transport.Pair("unix")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer client.Close()
+	go handle(server)
+	var want []byte
+	for _, p := range []string{"one", "two"} {
+		want = binary.BigEndian.AppendUint32(want, uint32(len(p)))
+		want = append(want, p...)
+	}
+	in := binary.BigEndian.AppendUint32(bytes.Clone(want), 2*maxLineLength)
+	go client.Write(in)
+	got, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestMetrics checks what one connection of 20 round trips adds to the
+// shared echo metrics: a request and a flush per line, and 64 bytes each
+// way.
+func TestMetrics(t *testing.T) {
+	setup(t)
+	client, server, err := transport.Pair("unix")
+	if err != nil {
+		t.Skip(err)
+	}
+	reqs, in, out, flushes := echo.Latency.Count(), echo.BytesIn.Load(), echo.BytesOut.Load(), echo.Flushes.Load()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handle(server)
+	}()
+	s := budget.LineConn(client, 64)
+	for range 20 {
+		if err := s.RoundTrip(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+	<-done
+	for _, c := range []struct {
+		name      string
+		got, want uint64
+	}{
+		{"requests", echo.Latency.Count() - reqs, 20},
+		{"bytes in", echo.BytesIn.Load() - in, 20 * 64},
+		{"bytes out", echo.BytesOut.Load() - out, 20 * 64},
+		{"flushes", echo.Flushes.Load() - flushes, 20},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s: %d, want %d", c.name, c.got, c.want)
+		}
+	}
+}
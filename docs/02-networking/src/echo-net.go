@@ -2,18 +2,46 @@ package main
 
 import (
     "bufio"
+    "flag"
     "fmt"
     "net"
     "time"
+
+    "github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+    "github.com/astavonin/go-optimization-guide/docs/02-networking/src/connlimit"
+    "github.com/astavonin/go-optimization-guide/docs/02-networking/src/metrics"
+    "github.com/astavonin/go-optimization-guide/docs/02-networking/src/srvconfig"
 )
 
+// cfg holds the listen address, buffer sizes, timeouts and connection
+// limit, from flags or SRV_* environment variables; -addr :9001 runs a
+// second copy alongside.
+var cfg = srvconfig.Register(flag.CommandLine, srvconfig.Config{
+    Addr:        ":9000",
+    ReadBuffer:  4096,
+    IdleTimeout: 5 * time.Minute,
+}, srvconfig.Addr|srvconfig.ReadBuffer|srvconfig.SocketBuffers|srvconfig.IdleTimeout|srvconfig.WriteTimeout|srvconfig.MaxConns)
+
+// echo counts connections, requests, bytes and writes under the names the
+// other echo servers use; -debug-addr serves them at /metrics
+var echo = metrics.NewEcho(metrics.Default)
+
 func main() {
-    // Start listening on TCP port 9000
-    listener, err := net.Listen("tcp", ":9000")
+    flag.Parse()
+    if err := cfg.Apply(flag.CommandLine); err != nil {
+        panic(err) // Exit on a bad flag or environment variable
+    }
+
+    // Start listening on TCP, port 9000 by default
+    listener, err := cfg.Listen("tcp")
     if err != nil {
         panic(err) // Exit if the port can't be bound
     }
-    fmt.Println("Echo server listening on :9000")
+    fmt.Println("Echo server listening on", cfg.Addr)
+
+    // With -max-conns, hold at most that many connections open; past it,
+    // leave new ones waiting in the kernel or turn them away with a busy line
+    listener = cfg.Limit(listener, connlimit.BusyReply(codec.NewLine(0)))
 
     // Accept incoming connections in a loop
     for {
@@ -22,6 +50,7 @@ func main() {
             fmt.Printf("Accept error: %v\n", err)
             continue // Skip this iteration on error
         }
+        echo.Accepted.Inc()
 
         // Handle the connection in a new goroutine for concurrency
         go handle(conn)
@@ -31,12 +60,17 @@ func main() {
 // handle echoes data back to the client line-by-line
 func handle(conn net.Conn) {
     defer conn.Close() // Ensure connection is closed on exit
+    echo.Conns.Add(1)
+    defer echo.Conns.Add(-1)
+    conn = echo.Conn(conn) // Count the bytes and writes
 
-    reader := bufio.NewReader(conn) // Wrap connection with buffered reader
+    reader := bufio.NewReaderSize(conn, cfg.ReadBuffer) // Wrap connection with buffered reader
 
     for {
         // Set a read deadline to avoid hanging goroutines if client disappears
-        conn.SetReadDeadline(time.Now().Add(5 * 60 * time.Second)) // 5 minutes timeout
+        if cfg.IdleTimeout > 0 {
+            conn.SetReadDeadline(time.Now().Add(cfg.IdleTimeout)) // 5 minutes by default
+        }
 
         // Read input until newline character
         line, err := reader.ReadString('\n')
@@ -44,12 +78,18 @@ func handle(conn net.Conn) {
             fmt.Printf("Connection closed: %v\n", err)
             return // Exit on read error (e.g. client disconnect)
         }
+        start := time.Now() // Handling time, for the latency histogram
 
-        // Echo the received line back to the client
+        // Echo the received line back to the client, giving up on a
+        // client that stops reading its replies
+        if cfg.WriteTimeout > 0 {
+            conn.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout))
+        }
         _, err = conn.Write([]byte(line))
         if err != nil {
             fmt.Printf("Write error: %v\n", err)
             return // Exit on write error
         }
+        echo.Latency.ObserveDuration(time.Since(start))
     }
 }
\ No newline at end of file
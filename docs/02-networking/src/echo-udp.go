@@ -0,0 +1,191 @@
+//go:build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/debugsrv"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/mmsg"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/poller"
+)
+
+var (
+	batch     = flag.Int("batch", 64, "Datagrams per recvmmsg and sendmmsg (1 makes one syscall per datagram in each direction)")
+	netUDP    = flag.Bool("net", false, "Serve with net.UDPConn, ReadFromUDPAddrPort and WriteToUDPAddrPort on one goroutine instead of the poller and mmsg")
+	every     = flag.Duration("stats", 0, "Print datagrams, syscalls and wakeups per second at this interval (0 disables)")
+	debugAddr = flag.String("debug-addr", "", debugsrv.Usage)
+)
+
+// maxDatagram is the size of each receive buffer. Longer datagrams are
+// truncated by the kernel, and echoed as far as they were received.
+const maxDatagram = 2048
+
+// counters are kept by the serving goroutine and read by the stats
+// printer. With -net, a call that would block parks the goroutine in the
+// runtime's netpoller, and the recvfrom that returned EAGAIN and the
+// epoll_wait that follows it are not counted.
+var counters struct {
+	in, out, dropped, trunc atomic.Uint64
+	recvs, sends, wakeups   atomic.Uint64
+}
+
+func main() {
+	flag.Parse()
+	if *debugAddr != "" {
+		if err := debugsrv.Listen(*debugAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *batch <= 0 {
+		log.Fatal("-batch must be positive")
+	}
+	if *every > 0 {
+		go printStats(*every)
+	}
+	if *netUDP {
+		serveNet()
+		return
+	}
+
+	p, err := poller.New()
+	if err != nil {
+		log.Fatal("poller error:", err)
+	}
+	defer p.Close()
+	fd, err := listenUDP(9000)
+	if err != nil {
+		log.Fatal("Listen error:", err)
+	}
+	defer syscall.Close(fd)
+	log.Printf("Listening on :9000/udp (epoll, batches of %d)", *batch)
+
+	b := mmsg.NewBatch(*batch)
+	msgs := make([]mmsg.Message, *batch)
+	bufs := make([]byte, *batch*maxDatagram)
+	for i := range msgs {
+		msgs[i].Buf = bufs[i*maxDatagram : (i+1)*maxDatagram : (i+1)*maxDatagram]
+	}
+
+	// echo receives a batch and sends it straight back, until the socket
+	// has nothing left. The socket is level-triggered, so stopping early
+	// would lose nothing, but the next Wait would cost a syscall for
+	// datagrams already known to be there. A reply the send buffer has no
+	// room for is dropped, as the network would drop it: UDP has no
+	// backpressure to pass on, and queueing replies would only delay the
+	// ones behind them.
+	echo := func(fd int, ev poller.Event) {
+		for {
+			counters.recvs.Add(1)
+			n, err := b.Recv(fd, msgs)
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				return
+			}
+			if err != nil {
+				log.Println("recvmmsg error:", err)
+				return
+			}
+			counters.in.Add(uint64(n))
+			for _, m := range msgs[:n] {
+				if m.Trunc {
+					counters.trunc.Add(1)
+				}
+			}
+			for off := 0; off < n; {
+				counters.sends.Add(1)
+				sent, err := b.Send(fd, msgs[off:n])
+				if err != nil {
+					if err != syscall.EAGAIN && err != syscall.ENOBUFS {
+						log.Println("sendmmsg error:", err)
+					}
+					counters.dropped.Add(uint64(n - off))
+					break
+				}
+				counters.out.Add(uint64(sent))
+				off += sent
+			}
+		}
+	}
+	if err := p.Add(fd, poller.Read, echo); err != nil {
+		log.Fatal("poller Add error:", err)
+	}
+	for {
+		if _, err := p.Wait(-1); err != nil {
+			log.Fatal("Wait error:", err)
+		}
+		counters.wakeups.Add(1)
+	}
+}
+
+// serveNet is the naive server: a datagram per ReadFromUDPAddrPort and a
+// reply per WriteToUDPAddrPort, each its own syscall.
+func serveNet() {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: 9000})
+	if err != nil {
+		log.Fatal("Listen error:", err)
+	}
+	defer conn.Close()
+	log.Println("Listening on :9000/udp (net.UDPConn)")
+	buf := make([]byte, maxDatagram)
+	for {
+		counters.recvs.Add(1)
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			log.Fatal("Read error:", err)
+		}
+		counters.in.Add(1)
+		counters.sends.Add(1)
+		if _, err := conn.WriteToUDPAddrPort(buf[:n], from); err != nil {
+			counters.dropped.Add(1)
+			continue
+		}
+		counters.out.Add(1)
+	}
+}
+
+// listenUDP returns a non-blocking UDP socket bound to port on every
+// address, dual-stack where IPv6 is available.
+func listenUDP(port int) (int, error) {
+	const flags = syscall.SOCK_DGRAM | syscall.SOCK_NONBLOCK | syscall.SOCK_CLOEXEC
+	var sa syscall.Sockaddr = &syscall.SockaddrInet6{Port: port}
+	fd, err := syscall.Socket(syscall.AF_INET6, flags, 0)
+	if err == nil {
+		if err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 0); err != nil {
+			syscall.Close(fd)
+		}
+	}
+	if err != nil {
+		sa = &syscall.SockaddrInet4{Port: port}
+		if fd, err = syscall.Socket(syscall.AF_INET, flags, 0); err != nil {
+			return -1, err
+		}
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+func printStats(interval time.Duration) {
+	var last [7]uint64
+	for range time.Tick(interval) {
+		cur := [7]uint64{
+			counters.in.Load(), counters.out.Load(), counters.dropped.Load(), counters.trunc.Load(),
+			counters.recvs.Load(), counters.sends.Load(), counters.wakeups.Load(),
+		}
+		var d [7]float64
+		for i := range cur {
+			d[i] = float64(cur[i]-last[i]) / interval.Seconds()
+		}
+		last = cur
+		fmt.Printf("in/s=%.0f out/s=%.0f dropped/s=%.0f truncated/s=%.0f recv calls/s=%.0f send calls/s=%.0f wakeups/s=%.0f (%.2f syscalls per datagram)\n",
+			d[0], d[1], d[2], d[3], d[4], d[5], d[6], (d[4]+d[5]+d[6])/max(d[0], 1))
+	}
+}
@@ -0,0 +1,192 @@
+//go:build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/debugsrv"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/uring"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	entries   = flag.Uint("entries", 4096, "Submission queue size; completions get twice as many")
+	every     = flag.Duration("stats", 0, "Print enters, submissions and completions per second at this interval (0 disables)")
+	debugAddr = flag.String("debug-addr", "", debugsrv.Usage)
+)
+
+// counters are kept by the event loop and read by the stats printer.
+var counters struct {
+	enters, submitted, completed, bytes atomic.Uint64
+}
+
+// The operation a completion belongs to is kept in the low bits of its
+// user data, the fd in the rest.
+const (
+	opAccept = iota
+	opRecv
+	opSend
+	opClose
+)
+
+func userData(fd, op int) uint64 { return uint64(fd)<<2 | uint64(op) }
+
+// client is the per-fd state. Each client has exactly one operation in
+// the kernel at a time, a recv or the send of what it returned, so the
+// echo keeps its order and a client that does not read its replies stops
+// being read from: the send waits, and no recv is queued behind it.
+type client struct {
+	fd   int
+	buf  []byte // the kernel's until the operation on it completes
+	n    int    // bytes received into buf
+	sent int    // bytes of them sent back
+}
+
+func main() {
+	flag.Parse()
+	if *debugAddr != "" {
+		if err := debugsrv.Listen(*debugAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Create the ring.
+	ring, err := uring.New(uint32(*entries))
+	if err != nil {
+		log.Fatal("io_uring error:", err)
+	}
+	defer ring.Close()
+
+	// Start listening on port 9000. File returns a duplicate of the
+	// socket in blocking mode, outside the Go runtime poller; the ring
+	// does its own waiting.
+	ln, err := net.Listen("tcp", ":9000")
+	if err != nil {
+		log.Fatal("Listen error:", err)
+	}
+	defer ln.Close()
+	lf, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		log.Fatal("Listener fd error:", err)
+	}
+	defer lf.Close()
+	lfd := int(lf.Fd())
+	log.Println("Listening on :9000 (io_uring)")
+
+	if *every > 0 {
+		go printStats(*every)
+	}
+
+	// sqe returns a free submission entry, submitting the queued ones
+	// first if the queue is full.
+	sqe := func(fd, op int) *uring.SQE {
+		for {
+			if s := ring.SQE(); s != nil {
+				s.UserData = userData(fd, op)
+				return s
+			}
+			n, err := ring.Submit()
+			if err != nil {
+				log.Fatal("Submit error:", err)
+			}
+			counters.enters.Add(1)
+			counters.submitted.Add(uint64(n))
+		}
+	}
+	clients := map[int]*client{}
+
+	recv := func(c *client) { sqe(c.fd, opRecv).PrepRecv(c.fd, c.buf, 0) }
+	send := func(c *client) { sqe(c.fd, opSend).PrepSend(c.fd, c.buf[c.sent:c.n], 0) }
+
+	// closeClient forgets the client before its close is queued: once the
+	// kernel has closed the fd, the next accept may return the same
+	// number, and its completion may arrive before the close's.
+	closeClient := func(c *client) {
+		delete(clients, c.fd)
+		sqe(c.fd, opClose).PrepClose(c.fd)
+	}
+
+	complete := func(cqe uring.CQE) {
+		fd, op := int(cqe.UserData>>2), int(cqe.UserData&3)
+		switch op {
+		case opAccept:
+			// Accept again at once, then start reading from the new client.
+			sqe(lfd, opAccept).PrepAccept(lfd, unix.SOCK_CLOEXEC)
+			if err := cqe.Err(); err != nil {
+				log.Println("Accept error:", err)
+				return
+			}
+			c := &client{fd: int(cqe.Res), buf: make([]byte, 4096)}
+			clients[c.fd] = c
+			recv(c)
+		case opRecv:
+			c := clients[fd]
+			if err := cqe.Err(); err != nil {
+				log.Println("Read error on fd", fd, err)
+				closeClient(c)
+				return
+			}
+			// A zero-byte read indicates that the client closed the connection.
+			if cqe.Res == 0 {
+				closeClient(c)
+				return
+			}
+			c.n, c.sent = int(cqe.Res), 0
+			send(c)
+		case opSend:
+			c := clients[fd]
+			if err := cqe.Err(); err != nil {
+				log.Println("Write error on fd", fd, err)
+				closeClient(c)
+				return
+			}
+			counters.bytes.Add(uint64(cqe.Res))
+			c.sent += int(cqe.Res)
+			if c.sent < c.n {
+				send(c) // a short send: the rest, before reading more
+				return
+			}
+			recv(c)
+		case opClose:
+		}
+	}
+
+	// Event loop: each enter submits everything queued since the last
+	// one and waits for at least one completion; then every completion
+	// that is ready is handled, queueing the next operations.
+	sqe(lfd, opAccept).PrepAccept(lfd, unix.SOCK_CLOEXEC)
+	for {
+		n, err := ring.SubmitAndWait(1)
+		if err != nil {
+			log.Fatal("Wait error:", err)
+		}
+		counters.enters.Add(1)
+		counters.submitted.Add(uint64(n))
+		counters.completed.Add(uint64(ring.Completions(complete)))
+	}
+}
+
+// printStats prints, every interval, the io_uring_enter calls per second,
+// the entries they submitted and the completions they reaped, and the
+// bytes echoed. An epoll loop makes one syscall per read and per write on
+// top of each wait; here one enter submits them all and collects their
+// results, and completions per enter is how many it batched.
+func printStats(interval time.Duration) {
+	var last [4]uint64
+	for range time.Tick(interval) {
+		cur := [4]uint64{counters.enters.Load(), counters.submitted.Load(), counters.completed.Load(), counters.bytes.Load()}
+		var d [4]float64
+		for i := range cur {
+			d[i] = float64(cur[i]-last[i]) / interval.Seconds()
+		}
+		last = cur
+		fmt.Printf("enters/s=%.0f submitted/s=%.0f completed/s=%.0f (%.1f per enter) MB/s=%.1f\n",
+			d[0], d[1], d[2], d[2]/max(d[0], 1), d[3]/1e6)
+	}
+}
@@ -0,0 +1,113 @@
+package fanout
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// BenchmarkFanout publishes one payload to every subscriber, either as
+// one shared Msg or as a Msg copied for each subscriber, the way a hub
+// of plain []byte queues has to. The subscribers only release what they
+// receive, so the difference is the copying and the pool traffic.
+func BenchmarkFanout(b *testing.B) {
+	for _, subs := range []int{10, 100, 1000} {
+		for _, size := range []int{64, 4 << 10} {
+			for _, mode := range []string{"shared", "copy"} {
+				b.Run(fmt.Sprintf("subs=%d/size=%d/%s", subs, size, mode), func(b *testing.B) {
+					benchFanout(b, subs, size, mode == "copy")
+				})
+			}
+		}
+	}
+}
+
+func benchFanout(b *testing.B, subs, size int, copyEach bool) {
+	p := NewPool(Config{})
+	h := NewHub(64)
+	var wg sync.WaitGroup
+	for range subs {
+		s := h.Subscribe()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range s.C {
+				m.Release()
+			}
+		}()
+	}
+	payload := make([]byte, size)
+	b.ReportAllocs()
+	for b.Loop() {
+		if copyEach {
+			h.mu.RLock()
+			for _, s := range h.subs {
+				s.c <- p.New(payload)
+			}
+			h.mu.RUnlock()
+			continue
+		}
+		m := p.New(payload)
+		h.Publish(m)
+		m.Release()
+	}
+	copies := 1
+	if copyEach {
+		copies = subs
+	}
+	b.ReportMetric(float64(copies*size), "copied-B/op")
+	for _, s := range append([]*Sub(nil), h.subs...) {
+		h.Unsubscribe(s)
+	}
+	wg.Wait()
+}
+
+// BenchmarkSlowSubscriber publishes to 10,000 subscribers that keep up
+// and one that takes 10ms per message, a client on a congested link.
+// ns/op is how often the fast subscribers get a message.
+func BenchmarkSlowSubscriber(b *testing.B) {
+	const fast, depth = 10000, 16
+	for _, policy := range []SlowPolicy{Block, DropOldest, Disconnect, Spill} {
+		b.Run(policy.String(), func(b *testing.B) {
+			p := NewPool(Config{})
+			h := NewHub(depth)
+			var wg sync.WaitGroup
+			for range fast {
+				s := h.Subscribe()
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for m := range s.C {
+						m.Release()
+					}
+				}()
+			}
+			slow := h.SubscribeWith(SubConfig{Policy: policy, SpillDir: b.TempDir()})
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for m := range slow.C {
+					time.Sleep(10 * time.Millisecond)
+					m.Release()
+				}
+			}()
+
+			payload := make([]byte, 256)
+			maxSpilled := 0
+			for b.Loop() {
+				m := p.New(payload)
+				h.Publish(m)
+				m.Release()
+				maxSpilled = max(maxSpilled, slow.Stats().Spilled)
+			}
+			st := slow.Stats()
+			b.ReportMetric(float64(st.Dropped)/float64(b.N), "slow-dropped/op")
+			b.ReportMetric(float64(maxSpilled), "slow-spilled-max")
+			for _, s := range append([]*Sub(nil), h.subs...) {
+				h.Unsubscribe(s)
+			}
+			wg.Wait()
+		})
+	}
+}
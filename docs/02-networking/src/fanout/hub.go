@@ -0,0 +1,304 @@
+package fanout
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrSlow is the reason a subscriber was disconnected for falling behind.
+var ErrSlow = errors.New("fanout: subscriber too slow")
+
+// Hub delivers every published Msg to every subscriber, in the order it
+// was published. Each subscriber has a queue of its own, so a subscriber
+// that writes to a slow connection does not hold up the others until its
+// queue is full. What happens then is the subscriber's SlowPolicy. With
+// the default, Block, Publish waits for room, and every subscriber waits
+// with it: one stalled client stops the whole hub.
+type Hub struct {
+	mu    sync.RWMutex
+	subs  []*Sub
+	depth int
+
+	disconnected atomic.Uint64
+}
+
+// SlowPolicy is what Publish does with a message for a full queue.
+type SlowPolicy int
+
+const (
+	// Block waits until the subscriber makes room. Nothing is lost, and
+	// every other subscriber waits too.
+	Block SlowPolicy = iota
+	// DropOldest discards the oldest queued message to make room. The
+	// subscriber sees a gap; suits feeds where only the latest state
+	// matters, such as prices or positions.
+	DropOldest
+	// Disconnect unsubscribes the subscriber with ErrSlow. It can
+	// reconnect and resynchronize, which is often cheaper for everyone
+	// than serving it slowly.
+	Disconnect
+	// Spill appends the message to a file of the subscriber's own and
+	// feeds the queue from the file as the subscriber catches up. Nothing
+	// is lost and nobody waits, as long as the disk keeps up and the
+	// subscriber does catch up; past SpillLimit it is disconnected.
+	Spill
+)
+
+func (p SlowPolicy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropOldest:
+		return "drop-oldest"
+	case Disconnect:
+		return "disconnect"
+	case Spill:
+		return "spill"
+	}
+	return "unknown"
+}
+
+// SubConfig configures a subscriber. Zero values select the defaults.
+type SubConfig struct {
+	Depth  int // queued messages; defaults to the Hub's depth
+	Policy SlowPolicy
+
+	// SpillDir holds Spill's files; defaults to os.TempDir().
+	SpillDir string
+	// SpillLimit bounds a subscriber's spill file; defaults to 64MiB.
+	SpillLimit int64
+}
+
+// A Sub is one subscriber's queue.
+type Sub struct {
+	// C delivers the subscriber's messages. Each carries a reference the
+	// subscriber owns and must Release. C is closed by Unsubscribe, or
+	// when the Disconnect policy or a spill failure drops the subscriber;
+	// Err then says why.
+	C    <-chan *Msg
+	c    chan *Msg
+	cfg  SubConfig
+	hub  *Hub
+	done chan struct{} // closed by Unsubscribe, to free a blocked Publish
+	once sync.Once
+	err  atomic.Pointer[error]
+
+	maxDepth  atomic.Int64
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+
+	spill spill
+}
+
+// SubStats are a subscriber's counters.
+type SubStats struct {
+	Policy    SlowPolicy
+	Depth     int    // messages queued in C now
+	MaxDepth  int    // the most ever queued in C
+	Spilled   int    // messages in the spill file, behind those in C
+	SpillSize int64  // bytes in the spill file
+	Delivered uint64 // messages queued in C, including those read back from the spill file
+	Dropped   uint64 // messages discarded by DropOldest
+}
+
+// Stats returns s's counters.
+func (s *Sub) Stats() SubStats {
+	st := SubStats{
+		Policy:    s.cfg.Policy,
+		Depth:     len(s.c),
+		MaxDepth:  int(s.maxDepth.Load()),
+		Delivered: s.delivered.Load(),
+		Dropped:   s.dropped.Load(),
+	}
+	st.Spilled, st.SpillSize = s.spill.backlog()
+	return st
+}
+
+// Err returns why the hub unsubscribed s, or nil if it did not.
+func (s *Sub) Err() error {
+	if err := s.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// NewHub returns a Hub that queues up to depth messages per subscriber.
+func NewHub(depth int) *Hub {
+	return &Hub{depth: max(depth, 1)}
+}
+
+// Subscribe adds a subscriber with the default configuration, which
+// receives the messages published after it returns.
+func (h *Hub) Subscribe() *Sub { return h.SubscribeWith(SubConfig{}) }
+
+// SubscribeWith adds a subscriber configured by cfg.
+func (h *Hub) SubscribeWith(cfg SubConfig) *Sub {
+	if cfg.Depth <= 0 {
+		cfg.Depth = h.depth
+	}
+	if cfg.SpillLimit <= 0 {
+		cfg.SpillLimit = 64 << 20
+	}
+	c := make(chan *Msg, cfg.Depth)
+	s := &Sub{C: c, c: c, cfg: cfg, hub: h, done: make(chan struct{})}
+	h.mu.Lock()
+	h.subs = append(h.subs, s)
+	h.mu.Unlock()
+	return s
+}
+
+// Unsubscribe removes s and closes its channel. Messages still queued
+// stay there for the subscriber to receive and release, or to drop with
+// Drain; messages in a spill file are discarded. It may be called by the
+// subscriber itself while a Publish is waiting for room in its queue:
+// that Publish skips s.
+func (h *Hub) Unsubscribe(s *Sub) {
+	s.once.Do(func() { close(s.done) })
+	s.spill.stop() // its feeder sends to c, so it must be gone first
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i := slices.Index(h.subs, s); i >= 0 {
+		h.subs = slices.Delete(h.subs, i, i+1)
+		close(s.c)
+	}
+}
+
+// drop unsubscribes s for err.
+func (h *Hub) drop(s *Sub, err error) {
+	if s.err.CompareAndSwap(nil, &err) {
+		h.disconnected.Add(1)
+	}
+	h.Unsubscribe(s)
+}
+
+// Drain releases the messages queued for an unsubscribed s.
+func (s *Sub) Drain() {
+	for m := range s.c {
+		m.Release()
+	}
+}
+
+// Len returns the number of messages queued for s.
+func (s *Sub) Len() int { return len(s.c) }
+
+// Publish queues m for every subscriber, with a reference each. The
+// caller keeps its own reference and releases it as usual, so a message
+// published to nobody is freed by that Release.
+func (h *Hub) Publish(m *Msg) {
+	type failed struct {
+		s   *Sub
+		err error
+	}
+	var drops []failed
+	h.mu.RLock()
+	// One atomic add for all of them rather than one per subscriber; the
+	// caller's reference keeps m alive while the queues fill.
+	m.retain(len(h.subs))
+	for _, s := range h.subs {
+		if err := s.deliver(m); err != nil {
+			drops = append(drops, failed{s, err})
+		}
+	}
+	h.mu.RUnlock()
+	for _, d := range drops {
+		h.drop(d.s, d.err) // needs the write lock
+	}
+}
+
+// deliver queues m, which carries a reference for s, according to s's
+// policy. An error means s has to go.
+func (s *Sub) deliver(m *Msg) error {
+	if s.cfg.Policy == Spill && s.spill.active() {
+		return s.spillMsg(m) // behind the file, to stay in order
+	}
+	select {
+	case s.c <- m:
+		s.queued()
+		return nil
+	default:
+	}
+
+	switch s.cfg.Policy {
+	case DropOldest:
+		// Take one out, then put m in. A select on both would pick at
+		// random when both are ready and drop more than it needs to.
+		for {
+			select {
+			case old := <-s.c:
+				old.Release()
+				s.dropped.Add(1)
+			default: // the subscriber emptied it first
+			}
+			select {
+			case s.c <- m:
+				s.queued()
+				return nil
+			default: // another publisher filled it first
+			}
+		}
+	case Disconnect:
+		m.Release()
+		return ErrSlow
+	case Spill:
+		return s.spillMsg(m)
+	}
+	select {
+	case s.c <- m:
+		s.queued()
+	case <-s.done:
+		m.Release()
+	}
+	return nil
+}
+
+// queued counts a message put in C.
+func (s *Sub) queued() {
+	s.delivered.Add(1)
+	d := int64(len(s.c))
+	for {
+		old := s.maxDepth.Load()
+		if d <= old || s.maxDepth.CompareAndSwap(old, d) {
+			return
+		}
+	}
+}
+
+// spillMsg appends m to the spill file and releases it.
+func (s *Sub) spillMsg(m *Msg) error {
+	defer m.Release()
+	return s.spill.write(s, m)
+}
+
+// HubStats summarize a Hub's subscribers.
+type HubStats struct {
+	Subscribers  int
+	Disconnected uint64 // subscribers dropped by Disconnect or a spill failure
+	Dropped      uint64 // messages discarded by DropOldest, over current subscribers
+	Spilling     int    // subscribers with a spill file
+	MaxDepth     int    // the longest queue now, spill files included
+}
+
+// Stats returns h's counters. It visits every subscriber.
+func (h *Hub) Stats() HubStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	st := HubStats{Subscribers: len(h.subs), Disconnected: h.disconnected.Load()}
+	for _, s := range h.subs {
+		ss := s.Stats()
+		st.Dropped += ss.Dropped
+		if ss.Spilled > 0 {
+			st.Spilling++
+		}
+		st.MaxDepth = max(st.MaxDepth, ss.Depth+ss.Spilled)
+	}
+	return st
+}
+
+// Subscribers returns the number of subscribers.
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs)
+}
@@ -0,0 +1,95 @@
+package fanout
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestHub publishes to many subscribers at once and checks that each
+// receives every message in order, that they all share one payload, and
+// that the last Release of each frees it.
+func TestHub(t *testing.T) {
+	const subs, msgs = 50, 200
+	p := NewPool(Config{Track: true})
+	h := NewHub(4)
+	var wg sync.WaitGroup
+	errs := make(chan error, subs)
+	first := make(chan *byte, subs)
+	var all []*Sub
+	for range subs {
+		s := h.Subscribe()
+		all = append(all, s)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			i, ok := 0, true
+			for m := range s.C {
+				if want := fmt.Sprint(i); ok && string(m.Payload) != want {
+					errs <- fmt.Errorf("message %d is %q", i, m.Payload)
+					ok = false
+				}
+				if i == 0 {
+					first <- &m.Payload[0]
+				}
+				i++
+				m.Release()
+			}
+			if ok && i != msgs {
+				errs <- fmt.Errorf("%d messages, want %d", i, msgs)
+			}
+		}()
+	}
+	for i := range msgs {
+		m := p.New([]byte(fmt.Sprint(i)))
+		h.Publish(m)
+		m.Release()
+	}
+	for _, s := range all {
+		h.Unsubscribe(s)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	close(first)
+	shared := <-first
+	for b := range first {
+		if b != shared {
+			t.Fatal("subscribers got different copies of the first message")
+		}
+	}
+	if leaks := p.Leaks(); len(leaks) > 0 {
+		t.Errorf("%d messages never released, the first:\n%v", len(leaks), leaks[0])
+	}
+}
+
+// TestUnsubscribeBlocked checks that a subscriber that stops reading can
+// leave while Publish waits on its full queue, and that its queued
+// messages are released by Drain.
+func TestUnsubscribeBlocked(t *testing.T) {
+	p := NewPool(Config{Track: true})
+	h := NewHub(1)
+	s := h.Subscribe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 3 {
+			m := p.New([]byte("x"))
+			h.Publish(m)
+			m.Release()
+		}
+	}()
+	for s.Len() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	h.Unsubscribe(s)
+	<-done
+	s.Drain()
+	h.Unsubscribe(s) // a second call is harmless
+	if h.Subscribers() != 0 || p.Live() != 0 {
+		t.Errorf("%d subscribers, %d messages live after Unsubscribe and Drain", h.Subscribers(), p.Live())
+	}
+}
@@ -0,0 +1,191 @@
+// Package fanout delivers one message to many subscribers: a chat room,
+// a market data feed, a pub/sub topic. Hub queues each published message
+// to every subscriber. Msg is the message: a reference-counted payload
+// that every queue shares, so a frame decoded once is copied once, into
+// the Msg, however many subscribers it goes to.
+package fanout
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/bufpool"
+)
+
+// Msg is a payload shared by reference count. Whoever holds a reference
+// may read Payload and must call Release exactly once when done with it;
+// Retain hands out another reference. The last Release returns the
+// payload's buffer, and the Msg itself, to the Pool. Payload must not be
+// modified once the Msg is shared.
+//
+// A reference count moves the question of when a buffer is free from the
+// GC to the code, and the code can get it wrong in two ways. A Release
+// too many frees a buffer someone is still reading, and the next New
+// writes over it. A Release missed keeps the buffer out of the pool
+// forever. The Pool's Config has hooks for the first and a tracking mode
+// for the second.
+type Msg struct {
+	Payload []byte
+
+	refs atomic.Int32
+	buf  *[]byte
+	pool *Pool
+	site []uintptr // where New was called, with Config.Track
+}
+
+// Retain adds a reference and returns m.
+func (m *Msg) Retain() *Msg {
+	m.retain(1)
+	return m
+}
+
+func (m *Msg) retain(n int) {
+	if n > 0 && m.refs.Add(int32(n)) <= int32(n) {
+		m.refs.Add(-int32(n))
+		m.pool.misuse("Retain", m)
+	}
+}
+
+// Release drops a reference. The last one recycles m, which must not be
+// used afterwards.
+func (m *Msg) Release() {
+	switch n := m.refs.Add(-1); {
+	case n > 0:
+		return
+	case n < 0:
+		m.refs.Add(1)
+		m.pool.misuse("Release", m)
+		return
+	}
+	m.pool.free(m)
+}
+
+// Refs returns the number of references to m.
+func (m *Msg) Refs() int { return int(m.refs.Load()) }
+
+// Config configures a Pool. Zero values select the defaults.
+type Config struct {
+	// MinSize and MaxSize bound the payload size classes kept in the
+	// pool; they default to 64 bytes and 64KiB. Larger payloads are
+	// allocated and dropped.
+	MinSize, MaxSize int
+
+	// OnMisuse is called for a Retain or a Release on a Msg that has
+	// already been freed, with the name of the call. It defaults to a
+	// panic: the buffer may already belong to another message, so going
+	// on would deliver one message's bytes as another's.
+	OnMisuse func(op string, m *Msg)
+
+	// Track records where each Msg was created, so that Leaks can report
+	// the ones never released, and stops recycling Msg structs, so that a
+	// stale reference finds a freed Msg instead of a new one. It costs a
+	// stack walk and a map entry per New; use it in tests.
+	Track bool
+}
+
+// Pool creates Msgs and recycles them.
+type Pool struct {
+	cfg  Config
+	bufs *bufpool.Pool
+	msgs sync.Pool
+	live atomic.Int64
+
+	mu      sync.Mutex
+	tracked map[*Msg]struct{}
+}
+
+// NewPool returns a Pool configured by cfg.
+func NewPool(cfg Config) *Pool {
+	if cfg.MinSize == 0 {
+		cfg.MinSize = 64
+	}
+	if cfg.MaxSize == 0 {
+		cfg.MaxSize = 64 << 10
+	}
+	p := &Pool{cfg: cfg, bufs: bufpool.New(cfg.MinSize, cfg.MaxSize)}
+	p.msgs.New = func() any { return &Msg{pool: p} }
+	if cfg.Track {
+		p.tracked = make(map[*Msg]struct{})
+	}
+	return p
+}
+
+// New returns a Msg holding a copy of payload, with one reference.
+func (p *Pool) New(payload []byte) *Msg {
+	var m *Msg
+	if p.cfg.Track {
+		m = &Msg{pool: p}
+		var pcs [32]uintptr
+		m.site = pcs[:runtime.Callers(2, pcs[:])]
+		p.mu.Lock()
+		p.tracked[m] = struct{}{}
+		p.mu.Unlock()
+	} else {
+		m = p.msgs.Get().(*Msg)
+	}
+	m.buf = p.bufs.Get(len(payload))
+	copy(*m.buf, payload)
+	m.Payload = *m.buf
+	m.refs.Store(1)
+	p.live.Add(1)
+	return m
+}
+
+func (p *Pool) free(m *Msg) {
+	p.bufs.Put(m.buf)
+	m.buf, m.Payload = nil, nil
+	p.live.Add(-1)
+	if p.cfg.Track {
+		p.mu.Lock()
+		delete(p.tracked, m)
+		p.mu.Unlock()
+		return // never reused, so a stale reference is caught
+	}
+	p.msgs.Put(m)
+}
+
+func (p *Pool) misuse(op string, m *Msg) {
+	if p.cfg.OnMisuse != nil {
+		p.cfg.OnMisuse(op, m)
+		return
+	}
+	panic("fanout: " + op + " of a released Msg")
+}
+
+// Live returns the number of Msgs created and not yet released.
+func (p *Pool) Live() int { return int(p.live.Load()) }
+
+// A Leak is a Msg that is still live, with the stack that created it.
+type Leak struct {
+	Refs  int
+	Stack string
+}
+
+func (l Leak) String() string {
+	return fmt.Sprintf("%d references, created at\n%s", l.Refs, l.Stack)
+}
+
+// Leaks returns every live Msg with the stack that created it. It needs
+// Config.Track; without it, it returns nil. Called when all work is done,
+// as at the end of a test, everything it returns is a missed Release.
+func (p *Pool) Leaks() []Leak {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var leaks []Leak
+	for m := range p.tracked {
+		var sb strings.Builder
+		frames := runtime.CallersFrames(m.site)
+		for {
+			f, more := frames.Next()
+			fmt.Fprintf(&sb, "\t%s\n\t\t%s:%d\n", f.Function, f.File, f.Line)
+			if !more {
+				break
+			}
+		}
+		leaks = append(leaks, Leak{Refs: m.Refs(), Stack: sb.String()})
+	}
+	return leaks
+}
@@ -0,0 +1,100 @@
+package fanout
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRefcount(t *testing.T) {
+	p := NewPool(Config{})
+	m := p.New([]byte("hello"))
+	if string(m.Payload) != "hello" || m.Refs() != 1 || p.Live() != 1 {
+		t.Fatalf("new: %q, %d refs, %d live", m.Payload, m.Refs(), p.Live())
+	}
+	m.Retain().Retain()
+	m.Release()
+	m.Release()
+	if m.Refs() != 1 || p.Live() != 1 {
+		t.Fatalf("freed early: %d refs, %d live", m.Refs(), p.Live())
+	}
+	m.Release()
+	if p.Live() != 0 || m.Payload != nil {
+		t.Fatalf("not freed: %d live, payload %q", p.Live(), m.Payload)
+	}
+}
+
+// TestNewCopies checks that a Msg owns its bytes, so it can be made from
+// a read buffer that is about to be reused.
+func TestNewCopies(t *testing.T) {
+	p := NewPool(Config{})
+	in := []byte("frame")
+	m := p.New(in)
+	defer m.Release()
+	clear(in)
+	if string(m.Payload) != "frame" {
+		t.Errorf("payload %q changed with its source", m.Payload)
+	}
+}
+
+func TestMisuse(t *testing.T) {
+	var ops []string
+	p := NewPool(Config{Track: true, OnMisuse: func(op string, m *Msg) { ops = append(ops, op) }})
+	m := p.New([]byte("x"))
+	m.Release()
+	m.Release()
+	m.Retain()
+	if strings.Join(ops, ",") != "Release,Retain" {
+		t.Errorf("hooks called for %v, want Release, Retain", ops)
+	}
+	if m.Refs() != 0 || p.Live() != 0 {
+		t.Errorf("misuse changed the count: %d refs, %d live", m.Refs(), p.Live())
+	}
+}
+
+func TestMisusePanics(t *testing.T) {
+	p := NewPool(Config{})
+	m := p.New([]byte("x"))
+	m.Release()
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for a double Release")
+		}
+	}()
+	m.Release()
+}
+
+func TestLeaks(t *testing.T) {
+	p := NewPool(Config{Track: true})
+	released := p.New([]byte("a"))
+	leaked := p.New([]byte("b"))
+	leaked.Retain()
+	released.Release()
+	leaked.Release()
+
+	leaks := p.Leaks()
+	if len(leaks) != 1 {
+		t.Fatalf("%d leaks, want 1", len(leaks))
+	}
+	if leaks[0].Refs != 1 || !strings.Contains(leaks[0].Stack, "TestLeaks") {
+		t.Errorf("leak does not point at its New:\n%v", leaks[0])
+	}
+	if NewPool(Config{}).Leaks() != nil {
+		t.Error("Leaks without Track")
+	}
+}
+
+// TestRecycle checks that with Track off a steady stream of messages
+// reuses both the Msg structs and their buffers.
+func TestRecycle(t *testing.T) {
+	p := NewPool(Config{})
+	payload := make([]byte, 1000)
+	p.New(payload).Release()
+	allocs := testing.AllocsPerRun(1000, func() {
+		m := p.New(payload)
+		m.Retain().Release()
+		m.Release()
+	})
+	if allocs != 0 {
+		t.Errorf("%.1f allocations per message, want 0", allocs)
+	}
+}
@@ -0,0 +1,204 @@
+package fanout
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// publishN publishes messages "0" to "n-1".
+func publishN(p *Pool, h *Hub, n int) {
+	for i := range n {
+		m := p.New([]byte(fmt.Sprint(i)))
+		h.Publish(m)
+		m.Release()
+	}
+}
+
+// receive reads what is queued for s without blocking.
+func receive(s *Sub) []string {
+	var got []string
+	for {
+		select {
+		case m, ok := <-s.C:
+			if !ok {
+				return got
+			}
+			got = append(got, string(m.Payload))
+			m.Release()
+		default:
+			return got
+		}
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	p := NewPool(Config{Track: true})
+	h := NewHub(4)
+	s := h.SubscribeWith(SubConfig{Policy: DropOldest})
+	publishN(p, h, 10)
+	if got := fmt.Sprint(receive(s)); got != "[6 7 8 9]" {
+		t.Errorf("received %s, want the last 4", got)
+	}
+	if st := s.Stats(); st.Dropped != 6 || st.MaxDepth != 4 || st.Delivered != 10 {
+		t.Errorf("stats %+v", st)
+	}
+	h.Unsubscribe(s)
+	if leaks := p.Leaks(); len(leaks) > 0 {
+		t.Errorf("%d leaked", len(leaks))
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	p := NewPool(Config{Track: true})
+	h := NewHub(4)
+	slow := h.SubscribeWith(SubConfig{Policy: Disconnect})
+	fast := h.Subscribe()
+	for range 10 {
+		publishN(p, h, 1)
+		receive(fast)
+	}
+	if got := fmt.Sprint(receive(slow)); got != "[0 0 0 0]" {
+		t.Errorf("slow subscriber received %s before it was dropped", got)
+	}
+	if !errors.Is(slow.Err(), ErrSlow) || fast.Err() != nil {
+		t.Errorf("errors: slow %v, fast %v", slow.Err(), fast.Err())
+	}
+	if st := h.Stats(); st.Subscribers != 1 || st.Disconnected != 1 {
+		t.Errorf("hub stats %+v", st)
+	}
+	h.Unsubscribe(fast)
+	if leaks := p.Leaks(); len(leaks) > 0 {
+		t.Errorf("%d leaked", len(leaks))
+	}
+}
+
+func TestSpill(t *testing.T) {
+	dir := t.TempDir()
+	p := NewPool(Config{Track: true})
+	h := NewHub(4)
+	s := h.SubscribeWith(SubConfig{Policy: Spill, SpillDir: dir})
+	publishN(p, h, 100)
+	if st := s.Stats(); st.Spilled == 0 || st.SpillSize == 0 {
+		t.Fatalf("nothing spilled: %+v", st)
+	}
+
+	// Read slowly enough for the feeder to run dry in between, so that
+	// the file is emptied and refilled.
+	var got []string
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 100; len(got) < 200 && time.Now().Before(deadline); i++ {
+		if i < 200 {
+			m := p.New([]byte(fmt.Sprint(i)))
+			h.Publish(m)
+			m.Release()
+		}
+		select {
+		case m := <-s.C:
+			got = append(got, string(m.Payload))
+			m.Release()
+		case <-time.After(time.Second):
+		}
+	}
+	for i, g := range got {
+		if g != fmt.Sprint(i) {
+			t.Fatalf("message %d is %s", i, g)
+		}
+	}
+	if len(got) != 200 {
+		t.Fatalf("%d messages, want 200", len(got))
+	}
+	if st := s.Stats(); st.Spilled != 0 || st.SpillSize != 0 || st.Delivered != 200 {
+		t.Errorf("after catching up: %+v", st)
+	}
+
+	publishN(p, h, 20)
+	h.Unsubscribe(s)
+	s.Drain()
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Errorf("spill files left: %v", files)
+	}
+	if leaks := p.Leaks(); len(leaks) > 0 {
+		t.Errorf("%d leaked, the first:\n%v", len(leaks), leaks[0])
+	}
+}
+
+func TestSpillLimit(t *testing.T) {
+	p := NewPool(Config{Track: true})
+	h := NewHub(1)
+	s := h.SubscribeWith(SubConfig{Policy: Spill, SpillDir: t.TempDir(), SpillLimit: 100})
+	publishN(p, h, 50)
+	if !errors.Is(s.Err(), ErrSlow) || h.Subscribers() != 0 {
+		t.Fatalf("over the limit: %v, %d subscribers", s.Err(), h.Subscribers())
+	}
+	s.Drain()
+	if leaks := p.Leaks(); len(leaks) > 0 {
+		t.Errorf("%d leaked", len(leaks))
+	}
+}
+
+func TestSpillDirMissing(t *testing.T) {
+	p := NewPool(Config{})
+	h := NewHub(1)
+	s := h.SubscribeWith(SubConfig{Policy: Spill, SpillDir: filepath.Join(t.TempDir(), "missing")})
+	publishN(p, h, 3)
+	if !errors.Is(s.Err(), os.ErrNotExist) {
+		t.Errorf("err %v, want a missing directory", s.Err())
+	}
+	s.Drain()
+}
+
+// TestStalledSubscriber is the failure the policies exist for: a
+// subscriber that stops reading. With Block it stops every other
+// subscriber once its queue is full; with the others the rest are
+// served as if it were not there.
+func TestStalledSubscriber(t *testing.T) {
+	const fast, msgs, depth = 20, 200, 8
+	for _, policy := range []SlowPolicy{Block, DropOldest, Disconnect, Spill} {
+		t.Run(policy.String(), func(t *testing.T) {
+			p := NewPool(Config{})
+			h := NewHub(depth)
+			stalled := h.SubscribeWith(SubConfig{Policy: policy, SpillDir: t.TempDir()})
+			counts := make(chan int, fast)
+			for range fast {
+				s := h.Subscribe()
+				go func() {
+					n := 0
+					for m := range s.C {
+						n++
+						m.Release()
+					}
+					counts <- n
+				}()
+			}
+			published := make(chan struct{})
+			go func() {
+				publishN(p, h, msgs)
+				close(published)
+			}()
+			finished := false
+			select {
+			case <-published:
+				finished = true
+			case <-time.After(time.Second):
+			}
+			if finished == (policy == Block) {
+				t.Errorf("publishing finished while one subscriber was stalled: %v", finished)
+			}
+			h.Unsubscribe(stalled) // frees a blocked Publish
+			<-published
+			stalled.Drain()
+			for _, s := range append([]*Sub(nil), h.subs...) {
+				h.Unsubscribe(s)
+			}
+			for range fast {
+				if n := <-counts; n != msgs {
+					t.Errorf("a fast subscriber got %d of %d", n, msgs)
+				}
+			}
+		})
+	}
+}
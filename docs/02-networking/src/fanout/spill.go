@@ -0,0 +1,150 @@
+package fanout
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// spill is the file behind a Spill subscriber's queue. Once a message
+// goes to the file, every later one follows it until a feeder goroutine
+// has moved them all into the queue, so the subscriber sees them in
+// order. Records are a 4-byte length and the payload. The file is
+// created the first time the subscriber falls behind, emptied whenever
+// it catches up, and removed by Unsubscribe.
+type spill struct {
+	on atomic.Bool // new messages go to the file
+
+	mu         sync.Mutex
+	f          *os.File
+	woff, roff int64
+	n          int // records between roff and woff
+	pool       *Pool
+	wbuf       []byte
+	feeding    bool
+	stopped    bool
+	wg         sync.WaitGroup
+}
+
+func (sp *spill) active() bool { return sp.on.Load() }
+
+func (sp *spill) backlog() (int, int64) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.n, sp.woff - sp.roff
+}
+
+// write appends m's payload and starts the feeder if it is not running.
+func (sp *spill) write(s *Sub, m *Msg) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.stopped {
+		return nil
+	}
+	size := int64(4 + len(m.Payload))
+	if sp.woff+size > s.cfg.SpillLimit {
+		return fmt.Errorf("%w: spill file at its %d-byte limit", ErrSlow, s.cfg.SpillLimit)
+	}
+	if sp.f == nil {
+		f, err := os.CreateTemp(s.cfg.SpillDir, "fanout-spill-*")
+		if err != nil {
+			return err
+		}
+		sp.f = f
+	}
+	sp.wbuf = binary.BigEndian.AppendUint32(sp.wbuf[:0], uint32(len(m.Payload)))
+	sp.wbuf = append(sp.wbuf, m.Payload...)
+	if _, err := sp.f.WriteAt(sp.wbuf, sp.woff); err != nil {
+		return err
+	}
+	sp.woff += size
+	sp.n++
+	sp.pool = m.pool
+	sp.on.Store(true)
+	if !sp.feeding {
+		sp.feeding = true
+		sp.wg.Add(1)
+		go func() {
+			err := sp.feed(s)
+			sp.wg.Done()
+			if err != nil {
+				s.hub.drop(s, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// feed moves records from the file into the queue, blocking on the queue
+// as the publisher no longer has to, until the file is empty.
+func (sp *spill) feed(s *Sub) error {
+	var buf []byte
+	for {
+		sp.mu.Lock()
+		if sp.stopped {
+			sp.feeding = false
+			sp.mu.Unlock()
+			return nil
+		}
+		if sp.roff == sp.woff {
+			// Caught up: the publisher queues directly again.
+			sp.woff, sp.roff = 0, 0
+			sp.on.Store(false)
+			sp.feeding = false
+			err := sp.f.Truncate(0)
+			sp.mu.Unlock()
+			return err
+		}
+		f, off, pool := sp.f, sp.roff, sp.pool
+		sp.mu.Unlock()
+
+		// The bytes from roff to woff are only appended to, so they can be
+		// read without the lock.
+		var hdr [4]byte
+		if _, err := f.ReadAt(hdr[:], off); err != nil {
+			return err
+		}
+		n := binary.BigEndian.Uint32(hdr[:])
+		if cap(buf) < int(n) {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+		if _, err := f.ReadAt(buf, off+4); err != nil && err != io.EOF {
+			return err
+		}
+		m := pool.New(buf)
+		select {
+		case s.c <- m:
+			s.queued()
+		case <-s.done:
+			m.Release()
+			return nil
+		}
+
+		sp.mu.Lock()
+		sp.roff = off + 4 + int64(n)
+		sp.n--
+		sp.mu.Unlock()
+	}
+}
+
+// stop waits for the feeder and removes the file.
+func (sp *spill) stop() {
+	sp.mu.Lock()
+	sp.stopped = true
+	sp.mu.Unlock()
+	sp.wg.Wait()
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.f != nil {
+		sp.f.Close()
+		os.Remove(sp.f.Name())
+		sp.f = nil
+	}
+	sp.woff, sp.roff, sp.n = 0, 0, 0
+	sp.on.Store(false)
+}
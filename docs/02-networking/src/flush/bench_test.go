@@ -0,0 +1,144 @@
+package flush
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serve echoes lines from c, batching the replies by p and counting the
+// flushes. It flushes what is left when the client stops sending, so a
+// count that does not divide the messages sent still gets every reply out.
+func serve(c net.Conn, p Policy, flushes *atomic.Int64) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	w := bufio.NewWriterSize(c, 64<<10)
+	batch := NewBatcher(func() error {
+		flushes.Add(1)
+		return w.Flush()
+	})
+	defer batch.Close(nil)
+	for {
+		line, err := r.ReadSlice('\n')
+		if err != nil {
+			batch.Flush()
+			return
+		}
+		err = batch.Write(p, func() (int, error) {
+			_, err := w.Write(line)
+			return 1, err
+		})
+		if err != nil {
+			return
+		}
+	}
+}
+
+// dial starts a server for p on loopback and connects to it.
+func dial(b *testing.B, p Policy, flushes *atomic.Int64) *net.TCPConn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { ln.Close() })
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			serve(c, p, flushes)
+		}
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { c.Close() })
+	return c.(*net.TCPConn)
+}
+
+// BenchmarkPolicy echoes 64-byte lines over loopback under each flush
+// policy, for two kinds of client. An interactive client sends a line and
+// waits for its reply before the next one, as a user at a prompt or an
+// RPC caller does; a policy that waits for a count alone would never
+// answer it, so those are skipped. A bulk client keeps 64 lines in flight.
+// ns/op is the time per message, so its inverse is the throughput;
+// p50-µs and p99-µs are the round trips the client saw, and flushes/msg
+// is the write syscalls the server made per reply.
+//
+//	go test -bench Policy ./flush
+func BenchmarkPolicy(b *testing.B) {
+	const window = 64
+	policies := []Policy{
+		{},
+		{Count: 16},
+		{MaxDelay: 100 * time.Microsecond},
+		{MaxDelay: time.Millisecond},
+		{Count: 16, MaxDelay: 100 * time.Microsecond},
+		{Count: 16, MaxDelay: time.Millisecond},
+	}
+	msg := make([]byte, 64)
+	msg[len(msg)-1] = '\n'
+	report := func(b *testing.B, lat []time.Duration, flushes int64) {
+		slices.Sort(lat)
+		b.ReportMetric(float64(lat[len(lat)/2].Nanoseconds())/1e3, "p50-µs")
+		b.ReportMetric(float64(lat[len(lat)*99/100].Nanoseconds())/1e3, "p99-µs")
+		b.ReportMetric(float64(flushes)/float64(len(lat)), "flushes/msg")
+	}
+
+	for _, p := range policies {
+		if p.Count > 1 && p.MaxDelay == 0 {
+			continue
+		}
+		b.Run("interactive/"+p.String(), func(b *testing.B) {
+			var flushes atomic.Int64
+			c := dial(b, p, &flushes)
+			reply := make([]byte, len(msg))
+			var lat []time.Duration
+			for b.Loop() {
+				start := time.Now()
+				if _, err := c.Write(msg); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.ReadFull(c, reply); err != nil {
+					b.Fatal(err)
+				}
+				lat = append(lat, time.Since(start))
+			}
+			report(b, lat, flushes.Load())
+		})
+	}
+
+	for _, p := range policies {
+		b.Run("bulk/"+p.String(), func(b *testing.B) {
+			var flushes atomic.Int64
+			c := dial(b, p, &flushes)
+			// The writer queues each line's send time; the reader takes it
+			// off with the reply, and a full queue holds the writer back.
+			sent := make(chan time.Time, window)
+			b.ResetTimer()
+			go func() {
+				for range b.N {
+					sent <- time.Now()
+					if _, err := c.Write(msg); err != nil {
+						return
+					}
+				}
+				c.CloseWrite()
+			}()
+			r := bufio.NewReader(c)
+			reply := make([]byte, len(msg))
+			lat := make([]time.Duration, 0, b.N)
+			for range b.N {
+				if _, err := io.ReadFull(r, reply); err != nil {
+					b.Fatal(err)
+				}
+				lat = append(lat, time.Since(<-sent))
+			}
+			b.StopTimer()
+			report(b, lat, flushes.Load())
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Package flush decides when a connection's buffered replies are written.
+//
+// Writing every reply as it is produced costs a syscall per message.
+// Holding replies back and writing them together saves most of those, but
+// only a client that keeps sending refills the batch: a client that sends
+// one request and waits for its reply waits forever behind a batch that
+// never fills. A Policy bounds the batch by count, by the time its oldest
+// reply has waited, or by both, and a Batcher applies one to a connection.
+//
+// BenchmarkPolicy in this package measures what each policy costs
+// interactive and bulk clients.
+package flush
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Policy says when buffered replies are flushed: once Count of them are
+// buffered, or once the oldest has waited MaxDelay. Either may be zero to
+// flush by the other alone; the zero Policy flushes every reply. A Policy
+// with only a Count holds back a partial batch until the client sends
+// enough to fill it.
+type Policy struct {
+	Count    int
+	MaxDelay time.Duration
+}
+
+func (p Policy) String() string {
+	switch {
+	case p.Count <= 0 && p.MaxDelay <= 0:
+		return "each"
+	case p.MaxDelay <= 0:
+		return fmt.Sprintf("count=%d", p.Count)
+	case p.Count <= 0:
+		return fmt.Sprintf("delay=%v", p.MaxDelay)
+	}
+	return fmt.Sprintf("count=%d+delay=%v", p.Count, p.MaxDelay)
+}
+
+// due reports whether a batch of n replies should be flushed now.
+func (p Policy) due(n int) bool {
+	if p.Count > 0 {
+		return n >= p.Count
+	}
+	return p.MaxDelay <= 0
+}
+
+// Batcher applies a Policy to one connection's output buffer. The delay
+// flushes from a timer goroutine, so the buffer must only be written
+// through Write, which holds the Batcher's lock.
+type Batcher struct {
+	flush func() error
+
+	mu    sync.Mutex
+	n     int   // replies buffered since the last flush
+	err   error // from a timed flush, for the next Write or Flush
+	timer *time.Timer
+}
+
+// NewBatcher returns a Batcher that writes out the buffer with flush.
+func NewBatcher(flush func() error) *Batcher {
+	return &Batcher{flush: flush}
+}
+
+// Write calls write to buffer replies; write returns how many it
+// buffered. Then it flushes if p says the batch is due, or starts p's
+// delay if these are the first replies of a batch. The policy is passed
+// on every call, so a server can change it while connections are open.
+func (b *Batcher) Write(p Policy, write func() (int, error)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	idle := b.n == 0
+	n, err := write()
+	b.n += n
+	if err != nil || b.n == 0 {
+		return err
+	}
+	if p.due(b.n) {
+		return b.flushLocked()
+	}
+	if p.MaxDelay > 0 && idle {
+		if b.timer == nil {
+			b.timer = time.AfterFunc(p.MaxDelay, b.flushLate)
+		} else {
+			b.timer.Reset(p.MaxDelay)
+		}
+	}
+	return nil
+}
+
+// Flush writes out whatever is buffered.
+func (b *Batcher) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	if b.n == 0 {
+		return nil
+	}
+	return b.flushLocked()
+}
+
+// flushLate is the delay's flush. A timer that belonged to a batch that
+// has since been flushed by count may fire early for the next one, never
+// late.
+func (b *Batcher) flushLate() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.n > 0 && b.err == nil {
+		b.err = b.flushLocked()
+	}
+}
+
+func (b *Batcher) flushLocked() error {
+	b.n = 0
+	return b.flush()
+}
+
+// Close stops the delay and runs release, which may free the buffer,
+// under the lock. A timed flush that has already fired and is waiting for
+// the lock finds nothing to flush.
+func (b *Batcher) Close(release func()) {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.n = 0
+	if release != nil {
+		release()
+	}
+}
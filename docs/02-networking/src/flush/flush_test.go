@@ -0,0 +1,105 @@
+package flush
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// counter is a Batcher's output: flush moves what was buffered to sent.
+type counter struct {
+	mu             sync.Mutex
+	buffered, sent int
+	flushes        int
+	err            error
+}
+
+func (c *counter) flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent += c.buffered
+	c.buffered = 0
+	c.flushes++
+	return c.err
+}
+
+func (c *counter) write(n int) func() (int, error) {
+	return func() (int, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.buffered += n
+		return n, nil
+	}
+}
+
+func (c *counter) load() (sent, flushes int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sent, c.flushes
+}
+
+func TestBatcher(t *testing.T) {
+	for _, tc := range []struct {
+		p       Policy
+		writes  []int
+		sent    int // right after the writes
+		flushes int
+		later   int // after MaxDelay
+	}{
+		{Policy{}, []int{1, 1, 3}, 5, 3, 5},
+		{Policy{Count: 4}, []int{1, 1, 1}, 0, 0, 0},
+		{Policy{Count: 4}, []int{1, 3, 1, 2}, 4, 1, 4},
+		{Policy{MaxDelay: 5 * time.Millisecond}, []int{1, 1}, 0, 0, 2},
+		{Policy{Count: 4, MaxDelay: 5 * time.Millisecond}, []int{3, 2, 1}, 5, 1, 6},
+	} {
+		var c counter
+		b := NewBatcher(c.flush)
+		for _, n := range tc.writes {
+			if err := b.Write(tc.p, c.write(n)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if sent, flushes := c.load(); sent != tc.sent || flushes != tc.flushes {
+			t.Errorf("%v: sent %d in %d flushes, want %d in %d", tc.p, sent, flushes, tc.sent, tc.flushes)
+		}
+		deadline := time.Now().Add(time.Second)
+		for sent, _ := c.load(); sent != tc.later && time.Now().Before(deadline); sent, _ = c.load() {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(2 * tc.p.MaxDelay)
+		if sent, _ := c.load(); sent != tc.later {
+			t.Errorf("%v: sent %d after the delay, want %d", tc.p, sent, tc.later)
+		}
+		b.Close(nil)
+	}
+}
+
+func TestBatcherErrors(t *testing.T) {
+	// A timed flush that fails fails the next Write.
+	boom := errors.New("boom")
+	c := counter{err: boom}
+	b := NewBatcher(c.flush)
+	p := Policy{MaxDelay: time.Millisecond}
+	if err := b.Write(p, c.write(1)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for _, f := c.load(); f == 0 && time.Now().Before(deadline); _, f = c.load() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := b.Write(p, c.write(1)); err != boom {
+		t.Errorf("Write after a failed timed flush: %v, want %v", err, boom)
+	}
+
+	// After Close, a pending delay flushes nothing.
+	var d counter
+	b = NewBatcher(d.flush)
+	b.Write(Policy{MaxDelay: time.Millisecond}, d.write(1))
+	released := false
+	b.Close(func() { released = true })
+	time.Sleep(5 * time.Millisecond)
+	if _, f := d.load(); f != 0 || !released {
+		t.Errorf("%d flushes after Close, released %v; want 0 and true", f, released)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseGCTrace(t *testing.T) {
+	ev, ok := parseGCTrace("gc 87 @1.069s 17%: 0.007+0.13+0.002 ms clock, 0.007+0/0.019/0.10+0.002 ms cpu, 3->2->1 MB, 4 MB goal, 0 MB stacks, 0 MB globals, 1 P (forced)")
+	if !ok {
+		t.Fatal("gctrace line not recognised")
+	}
+	want := gcEvent{Cycle: 87, Since: 1069 * time.Millisecond, SweepTerm: 7 * time.Microsecond,
+		Mark: 130 * time.Microsecond, MarkTerm: 2 * time.Microsecond, HeapStart: 3, HeapLive: 1, Forced: true}
+	if ev != want {
+		t.Errorf("got %+v, want %+v", ev, want)
+	}
+	for _, line := range []string{"Echo server listening on :9000", "gc 1 @0.012s 1%: garbled"} {
+		if _, ok := parseGCTrace(line); ok {
+			t.Errorf("%q parsed as a GC cycle", line)
+		}
+	}
+}
+
+func TestPlace(t *testing.T) {
+	origin := time.Unix(1000, 0)
+	ev := func(since, late time.Duration) gcEvent {
+		e := gcEvent{Since: since, SweepTerm: 100 * time.Microsecond, Mark: 2 * time.Millisecond, MarkTerm: 100 * time.Microsecond}
+		e.Read = origin.Add(since + 2200*time.Microsecond + late)
+		return e
+	}
+	// The second line was read promptly and sets the origin; the others
+	// were read late but are placed by their @ times all the same.
+	cycles := place([]gcEvent{ev(time.Second, 5*time.Millisecond), ev(2*time.Second, 0), ev(3*time.Second, 40*time.Millisecond)})
+	for i, c := range cycles {
+		if start := origin.Add(time.Duration(i+1) * time.Second); !c.Start.Equal(start) {
+			t.Errorf("cycle %d starts %v after the origin, want %v", i, c.Start.Sub(origin), start.Sub(origin))
+		}
+		if d := c.End.Sub(c.Start); d != 2200*time.Microsecond {
+			t.Errorf("cycle %d lasts %v", i, d)
+		}
+	}
+}
+
+// TestAttribute runs a millisecond request every 10ms for ten seconds,
+// with a 30ms cycle every second. A request in flight as a cycle starts
+// takes 50ms; those that start during the cycle take 5ms.
+func TestAttribute(t *testing.T) {
+	base := time.Unix(1000, 0)
+	var cycles []gcCycle
+	for s := range 10 {
+		start := base.Add(time.Duration(s)*time.Second + 500500*time.Microsecond)
+		cycles = append(cycles, gcCycle{Start: start, End: start.Add(30 * time.Millisecond),
+			SweepTerm: time.Millisecond, MarkTerm: time.Millisecond})
+	}
+	var reqs []request
+	for i := range 1000 {
+		r := request{Start: base.Add(time.Duration(i) * 10 * time.Millisecond), RTT: time.Millisecond}
+		for _, c := range cycles {
+			switch {
+			case r.Start.Before(c.Start) && r.end().After(c.Start):
+				r.RTT = 50 * time.Millisecond
+			case r.Start.After(c.Start) && r.Start.Before(c.End):
+				r.RTT = 5 * time.Millisecond
+			}
+		}
+		reqs = append(reqs, r)
+	}
+
+	a := attribute(reqs, cycles, 0)
+	if a.cycles != 10 || a.gcTime != 300*time.Millisecond || a.stwTime != 20*time.Millisecond {
+		t.Errorf("%d cycles, %v of GC and %v stopped; want 10, 300ms and 20ms", a.cycles, a.gcTime, a.stwTime)
+	}
+	// Per cycle, the request caught by its start and the one still running
+	// at its end overlap a pause; the two in between only the mark.
+	for k, want := range []group{
+		noGC:   {n: 960, p50: time.Millisecond, p99: time.Millisecond, p999: time.Millisecond, max: time.Millisecond},
+		inMark: {n: 20, p50: 5 * time.Millisecond, p99: 5 * time.Millisecond, p999: 5 * time.Millisecond, max: 5 * time.Millisecond},
+		inSTW:  {n: 20, p50: 50 * time.Millisecond, p99: 50 * time.Millisecond, p999: 50 * time.Millisecond, max: 50 * time.Millisecond},
+	} {
+		if a.by[k] != want {
+			t.Errorf("%s: %+v, want %+v", overlapNames[k], a.by[k], want)
+		}
+	}
+	if a.tail != 10 || a.tailGC != 10 || a.gcShare != 0.04 {
+		t.Errorf("%d of the %d slowest overlapped GC, and %.3f of all; want 10 of 10 and 0.04", a.tailGC, a.tail, a.gcShare)
+	}
+	if a.all.p999 != 50*time.Millisecond || a.attributable != 0.98 {
+		t.Errorf("p99.9 %v, %.2f attributable to GC; want 50ms and 0.98", a.all.p999, a.attributable)
+	}
+}
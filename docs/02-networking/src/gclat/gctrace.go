@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gcLine matches the runtime's GODEBUG=gctrace=1 summary of one cycle:
+//
+//	gc 7 @1.052s 2%: 0.018+1.2+0.031 ms clock, 0.036+0.4/1.1/0+0.062 ms cpu, 4->4->1 MB, 4 MB goal, 0 MB stacks, 0 MB globals, 2 P
+//
+// @ is when the cycle started, counted from the start of the runtime, to
+// the millisecond. The three clock times are the stop-the-world sweep
+// termination, the concurrent mark and the stop-the-world mark
+// termination. The heap sizes are at the start of the cycle, at its end
+// and what it found live.
+var gcLine = regexp.MustCompile(`^gc (\d+) @([\d.]+)s \d+%: ([\d.]+)\+([\d.]+)\+([\d.]+) ms clock, .* ms cpu, (\d+)->\d+->(\d+) MB`)
+
+// gcEvent is one gctrace line, with the time it was read.
+type gcEvent struct {
+	Read      time.Time
+	Cycle     int
+	Since     time.Duration // cycle start since the runtime started
+	SweepTerm time.Duration
+	Mark      time.Duration
+	MarkTerm  time.Duration
+	HeapStart int // MB
+	HeapLive  int // MB
+	Forced    bool
+}
+
+// parseGCTrace parses a gctrace line. ok is false for any other line the
+// server writes to stderr.
+func parseGCTrace(line string) (ev gcEvent, ok bool) {
+	m := gcLine.FindStringSubmatch(line)
+	if m == nil {
+		return gcEvent{}, false
+	}
+	ms := func(s string) time.Duration {
+		f, _ := strconv.ParseFloat(s, 64) // the pattern only matches numbers
+		return time.Duration(f * float64(time.Millisecond))
+	}
+	ev.Cycle, _ = strconv.Atoi(m[1])
+	ev.Since = ms(m[2]) * 1000
+	ev.SweepTerm, ev.Mark, ev.MarkTerm = ms(m[3]), ms(m[4]), ms(m[5])
+	ev.HeapStart, _ = strconv.Atoi(m[6])
+	ev.HeapLive, _ = strconv.Atoi(m[7])
+	ev.Forced = strings.HasSuffix(strings.TrimSpace(line), "(forced)")
+	return ev, true
+}
+
+const gcHeader = "read_unix_us,cycle,start_ms,sweep_term_us,mark_us,mark_term_us,heap_start_mb,heap_live_mb,forced"
+
+// csv formats ev as a row under gcHeader.
+func (ev gcEvent) csv() string {
+	return fmt.Sprintf("%d,%d,%d,%d,%d,%d,%d,%d,%t", ev.Read.UnixMicro(), ev.Cycle, ev.Since.Milliseconds(),
+		ev.SweepTerm.Microseconds(), ev.Mark.Microseconds(), ev.MarkTerm.Microseconds(),
+		ev.HeapStart, ev.HeapLive, ev.Forced)
+}
+
+// gcCycle is a GC cycle placed on the wall clock.
+type gcCycle struct {
+	Start, End          time.Time
+	SweepTerm, MarkTerm time.Duration // the stopped-the-world phases at each end
+	Forced              bool
+}
+
+// place puts events on the wall clock. A line is read at some point after
+// its cycle ended, a little later when the machine is busy, so read minus
+// the cycle's start and length is the runtime's start time plus a delay
+// for every line. The smallest of those, from the line read most promptly,
+// is taken as the runtime's start, and every cycle is placed from its @
+// time. That is accurate to the millisecond @ is printed with, where the
+// read times alone can be late by as long as the server keeps the CPU.
+func place(events []gcEvent) []gcCycle {
+	if len(events) == 0 {
+		return nil
+	}
+	length := func(ev gcEvent) time.Duration { return ev.SweepTerm + ev.Mark + ev.MarkTerm }
+	origin := events[0].Read.Add(-events[0].Since - length(events[0]))
+	for _, ev := range events[1:] {
+		if o := ev.Read.Add(-ev.Since - length(ev)); o.Before(origin) {
+			origin = o
+		}
+	}
+	out := make([]gcCycle, len(events))
+	for i, ev := range events {
+		start := origin.Add(ev.Since)
+		out[i] = gcCycle{Start: start, End: start.Add(length(ev)), SweepTerm: ev.SweepTerm, MarkTerm: ev.MarkTerm, Forced: ev.Forced}
+	}
+	return out
+}
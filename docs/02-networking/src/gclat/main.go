@@ -0,0 +1,145 @@
+// Command gclat works out how much of a server's tail latency is the
+// garbage collector's, by lining up its GC cycles with the round trips
+// loadgen measured.
+//
+// It works with any server in this directory, unchanged. Record mode runs
+// the server with GODEBUG=gctrace=1, passes its output through and writes
+// each cycle the runtime reports to a CSV file. Build the server first:
+// under go run, the go command would report its own cycles as well.
+//
+//	go build -o /tmp/echo-net echo-net.go
+//	go run ./gclat -record gc.csv -- /tmp/echo-net
+//	go run ./loadgen -conns 1000 -interval 10ms -latency-log lat.csv
+//
+// Stop the server with Ctrl-C or by signalling gclat, then merge both:
+//
+//	go run ./gclat -gc gc.csv -latency lat.csv
+//
+// The report splits the requests by the worst GC phase each one was in
+// flight for: none, the concurrent mark only, or a stop-the-world pause.
+// It gives the latency percentiles of each, how many of the slowest 0.1%
+// overlapped a cycle against how many of all requests did, and the share
+// of p99.9 that goes away when the requests that overlapped a cycle are
+// left out.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	recordF  = flag.String("record", "", "Run the command after -- with gctrace on and write its GC cycles to this CSV file")
+	gcF      = flag.String("gc", "", "GC cycle CSV written by -record; switches to report mode")
+	latencyF = flag.String("latency", "", "Round trip CSV written by loadgen -latency-log")
+	slack    = flag.Duration("slack", time.Millisecond, "Widen each GC cycle by this much on both sides when matching requests")
+)
+
+// record runs args with gctrace on until it exits, copying its stdout and
+// the rest of its stderr through, and writes the cycles to path as they
+// are reported, so a run that is killed keeps what it had.
+func record(path string, args []string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, gcHeader)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	// Later GODEBUG settings override earlier ones.
+	godebug := "gctrace=1"
+	if v := os.Getenv("GODEBUG"); v != "" {
+		godebug = v + "," + godebug
+	}
+	cmd.Env = append(os.Environ(), "GODEBUG="+godebug)
+	cmd.Stdin, cmd.Stdout = os.Stdin, os.Stdout
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// Ctrl-C reaches the server too, as part of the terminal's process
+	// group. Catch it so gclat outlives the server and writes its last
+	// cycles; pass a SIGTERM on, since only gclat got it.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for s := range sigs {
+			if s != os.Interrupt {
+				cmd.Process.Signal(s)
+			}
+		}
+	}()
+
+	n := 0
+	sc := bufio.NewScanner(stderr)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		ev, ok := parseGCTrace(sc.Text())
+		if !ok {
+			fmt.Fprintln(os.Stderr, sc.Text())
+			continue
+		}
+		ev.Read = time.Now()
+		fmt.Fprintln(w, ev.csv())
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		n++
+	}
+	err = cmd.Wait()
+	log.Printf("%d GC cycles written to %s (%v)", n, path, cmd.ProcessState)
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return nil // the server's own business, reported above
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func main() {
+	flag.Parse()
+
+	switch {
+	case *recordF != "":
+		if flag.NArg() == 0 {
+			log.Fatal("-record needs a command to run after --")
+		}
+		if err := record(*recordF, flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+	case *gcF != "":
+		if *latencyF == "" {
+			log.Fatal("-gc needs -latency")
+		}
+		events, err := readGCCSV(*gcF)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reqs, err := readLatencyCSV(*latencyF)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(reqs) == 0 {
+			log.Fatalf("%s: no requests", *latencyF)
+		}
+		attribute(reqs, place(events), *slack).print(os.Stdout)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// request is one row of loadgen -latency-log output.
+type request struct {
+	Start time.Time
+	RTT   time.Duration
+}
+
+func (r request) end() time.Time { return r.Start.Add(r.RTT) }
+
+func readCSV(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	return r.ReadAll()
+}
+
+func parseMicros(s string) (int64, error) { return strconv.ParseInt(s, 10, 64) }
+
+func readLatencyCSV(path string) ([]request, error) {
+	rows, err := readCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || rows[0][0] != "unix_us" {
+		return nil, fmt.Errorf("%s: no loadgen -latency-log header", path)
+	}
+	out := make([]request, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		if len(row) < 2 {
+			return nil, fmt.Errorf("%s: short row %v", path, row)
+		}
+		start, err := parseMicros(row[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		rtt, err := parseMicros(row[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		out = append(out, request{Start: time.UnixMicro(start), RTT: time.Duration(rtt) * time.Microsecond})
+	}
+	return out, nil
+}
+
+func readGCCSV(path string) ([]gcEvent, error) {
+	rows, err := readCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || rows[0][0] != "read_unix_us" {
+		return nil, fmt.Errorf("%s: no gclat -record header", path)
+	}
+	out := make([]gcEvent, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		if len(row) < 9 {
+			return nil, fmt.Errorf("%s: short row %v", path, row)
+		}
+		var v [8]int64
+		for i := range v {
+			if v[i], err = parseMicros(row[i]); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+		out = append(out, gcEvent{
+			Read:      time.UnixMicro(v[0]),
+			Cycle:     int(v[1]),
+			Since:     time.Duration(v[2]) * time.Millisecond,
+			SweepTerm: time.Duration(v[3]) * time.Microsecond,
+			Mark:      time.Duration(v[4]) * time.Microsecond,
+			MarkTerm:  time.Duration(v[5]) * time.Microsecond,
+			HeapStart: int(v[6]),
+			HeapLive:  int(v[7]),
+			Forced:    row[8] == "true",
+		})
+	}
+	return out, nil
+}
+
+// overlap is how a request relates to the GC cycles it ran beside.
+type overlap int
+
+const (
+	noGC   overlap = iota // no cycle ran while it was in flight
+	inMark                // it overlapped the concurrent mark only
+	inSTW                 // it overlapped a stop-the-world phase
+)
+
+var overlapNames = [...]string{"outside GC", "during mark", "during STW"}
+
+// classify reports for each request the worst GC phase it overlapped.
+// Each cycle is widened by slack on both sides: a pause also delays the
+// requests that queue behind it, and the placement of the cycles is only
+// good to a millisecond or so.
+func classify(reqs []request, cycles []gcCycle, slack time.Duration) []overlap {
+	cycles = slices.Clone(cycles)
+	slices.SortFunc(cycles, func(a, b gcCycle) int { return a.Start.Compare(b.Start) })
+	hits := func(r request, from, to time.Time) bool {
+		return r.Start.Before(to.Add(slack)) && r.end().After(from.Add(-slack))
+	}
+	out := make([]overlap, len(reqs))
+	for i, r := range reqs {
+		// Cycles do not overlap each other, so their ends are sorted too.
+		j, _ := slices.BinarySearchFunc(cycles, r.Start, func(c gcCycle, t time.Time) int {
+			return c.End.Add(slack).Compare(t)
+		})
+		for ; j < len(cycles) && cycles[j].Start.Add(-slack).Before(r.end()); j++ {
+			c := cycles[j]
+			if !hits(r, c.Start, c.End) {
+				continue
+			}
+			out[i] = max(out[i], inMark)
+			if hits(r, c.Start, c.Start.Add(c.SweepTerm)) || hits(r, c.End.Add(-c.MarkTerm), c.End) {
+				out[i] = inSTW
+			}
+		}
+	}
+	return out
+}
+
+// group is the latency of the requests that overlapped GC in one way.
+type group struct {
+	n                   int
+	p50, p99, p999, max time.Duration
+}
+
+func summarize(lat []time.Duration) group {
+	if len(lat) == 0 {
+		return group{}
+	}
+	slices.Sort(lat)
+	q := func(p float64) time.Duration { return lat[min(int(p*float64(len(lat))), len(lat)-1)] }
+	return group{n: len(lat), p50: q(0.5), p99: q(0.99), p999: q(0.999), max: lat[len(lat)-1]}
+}
+
+// attribution is what gclat reports.
+type attribution struct {
+	span         time.Duration // from the first request's start to the last one's end
+	cycles       int           // that ran within span
+	gcTime       time.Duration // GC running, within span
+	stwTime      time.Duration // the world stopped, within span
+	all          group
+	by           [3]group
+	tail         int     // requests at or above all.p999
+	tailGC       int     // of them, those that overlapped a cycle
+	gcShare      float64 // of all requests, those that overlapped a cycle
+	attributable float64 // share of all.p999 that goes away without GC
+}
+
+func attribute(reqs []request, cycles []gcCycle, slack time.Duration) attribution {
+	var a attribution
+	if len(reqs) == 0 {
+		return a
+	}
+	first, last := reqs[0].Start, reqs[0].end()
+	for _, r := range reqs {
+		if r.Start.Before(first) {
+			first = r.Start
+		}
+		if r.end().After(last) {
+			last = r.end()
+		}
+	}
+	a.span = last.Sub(first)
+	clip := func(from, to time.Time) time.Duration {
+		if from.Before(first) {
+			from = first
+		}
+		if to.After(last) {
+			to = last
+		}
+		return max(to.Sub(from), 0)
+	}
+	for _, c := range cycles {
+		if d := clip(c.Start, c.End); d > 0 {
+			a.cycles++
+			a.gcTime += d
+			a.stwTime += clip(c.Start, c.Start.Add(c.SweepTerm)) + clip(c.End.Add(-c.MarkTerm), c.End)
+		}
+	}
+
+	kinds := classify(reqs, cycles, slack)
+	all := make([]time.Duration, len(reqs))
+	var by [3][]time.Duration
+	for i, r := range reqs {
+		all[i] = r.RTT
+		by[kinds[i]] = append(by[kinds[i]], r.RTT)
+	}
+	a.all = summarize(all)
+	for k := range by {
+		a.by[k] = summarize(by[k])
+	}
+	clean := a.by[noGC]
+	a.gcShare = float64(len(reqs)-clean.n) / float64(len(reqs))
+	for i, r := range reqs {
+		if r.RTT >= a.all.p999 {
+			a.tail++
+			if kinds[i] != noGC {
+				a.tailGC++
+			}
+		}
+	}
+	if a.all.p999 > 0 && clean.n > 0 {
+		a.attributable = max(float64(a.all.p999-clean.p999)/float64(a.all.p999), 0)
+	}
+	return a
+}
+
+func (a attribution) print(w io.Writer) {
+	pct := func(part, whole time.Duration) float64 { return 100 * part.Seconds() / whole.Seconds() }
+	fmt.Fprintf(w, "%d requests over %v, %d GC cycles: GC running %.1f%% of the time, world stopped %.3f%%\n\n",
+		a.all.n, a.span.Round(time.Millisecond), a.cycles, pct(a.gcTime, a.span), pct(a.stwTime, a.span))
+	fmt.Fprintf(w, "%-12s %9s %7s %10s %10s %10s %10s\n", "requests", "n", "share", "p50", "p99", "p99.9", "max")
+	line := func(name string, g group) {
+		fmt.Fprintf(w, "%-12s %9d %6.2f%% %10v %10v %10v %10v\n", name, g.n, 100*float64(g.n)/float64(a.all.n),
+			g.p50, g.p99, g.p999, g.max)
+	}
+	line("all", a.all)
+	for k, g := range a.by {
+		line(overlapNames[k], g)
+	}
+	fmt.Fprintln(w)
+	if a.tail > 0 {
+		fmt.Fprintf(w, "slowest 0.1%% (%d requests): %.0f%% overlapped a GC cycle, against %.1f%% of all requests\n",
+			a.tail, 100*float64(a.tailGC)/float64(a.tail), 100*a.gcShare)
+	}
+	fmt.Fprintf(w, "p99.9 is %v, %v outside GC: %.0f%% of it is attributable to GC\n",
+		a.all.p999, a.by[noGC].p999, 100*a.attributable)
+}
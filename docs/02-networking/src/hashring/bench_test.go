@@ -0,0 +1,53 @@
+package hashring
+
+import (
+	"fmt"
+	"testing"
+)
+
+var sinkString string
+
+// BenchmarkPick picks a node for 8-byte keys, cycling through 1024 of
+// them, the per-datagram cost in a load balancer. A ring's search grows
+// with the log of its points; rendezvous scores every node.
+func BenchmarkPick(b *testing.B) {
+	keys := cids(1024)
+	for _, n := range []int{3, 10, 100, 1000} {
+		nodes := backends(n)
+		for _, bl := range builders {
+			p := bl.new(nodes)
+			b.Run(fmt.Sprintf("n=%d/%s", n, bl.name), func(b *testing.B) {
+				i := 0
+				for b.Loop() {
+					sinkString = p.Pick(keys[i&1023])
+					i++
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkChange rebuilds a picker after one of n nodes is removed, and
+// reports the share of keys that moved and the busiest node's load
+// relative to the mean after the change.
+func BenchmarkChange(b *testing.B) {
+	keys := cids(100_000)
+	for _, n := range []int{10, 100} {
+		nodes := backends(n)
+		for _, bl := range builders {
+			b.Run(fmt.Sprintf("n=%d/%s", n, bl.name), func(b *testing.B) {
+				before := bl.new(nodes)
+				var after Picker
+				for b.Loop() {
+					after = bl.new(nodes[1:])
+				}
+				worst := 0
+				for _, v := range counts(after, keys) {
+					worst = max(worst, v)
+				}
+				b.ReportMetric(100*moved(before, after, keys), "moved-%")
+				b.ReportMetric(float64(worst)*float64(n-1)/float64(len(keys)), "max/mean")
+			})
+		}
+	}
+}
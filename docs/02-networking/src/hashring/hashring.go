@@ -0,0 +1,192 @@
+// Package hashring picks a backend for a key, such as a client address or
+// a QUIC connection ID, so that every packet of a flow goes to the same
+// backend without a table of flows. Three pickers are compared:
+//
+//   - Modulo, hash(key) % n, the baseline: cheap, but adding or removing a
+//     backend moves almost every key.
+//   - Ring, consistent hashing: each backend owns many points on a circle
+//     and a key goes to the next point after its hash. A change moves only
+//     the keys of the arcs that changed hands, about 1/n of them.
+//   - Rendezvous (highest random weight): every backend scores the key
+//     and the highest score wins. It moves the same 1/n with no table to
+//     build, and pays one hash per backend on every lookup.
+//
+// The hash is fixed, not seeded per process as hash/maphash is, so that
+// every load balancer in front of the same backends picks the same one for
+// a key. Pickers are immutable: to change the backends, build a new one and
+// swap it in.
+package hashring
+
+import (
+	"slices"
+	"strconv"
+)
+
+// Picker maps a key to one of its nodes.
+type Picker interface {
+	// Pick returns the node for key, or "" if there are no nodes.
+	Pick(key []byte) string
+	// Nodes returns the nodes in the order they were given.
+	Nodes() []string
+}
+
+// Hash is 64-bit FNV-1a followed by the murmur3 finalizer. FNV alone
+// leaves the high bits of short keys poorly mixed, and the ring and
+// rendezvous orders depend on all of them.
+func Hash(key []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range key {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return mix(h)
+}
+
+func hashString(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := range len(s) {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return mix(h)
+}
+
+// mix is the murmur3 64-bit finalizer.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Modulo picks nodes[Hash(key) % len(nodes)].
+type Modulo struct {
+	nodes []string
+}
+
+// NewModulo returns a Modulo over nodes.
+func NewModulo(nodes []string) *Modulo {
+	return &Modulo{nodes: slices.Clone(nodes)}
+}
+
+func (m *Modulo) Pick(key []byte) string {
+	if len(m.nodes) == 0 {
+		return ""
+	}
+	return m.nodes[Hash(key)%uint64(len(m.nodes))]
+}
+
+func (m *Modulo) Nodes() []string { return m.nodes }
+
+// Ring is a consistent hash ring. Each node is placed at vnodes points;
+// more points spread the keys more evenly at the cost of a larger table
+// and a longer search.
+type Ring struct {
+	nodes  []string
+	points []uint64 // sorted
+	owners []int32  // owners[i] is the node at points[i]
+}
+
+// DefaultVnodes keeps the busiest node within about 10% of the average
+// load with 10 nodes, and 20% with 50 to 100.
+const DefaultVnodes = 160
+
+// NewRing returns a Ring over nodes with vnodes points each; vnodes <= 0
+// means DefaultVnodes. Node names must be distinct.
+func NewRing(nodes []string, vnodes int) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVnodes
+	}
+	type point struct {
+		h     uint64
+		owner int32
+	}
+	pts := make([]point, 0, len(nodes)*vnodes)
+	var buf []byte
+	for i, n := range nodes {
+		for v := range vnodes {
+			buf = strconv.AppendInt(append(append(buf[:0], n...), '#'), int64(v), 10)
+			pts = append(pts, point{Hash(buf), int32(i)})
+		}
+	}
+	// Ties are broken by node order, so the ring does not depend on the
+	// sort's stability.
+	slices.SortFunc(pts, func(a, b point) int {
+		if a.h != b.h {
+			if a.h < b.h {
+				return -1
+			}
+			return 1
+		}
+		return int(a.owner - b.owner)
+	})
+	r := &Ring{nodes: slices.Clone(nodes), points: make([]uint64, len(pts)), owners: make([]int32, len(pts))}
+	for i, p := range pts {
+		r.points[i], r.owners[i] = p.h, p.owner
+	}
+	return r
+}
+
+func (r *Ring) Pick(key []byte) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := Hash(key)
+	// The first point at or after h, wrapping past the top of the circle.
+	// An open-coded search: slices.BinarySearch is no faster and its
+	// generic comparison does not inline.
+	lo, hi := 0, len(r.points)
+	for lo < hi {
+		m := int(uint(lo+hi) >> 1)
+		if r.points[m] < h {
+			lo = m + 1
+		} else {
+			hi = m
+		}
+	}
+	if lo == len(r.points) {
+		lo = 0
+	}
+	return r.nodes[r.owners[lo]]
+}
+
+func (r *Ring) Nodes() []string { return r.nodes }
+
+// Rendezvous is highest-random-weight hashing: the node whose hash,
+// combined with the key's, is largest wins. Removing a node moves only
+// the keys it won, each to its runner-up.
+type Rendezvous struct {
+	nodes  []string
+	hashes []uint64
+}
+
+// NewRendezvous returns a Rendezvous over nodes. Node names must be
+// distinct.
+func NewRendezvous(nodes []string) *Rendezvous {
+	r := &Rendezvous{nodes: slices.Clone(nodes), hashes: make([]uint64, len(nodes))}
+	for i, n := range nodes {
+		r.hashes[i] = hashString(n)
+	}
+	return r
+}
+
+func (r *Rendezvous) Pick(key []byte) string {
+	if len(r.nodes) == 0 {
+		return ""
+	}
+	// The key is hashed once; each node's score is that hash mixed with
+	// the node's own, which is as good as hashing the pair and much
+	// cheaper for long keys.
+	h := Hash(key)
+	best, bestScore := 0, uint64(0)
+	for i, nh := range r.hashes {
+		if s := mix(h ^ nh); s > bestScore || i == 0 {
+			best, bestScore = i, s
+		}
+	}
+	return r.nodes[best]
+}
+
+func (r *Rendezvous) Nodes() []string { return r.nodes }
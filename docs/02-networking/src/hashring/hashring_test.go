@@ -0,0 +1,138 @@
+package hashring
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/workload"
+)
+
+// backends returns n names shaped like backend addresses.
+func backends(n int) []string {
+	b := make([]string, n)
+	for i := range b {
+		b[i] = fmt.Sprintf("10.0.%d.%d:4433", i/250, i%250+1)
+	}
+	return b
+}
+
+// cids returns n random 8-byte keys, the length of a typical QUIC
+// connection ID.
+func cids(n int) [][]byte {
+	r := workload.Rand(1, 0)
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = make([]byte, 8)
+		for j := range keys[i] {
+			keys[i][j] = byte(r.Uint32())
+		}
+	}
+	return keys
+}
+
+type builder struct {
+	name string
+	new  func([]string) Picker
+}
+
+var builders = []builder{
+	{"modulo", func(n []string) Picker { return NewModulo(n) }},
+	{"ring", func(n []string) Picker { return NewRing(n, 0) }},
+	{"rendezvous", func(n []string) Picker { return NewRendezvous(n) }},
+}
+
+// counts returns how many keys each node gets.
+func counts(p Picker, keys [][]byte) map[string]int {
+	c := map[string]int{}
+	for _, k := range keys {
+		c[p.Pick(k)]++
+	}
+	return c
+}
+
+// moved returns the fraction of keys that a and b send to different nodes.
+func moved(a, b Picker, keys [][]byte) float64 {
+	n := 0
+	for _, k := range keys {
+		if a.Pick(k) != b.Pick(k) {
+			n++
+		}
+	}
+	return float64(n) / float64(len(keys))
+}
+
+func TestPick(t *testing.T) {
+	keys := cids(1000)
+	for _, b := range builders {
+		if got := b.new(nil).Pick(keys[0]); got != "" {
+			t.Errorf("%s: no nodes picked %q", b.name, got)
+		}
+		if got := b.new([]string{"only"}).Pick(keys[0]); got != "only" {
+			t.Errorf("%s: one node picked %q", b.name, got)
+		}
+		nodes := backends(10)
+		p, q := b.new(nodes), b.new(slices.Clone(nodes))
+		if !slices.Equal(p.Nodes(), nodes) {
+			t.Errorf("%s: Nodes = %v", b.name, p.Nodes())
+		}
+		for _, k := range keys {
+			got := p.Pick(k)
+			if !slices.Contains(nodes, got) {
+				t.Fatalf("%s: picked %q, not a node", b.name, got)
+			}
+			// Another picker built from the same nodes, as another load
+			// balancer would, agrees.
+			if q.Pick(k) != got {
+				t.Fatalf("%s: two pickers over the same nodes disagree on %x", b.name, k)
+			}
+		}
+	}
+}
+
+// TestRedistribution checks the property consistent and rendezvous hashing
+// exist for: adding an eleventh node moves about 1/11 of the keys, all of
+// them to the new node, and removing it moves them back and nothing else.
+// Modulo moves almost all of them.
+func TestRedistribution(t *testing.T) {
+	keys := cids(100_000)
+	nodes := backends(11)
+	for _, b := range builders {
+		before, after := b.new(nodes[:10]), b.new(nodes)
+		f := moved(before, after, keys)
+		t.Logf("%s: %.1f%% of keys moved", b.name, 100*f)
+		if b.name == "modulo" {
+			if f < 0.8 {
+				t.Errorf("modulo: only %.1f%% moved, want most", 100*f)
+			}
+			continue
+		}
+		if f < 0.06 || f > 0.12 {
+			t.Errorf("%s: %.1f%% moved, want about 9%%", b.name, 100*f)
+		}
+		for _, k := range keys {
+			if was, is := before.Pick(k), after.Pick(k); was != is && is != nodes[10] {
+				t.Fatalf("%s: %x moved from %s to %s, not to the new node", b.name, k, was, is)
+			}
+		}
+	}
+}
+
+// TestBalance checks that no node gets much more than its share.
+func TestBalance(t *testing.T) {
+	keys := cids(100_000)
+	for _, b := range builders {
+		for _, n := range []int{3, 10, 50} {
+			c := counts(b.new(backends(n)), keys)
+			mean := float64(len(keys)) / float64(n)
+			worst := 0
+			for _, v := range c {
+				worst = max(worst, v)
+			}
+			t.Logf("%s n=%d: busiest node has %.2fx the mean", b.name, n, float64(worst)/mean)
+			if float64(worst) > 1.3*mean {
+				t.Errorf("%s n=%d: busiest node has %d keys, mean %.0f", b.name, n, worst, mean)
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Package hedge cuts tail latency by sending a second copy of a slow
+// request to another replica and taking whichever answer comes first.
+//
+// Most slow requests are slow for reasons local to one replica at one
+// moment: a GC pause, a full queue, a lost packet. A copy sent to another
+// replica a little later usually does not hit the same delay. Waiting for
+// about the 95th percentile latency before hedging means only the slowest
+// 5% of requests are sent twice, so the extra load is about 5% while the
+// tail shrinks to roughly the hedge delay plus one normal response time.
+// The loser is canceled through its context as soon as the winner returns.
+//
+//	h := hedge.New(hedge.Config{Replicas: 2, Quantile: 0.95})
+//	resp, err := hedge.Do(ctx, h, func(ctx context.Context, replica int) (*Reply, error) {
+//		return clients[replica].Call(ctx, req)
+//	})
+//
+// Only idempotent requests may be hedged: both copies may be served. A
+// Budget bounds the extra load when a replica, or all of them, slow down
+// together and every request would otherwise be sent twice. The hedging
+// experiment measures the tail against the extra load for a range of
+// delays.
+package hedge
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/breaker"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/telemetry"
+)
+
+// Config tunes a Hedger. Zero fields take the defaults noted.
+type Config struct {
+	Replicas int           // replicas to spread requests over; at least 2 to hedge
+	Delay    time.Duration // wait before hedging; with Quantile, only until enough latencies are known
+	Quantile float64       // if set, hedge after this quantile of recent attempt latencies, e.g. 0.95
+	Window   int           // attempt latencies the quantile is taken over; 1024
+	Budget   *breaker.RetryBudget
+}
+
+// Stats counts what a Hedger has done.
+type Stats struct {
+	Calls  int64
+	Hedges int64         // second copies sent
+	Wins   int64         // calls answered by the second copy
+	Denied int64         // hedges the Budget refused
+	Delay  time.Duration // the current hedge delay
+}
+
+// Hedger decides when to hedge. It is safe for concurrent use, and one
+// Hedger should serve every call to the same set of replicas, so that
+// its latencies describe them.
+type Hedger struct {
+	cfg  Config
+	ring *telemetry.Ring
+	next atomic.Uint64 // round-robin over replicas
+
+	delay    atomic.Int64
+	updateAt atomic.Uint64 // ring count at which the delay is next recomputed
+
+	calls, hedges, wins, denied atomic.Int64
+}
+
+// New returns a Hedger for cfg.
+func New(cfg Config) *Hedger {
+	if cfg.Replicas < 1 {
+		cfg.Replicas = 1
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 1024
+	}
+	h := &Hedger{cfg: cfg, ring: telemetry.NewRing(cfg.Window)}
+	h.delay.Store(int64(cfg.Delay))
+	h.updateAt.Store(uint64(h.ring.Len() / 4))
+	return h
+}
+
+// Stats returns the Hedger's counters.
+func (h *Hedger) Stats() Stats {
+	return Stats{
+		Calls: h.calls.Load(), Hedges: h.hedges.Load(), Wins: h.wins.Load(),
+		Denied: h.denied.Load(), Delay: time.Duration(h.delay.Load()),
+	}
+}
+
+// currentDelay returns the hedge delay, recomputing it from the latency
+// window every eighth of a window's worth of attempts once a quarter of
+// the window is filled. One caller does the work; the rest use the
+// previous value meanwhile.
+func (h *Hedger) currentDelay() time.Duration {
+	if h.cfg.Quantile > 0 {
+		n := h.ring.Count()
+		if at := h.updateAt.Load(); n >= at && h.updateAt.CompareAndSwap(at, n+uint64(h.ring.Len()/8)) {
+			q := telemetry.Quantiles(h.ring.Snapshot(nil), h.cfg.Quantile)[0]
+			h.delay.Store(int64(q))
+		}
+	}
+	return time.Duration(h.delay.Load())
+}
+
+type result[T any] struct {
+	v     T
+	err   error
+	hedge bool
+	took  time.Duration
+}
+
+// Do calls fn for one replica, and again for the next replica if the
+// first call has not returned after the hedge delay. It returns the first
+// success and cancels the other call's context, or the last error once
+// every call made has failed. A call that fails before the hedge delay is
+// not hedged: hedging is for slow replicas, and retrying a failed request
+// is a retry policy's decision. fn must return promptly once its context
+// is canceled.
+func Do[T any](ctx context.Context, h *Hedger, fn func(ctx context.Context, replica int) (T, error)) (T, error) {
+	h.calls.Add(1)
+	if h.cfg.Budget != nil {
+		h.cfg.Budget.Request()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	primary := int((h.next.Add(1) - 1) % uint64(h.cfg.Replicas))
+	results := make(chan result[T], 2) // never blocks the loser after Do returns
+	var starts [2]time.Time
+	launch := func(replica int, hedge bool) {
+		i := 0
+		if hedge {
+			i = 1
+		}
+		starts[i] = time.Now()
+		go func() {
+			v, err := fn(ctx, replica)
+			results <- result[T]{v, err, hedge, time.Since(starts[i])}
+		}()
+	}
+	launch(primary, false)
+	inflight := 1
+
+	var timer <-chan time.Time
+	if h.cfg.Replicas > 1 {
+		t := time.NewTimer(h.currentDelay())
+		defer t.Stop()
+		timer = t.C
+	}
+	for {
+		select {
+		case <-timer:
+			timer = nil
+			if h.cfg.Budget != nil && !h.cfg.Budget.Retry() {
+				h.denied.Add(1)
+				continue
+			}
+			h.hedges.Add(1)
+			launch((primary+1)%h.cfg.Replicas, true)
+			inflight++
+		case r := <-results:
+			inflight--
+			h.ring.Record(r.took)
+			if r.err != nil && inflight > 0 {
+				continue
+			}
+			if r.err == nil && inflight > 0 {
+				// The loser's latency is unknown but at least this long.
+				// Leaving it out would make the tail look shorter than
+				// it is and the delay shrink with every hedge that wins.
+				i := 1
+				if r.hedge {
+					i = 0
+				}
+				h.ring.Record(time.Since(starts[i]))
+			}
+			if r.err == nil && r.hedge {
+				h.wins.Add(1)
+			}
+			return r.v, r.err
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package hedge
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/breaker"
+)
+
+// attempts returns an fn for Do whose first call takes first and whose
+// second takes second, each returning early if canceled. It reports the
+// replica that answered and counts canceled calls.
+func attempts(first, second time.Duration, canceled *atomic.Int32) func(context.Context, int) (int, error) {
+	var n atomic.Int32
+	return func(ctx context.Context, replica int) (int, error) {
+		d := first
+		if n.Add(1) > 1 {
+			d = second
+		}
+		select {
+		case <-time.After(d):
+			return replica, nil
+		case <-ctx.Done():
+			canceled.Add(1)
+			return -1, ctx.Err()
+		}
+	}
+}
+
+func TestNoHedge(t *testing.T) {
+	h := New(Config{Replicas: 2, Delay: 50 * time.Millisecond})
+	var canceled atomic.Int32
+	for i := range 4 {
+		replica, err := Do(context.Background(), h, attempts(0, 0, &canceled))
+		if err != nil || replica != i%2 {
+			t.Fatalf("call %d: replica %d, %v; want %d", i, replica, err, i%2)
+		}
+	}
+	if s := h.Stats(); s.Calls != 4 || s.Hedges != 0 {
+		t.Errorf("stats %+v, want 4 calls and no hedges", s)
+	}
+}
+
+func TestHedgeWins(t *testing.T) {
+	h := New(Config{Replicas: 2, Delay: 10 * time.Millisecond})
+	var canceled atomic.Int32
+	start := time.Now()
+	replica, err := Do(context.Background(), h, attempts(time.Minute, 0, &canceled))
+	if err != nil || replica != 1 {
+		t.Fatalf("replica %d, %v; want the hedge to replica 1", replica, err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("took %v with a 10ms hedge delay", took)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if canceled.Load() != 1 {
+		t.Error("the slow primary was not canceled")
+	}
+	if s := h.Stats(); s.Hedges != 1 || s.Wins != 1 {
+		t.Errorf("stats %+v, want one hedge that won", s)
+	}
+}
+
+func TestPrimaryWins(t *testing.T) {
+	h := New(Config{Replicas: 2, Delay: 5 * time.Millisecond})
+	var canceled atomic.Int32
+	replica, err := Do(context.Background(), h, attempts(20*time.Millisecond, time.Minute, &canceled))
+	if err != nil || replica != 0 {
+		t.Fatalf("replica %d, %v; want the primary", replica, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if s := h.Stats(); s.Hedges != 1 || s.Wins != 0 || canceled.Load() != 1 {
+		t.Errorf("stats %+v, %d canceled; want a hedge that lost and was canceled", s, canceled.Load())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	h := New(Config{Replicas: 2, Delay: 20 * time.Millisecond})
+	boom := errors.New("boom")
+
+	// A fast failure is returned, not hedged.
+	_, err := Do(context.Background(), h, func(ctx context.Context, replica int) (int, error) {
+		return 0, boom
+	})
+	if err != boom || h.Stats().Hedges != 0 {
+		t.Fatalf("err %v, %d hedges; want boom and no hedge", err, h.Stats().Hedges)
+	}
+
+	// A failure after the hedge went out waits for the hedge.
+	var n atomic.Int32
+	replica, err := Do(context.Background(), h, func(ctx context.Context, replica int) (int, error) {
+		if n.Add(1) == 1 {
+			time.Sleep(30 * time.Millisecond)
+			return 0, boom
+		}
+		time.Sleep(30 * time.Millisecond)
+		return replica, nil
+	})
+	if err != nil || replica != 0 {
+		t.Errorf("replica %d, %v; want the hedge's answer", replica, err)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	h := New(Config{Replicas: 2, Delay: time.Millisecond, Budget: breaker.NewRetryBudget(0, 1)})
+	var canceled atomic.Int32
+	for range 3 {
+		if _, err := Do(context.Background(), h, attempts(10*time.Millisecond, 0, &canceled)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := h.Stats(); s.Hedges != 1 || s.Denied != 2 {
+		t.Errorf("stats %+v, want a burst of one hedge and two denied", s)
+	}
+}
+
+// TestAdaptiveDelay checks that the delay follows the quantile of the
+// latencies seen, and that hedges that win do not drag it down.
+func TestAdaptiveDelay(t *testing.T) {
+	h := New(Config{Replicas: 2, Delay: time.Hour, Quantile: 0.9, Window: 64})
+	fast := func(ctx context.Context, replica int) (int, error) { return 0, nil }
+	for range 64 {
+		Do(context.Background(), h, fast)
+	}
+	if d := h.Stats().Delay; d > time.Millisecond {
+		t.Fatalf("delay %v after 64 instant calls, want it near zero", d)
+	}
+
+	// Now a fifth of first attempts take 30ms. Their hedges win, but the
+	// primaries' 30ms+ keeps the 90th percentile up.
+	var i atomic.Int32
+	var canceled atomic.Int32
+	for range 128 {
+		slow := time.Duration(0)
+		if i.Add(1)%5 == 0 {
+			slow = 30 * time.Millisecond
+		}
+		Do(context.Background(), h, attempts(slow, 0, &canceled))
+	}
+	if d := h.Stats().Delay; d < time.Millisecond {
+		t.Errorf("delay %v with a fifth of the calls slow, want it above the fast calls", d)
+	}
+}
+
+func BenchmarkDo(b *testing.B) {
+	h := New(Config{Replicas: 2, Quantile: 0.95})
+	fn := func(ctx context.Context, replica int) (int, error) { return replica, nil }
+	for b.Loop() {
+		Do(context.Background(), h, fn)
+	}
+}
@@ -0,0 +1,79 @@
+//go:build linux
+
+package hedge
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/vnet"
+)
+
+// TestAcrossLinks hedges real TCP requests between two replicas in their
+// own network namespaces, one 2ms and one 100ms away. A request dials and
+// waits for one line, two round trips. Calls whose primary is the near
+// replica answer before the hedge delay; the others are hedged to it and
+// answer after the delay plus its two round trips, never the far
+// replica's 200ms.
+func TestAcrossLinks(t *testing.T) {
+	n := vnet.New(t, vnet.Link{RTT: 2 * time.Millisecond}, vnet.Link{RTT: 100 * time.Millisecond})
+	var addrs []string
+	for _, host := range n.Hosts {
+		ln, err := host.Listen("tcp", ":0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		go func() {
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer c.Close()
+					line, _ := bufio.NewReader(c).ReadString('\n')
+					c.Write([]byte(line))
+				}()
+			}
+		}()
+		addrs = append(addrs, netip.AddrPortFrom(host.Addr, uint16(ln.Addr().(*net.TCPAddr).Port)).String())
+	}
+	request := func(ctx context.Context, replica int) (int, error) {
+		c, err := n.Client.DialContext(ctx, "tcp", addrs[replica])
+		if err != nil {
+			return -1, err
+		}
+		defer c.Close()
+		defer context.AfterFunc(ctx, func() { c.Close() })()
+		if _, err := c.Write([]byte("ping\n")); err != nil {
+			return -1, err
+		}
+		if _, err := bufio.NewReader(c).ReadString('\n'); err != nil {
+			return -1, err
+		}
+		return replica, nil
+	}
+
+	const calls = 10
+	const delay = 30 * time.Millisecond
+	h := New(Config{Replicas: 2, Delay: delay})
+	for i := range calls {
+		start := time.Now()
+		replica, err := Do(context.Background(), h, request)
+		took := time.Since(start)
+		if err != nil || replica != 0 {
+			t.Fatalf("call %d: replica %d, %v; want the near replica", i, replica, err)
+		}
+		if took > delay+50*time.Millisecond {
+			t.Errorf("call %d took %v; the hedge should answer within about %v", i, took, delay+4*time.Millisecond)
+		}
+	}
+	if s := h.Stats(); s.Hedges != calls/2 || s.Wins != calls/2 {
+		t.Errorf("stats %+v, want a hedge that won for every call to the far replica", s)
+	}
+}
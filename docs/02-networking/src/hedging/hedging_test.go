@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParsePolicies(t *testing.T) {
+	ps, err := parsePolicies("off, 0,2ms,p95")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 4 || !ps[0].off || ps[1].delay != 0 || ps[2].delay != 2*time.Millisecond || ps[3].quantile != 0.95 {
+		t.Errorf("parsed %+v", ps)
+	}
+	for _, bad := range []string{"p", "p100", "-1ms", "soon"} {
+		if _, err := parsePolicies(bad); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+}
+
+// TestHedging checks the experiment's claim on a small scale, over both
+// protocols: hedging after a quantile below the fraction of stalled
+// requests takes the stall out of the p99 for a few percent more load.
+func TestHedging(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs for seconds")
+	}
+	for _, proto := range []string{"line", "http"} {
+		cfg := config{Proto: proto, Requests: 3000, Concurrency: 8,
+			Lat:      latency{service: time.Millisecond, slow: 0.03, stall: 50 * time.Millisecond},
+			Policies: []policy{{name: "off", off: true}, {name: "p90", delay: time.Second, quantile: 0.9}}}
+		if err := cfg.validate(); err != nil {
+			t.Fatal(err)
+		}
+		var res []result
+		if err := run(context.Background(), cfg, func(r result) { res = append(res, r) }); err != nil {
+			t.Fatal(err)
+		}
+		off, on := res[0], res[1]
+		t.Logf("%s: off %+v", proto, off)
+		t.Logf("%s: p90 %+v", proto, on)
+		if off.P99 < cfg.Lat.stall || on.P99 > cfg.Lat.stall/2 {
+			t.Errorf("%s: p99 %v without hedging and %v with it, want the %v stall gone", proto, off.P99, on.P99, cfg.Lat.stall)
+		}
+		if load := float64(on.Arrivals) / float64(on.Requests); load > 1.3 {
+			t.Errorf("%s: %.2f requests at the replicas per request, want about 1.1", proto, load)
+		}
+		if off.Errors+on.Errors != 0 {
+			t.Errorf("%s: %d errors", proto, off.Errors+on.Errors)
+		}
+	}
+}
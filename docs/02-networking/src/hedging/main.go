@@ -0,0 +1,75 @@
+// Command hedging measures what hedged requests from the hedge package do
+// to tail latency, and what they cost in extra load. It starts two
+// replicas and sends -requests requests from -concurrency goroutines under
+// each hedge delay in -delays:
+//
+//	go run ./hedging -proto line -delays off,0,1ms,2ms,5ms,10ms,p95,p99
+//
+// A replica answers after -service, scaled by a random factor between 0.5
+// and 1.5, and -slow of its requests stall for another -stall, so without
+// hedging the p99 is the stall. "off" sends each request to one replica
+// only. A duration hedges every request still unanswered after it; 0 sends
+// every request to both replicas at once. "p95" hedges after the 95th
+// percentile of recent latencies, so it adapts to the replicas.
+//
+// For each delay it prints the latency percentiles, the requests the
+// replicas received per request sent (the load hedging adds), the hedges
+// that were answered first, and, over HTTP, the copies the replicas saw
+// canceled before they replied.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.Proto, "proto", "line", "Protocol: line or http")
+	flag.IntVar(&cfg.Requests, "requests", 20000, "Requests per hedge delay")
+	flag.IntVar(&cfg.Concurrency, "concurrency", 16, "Requests in flight at once")
+	flag.DurationVar(&cfg.Lat.service, "service", time.Millisecond, "Replica response time, before the random factor")
+	flag.Float64Var(&cfg.Lat.slow, "slow", 0.02, "Fraction of requests that stall")
+	flag.DurationVar(&cfg.Lat.stall, "stall", 40*time.Millisecond, "Extra time a stalled request takes")
+	delays := flag.String("delays", "off,0,1ms,2ms,5ms,10ms,20ms,p90,p95,p99", "Hedge delays to compare")
+	flag.Parse()
+	var err error
+	if cfg.Policies, err = parsePolicies(*delays); err == nil {
+		err = cfg.validate()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	fmt.Printf("proto=%s service=%v slow=%.1f%% stall=%v concurrency=%d\n",
+		cfg.Proto, cfg.Lat.service, cfg.Lat.slow*100, cfg.Lat.stall, cfg.Concurrency)
+	fmt.Printf("%-6s %9s %9s %9s %9s %9s %6s %8s %8s %7s\n",
+		"delay", "p50", "p90", "p99", "p99.9", "hedge at", "load", "hedges", "won", "errors")
+	err = run(ctx, cfg, func(r result) {
+		n := float64(max(r.Requests, 1))
+		at := "-"
+		if r.Policy != "off" {
+			at = r.Delay.Round(10 * time.Microsecond).String()
+		}
+		fmt.Printf("%-6s %9v %9v %9v %9v %9s %6.3f %7.1f%% %7.1f%% %7d\n",
+			r.Policy, r.P50.Round(10*time.Microsecond), r.P90.Round(10*time.Microsecond),
+			r.P99.Round(10*time.Microsecond), r.P999.Round(10*time.Microsecond), at,
+			float64(r.Arrivals)/n, 100*float64(r.Hedges)/n, 100*float64(r.Wins)/n, r.Errors)
+		if r.Canceled > 0 {
+			fmt.Printf("       %d copies canceled at the replica before it replied\n", r.Canceled)
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
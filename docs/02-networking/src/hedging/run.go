@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/connpool"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/hedge"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/telemetry"
+)
+
+// config is one run, as set by the flags.
+type config struct {
+	Proto       string
+	Requests    int // per policy
+	Concurrency int
+	Lat         latency
+	Policies    []policy
+}
+
+func (c *config) validate() error {
+	switch {
+	case c.Proto != "http" && c.Proto != "line":
+		return fmt.Errorf("unknown -proto %q", c.Proto)
+	case c.Requests <= 0 || c.Concurrency <= 0:
+		return errors.New("-requests and -concurrency must be positive")
+	case c.Lat.service <= 0 || c.Lat.stall < 0 || c.Lat.slow < 0 || c.Lat.slow > 1:
+		return errors.New("-service must be positive, -stall not negative and -slow between 0 and 1")
+	case len(c.Policies) == 0:
+		return errors.New("no -delays")
+	}
+	return nil
+}
+
+// policy is one entry of -delays: "off", a fixed delay such as "2ms", or
+// a quantile of recent latencies such as "p95".
+type policy struct {
+	name     string
+	off      bool
+	delay    time.Duration
+	quantile float64
+}
+
+func parsePolicies(s string) ([]policy, error) {
+	var ps []policy
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		p := policy{name: f}
+		switch {
+		case f == "off":
+			p.off = true
+		case strings.HasPrefix(f, "p"):
+			q, err := strconv.ParseFloat(f[1:], 64)
+			if err != nil || q <= 0 || q >= 100 {
+				return nil, fmt.Errorf("bad quantile %q", f)
+			}
+			p.quantile = q / 100
+			p.delay = time.Second // until the first quarter window is in
+		default:
+			d, err := time.ParseDuration(f)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("bad delay %q", f)
+			}
+			p.delay = d
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// result is one policy's outcome.
+type result struct {
+	Policy              string
+	P50, P90, P99, P999 time.Duration
+	Errors              int64
+	Arrivals            int64 // requests the replicas received
+	Canceled            int64 // of those, canceled before the reply (HTTP only)
+	Hedges, Wins        int64
+	Delay               time.Duration // the hedge delay at the end
+	Requests            int64
+	Elapsed             time.Duration
+}
+
+// caller sends one request to a replica.
+type caller interface {
+	call(ctx context.Context, replica int) (struct{}, error)
+	close()
+}
+
+type httpCaller struct {
+	c    *http.Client
+	urls []string
+}
+
+func (h *httpCaller) call(ctx context.Context, replica int) (struct{}, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", h.urls[replica], nil)
+	resp, err := h.c.Do(req)
+	if err != nil {
+		return struct{}{}, err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return struct{}{}, err
+}
+
+func (h *httpCaller) close() { h.c.CloseIdleConnections() }
+
+type lineCaller struct {
+	pools []*connpool.Pool
+}
+
+var line = []byte("hedge me\n")
+
+// call writes one line and reads it back. A canceled call closes its
+// connection: the reply may still arrive on it.
+func (l *lineCaller) call(ctx context.Context, replica int) (struct{}, error) {
+	pool := l.pools[replica]
+	conn, err := pool.Get(ctx)
+	if err != nil {
+		return struct{}{}, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	_, err = conn.Write(line)
+	var buf [64]byte
+	n := 0
+	for err == nil && (n == 0 || buf[n-1] != '\n') {
+		var m int
+		m, err = conn.Read(buf[n:])
+		n += m
+	}
+	if !stop() || err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return struct{}{}, err
+	}
+	pool.Put(conn)
+	if !bytes.Equal(buf[:n], line) {
+		return struct{}{}, errors.New("bad echo")
+	}
+	return struct{}{}, nil
+}
+
+func (l *lineCaller) close() {
+	for _, p := range l.pools {
+		p.Close()
+	}
+}
+
+// run starts two replicas and sends cfg.Requests requests through each
+// policy in turn, from cfg.Concurrency goroutines, calling onResult after
+// each policy.
+func run(ctx context.Context, cfg config, onResult func(result)) error {
+	var reps []*replica
+	defer func() {
+		for _, r := range reps {
+			r.Close()
+		}
+	}()
+	for range 2 {
+		r, err := listenReplica(cfg.Proto, cfg.Lat)
+		if err != nil {
+			return err
+		}
+		reps = append(reps, r)
+	}
+	for _, p := range cfg.Policies {
+		if ctx.Err() != nil {
+			return nil
+		}
+		c := newCaller(cfg.Proto, reps)
+		res := runPolicy(ctx, cfg, p, c, reps)
+		c.close()
+		onResult(res)
+	}
+	return nil
+}
+
+func newCaller(proto string, reps []*replica) caller {
+	if proto == "http" {
+		h := &httpCaller{c: &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 1024}}}
+		for _, r := range reps {
+			h.urls = append(h.urls, "http://"+r.Addr()+"/")
+		}
+		return h
+	}
+	l := &lineCaller{}
+	for _, r := range reps {
+		addr := r.Addr()
+		var d net.Dialer
+		l.pools = append(l.pools, connpool.New(func(ctx context.Context) (net.Conn, error) {
+			return d.DialContext(ctx, "tcp", addr)
+		}, 1024))
+	}
+	return l
+}
+
+func runPolicy(ctx context.Context, cfg config, p policy, c caller, reps []*replica) result {
+	var arrivals, canceled int64
+	for _, r := range reps {
+		arrivals -= r.arrivals.Load()
+		canceled -= r.canceled.Load()
+	}
+	h := hedge.New(hedge.Config{Replicas: len(reps), Delay: p.delay, Quantile: p.quantile})
+	var rr atomic.Uint64
+	do := func(ctx context.Context) error {
+		if p.off {
+			_, err := c.call(ctx, int(rr.Add(1)%uint64(len(reps))))
+			return err
+		}
+		_, err := hedge.Do(ctx, h, c.call)
+		return err
+	}
+
+	var next, errs atomic.Int64
+	lat := make([][]time.Duration, cfg.Concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := range cfg.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next.Add(1) <= int64(cfg.Requests) && ctx.Err() == nil {
+				t := time.Now()
+				if err := do(ctx); err != nil {
+					errs.Add(1)
+					continue
+				}
+				lat[w] = append(lat[w], time.Since(t))
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var all []time.Duration
+	for _, l := range lat {
+		all = append(all, l...)
+	}
+	q := telemetry.Quantiles(all, 0.5, 0.9, 0.99, 0.999)
+	// Let canceled requests reach the replicas' counters.
+	time.Sleep(cfg.Lat.stall + 2*cfg.Lat.service)
+	for _, r := range reps {
+		arrivals += r.arrivals.Load()
+		canceled += r.canceled.Load()
+	}
+	s := h.Stats()
+	res := result{
+		Policy: p.name, P50: q[0], P90: q[1], P99: q[2], P999: q[3], Errors: errs.Load(),
+		Arrivals: arrivals, Canceled: canceled, Hedges: s.Hedges, Wins: s.Wins,
+		Requests: int64(len(all)) + errs.Load(), Elapsed: elapsed,
+	}
+	if !p.off {
+		res.Delay = s.Delay
+	}
+	return res
+}
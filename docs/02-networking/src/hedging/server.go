@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latency is a replica's response time: service scaled by a random factor
+// in [0.5, 1.5), and with probability slow a stall on top, standing in for
+// a GC pause, a queue behind a large request or a retransmission.
+type latency struct {
+	service time.Duration
+	slow    float64
+	stall   time.Duration
+}
+
+func (l latency) draw() time.Duration {
+	d := time.Duration(float64(l.service) * (0.5 + rand.Float64()))
+	if rand.Float64() < l.slow {
+		d += l.stall
+	}
+	return d
+}
+
+// replica is one backend, serving either HTTP or the line echo protocol on
+// a loopback port. It counts the requests it received and, for HTTP, the
+// ones whose client canceled them before the reply.
+type replica struct {
+	lat      latency
+	ln       net.Listener
+	http     *http.Server
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+	arrivals atomic.Int64
+	canceled atomic.Int64
+}
+
+func listenReplica(proto string, lat latency) (*replica, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	r := &replica{lat: lat, ln: ln, conns: map[net.Conn]struct{}{}}
+	if proto == "http" {
+		r.http = &http.Server{Handler: r}
+		go r.http.Serve(ln)
+	} else {
+		r.wg.Add(1)
+		go r.acceptLine()
+	}
+	return r, nil
+}
+
+func (r *replica) Addr() string { return r.ln.Addr().String() }
+
+func (r *replica) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.arrivals.Add(1)
+	t := time.NewTimer(r.lat.draw())
+	defer t.Stop()
+	select {
+	case <-t.C:
+		w.Write([]byte("ok\n"))
+	case <-req.Context().Done():
+		r.canceled.Add(1)
+	}
+}
+
+func (r *replica) acceptLine() {
+	defer r.wg.Done()
+	for {
+		conn, err := r.ln.Accept()
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			conn.Close()
+			return
+		}
+		r.conns[conn] = struct{}{}
+		r.wg.Add(1)
+		r.mu.Unlock()
+		go r.serveLine(conn)
+	}
+}
+
+// serveLine echoes each line after the replica's latency. A client that
+// gave up on a reply has closed the connection, which shows up only when
+// the reply is written.
+func (r *replica) serveLine(conn net.Conn) {
+	defer r.wg.Done()
+	defer func() {
+		r.mu.Lock()
+		delete(r.conns, conn)
+		r.mu.Unlock()
+		conn.Close()
+	}()
+	br := bufio.NewReader(conn)
+	for {
+		line, err := br.ReadSlice('\n')
+		if err != nil {
+			return
+		}
+		r.arrivals.Add(1)
+		time.Sleep(r.lat.draw())
+		if _, err := conn.Write(line); err != nil {
+			return
+		}
+	}
+}
+
+func (r *replica) Close() error {
+	if r.http != nil {
+		return r.http.Close()
+	}
+	err := r.ln.Close()
+	r.mu.Lock()
+	r.closed = true
+	for conn := range r.conns {
+		conn.Close()
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	return err
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hostSample is one row of hostmon output, by column name.
+type hostSample struct {
+	At   time.Time
+	Vals map[string]uint64
+}
+
+// connectSample is one row of loadgen -connect-log output.
+type connectSample struct {
+	At      time.Time
+	Latency time.Duration
+	Result  string // "ok" or the error
+}
+
+// bucket aggregates both sides over one second: the largest size of each
+// table and the sum of each event counter.
+type bucket struct {
+	Second   time.Time
+	Vals     map[string]uint64
+	Connects int
+	Failed   int
+	Errors   map[string]int
+	P99      time.Duration // of the connects that succeeded
+}
+
+func readCSV(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	return r.ReadAll()
+}
+
+func parseMillis(s string) (time.Time, error) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	return time.UnixMilli(ms), err
+}
+
+// readHostCSV reads hostmon output by its header, so files from a version
+// with other columns still load.
+func readHostCSV(path string) ([]hostSample, error) {
+	rows, err := readCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || rows[0][0] != "unix_ms" {
+		return nil, fmt.Errorf("%s: no hostmon header", path)
+	}
+	header := rows[0]
+	out := make([]hostSample, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		if len(row) != len(header) {
+			return nil, fmt.Errorf("%s: %d fields for %d columns", path, len(row), len(header))
+		}
+		s := hostSample{Vals: make(map[string]uint64, len(row)-1)}
+		if s.At, err = parseMillis(row[0]); err != nil {
+			return nil, err
+		}
+		for i := 1; i < len(row); i++ {
+			if s.Vals[header[i]], err = strconv.ParseUint(row[i], 10, 64); err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", path, header[i], err)
+			}
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func readConnectCSV(path string) ([]connectSample, error) {
+	rows, err := readCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > 0 {
+		rows = rows[1:] // header
+	}
+	out := make([]connectSample, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 3 {
+			return nil, fmt.Errorf("%s: short row %v", path, row)
+		}
+		var s connectSample
+		if s.At, err = parseMillis(row[0]); err != nil {
+			return nil, err
+		}
+		us, err := strconv.ParseInt(row[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		s.Latency = time.Duration(us) * time.Microsecond
+		s.Result = row[2]
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// isEvent reports whether column is an event counter, summed over a
+// second, rather than a table size.
+func isEvent(column string) bool {
+	for _, e := range events {
+		if e.name == column {
+			return true
+		}
+	}
+	return false
+}
+
+// correlate joins both series on wall-clock seconds. Connect samples are
+// bucketed by when the connect started.
+func correlate(host []hostSample, conns []connectSample) []bucket {
+	byKey := make(map[int64]*bucket)
+	get := func(t time.Time) *bucket {
+		sec := t.Unix()
+		b, ok := byKey[sec]
+		if !ok {
+			b = &bucket{Second: time.Unix(sec, 0), Vals: make(map[string]uint64), Errors: make(map[string]int)}
+			byKey[sec] = b
+		}
+		return b
+	}
+
+	for _, s := range host {
+		b := get(s.At)
+		for k, v := range s.Vals {
+			if isEvent(k) {
+				b.Vals[k] += v
+			} else {
+				b.Vals[k] = max(b.Vals[k], v)
+			}
+		}
+	}
+	lat := make(map[int64][]time.Duration)
+	for _, c := range conns {
+		b := get(c.At)
+		b.Connects++
+		if c.Result != "ok" {
+			b.Failed++
+			b.Errors[c.Result]++
+			continue
+		}
+		lat[c.At.Unix()] = append(lat[c.At.Unix()], c.Latency)
+	}
+
+	out := make([]bucket, 0, len(byKey))
+	for sec, b := range byKey {
+		if l := lat[sec]; len(l) > 0 {
+			sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+			b.P99 = l[(len(l)-1)*99/100]
+		}
+		out = append(out, *b)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Second.Before(out[j].Second) })
+	return out
+}
+
+// pearson returns the correlation coefficient of x and y, or NaN when either
+// series is constant.
+func pearson(x, y []float64) float64 {
+	n := float64(len(x))
+	var sx, sy, sxx, syy, sxy float64
+	for i := range x {
+		sx += x[i]
+		sy += y[i]
+		sxx += x[i] * x[i]
+		syy += y[i] * y[i]
+		sxy += x[i] * y[i]
+	}
+	den := math.Sqrt(n*sxx-sx*sx) * math.Sqrt(n*syy-sy*sy)
+	if den == 0 {
+		return math.NaN()
+	}
+	return (n*sxy - sx*sy) / den
+}
+
+// nearFull is the share of its limit at which a table is reported as
+// the likely cause of failures in the same second.
+const nearFull = 0.9
+
+// suspects returns what was full or dropping in b: tables at nearFull of
+// their limit or more, and event counters that moved.
+func suspects(b bucket) []string {
+	var out []string
+	for _, t := range tables {
+		if t.limit == "" {
+			continue
+		}
+		v, limit := b.Vals[t.name], b.Vals[t.name+"_max"]
+		if limit > 0 && float64(v) >= nearFull*float64(limit) {
+			out = append(out, fmt.Sprintf("%s %d/%d", t.name, v, limit))
+		}
+	}
+	for _, e := range events {
+		if v := b.Vals[e.name]; v > 0 {
+			out = append(out, fmt.Sprintf("%s +%d", e.name, v))
+		}
+	}
+	return out
+}
+
+// topError returns the most frequent error of b.
+func topError(b bucket) string {
+	top, n := "", 0
+	for e, c := range b.Errors {
+		if c > n || c == n && e < top {
+			top, n = e, c
+		}
+	}
+	return top
+}
+
+func printCorrelation(w io.Writer, buckets []bucket) {
+	// Only the tables and events that moved get a column; on most hosts
+	// most of them stay flat.
+	var cols []string
+	for _, t := range tables {
+		var lo, hi uint64 = math.MaxUint64, 0
+		for _, b := range buckets {
+			lo, hi = min(lo, b.Vals[t.name]), max(hi, b.Vals[t.name])
+		}
+		if hi > lo {
+			cols = append(cols, t.name)
+		}
+	}
+	for _, e := range events {
+		for _, b := range buckets {
+			if b.Vals[e.name] > 0 {
+				cols = append(cols, e.name)
+				break
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "%-8s %8s %6s %12s", "second", "connects", "failed", "p99")
+	for _, c := range cols {
+		fmt.Fprintf(w, " %*s", max(len(c), 8), c)
+	}
+	fmt.Fprintf(w, "  %s\n", "top error")
+	var t0 time.Time
+	for i, b := range buckets {
+		if i == 0 {
+			t0 = b.Second
+		}
+		fmt.Fprintf(w, "%-8d %8d %6d %12v", int(b.Second.Sub(t0)/time.Second), b.Connects, b.Failed, b.P99.Round(time.Microsecond))
+		for _, c := range cols {
+			fmt.Fprintf(w, " %*d", max(len(c), 8), b.Vals[c])
+		}
+		fmt.Fprintf(w, "  %s\n", topError(b))
+	}
+
+	fmt.Fprintln(w)
+	for _, t := range tables {
+		if t.limit == "" {
+			continue
+		}
+		var peak, limit uint64
+		for _, b := range buckets {
+			peak, limit = max(peak, b.Vals[t.name]), max(limit, b.Vals[t.name+"_max"])
+		}
+		if limit > 0 {
+			fmt.Fprintf(w, "%-14s peak %d of %d (%.0f%%)\n", t.name, peak, limit, 100*float64(peak)/float64(limit))
+		}
+	}
+
+	// Correlations are taken over the seconds with connect attempts, so
+	// that idle time before and after the test does not count. A table
+	// under pressure often slows connects down before any of them fail,
+	// so both are shown; "-" is a series that never changed.
+	var failed, p99s []float64
+	series := make(map[string][]float64)
+	for _, b := range buckets {
+		if b.Connects == 0 {
+			continue
+		}
+		failed = append(failed, float64(b.Failed))
+		p99s = append(p99s, float64(b.P99))
+		for _, c := range cols {
+			series[c] = append(series[c], float64(b.Vals[c]))
+		}
+	}
+	if len(cols) > 0 {
+		fmt.Fprintf(w, "\n%-24s %15s %12s\n", "correlation with", "failed connects", "connect p99")
+	}
+	coef := func(x, y []float64) string {
+		if r := pearson(x, y); !math.IsNaN(r) {
+			return fmt.Sprintf("%.2f", r)
+		}
+		return "-"
+	}
+	for _, c := range cols {
+		fmt.Fprintf(w, "%-24s %15s %12s\n", c, coef(series[c], failed), coef(series[c], p99s))
+	}
+
+	for _, b := range buckets {
+		if b.Failed == 0 {
+			continue
+		}
+		s := suspects(b)
+		if len(s) == 0 {
+			s = []string{"no host table near its limit"}
+		}
+		fmt.Fprintf(w, "\nfirst failed connects at second %d (%d, %s): %s\n",
+			int(b.Second.Sub(t0)/time.Second), b.Failed, topError(b), strings.Join(s, ", "))
+		break
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleSockstat = `sockets: used 1024
+TCP: inuse 900 orphan 3 tw 4000 alloc 910 mem 226
+UDP: inuse 2 mem 1
+FRAG: inuse 0 memory 0
+`
+
+// Two CPUs. entries is the table's size, repeated on every line.
+const sampleConntrack = `entries  clashres found new invalid ignore delete chainlength insert insert_failed drop early_drop icmp_error  expect_new expect_create expect_delete search_restart
+0003fffe  00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000002 00000010 00000001 00000000  00000000 00000000 00000000 00000000
+0003fffe  00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000020 00000000 00000000  00000000 00000000 00000000 00000000
+`
+
+func TestParseSockstat(t *testing.T) {
+	got := make(map[string]uint64)
+	if err := parseSockstat(strings.NewReader(sampleSockstat), got); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]uint64{
+		"sockets.used": 1024, "TCP.inuse": 900, "TCP.orphan": 3, "TCP.tw": 4000, "TCP.mem": 226, "UDP.inuse": 2,
+	} {
+		if got[k] != v {
+			t.Errorf("%s = %d, want %d", k, got[k], v)
+		}
+	}
+	if err := parseSockstat(strings.NewReader("TCP: inuse\n"), got); err == nil {
+		t.Error("expected an error for a name without a value")
+	}
+}
+
+func TestParseStatTable(t *testing.T) {
+	got := make(map[string]uint64)
+	if err := parseStatTable("nf_conntrack", strings.NewReader(sampleConntrack), got); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]uint64{
+		"nf_conntrack.entries": 0x3fffe, "nf_conntrack.drop": 0x30, "nf_conntrack.early_drop": 1, "nf_conntrack.insert_failed": 2,
+	} {
+		if got[k] != v {
+			t.Errorf("%s = %d, want %d", k, got[k], v)
+		}
+	}
+}
+
+// TestReadProc reads a fixture tree without nf_conntrack, as on a host
+// where the module is not loaded.
+func TestReadProc(t *testing.T) {
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"net/sockstat":                          sampleSockstat,
+		"net/stat/arp_cache":                    "entries table_fulls\n00000010 00000000\n00000010 00000003\n",
+		"sys/net/ipv4/neigh/default/gc_thresh3": "1024\n",
+		"sys/net/ipv4/tcp_mem":                  "70809\t94415\t141618\n",
+		"sys/fs/file-nr":                        "2048\t0\t613820\n",
+		"sys/net/ipv4/tcp_max_tw_buckets":       "32768\n",
+	} {
+		p := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(r string) { procRoot = r }(procRoot)
+	procRoot = root
+
+	got, err := readProc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]uint64{
+		"TCP.tw": 4000, "TCP.tw_max": 32768, "TCP.mem_max": 141618, "files.used": 2048, "files.max": 613820,
+		"arp_cache.entries": 16, "arp_cache.table_fulls": 3, "neigh4.max": 1024, "conntrack.count": 0,
+	} {
+		if got[k] != v {
+			t.Errorf("%s = %d, want %d", k, got[k], v)
+		}
+	}
+}
+
+func TestCorrelate(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	host := []hostSample{
+		{At: t0, Vals: map[string]uint64{"conntrack": 1000, "conntrack_max": 262144, "tcp_tw": 10}},
+		{At: t0.Add(time.Second), Vals: map[string]uint64{"conntrack": 250000, "conntrack_max": 262144, "tcp_tw": 12, "conntrack_drop": 5}},
+		{At: t0.Add(1500 * time.Millisecond), Vals: map[string]uint64{"conntrack": 262144, "conntrack_max": 262144, "tcp_tw": 11, "conntrack_drop": 40}},
+	}
+	conns := []connectSample{
+		{At: t0, Latency: time.Millisecond, Result: "ok"},
+		{At: t0.Add(time.Second), Latency: 3 * time.Millisecond, Result: "ok"},
+		{At: t0.Add(1100 * time.Millisecond), Result: "i/o timeout"},
+		{At: t0.Add(1200 * time.Millisecond), Result: "i/o timeout"},
+	}
+	got := correlate(host, conns)
+	if len(got) != 2 {
+		t.Fatalf("got %d buckets, want 2", len(got))
+	}
+	b := got[1]
+	if b.Vals["conntrack"] != 262144 || b.Vals["tcp_tw"] != 12 || b.Vals["conntrack_drop"] != 45 || b.Connects != 3 || b.Failed != 2 || b.P99 != 3*time.Millisecond {
+		t.Errorf("unexpected second bucket %+v", b)
+	}
+
+	var out bytes.Buffer
+	printCorrelation(&out, got)
+	want := "first failed connects at second 1 (2, i/o timeout): conntrack 262144/262144, conntrack_drop +45"
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("output lacks %q:\n%s", want, out.String())
+	}
+}
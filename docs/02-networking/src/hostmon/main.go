@@ -0,0 +1,155 @@
+// Command hostmon samples the kernel tables that fill up during a test with
+// hundreds of thousands of connections, and correlates them with the
+// connect errors recorded by loadgen.
+//
+// Go-level metrics cannot see these limits. A full conntrack table drops
+// new SYNs before any socket exists, and a full neighbour table stops the
+// host from resolving peers. Too many orphaned or TIME_WAIT sockets get
+// reset or destroyed early, and running out of TCP memory or file handles
+// fails calls in processes other than the one under test. All of them
+// show up on the client as timeouts, resets or EADDRNOTAVAIL.
+//
+// Sample the host during a test, on each machine involved:
+//
+//	go run ./hostmon -interval 1s > host.csv
+//	go run ./loadgen -conns 200000 -src 10.0.0.2,10.0.0.3,10.0.0.4,10.0.0.5 -connect-log connect.csv
+//
+// Then merge both into a per-second view:
+//
+//	go run ./hostmon -correlate host.csv -connects connect.csv
+//
+// The tables are per network namespace, so run hostmon in the namespace
+// whose interfaces carry the test traffic: on the host, not in a
+// container, when the container's traffic is NATed by the host.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+)
+
+var (
+	interval   = flag.Duration("interval", time.Second, "Sampling interval")
+	correlateF = flag.String("correlate", "", "Host CSV produced by a previous run; switches to correlation mode")
+	connectsF  = flag.String("connects", "", "Connect log CSV produced by loadgen -connect-log")
+)
+
+// table is a kernel table whose size is sampled, with the limit it runs
+// into. Keys are those of readProc.
+type table struct {
+	name, key, limit string
+}
+
+var tables = []table{
+	{"sockets", "sockets.used", ""},
+	{"tcp_inuse", "TCP.inuse", ""},
+	{"tcp6_inuse", "TCP6.inuse", ""},
+	{"tcp_orphan", "TCP.orphan", "TCP.orphan_max"},    // reset past the limit
+	{"tcp_tw", "TCP.tw", "TCP.tw_max"},                // destroyed early past the limit
+	{"tcp_mem_pages", "TCP.mem", "TCP.mem_max"},       // sends and receives fail past the limit
+	{"files", "files.used", "files.max"},              // ENFILE
+	{"conntrack", "conntrack.count", "conntrack.max"}, // new flows dropped
+	{"arp", "arp_cache.entries", "neigh4.max"},        // IPv4 neighbours
+	{"ndisc", "ndisc_cache.entries", "neigh6.max"},    // IPv6 neighbours
+}
+
+// events are counters of what the kernel did when a table was full.
+// hostmon writes how much each grew since the previous sample.
+var events = []table{
+	{name: "conntrack_drop", key: "nf_conntrack.drop"},                   // a new flow found the table full
+	{name: "conntrack_early_drop", key: "nf_conntrack.early_drop"},       // an unreplied flow evicted for a new one
+	{name: "conntrack_insert_failed", key: "nf_conntrack.insert_failed"}, // a race or clash on insert
+	{name: "arp_table_fulls", key: "arp_cache.table_fulls"},              // "neighbour table overflow"
+	{name: "ndisc_table_fulls", key: "ndisc_cache.table_fulls"},
+}
+
+func monitorHeader() []string {
+	h := []string{"unix_ms"}
+	for _, t := range tables {
+		h = append(h, t.name)
+		if t.limit != "" {
+			h = append(h, t.name+"_max")
+		}
+	}
+	for _, e := range events {
+		h = append(h, e.name)
+	}
+	return h
+}
+
+func monitor(ctx context.Context) error {
+	w := csv.NewWriter(os.Stdout)
+	defer w.Flush()
+	w.Write(monitorHeader())
+
+	prev, err := readProc()
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			cur, err := readProc()
+			if err != nil {
+				return err
+			}
+			row := []string{strconv.FormatInt(now.UnixMilli(), 10)}
+			for _, t := range tables {
+				row = append(row, strconv.FormatUint(cur[t.key], 10))
+				if t.limit != "" {
+					row = append(row, strconv.FormatUint(cur[t.limit], 10))
+				}
+			}
+			for _, e := range events {
+				// A counter that went backwards was reset, by a module
+				// reload for nf_conntrack.
+				d := cur[e.key] - prev[e.key]
+				if cur[e.key] < prev[e.key] {
+					d = cur[e.key]
+				}
+				row = append(row, strconv.FormatUint(d, 10))
+			}
+			w.Write(row)
+			w.Flush()
+			prev = cur
+		}
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *correlateF != "" {
+		if *connectsF == "" {
+			log.Fatal("-correlate needs -connects")
+		}
+		host, err := readHostCSV(*correlateF)
+		if err != nil {
+			log.Fatal(err)
+		}
+		conns, err := readConnectCSV(*connectsF)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printCorrelation(os.Stdout, correlate(host, conns))
+		return
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	if err := monitor(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
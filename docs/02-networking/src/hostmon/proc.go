@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// procRoot is where the proc files are read from; tests point it at a
+// directory of fixtures.
+var procRoot = "/proc"
+
+// parseSockstat parses /proc/net/sockstat and sockstat6, lines such as
+// "TCP: inuse 4 orphan 0 tw 33 alloc 4 mem 226", into "TCP.inuse" keys.
+// "sockets: used 16" becomes "sockets.used".
+func parseSockstat(r io.Reader, out map[string]uint64) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) == 0 {
+			continue
+		}
+		if len(f)%2 != 1 {
+			return fmt.Errorf("sockstat: odd line %q", sc.Text())
+		}
+		prefix := strings.TrimSuffix(f[0], ":")
+		for i := 1; i < len(f); i += 2 {
+			v, err := strconv.ParseUint(f[i+1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("sockstat: %s %s: %v", prefix, f[i], err)
+			}
+			out[prefix+"."+f[i]] = v
+		}
+	}
+	return sc.Err()
+}
+
+// parseStatTable parses a per-CPU statistics table from /proc/net/stat,
+// such as arp_cache or nf_conntrack: a line of column names, then one line
+// of hex values per CPU. The counters are summed over the CPUs into
+// "name.column" keys. "entries" is the table's size, which every line
+// repeats, so it is taken once.
+func parseStatTable(name string, r io.Reader, out map[string]uint64) error {
+	sc := bufio.NewScanner(r)
+	if !sc.Scan() {
+		return fmt.Errorf("%s: empty", name)
+	}
+	cols := strings.Fields(sc.Text())
+	for row := 0; sc.Scan(); row++ {
+		vals := strings.Fields(sc.Text())
+		if len(vals) != len(cols) {
+			return fmt.Errorf("%s: %d values for %d columns", name, len(vals), len(cols))
+		}
+		for i, col := range cols {
+			v, err := strconv.ParseUint(vals[i], 16, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %s: %v", name, col, err)
+			}
+			if col == "entries" && row > 0 {
+				continue
+			}
+			out[name+"."+col] += v
+		}
+	}
+	return sc.Err()
+}
+
+// readProc reads the sample's sources into one map. Files that do not
+// exist, nf_conntrack when the module is not loaded or sockstat6 without
+// IPv6, leave their keys out, which reads as 0.
+func readProc() (map[string]uint64, error) {
+	out := make(map[string]uint64)
+	parse := func(path string, f func(io.Reader) error) error {
+		r, err := os.Open(procRoot + "/" + path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		if err := f(r); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		return nil
+	}
+	// fields stores the whitespace-separated numbers of a one-line file
+	// under the given keys; "" skips a field.
+	fields := func(path string, keys ...string) error {
+		return parse(path, func(r io.Reader) error {
+			b, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			f := strings.Fields(string(b))
+			if len(f) < len(keys) {
+				return fmt.Errorf("%d fields, want %d", len(f), len(keys))
+			}
+			for i, k := range keys {
+				if k == "" {
+					continue
+				}
+				if out[k], err = strconv.ParseUint(f[i], 10, 64); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	sockstat := func(r io.Reader) error { return parseSockstat(r, out) }
+	table := func(name string) func(io.Reader) error {
+		return func(r io.Reader) error { return parseStatTable(name, r, out) }
+	}
+	for _, err := range []error{
+		parse("net/sockstat", sockstat),
+		parse("net/sockstat6", sockstat),
+		parse("net/stat/arp_cache", table("arp_cache")),
+		parse("net/stat/ndisc_cache", table("ndisc_cache")),
+		parse("net/stat/nf_conntrack", table("nf_conntrack")),
+		fields("sys/net/netfilter/nf_conntrack_count", "conntrack.count"),
+		fields("sys/net/netfilter/nf_conntrack_max", "conntrack.max"),
+		fields("sys/net/ipv4/neigh/default/gc_thresh3", "neigh4.max"),
+		fields("sys/net/ipv6/neigh/default/gc_thresh3", "neigh6.max"),
+		fields("sys/net/ipv4/tcp_max_orphans", "TCP.orphan_max"),
+		fields("sys/net/ipv4/tcp_max_tw_buckets", "TCP.tw_max"),
+		fields("sys/net/ipv4/tcp_mem", "", "", "TCP.mem_max"),
+		fields("sys/fs/file-nr", "files.used", "", "files.max"),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,62 @@
+// Package openloop is the load harness of the overload experiments,
+// retrystorm, deadlinehops and limitshift. The load is open-loop: requests
+// start on a schedule, whether or not the earlier ones have finished, the
+// way independent users arrive at a service. A closed loop of N clients
+// would slow down with the server and hide the overload it is meant to
+// show.
+//
+// Run follows the schedule in millisecond steps and hands each program a
+// report once a second, which the program fills in with its own counts.
+package openloop
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Rate is the schedule of a constant load: perSecond requests every
+// second.
+func Rate(perSecond int) func(elapsed time.Duration) int64 {
+	return func(elapsed time.Duration) int64 { return int64(elapsed.Seconds() * float64(perSecond)) }
+}
+
+// Run offers load until ctx is done or it has made seconds reports. Every
+// millisecond it calls due with the time since the start, and starts as
+// many calls of start, each on a goroutine of its own, as bring the total
+// to what due returns. Once a second it calls report with the second's
+// number, counted from 1, and the requests started during it. due and
+// report run on the caller's goroutine, so due may also change the
+// experiment as time passes. Run returns once every start has returned.
+func Run(ctx context.Context, seconds int, due func(elapsed time.Duration) int64, start func(), report func(t int, offered int64)) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	begin := time.Now()
+	tick := time.NewTicker(time.Millisecond)
+	defer tick.Stop()
+	every := time.NewTicker(time.Second)
+	defer every.Stop()
+	var started, offered int64
+	for t := 0; ; {
+		select {
+		case <-ctx.Done():
+			return
+		case <-every.C:
+			t++
+			report(t, offered)
+			offered = 0
+			if t == seconds {
+				return
+			}
+		case <-tick.C:
+			for n := due(time.Since(begin)); started < n; started++ {
+				offered++
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					start()
+				}()
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package openloop
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	var done atomic.Int64
+	var reports []int
+	var offered int64
+	Run(context.Background(), 2, Rate(200), func() {
+		time.Sleep(5 * time.Millisecond)
+		done.Add(1)
+	}, func(t int, n int64) {
+		reports = append(reports, t)
+		offered += n
+	})
+	if len(reports) != 2 || reports[0] != 1 || reports[1] != 2 {
+		t.Fatalf("reports %v, want seconds 1 and 2", reports)
+	}
+	// The report at 2s may come before the last few steps of the
+	// schedule, more so on a busy machine, but never after more.
+	if offered < 360 || offered > 400 {
+		t.Errorf("offered %d in 2s at 200/s", offered)
+	}
+	if done.Load() != offered {
+		t.Errorf("Run returned with %d of %d requests done", done.Load(), offered)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	Run(ctx, 10, Rate(100), func() {}, func(int, int64) { t.Error("report before the first second") })
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Run took %v to stop", d)
+	}
+}
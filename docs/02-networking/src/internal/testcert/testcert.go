@@ -0,0 +1,54 @@
+// Package testcert makes throwaway self-signed certificates for the TLS
+// examples and their tests, so that none of them needs key files on disk.
+// A client trusts one by its pool, or skips verification.
+package testcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// New returns a self-signed ECDSA P-256 certificate for localhost, valid
+// from an hour ago for a day, and a pool that trusts it.
+func New() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, roots, nil
+}
+
+// Must is New for tests and benchmarks: it fails tb if New does.
+func Must(tb testing.TB) (tls.Certificate, *x509.CertPool) {
+	tb.Helper()
+	cert, roots, err := New()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return cert, roots
+}
@@ -0,0 +1,20 @@
+package testcert
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	cert, roots := Must(t)
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	srv := tls.Server(s, &tls.Config{Certificates: []tls.Certificate{cert}})
+	go srv.Handshake()
+	cli := tls.Client(c, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	if err := cli.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+}
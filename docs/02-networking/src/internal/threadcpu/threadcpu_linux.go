@@ -0,0 +1,28 @@
+// Package threadcpu reads the CPU time of the calling OS thread, for
+// benchmarks that report what one thread spent, kernel included, next to
+// throughput: a copy path, a sender, a TLS server, a spinning poller.
+// Throughput alone hides a difference when the peer is the bottleneck.
+//
+// The caller locks its goroutine to its thread with runtime.LockOSThread
+// for the span it measures; otherwise two readings may come from two
+// threads and their difference means nothing.
+package threadcpu
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Now returns the CPU time consumed by the calling OS thread, including
+// time spent in the kernel on its behalf. It fails tb if the clock cannot
+// be read.
+func Now(tb testing.TB) time.Duration {
+	tb.Helper()
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_THREAD_CPUTIME_ID, &ts); err != nil {
+		tb.Fatal(err)
+	}
+	return time.Duration(ts.Nano())
+}
@@ -0,0 +1,20 @@
+package threadcpu
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestNow(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	start := Now(t)
+	for wall := time.Now(); time.Since(wall) < 20*time.Millisecond; {
+	}
+	// The thread spun for 20ms of wall time; on a loaded machine it may
+	// have run for less, but not for nothing, and never for more.
+	if d := Now(t) - start; d <= 0 || d > time.Second {
+		t.Errorf("%v of CPU for 20ms of spinning", d)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCPUList(t *testing.T) {
+	for in, want := range map[string]string{"0-3,8": "0-3,8", "5": "5", "": "-", "1,2,3,7-9": "1-3,7-9"} {
+		c, err := parseCPUList(in)
+		if err != nil || c.String() != want {
+			t.Errorf("parseCPUList(%q) = %v, %v; want %s", in, c, err, want)
+		}
+	}
+	for _, in := range []string{"a", "3-1", "-2"} {
+		if _, err := parseCPUList(in); err == nil {
+			t.Errorf("parseCPUList(%q) accepted", in)
+		}
+	}
+}
+
+func TestCPUMask(t *testing.T) {
+	for in, want := range map[string]string{"f": "0-3", "00000000,0000000c": "2-3", "00000001,00000000": "32", "0": "-"} {
+		c, err := parseCPUMask(in)
+		if err != nil || c.String() != want {
+			t.Errorf("parseCPUMask(%q) = %v, %v; want %s", in, c, err, want)
+		}
+	}
+}
+
+func TestParseStat(t *testing.T) {
+	line := "4242 (echo (epoll) x) S 1 4242 4242 0 -1 4194560 " + strings.Repeat("0 ", 29) + "3 0 0"
+	comm, cpu, err := parseStat(line)
+	if err != nil || comm != "echo (epoll) x" || cpu != 3 {
+		t.Errorf("parseStat = %q, %d, %v", comm, cpu, err)
+	}
+}
+
+func TestKind(t *testing.T) {
+	for name, want := range map[string]string{
+		"eth0-TxRx-3": "rxtx", "mlx5_comp7@pci:0000:3b:00.0": "rxtx", "virtio3-input.0": "rx",
+		"virtio3-output.0": "tx", "ens5-rx-1": "rx", "virtio3-config": "", "eth0": "",
+	} {
+		if got := kind(name); got != want {
+			t.Errorf("kind(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// Four queue pairs on eight CPUs, every interrupt handled on CPU 0.
+const sampleInterrupts = `            CPU0       CPU1       CPU2       CPU3       CPU4       CPU5       CPU6       CPU7
+  24:          1          0          0          0          0          0          0          0  IO-APIC   5-edge      ACPI:Ged
+ 130:          3          0          0          0          0          0          0          0  IR-PCI-MSI 1048576-edge      eth0
+ 131:     900000          0          0          0          0          0          0          0  IR-PCI-MSI 1048577-edge      eth0-TxRx-0
+ 132:     800000          0          0          0          0          0          0          0  IR-PCI-MSI 1048578-edge      eth0-TxRx-1
+ 133:     850000          0          0          0          0          0          0          0  IR-PCI-MSI 1048579-edge      eth0-TxRx-2
+ 134:     700000          0          0          0          0          0          0          0  IR-PCI-MSI 1048580-edge      eth0-TxRx-3
+NMI:          0          0          0          0          0          0          0          0   Non-maskable interrupts
+LOC:    4411940    4411940    4411940    4411940    4411940    4411940    4411940    4411940   Local timer interrupts
+`
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		p := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fixture builds a proc and a sys tree for the NIC above and a server
+// pinned to CPUs 4-5, and returns a function that sets the rx queues'
+// rps_cpus.
+func fixture(t *testing.T) func(mask string) {
+	root := t.TempDir()
+	files := map[string]string{
+		"proc/interrupts":               sampleInterrupts,
+		"sys/devices/system/cpu/online": "0-7\n",
+		"proc/4242/task/4242/stat":      "4242 (echo-epoll) S " + strings.Repeat("0 ", 35) + "4 0\n",
+		"proc/4242/task/4242/status":    "Name:\techo-epoll\nCpus_allowed_list:\t4-5\n",
+		"proc/4242/task/4243/stat":      "4243 (echo-epoll) S " + strings.Repeat("0 ", 35) + "5 0\n",
+		"proc/4242/task/4243/status":    "Name:\techo-epoll\nCpus_allowed_list:\t4-5\n",
+	}
+	for irq := 130; irq <= 134; irq++ {
+		dir := filepath.Join("proc/irq", strconv.Itoa(irq))
+		files[filepath.Join(dir, "smp_affinity_list")] = "0-7\n"
+		files[filepath.Join(dir, "effective_affinity_list")] = "0\n"
+	}
+	pci := "sys/devices/pci0000:00/0000:3b:00.0"
+	files[pci+"/msi_irqs/130"] = "msix\n"
+	for q := range 4 {
+		files["sys/class/net/eth0/queues/rx-"+strconv.Itoa(q)+"/rps_cpus"] = "00\n"
+		files["sys/class/net/eth0/queues/rx-"+strconv.Itoa(q)+"/rps_flow_cnt"] = "0\n"
+		files["sys/class/net/eth0/queues/tx-"+strconv.Itoa(q)+"/xps_cpus"] = fmt.Sprintf("%x\n", 1<<(2*q))
+	}
+	files["sys/class/net/lo/queues/rx-0/rps_cpus"] = "00\n"
+	writeTree(t, root, files)
+	if err := os.Symlink(filepath.Join(root, pci), filepath.Join(root, "sys/class/net/eth0/device")); err != nil {
+		t.Fatal(err)
+	}
+
+	p, s := procRoot, sysRoot
+	t.Cleanup(func() { procRoot, sysRoot = p, s })
+	procRoot, sysRoot = filepath.Join(root, "proc"), filepath.Join(root, "sys")
+	return func(mask string) {
+		for q := range 4 {
+			writeTree(t, root, map[string]string{"sys/class/net/eth0/queues/rx-" + strconv.Itoa(q) + "/rps_cpus": mask})
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	setRPS := fixture(t)
+	defer func(p int) { *pid = p }(*pid)
+	*pid = 4242
+
+	var out bytes.Buffer
+	if err := run(&out); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"server: pid 4242, 2 threads, may run on CPUs 4-5, last ran on 4-5",
+		"131  eth0-TxRx-0  rxtx  0-7       0          0:100%",
+		"tx-2   ",
+		"warning: eth0: all 4 receive queue interrupts are handled on CPU 0",
+		"warning: eth0: packets are processed on CPUs 0 (interrupts) and the server runs on CPUs 4-5",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output lacks %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "\nlo") {
+		t.Error("reported lo, which has no device")
+	}
+
+	// Steering the protocol work to the server's CPUs settles the
+	// mismatch, though not the interrupts all landing on CPU 0.
+	setRPS("30\n")
+	out.Reset()
+	if err := run(&out); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); strings.Contains(got, "packets are processed") || !strings.Contains(got, "all 4 receive queue") {
+		t.Errorf("with rps_cpus 4-5:\n%s", got)
+	}
+}
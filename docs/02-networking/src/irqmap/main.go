@@ -0,0 +1,305 @@
+// Command irqmap shows which CPUs handle a NIC's packets and whether a
+// server's threads run on the same ones.
+//
+// A packet is handled first on the CPU its receive queue's interrupt is
+// sent to: the driver's poll, GRO and, unless RPS moves it elsewhere, the
+// whole TCP/IP receive path up to the socket's queue. The server's thread
+// then wakes and copies the data out. When both run on one core, or on
+// cores that share a cache, the wakeup is local and the data is still in
+// cache. When the server has been pinned (taskset, sched_setaffinity,
+// cpusets) to cores the NIC never interrupts, every packet crosses cores:
+// the wakeup is an inter-processor interrupt and the data arrives cold.
+// Pinning the threads without pinning the queues to match can make a
+// server slower than leaving both to the kernel.
+//
+// irqmap reads /proc/interrupts, /proc/irq/*/ and
+// /sys/class/net/*/queues/ and prints, for each NIC, its queue interrupts
+// with their affinity and the CPUs they actually fired on, and the RPS
+// and XPS settings of its queues. Given a server's pid, or the CPUs it is
+// meant to run on, it adds where the threads may run and last ran, and
+// warns when the packet processing and the server sit on different
+// cores:
+//
+//	go run ./irqmap -pid $(pgrep echo-epoll)
+//	go run ./irqmap -i eth0 -cpus 2-3
+//
+// It changes nothing; the warnings say which file would.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	ifaces  = flag.String("i", "", "Interfaces to report, comma-separated (default: every interface with a device)")
+	pid     = flag.Int("pid", 0, "Server process whose threads are checked against the NIC queues")
+	cpuList = flag.String("cpus", "", "CPUs the server runs on, e.g. 2-3, instead of reading them from -pid")
+)
+
+// irqInfo is a queue interrupt of a NIC.
+type irqInfo struct {
+	irq
+	kind      string // rx, tx or rxtx
+	allowed   *cpus
+	effective *cpus // nil where the kernel does not report it
+}
+
+// nic is what irqmap knows about one interface.
+type nic struct {
+	name, dev string
+	irqs      []irqInfo
+	queues    []queue
+}
+
+func inspect(iface string, all []irq) (*nic, error) {
+	dev, nums, ok := device(iface)
+	n := &nic{name: iface, dev: dev}
+	if ok {
+		for _, q := range all {
+			k := kind(q.name)
+			if k == "" || !owns(q, iface, dev, nums) {
+				continue
+			}
+			allowed, effective, err := affinity(q.num)
+			if err != nil {
+				return nil, err
+			}
+			n.irqs = append(n.irqs, irqInfo{irq: q, kind: k, allowed: allowed, effective: effective})
+		}
+	}
+	var err error
+	n.queues, err = readQueues(iface)
+	return n, err
+}
+
+// rxCPUs returns the CPUs that handle the receive interrupts: those they
+// fired on, or before any has fired, those they are targeted at.
+func (n *nic) rxCPUs() *cpus {
+	c := new(cpus)
+	for _, q := range n.irqs {
+		switch {
+		case q.kind == "tx":
+		case q.total() > 0:
+			c.add(q.fired())
+		case q.effective != nil:
+			c.add(q.effective)
+		default:
+			c.add(q.allowed)
+		}
+	}
+	return c
+}
+
+func (n *nic) rxIRQs() int {
+	k := 0
+	for _, q := range n.irqs {
+		if q.kind != "tx" {
+			k++
+		}
+	}
+	return k
+}
+
+// processing returns the CPUs that run the receive path up to the socket:
+// the RPS CPUs where any queue has them, or the interrupts' CPUs.
+func (n *nic) processing() (c *cpus, rps bool) {
+	c = new(cpus)
+	for _, q := range n.queues {
+		if q.rps != nil {
+			c.add(q.rps)
+		}
+	}
+	if c.Len() > 0 {
+		return c, true
+	}
+	return n.rxCPUs(), false
+}
+
+// check returns warnings about n and a server that may run on server,
+// which is nil when no server was given.
+func check(n *nic, server, all *cpus) []string {
+	var w []string
+	if k := n.rxIRQs(); k > 1 {
+		if rx := n.rxCPUs(); rx.Len() == 1 {
+			w = append(w, fmt.Sprintf("%s: all %d receive queue interrupts are handled on CPU %v, which does the receive work of every queue; "+
+				"spread them with irqbalance or /proc/irq/N/smp_affinity_list, or spread the protocol work with rps_cpus", n.name, k, rx))
+		}
+	}
+	if server == nil || all.subsetOf(server) || n.rxIRQs() == 0 && !n.hasRPS() {
+		return w
+	}
+	proc, rps := n.processing()
+	how := "interrupts"
+	if rps {
+		how = "RPS"
+	}
+	switch {
+	case !proc.intersects(server):
+		w = append(w, fmt.Sprintf("%s: packets are processed on CPUs %v (%s) and the server runs on CPUs %v: "+
+			"every wakeup crosses cores and the data arrives cold in the server's cache. "+
+			"Move the queue interrupts to %v (/proc/irq/N/smp_affinity_list), set rps_cpus of the rx queues to it, or run the server on %v",
+			n.name, proc, how, server, server, proc))
+	case !proc.subsetOf(server):
+		w = append(w, fmt.Sprintf("%s: some packets are processed on CPUs %v (%s), outside the server's CPUs %v; "+
+			"connections whose queue lands there are served across cores", n.name, proc, how, server))
+	}
+	return w
+}
+
+func (n *nic) hasRPS() bool {
+	_, rps := n.processing()
+	return rps
+}
+
+func (n *nic) print(out io.Writer) {
+	fmt.Fprintf(out, "%s", n.name)
+	if n.dev != "" {
+		fmt.Fprintf(out, " (device %s)", n.dev)
+	}
+	fmt.Fprintln(out)
+	if len(n.irqs) == 0 {
+		fmt.Fprintln(out, "  no queue interrupts: a virtual interface, or one whose interrupts irqmap cannot match by name")
+	} else {
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  irq\tname\tkind\taffinity\teffective\tfired on")
+		for _, q := range n.irqs {
+			eff := "n/a"
+			if q.effective != nil {
+				eff = q.effective.String()
+			}
+			fmt.Fprintf(tw, "  %d\t%s\t%s\t%v\t%s\t%s\n", q.num, q.name, q.kind, q.allowed, eff, shares(q.irq))
+		}
+		tw.Flush()
+	}
+	if len(n.queues) > 0 {
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  queue\trps_cpus\trps_flow_cnt\txps_cpus")
+		for _, q := range n.queues {
+			rps, flow, xps := "", "", ""
+			if q.rps != nil {
+				rps, flow = q.rps.String(), q.rpsFlow
+			}
+			if strings.HasPrefix(q.name, "tx-") {
+				xps = "n/a"
+				if q.xps != nil {
+					xps = q.xps.String()
+				}
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", q.name, rps, flow, xps)
+		}
+		tw.Flush()
+	}
+}
+
+// shares lists the CPUs an interrupt fired on with their share of it,
+// such as "0:75% 2:25%".
+func shares(q irq) string {
+	t := q.total()
+	if t == 0 {
+		return "never"
+	}
+	var parts []string
+	for i, n := range q.counts {
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("%d:%.0f%%", i, 100*float64(n)/float64(t)))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// serverCPUs returns the CPUs the server may run on, from -cpus or from
+// its threads, and prints the threads.
+func serverCPUs(out io.Writer) (*cpus, error) {
+	if *cpuList != "" {
+		c, err := parseCPUList(*cpuList)
+		if err == nil {
+			fmt.Fprintf(out, "server: CPUs %v (-cpus)\n", c)
+		}
+		return c, err
+	}
+	if *pid == 0 {
+		return nil, nil
+	}
+	threads, err := readThreads(*pid)
+	if err != nil {
+		return nil, err
+	}
+	allowed, last := new(cpus), new(cpus)
+	for _, t := range threads {
+		allowed.add(t.allowed)
+		last.Add(t.last)
+	}
+	fmt.Fprintf(out, "server: pid %d, %d threads, may run on CPUs %v, last ran on %v\n", *pid, len(threads), allowed, last)
+	return allowed, nil
+}
+
+func run(out io.Writer) error {
+	f, err := os.Open(filepath.Join(procRoot, "interrupts"))
+	if err != nil {
+		return err
+	}
+	irqs, err := parseInterrupts(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	all, err := online()
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	if *ifaces != "" {
+		names = strings.Split(*ifaces, ",")
+	} else {
+		ents, err := os.ReadDir(filepath.Join(sysRoot, "class/net"))
+		if err != nil {
+			return err
+		}
+		for _, e := range ents {
+			if _, _, ok := device(e.Name()); ok {
+				names = append(names, e.Name())
+			}
+		}
+	}
+
+	fmt.Fprintf(out, "online CPUs: %v\n", all)
+	server, err := serverCPUs(out)
+	if err != nil {
+		return err
+	}
+	if server != nil && all.subsetOf(server) {
+		fmt.Fprintln(out, "server is not pinned: the scheduler may run it next to any queue")
+	}
+	var warnings []string
+	for _, name := range names {
+		n, err := inspect(name, irqs)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		fmt.Fprintln(out)
+		n.print(out)
+		warnings = append(warnings, check(n, server, all)...)
+	}
+	if len(warnings) > 0 {
+		fmt.Fprintln(out)
+	}
+	for _, w := range warnings {
+		fmt.Fprintln(out, "warning:", w)
+	}
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	if err := run(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/bitset"
+)
+
+// procRoot and sysRoot are where the proc and sysfs files are read from;
+// tests point them at directories of fixtures.
+var (
+	procRoot = "/proc"
+	sysRoot  = "/sys"
+)
+
+// cpus is a set of CPU numbers.
+type cpus struct{ bitset.Set }
+
+// parseCPUList parses a CPU list such as "0-3,8", the format of
+// smp_affinity_list and Cpus_allowed_list. An empty list is an empty set.
+func parseCPUList(s string) (*cpus, error) {
+	c := new(cpus)
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return c, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("cpu list %q: bad %q", s, part)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil || last < first {
+				return nil, fmt.Errorf("cpu list %q: bad %q", s, part)
+			}
+		}
+		for i := first; i <= last; i++ {
+			c.Add(i)
+		}
+	}
+	return c, nil
+}
+
+// parseCPUMask parses a hex CPU mask such as "00000000,0000000f", the
+// format of rps_cpus and xps_cpus: 32-bit groups, the highest first.
+func parseCPUMask(s string) (*cpus, error) {
+	c := new(cpus)
+	groups := strings.Split(strings.TrimSpace(s), ",")
+	for g := range groups {
+		w, err := strconv.ParseUint(groups[len(groups)-1-g], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("cpu mask %q: %v", s, err)
+		}
+		for b := 0; w != 0; b, w = b+1, w>>1 {
+			if w&1 != 0 {
+				c.Add(32*g + b)
+			}
+		}
+	}
+	return c, nil
+}
+
+// String formats the set as a CPU list, or "-" when it is empty.
+func (c *cpus) String() string {
+	var parts []string
+	for i := c.Next(0); i >= 0; {
+		j := i
+		for c.Has(j + 1) {
+			j++
+		}
+		if j == i {
+			parts = append(parts, strconv.Itoa(i))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", i, j))
+		}
+		i = c.Next(j + 1)
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, ",")
+}
+
+func (c *cpus) add(o *cpus) {
+	for i := o.Next(0); i >= 0; i = o.Next(i + 1) {
+		c.Add(i)
+	}
+}
+
+func (c *cpus) intersects(o *cpus) bool {
+	for i := o.Next(0); i >= 0; i = o.Next(i + 1) {
+		if c.Has(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// subsetOf reports whether every CPU in c is in o.
+func (c *cpus) subsetOf(o *cpus) bool {
+	for i := c.Next(0); i >= 0; i = c.Next(i + 1) {
+		if !o.Has(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// irq is one numbered line of /proc/interrupts.
+type irq struct {
+	num    int
+	counts []uint64 // per CPU
+	name   string   // the action, such as eth0-TxRx-0 or virtio3-input.0
+}
+
+// parseInterrupts parses /proc/interrupts: a header of CPU columns, then a
+// line per interrupt with a count for each CPU, the controller, and the
+// name. Lines for the architecture's own interrupts, NMI or LOC, are
+// skipped.
+func parseInterrupts(r io.Reader) ([]irq, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20) // a line is ~11 bytes per CPU
+	if !sc.Scan() {
+		return nil, errors.New("interrupts: empty")
+	}
+	ncpu := len(strings.Fields(sc.Text()))
+	var out []irq
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) < 2+ncpu {
+			continue
+		}
+		num, err := strconv.Atoi(strings.TrimSuffix(f[0], ":"))
+		if err != nil {
+			continue
+		}
+		q := irq{num: num, counts: make([]uint64, ncpu), name: f[len(f)-1]}
+		for i := range ncpu {
+			if q.counts[i], err = strconv.ParseUint(f[1+i], 10, 64); err != nil {
+				return nil, fmt.Errorf("interrupts: irq %d: %v", num, err)
+			}
+		}
+		out = append(out, q)
+	}
+	return out, sc.Err()
+}
+
+// fired returns the CPUs that handled the interrupt at least once.
+func (q irq) fired() *cpus {
+	c := new(cpus)
+	for i, n := range q.counts {
+		if n > 0 {
+			c.Add(i)
+		}
+	}
+	return c
+}
+
+func (q irq) total() uint64 {
+	var t uint64
+	for _, n := range q.counts {
+		t += n
+	}
+	return t
+}
+
+// kind classifies an interrupt by its name: "rx", "tx", "rxtx" for a
+// queue pair or a completion queue, which most drivers use for both
+// directions, or "" for the rest, such as link or config interrupts.
+func kind(name string) string {
+	n := strings.ToLower(name)
+	rx := strings.Contains(n, "rx") || strings.Contains(n, "input")
+	tx := strings.Contains(n, "tx") || strings.Contains(n, "output")
+	switch {
+	case rx && tx, strings.Contains(n, "comp"):
+		return "rxtx"
+	case rx:
+		return "rx"
+	case tx:
+		return "tx"
+	}
+	return ""
+}
+
+func readFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	return strings.TrimSpace(string(b)), err
+}
+
+// device returns the name of the interface's device, such as virtio3 or
+// 0000:3b:00.0, and the interrupt numbers the device or, for a virtio NIC,
+// its PCI parent owns. A virtual interface has no device.
+func device(iface string) (string, map[int]bool, bool) {
+	dev := filepath.Join(sysRoot, "class/net", iface, "device")
+	target, err := filepath.EvalSymlinks(dev)
+	if err != nil {
+		return "", nil, false
+	}
+	nums := make(map[int]bool)
+	for _, dir := range []string{target, filepath.Dir(target)} {
+		ents, _ := os.ReadDir(filepath.Join(dir, "msi_irqs"))
+		for _, e := range ents {
+			if n, err := strconv.Atoi(e.Name()); err == nil {
+				nums[n] = true
+			}
+		}
+		if len(ents) > 0 {
+			break
+		}
+	}
+	return filepath.Base(target), nums, true
+}
+
+// owns reports whether the interrupt belongs to iface, whose device is
+// dev and owns the MSI interrupts nums. Drivers name their queue
+// interrupts after the interface (eth0-TxRx-0), the driver and device
+// (mlx5_comp0@pci:0000:3b:00.0) or the virtio device (virtio3-input.0);
+// the MSI list covers the ones named otherwise.
+func owns(q irq, iface, dev string, nums map[int]bool) bool {
+	if nums[q.num] {
+		return true
+	}
+	n := q.name
+	return strings.HasPrefix(n, iface+"-") || strings.Contains(n, "-"+iface+"-") ||
+		strings.HasPrefix(n, dev+"-") || strings.HasSuffix(n, "@pci:"+dev)
+}
+
+// queue is one of the interface's RX or TX queues in sysfs.
+type queue struct {
+	name string // rx-0, tx-3
+	// Receive packet steering: the CPUs that run the protocol processing
+	// for packets from this queue, and its flow table. Empty when off.
+	rps     *cpus
+	rpsFlow string
+	// Transmit packet steering: the CPUs whose sends use this queue.
+	// Empty when off; nil when the driver has no XPS.
+	xps *cpus
+}
+
+// readQueues reads the interface's queues, the RX queues first, each in
+// numeric order.
+func readQueues(iface string) ([]queue, error) {
+	dir := filepath.Join(sysRoot, "class/net", iface, "queues")
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []queue
+	for _, e := range ents {
+		q := queue{name: e.Name()}
+		switch {
+		case strings.HasPrefix(q.name, "rx-"):
+			if s, err := readFile(filepath.Join(dir, q.name, "rps_cpus")); err == nil {
+				if q.rps, err = parseCPUMask(s); err != nil {
+					return nil, err
+				}
+			}
+			q.rpsFlow, _ = readFile(filepath.Join(dir, q.name, "rps_flow_cnt"))
+		case strings.HasPrefix(q.name, "tx-"):
+			if s, err := readFile(filepath.Join(dir, q.name, "xps_cpus")); err == nil {
+				if q.xps, err = parseCPUMask(s); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			continue
+		}
+		out = append(out, q)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].name[:2] != out[j].name[:2] {
+			return out[i].name < out[j].name
+		}
+		a, _ := strconv.Atoi(out[i].name[3:])
+		b, _ := strconv.Atoi(out[j].name[3:])
+		return a < b
+	})
+	return out, nil
+}
+
+// affinity returns the interrupt's smp_affinity_list and, where the kernel
+// reports it, effective_affinity_list: the CPUs it may be sent to, and
+// the one or few the interrupt controller actually targets.
+func affinity(num int) (allowed, effective *cpus, err error) {
+	dir := filepath.Join(procRoot, "irq", strconv.Itoa(num))
+	s, err := readFile(filepath.Join(dir, "smp_affinity_list"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if allowed, err = parseCPUList(s); err != nil {
+		return nil, nil, err
+	}
+	s, err = readFile(filepath.Join(dir, "effective_affinity_list"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return allowed, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	effective, err = parseCPUList(s)
+	return allowed, effective, err
+}
+
+// thread is one thread of the server process.
+type thread struct {
+	tid     int
+	comm    string
+	allowed *cpus // Cpus_allowed_list: where it may run
+	last    int   // the CPU it last ran on
+}
+
+// readThreads reads every thread of pid.
+func readThreads(pid int) ([]thread, error) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid), "task")
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []thread
+	for _, e := range ents {
+		tid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := readFile(filepath.Join(dir, e.Name(), "stat"))
+		if err != nil {
+			continue // the thread exited
+		}
+		t := thread{tid: tid}
+		if t.comm, t.last, err = parseStat(stat); err != nil {
+			return nil, err
+		}
+		status, err := readFile(filepath.Join(dir, e.Name(), "status"))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(status, "\n") {
+			if v, ok := strings.CutPrefix(line, "Cpus_allowed_list:"); ok {
+				if t.allowed, err = parseCPUList(v); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if t.allowed == nil {
+			return nil, fmt.Errorf("task %d: no Cpus_allowed_list", tid)
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+// parseStat returns the command name and the processor field (the 39th)
+// of a /proc/<pid>/stat line. The name is in parentheses and may itself
+// hold spaces and parentheses, so the fields are counted from the last
+// ')'.
+func parseStat(s string) (string, int, error) {
+	open, end := strings.IndexByte(s, '('), strings.LastIndexByte(s, ')')
+	if open < 0 || end < open {
+		return "", 0, fmt.Errorf("stat %q: no command name", s)
+	}
+	f := strings.Fields(s[end+1:])
+	const processor = 39 - 3 // fields 1 and 2 are the pid and the name
+	if len(f) <= processor {
+		return "", 0, fmt.Errorf("stat: %d fields", len(f)+2)
+	}
+	cpu, err := strconv.Atoi(f[processor])
+	return s[open+1 : end], cpu, err
+}
+
+// online returns the CPUs that are online.
+func online() (*cpus, error) {
+	s, err := readFile(filepath.Join(sysRoot, "devices/system/cpu/online"))
+	if err != nil {
+		return nil, err
+	}
+	return parseCPUList(s)
+}
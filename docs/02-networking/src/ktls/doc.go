@@ -0,0 +1,29 @@
+// Package ktls terminates TLS 1.3 in the Linux kernel (kTLS). The
+// handshake runs in crypto/tls as usual; then the record keys are handed
+// to the socket with setsockopt(SOL_TLS), and from there the kernel
+// encrypts what is written and decrypts what is read.
+//
+//	conn, err := ktls.Server(tcpConn, tlsConfig)
+//	n, err := conn.Read(buf)  // plaintext, from recvmsg
+//	_, err = conn.ReadFrom(f) // sendfile: the file never enters user space
+//
+// Two things are gained. Reads and writes are one system call each, with
+// no copy through crypto/tls's buffers, and sendfile works again: with
+// crypto/tls, serving a file means reading it into user space to encrypt
+// it. With a NIC that offloads TLS the kernel hands the encryption on to
+// the hardware as well; without one it runs the same AES-GCM in the
+// kernel's crypto code.
+//
+// crypto/tls does not give out its keys, so Server takes the traffic
+// secrets from Config.KeyLogWriter and derives the keys itself. The
+// kernel needs the sequence numbers to start where crypto/tls left off,
+// which holds only if nothing has been read or written under the new keys
+// yet. So session tickets are off, and the handshake reads the socket a
+// record at a time. Anything that needs crypto/tls after the handshake is
+// not supported either: a KeyUpdate from the peer ends the connection
+// with an error.
+//
+// The kernel needs the tls module (CONFIG_TLS). Without it Server fails
+// before touching the connection, with an error wrapping
+// errors.ErrUnsupported, and the caller can fall back to tls.Server.
+package ktls
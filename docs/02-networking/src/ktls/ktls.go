@@ -0,0 +1,147 @@
+package ktls
+
+import (
+	"bytes"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"sync"
+)
+
+// keys are one direction's record protection after the handshake.
+type keys struct {
+	suite uint16
+	key   []byte
+	iv    []byte // 12 bytes, XORed with the record sequence number
+}
+
+// trafficKeys derives the key and IV for suite from a TLS 1.3
+// application traffic secret, as RFC 8446 section 7.3 does.
+func trafficKeys(suite uint16, secret []byte) (keys, error) {
+	var h func() hash.Hash
+	var keyLen int
+	switch suite {
+	case tls.TLS_AES_128_GCM_SHA256:
+		h, keyLen = sha256.New, 16
+	case tls.TLS_AES_256_GCM_SHA384:
+		h, keyLen = sha512.New384, 32
+	case tls.TLS_CHACHA20_POLY1305_SHA256:
+		h, keyLen = sha256.New, 32
+	default:
+		return keys{}, fmt.Errorf("ktls: cipher suite %s: %w", tls.CipherSuiteName(suite), errors.ErrUnsupported)
+	}
+	key, err := hkdf.Expand(h, secret, expandLabel("key", keyLen), keyLen)
+	if err != nil {
+		return keys{}, err
+	}
+	iv, err := hkdf.Expand(h, secret, expandLabel("iv", 12), 12)
+	if err != nil {
+		return keys{}, err
+	}
+	return keys{suite: suite, key: key, iv: iv}, nil
+}
+
+// expandLabel builds the HkdfLabel structure with an empty context.
+func expandLabel(label string, length int) string {
+	label = "tls13 " + label
+	b := []byte{byte(length >> 8), byte(length), byte(len(label))}
+	b = append(b, label...)
+	return string(append(b, 0))
+}
+
+// secrets collects the application traffic secrets crypto/tls reports
+// through Config.KeyLogWriter, which is the only way it gives them out.
+// Each handshake gets its own, so the client random is not checked.
+type secrets struct {
+	mu             sync.Mutex
+	client, server []byte
+	next           io.Writer // the caller's KeyLogWriter, if any
+}
+
+func (s *secrets) Write(line []byte) (int, error) {
+	if s.next != nil {
+		s.next.Write(line)
+	}
+	f := bytes.Fields(line)
+	if len(f) != 3 {
+		return len(line), nil
+	}
+	secret, err := hex.DecodeString(string(f[2]))
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch string(f[0]) {
+	case "CLIENT_TRAFFIC_SECRET_0":
+		s.client = secret
+	case "SERVER_TRAFFIC_SECRET_0":
+		s.server = secret
+	}
+	return len(line), nil
+}
+
+// recordReader hands crypto/tls one record at a time. tls.Conn reads
+// ahead into its own buffer when it can, and anything it read past the
+// client's Finished would be lost to the kernel, which must see the
+// first application record from its start.
+type recordReader struct {
+	net.Conn
+	hdr  [5]byte
+	held []byte // the part of hdr not yet returned
+	left int    // body bytes of the current record not yet read
+}
+
+func (r *recordReader) Read(b []byte) (int, error) {
+	if len(r.held) == 0 && r.left == 0 {
+		if _, err := io.ReadFull(r.Conn, r.hdr[:]); err != nil {
+			return 0, err
+		}
+		r.held = r.hdr[:]
+		r.left = int(r.hdr[3])<<8 | int(r.hdr[4])
+	}
+	if len(r.held) > 0 {
+		n := copy(b, r.held)
+		r.held = r.held[n:]
+		return n, nil
+	}
+	n, err := r.Conn.Read(b[:min(len(b), r.left)])
+	r.left -= n
+	return n, err
+}
+
+// handshake runs a TLS 1.3 server handshake on conn and returns the keys
+// for both directions. Nothing beyond the client's Finished is read, and
+// nothing is written after the server's, so both sequence numbers start
+// at zero.
+func handshake(conn net.Conn, config *tls.Config) (state tls.ConnectionState, rx, tx keys, err error) {
+	s := &secrets{next: config.KeyLogWriter}
+	cfg := config.Clone()
+	cfg.MinVersion, cfg.MaxVersion = tls.VersionTLS13, tls.VersionTLS13
+	cfg.KeyLogWriter = s
+	// A ticket is a record under the new keys, sent after the handshake.
+	cfg.SessionTicketsDisabled = true
+
+	tc := tls.Server(&recordReader{Conn: conn}, cfg)
+	if err = tc.Handshake(); err != nil {
+		return state, rx, tx, err
+	}
+	state = tc.ConnectionState()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil || s.server == nil {
+		return state, rx, tx, errors.New("ktls: handshake did not report its traffic secrets")
+	}
+	if rx, err = trafficKeys(state.CipherSuite, s.client); err != nil {
+		return state, rx, tx, err
+	}
+	tx, err = trafficKeys(state.CipherSuite, s.server)
+	return state, rx, tx, err
+}
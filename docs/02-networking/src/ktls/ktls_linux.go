@@ -0,0 +1,220 @@
+//go:build linux
+
+package ktls
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// From linux/tls.h, which x/sys/unix does not carry.
+const (
+	tlsTX            = 1
+	tlsRX            = 2
+	tlsSetRecordType = 1
+	tlsGetRecordType = 2
+
+	tls13Version           = 0x0304
+	cipherAESGCM128        = 51
+	cipherAESGCM256        = 52
+	cipherChaCha20Poly1305 = 54
+)
+
+// TLS record content types.
+const (
+	recordAlert     = 21
+	recordHandshake = 22
+)
+
+// Conn is a TLS connection whose records the kernel encrypts and
+// decrypts. Reads and writes are plain system calls on the socket, and
+// ReadFrom an *os.File is sendfile(2). Close sends close_notify.
+type Conn struct {
+	tcp    *net.TCPConn
+	raw    syscall.RawConn
+	state  tls.ConnectionState
+	oob    []byte
+	eof    bool
+	closed atomic.Bool
+}
+
+// Server runs a TLS 1.3 server handshake on conn with crypto/tls, then
+// installs the negotiated keys in the kernel and returns a Conn that
+// sends and receives through them. config must allow TLS 1.3, which is
+// all Server negotiates; session tickets are never sent, and its
+// KeyLogWriter, if any, still gets every line.
+//
+// If the kernel has no TLS support, Server fails before anything is read
+// or written, with an error wrapping errors.ErrUnsupported, and conn can
+// still be handed to tls.Server.
+func Server(conn *net.TCPConn, config *tls.Config) (*Conn, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	// The ULP goes on first: it passes bytes through untouched until the
+	// keys are set, and it is how to find out early that the kernel has
+	// no TLS module.
+	if err := setsockopt(raw, unix.IPPROTO_TCP, unix.TCP_ULP, "tls"); err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return nil, fmt.Errorf("ktls: the kernel has no tls module: %w", errors.ErrUnsupported)
+		}
+		return nil, fmt.Errorf("ktls: attaching the tls ULP: %w", err)
+	}
+	state, rx, tx, err := handshake(conn, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := setsockopt(raw, unix.SOL_TLS, tlsTX, string(cryptoInfo(tx))); err != nil {
+		return nil, fmt.Errorf("ktls: setting TLS_TX for %s: %w", tls.CipherSuiteName(tx.suite), err)
+	}
+	if err := setsockopt(raw, unix.SOL_TLS, tlsRX, string(cryptoInfo(rx))); err != nil {
+		return nil, fmt.Errorf("ktls: setting TLS_RX for %s: %w", tls.CipherSuiteName(rx.suite), err)
+	}
+	return &Conn{tcp: conn, raw: raw, state: state, oob: make([]byte, unix.CmsgSpace(1))}, nil
+}
+
+func setsockopt(raw syscall.RawConn, level, opt int, val string) error {
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		serr = unix.SetsockoptString(int(fd), level, opt, val)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// cryptoInfo lays k out as the kernel's tls12_crypto_info_* structures
+// do. For AES-GCM the kernel splits TLS 1.3's 12-byte IV into a 4-byte
+// salt and an 8-byte IV; ChaCha20 takes it whole. The record sequence
+// number is zero in both directions.
+func cryptoInfo(k keys) []byte {
+	b := binary.NativeEndian.AppendUint16(nil, tls13Version)
+	var seq [8]byte
+	switch k.suite {
+	case tls.TLS_CHACHA20_POLY1305_SHA256:
+		b = binary.NativeEndian.AppendUint16(b, cipherChaCha20Poly1305)
+		b = append(b, k.iv...)
+		b = append(b, k.key...)
+	default:
+		cipher := uint16(cipherAESGCM128)
+		if len(k.key) == 32 {
+			cipher = cipherAESGCM256
+		}
+		b = binary.NativeEndian.AppendUint16(b, cipher)
+		b = append(b, k.iv[4:]...)
+		b = append(b, k.key...)
+		b = append(b, k.iv[:4]...)
+	}
+	return append(b, seq[:]...)
+}
+
+// Read returns application data. The kernel returns other records one at
+// a time, marked with their type, and refuses plain reads of them: an
+// alert ends the stream, and a post-handshake message such as KeyUpdate
+// is an error, since the keys it changes are in the kernel.
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.eof {
+		return 0, io.EOF
+	}
+	var n, oobn int
+	var rerr error
+	err := c.raw.Read(func(fd uintptr) bool {
+		n, oobn, _, _, rerr = unix.Recvmsg(int(fd), b, c.oob, 0)
+		return rerr != unix.EAGAIN
+	})
+	if err == nil {
+		err = rerr
+	}
+	if err != nil {
+		return 0, &net.OpError{Op: "read", Net: "tcp", Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: err}
+	}
+	if n == 0 && len(b) > 0 {
+		c.eof = true
+		return 0, io.EOF
+	}
+	switch typ := recordType(c.oob[:oobn]); typ {
+	case 0:
+		return n, nil
+	case recordAlert:
+		c.eof = true
+		if n == 2 && b[1] == 0 { // close_notify
+			return 0, io.EOF
+		}
+		return 0, fmt.Errorf("ktls: peer sent alert %x", b[:n])
+	case recordHandshake:
+		return 0, errors.New("ktls: post-handshake message, which kernel TLS leaves to the application")
+	default:
+		return 0, fmt.Errorf("ktls: unexpected record type %d", typ)
+	}
+}
+
+// recordType finds the TLS_GET_RECORD_TYPE control message, which the
+// kernel adds for anything but application data.
+func recordType(oob []byte) byte {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, m := range msgs {
+		if m.Header.Level == unix.SOL_TLS && m.Header.Type == tlsGetRecordType && len(m.Data) > 0 {
+			return m.Data[0]
+		}
+	}
+	return 0
+}
+
+// Write sends b; the kernel splits it into records of up to 16KiB.
+func (c *Conn) Write(b []byte) (int, error) { return c.tcp.Write(b) }
+
+// ReadFrom sends what r holds. For an *os.File that is one sendfile(2)
+// call per chunk, the file's pages encrypted on their way out without
+// passing through user space, which crypto/tls cannot do.
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) { return c.tcp.ReadFrom(r) }
+
+// Close sends close_notify and closes the connection.
+func (c *Conn) Close() error {
+	if !c.closed.CompareAndSwap(false, true) {
+		return net.ErrClosed
+	}
+	c.alert(0)
+	return c.tcp.Close()
+}
+
+// alert sends a warning-level alert record, best effort.
+func (c *Conn) alert(desc byte) {
+	oob := make([]byte, unix.CmsgSpace(1))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = unix.SOL_TLS
+	h.Type = tlsSetRecordType
+	h.SetLen(unix.CmsgLen(1))
+	oob[unix.CmsgLen(0)] = recordAlert
+	// A peer that stopped reading must not hold up Close.
+	c.tcp.SetWriteDeadline(time.Now().Add(time.Second))
+	c.raw.Write(func(fd uintptr) bool {
+		_, err := unix.SendmsgN(int(fd), []byte{1, desc}, oob, nil, 0)
+		return err != unix.EAGAIN
+	})
+}
+
+// ConnectionState returns what the handshake negotiated.
+func (c *Conn) ConnectionState() tls.ConnectionState { return c.state }
+
+// NetConn returns the TCP connection under c.
+func (c *Conn) NetConn() *net.TCPConn { return c.tcp }
+
+func (c *Conn) LocalAddr() net.Addr                { return c.tcp.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr               { return c.tcp.RemoteAddr() }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.tcp.SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.tcp.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.tcp.SetWriteDeadline(t) }
@@ -0,0 +1,215 @@
+//go:build linux
+
+package ktls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/internal/testcert"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/internal/threadcpu"
+)
+
+// pair returns a crypto/tls client connected to a server end that is
+// either kernel TLS or crypto/tls. It skips when the kernel has no TLS.
+func pair(tb testing.TB, kernel bool) (client *tls.Conn, server net.Conn) {
+	tb.Helper()
+	cert, roots := testcert.Must(tb)
+	cc, sc := tcpPair(tb)
+	client = tls.Client(cc, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	go client.Handshake()
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if !kernel {
+		ts := tls.Server(sc, cfg)
+		if err := ts.Handshake(); err != nil {
+			tb.Fatal(err)
+		}
+		return client, ts
+	}
+	kc, err := Server(sc, cfg)
+	if errors.Is(err, errors.ErrUnsupported) {
+		tb.Skip(err)
+	}
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return client, kc
+}
+
+func TestEcho(t *testing.T) {
+	client, server := pair(t, true)
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	server.SetDeadline(time.Now().Add(10 * time.Second))
+	go io.Copy(server, server)
+
+	// 100KiB is several records each way.
+	for _, size := range []int{5, 100 << 10} {
+		msg := bytes.Repeat([]byte("0123456789"), size/10+1)[:size]
+		go client.Write(msg)
+		got := make([]byte, size)
+		if _, err := io.ReadFull(client, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("%d-byte echo differs", size)
+		}
+	}
+	if s := server.(*Conn).ConnectionState(); s.Version != tls.VersionTLS13 || !s.HandshakeComplete {
+		t.Errorf("state %+v", s)
+	}
+}
+
+func TestCloseNotify(t *testing.T) {
+	client, server := pair(t, true)
+	server.SetDeadline(time.Now().Add(5 * time.Second))
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Client to server: the kernel hands the alert up as a control record.
+	if err := client.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := server.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("server read after close_notify: %d, %v", n, err)
+	}
+	// Server to client: crypto/tls reports a close_notify as io.EOF and an
+	// abrupt close as an error.
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := client.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("client read after close_notify: %d, %v", n, err)
+	}
+}
+
+func TestSendfile(t *testing.T) {
+	client, server := pair(t, true)
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	want := make([]byte, 1<<20+123)
+	for i := range want {
+		want[i] = byte(i * 7)
+	}
+	f := tempFile(t, want)
+	go func() {
+		server.(*Conn).ReadFrom(f)
+		server.Close()
+	}()
+	got, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("received %d bytes, not the file's %d", len(got), len(want))
+	}
+}
+
+func tempFile(tb testing.TB, data []byte) *os.File {
+	tb.Helper()
+	name := filepath.Join(tb.TempDir(), "payload")
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		tb.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { f.Close() })
+	return f
+}
+
+// serve runs step until it fails, on a goroutine locked to its thread,
+// and returns a channel that yields the thread's CPU time once the client
+// closes. The client decrypts in user space
+// either way, so the server's own CPU is where kernel TLS shows.
+func serve(tb testing.TB, server net.Conn, step func() error) <-chan time.Duration {
+	cpu := make(chan time.Duration, 1)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		start := threadcpu.Now(tb)
+		defer func() { cpu <- threadcpu.Now(tb) - start }()
+		for {
+			if err := step(); err != nil {
+				return
+			}
+		}
+	}()
+	return cpu
+}
+
+var modes = []struct {
+	name   string
+	kernel bool
+}{{"crypto-tls", false}, {"ktls", true}}
+
+// BenchmarkEcho times round trips of size bytes through a server that
+// echoes with crypto/tls or with kernel TLS.
+func BenchmarkEcho(b *testing.B) {
+	for _, size := range []int{64, 16 << 10, 256 << 10} {
+		for _, m := range modes {
+			b.Run(fmt.Sprintf("size=%d/%s", size, m.name), func(b *testing.B) {
+				client, server := pair(b, m.kernel)
+				buf := make([]byte, 64<<10)
+				cpu := serve(b, server, func() error {
+					n, err := server.Read(buf)
+					if err != nil {
+						return err
+					}
+					_, err = server.Write(buf[:n])
+					return err
+				})
+				msg, got := make([]byte, size), make([]byte, size)
+				b.SetBytes(int64(size))
+				for b.Loop() {
+					go client.Write(msg)
+					if _, err := io.ReadFull(client, got); err != nil {
+						b.Fatal(err)
+					}
+				}
+				client.Close()
+				b.ReportMetric(float64(<-cpu)/float64(b.N), "server-cpu-ns/op")
+			})
+		}
+	}
+}
+
+// BenchmarkFile times serving a file: crypto/tls reads it into user
+// space and encrypts it there, kernel TLS sends it with sendfile.
+func BenchmarkFile(b *testing.B) {
+	const size = 4 << 20
+	for _, m := range modes {
+		b.Run(m.name, func(b *testing.B) {
+			client, server := pair(b, m.kernel)
+			f := tempFile(b, make([]byte, size))
+			req := make([]byte, 1)
+			cpu := serve(b, server, func() error {
+				if _, err := io.ReadFull(server, req); err != nil {
+					return err
+				}
+				if _, err := f.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+				_, err := io.Copy(server, f)
+				return err
+			})
+			buf := make([]byte, size)
+			b.SetBytes(size)
+			for b.Loop() {
+				client.Write(req)
+				if _, err := io.ReadFull(client, buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+			client.Close()
+			b.ReportMetric(float64(<-cpu)/float64(b.N), "server-cpu-ns/op")
+		})
+	}
+}
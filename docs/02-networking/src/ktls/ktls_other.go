@@ -0,0 +1,33 @@
+//go:build !linux
+
+package ktls
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"time"
+)
+
+var errNoKTLS = fmt.Errorf("ktls: no kernel TLS on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+
+// Conn needs kernel TLS, which only Linux has; Server fails everywhere
+// else.
+type Conn struct{}
+
+func Server(conn *net.TCPConn, config *tls.Config) (*Conn, error) { return nil, errNoKTLS }
+
+func (c *Conn) Read(b []byte) (int, error)           { return 0, errNoKTLS }
+func (c *Conn) Write(b []byte) (int, error)          { return 0, errNoKTLS }
+func (c *Conn) ReadFrom(r io.Reader) (int64, error)  { return 0, errNoKTLS }
+func (c *Conn) Close() error                         { return errNoKTLS }
+func (c *Conn) ConnectionState() tls.ConnectionState { return tls.ConnectionState{} }
+func (c *Conn) NetConn() *net.TCPConn                { return nil }
+func (c *Conn) LocalAddr() net.Addr                  { return nil }
+func (c *Conn) RemoteAddr() net.Addr                 { return nil }
+func (c *Conn) SetDeadline(t time.Time) error        { return errNoKTLS }
+func (c *Conn) SetReadDeadline(t time.Time) error    { return errNoKTLS }
+func (c *Conn) SetWriteDeadline(t time.Time) error   { return errNoKTLS }
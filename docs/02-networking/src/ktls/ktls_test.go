@@ -0,0 +1,137 @@
+package ktls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/tls"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/internal/testcert"
+)
+
+// RFC 8448 section 3: the server's application keys in the simple 1-RTT
+// handshake.
+func TestTrafficKeys(t *testing.T) {
+	secret, _ := hex.DecodeString("a11af9f05531f856ad47116b45a950328204b4f44bfb6b3a4b4f1f3fcb631643")
+	k, err := trafficKeys(tls.TLS_AES_128_GCM_SHA256, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(k.key); got != "9f02283b6c9c07efc26bb9f2ac92e356" {
+		t.Errorf("key %s", got)
+	}
+	if got := hex.EncodeToString(k.iv); got != "cf782b88dd83549aadf1e984" {
+		t.Errorf("iv %s", got)
+	}
+	if _, err := trafficKeys(tls.TLS_RSA_WITH_AES_128_GCM_SHA256, secret); err == nil {
+		t.Error("TLS 1.2 suite accepted")
+	}
+}
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(tb testing.TB) (client, server *net.TCPConn) {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer ln.Close()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	s, err := ln.Accept()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	return c.(*net.TCPConn), s.(*net.TCPConn)
+}
+
+// aead is the cipher the kernel would use for k.
+func aead(tb testing.TB, k keys) cipher.AEAD {
+	tb.Helper()
+	if k.suite == tls.TLS_CHACHA20_POLY1305_SHA256 {
+		tb.Skip("negotiated ChaCha20-Poly1305; the test speaks AES-GCM only")
+	}
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	g, err := cipher.NewGCM(block)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return g
+}
+
+// nonce is the per-record nonce: the IV XORed with the sequence number.
+func nonce(k keys, seq uint64) []byte {
+	n := bytes.Clone(k.iv)
+	for i := range 8 {
+		n[len(n)-1-i] ^= byte(seq >> (8 * i))
+	}
+	return n
+}
+
+// TestHandshakeKeys does by hand what the kernel does with the keys
+// handshake returns: it opens the client's first record and seals one
+// for the client to read. Both only work if the keys are right and no
+// record was sent or consumed under them during the handshake.
+func TestHandshakeKeys(t *testing.T) {
+	cert, roots := testcert.Must(t)
+	client, server := tcpPair(t)
+	tc := tls.Client(client, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	go func() {
+		// The first record right behind the client's Finished.
+		if err := tc.Handshake(); err == nil {
+			tc.Write([]byte("hello"))
+		}
+	}()
+	_, rx, tx, err := handshake(server, &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetDeadline(time.Now().Add(5 * time.Second))
+
+	hdr := make([]byte, 5)
+	if _, err := io.ReadFull(server, hdr); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, int(hdr[3])<<8|int(hdr[4]))
+	if _, err := io.ReadFull(server, body); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := aead(t, rx).Open(nil, nonce(rx, 0), body, hdr)
+	if err != nil {
+		t.Fatalf("opening the client's first record: %v", err)
+	}
+	if want := "hello\x17"; string(plain) != want { // content type application_data
+		t.Errorf("client sent %q", plain)
+	}
+
+	g := aead(t, tx)
+	msg := append([]byte("world"), 0x17)
+	n := len(msg) + g.Overhead()
+	rec := []byte{0x17, 0x03, 0x03, byte(n >> 8), byte(n)}
+	rec = g.Seal(rec, nonce(tx, 0), msg, rec)
+	if _, err := server.Write(rec); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 5)
+	tc.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(tc, got); err != nil {
+		t.Fatalf("client reading the server's first record: %v", err)
+	}
+	if string(got) != "world" {
+		t.Errorf("client read %q", got)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+)
+
+// spin burns CPU in its own frame for a fixed amount of work, so that
+// the ratio between two calls holds however much of the wall clock the
+// scheduler gives the test.
+//
+//go:noinline
+func spin(n int) (x uint64) {
+	for range n {
+		x = x*6364136223846793005 + 1442695040888963407
+	}
+	return x
+}
+
+// TestCPUProfile records a real CPU profile with two labeled phases, three
+// times as much work for "big" as for "small", and checks that grouping by
+// the label recovers them.
+func TestCPUProfile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("needs half a second of CPU")
+	}
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		t.Skip(err) // another profile is running, e.g. go test -cpuprofile
+	}
+	// With spin at 1-2ns an iteration, the two phases take about half a
+	// second between them.
+	const work = 75_000_000
+	pprof.Do(context.Background(), pprof.Labels("size", "big", "conn", "1"), func(context.Context) {
+		spin(3 * work)
+	})
+	pprof.Do(context.Background(), pprof.Labels("size", "small", "conn", "2"), func(context.Context) {
+		spin(work)
+	})
+	pprof.StopCPUProfile()
+
+	p, err := parseProfile(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(p.SampleTypes, ","); got != "samples/count,cpu/nanoseconds" {
+		t.Fatalf("sample types %q", got)
+	}
+
+	groups := group(p, []string{"size"})
+	values := map[string]*groupStats{}
+	for _, g := range groups {
+		values[g.key] = g
+	}
+	big, small := values["size=big"], values["size=small"]
+	if big == nil || small == nil {
+		t.Fatalf("groups %v lack a labeled phase", keys(groups))
+	}
+	if big.value < 2*small.value {
+		t.Errorf("big %v is not about three times small %v", time.Duration(big.value), time.Duration(small.value))
+	}
+	if !hasLeaf(big, "spin") || !hasLeaf(small, "spin") {
+		t.Errorf("leaf functions: big %v, small %v", big.leaves, small.leaves)
+	}
+
+	var out bytes.Buffer
+	report(&out, p, groups, 2)
+	if !strings.Contains(out.String(), "size=big") {
+		t.Errorf("report:\n%s", out.String())
+	}
+	t.Logf("\n%s", out.String())
+
+	// Two keys combine; unlabeled samples fall under "-".
+	for _, g := range group(p, []string{"conn", "size"}) {
+		if g.key != "conn=1 size=big" && g.key != "conn=2 size=small" && g.key != "conn=- size=-" {
+			t.Errorf("unexpected group %q", g.key)
+		}
+	}
+}
+
+func keys(groups []*groupStats) []string {
+	var ks []string
+	for _, g := range groups {
+		ks = append(ks, g.key)
+	}
+	return ks
+}
+
+// hasLeaf reports whether the function called name accounts for most of
+// g's samples.
+func hasLeaf(g *groupStats, name string) bool {
+	return g.leaves["github.com/astavonin/go-optimization-guide/docs/02-networking/src/labelprof."+name] > g.value/2
+}
+
+func TestTruncated(t *testing.T) {
+	for _, in := range [][]byte{{0x12}, {0x12, 0x05, 0x01}, {0x08}} {
+		if _, err := parseProfile(in); err == nil {
+			t.Errorf("parseProfile(%x) succeeded", in)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Command labelprof groups the samples of a pprof profile by label, so a
+// CPU profile taken from a server that labels its handlers (see
+// telemetry.Labeler and telemetry.LabelHTTP) can be read as "where does
+// the CPU go per size class" or "which connections are expensive".
+//
+// Take a profile from a running server and group it:
+//
+//	go run echo-net-trace.go &
+//	curl -o cpu.pb.gz 'http://localhost:6061/debug/pprof/profile?seconds=10'
+//	go run ./labelprof -by size cpu.pb.gz
+//	go run ./labelprof -by conn -limit 10 cpu.pb.gz
+//
+// The profile may also be given as a URL. go tool pprof can filter on one
+// label at a time with -tagfocus; labelprof shows every value side by side
+// along with the hottest leaf functions of each group.
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	byF    = flag.String("by", "size", "Comma-separated label keys to group by")
+	limitF = flag.Int("limit", 0, "Show only the N heaviest groups (0 shows all)")
+	funcsF = flag.Int("funcs", 3, "Leaf functions to list per group")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: labelprof [-by keys] [-limit n] [-funcs n] profile|URL")
+		os.Exit(2)
+	}
+	data, err := load(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	p, err := parseProfile(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	groups := group(p, strings.Split(*byF, ","))
+	if *limitF > 0 && len(groups) > *limitF {
+		groups = groups[:*limitF]
+	}
+	report(os.Stdout, p, groups, *funcsF)
+}
+
+func load(src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return os.ReadFile(src)
+	}
+	resp, err := http.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", src, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// groupStats is the total of one label combination.
+type groupStats struct {
+	key     string
+	samples int64
+	value   int64 // last sample value: CPU nanoseconds in a CPU profile
+	leaves  map[string]int64
+}
+
+// group sums samples by the values of keys, heaviest group first. Samples
+// missing a key are grouped under "-" for it.
+func group(p *profile, keys []string) []*groupStats {
+	byKey := map[string]*groupStats{}
+	var parts []string
+	for _, s := range p.Samples {
+		parts = parts[:0]
+		for _, k := range keys {
+			v, ok := s.Labels[k]
+			if !ok {
+				v = "-"
+			}
+			parts = append(parts, k+"="+v)
+		}
+		key := strings.Join(parts, " ")
+		g := byKey[key]
+		if g == nil {
+			g = &groupStats{key: key, leaves: map[string]int64{}}
+			byKey[key] = g
+		}
+		var v int64
+		if len(s.Values) > 0 {
+			v = s.Values[len(s.Values)-1]
+		}
+		if len(s.Values) > 1 {
+			g.samples += s.Values[0]
+		} else {
+			g.samples++
+		}
+		g.value += v
+		g.leaves[s.Leaf] += v
+	}
+	out := make([]*groupStats, 0, len(byKey))
+	for _, g := range byKey {
+		out = append(out, g)
+	}
+	slices.SortFunc(out, func(a, b *groupStats) int {
+		if c := cmp.Compare(b.value, a.value); c != 0 {
+			return c
+		}
+		return strings.Compare(a.key, b.key)
+	})
+	return out
+}
+
+func report(w io.Writer, p *profile, groups []*groupStats, nfuncs int) {
+	var total int64
+	for _, s := range p.Samples {
+		if len(s.Values) > 0 {
+			total += s.Values[len(s.Values)-1]
+		}
+	}
+	unit := ""
+	if n := len(p.SampleTypes); n > 0 {
+		unit = p.SampleTypes[n-1]
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "group\tsamples\t%s\tshare\t\n", unit)
+	for _, g := range groups {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%.1f%%\t\n", g.key, g.samples, formatValue(g.value, unit), 100*float64(g.value)/float64(max(total, 1)))
+	}
+	tw.Flush()
+
+	if nfuncs <= 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	for _, g := range groups {
+		type leaf struct {
+			name string
+			v    int64
+		}
+		leaves := make([]leaf, 0, len(g.leaves))
+		for name, v := range g.leaves {
+			leaves = append(leaves, leaf{name, v})
+		}
+		slices.SortFunc(leaves, func(a, b leaf) int { return cmp.Compare(b.v, a.v) })
+		fmt.Fprintf(w, "%s:\n", g.key)
+		for _, l := range leaves[:min(nfuncs, len(leaves))] {
+			fmt.Fprintf(w, "  %5.1f%%  %s\n", 100*float64(l.v)/float64(max(g.value, 1)), l.name)
+		}
+	}
+}
+
+func formatValue(v int64, unit string) string {
+	if strings.HasSuffix(unit, "/nanoseconds") {
+		return time.Duration(v).Round(time.Millisecond).String()
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// profile is the part of a pprof profile.proto that grouping by label
+// needs. The format is documented in
+// https://github.com/google/pprof/blob/main/proto/profile.proto; decoding
+// it here keeps the command free of dependencies.
+type profile struct {
+	SampleTypes []string // "type/unit", one per value in each sample
+	Samples     []sample
+}
+
+type sample struct {
+	Values []int64
+	Labels map[string]string
+	Leaf   string // innermost function, or "?" if unknown
+}
+
+// Field numbers from profile.proto.
+const (
+	fProfileSampleType  = 1
+	fProfileSample      = 2
+	fProfileLocation    = 4
+	fProfileFunction    = 5
+	fProfileStringTable = 6
+
+	fValueTypeType = 1
+	fValueTypeUnit = 2
+
+	fSampleLocationID = 1
+	fSampleValue      = 2
+	fSampleLabel      = 3
+
+	fLabelKey = 1
+	fLabelStr = 2
+
+	fLocationID   = 1
+	fLocationLine = 4
+	fLineFunction = 1
+
+	fFunctionID   = 1
+	fFunctionName = 2
+)
+
+// rawSample keeps string table indexes until the whole profile is read;
+// the string table may come after the samples.
+type rawSample struct {
+	locs   []uint64
+	values []int64
+	labels [][2]int64 // key, str
+}
+
+// parseProfile decodes a profile, gzipped as written by runtime/pprof or
+// not.
+func parseProfile(data []byte) (*profile, error) {
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		strs     []string
+		types    [][2]int64
+		raws     []rawSample
+		locFunc  = map[uint64]uint64{} // location -> innermost function
+		funcName = map[uint64]int64{}
+	)
+	err := fields(data, func(num int, wire int, v uint64, b []byte) error {
+		switch num {
+		case fProfileStringTable:
+			strs = append(strs, string(b))
+		case fProfileSampleType:
+			var t [2]int64
+			err := fields(b, func(num, _ int, v uint64, _ []byte) error {
+				switch num {
+				case fValueTypeType:
+					t[0] = int64(v)
+				case fValueTypeUnit:
+					t[1] = int64(v)
+				}
+				return nil
+			})
+			types = append(types, t)
+			return err
+		case fProfileSample:
+			var s rawSample
+			err := fields(b, func(num, wire int, v uint64, b []byte) error {
+				switch num {
+				case fSampleLocationID:
+					return repeated(wire, v, b, func(x uint64) { s.locs = append(s.locs, x) })
+				case fSampleValue:
+					return repeated(wire, v, b, func(x uint64) { s.values = append(s.values, int64(x)) })
+				case fSampleLabel:
+					var l [2]int64
+					err := fields(b, func(num, _ int, v uint64, _ []byte) error {
+						switch num {
+						case fLabelKey:
+							l[0] = int64(v)
+						case fLabelStr:
+							l[1] = int64(v)
+						}
+						return nil
+					})
+					s.labels = append(s.labels, l)
+					return err
+				}
+				return nil
+			})
+			raws = append(raws, s)
+			return err
+		case fProfileLocation:
+			var id, fn uint64
+			err := fields(b, func(num, _ int, v uint64, b []byte) error {
+				switch num {
+				case fLocationID:
+					id = v
+				case fLocationLine:
+					// Lines are listed innermost first; inlined
+					// callers follow.
+					if fn == 0 {
+						return fields(b, func(num, _ int, v uint64, _ []byte) error {
+							if num == fLineFunction {
+								fn = v
+							}
+							return nil
+						})
+					}
+				}
+				return nil
+			})
+			locFunc[id] = fn
+			return err
+		case fProfileFunction:
+			var id uint64
+			var name int64
+			err := fields(b, func(num, _ int, v uint64, _ []byte) error {
+				switch num {
+				case fFunctionID:
+					id = v
+				case fFunctionName:
+					name = int64(v)
+				}
+				return nil
+			})
+			funcName[id] = name
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	str := func(i int64) string {
+		if i < 0 || i >= int64(len(strs)) {
+			return ""
+		}
+		return strs[i]
+	}
+	p := &profile{}
+	for _, t := range types {
+		p.SampleTypes = append(p.SampleTypes, str(t[0])+"/"+str(t[1]))
+	}
+	for _, r := range raws {
+		s := sample{Values: r.values, Leaf: "?"}
+		if len(r.labels) > 0 {
+			s.Labels = make(map[string]string, len(r.labels))
+			for _, l := range r.labels {
+				s.Labels[str(l[0])] = str(l[1])
+			}
+		}
+		if len(r.locs) > 0 {
+			if fn, ok := locFunc[r.locs[0]]; ok {
+				if name := str(funcName[fn]); name != "" {
+					s.Leaf = name
+				}
+			}
+		}
+		p.Samples = append(p.Samples, s)
+	}
+	return p, nil
+}
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("labelprof: truncated profile")
+
+// fields calls f for every field in the message b. For varints v holds the
+// value; for length-delimited fields b holds the payload.
+func fields(b []byte, f func(num, wire int, v uint64, b []byte) error) error {
+	for len(b) > 0 {
+		key, n := uvarint(b)
+		if n == 0 {
+			return errTruncated
+		}
+		b = b[n:]
+		num, wire := int(key>>3), int(key&7)
+		var v uint64
+		var payload []byte
+		switch wire {
+		case wireVarint:
+			if v, n = uvarint(b); n == 0 {
+				return errTruncated
+			}
+			b = b[n:]
+		case wireBytes:
+			l, n := uvarint(b)
+			if n == 0 || uint64(len(b)-n) < l {
+				return errTruncated
+			}
+			payload, b = b[n:n+int(l)], b[n+int(l):]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			b = b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			b = b[4:]
+		default:
+			return fmt.Errorf("labelprof: unsupported wire type %d", wire)
+		}
+		if err := f(num, wire, v, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repeated handles a repeated scalar field, which encoders may write
+// packed (one length-delimited run of varints) or as separate varints.
+func repeated(wire int, v uint64, b []byte, add func(uint64)) error {
+	if wire == wireVarint {
+		add(v)
+		return nil
+	}
+	for len(b) > 0 {
+		x, n := uvarint(b)
+		if n == 0 {
+			return errTruncated
+		}
+		add(x)
+		b = b[n:]
+	}
+	return nil
+}
+
+// uvarint is binary.Uvarint with every malformed input reported as n == 0.
+func uvarint(b []byte) (uint64, int) {
+	x, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, 0
+	}
+	return x, n
+}
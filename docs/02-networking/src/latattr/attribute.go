@@ -0,0 +1,188 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/telemetry"
+)
+
+// Stages a request's latency is split into. They add up to the total the
+// client sees, measured from the time the request was due.
+const (
+	stageClient  = iota // due -> written: load generator running late
+	stageNetwork        // both kernels, the wire, and waking the readers
+	stageQueue          // decoded -> picked up by a worker
+	stageService        // picked up -> reply ready
+	numStages
+)
+
+var stageNames = [numStages]string{"client", "network", "queue", "service"}
+
+// clientRecord is the load generator's view of one request. Times are
+// relative to the start of the run.
+type clientRecord struct {
+	ID       uint64
+	Intended time.Duration // when the schedule said to send it
+	Sent     time.Duration // when the write started
+	Recv     time.Duration // when the reply was decoded
+}
+
+// serverRecord is the server's view of one request.
+type serverRecord struct {
+	ID    uint64
+	Conn  int           // server connection index, for TCP_INFO samples
+	Read  time.Duration // request decoded
+	Start time.Duration // picked up by a worker
+	Done  time.Duration // reply encoded, about to be written
+}
+
+// request is one request seen from both ends.
+type request struct {
+	clientRecord
+	Conn              int
+	Read, Start, Done time.Duration
+}
+
+// Total is the latency the client observed.
+func (r *request) Total() time.Duration { return r.Recv - r.Intended }
+
+// Stages splits Total. Read follows the client's write and Done precedes
+// the server's, so the network share is never negative.
+func (r *request) Stages() [numStages]time.Duration {
+	return [numStages]time.Duration{
+		stageClient:  r.Sent - r.Intended,
+		stageNetwork: (r.Read - r.Sent) + (r.Recv - r.Done),
+		stageQueue:   r.Start - r.Read,
+		stageService: r.Done - r.Start,
+	}
+}
+
+// join pairs client and server records by request ID. Requests only one
+// side saw, such as those cut off at shutdown, are counted in unmatched.
+func join(cl []clientRecord, sv []serverRecord) (reqs []request, unmatched int) {
+	byID := make(map[uint64]*serverRecord, len(sv))
+	for i := range sv {
+		byID[sv[i].ID] = &sv[i]
+	}
+	reqs = make([]request, 0, len(cl))
+	for _, c := range cl {
+		s, ok := byID[c.ID]
+		if !ok {
+			unmatched++
+			continue
+		}
+		delete(byID, c.ID)
+		reqs = append(reqs, request{clientRecord: c, Conn: s.Conn, Read: s.Read, Start: s.Start, Done: s.Done})
+	}
+	slices.SortFunc(reqs, func(a, b request) int { return cmp.Compare(a.Intended, b.Intended) })
+	return reqs, unmatched + len(byID)
+}
+
+// tcpSample is one TCP_INFO reading of a server connection.
+type tcpSample struct {
+	At      time.Duration
+	Conn    int
+	RTT     time.Duration // smoothed RTT, including the peer's ACK delay
+	RTTVar  time.Duration
+	MinRTT  time.Duration // lowest RTT seen, closest to the bare path
+	Unacked uint32        // segments sent but not yet acknowledged
+	Retrans uint32        // retransmitted segments over the connection's life
+}
+
+// breakdown attributes latency to stages over all requests and over the
+// tail.
+type breakdown struct {
+	N         int
+	Quantiles [numStages + 1][]time.Duration // per stage, then total
+	Cutoff    time.Duration                  // total at the tail quantile
+	Tail      int                            // requests at or above Cutoff
+	Share     [numStages]float64             // fraction of tail time per stage
+	Dominant  [numStages]int                 // tail requests where the stage is largest
+	SRTT      []time.Duration                // kernel smoothed RTT at quantiles
+	MinRTT    []time.Duration                // kernel minimum RTT at quantiles
+	Retrans   int                            // tail requests whose connection retransmitted meanwhile
+}
+
+// reportQuantiles are the columns of the stage table.
+var reportQuantiles = []float64{0.5, 0.9, 0.99, 0.999, 1}
+
+// attribute computes the breakdown, treating requests at or above the
+// tailQ quantile of total latency as the tail.
+func attribute(reqs []request, samples []tcpSample, tailQ float64) breakdown {
+	b := breakdown{N: len(reqs)}
+	if len(reqs) == 0 {
+		return b
+	}
+	var cols [numStages + 1][]time.Duration
+	for i := range cols {
+		cols[i] = make([]time.Duration, len(reqs))
+	}
+	for i := range reqs {
+		st := reqs[i].Stages()
+		for s, d := range st {
+			cols[s][i] = d
+		}
+		cols[numStages][i] = reqs[i].Total()
+	}
+	for i := range cols {
+		b.Quantiles[i] = telemetry.Quantiles(cols[i], reportQuantiles...)
+	}
+	b.Cutoff = telemetry.Quantiles(cols[numStages], tailQ)[0]
+
+	byConn := make(map[int][]tcpSample)
+	for _, s := range samples {
+		byConn[s.Conn] = append(byConn[s.Conn], s)
+	}
+	var tailTotal time.Duration
+	var tailStages [numStages]time.Duration
+	for i := range reqs {
+		r := &reqs[i]
+		if r.Total() < b.Cutoff {
+			continue
+		}
+		b.Tail++
+		tailTotal += r.Total()
+		st := r.Stages()
+		top := 0
+		for s, d := range st {
+			tailStages[s] += d
+			if d > st[top] {
+				top = s
+			}
+		}
+		b.Dominant[top]++
+		if retransmitted(byConn[r.Conn], r.Intended, r.Recv) {
+			b.Retrans++
+		}
+	}
+	if tailTotal > 0 {
+		for s := range tailStages {
+			b.Share[s] = float64(tailStages[s]) / float64(tailTotal)
+		}
+	}
+
+	if len(samples) > 0 {
+		srtt := make([]time.Duration, len(samples))
+		minRTT := make([]time.Duration, len(samples))
+		for i, s := range samples {
+			srtt[i], minRTT[i] = s.RTT, s.MinRTT
+		}
+		b.SRTT = telemetry.Quantiles(srtt, reportQuantiles...)
+		b.MinRTT = telemetry.Quantiles(minRTT, reportQuantiles...)
+	}
+	return b
+}
+
+// retransmitted reports whether the retransmit counter in samples, which
+// are in time order, grew between the last reading before from and the
+// first reading after to. Without readings on both sides it reports false.
+func retransmitted(samples []tcpSample, from, to time.Duration) bool {
+	i, _ := slices.BinarySearchFunc(samples, from, func(s tcpSample, t time.Duration) int { return cmp.Compare(s.At, t) })
+	j, _ := slices.BinarySearchFunc(samples, to, func(s tcpSample, t time.Duration) int { return cmp.Compare(s.At, t) })
+	if i == 0 || j == len(samples) {
+		return false
+	}
+	return samples[j].Retrans > samples[i-1].Retrans
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"net"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+)
+
+// client is the open-loop load generator. Each connection sends on a fixed
+// schedule whether or not earlier replies have arrived and stamps every
+// request with the time it was due, so a stalled server shows up as
+// latency rather than as fewer requests (coordinated omission). The gap
+// between due and actually written is reported as its own stage, so a
+// generator that cannot keep up is visible too.
+type client struct {
+	base  time.Time
+	ids   atomic.Uint64
+	conns []*clientConn
+	wg    sync.WaitGroup
+}
+
+type clientConn struct {
+	cc *codec.Conn
+
+	mu       sync.Mutex
+	inflight map[uint64][2]time.Duration // intended, sent
+	records  []clientRecord
+}
+
+// dialClient opens n connections to addr and starts sending on each every
+// interval, with start times spread over the first interval.
+func dialClient(ctx context.Context, addr string, n, size int, interval time.Duration, base time.Time) (*client, error) {
+	cl := &client{base: base}
+	for range n {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			cl.Close()
+			return nil, err
+		}
+		c, _ := codec.New("line", 0)
+		cl.conns = append(cl.conns, &clientConn{cc: codec.NewConn(conn, c, 0), inflight: make(map[uint64][2]time.Duration)})
+	}
+	for i, c := range cl.conns {
+		phase := interval * time.Duration(i) / time.Duration(n)
+		cl.wg.Add(2)
+		go pprof.Do(ctx, pprof.Labels("stage", "loadgen"), func(ctx context.Context) {
+			defer cl.wg.Done()
+			cl.send(ctx, c, size, time.Now().Add(phase), interval)
+		})
+		go pprof.Do(ctx, pprof.Labels("stage", "loadgen"), func(context.Context) {
+			defer cl.wg.Done()
+			cl.receive(c)
+		})
+	}
+	return cl, nil
+}
+
+func (cl *client) send(ctx context.Context, c *clientConn, size int, next time.Time, interval time.Duration) {
+	var payload []byte
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		id := cl.ids.Add(1)
+		sent := time.Since(cl.base)
+		c.mu.Lock()
+		c.inflight[id] = [2]time.Duration{next.Sub(cl.base), sent}
+		c.mu.Unlock()
+
+		payload = strconv.AppendUint(payload[:0], id, 10)
+		payload = append(payload, ' ')
+		for len(payload) < size-1 {
+			payload = append(payload, 'x')
+		}
+		if err := c.cc.Send(codec.Message{Payload: payload}); err != nil {
+			return
+		}
+		if err := c.cc.Flush(); err != nil {
+			return
+		}
+		// Requests that are already late go out back to back.
+		next = next.Add(interval)
+		timer.Reset(time.Until(next))
+	}
+}
+
+func (cl *client) receive(c *clientConn) {
+	for {
+		msgs, err := c.cc.Next()
+		if err != nil {
+			return
+		}
+		recv := time.Since(cl.base)
+		c.mu.Lock()
+		for _, m := range msgs {
+			id, ok := parseID(m.Payload)
+			if !ok {
+				continue
+			}
+			if t, ok := c.inflight[id]; ok {
+				delete(c.inflight, id)
+				c.records = append(c.records, clientRecord{ID: id, Intended: t[0], Sent: t[1], Recv: recv})
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// inflight returns the number of requests still waiting for a reply.
+func (cl *client) inflight() int {
+	n := 0
+	for _, c := range cl.conns {
+		c.mu.Lock()
+		n += len(c.inflight)
+		c.mu.Unlock()
+	}
+	return n
+}
+
+// Close closes every connection, waits for the senders and receivers to
+// stop and returns the completed requests.
+func (cl *client) Close() []clientRecord {
+	for _, c := range cl.conns {
+		c.cc.Close()
+	}
+	cl.wg.Wait()
+	var out []clientRecord
+	for _, c := range cl.conns {
+		out = append(out, c.records...)
+	}
+	return out
+}
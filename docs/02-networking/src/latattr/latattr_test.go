@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const us = time.Microsecond
+
+func TestStages(t *testing.T) {
+	r := request{
+		clientRecord: clientRecord{ID: 1, Intended: 100 * us, Sent: 110 * us, Recv: 400 * us},
+		Read:         150 * us, Start: 250 * us, Done: 300 * us,
+	}
+	st := r.Stages()
+	want := [numStages]time.Duration{stageClient: 10 * us, stageNetwork: 140 * us, stageQueue: 100 * us, stageService: 50 * us}
+	if st != want {
+		t.Fatalf("stages %v, want %v", st, want)
+	}
+	var sum time.Duration
+	for _, d := range st {
+		sum += d
+	}
+	if sum != r.Total() {
+		t.Errorf("stages add up to %v, total is %v", sum, r.Total())
+	}
+}
+
+func TestJoin(t *testing.T) {
+	cl := []clientRecord{{ID: 2, Intended: 20}, {ID: 1, Intended: 10}, {ID: 3, Intended: 30}}
+	sv := []serverRecord{{ID: 1, Conn: 7}, {ID: 2, Conn: 8}, {ID: 4}}
+	reqs, unmatched := join(cl, sv)
+	if len(reqs) != 2 || reqs[0].ID != 1 || reqs[0].Conn != 7 || reqs[1].ID != 2 || reqs[1].Conn != 8 {
+		t.Errorf("joined %+v", reqs)
+	}
+	if unmatched != 2 {
+		t.Errorf("unmatched %d, want 2 (3 on the client, 4 on the server)", unmatched)
+	}
+}
+
+// queued returns a request on conn that spends q in the server queue and
+// 10us in every other stage.
+func queued(id uint64, conn int, at, q time.Duration) request {
+	return request{
+		clientRecord: clientRecord{ID: id, Intended: at, Sent: at + 10*us, Recv: at + 40*us + q},
+		Conn:         conn,
+		Read:         at + 15*us, Start: at + 15*us + q, Done: at + 25*us + q,
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	var reqs []request
+	for i := range 100 {
+		q := time.Duration(0)
+		if i%10 == 0 {
+			q = time.Millisecond // the slowest tenth waited for a worker
+		}
+		reqs = append(reqs, queued(uint64(i), i%2, time.Duration(i)*time.Millisecond, q))
+	}
+	samples := []tcpSample{
+		{At: 0, Conn: 0, Retrans: 0},
+		{At: 0, Conn: 1, Retrans: 0},
+		{At: 35 * time.Millisecond, Conn: 0, Retrans: 0},
+		{At: 45 * time.Millisecond, Conn: 0, Retrans: 1},
+		{At: 45 * time.Millisecond, Conn: 1, Retrans: 3},
+		{At: time.Second, Conn: 0, Retrans: 1},
+		{At: time.Second, Conn: 1, Retrans: 3},
+	}
+	b := attribute(reqs, samples, 0.95)
+	if b.N != 100 || b.Tail != 10 {
+		t.Fatalf("n %d tail %d, want 100 and 10", b.N, b.Tail)
+	}
+	if b.Dominant[stageQueue] != 10 {
+		t.Errorf("queue dominates %d tail requests, want 10", b.Dominant[stageQueue])
+	}
+	if b.Share[stageQueue] < 0.9 {
+		t.Errorf("queue share of the tail %.2f, want > 0.9", b.Share[stageQueue])
+	}
+	// Tail requests are on conn 0. Only the one sent at 40ms spans its
+	// retransmit; the one at 0ms has no reading before it.
+	if b.Retrans != 1 {
+		t.Errorf("%d tail requests overlap a retransmit, want 1", b.Retrans)
+	}
+	if got := b.Quantiles[numStages][0]; got != 40*us {
+		t.Errorf("median total %v, want 40us", got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the experiment")
+	}
+	cfg := config{
+		Conns: 4, Rate: 400, Duration: 300 * time.Millisecond, Size: 32,
+		Workers: 1, Queue: 16, Service: 10 * us, TCPInfo: 20 * time.Millisecond,
+	}
+	res, err := run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Requests) < 60 {
+		t.Fatalf("%d requests, want about 120", len(res.Requests))
+	}
+	if res.Unmatched > cfg.Conns {
+		t.Errorf("%d unmatched, want at most one per connection", res.Unmatched)
+	}
+	for _, r := range res.Requests {
+		var sum time.Duration
+		for s, d := range r.Stages() {
+			if d < 0 {
+				t.Fatalf("request %d: %s stage %v", r.ID, stageNames[s], d)
+			}
+			sum += d
+		}
+		if sum != r.Total() {
+			t.Fatalf("request %d: stages add up to %v, total %v", r.ID, sum, r.Total())
+		}
+		if r.Stages()[stageService] < cfg.Service {
+			t.Fatalf("request %d: service %v, want >= %v", r.ID, r.Stages()[stageService], cfg.Service)
+		}
+	}
+	if runtime.GOOS == "linux" && len(res.TCP) == 0 {
+		t.Error("no TCP_INFO samples")
+	}
+
+	var out strings.Builder
+	report(&out, res, attribute(res.Requests, res.TCP, 0.99), 0.99)
+	for _, want := range []string{"network", "queue", "service", "tail:"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+}
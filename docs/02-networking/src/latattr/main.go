@@ -0,0 +1,317 @@
+// Command latattr runs an end-to-end tail latency attribution experiment.
+// It starts an instrumented echo server and an open-loop load generator in
+// one process, samples TCP_INFO on the server's connections while the load
+// runs, and joins what both ends recorded into a per-request breakdown:
+//
+//	client   due -> written: the generator itself running late
+//	network  both kernels, the wire, and the scheduler waking the readers
+//	queue    decoded -> picked up by one of the server's workers
+//	service  picked up -> reply ready
+//
+// The stages add up to the latency the client saw, so the report can say
+// which one the slowest requests spent their time in:
+//
+//	go run ./latattr -conns 100 -rate 20000 -workers 2 -service 50us
+//
+// With -out the run also leaves everything needed to dig further: a
+// runtime trace with one task per request, a CPU profile whose samples are
+// labeled stage=read, service or loadgen (split it with labelprof), the
+// joined requests and the TCP_INFO samples as CSV:
+//
+//	go run ./latattr -out /tmp/run
+//	go run ./labelprof -by stage /tmp/run/cpu.pprof
+//	go tool trace -pprof=sched /tmp/run/trace.out > sched.pprof
+//
+// As root, -rtt shapes loopback with netem so the network stage has
+// something to show.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/metrics"
+	"runtime/pprof"
+	"runtime/trace"
+	"text/tabwriter"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/netem"
+)
+
+// config describes one experiment.
+type config struct {
+	Conns    int
+	Rate     float64       // requests per second over all connections
+	Duration time.Duration // how long to send
+	Size     int           // request size in bytes, including the newline
+	Workers  int
+	Queue    int           // server queue capacity
+	Service  time.Duration // CPU time per request
+	TCPInfo  time.Duration // TCP_INFO sampling interval
+}
+
+// result is everything both ends recorded.
+type result struct {
+	Requests  []request
+	Unmatched int
+	TCP       []tcpSample
+	Sched     *metrics.Float64Histogram // scheduling latency while sending
+}
+
+// drainTimeout bounds how long run waits for replies to requests sent just
+// before the end.
+const drainTimeout = 2 * time.Second
+
+func run(ctx context.Context, cfg config) (*result, error) {
+	if cfg.Conns <= 0 || cfg.Rate <= 0 {
+		return nil, fmt.Errorf("need at least one connection and a positive rate")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	base := time.Now()
+	srv := newServer(ln, base, max(cfg.Workers, 1), cfg.Queue, cfg.Service)
+
+	sendCtx, stop := context.WithTimeout(ctx, cfg.Duration)
+	defer stop()
+	interval := time.Duration(float64(cfg.Conns) / cfg.Rate * float64(time.Second))
+	cl, err := dialClient(sendCtx, ln.Addr().String(), cfg.Conns, cfg.Size, interval, base)
+	if err != nil {
+		srv.Close()
+		return nil, err
+	}
+
+	samples := sampleTCP(sendCtx, srv, base, cfg.TCPInfo)
+	sched := readSched()
+	<-sendCtx.Done()
+	sched = subHist(readSched(), sched)
+	for deadline := time.Now().Add(drainTimeout); cl.inflight() > 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cr := cl.Close()
+	sr := srv.Close()
+	res := &result{TCP: <-samples, Sched: sched}
+	res.Requests, res.Unmatched = join(cr, sr)
+	return res, nil
+}
+
+// sampleTCP reads TCP_INFO from every server connection each interval
+// until ctx is done, then sends the samples, in time order, on the
+// returned channel.
+func sampleTCP(ctx context.Context, srv *server, base time.Time, interval time.Duration) <-chan []tcpSample {
+	out := make(chan []tcpSample, 1)
+	if interval <= 0 {
+		out <- nil
+		return out
+	}
+	go func() {
+		var samples []tcpSample
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			at := time.Since(base)
+			for _, c := range srv.snapshotConns() {
+				if s, ok := readTCPInfo(c.conn); ok {
+					s.At, s.Conn = at, c.index
+					samples = append(samples, s)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				out <- samples
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+	return out
+}
+
+// report prints the stage table and the tail attribution.
+func report(w io.Writer, res *result, b breakdown, tailQ float64) {
+	fmt.Fprintf(w, "requests=%d unmatched=%d tcp_info samples=%d\n\n", b.N, res.Unmatched, len(res.TCP))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "stage\tp50\tp90\tp99\tp99.9\tmax\ttail share\ttail dominant\t")
+	for s := range numStages + 1 {
+		name, share, dom := "total", "", ""
+		if s < numStages {
+			name = stageNames[s]
+			share = fmt.Sprintf("%.1f%%", 100*b.Share[s])
+			dom = fmt.Sprint(b.Dominant[s])
+		}
+		fmt.Fprintf(tw, "%s\t", name)
+		for _, d := range b.Quantiles[s] {
+			fmt.Fprintf(tw, "%v\t", d.Round(time.Microsecond))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t\n", share, dom)
+	}
+	// The kernel's RTT for comparison with the network stage. The smoothed
+	// RTT of request/response traffic includes however long the client
+	// held its ACK, often until its next request; the minimum does not.
+	for _, row := range []struct {
+		name string
+		q    []time.Duration
+	}{{"kernel srtt", b.SRTT}, {"kernel min rtt", b.MinRTT}} {
+		if row.q == nil {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t", row.name)
+		for _, d := range row.q {
+			fmt.Fprintf(tw, "%v\t", d)
+		}
+		fmt.Fprintln(tw, "\t\t")
+	}
+	tw.Flush()
+	if res.Sched != nil {
+		fmt.Fprintf(w, "\ngoroutine scheduling latency p50/p99/p99.9: %v/%v/%v",
+			histQuantile(res.Sched, 0.5), histQuantile(res.Sched, 0.99), histQuantile(res.Sched, 0.999))
+	}
+	fmt.Fprintf(w, "\ntail: %d requests at or above p%g = %v", b.Tail, 100*tailQ, b.Cutoff.Round(time.Microsecond))
+	if res.TCP != nil {
+		fmt.Fprintf(w, ", %d overlapping a retransmit", b.Retrans)
+	}
+	fmt.Fprintln(w)
+}
+
+// writeRequests writes the joined requests as CSV, in microseconds since
+// the start of the run.
+func writeRequests(path string, reqs []request) error {
+	return writeCSV(path, "id,conn,intended_us,sent_us,read_us,start_us,done_us,recv_us", func(w *bufio.Writer) {
+		for _, r := range reqs {
+			fmt.Fprintf(w, "%d,%d,%d,%d,%d,%d,%d,%d\n", r.ID, r.Conn,
+				r.Intended.Microseconds(), r.Sent.Microseconds(), r.Read.Microseconds(),
+				r.Start.Microseconds(), r.Done.Microseconds(), r.Recv.Microseconds())
+		}
+	})
+}
+
+func writeTCP(path string, samples []tcpSample) error {
+	return writeCSV(path, "at_us,conn,rtt_us,rttvar_us,min_rtt_us,unacked,retrans", func(w *bufio.Writer) {
+		for _, s := range samples {
+			fmt.Fprintf(w, "%d,%d,%d,%d,%d,%d,%d\n", s.At.Microseconds(), s.Conn,
+				s.RTT.Microseconds(), s.RTTVar.Microseconds(), s.MinRTT.Microseconds(), s.Unacked, s.Retrans)
+		}
+	})
+}
+
+func writeCSV(path, header string, rows func(*bufio.Writer)) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, header)
+	rows(w)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// startArtifacts starts the runtime trace and the CPU profile in dir and
+// returns a function that stops both.
+func startArtifacts(dir string) (stop func(), err error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	tf, err := os.Create(filepath.Join(dir, "trace.out"))
+	if err != nil {
+		return nil, err
+	}
+	pf, err := os.Create(filepath.Join(dir, "cpu.pprof"))
+	if err != nil {
+		tf.Close()
+		return nil, err
+	}
+	if err := trace.Start(tf); err != nil {
+		tf.Close()
+		pf.Close()
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(pf); err != nil {
+		trace.Stop()
+		tf.Close()
+		pf.Close()
+		return nil, err
+	}
+	return func() {
+		pprof.StopCPUProfile()
+		trace.Stop()
+		pf.Close()
+		tf.Close()
+	}, nil
+}
+
+func main() {
+	cfg := config{}
+	flag.IntVar(&cfg.Conns, "conns", 100, "Client connections")
+	flag.Float64Var(&cfg.Rate, "rate", 10000, "Requests per second over all connections")
+	flag.DurationVar(&cfg.Duration, "duration", 10*time.Second, "How long to send")
+	flag.IntVar(&cfg.Size, "size", 64, "Request size in bytes, including the newline")
+	flag.IntVar(&cfg.Workers, "workers", runtime.GOMAXPROCS(0), "Server worker goroutines")
+	flag.IntVar(&cfg.Queue, "queue", 1024, "Server queue capacity")
+	flag.DurationVar(&cfg.Service, "service", 20*time.Microsecond, "CPU time spent per request")
+	flag.DurationVar(&cfg.TCPInfo, "tcpinfo", 50*time.Millisecond, "TCP_INFO sampling interval (0 disables)")
+	tailQ := flag.Float64("tail", 0.99, "Quantile of total latency from which a request counts as tail")
+	out := flag.String("out", "", "Directory for trace.out, cpu.pprof, requests.csv and tcpinfo.csv")
+	rtt := flag.Duration("rtt", 0, "Shape loopback to this RTT with netem (needs root)")
+	flag.Parse()
+
+	// fatal also takes the netem qdisc off loopback, which log.Fatal
+	// alone would leave behind.
+	fatal := log.Fatal
+	if *rtt > 0 {
+		if err := netem.Available(); err != nil {
+			log.Fatal(err)
+		}
+		restore, err := netem.Apply("lo", netem.ForRTT(*rtt))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer restore()
+		fatal = func(v ...any) {
+			restore()
+			log.Fatal(v...)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	stopArtifacts := func() {}
+	if *out != "" {
+		var err error
+		if stopArtifacts, err = startArtifacts(*out); err != nil {
+			fatal(err)
+		}
+	}
+	log.Printf("%d connections, %.0f req/s for %v, %d workers, %v service", cfg.Conns, cfg.Rate, cfg.Duration, cfg.Workers, cfg.Service)
+	res, err := run(ctx, cfg)
+	stopArtifacts()
+	if err != nil {
+		fatal(err)
+	}
+
+	report(os.Stdout, res, attribute(res.Requests, res.TCP, *tailQ), *tailQ)
+	if *out != "" {
+		if err := writeRequests(filepath.Join(*out, "requests.csv"), res.Requests); err != nil {
+			fatal(err)
+		}
+		if err := writeTCP(filepath.Join(*out, "tcpinfo.csv"), res.TCP); err != nil {
+			fatal(err)
+		}
+		log.Printf("wrote trace.out, cpu.pprof, requests.csv and tcpinfo.csv to %s", *out)
+	}
+}
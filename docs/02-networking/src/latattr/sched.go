@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"runtime/metrics"
+	"time"
+)
+
+// The network stage also holds the time a reader goroutine was runnable
+// but not running. The runtime's scheduling latency histogram covers
+// every goroutine rather than one request, but shows how large that part
+// can be.
+const schedMetric = "/sched/latencies:seconds"
+
+func readSched() *metrics.Float64Histogram {
+	s := []metrics.Sample{{Name: schedMetric}}
+	metrics.Read(s)
+	return s[0].Value.Float64Histogram()
+}
+
+// subHist returns a-b for two readings of the same cumulative histogram.
+func subHist(a, b *metrics.Float64Histogram) *metrics.Float64Histogram {
+	d := &metrics.Float64Histogram{Buckets: a.Buckets, Counts: make([]uint64, len(a.Counts))}
+	for i := range a.Counts {
+		d.Counts[i] = a.Counts[i] - b.Counts[i]
+	}
+	return d
+}
+
+// histQuantile returns the upper bound of the bucket holding quantile q,
+// or 0 for an empty histogram. The last bucket is unbounded, so its lower
+// bound is returned instead.
+func histQuantile(h *metrics.Float64Histogram, q float64) time.Duration {
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	var seen uint64
+	for i, c := range h.Counts {
+		seen += c
+		if seen >= rank {
+			v := h.Buckets[i+1]
+			if math.IsInf(v, 1) {
+				v = h.Buckets[i]
+			}
+			return time.Duration(v * float64(time.Second))
+		}
+	}
+	return time.Duration(h.Buckets[len(h.Buckets)-1] * float64(time.Second))
+}
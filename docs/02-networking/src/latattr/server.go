@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"net"
+	"runtime/pprof"
+	"runtime/trace"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+)
+
+// server is the echo server under test. A reader goroutine per connection
+// decodes requests and hands them to a fixed pool of workers over a
+// bounded channel, so waiting for a worker is a stage of its own instead
+// of being hidden inside the handler. When the channel is full readers
+// block and requests wait in the socket buffers, which is counted as
+// network time.
+type server struct {
+	ln      net.Listener
+	base    time.Time
+	service time.Duration
+	jobs    chan job
+
+	accepting chan struct{} // closed when accept returns
+	readers   sync.WaitGroup
+	workers   sync.WaitGroup
+
+	mu      sync.Mutex
+	conns   []*serverConn
+	records []serverRecord
+}
+
+type serverConn struct {
+	index int
+	conn  net.Conn
+	cc    *codec.Conn
+
+	wmu sync.Mutex // workers share the write side
+}
+
+type job struct {
+	c       *serverConn
+	id      uint64
+	payload []byte
+	read    time.Duration
+	ctx     context.Context
+	task    *trace.Task
+}
+
+func newServer(ln net.Listener, base time.Time, workers, queue int, service time.Duration) *server {
+	s := &server{ln: ln, base: base, service: service, jobs: make(chan job, queue), accepting: make(chan struct{})}
+	s.workers.Add(workers)
+	for range workers {
+		go pprof.Do(context.Background(), pprof.Labels("stage", "service"), func(context.Context) {
+			s.work()
+		})
+	}
+	go s.accept()
+	return s
+}
+
+func (s *server) accept() {
+	defer close(s.accepting)
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		c, _ := codec.New("line", 0)
+		s.mu.Lock()
+		sc := &serverConn{index: len(s.conns), conn: conn, cc: codec.NewConn(conn, c, 0)}
+		s.conns = append(s.conns, sc)
+		s.mu.Unlock()
+
+		s.readers.Add(1)
+		go pprof.Do(context.Background(), pprof.Labels("stage", "read", "conn", strconv.Itoa(sc.index)), func(context.Context) {
+			defer s.readers.Done()
+			s.read(sc)
+		})
+	}
+}
+
+// read decodes requests and queues them. Each request becomes a trace
+// task, so go tool trace shows its queueing and service regions.
+func (s *server) read(c *serverConn) {
+	defer c.cc.Close()
+	for {
+		msgs, err := c.cc.Next()
+		if err != nil {
+			return
+		}
+		now := time.Since(s.base)
+		for _, m := range msgs {
+			id, ok := parseID(m.Payload)
+			if !ok {
+				return
+			}
+			ctx, task := trace.NewTask(context.Background(), "request")
+			trace.Log(ctx, "id", strconv.FormatUint(id, 10))
+			s.jobs <- job{c: c, id: id, payload: append([]byte(nil), m.Payload...), read: now, ctx: ctx, task: task}
+		}
+	}
+}
+
+func (s *server) work() {
+	defer s.workers.Done()
+	var records []serverRecord
+	for j := range s.jobs {
+		start := time.Since(s.base)
+		trace.WithRegion(j.ctx, "service", func() { spin(j.payload, s.service) })
+		done := time.Since(s.base)
+
+		j.c.wmu.Lock()
+		j.c.cc.Send(codec.Message{Payload: j.payload})
+		err := j.c.cc.Flush()
+		j.c.wmu.Unlock()
+		j.task.End()
+		if err == nil {
+			records = append(records, serverRecord{ID: j.id, Conn: j.c.index, Read: j.read, Start: start, Done: done})
+		}
+	}
+	s.mu.Lock()
+	s.records = append(s.records, records...)
+	s.mu.Unlock()
+}
+
+// snapshotConns returns the connections accepted so far.
+func (s *server) snapshotConns() []*serverConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.conns)
+}
+
+// Close stops accepting, closes every connection and waits for the queue
+// to drain. It returns the records of every request answered.
+func (s *server) Close() []serverRecord {
+	s.ln.Close()
+	<-s.accepting
+	for _, c := range s.snapshotConns() {
+		c.cc.Close()
+	}
+	s.readers.Wait()
+	close(s.jobs)
+	s.workers.Wait()
+	return s.records
+}
+
+// spin stands in for request handling: it hashes the payload for d, so
+// the time shows up in CPU profiles under stage=service.
+func spin(p []byte, d time.Duration) {
+	sum := sha256.Sum256(p)
+	for start := time.Now(); time.Since(start) < d; {
+		sum = sha256.Sum256(sum[:])
+	}
+}
+
+// parseID reads the decimal request ID that starts every payload.
+func parseID(p []byte) (uint64, bool) {
+	var id uint64
+	n := 0
+	for ; n < len(p) && p[n] >= '0' && p[n] <= '9'; n++ {
+		id = id*10 + uint64(p[n]-'0')
+	}
+	return id, n > 0
+}
@@ -0,0 +1,38 @@
+//go:build linux
+
+package main
+
+import (
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// readTCPInfo samples the kernel's view of conn: its smoothed RTT and how
+// much it has had to retransmit.
+func readTCPInfo(conn net.Conn) (tcpSample, bool) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return tcpSample{}, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return tcpSample{}, false
+	}
+	var info *unix.TCPInfo
+	raw.Control(func(fd uintptr) {
+		info, err = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if info == nil || err != nil {
+		return tcpSample{}, false
+	}
+	return tcpSample{
+		RTT:     time.Duration(info.Rtt) * time.Microsecond,
+		RTTVar:  time.Duration(info.Rttvar) * time.Microsecond,
+		MinRTT:  time.Duration(info.Min_rtt) * time.Microsecond,
+		Unacked: info.Unacked,
+		Retrans: info.Total_retrans,
+	}, true
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// readTCPInfo is Linux only; elsewhere the report leaves out the kernel's
+// RTT and retransmits.
+func readTCPInfo(net.Conn) (tcpSample, bool) { return tcpSample{}, false }
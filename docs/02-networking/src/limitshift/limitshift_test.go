@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+)
+
+// TestShift checks the experiment's claim on a small scale: when capacity
+// drops, a static limit sized for the healthy server lets the queue grow
+// until nearly every request times out, while the gradient limit keeps
+// most of the reduced capacity serving requests in time.
+//
+// It runs the limiters against simulate, which models the server on a
+// fake clock, so the outcome does not depend on how busy the machine is.
+func TestShift(t *testing.T) {
+	cfg := config{Rate: 300, Workers: []int{12, 3}, Phase: 2 * time.Second,
+		Service: 20 * time.Millisecond, Timeout: 100 * time.Millisecond, Static: 24}
+	degraded := func(mode string) (ok, fail int64) {
+		c := cfg
+		c.Mode = mode
+		if err := c.validate(); err != nil {
+			t.Fatal(err)
+		}
+		s := simulate(c)[3] // the last second at 3 workers
+		t.Logf("%s: %+v", mode, s)
+		return s.OK, s.Fail
+	}
+	// 3 workers serve 150 requests a second.
+	if ok, fail := degraded("static"); ok > 50 || fail < 100 {
+		t.Errorf("static: %d ok, %d timed out in the degraded phase, want most timing out", ok, fail)
+	}
+	if ok, fail := degraded("gradient"); ok < 100 || fail > 20 {
+		t.Errorf("gradient: %d ok, %d timed out in the degraded phase, want most of 150 ok", ok, fail)
+	}
+}
+
+// simReq is a request admitted to the simulated server.
+type simReq struct {
+	start, end time.Time // end is zero until it is served
+	done       func(ok bool)
+	timedOut   bool
+}
+
+// simulate runs cfg a millisecond at a time on a fake clock against a
+// model of the server: cfg.Rate arrivals a second, a limiter that sheds or
+// admits each, an unbounded queue in front of the phase's workers, and
+// service that finishes requests whose clients timed out. It returns one
+// second per element, counted as run counts them.
+func simulate(cfg config) []second {
+	clk := clock.NewFake(time.Unix(0, 0))
+	lim := cfg.limiter(clk)
+	start := clk.Now()
+	var queue, busy []*simReq
+	var secs []second
+	var cur second
+	var started int64
+	end := time.Duration(len(cfg.Workers)) * cfg.Phase
+	for el := time.Duration(0); el < end; {
+		workers := cfg.Workers[min(int(el/cfg.Phase), len(cfg.Workers)-1)]
+		now := clk.Now()
+		// Arrivals due by now.
+		for due := int64(el.Seconds() * float64(cfg.Rate)); started < due; started++ {
+			cur.Offered++
+			r := &simReq{start: now, done: func(bool) {}}
+			if lim != nil {
+				done, err := lim.Acquire()
+				if err != nil {
+					cur.Shed++
+					continue
+				}
+				r.done = done
+			}
+			queue = append(queue, r)
+		}
+		// Requests whose service ends now, in time or not.
+		rest := busy[:0]
+		for _, r := range busy {
+			if now.Before(r.end) {
+				rest = append(rest, r)
+				continue
+			}
+			if !r.timedOut {
+				cur.OK++
+			}
+			r.done(!r.timedOut)
+		}
+		busy = rest
+		// Workers freed take the oldest requests.
+		for len(busy) < workers && len(queue) > 0 {
+			r := queue[0]
+			queue = queue[1:]
+			r.end = now.Add(cfg.Service)
+			busy = append(busy, r)
+		}
+		// Clients give up on what has not been served by their timeout.
+		for _, rs := range [][]*simReq{queue, busy} {
+			for _, r := range rs {
+				if !r.timedOut && now.Sub(r.start) >= cfg.Timeout && (r.end.IsZero() || r.end.After(now)) {
+					r.timedOut = true
+					cur.Fail++
+				}
+			}
+		}
+
+		clk.Advance(time.Millisecond)
+		el = clk.Now().Sub(start)
+		if el%time.Second == 0 {
+			cur.T, cur.Workers, cur.Queue = len(secs)+1, workers, len(queue)
+			if lim != nil {
+				cur.Limit = lim.Limit()
+			}
+			secs = append(secs, cur)
+			cur = second{}
+		}
+	}
+	return secs
+}
+
+// TestRun runs the experiment itself briefly, over HTTP and the system
+// clock, and checks only that it reports every second of every phase.
+func TestRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs for seconds")
+	}
+	c := config{Mode: "gradient", Rate: 100, Workers: []int{4, 2}, Phase: time.Second,
+		Service: 10 * time.Millisecond, Timeout: 100 * time.Millisecond, Static: 8}
+	var got []second
+	if err := run(context.Background(), c, func(s second) { got = append(got, s) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Workers != 4 || got[1].Workers != 2 {
+		t.Fatalf("seconds %+v, want one at 4 workers and one at 2", got)
+	}
+	for _, s := range got {
+		if s.Offered == 0 || s.OK+s.Shed+s.Fail == 0 {
+			t.Errorf("second %+v: nothing offered or finished", s)
+		}
+	}
+}
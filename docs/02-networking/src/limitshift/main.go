@@ -0,0 +1,95 @@
+// Command limitshift compares a static concurrency limit with adaptive ones
+// from the conclimit package while the capacity behind them changes.
+//
+// It starts an HTTP server whose worker count follows -workers, one entry
+// per -phase, each request taking -service, so with the defaults its
+// capacity drops from 800 to 200 requests per second for the middle phase
+// and comes back. Requests beyond the workers queue, and the server
+// serves a request even after its client has given up on it. A client
+// offers -rate requests per second with a -timeout on each:
+//
+//	go run ./limitshift -mode gradient
+//
+// -mode picks what stands in front of the server:
+//
+//	none      nothing: every request joins the queue
+//	static    a fixed limit of -static requests in flight
+//	aimd      an adaptive limit that backs off when requests time out
+//	gradient  an adaptive limit that backs off when latency rises
+//
+// Every second it prints the requests that succeeded, were shed with a
+// 503 or timed out, the latency of the successful ones, the limit and the
+// server's queue, and the work the server did for clients that had
+// already left (wasted). A summary per phase follows.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func main() {
+	var cfg config
+	var workers string
+	flag.StringVar(&cfg.Mode, "mode", "gradient", "Limiter: "+strings.Join(modes, ", "))
+	flag.IntVar(&cfg.Rate, "rate", 500, "Requests per second")
+	flag.StringVar(&workers, "workers", "16,4,16", "Server workers in each phase, comma separated")
+	flag.DurationVar(&cfg.Phase, "phase", 5*time.Second, "Length of each phase")
+	flag.DurationVar(&cfg.Service, "service", 20*time.Millisecond, "Time the server spends on a request")
+	flag.DurationVar(&cfg.Timeout, "timeout", 100*time.Millisecond, "Client timeout per request")
+	flag.IntVar(&cfg.Static, "static", 32, "Requests in flight for -mode static")
+	flag.Parse()
+	for _, f := range strings.Split(workers, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bad -workers: %v\n", err)
+			os.Exit(2)
+		}
+		cfg.Workers = append(cfg.Workers, n)
+	}
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	fmt.Printf("mode=%s rate=%d/s workers=%s per %v service=%v timeout=%v\n",
+		cfg.Mode, cfg.Rate, workers, cfg.Phase, cfg.Service, cfg.Timeout)
+	type phase struct {
+		workers             int
+		ok, shed, fail, was int64
+		worstP99            time.Duration
+	}
+	var phases []phase
+	err := run(ctx, cfg, func(s second) {
+		fmt.Printf("t=%-3d workers=%-3d ok=%-4d shed=%-4d timeout=%-4d p50=%-7v p99=%-7v limit=%-4d queue=%-4d wasted=%d\n",
+			s.T, s.Workers, s.OK, s.Shed, s.Fail, s.P50.Round(100*time.Microsecond), s.P99.Round(100*time.Microsecond),
+			s.Limit, s.Queue, s.Wasted)
+		if len(phases) == 0 || phases[len(phases)-1].workers != s.Workers {
+			phases = append(phases, phase{workers: s.Workers})
+		}
+		p := &phases[len(phases)-1]
+		p.ok += s.OK
+		p.shed += s.Shed
+		p.fail += s.Fail
+		p.was += s.Wasted
+		p.worstP99 = max(p.worstP99, s.P99)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range phases {
+		fmt.Printf("workers=%-3d ok=%-5d shed=%-5d timeout=%-5d wasted=%-5d worst p99=%v\n",
+			p.workers, p.ok, p.shed, p.fail, p.was, p.worstP99.Round(100*time.Microsecond))
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/conclimit"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/internal/openloop"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/telemetry"
+)
+
+var modes = []string{"none", "static", "aimd", "gradient"}
+
+// config is one run, as set by the flags.
+type config struct {
+	Mode    string
+	Rate    int           // requests per second
+	Workers []int         // the server's workers in each phase
+	Phase   time.Duration // length of a phase
+	Service time.Duration
+	Timeout time.Duration // per request
+	Static  int           // the limit for -mode static
+}
+
+func (c *config) validate() error {
+	switch {
+	case !slices.Contains(modes, c.Mode):
+		return fmt.Errorf("unknown -mode %q", c.Mode)
+	case c.Rate <= 0 || c.Phase <= 0 || len(c.Workers) == 0:
+		return errors.New("-rate, -phase and -workers must be positive")
+	case slices.Min(c.Workers) <= 0:
+		return errors.New("-workers must be positive")
+	case c.Service <= 0 || c.Timeout <= 0 || c.Static <= 0:
+		return errors.New("-service, -timeout and -static must be positive")
+	}
+	return nil
+}
+
+// limiter returns the limiter for c.Mode, timing requests on clk, or nil
+// for none.
+func (c *config) limiter(clk clock.Clock) conclimit.Limiter {
+	switch c.Mode {
+	case "static":
+		return conclimit.NewStatic(c.Static)
+	case "aimd":
+		return conclimit.NewAdaptive(conclimit.Config{Algorithm: &conclimit.AIMD{}, Clock: clk})
+	case "gradient":
+		return conclimit.NewAdaptive(conclimit.Config{Algorithm: &conclimit.Gradient{}, Clock: clk})
+	}
+	return nil
+}
+
+// second is one line of the report: the server's workers during second
+// T, and how the requests that finished in it ended. OK got a 200, Shed
+// the limiter's 503, and Fail timed out; P50 and P99 are over the OK
+// ones. Limit and Queue are the limiter's limit and the requests waiting
+// for a worker at the end of the second, and Wasted counts requests the
+// server served after their client had given up.
+type second struct {
+	T, Workers              int
+	Offered, OK, Shed, Fail int64
+	P50, P99                time.Duration
+	Limit, Queue            int
+	Wasted                  int64
+}
+
+// run offers cfg.Rate requests per second to a fresh server for one
+// cfg.Phase per entry in cfg.Workers, moving the server to each phase's
+// workers on time, and calls onSecond once a second.
+func run(ctx context.Context, cfg config, onSecond func(second)) error {
+	lim := cfg.limiter(clock.Real)
+	srv, err := listen(slices.Max(cfg.Workers), cfg.Service, lim)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+	c := &http.Client{Transport: &http.Transport{
+		// The server writes nothing until the request has been served, so
+		// this is the timeout of the whole request.
+		ResponseHeaderTimeout: cfg.Timeout,
+		MaxIdleConns:          4096,
+		MaxIdleConnsPerHost:   4096,
+	}}
+	defer c.CloseIdleConnections()
+	url := srv.URL()
+
+	var mu sync.Mutex
+	var cur second
+	var lat []time.Duration
+	do := func() {
+		start := time.Now()
+		resp, err := c.Get(url)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		d := time.Since(start)
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			cur.Fail++
+		case resp.StatusCode == http.StatusServiceUnavailable:
+			cur.Shed++
+		case resp.StatusCode != http.StatusOK:
+			cur.Fail++
+		default:
+			cur.OK++
+			lat = append(lat, d)
+		}
+	}
+
+	seconds := int((time.Duration(len(cfg.Workers))*cfg.Phase + time.Second - 1) / time.Second)
+	phase := 0
+	srv.SetWorkers(cfg.Workers[0])
+	rate := openloop.Rate(cfg.Rate)
+	var wasted int64
+	openloop.Run(ctx, seconds, func(el time.Duration) int64 {
+		if p := min(int(el/cfg.Phase), len(cfg.Workers)-1); p != phase {
+			phase = p
+			srv.SetWorkers(cfg.Workers[p])
+		}
+		return rate(el)
+	}, do, func(t int, offered int64) {
+		mu.Lock()
+		s := cur
+		q := telemetry.Quantiles(lat, 0.5, 0.99)
+		cur, lat = second{}, lat[:0]
+		mu.Unlock()
+		// Label the second with the phase it started in.
+		s.T, s.Workers = t, cfg.Workers[min(int(time.Duration(t-1)*time.Second/cfg.Phase), len(cfg.Workers)-1)]
+		s.Offered = offered
+		s.P50, s.P99 = q[0], q[1]
+		s.Queue = int(srv.queued.Load())
+		if lim != nil {
+			s.Limit = lim.Limit()
+		}
+		w := srv.wasted.Load()
+		s.Wasted, wasted = w-wasted, w
+		onSecond(s)
+	})
+	return nil
+}
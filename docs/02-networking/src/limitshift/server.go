@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/conclimit"
+)
+
+// server is an HTTP service whose capacity changes while it runs: it
+// serves workers requests at a time, each taking service, and queues the
+// rest without bound. A request whose client gave up is still served when
+// its turn comes. A limiter in front of it, if any, refuses requests with
+// a 503 before they join the queue.
+type server struct {
+	srv      *http.Server
+	ln       net.Listener
+	limiter  conclimit.Limiter
+	slots    chan struct{} // one per worker the server could have
+	capacity chan int      // new worker counts for the resizer
+	service  time.Duration
+	stop     chan struct{}
+
+	served, wasted, queued atomic.Int64
+}
+
+func listen(maxWorkers int, service time.Duration, l conclimit.Limiter) (*server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &server{
+		ln:       ln,
+		limiter:  l,
+		slots:    make(chan struct{}, maxWorkers),
+		capacity: make(chan int, 1),
+		service:  service,
+		stop:     make(chan struct{}),
+	}
+	var h http.Handler = s
+	if l != nil {
+		h = conclimit.Handler(l, s)
+	}
+	s.srv = &http.Server{Handler: h}
+	go s.resize(maxWorkers)
+	go s.srv.Serve(ln)
+	return s, nil
+}
+
+func (s *server) URL() string { return "http://" + s.ln.Addr().String() + "/" }
+
+// SetWorkers changes how many requests the server serves at once. A
+// smaller number takes effect as requests in service finish.
+func (s *server) SetWorkers(n int) {
+	select {
+	case <-s.capacity:
+	default:
+	}
+	s.capacity <- n
+}
+
+// resize holds the slots of the workers the server does not have, taking
+// more as they are freed when it shrinks and handing them back when it
+// grows.
+func (s *server) resize(maxWorkers int) {
+	held, target := 0, 0
+	for {
+		if held < target {
+			select {
+			case s.slots <- struct{}{}:
+				held++
+			case target = <-s.capacity:
+				target = maxWorkers - min(max(target, 1), maxWorkers)
+			case <-s.stop:
+				return
+			}
+			continue
+		}
+		for held > target {
+			<-s.slots
+			held--
+		}
+		select {
+		case target = <-s.capacity:
+			target = maxWorkers - min(max(target, 1), maxWorkers)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.queued.Add(1)
+	select {
+	case s.slots <- struct{}{}:
+	case <-s.stop:
+		return
+	}
+	s.queued.Add(-1)
+	time.Sleep(s.service)
+	<-s.slots
+	if r.Context().Err() != nil {
+		s.wasted.Add(1) // the client timed out while this waited
+		return
+	}
+	s.served.Add(1)
+	w.Write([]byte("ok\n"))
+}
+
+// Close drops the queue and closes every connection.
+func (s *server) Close() error {
+	close(s.stop)
+	return s.srv.Close()
+}
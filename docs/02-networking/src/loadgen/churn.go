@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+)
+
+// churnStats counts the short-lived connections opened by -churn.
+type churnStats struct {
+	started, completed atomic.Int64
+
+	// TIME_WAIT sockets on the server's port, sampled once a second and
+	// after the run. The side that closes first holds TIME_WAIT: the client
+	// with -churn-close fin, nobody with rst.
+	mu                     sync.Mutex
+	twClient, twServer     int
+	peakClient, peakServer int
+	twErr                  error
+
+	elapsed time.Duration // how long churn ran
+}
+
+// churn opens connections at rate per second until ctx is done. Each one
+// optionally exchanges a message and then closes at once, so the server
+// spends its time in accept and close instead of in reads and writes. The
+// schedule is open loop: a slow server makes connections overlap rather
+// than slowing the rate down.
+func churn(ctx context.Context, dialer *net.Dialer, sources *sourcePool, st *stats, cs *churnStats, msg []byte, rate int) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// A ticker drops ticks when the receiver falls behind, which at
+	// thousands per second on a busy machine is most of them. Each tick
+	// instead starts every connection that is due by now.
+	start := time.Now()
+	tick := time.NewTicker(time.Millisecond)
+	defer tick.Stop()
+	for started := int64(0); ; {
+		select {
+		case <-ctx.Done():
+			cs.elapsed = time.Since(start)
+			return
+		case <-tick.C:
+		}
+		due := int64(time.Since(start).Seconds() * float64(rate))
+		for ; started < due; started++ {
+			cs.started.Add(1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if churnOnce(ctx, dialer, sources, st, msg) {
+					cs.completed.Add(1)
+				}
+			}()
+		}
+	}
+}
+
+// churnOnce runs one connection from dial to close and reports whether it
+// got there without errors.
+func churnOnce(ctx context.Context, dialer *net.Dialer, sources *sourcePool, st *stats, msg []byte) bool {
+	d := *dialer
+	if local := sources.Next(); local != nil {
+		d.LocalAddr = local
+	}
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", *addr)
+	if err != nil && ctx.Err() != nil {
+		return false // the run ended mid-dial
+	}
+	st.dialDone(start, time.Since(start), err)
+	if err != nil {
+		st.dialErrors.Add(1)
+		return false
+	}
+	if *churnClose == "rst" {
+		// SO_LINGER 0: close sends RST and skips TIME_WAIT.
+		conn.(*net.TCPConn).SetLinger(0)
+	}
+	if !*churnData {
+		return conn.Close() == nil
+	}
+
+	c, _ := codec.New(*codecName, 0) // validated in main
+	sess := newStreamSession(ctx, conn, c)
+	defer sess.Close()
+	sent := time.Now()
+	if err := sess.roundTrip(msg); err != nil {
+		if ctx.Err() == nil {
+			st.ioErrors.Add(1)
+			st.mu.Lock()
+			st.errByKind[errKind(err)]++
+			st.mu.Unlock()
+		}
+		return false
+	}
+	var r rtts
+	r.add(sent)
+	st.addRTT(&r)
+	st.requests.Add(1)
+	return true
+}
+
+// watchChurn samples TIME_WAIT counts for port every second until ctx is
+// done.
+func watchChurn(ctx context.Context, cs *churnStats, port int) {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for cs.sampleTimeWait(port) == nil {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func (cs *churnStats) sampleTimeWait(port int) error {
+	client, server, err := readTimeWait(port)
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.twClient, cs.twServer, cs.twErr = client, server, err
+	cs.peakClient = max(cs.peakClient, client)
+	cs.peakServer = max(cs.peakServer, server)
+	return err
+}
+
+func (cs *churnStats) report() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	done := cs.completed.Load()
+	fmt.Printf("churn: started=%d completed=%d rate=%.0f/s\n",
+		cs.started.Load(), done, float64(done)/cs.elapsed.Seconds())
+	if cs.twErr != nil {
+		fmt.Printf("churn: time_wait unavailable: %v\n", cs.twErr)
+	} else {
+		fmt.Printf("churn: time_wait client=%d (peak %d) server=%d (peak %d)\n",
+			cs.twClient, cs.peakClient, cs.twServer, cs.peakServer)
+	}
+}
+
+// userHZ is the unit of the CPU times in /proc/PID/stat. It has been 100
+// on every Linux architecture for decades, whatever the kernel's HZ.
+const userHZ = 100
+
+// readProcCPU returns the user plus system CPU time pid has used.
+func readProcCPU(pid int) (time.Duration, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	return parseProcCPU(string(data))
+}
+
+// parseProcCPU extracts utime + stime from a /proc/PID/stat line. The
+// command name in parentheses may contain spaces, so fields are counted
+// from the last ')'.
+func parseProcCPU(stat string) (time.Duration, error) {
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("stat: no command name in %q", stat)
+	}
+	// Fields after the name start at field 3 (state); utime and stime are
+	// fields 14 and 15.
+	f := strings.Fields(stat[i+1:])
+	if len(f) < 13 {
+		return 0, fmt.Errorf("stat: %d fields after the command name", len(f))
+	}
+	var ticks uint64
+	for _, s := range f[11:13] {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("stat: %w", err)
+		}
+		ticks += n
+	}
+	return time.Duration(ticks) * time.Second / userHZ, nil
+}
+
+// readTimeWait counts the IPv4 and IPv6 sockets in TIME_WAIT whose remote
+// port is port (the client side of connections to the server) and whose
+// local port is port (the server side).
+func readTimeWait(port int) (client, server int, err error) {
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		f, err := os.Open(path)
+		if err != nil {
+			if path == "/proc/net/tcp6" && os.IsNotExist(err) {
+				continue // IPv6 disabled
+			}
+			return 0, 0, err
+		}
+		c, s, err := parseTimeWait(f, port)
+		f.Close()
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %w", path, err)
+		}
+		client += c
+		server += s
+	}
+	return client, server, nil
+}
+
+// tcpTimeWait is TCP_TIME_WAIT in the kernel's state numbering, which
+// /proc/net/tcp prints in hex.
+const tcpTimeWait = "06"
+
+// parseTimeWait counts TIME_WAIT entries in the /proc/net/tcp format:
+//
+//	sl  local_address rem_address   st ...
+//	 0: 0100007F:2328 0100007F:D2A4 06 ...
+func parseTimeWait(r io.Reader, port int) (client, server int, err error) {
+	sc := bufio.NewScanner(r)
+	sc.Scan() // header
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) < 4 {
+			return 0, 0, fmt.Errorf("short line %q", sc.Text())
+		}
+		if f[3] != tcpTimeWait {
+			continue
+		}
+		local, err := hexPort(f[1])
+		if err != nil {
+			return 0, 0, err
+		}
+		remote, err := hexPort(f[2])
+		if err != nil {
+			return 0, 0, err
+		}
+		if remote == port {
+			client++
+		}
+		if local == port {
+			server++
+		}
+	}
+	return client, server, sc.Err()
+}
+
+// hexPort returns the port of an ADDR:PORT pair with both halves in hex.
+func hexPort(s string) (int, error) {
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 {
+		return 0, fmt.Errorf("bad address %q", s)
+	}
+	p, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %q: %w", s, err)
+	}
+	return int(p), nil
+}
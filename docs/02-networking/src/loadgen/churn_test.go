@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeWait(t *testing.T) {
+	const tcp = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:2328 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0 100 0 0 10 0
+   1: 0100007F:D2A4 0100007F:2328 06 00000000:00000000 03:00001770 00000000     0        0 0 3 0000000000000000
+   2: 0100007F:D2A6 0100007F:2328 06 00000000:00000000 03:00001770 00000000     0        0 0 3 0000000000000000
+   3: 0100007F:2328 0100007F:D2A8 06 00000000:00000000 03:00001770 00000000     0        0 0 3 0000000000000000
+   4: 0100007F:D2AA 0100007F:2328 01 00000000:00000000 00:00000000 00000000     0        0 2 1 0 20 4 30 10 -1
+   5: 0100007F:D2AC 0100007F:1F90 06 00000000:00000000 03:00001770 00000000     0        0 0 3 0000000000000000
+`
+	client, server, err := parseTimeWait(strings.NewReader(tcp), 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client != 2 || server != 1 {
+		t.Fatalf("client=%d server=%d, want 2 and 1", client, server)
+	}
+	if _, _, err := parseTimeWait(strings.NewReader("header\n 0: 0100007F 0100007F:2328 06\n"), 9000); err == nil {
+		t.Fatal("accepted an address without a port")
+	}
+}
+
+func TestParseProcCPU(t *testing.T) {
+	// The command name contains spaces and a parenthesis.
+	const stat = "4242 (echo (net) trace) S 1 4242 4242 0 -1 4194560 1234 0 0 0 250 75 0 0 20 0 9 0 100 123456 789 18446744073709551615"
+	got, err := parseProcCPU(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 3250 * time.Millisecond; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if _, err := parseProcCPU("4242 (short) S 1 2"); err == nil {
+		t.Fatal("accepted a truncated stat line")
+	}
+}
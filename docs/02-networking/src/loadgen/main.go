@@ -0,0 +1,690 @@
+// Command loadgen drives the line-based echo servers in this directory with
+// many concurrent long-lived connections and reports connect and round-trip
+// latency percentiles.
+//
+//	go run ./loadgen -addr 127.0.0.1:9000 -conns 20000 -duration 1m
+//
+// A single source IP can hold at most ~64k connections to one server
+// address. Pass several local IPs with -src to go past that limit:
+//
+//	go run ./loadgen -conns 200000 -src 10.0.0.2,10.0.0.3,10.0.0.4,10.0.0.5
+//
+// With -slow-conns the generator also opens slowloris connections that
+// trickle a single byte every -slow-interval, to show how quickly they
+// exhaust a server without slow-client protection:
+//
+//	go run ./loadgen -conns 100 -slow-conns 20000 -slow-interval 5s
+//
+// With -control the generator asks the server to drain -drain-after into the
+// run (see the drain package) and reports how many in-flight requests still
+// succeeded and how long the server took to shed its connections. -proto
+// selects the server: line (echo-net-trace.go), http (net-app-full.go), quic
+// (quic_server.go) or udp (echo-udp.go, a datagram per message):
+//
+//	go run ./loadgen -proto http -addr 127.0.0.1:8080 -control localhost:9101 -drain-after 10s
+//
+// -sizes and -think replace the fixed -size and -interval with
+// distributions from the workload package. Each connection draws from its
+// own stream of -seed, so two runs with the same seed send the same sizes
+// with the same pauses:
+//
+//	go run ./loadgen -sizes lognormal:256,1 -think exp:500ms -seed 7
+//
+// -churn opens and closes that many connections per second, on top of
+// the -conns persistent ones, to measure what connection churn costs the
+// server: the TIME_WAIT sockets left behind and, given the server's -pid,
+// the CPU time per connection, which compares directly with the CPU time
+// per request of persistent connections. -churn-data echoes one message
+// before each close; -churn-close rst closes with RST instead of FIN:
+//
+//	go run ./loadgen -conns 0 -churn 5000 -churn-data -pid $(pgrep -n echo-net-trace) -duration 30s
+//
+// Connections normally end at their first error, which suits a benchmark
+// but not a long run against a server that restarts or misbehaves.
+// -reconnect keeps every connection going for the whole run through the
+// connmgr package: a lost connection is redialed with jittered exponential
+// backoff up to -backoff-max, an idle one is pinged every -ping, and a
+// connection that keeps failing stops dialing for a while (circuit
+// breaking). Each request gets -request-timeout, so a server that stops
+// answering fails requests rather than hanging them. Failed and rejected
+// requests are counted instead:
+//
+//	go run ./loadgen -conns 100 -duration 10m -reconnect
+//
+// With -proto http, -retries retries failed requests within a retry budget
+// of -retry-budget retries per request, and -breaker adds a circuit
+// breaker, both from the breaker package and shared by all connections.
+// Requests the breaker rejects are counted and do not end the connection:
+//
+//	go run ./loadgen -proto http -addr 127.0.0.1:8080 -retries 3 -retry-budget 0.1 -breaker
+//
+// -latency-log keeps the start time and round trip of every request and
+// writes them out at the end, for gclat to line up with the server's GC
+// cycles:
+//
+//	go run ./loadgen -conns 1000 -interval 100ms -latency-log lat.csv
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/breaker"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/connmgr"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/drain"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/workload"
+)
+
+var (
+	addr       = flag.String("addr", "127.0.0.1:9000", "Server address")
+	conns      = flag.Int("conns", 1000, "Number of concurrent connections")
+	rampRate   = flag.Int("ramp", 2000, "New connections per second while ramping up (0 = unlimited)")
+	duration   = flag.Duration("duration", 30*time.Second, "Test duration after ramp-up")
+	interval   = flag.Duration("interval", time.Second, "Delay between messages on each connection")
+	msgSize    = flag.Int("size", 64, "Message size in bytes, including framing")
+	dialTO     = flag.Duration("dial-timeout", 5*time.Second, "Dial timeout")
+	sourceSpec = flag.String("src", "", "Comma separated local source IPs, each optionally with a port range (ip or ip:lo-hi)")
+	connectLog = flag.String("connect-log", "", "Write every connect attempt to this CSV file (unix_ms,connect_us,result)")
+	latencyLog = flag.String("latency-log", "", "Write every round trip to this CSV file (unix_us,rtt_us), for gclat")
+	slowConns  = flag.Int("slow-conns", 0, "Additional slowloris connections that trickle one byte per -slow-interval")
+	slowEvery  = flag.Duration("slow-interval", 3*time.Second, "Delay between bytes on slow connections")
+	proto      = flag.String("proto", "line", "Server protocol: line, http, quic or udp")
+	codecName  = flag.String("codec", "line", "Message framing for -proto line: line, length, length-crc, chunked or jsonl")
+	httpPath   = flag.String("path", "/fast", "Request path for -proto http")
+	control    = flag.String("control", "", "Server drain control address (host:port)")
+	drainAfter = flag.Duration("drain-after", 0, "Ask the server to drain this long after ramp-up (requires -control)")
+	seed       = flag.Uint64("seed", 1, "Seed for -sizes and -think; runs with the same seed draw the same values")
+	sizeSpec   = flag.String("sizes", "", "Message size distribution, e.g. lognormal:256,1 (overrides -size)")
+	thinkSpec  = flag.String("think", "", "Pause between a reply and the next message, e.g. exp:1s (overrides -interval)")
+	churnRate  = flag.Int("churn", 0, "Also open and close this many connections per second (0 = off; -proto line only)")
+	churnData  = flag.Bool("churn-data", false, "Echo one message on each churned connection before closing it")
+	churnClose = flag.String("churn-close", "fin", "How churned connections close: fin (the client holds TIME_WAIT) or rst (nobody does)")
+	serverPID  = flag.Int("pid", 0, "Server process ID; report the CPU time it used during the run (Linux)")
+	reconnect  = flag.Bool("reconnect", false, "Keep connections up for the whole run: redial with backoff, ping idle ones, break the circuit on repeated failures (-proto line only)")
+	pingEvery  = flag.Duration("ping", time.Second, "Idle time before -reconnect pings a connection")
+	backoffMax = flag.Duration("backoff-max", 5*time.Second, "Longest wait between -reconnect redials")
+	reqTimeout = flag.Duration("request-timeout", 2*time.Second, "Deadline for each -reconnect or -proto udp request")
+	retries    = flag.Int("retries", 0, "Retries of a failed request (-proto http)")
+	retryRatio = flag.Float64("retry-budget", 0.1, "Retries allowed per request across all connections with -retries (0 = no budget)")
+	useBreaker = flag.Bool("breaker", false, "Put a circuit breaker shared by all connections in front of the server (-proto http)")
+)
+
+// maxMsgSize caps sizes drawn from -sizes at the echo servers' message
+// limit, so a long-tailed distribution does not get connections dropped.
+const maxMsgSize = 4096
+
+// sizes and think are the parsed -sizes and -think, nil when not given.
+var sizes, think *workload.Dist
+
+// stats aggregates results from all client goroutines.
+type stats struct {
+	connected  atomic.Int64
+	dialErrors atomic.Int64
+	ioErrors   atomic.Int64
+	requests   atomic.Int64
+	slowOpen   atomic.Int64
+	rejected   atomic.Int64 // requests the -breaker rejected
+
+	// Drain accounting. drainStart is the UnixNano time the drain request
+	// was sent; lastClose is when the last connection was lost after that.
+	// In-flight requests were sent before drainStart and finished after it;
+	// late requests were sent after it.
+	drainStart     atomic.Int64
+	inflightOK     atomic.Int64
+	inflightFailed atomic.Int64
+	lateOK         atomic.Int64
+	lateFailed     atomic.Int64
+	drainClosed    atomic.Int64
+	lastClose      atomic.Int64
+
+	mu        sync.Mutex
+	dialLat   []time.Duration
+	dialLog   []dialRecord
+	rttLat    []time.Duration
+	rttLog    []rttRecord
+	slowLife  []time.Duration // how long slow connections lasted before the server dropped them
+	errByKind map[string]int
+	managed   connmgr.Stats // totals over the -reconnect clients
+}
+
+// dialRecord is one connect attempt, kept for -connect-log.
+type dialRecord struct {
+	start   time.Time
+	latency time.Duration
+	err     error
+}
+
+// rttRecord is one round trip, kept for -latency-log.
+type rttRecord struct {
+	start time.Time
+	rtt   time.Duration
+}
+
+// rtts collects one client's round trips until it hands them to stats,
+// with their start times when -latency-log is set.
+type rtts struct {
+	lat []time.Duration
+	log []rttRecord
+}
+
+// add records a round trip that started at sent and has just finished.
+func (r *rtts) add(sent time.Time) {
+	d := time.Since(sent)
+	r.lat = append(r.lat, d)
+	if *latencyLog != "" {
+		r.log = append(r.log, rttRecord{start: sent, rtt: d})
+	}
+}
+
+func newStats() *stats {
+	return &stats{errByKind: make(map[string]int)}
+}
+
+func (s *stats) dialDone(start time.Time, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if *connectLog != "" {
+		s.dialLog = append(s.dialLog, dialRecord{start: start, latency: d, err: err})
+	}
+	if err != nil {
+		s.errByKind[errKind(err)]++
+		return
+	}
+	s.dialLat = append(s.dialLat, d)
+}
+
+func (s *stats) slowDropped(lifetime time.Duration) {
+	s.mu.Lock()
+	s.slowLife = append(s.slowLife, lifetime)
+	s.mu.Unlock()
+}
+
+func (s *stats) addRTT(r *rtts) {
+	s.mu.Lock()
+	s.rttLat = append(s.rttLat, r.lat...)
+	s.rttLog = append(s.rttLog, r.log...)
+	s.mu.Unlock()
+}
+
+// draining reports whether the drain request has been sent.
+func (s *stats) draining() bool { return s.drainStart.Load() != 0 }
+
+// drainResult classifies a request that finished while draining.
+func (s *stats) drainResult(sentBefore bool, err error) {
+	switch {
+	case sentBefore && err == nil:
+		s.inflightOK.Add(1)
+	case sentBefore:
+		s.inflightFailed.Add(1)
+	case err == nil:
+		s.lateOK.Add(1)
+	default:
+		s.lateFailed.Add(1)
+	}
+	if err != nil {
+		s.connLost()
+	}
+}
+
+// connLost records a connection ending on the server's initiative during
+// a drain.
+func (s *stats) connLost() {
+	s.drainClosed.Add(1)
+	now := time.Now().UnixNano()
+	for {
+		last := s.lastClose.Load()
+		if now <= last || s.lastClose.CompareAndSwap(last, now) {
+			return
+		}
+	}
+}
+
+// errKind reduces an error to a short label so that thousands of identical
+// failures are reported as one line.
+func errKind(err error) string {
+	msg := err.Error()
+	if i := strings.LastIndex(msg, ": "); i >= 0 {
+		msg = msg[i+2:]
+	}
+	return msg
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
+
+func printLatency(name string, samples []time.Duration) {
+	if len(samples) == 0 {
+		fmt.Printf("%-8s no samples\n", name)
+		return
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	fmt.Printf("%-8s n=%d p50=%v p90=%v p99=%v p999=%v max=%v\n", name, len(samples),
+		percentile(samples, 0.50), percentile(samples, 0.90),
+		percentile(samples, 0.99), percentile(samples, 0.999), samples[len(samples)-1])
+}
+
+// reportServerCPU prints the CPU time the -pid process used since start,
+// per request and per churned connection.
+func reportServerCPU(start, elapsed time.Duration, st *stats, cs *churnStats) {
+	end, err := readProcCPU(*serverPID)
+	if err != nil {
+		fmt.Printf("server cpu unavailable: %v\n", err)
+		return
+	}
+	cpu := end - start
+	fmt.Printf("server cpu=%v (%.0f%% of one core)", cpu, 100*cpu.Seconds()/elapsed.Seconds())
+	if n := st.requests.Load(); n > 0 {
+		fmt.Printf(" %.1fµs/request", float64(cpu.Nanoseconds())/1e3/float64(n))
+	}
+	if cs != nil {
+		if n := cs.completed.Load(); n > 0 {
+			fmt.Printf(" %.1fµs/churned_conn", float64(cpu.Nanoseconds())/1e3/float64(n))
+		}
+	}
+	fmt.Println()
+}
+
+func (s *stats) report() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Printf("connected=%d dial_errors=%d io_errors=%d requests=%d\n",
+		s.connected.Load(), s.dialErrors.Load(), s.ioErrors.Load(), s.requests.Load())
+	printLatency("connect", s.dialLat)
+	printLatency("rtt", s.rttLat)
+	if *slowConns > 0 {
+		fmt.Printf("slow clients: still open=%d dropped by server=%d\n", s.slowOpen.Load(), len(s.slowLife))
+		printLatency("slowlife", s.slowLife)
+	}
+	if start := s.drainStart.Load(); start != 0 {
+		ok, failed := s.inflightOK.Load(), s.inflightFailed.Load()
+		rate := 100.0
+		if ok+failed > 0 {
+			rate = 100 * float64(ok) / float64(ok+failed)
+		}
+		fmt.Printf("drain: in-flight ok=%d failed=%d (%.2f%% success) late ok=%d failed=%d connections closed=%d",
+			ok, failed, rate, s.lateOK.Load(), s.lateFailed.Load(), s.drainClosed.Load())
+		if last := s.lastClose.Load(); last != 0 {
+			fmt.Printf(" last close after %v", time.Duration(last-start))
+		}
+		fmt.Println()
+	}
+	if *reconnect {
+		m := s.managed
+		fmt.Printf("reconnect: dials=%d dial_errors=%d drops=%d pings=%d ping_failures=%d circuit_opens=%d rejected=%d\n",
+			m.Dials, m.DialErrors, m.Drops, m.Pings, m.PingFailures, m.Opens, m.Rejected)
+	}
+	if *retries > 0 || httpBreaker != nil {
+		retryTotals.Lock()
+		r := retryTotals.TransportStats
+		retryTotals.Unlock()
+		fmt.Printf("http: attempts=%d retries=%d", r.Attempts, r.Retries)
+		if httpBudget != nil {
+			fmt.Printf(" retries_denied=%d", httpBudget.Denied())
+		}
+		if httpBreaker != nil {
+			b := httpBreaker.Stats()
+			fmt.Printf(" breaker=%v opens=%d rejected=%d", b.State, b.Opens, s.rejected.Load())
+		}
+		fmt.Println()
+	}
+	for kind, n := range s.errByKind {
+		fmt.Printf("error %q x%d\n", kind, n)
+	}
+}
+
+// writeConnectLog dumps every connect attempt so it can be lined up with
+// server-side accept queue samples (see backlogmon).
+func (s *stats) writeConnectLog(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "unix_ms,connect_us,result")
+	for _, r := range s.dialLog {
+		result := "ok"
+		if r.err != nil {
+			result = strings.ReplaceAll(errKind(r.err), ",", ";")
+		}
+		fmt.Fprintf(w, "%d,%d,%s\n", r.start.UnixMilli(), r.latency.Microseconds(), result)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeLatencyLog writes every round trip in the order the clients
+// finished, which is not the order they started in.
+func (s *stats) writeLatencyLog(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "unix_us,rtt_us")
+	for _, r := range s.rttLog {
+		fmt.Fprintf(w, "%d,%d\n", r.start.UnixMicro(), r.rtt.Microseconds())
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// client runs one connection: dial, then send a request and wait for its
+// response every interval, or after a think time drawn from -think, until
+// ctx is done or the server goes away. id selects the connection's stream
+// of -seed.
+func client(ctx context.Context, dialer *net.Dialer, sources *sourcePool, st *stats, msg []byte, id int) {
+	d := *dialer
+	if local := sources.Next(); local != nil {
+		d.LocalAddr = local
+	}
+
+	start := time.Now()
+	sess, err := dialSession(ctx, &d, *proto)
+	st.dialDone(start, time.Since(start), err)
+	if err != nil {
+		st.dialErrors.Add(1)
+		return
+	}
+	defer sess.Close()
+	st.connected.Add(1)
+	defer st.connected.Add(-1)
+
+	var samples rtts
+	defer st.addRTT(&samples)
+
+	rng := workload.Rand(*seed, uint64(id))
+	var next <-chan time.Time
+	if think == nil {
+		ticker := time.NewTicker(*interval)
+		defer ticker.Stop()
+		next = ticker.C
+	}
+	for {
+		if sizes != nil {
+			msg = makePayload(*codecName, min(max(sizes.Int(rng), 1), maxMsgSize))
+		}
+		sent := time.Now()
+		sentBefore := !st.draining()
+		err := sess.roundTrip(msg)
+		switch {
+		case errors.Is(err, breaker.ErrOpen):
+			// Rejected without touching the connection, which is still fine.
+			st.rejected.Add(1)
+		case st.draining() && ctx.Err() == nil:
+			st.drainResult(sentBefore, err)
+			fallthrough
+		default:
+			if err != nil {
+				if ctx.Err() == nil && !st.draining() {
+					st.ioErrors.Add(1)
+					st.mu.Lock()
+					st.errByKind[errKind(err)]++
+					st.mu.Unlock()
+				}
+				return
+			}
+			samples.add(sent)
+			st.requests.Add(1)
+		}
+
+		if think != nil {
+			next = time.After(think.Duration(rng))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-next:
+		}
+	}
+}
+
+// runDrain asks the server to drain, waits for our connections to be shed
+// and then for the server to report the drain as finished.
+func runDrain(ctx context.Context, st *stats) {
+	cl := &drain.Client{Addr: *control}
+	st.drainStart.Store(time.Now().UnixNano())
+	s, err := cl.Begin(ctx)
+	if err != nil {
+		log.Printf("drain request failed: %v", err)
+		return
+	}
+	log.Printf("drain started, server reports %d in flight", s.Active)
+
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for st.connected.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+	s, err = cl.WaitDone(ctx, 50*time.Millisecond)
+	switch {
+	case err == nil:
+		log.Printf("server drained in %v", s.Duration)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+	default:
+		// Servers exit once drained, taking the control listener along.
+		log.Printf("server stopped answering (%v); last status %+v", err, s)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	sources, err := parseSources(*sourceSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if sources.Len() == 0 && *conns > 60000 {
+		log.Printf("warning: %d connections from a single source IP will likely exhaust ephemeral ports; use -src", *conns)
+	}
+
+	if *sizeSpec != "" {
+		if sizes, err = workload.ParseSize(*sizeSpec); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *thinkSpec != "" {
+		if think, err = workload.ParseDuration(*thinkSpec); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var serverPort int
+	if *churnRate > 0 {
+		if *proto != "line" || *churnClose != "fin" && *churnClose != "rst" {
+			log.Fatal("-churn needs -proto line and -churn-close fin or rst")
+		}
+		if _, err := codec.New(*codecName, 0); err != nil {
+			log.Fatal(err)
+		}
+		_, p, err := net.SplitHostPort(*addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if serverPort, err = strconv.Atoi(p); err != nil {
+			log.Fatalf("-churn needs a numeric port in -addr: %v", err)
+		}
+	}
+
+	if *reconnect {
+		if *proto != "line" || *drainAfter > 0 {
+			log.Fatal("-reconnect needs -proto line and no -drain-after")
+		}
+		if _, err := codec.New(*codecName, 0); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *retries > 0 || *useBreaker {
+		if *proto != "http" || *retries < 0 || *retryRatio < 0 {
+			log.Fatal("-retries and -breaker need -proto http, and must not be negative")
+		}
+		if *retries > 0 && *retryRatio > 0 {
+			httpBudget = breaker.NewRetryBudget(*retryRatio, 10)
+		}
+		if *useBreaker {
+			httpBreaker = breaker.New(breaker.Config{})
+		}
+	}
+
+	msg := makePayload(*codecName, *msgSize)
+	dialer := &net.Dialer{Timeout: *dialTO}
+	if sources.Len() > 0 {
+		dialer.Control = bindNoPort
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	st := newStats()
+	var wg sync.WaitGroup
+
+	// Periodic progress logger
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				log.Printf("connected=%d slow=%d dial_errors=%d requests=%d",
+					st.connected.Load(), st.slowOpen.Load(), st.dialErrors.Load(), st.requests.Load())
+			}
+		}
+	}()
+
+	var pace <-chan time.Time
+	if *rampRate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(*rampRate))
+		defer ticker.Stop()
+		pace = ticker.C
+	}
+
+	// Slow clients go first so they hold their resources before the
+	// regular clients try to connect.
+	for i := 0; i < *slowConns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slowClient(ctx, dialer, sources, st, *slowEvery)
+		}()
+	}
+
+	// The server's CPU time is charged to the whole run, ramp-up included.
+	var cpuStart time.Duration
+	if *serverPID > 0 {
+		if cpuStart, err = readProcCPU(*serverPID); err != nil {
+			log.Fatalf("-pid: %v", err)
+		}
+	}
+	runStart := time.Now()
+
+	var cs *churnStats
+	var watch sync.WaitGroup
+	if *churnRate > 0 {
+		cs = &churnStats{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			churn(ctx, dialer, sources, st, cs, msg, *churnRate)
+		}()
+		watch.Add(1)
+		go func() {
+			defer watch.Done()
+			watchChurn(ctx, cs, serverPort)
+		}()
+	}
+
+	log.Printf("ramping up %d connections to %s from %d source address(es)", *conns, *addr, max(sources.Len(), 1))
+ramp:
+	for i := 0; i < *conns; i++ {
+		if pace != nil {
+			select {
+			case <-ctx.Done():
+				break ramp
+			case <-pace:
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if *reconnect {
+				managedClient(ctx, dialer, sources, st, msg, i)
+			} else {
+				client(ctx, dialer, sources, st, msg, i)
+			}
+		}()
+	}
+
+	if *control != "" && *drainAfter > 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(*drainAfter):
+			dctx, dcancel := context.WithTimeout(ctx, *duration)
+			runDrain(dctx, st)
+			dcancel()
+		}
+	} else {
+		select {
+		case <-ctx.Done():
+		case <-time.After(*duration):
+		}
+	}
+	cancel()
+	wg.Wait()
+	watch.Wait()
+
+	st.report()
+	if cs != nil {
+		cs.sampleTimeWait(serverPort) // after every churned connection closed
+		cs.report()
+	}
+	if *serverPID > 0 {
+		reportServerCPU(cpuStart, time.Since(runStart), st, cs)
+	}
+	if *connectLog != "" {
+		if err := st.writeConnectLog(*connectLog); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *latencyLog != "" {
+		if err := st.writeLatencyLog(*latencyLog); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/breaker"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/codec"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/connlimit"
+)
+
+// session is one client connection speaking the protocol selected by -proto.
+// roundTrip sends one request and waits for its complete response.
+type session interface {
+	roundTrip(msg []byte) error
+	Close() error
+}
+
+// dialSession opens a session to *addr. Pending round trips fail promptly
+// once ctx is done.
+func dialSession(ctx context.Context, d *net.Dialer, proto string) (session, error) {
+	switch proto {
+	case "line":
+		return dialStream(ctx, d)
+	case "http":
+		return dialHTTP(ctx, d), nil
+	case "quic":
+		return dialQUIC(ctx)
+	case "udp":
+		return dialUDP(ctx, d)
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", proto)
+	}
+}
+
+// streamSession talks to the echo servers over TCP, framing messages with
+// the codec selected by -codec.
+type streamSession struct {
+	conn *codec.Conn
+	raw  net.Conn
+	stop func() bool
+}
+
+func dialStream(ctx context.Context, d *net.Dialer) (*streamSession, error) {
+	c, err := codec.New(*codecName, 0)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.DialContext(ctx, "tcp", *addr)
+	if err != nil {
+		return nil, err
+	}
+	return newStreamSession(ctx, conn, c), nil
+}
+
+func newStreamSession(ctx context.Context, conn net.Conn, c codec.Codec) *streamSession {
+	return &streamSession{
+		conn: codec.NewConn(conn, c, 0),
+		raw:  conn,
+		// Unblock pending reads once the test is over.
+		stop: context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) }),
+	}
+}
+
+// roundTrip sends one message and waits for its echo. The echo servers
+// answer in order, so one decoded message is one reply; with -codec
+// chunked, a reply split into parts is complete at its End. A server over
+// its -max-conns answers connlimit.Busy instead and closes the connection.
+func (s *streamSession) roundTrip(msg []byte) error {
+	if err := s.conn.Send(codec.Message{Payload: msg}); err != nil {
+		return err
+	}
+	if err := s.conn.Flush(); err != nil {
+		return err
+	}
+	for {
+		msgs, err := s.conn.Next()
+		if err != nil {
+			return err
+		}
+		if len(msgs) > 0 {
+			if connlimit.IsBusy(msgs[0].Payload) {
+				return connlimit.ErrBusy
+			}
+			if last := msgs[len(msgs)-1].Chunk; last == codec.Whole || last == codec.End {
+				return nil
+			}
+		}
+	}
+}
+
+// makePayload returns a payload that frames to about size bytes with the
+// named codec; jsonl payloads are JSON objects. A chunked payload longer
+// than codec.ChunkSize adds a 4-byte header per frame.
+func makePayload(codecName string, size int) []byte {
+	switch codecName {
+	case "length", "chunked":
+		return bytes.Repeat([]byte("x"), max(size-4, 0))
+	case "length-crc":
+		return bytes.Repeat([]byte("x"), max(size-8, 0))
+	case "jsonl":
+		const prefix, suffix = `{"data":"`, `"}`
+		n := max(size-1-len(prefix)-len(suffix), 0)
+		return []byte(prefix + strings.Repeat("x", n) + suffix)
+	default:
+		return bytes.Repeat([]byte("x"), max(size-1, 0))
+	}
+}
+
+func (s *streamSession) Close() error {
+	s.stop()
+	return s.conn.Close()
+}
+
+// httpSession issues GET requests against net-app-full.go's handlers. Each
+// session has its own transport so that -conns maps to TCP connections.
+// With -retries or -breaker the transport is wrapped in a
+// breaker.Transport, sharing one breaker and one retry budget with every
+// other session, as the clients of one service in one process would.
+type httpSession struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+	retry  *breaker.Transport // nil without -retries and -breaker
+}
+
+// httpBreaker and httpBudget are shared by all HTTP sessions; nil when
+// not enabled.
+var (
+	httpBreaker *breaker.Breaker
+	httpBudget  *breaker.RetryBudget
+)
+
+// retryTotals adds up the retry counters of closed HTTP sessions.
+var retryTotals struct {
+	sync.Mutex
+	breaker.TransportStats
+}
+
+func dialHTTP(ctx context.Context, d *net.Dialer) *httpSession {
+	var tr http.RoundTripper = &http.Transport{
+		DialContext:         d.DialContext,
+		MaxIdleConnsPerHost: 1,
+	}
+	s := &httpSession{ctx: ctx, url: "http://" + *addr + *httpPath}
+	if *retries > 0 || httpBreaker != nil {
+		s.retry = &breaker.Transport{Base: tr, Breaker: httpBreaker, Budget: httpBudget, Retries: *retries}
+		tr = s.retry
+	}
+	s.client = &http.Client{Transport: tr}
+	return s
+}
+
+func (s *httpSession) roundTrip([]byte) error {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("http status %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSession) Close() error {
+	s.client.CloseIdleConnections()
+	if s.retry != nil {
+		st := s.retry.Stats()
+		retryTotals.Lock()
+		retryTotals.Attempts += st.Attempts
+		retryTotals.Retries += st.Retries
+		retryTotals.Rejected += st.Rejected
+		retryTotals.Unlock()
+	}
+	return nil
+}
+
+// quicSession sends each message on its own stream to quic_server.go, which
+// reads the stream to EOF and closes it; the round trip ends at our EOF.
+type quicSession struct {
+	ctx  context.Context
+	conn quic.Connection
+}
+
+var quicTLS = &tls.Config{
+	InsecureSkipVerify: true,
+	NextProtos:         []string{"quic-0rtt-example"},
+}
+
+func dialQUIC(ctx context.Context) (*quicSession, error) {
+	conn, err := quic.DialAddr(ctx, *addr, quicTLS, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &quicSession{ctx: ctx, conn: conn}, nil
+}
+
+func (s *quicSession) roundTrip(msg []byte) error {
+	stream, err := s.conn.OpenStreamSync(s.ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := stream.Write(msg); err != nil {
+		return err
+	}
+	if err := stream.Close(); err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, stream)
+	return err
+}
+
+func (s *quicSession) Close() error {
+	return s.conn.CloseWithError(0, "bye")
+}
+
+// udpSession sends each message as one datagram to echo-udp.go and waits
+// for it to come back. Each session is its own socket, so -conns maps to
+// client ports. A datagram lost either way fails the round trip once
+// -request-timeout passes, which ends the session like any other error.
+type udpSession struct {
+	conn net.Conn
+	buf  []byte
+	stop func() bool
+}
+
+func dialUDP(ctx context.Context, d *net.Dialer) (*udpSession, error) {
+	conn, err := d.DialContext(ctx, "udp", *addr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpSession{
+		conn: conn,
+		buf:  make([]byte, 64<<10),
+		// roundTrip sets its own read deadline, so the end of the test
+		// closes the socket instead of setting one.
+		stop: context.AfterFunc(ctx, func() { conn.Close() }),
+	}, nil
+}
+
+func (s *udpSession) roundTrip(msg []byte) error {
+	s.conn.SetReadDeadline(time.Now().Add(*reqTimeout))
+	if _, err := s.conn.Write(msg); err != nil {
+		return err
+	}
+	_, err := s.conn.Read(s.buf)
+	return err
+}
+
+func (s *udpSession) Close() error {
+	s.stop()
+	return s.conn.Close()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/connmgr"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/workload"
+)
+
+// managedSession is a stream session kept up by a connmgr.Manager. A ping
+// is a round trip with a small message.
+type managedSession struct {
+	*streamSession
+	st   *stats
+	ping []byte
+	once sync.Once
+}
+
+func (s *managedSession) Ping(ctx context.Context) error {
+	deadline, _ := ctx.Deadline()
+	return s.roundTripBy(deadline, s.ping)
+}
+
+// roundTripBy is roundTrip with a deadline, so that a server that stops
+// answering fails the request instead of hanging the client.
+func (s *managedSession) roundTripBy(deadline time.Time, msg []byte) error {
+	s.raw.SetDeadline(deadline)
+	defer s.raw.SetDeadline(time.Time{})
+	return s.roundTrip(msg)
+}
+
+func (s *managedSession) Close() error {
+	s.once.Do(func() { s.st.connected.Add(-1) })
+	return s.streamSession.Close()
+}
+
+// managedClient is client for -reconnect. Instead of returning on the
+// first error it keeps sending for the whole run, and a connmgr.Manager
+// redials, pings and backs off underneath. Requests that fail or are
+// rejected by the open circuit are counted, not fatal.
+func managedClient(ctx context.Context, dialer *net.Dialer, sources *sourcePool, st *stats, msg []byte, id int) {
+	ping := makePayload(*codecName, 16)
+	m := connmgr.New(func(ctx context.Context) (connmgr.Conn, error) {
+		d := *dialer
+		if local := sources.Next(); local != nil {
+			d.LocalAddr = local
+		}
+		start := time.Now()
+		s, err := dialStream(ctx, &d)
+		st.dialDone(start, time.Since(start), err)
+		if err != nil {
+			st.dialErrors.Add(1)
+			return nil, err
+		}
+		st.connected.Add(1)
+		return &managedSession{streamSession: s, st: st, ping: ping}, nil
+	}, connmgr.Config{PingInterval: *pingEvery, MaxBackoff: *backoffMax})
+
+	var run sync.WaitGroup
+	run.Add(1)
+	go func() {
+		defer run.Done()
+		m.Run(ctx)
+	}()
+	defer func() {
+		run.Wait()
+		m.Close()
+		st.mu.Lock()
+		st.managed.Add(m.Stats())
+		st.mu.Unlock()
+	}()
+
+	var samples rtts
+	defer st.addRTT(&samples)
+
+	rng := workload.Rand(*seed, uint64(id))
+	var next <-chan time.Time
+	if think == nil {
+		ticker := time.NewTicker(*interval)
+		defer ticker.Stop()
+		next = ticker.C
+	}
+	for {
+		if sizes != nil {
+			msg = makePayload(*codecName, min(max(sizes.Int(rng), 1), maxMsgSize))
+		}
+		sent := time.Now()
+		err := m.Do(ctx, func(c connmgr.Conn) error {
+			return c.(*managedSession).roundTripBy(time.Now().Add(*reqTimeout), msg)
+		})
+		var dialErr *connmgr.DialError
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err == nil:
+			samples.add(sent)
+			st.requests.Add(1)
+		case errors.As(err, &dialErr), errors.Is(err, connmgr.ErrOpen):
+			// Counted by dialDone and the Manager.
+		default:
+			st.ioErrors.Add(1)
+			st.mu.Lock()
+			st.errByKind[errKind(err)]++
+			st.mu.Unlock()
+		}
+
+		if think != nil {
+			next = time.After(think.Duration(rng))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-next:
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// slowClient behaves like a slowloris attacker: it opens a connection and
+// trickles one byte of a never-ending line every interval, holding the
+// server's per-connection resources for as long as the server lets it, and
+// records how long the server kept the connection open.
+func slowClient(ctx context.Context, dialer *net.Dialer, sources *sourcePool, st *stats, every time.Duration) {
+	d := *dialer
+	if local := sources.Next(); local != nil {
+		d.LocalAddr = local
+	}
+	conn, err := d.DialContext(ctx, "tcp", *addr)
+	if err != nil {
+		st.dialErrors.Add(1)
+		return
+	}
+	defer conn.Close()
+	st.slowOpen.Add(1)
+	defer st.slowOpen.Add(-1)
+
+	start := time.Now()
+	// One byte out and one in, both allocated once for the connection.
+	out, buf := []byte{'x'}, make([]byte, 1)
+	for ctx.Err() == nil {
+		if _, err := conn.Write(out); err != nil {
+			break
+		}
+		// Wait for the next tick, watching for the server closing on us.
+		conn.SetReadDeadline(time.Now().Add(every))
+		_, err := conn.Read(buf)
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+	}
+	if ctx.Err() == nil {
+		st.slowDropped(time.Since(start))
+	}
+}
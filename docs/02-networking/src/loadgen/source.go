@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// sourceAddr is a local IP to bind outgoing connections to, optionally
+// restricted to an explicit port range.
+type sourceAddr struct {
+	ip     netip.Addr
+	loPort uint16 // 0 means "let the kernel choose"
+	hiPort uint16
+	next   atomic.Uint32
+}
+
+// sourcePool spreads outgoing connections across several local addresses.
+//
+// The kernel identifies a TCP connection by its (src ip, src port, dst ip,
+// dst port) tuple, so a single source IP can open at most ~64k connections
+// to one destination. Every extra source IP adds another ~64k.
+type sourcePool struct {
+	addrs []*sourceAddr
+	next  atomic.Uint64
+}
+
+// parseSources parses a comma separated list of local addresses in the form
+// "ip" or "ip:lo-hi", e.g. "10.0.0.2,10.0.0.3:20000-40000".
+func parseSources(spec string) (*sourcePool, error) {
+	pool := &sourcePool{}
+	if strings.TrimSpace(spec) == "" {
+		return pool, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		src, err := parseSource(item)
+		if err != nil {
+			return nil, err
+		}
+		pool.addrs = append(pool.addrs, src)
+	}
+	return pool, nil
+}
+
+func parseSource(item string) (*sourceAddr, error) {
+	host, ports := item, ""
+	if i := strings.LastIndex(item, ":"); i >= 0 && strings.Contains(item[i:], "-") {
+		host, ports = item[:i], item[i+1:]
+	}
+	host = strings.Trim(host, "[]")
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil, fmt.Errorf("bad source address %q: %w", item, err)
+	}
+	src := &sourceAddr{ip: ip}
+	if ports == "" {
+		return src, nil
+	}
+	lo, hi, ok := strings.Cut(ports, "-")
+	if !ok {
+		return nil, fmt.Errorf("bad port range in %q", item)
+	}
+	loPort, err := strconv.ParseUint(lo, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad port range in %q: %w", item, err)
+	}
+	hiPort, err := strconv.ParseUint(hi, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad port range in %q: %w", item, err)
+	}
+	if loPort == 0 || loPort > hiPort {
+		return nil, fmt.Errorf("bad port range in %q", item)
+	}
+	src.loPort, src.hiPort = uint16(loPort), uint16(hiPort)
+	return src, nil
+}
+
+// Len returns the number of configured source addresses.
+func (p *sourcePool) Len() int { return len(p.addrs) }
+
+// Next returns the local address for the next connection, round-robin
+// across the configured IPs. It returns nil when no sources are configured,
+// which leaves the choice to the kernel.
+func (p *sourcePool) Next() *net.TCPAddr {
+	if len(p.addrs) == 0 {
+		return nil
+	}
+	src := p.addrs[(p.next.Add(1)-1)%uint64(len(p.addrs))]
+	return src.localAddr()
+}
+
+func (s *sourceAddr) localAddr() *net.TCPAddr {
+	addr := &net.TCPAddr{IP: s.ip.AsSlice()}
+	if s.loPort != 0 {
+		span := uint32(s.hiPort-s.loPort) + 1
+		addr.Port = int(s.loPort) + int((s.next.Add(1)-1)%span)
+	}
+	return addr
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindNoPort sets IP_BIND_ADDRESS_NO_PORT so that bind() to a source IP with
+// port 0 defers port selection until connect(). Without it the kernel picks
+// a port at bind time that must be unique per source IP, capping every IP at
+// the size of the ephemeral range regardless of the destination.
+func bindNoPort(network, address string, c syscall.RawConn) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_BIND_ADDRESS_NO_PORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "syscall"
+
+// bindNoPort is a no-op outside Linux; the kernel picks the port at bind time.
+func bindNoPort(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestParseSources(t *testing.T) {
+	pool, err := parseSources("127.0.0.1, 127.0.0.2:30000-30001,[::1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pool.Len() != 3 {
+		t.Fatalf("got %d sources, want 3", pool.Len())
+	}
+
+	want := []string{"127.0.0.1:0", "127.0.0.2:30000", "[::1]:0", "127.0.0.1:0", "127.0.0.2:30001", "[::1]:0", "127.0.0.1:0", "127.0.0.2:30000"}
+	for i, w := range want {
+		if got := pool.Next().String(); got != w {
+			t.Errorf("Next() #%d = %s, want %s", i, got, w)
+		}
+	}
+}
+
+func TestParseSourcesEmpty(t *testing.T) {
+	pool, err := parseSources("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pool.Next() != nil {
+		t.Fatal("empty pool must leave the source address to the kernel")
+	}
+}
+
+func TestParseSourcesInvalid(t *testing.T) {
+	for _, spec := range []string{"nope", "127.0.0.1:5-1", "127.0.0.1:0-10", "127.0.0.1:1-x"} {
+		if _, err := parseSources(spec); err == nil {
+			t.Errorf("parseSources(%q) succeeded, want error", spec)
+		}
+	}
+}
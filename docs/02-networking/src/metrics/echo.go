@@ -0,0 +1,63 @@
+package metrics
+
+import "net"
+
+// Echo is the set of metrics the echo servers share. They register it
+// under the same names, so one query compares echo-net.go, echo-net-trace.go
+// and echo-epoll.go, told apart by the job or instance label of the scrape:
+//
+//	histogram_quantile(0.99, rate(echo_request_duration_seconds_bucket[1m]))
+//	rate(echo_flushes_total[1m]) / rate(echo_request_duration_seconds_count[1m])
+//
+// A request is what the server answers as one: a line for echo-net.go, a
+// decoded message for echo-net-trace.go, and a read for echo-epoll.go,
+// or a decoded message with -codec.
+type Echo struct {
+	Conns    *Gauge   // connections open
+	Accepted *Counter // connections accepted
+
+	// Latency is the time from a request's read returning to its reply
+	// being written, or buffered for a later flush. Its count is the
+	// number of requests.
+	Latency *Histogram
+
+	BytesIn  *Counter // bytes read from clients
+	BytesOut *Counter // bytes written to clients
+	Flushes  *Counter // writes to client sockets, each one syscall
+}
+
+// NewEcho registers the echo metrics in r.
+func NewEcho(r *Registry) *Echo {
+	return &Echo{
+		Conns:    r.Gauge("echo_connections", "Client connections open."),
+		Accepted: r.Counter("echo_connections_accepted_total", "Client connections accepted."),
+		Latency:  r.Histogram("echo_request_duration_seconds", "Time from reading a request to writing or buffering its reply.", LatencyBuckets),
+		BytesIn:  r.Counter("echo_received_bytes_total", "Bytes read from clients."),
+		BytesOut: r.Counter("echo_sent_bytes_total", "Bytes written to clients."),
+		Flushes:  r.Counter("echo_flushes_total", "Writes to client sockets."),
+	}
+}
+
+// Conn returns c with its reads counted in BytesIn, and its writes in
+// BytesOut and Flushes. A server that reads and writes through net.Conn
+// wraps each connection once; one that makes its own syscalls, such as
+// echo-epoll.go, adds to the counters itself.
+func (e *Echo) Conn(c net.Conn) net.Conn { return &countedConn{c, e} }
+
+type countedConn struct {
+	net.Conn
+	e *Echo
+}
+
+func (c *countedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.e.BytesIn.Add(uint64(n))
+	return n, err
+}
+
+func (c *countedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.e.BytesOut.Add(uint64(n))
+	c.e.Flushes.Inc()
+	return n, err
+}
@@ -0,0 +1,277 @@
+// Package metrics keeps counters, gauges and histograms for the example
+// servers and serves them in the Prometheus text format, so that runs of
+// different servers, or of one server under different settings, can be
+// scraped and compared on the same dashboard instead of read off log
+// lines.
+//
+// Updating a metric takes an atomic operation or two, with no lock and no
+// allocation, so handlers call it on every request; only a scrape reads
+// them. The metrics are registered once, at startup, in a Registry.
+// Default is the one debugsrv serves at /metrics on every server's
+// -debug-addr:
+//
+//	go run echo-net-trace.go -debug-addr localhost:6060
+//	curl -s localhost:6060/metrics
+//
+// The package writes the text format itself rather than pulling in the
+// Prometheus client library: the examples need three metric types and one
+// handler, not the library's dependencies.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counter is a count that only grows. The zero value is ready to use.
+type Counter struct{ v atomic.Uint64 }
+
+// Add adds n to the counter.
+func (c *Counter) Add(n uint64) { c.v.Add(n) }
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() { c.v.Add(1) }
+
+// Load returns the count.
+func (c *Counter) Load() uint64 { return c.v.Load() }
+
+func (c *Counter) kind() string { return "counter" }
+
+func (c *Counter) write(w *bufio.Writer, name string) {
+	fmt.Fprintf(w, "%s %d\n", name, c.Load())
+}
+
+// Gauge is a value that goes up and down, such as the connections open.
+// The zero value is ready to use.
+type Gauge struct{ v atomic.Int64 }
+
+// Add adds n, which may be negative, to the gauge.
+func (g *Gauge) Add(n int64) { g.v.Add(n) }
+
+// Set sets the gauge to n.
+func (g *Gauge) Set(n int64) { g.v.Store(n) }
+
+// Load returns the value.
+func (g *Gauge) Load() int64 { return g.v.Load() }
+
+func (g *Gauge) kind() string { return "gauge" }
+
+func (g *Gauge) write(w *bufio.Writer, name string) {
+	fmt.Fprintf(w, "%s %d\n", name, g.Load())
+}
+
+// Histogram counts observations in fixed buckets, from which Prometheus
+// estimates quantiles with histogram_quantile. Unlike a summary computed
+// in the process, bucket counts from many processes or many scrapes add
+// up, which is what comparing servers over a run needs.
+//
+// A scrape reads the buckets one by one while observations go on, so the
+// count and the sum it reports may disagree by the observations that
+// landed in between.
+type Histogram struct {
+	bounds []float64       // upper bounds, ascending; +Inf is implied
+	counts []atomic.Uint64 // per bucket, not cumulative; one more than bounds
+	sum    atomic.Uint64   // float64 bits
+}
+
+// NewHistogram returns a histogram with the given bucket upper bounds,
+// which must be ascending. Observations above the last go to an implied
+// +Inf bucket.
+func NewHistogram(bounds []float64) *Histogram {
+	if !slices.IsSorted(bounds) {
+		panic("metrics: histogram bounds not ascending")
+	}
+	return &Histogram{bounds: slices.Clone(bounds), counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+// ExpBuckets returns n bounds starting at start, each factor times the
+// last.
+func ExpBuckets(start, factor float64, n int) []float64 {
+	b := make([]float64, n)
+	for i := range b {
+		b[i] = start
+		start *= factor
+	}
+	return b
+}
+
+// LatencyBuckets are 21 bounds from 1µs to about 1s, doubling, in
+// seconds. Each bucket is twice as wide as the one before, so a quantile
+// estimated from them is within a factor of two, and the sub-millisecond
+// times of a loopback echo fall in buckets of their own rather than all
+// in the first, as they would with the client library's defaults.
+var LatencyBuckets = ExpBuckets(1e-6, 2, 21)
+
+// Observe records v.
+func (h *Histogram) Observe(v float64) { h.ObserveN(v, 1) }
+
+// ObserveN records v n times, for requests that were read and answered
+// together and so took the same time.
+func (h *Histogram) ObserveN(v float64, n int) {
+	if n <= 0 {
+		return
+	}
+	i, _ := slices.BinarySearch(h.bounds, v)
+	h.counts[i].Add(uint64(n))
+	add := v * float64(n)
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+add)) {
+			return
+		}
+	}
+}
+
+// ObserveDuration records d in seconds.
+func (h *Histogram) ObserveDuration(d time.Duration) { h.Observe(d.Seconds()) }
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	var n uint64
+	for i := range h.counts {
+		n += h.counts[i].Load()
+	}
+	return n
+}
+
+func (h *Histogram) kind() string { return "histogram" }
+
+func (h *Histogram) write(w *bufio.Writer, name string) {
+	var n uint64
+	for i := range h.counts {
+		n += h.counts[i].Load()
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatFloat(h.bounds[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, le, n)
+	}
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(math.Float64frombits(h.sum.Load())))
+	fmt.Fprintf(w, "%s_count %d\n", name, n)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Metric is a Counter, a Gauge or a Histogram.
+type Metric interface {
+	kind() string
+	write(w *bufio.Writer, name string)
+}
+
+// Registry is a set of named metrics. Its methods are safe to call
+// concurrently with each other and with updates to the metrics.
+type Registry struct {
+	mu      sync.Mutex
+	entries []entry // sorted by name
+}
+
+type entry struct {
+	name, help string
+	m          Metric
+}
+
+// Default is the registry the servers register in and debugsrv serves.
+var Default = new(Registry)
+
+var validName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// Register adds m under name, with help as its description. Like
+// expvar.Publish, it panics if the name is taken, and also if it is not a
+// valid Prometheus metric name: both are mistakes in the program, found
+// the first time it starts.
+func (r *Registry) Register(name, help string, m Metric) {
+	if !validName.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid name %q", name))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i, found := slices.BinarySearchFunc(r.entries, name, func(e entry, name string) int {
+		return strings.Compare(e.name, name)
+	})
+	if found {
+		panic(fmt.Sprintf("metrics: %q registered twice", name))
+	}
+	r.entries = slices.Insert(r.entries, i, entry{name, help, m})
+}
+
+// Counter registers and returns a new counter.
+func (r *Registry) Counter(name, help string) *Counter {
+	c := new(Counter)
+	r.Register(name, help, c)
+	return c
+}
+
+// Gauge registers and returns a new gauge.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := new(Gauge)
+	r.Register(name, help, g)
+	return g
+}
+
+// Histogram registers and returns a new histogram with the given bounds.
+func (r *Registry) Histogram(name, help string, bounds []float64) *Histogram {
+	h := NewHistogram(bounds)
+	r.Register(name, help, h)
+	return h
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format,
+// sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	entries := slices.Clone(r.entries)
+	r.mu.Unlock()
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, e := range entries {
+		if e.help != "" {
+			fmt.Fprintf(bw, "# HELP %s %s\n", e.name, helpEscaper.Replace(e.help))
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", e.name, e.m.kind())
+		e.m.write(bw, e.name)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// helpEscaper escapes what the text format requires in a HELP line: the
+// backslash and the newline.
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ContentType is the media type of the text format, version 0.0.4.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// ServeHTTP serves the registry to a Prometheus scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := new(Registry)
+	h := r.Histogram("req_seconds", "Request time.", []float64{0.001, 0.01})
+	r.Counter("b_total", "Two lines:\nwith a \\ in.").Add(7)
+	r.Gauge("a", "").Set(-3)
+	for _, v := range []float64{0.0005, 0.001, 5} {
+		h.Observe(v)
+	}
+	h.ObserveN(0.002, 2)
+	h.ObserveN(1, 0)
+
+	var b strings.Builder
+	n, err := r.WriteTo(&b)
+	if err != nil || n != int64(b.Len()) {
+		t.Fatalf("WriteTo = %d, %v for %d bytes", n, err, b.Len())
+	}
+	want := `# TYPE a gauge
+a -3
+# HELP b_total Two lines:\nwith a \\ in.
+# TYPE b_total counter
+b_total 7
+# HELP req_seconds Request time.
+# TYPE req_seconds histogram
+req_seconds_bucket{le="0.001"} 2
+req_seconds_bucket{le="0.01"} 4
+req_seconds_bucket{le="+Inf"} 5
+req_seconds_sum 5.0055
+req_seconds_count 5
+`
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType || rec.Body.String() != want {
+		t.Errorf("ServeHTTP: %q, body\n%s", ct, rec.Body)
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	r := new(Registry)
+	r.Counter("x_total", "")
+	for _, name := range []string{"x_total", "1x", "x-y", ""} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%q) did not panic", name)
+				}
+			}()
+			r.Counter(name, "")
+		}()
+	}
+}
+
+func TestLatencyBuckets(t *testing.T) {
+	b := LatencyBuckets
+	if len(b) != 21 || b[0] != 1e-6 || b[20] < 1 || b[20] > 1.1 {
+		t.Fatalf("%d buckets from %g to %g", len(b), b[0], b[len(b)-1])
+	}
+	h := NewHistogram(b)
+	h.ObserveDuration(3 * time.Microsecond)
+	if h.counts[2].Load() != 1 {
+		t.Errorf("3µs not in the (2µs, 4µs] bucket")
+	}
+}
+
+// TestConcurrent updates every metric from many goroutines while the
+// registry is scraped, for the race detector, and checks no update is
+// lost.
+func TestConcurrent(t *testing.T) {
+	r := new(Registry)
+	e := NewEcho(r)
+	const workers, each = 8, 1000
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				r.WriteTo(new(strings.Builder))
+			}
+		}
+	}()
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range each {
+				e.Conns.Add(1)
+				e.Accepted.Inc()
+				e.Latency.ObserveDuration(time.Millisecond)
+				e.BytesIn.Add(2)
+				e.Conns.Add(-1)
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	if e.Conns.Load() != 0 || e.Accepted.Load() != workers*each || e.BytesIn.Load() != 2*workers*each {
+		t.Errorf("conns %d, accepted %d, bytes %d", e.Conns.Load(), e.Accepted.Load(), e.BytesIn.Load())
+	}
+	var b strings.Builder
+	r.WriteTo(&b)
+	if !strings.Contains(b.String(), "echo_request_duration_seconds_count 8000\n") ||
+		!strings.Contains(b.String(), "echo_request_duration_seconds_sum 8.0000000") {
+		t.Errorf("histogram:\n%s", b.String())
+	}
+}
+
+// BenchmarkObserve measures what a handler pays per request to record its
+// latency. Run it with -cpu 1,2,4,8: every goroutine adds to the same
+// bucket and the same sum, so it shows the cost of sharing their cache
+// lines.
+func BenchmarkObserve(b *testing.B) {
+	h := NewHistogram(LatencyBuckets)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			h.ObserveDuration(150 * time.Microsecond)
+		}
+	})
+}
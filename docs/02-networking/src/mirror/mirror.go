@@ -0,0 +1,302 @@
+// Package mirror copies the bytes of a sample of live connections to an
+// analysis sink, so the traffic of a load test can be examined while it
+// runs (which protocols, which requests, how large) without a packet
+// capture and without slowing the connections it watches.
+//
+// A Tap wraps a listener, or single connections. Each accepted connection
+// is picked for mirroring with probability Config.Fraction, and the others
+// are returned unwrapped, so they cost nothing. Sampling whole connections
+// rather than single reads keeps every mirrored stream complete from its
+// first byte, which a protocol parser needs.
+//
+// A mirrored Read or Write copies what it moved into a slot of a bounded
+// ring and returns; it never waits. One goroutine hands the slots to the
+// sink in order. When the sink falls behind and the ring fills, chunks are
+// dropped and counted, and the chunks that follow carry the offset at
+// which their stream resumes, so a parser can tell a gap from a message
+// boundary. The connection itself never loses a byte.
+package mirror
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Dir is the direction of a Chunk.
+type Dir uint8
+
+const (
+	In     Dir = iota // read from the peer
+	Out               // written to the peer
+	Closed            // the connection was closed; the Chunk has no data
+)
+
+func (d Dir) String() string {
+	switch d {
+	case In:
+		return "in"
+	case Out:
+		return "out"
+	case Closed:
+		return "closed"
+	}
+	return "invalid"
+}
+
+// Chunk is a piece of one direction of a mirrored connection.
+type Chunk struct {
+	Conn uint64    // the connection, numbered from 1 in the order they were sampled
+	Dir  Dir       // the direction
+	Off  int64     // offset of Data in the stream of that direction
+	At   time.Time // when the Read or Write returned
+	Data []byte    // valid until the sink returns
+}
+
+// Config controls what a Tap mirrors.
+type Config struct {
+	// Fraction is the share of connections mirrored, from 0 to 1.
+	Fraction float64
+	// Limit caps the bytes mirrored from each direction of a connection,
+	// counted from its start. Protocol detection and request mixes need
+	// only the first few kilobytes. 0 mirrors everything.
+	Limit int64
+	// Slots is the number of chunks the ring holds, rounded up to a power
+	// of two and at least 2. 0 means 1024.
+	Slots int
+	// ChunkSize is the most bytes one slot holds. Longer reads and writes
+	// take several slots. 0 means 2048.
+	ChunkSize int
+}
+
+// Stats counts what a Tap did.
+type Stats struct {
+	Conns        uint64 `json:"conns"`         // connections wrapped
+	Mirrored     uint64 `json:"mirrored"`      // of those, sampled for mirroring
+	Chunks       uint64 `json:"chunks"`        // chunks given to the sink
+	Bytes        uint64 `json:"bytes"`         // in those chunks
+	Dropped      uint64 `json:"dropped"`       // chunks dropped because the ring was full
+	DroppedBytes uint64 `json:"dropped_bytes"` // in those chunks
+}
+
+// idle is how long the sink goroutine sleeps when the ring is empty. It
+// delays analysis, never the connections.
+const idle = time.Millisecond
+
+// Tap mirrors connections into a sink. It is safe for concurrent use.
+type Tap struct {
+	cfg  Config
+	sink func(Chunk)
+	ring *ring
+
+	conns, mirrored atomic.Uint64
+	dropped         atomic.Uint64
+	droppedBytes    atomic.Uint64
+	chunks, bytes   atomic.Uint64 // written by the sink goroutine only
+
+	// A close is never dropped, or a sink keeping state per connection
+	// would leak it: the closes that find the ring full wait here.
+	mu     sync.Mutex
+	closes []Chunk
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns a Tap that gives the chunks it mirrors to sink, from a
+// goroutine of its own, one at a time. Close stops it.
+func New(cfg Config, sink func(Chunk)) *Tap {
+	if cfg.Slots <= 0 {
+		cfg.Slots = 1024
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = 2048
+	}
+	t := &Tap{
+		cfg:  cfg,
+		sink: sink,
+		ring: newRing(cfg.Slots, cfg.ChunkSize),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Close gives the sink what the ring still holds and stops. Connections
+// mirrored afterwards drop everything.
+func (t *Tap) Close() error {
+	select {
+	case <-t.stop:
+	default:
+		close(t.stop)
+	}
+	<-t.done
+	return nil
+}
+
+// Stats returns the counters so far.
+func (t *Tap) Stats() Stats {
+	return Stats{
+		Conns:        t.conns.Load(),
+		Mirrored:     t.mirrored.Load(),
+		Chunks:       t.chunks.Load(),
+		Bytes:        t.bytes.Load(),
+		Dropped:      t.dropped.Load(),
+		DroppedBytes: t.droppedBytes.Load(),
+	}
+}
+
+// ServeHTTP reports Stats as JSON.
+func (t *Tap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t.Stats())
+}
+
+// Conn returns c wrapped for mirroring if it is sampled, and c itself
+// otherwise.
+func (t *Tap) Conn(c net.Conn) net.Conn {
+	t.conns.Add(1)
+	if t.cfg.Fraction <= 0 || t.cfg.Fraction < 1 && rand.Float64() >= t.cfg.Fraction {
+		return c
+	}
+	return &conn{Conn: c, tap: t, id: t.mirrored.Add(1)}
+}
+
+// Listen wraps every connection ln accepts.
+func (t *Tap) Listen(ln net.Listener) net.Listener { return &listener{Listener: ln, tap: t} }
+
+type listener struct {
+	net.Listener
+	tap *Tap
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.tap.Conn(c), nil
+}
+
+type conn struct {
+	net.Conn
+	tap *Tap
+	id  uint64
+
+	// Each offset is used only by the goroutine reading, or writing.
+	in, out int64
+	closed  atomic.Bool
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.in = c.tap.offer(c.id, In, c.in, p[:n])
+	}
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.out = c.tap.offer(c.id, Out, c.out, p[:n])
+	}
+	return n, err
+}
+
+func (c *conn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		c.tap.close(c.id)
+	}
+	return c.Conn.Close()
+}
+
+// offer copies b, found at off in its stream, into the ring as far as the
+// Limit allows, and returns the offset after b.
+func (t *Tap) offer(id uint64, dir Dir, off int64, b []byte) int64 {
+	end := off + int64(len(b))
+	if t.cfg.Limit > 0 {
+		if off >= t.cfg.Limit {
+			return end
+		}
+		b = b[:min(int64(len(b)), t.cfg.Limit-off)]
+	}
+	now := time.Now()
+	for len(b) > 0 {
+		s, pos := t.ring.claim()
+		if s == nil {
+			t.dropped.Add(uint64((len(b) + t.cfg.ChunkSize - 1) / t.cfg.ChunkSize))
+			t.droppedBytes.Add(uint64(len(b)))
+			break
+		}
+		n := copy(s.buf, b)
+		s.conn, s.dir, s.off, s.at, s.n = id, dir, off, now, n
+		t.ring.publish(s, pos)
+		b, off = b[n:], off+int64(n)
+	}
+	return end
+}
+
+func (t *Tap) close(id uint64) {
+	s, pos := t.ring.claim()
+	if s == nil {
+		t.mu.Lock()
+		t.closes = append(t.closes, Chunk{Conn: id, Dir: Closed, At: time.Now()})
+		t.mu.Unlock()
+		return
+	}
+	s.conn, s.dir, s.off, s.at, s.n = id, Closed, 0, time.Now(), 0
+	t.ring.publish(s, pos)
+}
+
+// run is the sink goroutine.
+func (t *Tap) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(idle)
+	defer ticker.Stop()
+	for {
+		if t.drain() > 0 {
+			continue
+		}
+		select {
+		case <-t.stop:
+			for t.drain() > 0 {
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain gives the sink what was published so far, up to a ring's worth so
+// that busy producers cannot hold back the closes that did not fit, then
+// those closes. It returns how many chunks that was.
+func (t *Tap) drain() int {
+	n := 0
+	for range t.ring.mask.Len() {
+		s := t.ring.next()
+		if s == nil {
+			break
+		}
+		t.sink(Chunk{Conn: s.conn, Dir: s.dir, Off: s.off, At: s.at, Data: s.buf[:s.n]})
+		t.chunks.Add(1)
+		t.bytes.Add(uint64(s.n))
+		t.ring.release(s)
+		n++
+	}
+	t.mu.Lock()
+	closes := t.closes
+	t.closes = nil
+	t.mu.Unlock()
+	for _, c := range closes {
+		t.sink(c)
+		t.chunks.Add(1)
+		n++
+	}
+	return n
+}
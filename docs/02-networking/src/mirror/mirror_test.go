@@ -0,0 +1,294 @@
+package mirror
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector is a sink that keeps copies of the chunks it is given.
+type collector struct {
+	mu     sync.Mutex
+	chunks []Chunk
+}
+
+func (c *collector) add(ch Chunk) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch.Data = bytes.Clone(ch.Data)
+	c.chunks = append(c.chunks, ch)
+}
+
+// stream joins the data of one connection and direction, and fails the
+// test if the offsets leave a gap.
+func (c *collector) stream(t *testing.T, conn uint64, dir Dir) []byte {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var b []byte
+	for _, ch := range c.chunks {
+		if ch.Conn != conn || ch.Dir != dir {
+			continue
+		}
+		if ch.Off != int64(len(b)) {
+			t.Fatalf("conn %d %v: chunk at %d after %d bytes", conn, dir, ch.Off, len(b))
+		}
+		b = append(b, ch.Data...)
+	}
+	return b
+}
+
+func (c *collector) closed(conn uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ch := range c.chunks {
+		if ch.Conn == conn && ch.Dir == Closed {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRing(t *testing.T) {
+	r := newRing(3, 8)
+	if r.mask.Len() != 4 {
+		t.Fatalf("%d slots, want 4", r.mask.Len())
+	}
+	for lap := range 3 {
+		for i := range 4 {
+			s, pos := r.claim()
+			if s == nil {
+				t.Fatalf("lap %d: claim %d failed on a ring with room", lap, i)
+			}
+			s.n = i
+			r.publish(s, pos)
+		}
+		if s, _ := r.claim(); s != nil {
+			t.Fatalf("lap %d: claimed a slot of a full ring", lap)
+		}
+		for i := range 4 {
+			s := r.next()
+			if s == nil || s.n != i {
+				t.Fatalf("lap %d: next = %v, want slot %d", lap, s, i)
+			}
+			r.release(s)
+		}
+		if r.next() != nil {
+			t.Fatalf("lap %d: next on an empty ring", lap)
+		}
+	}
+}
+
+// TestRingConcurrent has several producers race one consumer; every chunk
+// is either delivered once or dropped.
+func TestRingConcurrent(t *testing.T) {
+	const producers, each = 4, 20000
+	r := newRing(64, 8)
+	var wg sync.WaitGroup
+	var dropped [producers]int
+	for p := range producers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range each {
+				s, pos := r.claim()
+				if s == nil {
+					dropped[p]++
+					continue
+				}
+				s.conn, s.n = uint64(p), i
+				r.publish(s, pos)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	last := [producers]int{-1, -1, -1, -1}
+	got := 0
+	for finished := false; ; {
+		s := r.next()
+		if s == nil {
+			if finished {
+				break
+			}
+			select {
+			case <-done:
+				finished = true
+			default:
+			}
+			continue
+		}
+		if s.n <= last[s.conn] {
+			t.Fatalf("producer %d: chunk %d after %d", s.conn, s.n, last[s.conn])
+		}
+		last[s.conn] = s.n
+		got++
+		r.release(s)
+	}
+	total := got
+	for _, d := range dropped {
+		total += d
+	}
+	if total != producers*each {
+		t.Errorf("%d delivered + dropped, want %d", total, producers*each)
+	}
+}
+
+func TestListen(t *testing.T) {
+	var col collector
+	tap := New(Config{Fraction: 1, ChunkSize: 16}, col.add)
+	defer tap.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln = tap.Listen(ln)
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(c, c)
+		c.Close()
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := bytes.Repeat([]byte("0123456789"), 10)
+	c.Write(msg)
+	c.(*net.TCPConn).CloseWrite()
+	echoed, err := io.ReadAll(c)
+	c.Close()
+	if err != nil || !bytes.Equal(echoed, msg) {
+		t.Fatalf("echo returned %q, %v", echoed, err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !col.closed(1) {
+		if time.Now().After(deadline) {
+			t.Fatal("no close mirrored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if in := col.stream(t, 1, In); !bytes.Equal(in, msg) {
+		t.Errorf("mirrored in %q, want %q", in, msg)
+	}
+	if out := col.stream(t, 1, Out); !bytes.Equal(out, msg) {
+		t.Errorf("mirrored out %q, want %q", out, msg)
+	}
+	if s := tap.Stats(); s.Conns != 1 || s.Mirrored != 1 || s.Bytes != 200 || s.Dropped != 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+// fakeConn reads and writes instantly.
+type fakeConn struct{ net.Conn }
+
+func (fakeConn) Read(p []byte) (int, error)  { return len(p), nil }
+func (fakeConn) Write(p []byte) (int, error) { return len(p), nil }
+func (fakeConn) Close() error                { return nil }
+
+func TestFraction(t *testing.T) {
+	tap := New(Config{Fraction: 0.25}, func(Chunk) {})
+	defer tap.Close()
+	wrapped := 0
+	for range 4000 {
+		if _, ok := tap.Conn(fakeConn{}).(*conn); ok {
+			wrapped++
+		}
+	}
+	if wrapped < 800 || wrapped > 1200 {
+		t.Errorf("%d of 4000 connections mirrored at 0.25", wrapped)
+	}
+	if s := tap.Stats(); s.Conns != 4000 || s.Mirrored != uint64(wrapped) {
+		t.Errorf("stats %+v, want %d mirrored of 4000", s, wrapped)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	var col collector
+	tap := New(Config{Fraction: 1, Limit: 10}, col.add)
+	c := tap.Conn(fakeConn{})
+	c.Write([]byte("0123456"))
+	c.Write([]byte("789abc"))
+	c.Write([]byte("def"))
+	c.Close()
+	tap.Close()
+	if out := col.stream(t, 1, Out); string(out) != "0123456789" {
+		t.Errorf("mirrored %q, want the first 10 bytes", out)
+	}
+}
+
+// TestDrop fills the ring behind a stalled sink. Writes still succeed, the
+// overflow is counted, the stream resumes at the right offset, and the
+// close is delivered although the ring was full.
+func TestDrop(t *testing.T) {
+	var col collector
+	stall := make(chan struct{})
+	tap := New(Config{Fraction: 1, Slots: 4, ChunkSize: 4}, func(ch Chunk) {
+		<-stall
+		col.add(ch)
+	})
+	c := tap.Conn(fakeConn{})
+	// The sink takes the first chunk and blocks on it, four more fill
+	// the ring, and the rest are dropped.
+	for i := range 10 {
+		if n, err := c.Write([]byte{'a' + byte(i), 0, 0, 0}); n != 4 || err != nil {
+			t.Fatalf("write %d: %d, %v", i, n, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Close()
+	close(stall)
+	tap.Close()
+
+	s := tap.Stats()
+	if s.Chunks+s.Dropped != 11 || s.Dropped == 0 || s.DroppedBytes != 4*s.Dropped {
+		t.Errorf("stats %+v, want 11 chunks delivered or dropped", s)
+	}
+	if !col.closed(1) {
+		t.Error("close was dropped")
+	}
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	for i, ch := range col.chunks {
+		if ch.Dir == Out && ch.Off != int64(ch.Data[0]-'a')*4 {
+			t.Errorf("chunk %d: %q at offset %d", i, ch.Data, ch.Off)
+		}
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	msg := make([]byte, 512)
+	for _, bc := range []struct {
+		name string
+		cfg  Config
+		sink func(Chunk)
+	}{
+		{"plain", Config{}, func(Chunk) {}},
+		{"mirrored", Config{Fraction: 1}, func(Chunk) {}},
+		// A sink that never keeps up: every write past the first lap
+		// finds the ring full.
+		{"dropping", Config{Fraction: 1, Slots: 1}, func(Chunk) { time.Sleep(time.Hour) }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			tap := New(bc.cfg, bc.sink)
+			c := tap.Conn(fakeConn{})
+			b.SetBytes(int64(len(msg)))
+			b.ReportAllocs()
+			for b.Loop() {
+				c.Write(msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package mirror
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/sniff"
+)
+
+// Mix is a sink that tells which protocols the mirrored connections speak,
+// from their first bytes (see the sniff package), and counts HTTP/1
+// requests by method and path:
+//
+//	mix := mirror.NewMix()
+//	tap := mirror.New(mirror.Config{Fraction: 0.01, Limit: 64 << 10}, mix.Add)
+//
+// Requests are found by their request lines, so a connection whose
+// requests reach past Limit contributes only its first ones, and a
+// request line that straddles a dropped chunk is not counted.
+type Mix struct {
+	mu       sync.Mutex
+	flows    map[uint64]*flow
+	protos   map[sniff.Protocol]*ProtoStats
+	requests map[string]uint64
+}
+
+// ProtoStats counts the connections of one protocol.
+type ProtoStats struct {
+	Conns    uint64 `json:"conns"`
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+}
+
+// Report is the mix so far. Connections whose protocol is not known yet
+// are left out.
+type Report struct {
+	Protocols map[string]ProtoStats `json:"protocols"`
+	Requests  map[string]uint64     `json:"requests,omitempty"` // by "METHOD /path", without the query
+}
+
+// maxRequestKeys bounds the request table, which a crawler or a fuzzer
+// would otherwise grow without end. Further paths count under "other".
+const maxRequestKeys = 1000
+
+// maxLine is the longest request line parsed. Longer lines are skipped.
+const maxLine = 512
+
+// flow is what Mix keeps per open connection.
+type flow struct {
+	prefix   []byte // the first bytes read, while the protocol is undecided
+	decided  bool
+	proto    sniff.Protocol
+	in, out  uint64 // bytes seen before the protocol was decided
+	next     int64  // offset of the next In chunk, unless one was dropped
+	line     []byte // the incomplete line at the end of the last In chunk
+	skipping bool   // discarding up to the next newline
+}
+
+// NewMix returns an empty Mix.
+func NewMix() *Mix {
+	return &Mix{
+		flows:    make(map[uint64]*flow),
+		protos:   make(map[sniff.Protocol]*ProtoStats),
+		requests: make(map[string]uint64),
+	}
+}
+
+// Add accounts for c. It is the sink to give a Tap.
+func (m *Mix) Add(c Chunk) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.flows[c.Conn]
+	if f == nil {
+		f = &flow{}
+		m.flows[c.Conn] = f
+	}
+	switch c.Dir {
+	case Closed:
+		if !f.decided {
+			m.decide(f, sniff.Unknown)
+		}
+		delete(m.flows, c.Conn)
+	case Out:
+		if f.decided {
+			m.protos[f.proto].BytesOut += uint64(len(c.Data))
+		} else {
+			f.out += uint64(len(c.Data))
+		}
+	case In:
+		if f.decided {
+			m.protos[f.proto].BytesIn += uint64(len(c.Data))
+		} else {
+			f.in += uint64(len(c.Data))
+			m.detect(f, c)
+		}
+		if c.Off != f.next {
+			f.line, f.skipping = f.line[:0], true
+		}
+		f.next = c.Off + int64(len(c.Data))
+		if !f.decided || f.proto == sniff.HTTP1 {
+			m.scan(f, c.Data)
+		}
+	}
+}
+
+// detect extends f's prefix with c and decides the protocol once it can.
+// A prefix with a gap in it cannot be trusted, so it decides Unknown.
+func (m *Mix) detect(f *flow, c Chunk) {
+	if c.Off != int64(len(f.prefix)) {
+		m.decide(f, sniff.Unknown)
+		return
+	}
+	f.prefix = append(f.prefix, c.Data[:min(len(c.Data), sniff.MaxPrefix-len(f.prefix))]...)
+	if p, err := sniff.Detect(f.prefix); err == nil {
+		m.decide(f, p)
+	}
+}
+
+func (m *Mix) decide(f *flow, p sniff.Protocol) {
+	s := m.protos[p]
+	if s == nil {
+		s = &ProtoStats{}
+		m.protos[p] = s
+	}
+	s.Conns++
+	s.BytesIn += f.in
+	s.BytesOut += f.out
+	f.decided, f.proto, f.prefix = true, p, nil
+}
+
+// scan splits b into lines and counts the request lines among them.
+func (m *Mix) scan(f *flow, b []byte) {
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			if !f.skipping {
+				if len(f.line)+len(b) > maxLine {
+					f.line, f.skipping = f.line[:0], true
+				} else {
+					f.line = append(f.line, b...)
+				}
+			}
+			return
+		}
+		if f.skipping {
+			f.skipping = false
+		} else if len(f.line)+i <= maxLine {
+			f.line = append(f.line, b[:i]...)
+			m.request(f, f.line)
+		}
+		f.line, b = f.line[:0], b[i+1:]
+	}
+}
+
+// request counts line if it is an HTTP/1 request line on an HTTP/1
+// connection.
+func (m *Mix) request(f *flow, line []byte) {
+	if !f.decided || f.proto != sniff.HTTP1 {
+		return
+	}
+	method, rest, ok := bytes.Cut(bytes.TrimSuffix(line, []byte("\r")), []byte(" "))
+	if !ok || !isToken(method) {
+		return
+	}
+	target, version, ok := bytes.Cut(rest, []byte(" "))
+	if !ok || !bytes.HasPrefix(version, []byte("HTTP/1.")) || len(target) == 0 {
+		return
+	}
+	target, _, _ = bytes.Cut(target, []byte("?"))
+	key := string(method) + " " + string(target)
+	if _, ok := m.requests[key]; !ok && len(m.requests) >= maxRequestKeys {
+		key = "other"
+	}
+	m.requests[key]++
+}
+
+// isToken reports whether b looks like a request method.
+func isToken(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Report returns the mix so far.
+func (m *Mix) Report() Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := Report{Protocols: make(map[string]ProtoStats, len(m.protos)), Requests: make(map[string]uint64, len(m.requests))}
+	for p, s := range m.protos {
+		r.Protocols[p.String()] = *s
+	}
+	for k, n := range m.requests {
+		r.Requests[k] = n
+	}
+	return r
+}
+
+// ServeHTTP reports the mix as JSON.
+func (m *Mix) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Report())
+}
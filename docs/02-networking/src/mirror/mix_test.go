@@ -0,0 +1,60 @@
+package mirror
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMix(t *testing.T) {
+	m := NewMix()
+	chunks := []Chunk{
+		// An HTTP/1 connection whose first read is too short to decide,
+		// with a request line split across reads.
+		{Conn: 1, Dir: In, Off: 0, Data: []byte("GE")},
+		{Conn: 1, Dir: In, Off: 2, Data: []byte("T /fast?x=1 HTTP/1.1\r\nHost: a\r\n\r\nPOST /sl")},
+		{Conn: 1, Dir: In, Off: 43, Data: []byte("ow HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc")},
+		{Conn: 1, Dir: Out, Off: 0, Data: []byte("HTTP/1.1 200 OK\r\n\r\n")},
+		// A dropped chunk: the line that resumes mid-way is not a request.
+		{Conn: 1, Dir: In, Off: 120, Data: []byte("T /lost HTTP/1.1\r\n\r\nGET /fast HTTP/1.1\r\n\r\n")},
+		{Conn: 1, Dir: Closed},
+		{Conn: 2, Dir: In, Off: 0, Data: []byte("\x16\x03\x01\x00\x10GET / HTTP/1.1\r\n")},
+		{Conn: 2, Dir: Closed},
+		// First chunk dropped.
+		{Conn: 3, Dir: In, Off: 100, Data: []byte("GET /x HTTP/1.1\r\n")},
+		{Conn: 3, Dir: Closed},
+		// Still undecided.
+		{Conn: 4, Dir: In, Off: 0, Data: []byte("P")},
+	}
+	for _, c := range chunks {
+		m.Add(c)
+	}
+	want := Report{
+		Protocols: map[string]ProtoStats{
+			"http1":   {Conns: 1, BytesIn: 2 + 41 + 37 + 42, BytesOut: 19},
+			"tls":     {Conns: 1, BytesIn: 21},
+			"unknown": {Conns: 1, BytesIn: 17},
+		},
+		Requests: map[string]uint64{"GET /fast": 2, "POST /slow": 1},
+	}
+	if got := m.Report(); !reflect.DeepEqual(got, want) {
+		t.Errorf("report\n%+v\nwant\n%+v", got, want)
+	}
+	if len(m.flows) != 1 {
+		t.Errorf("%d flows kept, want the open one", len(m.flows))
+	}
+}
+
+func TestMixRequestKeys(t *testing.T) {
+	m := NewMix()
+	m.Add(Chunk{Conn: 1, Dir: In, Data: []byte("GET / HTTP/1.1\r\n")})
+	off := int64(16)
+	for i := range maxRequestKeys + 5 {
+		line := []byte("GET /" + string(rune('a'+i%26)) + string(rune('a'+i/26%26)) + string(rune('a'+i/676)) + " HTTP/1.1\r\n")
+		m.Add(Chunk{Conn: 1, Dir: In, Off: off, Data: line})
+		off += int64(len(line))
+	}
+	r := m.Report()
+	if len(r.Requests) != maxRequestKeys+1 || r.Requests["other"] != 6 {
+		t.Errorf("%d request keys, %d other; want %d and 6", len(r.Requests), r.Requests["other"], maxRequestKeys+1)
+	}
+}
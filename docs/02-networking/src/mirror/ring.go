@@ -0,0 +1,93 @@
+package mirror
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/pow2"
+)
+
+// slot is one chunk in the ring, with its buffer allocated up front.
+type slot struct {
+	// seq says whose turn it is. A slot at position pos is free for the
+	// producer claiming pos while seq == pos, holds a chunk for the
+	// consumer while seq == pos+1, and becomes free for the producer one
+	// lap later when the consumer sets seq to pos+len(slots).
+	seq atomic.Uint64
+
+	conn uint64
+	dir  Dir
+	off  int64
+	at   time.Time
+	n    int
+	buf  []byte
+}
+
+// ring is a bounded queue for many producers and one consumer, after
+// Dmitry Vyukov's bounded MPMC queue. A producer claims a position with
+// one compare-and-swap and never waits: if the slot at that position has
+// not been consumed yet the ring is full and claim fails. The consumer
+// alone moves head, so it needs no atomic operation beyond seq.
+type ring struct {
+	mask  pow2.Mask
+	slots []slot
+
+	_    [64]byte // keeps tail, written by every producer, off the lines above
+	tail atomic.Uint64
+	_    [56]byte
+	head uint64
+}
+
+// newRing returns a ring of at least n slots of size bytes. It has two
+// slots at least: with one, a published slot's seq of pos+1 would read as
+// free to the producer claiming pos+1, which would overwrite a chunk the
+// consumer has not seen.
+func newRing(n, size int) *ring {
+	n = pow2.NextPow2(max(n, 2))
+	r := &ring{mask: pow2.MaskFor(n), slots: make([]slot, n)}
+	bufs := make([]byte, n*size)
+	for i := range r.slots {
+		r.slots[i].seq.Store(uint64(i))
+		r.slots[i].buf = bufs[i*size : (i+1)*size : (i+1)*size]
+	}
+	return r
+}
+
+// claim returns a free slot and its position, or nil if the ring is full.
+// The slot must be given back with publish.
+func (r *ring) claim() (*slot, uint64) {
+	for {
+		pos := r.tail.Load()
+		s := &r.slots[r.mask.Index(int(pos))]
+		seq := s.seq.Load()
+		switch {
+		case seq == pos:
+			if r.tail.CompareAndSwap(pos, pos+1) {
+				return s, pos
+			}
+		case seq < pos:
+			return nil, 0 // the consumer is a lap behind
+		}
+		// Another producer claimed pos first; try the next one.
+	}
+}
+
+// publish hands a claimed slot to the consumer.
+func (r *ring) publish(s *slot, pos uint64) { s.seq.Store(pos + 1) }
+
+// next returns the oldest published slot, or nil if there is none yet.
+// Only the consumer calls it, and it must release the slot before the
+// next call.
+func (r *ring) next() *slot {
+	s := &r.slots[r.mask.Index(int(r.head))]
+	if s.seq.Load() != r.head+1 {
+		return nil
+	}
+	return s
+}
+
+// release frees the slot next returned for the producers' next lap.
+func (r *ring) release(s *slot) {
+	s.seq.Store(r.head + uint64(len(r.slots)))
+	r.head++
+}
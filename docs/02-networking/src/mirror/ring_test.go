@@ -0,0 +1,169 @@
+package mirror
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"testing"
+)
+
+// ringModel is the queue the ring should behave like: positions handed out
+// in order, each published or not, and consumed strictly from the head.
+type ringModel struct {
+	cap        uint64
+	head, tail uint64
+	published  map[uint64]bool // claimed positions not yet released
+}
+
+// TestRingModel runs random sequences of claim, publish, next and release
+// on one goroutine and checks each result against ringModel. Publishing
+// out of order, a full ring with unpublished slots and a consumer that
+// peeks without releasing are all reached within a few hundred steps.
+func TestRingModel(t *testing.T) {
+	for seed := range uint64(200) {
+		rnd := rand.New(rand.NewPCG(seed, 1))
+		r := newRing(1+rnd.IntN(8), 4)
+		m := ringModel{cap: uint64(r.mask.Len()), published: map[uint64]bool{}}
+		claimed := map[uint64]*slot{}
+		var trace []string
+		fail := func(format string, args ...any) {
+			t.Helper()
+			t.Fatalf("seed %d, %d slots, after %v: %s", seed, m.cap, trace, fmt.Sprintf(format, args...))
+		}
+
+		for range 300 {
+			switch op := rnd.IntN(4); op {
+			case 0:
+				trace = append(trace, "claim")
+				s, pos := r.claim()
+				if m.tail-m.head == m.cap {
+					if s != nil {
+						fail("claimed position %d of a full ring", pos)
+					}
+					continue
+				}
+				if s == nil || pos != m.tail {
+					fail("claim = %v, %d; want position %d", s != nil, pos, m.tail)
+				}
+				s.n = int(pos)
+				s.buf[0] = byte(pos)
+				claimed[pos] = s
+				m.published[pos] = false
+				m.tail++
+			case 1:
+				// Publish a random claimed slot, not necessarily the oldest.
+				var unpublished []uint64
+				for pos, ok := range m.published {
+					if !ok {
+						unpublished = append(unpublished, pos)
+					}
+				}
+				if len(unpublished) == 0 {
+					continue
+				}
+				pos := unpublished[rnd.IntN(len(unpublished))]
+				trace = append(trace, fmt.Sprint("publish ", pos))
+				r.publish(claimed[pos], pos)
+				m.published[pos] = true
+			case 2, 3:
+				s := r.next()
+				if !m.published[m.head] {
+					if s != nil {
+						fail("next returned position %d before %d was published", s.n, m.head)
+					}
+					trace = append(trace, "next: none")
+					continue
+				}
+				if s == nil || s.n != int(m.head) || s.buf[0] != byte(m.head) {
+					fail("next = %v, want position %d", s, m.head)
+				}
+				if op == 3 {
+					trace = append(trace, "peek")
+					continue // the next call must return the same slot
+				}
+				trace = append(trace, "release")
+				r.release(s)
+				delete(m.published, m.head)
+				delete(claimed, m.head)
+				m.head++
+			}
+		}
+	}
+}
+
+// TestRingConcurrentProperty races producers against one consumer over
+// random ring sizes and producer counts. Every chunk must be delivered at
+// most once, in each producer's order, with the bytes its producer wrote,
+// or be counted as dropped; nothing else may happen to it.
+func TestRingConcurrentProperty(t *testing.T) {
+	const each, size = 5000, 16
+	for seed := range uint64(10) {
+		rnd := rand.New(rand.NewPCG(seed, 2))
+		producers := 1 + rnd.IntN(8)
+		r := newRing(1+rnd.IntN(64), size)
+		var wg sync.WaitGroup
+		dropped := make([]int, producers)
+		for p := range producers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range each {
+					s, pos := r.claim()
+					if s == nil {
+						dropped[p]++
+						continue
+					}
+					s.conn, s.n = uint64(p), i
+					for j := range s.buf {
+						s.buf[j] = byte(p*each + i + j)
+					}
+					r.publish(s, pos)
+				}
+			}()
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+
+		last := make([]int, producers)
+		for i := range last {
+			last[i] = -1
+		}
+		got := 0
+		for finished := false; ; {
+			s := r.next()
+			if s == nil {
+				if finished {
+					break
+				}
+				select {
+				case <-done:
+					finished = true
+				default:
+				}
+				continue
+			}
+			p := int(s.conn)
+			if s.n <= last[p] {
+				t.Fatalf("seed %d: producer %d: chunk %d after %d", seed, p, s.n, last[p])
+			}
+			for j, b := range s.buf {
+				if want := byte(p*each + s.n + j); b != want {
+					t.Fatalf("seed %d: producer %d chunk %d byte %d = %d, want %d: torn read", seed, p, s.n, j, b, want)
+				}
+			}
+			last[p] = s.n
+			got++
+			r.release(s)
+		}
+		total := got
+		for _, d := range dropped {
+			total += d
+		}
+		if total != producers*each {
+			t.Errorf("seed %d: %d delivered + dropped, want %d", seed, total, producers*each)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+// Package mmsg moves UDP datagrams in batches with Linux's recvmmsg and
+// sendmmsg, one syscall for up to a whole batch instead of one per
+// datagram.
+//
+// A UDP server on net.UDPConn makes two syscalls per packet, a recvfrom
+// and a sendto, and at a few hundred thousand packets a second the
+// syscalls are most of its CPU time. recvmmsg fills as many buffers as
+// there are datagrams queued, up to the batch size, and sendmmsg sends a
+// batch of replies the same way. Under load each syscall carries many
+// packets; when the socket is quiet, each carries one and costs what a
+// recvfrom would:
+//
+//	b := mmsg.NewBatch(64)
+//	msgs := make([]mmsg.Message, 64) // each with its own Buf
+//	n, err := b.Recv(fd, msgs)       // EAGAIN when nothing is queued
+//	for i := range msgs[:n] {
+//		// msgs[i].Buf[:msgs[i].N] came from msgs[i].Addr
+//	}
+//	sent, err := b.Send(fd, msgs[:n])
+//
+// The fd is a raw non-blocking UDP socket, such as one registered with
+// the poller package. A Batch holds the kernel's headers and address
+// buffers for one batch and is not safe for concurrent use.
+//
+// A batch leaves the sender as a burst, and a shallow queue downstream
+// drops its tail. A Pacer sends through a Batch in smaller sends spaced
+// out by a token bucket.
+package mmsg
@@ -0,0 +1,152 @@
+//go:build linux
+
+package mmsg
+
+import (
+	"net/netip"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Message is one datagram. Recv fills Buf up to its length and sets N,
+// Addr and Trunc; Send sends Buf[:N] to Addr.
+type Message struct {
+	Buf   []byte
+	N     int
+	Addr  netip.AddrPort
+	Trunc bool // the datagram was longer than Buf and its tail was lost
+}
+
+// mmsghdr is struct mmsghdr: a msghdr and the byte count the kernel
+// returns for it. Go pads it to the msghdr's alignment, as C does.
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// Batch is the kernel-facing state for up to Cap datagrams per syscall:
+// one header, iovec and address buffer per datagram, allocated once.
+type Batch struct {
+	hdrs  []mmsghdr
+	iovs  []unix.Iovec
+	names []unix.RawSockaddrInet6 // large enough for either family
+}
+
+// NewBatch returns a Batch of n datagrams per syscall.
+func NewBatch(n int) *Batch {
+	if n <= 0 {
+		panic("mmsg: batch size must be positive")
+	}
+	return &Batch{
+		hdrs:  make([]mmsghdr, n),
+		iovs:  make([]unix.Iovec, n),
+		names: make([]unix.RawSockaddrInet6, n),
+	}
+}
+
+// Cap returns the most datagrams one Recv or Send moves.
+func (b *Batch) Cap() int { return len(b.hdrs) }
+
+// Recv receives up to min(len(msgs), Cap()) queued datagrams from fd with
+// one recvmmsg and returns how many it received. On a non-blocking fd
+// with nothing queued it returns EAGAIN. An AF_INET6 socket reports IPv4
+// peers as IPv4-mapped addresses, which Send accepts back as they are.
+func (b *Batch) Recv(fd int, msgs []Message) (int, error) {
+	n := min(len(msgs), len(b.hdrs))
+	if n == 0 {
+		return 0, nil
+	}
+	for i := range n {
+		b.iovs[i].Base = unsafe.SliceData(msgs[i].Buf)
+		b.iovs[i].SetLen(len(msgs[i].Buf))
+		h := &b.hdrs[i].hdr
+		*h = unix.Msghdr{
+			Name:    (*byte)(unsafe.Pointer(&b.names[i])),
+			Namelen: unix.SizeofSockaddrInet6,
+			Iov:     &b.iovs[i],
+		}
+		h.SetIovlen(1)
+	}
+	r, _, errno := unix.Syscall6(unix.SYS_RECVMMSG, uintptr(fd), uintptr(unsafe.Pointer(&b.hdrs[0])), uintptr(n), 0, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	got := int(r)
+	for i := range got {
+		h := &b.hdrs[i]
+		m := &msgs[i]
+		m.N = min(int(h.len), len(m.Buf))
+		m.Trunc = h.hdr.Flags&unix.MSG_TRUNC != 0
+		m.Addr = fromRaw(&b.names[i])
+	}
+	return got, nil
+}
+
+// Send sends msgs[i].Buf[:msgs[i].N] to msgs[i].Addr for up to
+// min(len(msgs), Cap()) messages with one sendmmsg, and returns how many
+// the kernel took. Fewer than asked means the socket's send buffer
+// filled; the rest can be retried once fd is writable. If not even the
+// first fits, Send returns EAGAIN.
+func (b *Batch) Send(fd int, msgs []Message) (int, error) {
+	n := min(len(msgs), len(b.hdrs))
+	if n == 0 {
+		return 0, nil
+	}
+	for i := range n {
+		m := &msgs[i]
+		b.iovs[i].Base = unsafe.SliceData(m.Buf)
+		b.iovs[i].SetLen(m.N)
+		h := &b.hdrs[i].hdr
+		*h = unix.Msghdr{
+			Name:    (*byte)(unsafe.Pointer(&b.names[i])),
+			Namelen: toRaw(&b.names[i], m.Addr),
+			Iov:     &b.iovs[i],
+		}
+		h.SetIovlen(1)
+	}
+	r, _, errno := unix.Syscall6(unix.SYS_SENDMMSG, uintptr(fd), uintptr(unsafe.Pointer(&b.hdrs[0])), uintptr(n), 0, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+
+// fromRaw returns the address in a sockaddr the kernel filled in. The
+// port is in network byte order in either family.
+func fromRaw(raw *unix.RawSockaddrInet6) netip.AddrPort {
+	switch raw.Family {
+	case syscall.AF_INET:
+		sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(raw))
+		return netip.AddrPortFrom(netip.AddrFrom4(sa.Addr), port(&sa.Port))
+	case syscall.AF_INET6:
+		return netip.AddrPortFrom(netip.AddrFrom16(raw.Addr), port(&raw.Port))
+	}
+	return netip.AddrPort{}
+}
+
+// toRaw writes ap into raw as a sockaddr of its own family and returns
+// the sockaddr's length.
+func toRaw(raw *unix.RawSockaddrInet6, ap netip.AddrPort) uint32 {
+	p := ap.Port()
+	if a := ap.Addr(); a.Is4() {
+		sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(raw))
+		*sa = unix.RawSockaddrInet4{Family: syscall.AF_INET, Addr: a.As4()}
+		setPort(&sa.Port, p)
+		return unix.SizeofSockaddrInet4
+	}
+	*raw = unix.RawSockaddrInet6{Family: syscall.AF_INET6, Addr: ap.Addr().As16()}
+	setPort(&raw.Port, p)
+	return unix.SizeofSockaddrInet6
+}
+
+func port(p *uint16) uint16 {
+	b := (*[2]byte)(unsafe.Pointer(p))
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+func setPort(p *uint16, port uint16) {
+	b := (*[2]byte)(unsafe.Pointer(p))
+	b[0], b[1] = byte(port>>8), byte(port)
+}
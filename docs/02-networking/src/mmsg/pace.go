@@ -0,0 +1,75 @@
+//go:build linux
+
+package mmsg
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/ratelimit"
+)
+
+// Pacer sends through a Batch at a steady rate. A send loop that hands
+// sendmmsg a whole batch puts the datagrams on the wire back to back, as
+// fast as the interface takes them. Whatever queue they meet downstream,
+// a shaped link, a switch port or the receiver's socket buffer, holds
+// only so many before it drops the rest, so the tail of a large enough
+// burst is lost even when the average rate fits. Pacer cuts the batch
+// into sends of burst datagrams and sleeps between them, so that they
+// leave at rate on average.
+//
+// It is meant for a send loop with a goroutine of its own, such as a game
+// server's tick; it blocks while it paces. Like a Batch it is not safe
+// for concurrent use.
+type Pacer struct {
+	b      *Batch
+	bucket ratelimit.Local
+	burst  int
+
+	Sends  uint64        // sendmmsg calls
+	Sleeps uint64        // waits for the bucket
+	Slept  time.Duration // time spent in them
+}
+
+// NewPacer returns a Pacer that sends through b at rate datagrams a
+// second, in sends of burst, the last of a Send's possibly fewer. A
+// burst of one datagram gives the smoothest stream and a sendmmsg per
+// datagram; a larger one amortizes the syscall over burst datagrams, and
+// a downstream queue has to hold that many.
+func NewPacer(b *Batch, rate float64, burst int) *Pacer {
+	burst = min(max(burst, 1), b.Cap())
+	return &Pacer{b: b, bucket: ratelimit.NewLocal(rate, burst), burst: burst}
+}
+
+// Send sends msgs to fd as Batch.Send does, but all of them, in paced
+// sendmmsg calls, and returns how many the kernel took. It takes about
+// len(msgs)/rate once the bucket's first burst is spent. A send the
+// socket has no room for is retried for the rest of its datagrams; if
+// none of them fit, Send returns EAGAIN with the count so far.
+func (p *Pacer) Send(fd int, msgs []Message) (int, error) {
+	sent := 0
+	for sent < len(msgs) {
+		// Wait for a whole send's worth: sending each token as it comes
+		// would pay a syscall, and a sleep, per datagram.
+		n := min(len(msgs)-sent, p.burst)
+		if wait := p.bucket.Until(n); wait > 0 {
+			p.Sleeps++
+			p.Slept += wait
+			time.Sleep(wait)
+			continue
+		}
+		p.bucket.AllowN(n)
+		for end := sent + n; sent < end; {
+			p.Sends++
+			k, err := p.b.Send(fd, msgs[sent:end])
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				return sent, err
+			}
+			sent += k
+		}
+	}
+	return sent, nil
+}
@@ -0,0 +1,192 @@
+//go:build linux
+
+package mmsg
+
+import (
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/vnet"
+)
+
+// receiver counts the datagrams that reach pc until it is closed.
+func receiver(tb testing.TB, pc net.PacketConn) *atomic.Int64 {
+	var got atomic.Int64
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			if _, _, err := pc.ReadFrom(buf); err != nil {
+				return
+			}
+			got.Add(1)
+		}
+	}()
+	tb.Cleanup(func() { pc.Close() })
+	return &got
+}
+
+func TestPacer(t *testing.T) {
+	const n, rate, burst = 64, 6400, 8
+	lo := netip.MustParseAddr("127.0.0.1")
+	pc, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(lo, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := receiver(t, pc)
+	fd, _ := socket(t, lo)
+	msgs := messages(n, 100)
+	for i := range msgs {
+		msgs[i].N, msgs[i].Addr = 100, pc.LocalAddr().(*net.UDPAddr).AddrPort()
+	}
+
+	p := NewPacer(NewBatch(64), rate, burst)
+	start := time.Now()
+	sent, err := p.Send(fd, msgs)
+	elapsed := time.Since(start)
+	if sent != n || err != nil {
+		t.Fatalf("Send = %d, %v", sent, err)
+	}
+	// The first burst goes at once and the rest at the rate.
+	if want := time.Duration(n-burst) * time.Second / rate; elapsed < want {
+		t.Errorf("sent %d datagrams in %v, want at least %v", n, elapsed, want)
+	}
+	if p.Sends < n/burst {
+		t.Errorf("%d sends, want sends of at most %d datagrams", p.Sends, burst)
+	}
+	for deadline := time.Now().Add(time.Second); got.Load() < n && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if got.Load() != n {
+		t.Errorf("received %d of %d", got.Load(), n)
+	}
+}
+
+// tick is a game server's send loop in miniature: every period it sends
+// a state update of size bytes to each of n clients, all at once or
+// paced at rate datagrams a second in sends of at most burst.
+type tick struct {
+	n, size int
+	period  time.Duration
+	rate    float64
+	burst   int
+}
+
+// run sends b.N ticks from fd to to and reports the share of datagrams
+// that never reached got. For a paced run, rate should leave part of the
+// period idle, or the sender falls behind the ticks.
+func (tk tick) run(b *testing.B, fd int, to netip.AddrPort, got *atomic.Int64) {
+	batch := NewBatch(64)
+	msgs := messages(tk.n, tk.size)
+	for i := range msgs {
+		msgs[i].N, msgs[i].Addr = tk.size, to
+	}
+	var p *Pacer
+	if tk.rate > 0 {
+		p = NewPacer(batch, tk.rate, tk.burst)
+	}
+	sent, sends := 0, 0
+	var sending time.Duration
+	next := time.Now()
+	for b.Loop() {
+		start := time.Now()
+		if p != nil {
+			n, err := p.Send(fd, msgs)
+			sent += n
+			if err != nil && err != syscall.EAGAIN && err != syscall.ENOBUFS {
+				b.Fatal(err)
+			}
+		} else {
+			for off := 0; off < len(msgs); {
+				n, err := batch.Send(fd, msgs[off:])
+				sends++
+				if err != nil {
+					if err != syscall.EAGAIN && err != syscall.ENOBUFS {
+						b.Fatal(err)
+					}
+					break
+				}
+				off += n
+				sent += n
+			}
+		}
+		sending += time.Since(start)
+		next = next.Add(tk.period)
+		time.Sleep(time.Until(next))
+	}
+	time.Sleep(100 * time.Millisecond) // what is in flight lands
+	if p != nil {
+		sends = int(p.Sends)
+	}
+	b.ReportMetric(100*(1-float64(got.Load())/float64(b.N*tk.n)), "lost-%")
+	b.ReportMetric(100*(1-float64(sent)/float64(b.N*tk.n)), "unsent-%")
+	b.ReportMetric(float64(sends)/float64(b.N), "sends/tick")
+	b.ReportMetric(sending.Seconds()*1e3/float64(b.N), "send-ms/tick")
+}
+
+// BenchmarkTick sends ticks of 128 datagrams of 1200 bytes over loopback
+// to a receiver with a 64 KiB socket buffer, which holds about half of
+// them. An unpaced tick arrives faster than the receiver runs, and the
+// buffer overflows; paced over half the tick, the receiver keeps up.
+func BenchmarkTick(b *testing.B) {
+	lo := netip.MustParseAddr("127.0.0.1")
+	for _, tk := range []struct {
+		name string
+		tick
+	}{
+		{"unpaced", tick{n: 128, size: 1200, period: 20 * time.Millisecond}},
+		{"paced/burst=8", tick{n: 128, size: 1200, period: 20 * time.Millisecond, rate: 12800, burst: 8}},
+		{"paced/burst=32", tick{n: 128, size: 1200, period: 20 * time.Millisecond, rate: 12800, burst: 32}},
+	} {
+		b.Run(tk.name, func(b *testing.B) {
+			pc, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(lo, 0)))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := pc.SetReadBuffer(64 << 10); err != nil {
+				b.Fatal(err)
+			}
+			got := receiver(b, pc)
+			fd, _ := socket(b, lo)
+			tk.run(b, fd, pc.LocalAddr().(*net.UDPAddr).AddrPort(), got)
+		})
+	}
+}
+
+// BenchmarkTickNetem sends ticks of 128 datagrams of 1200 bytes across a
+// 20 Mbit/s link whose queue holds 32 packets. A tick averages 12 Mbit/s,
+// which the link carries, but unpaced it arrives as one burst and the
+// queue drops what it cannot hold. It needs root and sch_netem.
+func BenchmarkTickNetem(b *testing.B) {
+	const period = 100 * time.Millisecond
+	for _, tk := range []struct {
+		name string
+		tick
+	}{
+		{"unpaced", tick{n: 128, size: 1200, period: period}},
+		{"paced/burst=4", tick{n: 128, size: 1200, period: period, rate: 1700, burst: 4}},
+		{"paced/burst=16", tick{n: 128, size: 1200, period: period, rate: 1700, burst: 16}},
+	} {
+		b.Run(tk.name, func(b *testing.B) {
+			n := vnet.New(b, vnet.Link{Rate: "20mbit", Limit: 32})
+			h := n.Hosts[0]
+			pc, err := h.ListenPacket("udp", netip.AddrPortFrom(h.Addr, 0).String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			got := receiver(b, pc)
+			var fd int
+			if err := n.Client.Do(func() error {
+				fd, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+				return err
+			}); err != nil {
+				b.Fatal(err)
+			}
+			b.Cleanup(func() { syscall.Close(fd) })
+			tk.run(b, fd, pc.LocalAddr().(*net.UDPAddr).AddrPort(), got)
+		})
+	}
+}
@@ -0,0 +1,160 @@
+//go:build linux
+
+// Command multireactor is an echo server built from one reactor loop per
+// core. Each loop has its own epoll instance and its own listening socket
+// bound to the same port with SO_REUSEPORT, so the kernel shards new
+// connections across the loops and no lock or queue is shared between
+// them:
+//
+//	go run ./multireactor -loops 4 -stats 5s &
+//	go run ./loadgen -conns 200 -interval 1ms -duration 5s
+//
+// -loops defaults to GOMAXPROCS; -loops 1 is the single-loop baseline.
+// Every -stats interval it prints, per loop, the connections it accepted,
+// its events per second and the share of the interval it spent handling
+// them. On exit it prints the totals.
+//
+// -pin locks each loop to a CPU of its own, and -steer incoming-cpu then
+// moves every connection to the loop on the CPU that receives its
+// packets, as SO_INCOMING_CPU reports it; -steer random is the baseline
+// that ignores it:
+//
+//	go run ./multireactor -pin -steer incoming-cpu -stats 5s
+//
+// -work and -wait give each read a service time, of CPU or of blocking
+// in a syscall, before it is echoed. By default the loop runs it to
+// completion; -workers hands it to a reactor.Pool instead, and the reply
+// comes back through WriteAsync:
+//
+//	go run ./multireactor -wait 200us -workers 64
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/debugsrv"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/reactor"
+)
+
+var (
+	addr  = flag.String("addr", ":9000", "Listen address")
+	loops = flag.Int("loops", runtime.GOMAXPROCS(0), "Event loops, each with its own SO_REUSEPORT listener")
+	every = flag.Duration("stats", 0, "Print per-loop counters at this interval (0 disables)")
+	pin   = flag.Bool("pin", false, "Pin each loop to its own CPU")
+	steer reactor.Steering
+
+	workers = flag.Int("workers", 0, "Hand each read to a pool of this many workers (0 runs it to completion on the loop)")
+	work    = flag.Duration("work", 0, "CPU time spent on each read before echoing it")
+	wait    = flag.Duration("wait", 0, "Time each read blocks in a syscall before it is echoed, standing in for a blocking call")
+
+	debugAddr = flag.String("debug-addr", "", debugsrv.Usage)
+)
+
+func init() {
+	flag.Var(&steer, "steer", "Loop for each accepted connection: none (the SO_REUSEPORT hash), incoming-cpu (needs -pin) or random")
+}
+
+// echo writes every chunk back after its service time, on the loop or,
+// with a pool, on a worker.
+type echo struct{ pool *reactor.Pool }
+
+func (echo) OnOpen(c *reactor.Conn)             {}
+func (echo) OnClose(c *reactor.Conn, err error) {}
+
+func (e echo) OnData(c *reactor.Conn, data []byte) {
+	if e.pool == nil {
+		service()
+		c.Write(data)
+		return
+	}
+	msg := append([]byte(nil), data...) // data is the loop's read buffer
+	e.pool.Go(func() {
+		service()
+		c.WriteAsync(msg)
+	})
+}
+
+// service spends -work on the CPU and -wait blocked in nanosleep, which
+// unlike time.Sleep holds the thread as a blocking call does.
+func service() {
+	for start := time.Now(); time.Since(start) < *work; {
+	}
+	if *wait > 0 {
+		ts := syscall.NsecToTimespec(int64(*wait))
+		syscall.Nanosleep(&ts, nil)
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *debugAddr != "" {
+		if err := debugsrv.Listen(*debugAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var h echo
+	if *workers > 0 {
+		h.pool = reactor.NewPool(*workers, *workers)
+	}
+	g, err := reactor.ListenGroup(*addr, *loops, func(int) reactor.Handler { return h }, reactor.Config{Pin: *pin, Steer: steer})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d loops listening on %v, steering %v\n", len(g.Loops()), g.Addr(), steer)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		g.Close()
+	}()
+	if *every > 0 {
+		go func() {
+			for range time.Tick(*every) {
+				printStats(g, *every)
+			}
+		}()
+	}
+	if err := g.Run(); err != nil {
+		log.Fatal(err)
+	}
+	printTotals(g)
+}
+
+var last = map[*reactor.Loop]reactor.Stats{}
+
+// printStats prints each loop's counters over the last interval. An even
+// accept count with an uneven busy share means a few heavy connections
+// landed on the same loop: SO_REUSEPORT balances connections, not load.
+func printStats(g *reactor.Group, interval time.Duration) {
+	for i, l := range g.Loops() {
+		st, prev := l.Stats(), last[l]
+		last[l] = st
+		fmt.Printf("loop %d: cpu=%d accepts=%d handed_off=%d adopted=%d events/s=%.0f busy=%.1f%%\n",
+			i, l.CPU(), st.Accepts-prev.Accepts, st.HandedOff-prev.HandedOff, st.Adopted-prev.Adopted,
+			float64(st.Events-prev.Events)/interval.Seconds(),
+			100*(st.Busy-prev.Busy).Seconds()/interval.Seconds())
+	}
+}
+
+func printTotals(g *reactor.Group) {
+	var accepts, events uint64
+	for _, l := range g.Loops() {
+		st := l.Stats()
+		accepts += st.Accepts
+		events += st.Events
+	}
+	for i, l := range g.Loops() {
+		st := l.Stats()
+		fmt.Printf("loop %d: %d accepts (%.1f%%), %d handed off, %d adopted, %d events (%.1f%%), busy %v\n",
+			i, st.Accepts, 100*float64(st.Accepts)/float64(max(accepts, 1)), st.HandedOff, st.Adopted,
+			st.Events, 100*float64(st.Events)/float64(max(events, 1)), st.Busy.Round(time.Millisecond))
+	}
+}
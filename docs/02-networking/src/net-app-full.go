@@ -0,0 +1,211 @@
+// net-app-full.go is net-app.go with the protections and instruments the
+// later sections add, each behind a flag: the slow-client reaper, the drain
+// and admin control address, fault injection, the traffic mirror,
+// concurrency limits, pprof labels and the debug listener. net-app.go stays
+// the minimal profiling target; run this one for the experiments that need
+// the rest:
+//
+//	go run net-app-full.go -chaos -limit gradient -debug-addr localhost:6061
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/admin"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/chaos"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/conclimit"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/debugsrv"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/drain"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/mirror"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/readguard"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/telemetry"
+)
+
+var (
+	fastDelay   = flag.Duration("fast-delay", 0, "Fixed delay for fast handler (if any)")
+	slowMin     = flag.Duration("slow-min", 1*time.Millisecond, "Minimum delay for slow handler")
+	slowMax     = flag.Duration("slow-max", 300*time.Millisecond, "Maximum delay for slow handler")
+	gcMinAlloc  = flag.Int("gc-min-alloc", 50, "Minimum number of allocations in GC heavy handler")
+	gcMaxAlloc  = flag.Int("gc-max-alloc", 1000, "Maximum number of allocations in GC heavy handler")
+	minBodyRate = flag.Int("min-body-rate", 1024, "Minimum request upload rate in bytes/sec before a client is reaped (0 disables)")
+	controlAddr = flag.String("control", "localhost:9101", "Address for the drain control protocol (empty disables)")
+	drainTO     = flag.Duration("drain-timeout", 30*time.Second, "How long to wait for in-flight requests when shutting down")
+	chaosOn     = flag.Bool("chaos", false, "Wrap connections in a fault injector driven from /chaos on the control address")
+	mirrorFrac  = flag.Float64("mirror", 0, "Share of connections whose bytes are mirrored to a protocol-mix analyzer served at /mirror/mix on the control address")
+	mirrorLimit = flag.Int64("mirror-limit", 64<<10, "Bytes mirrored per connection and direction (0 for all)")
+	limitMode   = flag.String("limit", "none", "Concurrency limit on requests: none, static, aimd or gradient")
+	limitStatic = flag.Int("limit-static", 64, "Requests in flight for -limit static, and the starting limit of the adaptive ones")
+	debugAddr   = flag.String("debug-addr", "", debugsrv.Usage)
+)
+
+func randRange(min, max int) int {
+	return rand.IntN(max-min) + min
+}
+
+func fastHandler(w http.ResponseWriter, r *http.Request) {
+	if *fastDelay > 0 {
+		time.Sleep(*fastDelay)
+	}
+	fmt.Fprintln(w, "fast response")
+}
+
+func slowHandler(w http.ResponseWriter, r *http.Request) {
+	delayRange := int((*slowMax - *slowMin) / time.Millisecond)
+	delay := time.Duration(randRange(1, delayRange)) * time.Millisecond
+	time.Sleep(delay)
+	fmt.Fprintf(w, "slow response with delay %d ms\n", delay.Milliseconds())
+}
+
+var longLivedData [][]byte
+
+func gcHeavyHandler(w http.ResponseWriter, r *http.Request) {
+	numAllocs := randRange(*gcMinAlloc, *gcMaxAlloc)
+	var data [][]byte
+	for i := 0; i < numAllocs; i++ {
+		// Allocate 10KB slices. Occasionally retain a reference to simulate long-lived objects.
+		b := make([]byte, 1024*10)
+		data = append(data, b)
+		if i%100 == 0 { // every 100 allocations, keep the data alive
+			longLivedData = append(longLivedData, b)
+		}
+	}
+	fmt.Fprintf(w, "allocated %d KB\n", len(data)*10)
+}
+
+func main() {
+	flag.Parse()
+	if *debugAddr != "" {
+		if err := debugsrv.Listen(*debugAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	http.HandleFunc("/fast", fastHandler)
+	http.HandleFunc("/slow", slowHandler)
+	http.HandleFunc("/gc", gcHeavyHandler)
+
+	// Start pprof in a separate goroutine.
+	go func() {
+		log.Println("pprof listening on :6060")
+		if err := http.ListenAndServe("localhost:6060", nil); err != nil {
+			log.Fatalf("pprof server error: %v", err)
+		}
+	}()
+
+	// Slow clients: headers must arrive within ReadHeaderTimeout, and once a
+	// request has started the reaper drops uploads slower than -min-body-rate.
+	slowPolicy := &readguard.Policy{MinRate: *minBodyRate, Grace: 5 * time.Second, MessageTimeout: 30 * time.Second}
+	reaper := readguard.NewReaper(slowPolicy)
+	go reaper.Run(context.Background(), time.Second)
+
+	// In-flight requests are counted so a drain can be observed from the
+	// load generator via the control address. The same address serves
+	// /knobs, where GOGC can be changed while the GC heavy handler is
+	// under load, with -chaos /chaos for injecting faults into
+	// connections, and with -mirror /mirror and /mirror/mix, the mirror's
+	// counters and the mix of protocols and requests it has seen.
+	ctl := drain.New()
+	faults := chaos.New()
+	mix := mirror.NewMix()
+	tap := mirror.New(mirror.Config{Fraction: *mirrorFrac, Limit: *mirrorLimit}, mix.Add)
+
+	// Requests over the concurrency limit get a 503 at once instead of
+	// queueing for the handlers. The adaptive limits serve their current
+	// value at /limit on the control address.
+	var handler http.Handler = http.DefaultServeMux
+	var adaptive *conclimit.Adaptive
+	switch *limitMode {
+	case "none":
+	case "static":
+		handler = conclimit.Handler(conclimit.NewStatic(*limitStatic), handler)
+	case "aimd":
+		adaptive = conclimit.NewAdaptive(conclimit.Config{Initial: *limitStatic, Algorithm: &conclimit.AIMD{}})
+	case "gradient":
+		adaptive = conclimit.NewAdaptive(conclimit.Config{Initial: *limitStatic, Algorithm: &conclimit.Gradient{}})
+	default:
+		log.Fatalf("unknown -limit %q", *limitMode)
+	}
+	if adaptive != nil {
+		handler = conclimit.Handler(adaptive, handler)
+	}
+	if *controlAddr != "" {
+		adm := admin.New()
+		adm.Handle("/drain", ctl)
+		adm.Knob("gc_percent", "GOGC; -1 turns the GC off", admin.GCPercent())
+		if *chaosOn {
+			adm.Handle("/chaos", faults)
+		}
+		if *mirrorFrac > 0 {
+			adm.Handle("/mirror", tap)
+			adm.Handle("/mirror/mix", mix)
+		}
+		if adaptive != nil {
+			adm.Handle("/limit", adaptive)
+		}
+		go func() {
+			if err := adm.ListenAndServe(*controlAddr); err != nil {
+				log.Printf("control listener: %v", err)
+			}
+		}()
+	}
+
+	// Create a server to allow for graceful shutdown.
+	server := &http.Server{
+		Addr:              ":8080",
+		Handler:           telemetry.LabelHTTP(ctl.Handler(handler)),
+		ConnContext:       telemetry.ConnContext, // "conn" pprof label per connection
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    16 << 10,
+		ConnState:         reaper.HTTPConnState,
+	}
+
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatalf("listen error: %v", err)
+	}
+	// The mirror goes under the fault injector, so it records what
+	// reached the wire rather than writes the injector dropped.
+	if *mirrorFrac > 0 {
+		ln = tap.Listen(ln)
+	}
+	if *chaosOn {
+		ln = faults.Listen(ln)
+	}
+
+	go func() {
+		log.Println("HTTP server listening on :8080")
+		// net/http sets its own deadlines, so the guard must not.
+		if err := server.Serve(readguard.Listen(ln, slowPolicy, reaper, false)); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("HTTP server error: %v", err)
+		}
+	}()
+
+	// Graceful shutdown on interrupt signal or a drain request.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	select {
+	case <-sigCh:
+		ctl.Begin()
+	case <-ctl.Draining():
+	}
+	log.Println("Shutting down server...")
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTO)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Fatalf("Server Shutdown Failed:%+v", err)
+	}
+	d, _ := ctl.Wait(ctx)
+	log.Printf("Server exited, drained in %v", d)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/budget"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/conclimit"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/transport"
+)
+
+var floorBody = []byte("fast response\n")
+
+// TestAllocBudget holds /fast to the allocations net/http makes for any
+// request, measured on the same transport with a handler that writes a
+// preallocated body, and the static concurrency limit in front of it to
+// one more: the closure that releases its slot. net/http's own count, 13
+// or 14 per request here, differs between Go releases and between
+// transports, so only the handlers' share of it is budgeted.
+//
+//	go test net-app-full.go net-app-full_test.go
+func TestAllocBudget(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fast", fastHandler)
+	floor := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(floorBody) })
+
+	allocs := func(t *testing.T, name string, h http.Handler, check func(budget.Session)) {
+		client, server, err := transport.Pair(name)
+		if err != nil {
+			t.Skip(err)
+		}
+		srv := &http.Server{Handler: h}
+		go srv.Serve(budget.NewConnListener(server))
+		defer srv.Close()
+		s := budget.HTTPConn(client, "/fast")
+		defer s.Close()
+		check(s)
+	}
+	for _, name := range transport.Names {
+		t.Run(name, func(t *testing.T) {
+			var base float64
+			allocs(t, name, floor, func(s budget.Session) { base = budget.AllocsPerRequest(t, s, 1000) })
+			for _, tc := range []struct {
+				name string
+				h    http.Handler
+				own  float64
+			}{
+				{"fast", mux, 0},
+				{"fast-static-limit", conclimit.Handler(conclimit.NewStatic(64), mux), 1},
+			} {
+				t.Run(tc.name, func(t *testing.T) {
+					allocs(t, name, tc.h, func(s budget.Session) { budget.CheckAllocs(t, s, base+tc.own) })
+				})
+			}
+		})
+	}
+}
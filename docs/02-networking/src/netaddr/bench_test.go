@@ -0,0 +1,152 @@
+package netaddr
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+var (
+	sinkIP   net.IP
+	sinkAddr netip.Addr
+	sinkNet  net.Addr
+	sinkAP   netip.AddrPort
+	sinkN    int
+)
+
+// BenchmarkReadFrom receives one datagram per iteration on loopback, the
+// per-packet path of a UDP server. ReadFromUDP returns a new *net.UDPAddr
+// for every packet; ReadFromUDPAddrPort returns a value.
+func BenchmarkReadFrom(b *testing.B) {
+	srv, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer srv.Close()
+	cli, err := net.DialUDP("udp", nil, srv.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cli.Close()
+	msg, buf := make([]byte, 64), make([]byte, 1500)
+
+	b.Run("UDPAddr", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			cli.Write(msg)
+			_, from, err := srv.ReadFromUDP(buf)
+			if err != nil {
+				b.Fatal(err)
+			}
+			sinkNet = from
+		}
+	})
+	b.Run("AddrPort", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			cli.Write(msg)
+			_, from, err := srv.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				b.Fatal(err)
+			}
+			sinkAP = from
+		}
+	})
+}
+
+// BenchmarkParse parses the source address of a PROXY v1 header from the
+// bytes read off the connection.
+func BenchmarkParse(b *testing.B) {
+	for _, s := range []string{"192.0.2.1", "2001:db8::1"} {
+		in := []byte(s)
+		b.Run("net.ParseIP/"+s, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				sinkIP = net.ParseIP(string(in))
+			}
+		})
+		b.Run("netip.ParseAddr/"+s, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				sinkAddr, _ = netip.ParseAddr(string(in))
+			}
+		})
+		b.Run("ParseAddr/"+s, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				sinkAddr, _ = ParseAddr(in)
+			}
+		})
+	}
+}
+
+// BenchmarkSockaddr converts the sockaddr accept4 returns, once per
+// accepted connection in an event loop.
+func BenchmarkSockaddr(b *testing.B) {
+	sa := &syscall.SockaddrInet4{Port: 40000, Addr: [4]byte{10, 1, 2, 3}}
+	b.Run("TCPAddr", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			sinkNet = &net.TCPAddr{IP: net.IP(sa.Addr[:]).To16(), Port: sa.Port}
+		}
+	})
+	b.Run("FromSockaddr", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			sinkAP, _ = FromSockaddr(sa)
+		}
+	})
+}
+
+// BenchmarkLookup finds a client's state by its address among 10,000, as
+// a UDP server keeping per-client flows does for every packet. Without a
+// comparable address type the key is the address's text.
+func BenchmarkLookup(b *testing.B) {
+	const clients = 10000
+	addrs := make([]netip.AddrPort, clients)
+	for i := range addrs {
+		addrs[i] = netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)}), uint16(40000+i))
+	}
+
+	b.Run("string", func(b *testing.B) {
+		m := make(map[string]int, clients)
+		udp := make([]*net.UDPAddr, clients)
+		for i, a := range addrs {
+			udp[i] = net.UDPAddrFromAddrPort(a)
+			m[udp[i].String()] = i
+		}
+		b.ReportAllocs()
+		for i := 0; b.Loop(); i++ {
+			sinkN += m[udp[i%clients].String()]
+		}
+	})
+	b.Run("string-append", func(b *testing.B) {
+		// Formatting into a stack buffer and indexing with string(key)
+		// avoids the allocations but not the formatting.
+		m := make(map[string]int, clients)
+		for i, a := range addrs {
+			m[a.String()] = i
+		}
+		b.ReportAllocs()
+		var key [64]byte
+		for i := 0; b.Loop(); i++ {
+			a := addrs[i%clients]
+			k := a.Addr().AppendTo(key[:0])
+			k = append(k, ':')
+			k = strconv.AppendUint(k, uint64(a.Port()), 10)
+			sinkN += m[string(k)]
+		}
+	})
+	b.Run("AddrPort", func(b *testing.B) {
+		m := make(map[netip.AddrPort]int, clients)
+		for i, a := range addrs {
+			m[a] = i
+		}
+		b.ReportAllocs()
+		for i := 0; b.Loop(); i++ {
+			sinkN += m[addrs[i%clients]]
+		}
+	})
+}
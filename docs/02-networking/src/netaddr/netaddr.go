@@ -0,0 +1,51 @@
+// Package netaddr converts between the address forms a network path meets,
+// raw bytes, syscall sockaddrs and net.Addr, and net/netip values, without
+// allocating.
+//
+// net.IP is a slice and net.UDPAddr and net.TCPAddr are pointers, so each
+// address a server receives in one of them is a heap allocation, and
+// comparing or hashing one means formatting it or copying it into a key.
+// netip.Addr and netip.AddrPort are small comparable values: they live on
+// the stack, work as map keys, and append their text or binary form to a
+// caller's buffer. The net package already has the netip variants of its
+// hot calls (ReadFromUDPAddrPort, WriteToUDPAddrPort, TCPAddr.AddrPort);
+// this package covers the conversions it leaves out.
+package netaddr
+
+import (
+	"fmt"
+	"net/netip"
+	"syscall"
+	"unsafe"
+)
+
+// FromSockaddr returns the address and port of an IPv4 or IPv6 sockaddr,
+// as returned by accept or recvfrom, and false for any other kind. The
+// IPv6 zone, a numeric interface index in the sockaddr, is dropped:
+// naming it would take an interface lookup.
+func FromSockaddr(sa syscall.Sockaddr) (netip.AddrPort, bool) {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return netip.AddrPortFrom(netip.AddrFrom4(sa.Addr), uint16(sa.Port)), true
+	case *syscall.SockaddrInet6:
+		return netip.AddrPortFrom(netip.AddrFrom16(sa.Addr), uint16(sa.Port)), true
+	}
+	return netip.AddrPort{}, false
+}
+
+// ParseAddr parses an IPv4 or IPv6 address in text form, as in a PROXY
+// header or a config file read into a buffer. netip.ParseAddr(string(b))
+// copies b to the heap first, because the error it returns keeps the
+// string; ParseAddr parses b in place and builds its own error instead.
+func ParseAddr(b []byte) (netip.Addr, error) {
+	if len(b) == 0 {
+		return netip.Addr{}, fmt.Errorf("netaddr: empty IP address")
+	}
+	// The string does not outlive this call on success: netip keeps only
+	// the address bits, and copies a zone when it interns it.
+	a, err := netip.ParseAddr(unsafe.String(&b[0], len(b)))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("netaddr: invalid IP address %q", b)
+	}
+	return a, nil
+}
@@ -0,0 +1,71 @@
+package netaddr
+
+import (
+	"net/netip"
+	"syscall"
+	"testing"
+)
+
+func TestFromSockaddr(t *testing.T) {
+	for _, tc := range []struct {
+		sa   syscall.Sockaddr
+		want string
+	}{
+		{&syscall.SockaddrInet4{Port: 443, Addr: [4]byte{192, 0, 2, 1}}, "192.0.2.1:443"},
+		{&syscall.SockaddrInet6{Port: 8080, Addr: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}, "[2001:db8::1]:8080"},
+		{&syscall.SockaddrInet6{Port: 1, Addr: [16]byte{10: 0xff, 11: 0xff, 12: 10, 15: 1}}, "[::ffff:10.0.0.1]:1"},
+	} {
+		got, ok := FromSockaddr(tc.sa)
+		if !ok || got.String() != tc.want {
+			t.Errorf("FromSockaddr(%v) = %v, %v; want %s", tc.sa, got, ok, tc.want)
+		}
+	}
+	if _, ok := FromSockaddr(&syscall.SockaddrUnix{Name: "/tmp/s"}); ok {
+		t.Error("FromSockaddr accepted a unix sockaddr")
+	}
+}
+
+func TestParseAddr(t *testing.T) {
+	for _, s := range []string{"192.0.2.1", "2001:db8::1", "::ffff:10.0.0.1", "fe80::1%eth0"} {
+		got, err := ParseAddr([]byte(s))
+		if want := netip.MustParseAddr(s); err != nil || got != want {
+			t.Errorf("ParseAddr(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "192.0.2", "192.0.2.256", "2001:db8:::1", "host"} {
+		if got, err := ParseAddr([]byte(s)); err == nil {
+			t.Errorf("ParseAddr(%q) = %v, want an error", s, got)
+		}
+	}
+}
+
+// TestParseAddrReuse checks that nothing ParseAddr returns refers to its
+// input, which callers reuse for the next read.
+func TestParseAddrReuse(t *testing.T) {
+	buf := []byte("fe80::1%eth0")
+	a, err := ParseAddr(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := []byte("192.0.2.300")
+	_, err = ParseAddr(bad)
+	copy(buf, "XXXXXXXXXXXX")
+	copy(bad, "XXXXXXXXXXX")
+	if a.Zone() != "eth0" {
+		t.Errorf("zone %q after the buffer was overwritten, want eth0", a.Zone())
+	}
+	if want := `netaddr: invalid IP address "192.0.2.300"`; err == nil || err.Error() != want {
+		t.Errorf("error %v after the buffer was overwritten, want %s", err, want)
+	}
+}
+
+func TestZeroAllocs(t *testing.T) {
+	sa := &syscall.SockaddrInet6{Port: 8080, Addr: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}
+	b := []byte("2001:db8::1")
+	if n := testing.AllocsPerRun(100, func() {
+		FromSockaddr(sa)
+		ParseAddr(b)
+	}); n != 0 {
+		t.Errorf("%v allocations per conversion, want 0", n)
+	}
+}
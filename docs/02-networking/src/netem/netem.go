@@ -0,0 +1,108 @@
+// Package netem shapes traffic on a Linux network interface with the tc
+// netem qdisc, so experiments can run against a realistic RTT, jitter, loss
+// or bandwidth limit on a single machine.
+//
+// Shaping loopback is the simplest setup: every packet crosses lo's egress
+// once per direction, so a delay of RTT/2 yields the desired round trip.
+// All functions need root (CAP_NET_ADMIN) and the sch_netem module.
+package netem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config describes the impairment applied to every packet leaving the
+// interface. Zero fields are left out.
+type Config struct {
+	Delay  time.Duration // fixed one-way delay
+	Jitter time.Duration // +/- variation around Delay
+	Loss   float64       // packet loss in percent
+	Rate   string        // bandwidth limit in tc syntax, e.g. "100mbit"
+	Limit  int           // queue limit in packets; netem defaults to 1000, too few for high BDPs
+}
+
+// ForRTT returns a Config that, applied to loopback, produces rtt.
+func ForRTT(rtt time.Duration) Config {
+	return Config{Delay: rtt / 2}
+}
+
+func (c Config) String() string {
+	return strings.Join(c.args(), " ")
+}
+
+// args returns the netem part of the tc command line.
+func (c Config) args() []string {
+	args := []string{"netem"}
+	if c.Delay > 0 {
+		args = append(args, "delay", usec(c.Delay))
+		if c.Jitter > 0 {
+			args = append(args, usec(c.Jitter), "distribution", "normal")
+		}
+	}
+	if c.Loss > 0 {
+		args = append(args, "loss", strconv.FormatFloat(c.Loss, 'f', -1, 64)+"%")
+	}
+	if c.Rate != "" {
+		args = append(args, "rate", c.Rate)
+	}
+	if c.Limit > 0 {
+		args = append(args, "limit", strconv.Itoa(c.Limit))
+	}
+	return args
+}
+
+func usec(d time.Duration) string {
+	return strconv.FormatInt(d.Microseconds(), 10) + "us"
+}
+
+// Available reports why shaping cannot be used, or nil if it can.
+func Available() error {
+	if os.Geteuid() != 0 {
+		return errors.New("netem: needs root")
+	}
+	if _, err := exec.LookPath("tc"); err != nil {
+		return fmt.Errorf("netem: %w", err)
+	}
+	return nil
+}
+
+// Apply replaces the root qdisc of dev with netem configured by cfg and
+// returns a function that restores the default qdisc.
+func Apply(dev string, cfg Config) (restore func() error, err error) {
+	args := append([]string{"qdisc", "replace", "dev", dev, "root"}, cfg.args()...)
+	if err := tc(args...); err != nil {
+		return nil, err
+	}
+	return func() error { return Clear(dev) }, nil
+}
+
+// ApplyNS is Apply for a device in the named network namespace, as
+// created by "ip netns add". Deleting the namespace removes the device and
+// its qdisc, so there is nothing to restore.
+func ApplyNS(netns, dev string, cfg Config) error {
+	return tc(append([]string{"-n", netns, "qdisc", "replace", "dev", dev, "root"}, cfg.args()...)...)
+}
+
+// Clear removes any root qdisc from dev.
+func Clear(dev string) error {
+	err := tc("qdisc", "del", "dev", dev, "root")
+	if err != nil && strings.Contains(err.Error(), "handle of zero") {
+		// Nothing was installed.
+		return nil
+	}
+	return err
+}
+
+func tc(args ...string) error {
+	out, err := exec.Command("tc", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tc %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
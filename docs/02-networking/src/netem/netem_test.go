@@ -0,0 +1,23 @@
+package netem
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigArgs(t *testing.T) {
+	tests := []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{}, "netem"},
+		{ForRTT(50 * time.Millisecond), "netem delay 25000us"},
+		{Config{Delay: 10 * time.Millisecond, Jitter: time.Millisecond, Loss: 0.5}, "netem delay 10000us 1000us distribution normal loss 0.5%"},
+		{Config{Rate: "100mbit", Limit: 10000}, "netem rate 100mbit limit 10000"},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.String(); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.cfg, got, tt.want)
+		}
+	}
+}
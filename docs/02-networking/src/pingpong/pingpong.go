@@ -0,0 +1,92 @@
+// Package pingpong measures request/response round trips on a single TCP
+// connection. A request is a 4-byte big-endian length followed by that many
+// bytes; the server answers with the same number of bytes.
+//
+// It is the baseline for the networking section: with one message in flight
+// at a time, small payloads show the fixed per-message cost (syscalls,
+// wakeups, the loopback or veth path), mid-sized split writes expose the
+// Nagle/delayed-ACK interaction, and large payloads show bandwidth.
+package pingpong
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+)
+
+// MaxSize bounds the payload a server accepts.
+const MaxSize = 16 << 20
+
+// ErrTooLarge is returned for requests above MaxSize.
+var ErrTooLarge = errors.New("pingpong: payload too large")
+
+// Serve answers requests on every connection from ln until ln is closed.
+func Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go ServeConn(conn)
+	}
+}
+
+// ServeConn answers requests on conn until it fails or is closed.
+func ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	var hdr [4]byte
+	var buf []byte
+	for {
+		if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+			return err
+		}
+		n := binary.BigEndian.Uint32(hdr[:])
+		if n > MaxSize {
+			return ErrTooLarge
+		}
+		if cap(buf) < int(n) {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return err
+		}
+		if _, err := conn.Write(buf); err != nil {
+			return err
+		}
+	}
+}
+
+// Client issues requests of a fixed size on one connection.
+type Client struct {
+	conn  net.Conn
+	req   []byte // header followed by payload
+	resp  []byte
+	split bool
+}
+
+// NewClient returns a client sending size-byte payloads. With split the
+// header and the payload go out in two writes, the way code that writes a
+// length and then a body does; that is what Nagle's algorithm delays.
+func NewClient(conn net.Conn, size int, split bool) *Client {
+	req := make([]byte, 4+size)
+	binary.BigEndian.PutUint32(req, uint32(size))
+	return &Client{conn: conn, req: req, resp: make([]byte, size), split: split}
+}
+
+// RoundTrip sends one request and waits for the complete response.
+func (c *Client) RoundTrip() error {
+	if c.split {
+		if _, err := c.conn.Write(c.req[:4]); err != nil {
+			return err
+		}
+		if _, err := c.conn.Write(c.req[4:]); err != nil {
+			return err
+		}
+	} else if _, err := c.conn.Write(c.req); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(c.conn, c.resp)
+	return err
+}
@@ -0,0 +1,121 @@
+package pingpong
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/transport"
+)
+
+// sizes spans the regimes from per-message overhead to bandwidth.
+var sizes = []int{1, 64, 1 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+func sizeName(n int) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%dMB", n>>20)
+	case n >= 1<<10:
+		return fmt.Sprintf("%dKB", n>>10)
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+func TestRoundTrip(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, size := range []int{0, 1, 100000} {
+		for _, split := range []bool{false, true} {
+			if err := NewClient(conn, size, split).RoundTrip(); err != nil {
+				t.Fatalf("size %d split %v: %v", size, split, err)
+			}
+		}
+	}
+}
+
+// benchPingPong runs the size sweep against a server at addr, dialing with
+// dial. Each size runs with Nagle disabled (Go's default) and enabled, and
+// with the request in one write or split into header and payload writes.
+func benchPingPong(b *testing.B, dial func() (net.Conn, error)) {
+	for _, size := range sizes {
+		for _, nagle := range []bool{false, true} {
+			for _, split := range []bool{false, true} {
+				name := fmt.Sprintf("%s/nodelay=%v/split=%v", sizeName(size), !nagle, split)
+				b.Run(name, func(b *testing.B) {
+					conn, err := dial()
+					if err != nil {
+						b.Fatal(err)
+					}
+					defer conn.Close()
+					if err := conn.(*net.TCPConn).SetNoDelay(!nagle); err != nil {
+						b.Fatal(err)
+					}
+					c := NewClient(conn, size, split)
+					b.SetBytes(int64(2 * size))
+					b.ReportAllocs()
+					for b.Loop() {
+						if err := c.RoundTrip(); err != nil {
+							b.Fatal(err)
+						}
+					}
+					b.ReportMetric(float64(b.Elapsed().Microseconds())/float64(b.N), "µs/rtt")
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkPingPong measures single-connection round trips over loopback.
+func BenchmarkPingPong(b *testing.B) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	go Serve(ln)
+
+	benchPingPong(b, func() (net.Conn, error) { return net.Dial("tcp", ln.Addr().String()) })
+}
+
+// BenchmarkPingPongTransport runs the size sweep against ServeConn over each
+// transport, one request per write. Against net.Pipe it measures the
+// framing and copies alone; the unix and tcp rows add what the kernel
+// charges for a socket, and then for TCP on top of it.
+func BenchmarkPingPongTransport(b *testing.B) {
+	for _, size := range sizes {
+		for _, tr := range transport.Names {
+			b.Run(sizeName(size)+"/"+tr, func(b *testing.B) {
+				conn, server, err := transport.Pair(tr)
+				if errors.Is(err, errors.ErrUnsupported) {
+					b.Skip(err)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer conn.Close()
+				go ServeConn(server)
+
+				c := NewClient(conn, size, false)
+				b.SetBytes(int64(2 * size))
+				b.ReportAllocs()
+				for b.Loop() {
+					if err := c.RoundTrip(); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(b.Elapsed().Microseconds())/float64(b.N), "µs/rtt")
+			})
+		}
+	}
+}
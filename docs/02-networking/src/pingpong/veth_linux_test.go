@@ -0,0 +1,118 @@
+//go:build linux
+
+package pingpong
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/netem"
+)
+
+const (
+	vethNS   = "pingpong"
+	vethHost = "pp0"
+	vethPeer = "pp1"
+	hostIP   = "10.251.0.1"
+	peerIP   = "10.251.0.2"
+)
+
+func ip(b *testing.B, args ...string) {
+	b.Helper()
+	if out, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+		b.Fatalf("ip %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+}
+
+// setupVeth creates a network namespace joined to this one by a veth pair,
+// so traffic crosses a real device (and can be shaped) instead of lo.
+func setupVeth(b *testing.B) {
+	b.Helper()
+	exec.Command("ip", "netns", "del", vethNS).Run() // leftovers from an aborted run
+	ip(b, "netns", "add", vethNS)
+	b.Cleanup(func() { exec.Command("ip", "netns", "del", vethNS).Run() })
+	ip(b, "link", "add", vethHost, "type", "veth", "peer", "name", vethPeer, "netns", vethNS)
+	ip(b, "addr", "add", hostIP+"/30", "dev", vethHost)
+	ip(b, "link", "set", vethHost, "up")
+	ip(b, "-n", vethNS, "addr", "add", peerIP+"/30", "dev", vethPeer)
+	ip(b, "-n", vethNS, "link", "set", vethPeer, "up")
+	ip(b, "-n", vethNS, "link", "set", "lo", "up")
+}
+
+// listenInNS opens a listener inside the named namespace. Sockets stay in
+// the namespace they were created in, so only the Listen call has to run
+// there; it does so on a locked thread that is switched back afterwards, or
+// discarded if switching back fails.
+func listenInNS(ns, addr string) (net.Listener, error) {
+	type result struct {
+		ln  net.Listener
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		runtime.LockOSThread()
+		orig, err := os.Open("/proc/thread-self/ns/net")
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer orig.Close()
+		target, err := os.Open("/run/netns/" + ns)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer target.Close()
+		if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+			done <- result{err: err}
+			return
+		}
+		ln, err := net.Listen("tcp", addr)
+		if unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET) == nil {
+			runtime.UnlockOSThread()
+		}
+		done <- result{ln, err}
+	}()
+	r := <-done
+	return r.ln, r.err
+}
+
+// BenchmarkPingPongVeth runs the same sweep across a veth pair into another
+// network namespace. PINGPONG_RTT (e.g. "1ms") adds that much delay with
+// netem on the host side. Needs root.
+func BenchmarkPingPongVeth(b *testing.B) {
+	if os.Geteuid() != 0 {
+		b.Skip("needs root to create a network namespace")
+	}
+	setupVeth(b)
+	if v := os.Getenv("PINGPONG_RTT"); v != "" {
+		rtt, err := time.ParseDuration(v)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := netem.Available(); err != nil {
+			b.Fatal(err)
+		}
+		restore, err := netem.Apply(vethHost, netem.Config{Delay: rtt})
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer restore()
+	}
+
+	ln, err := listenInNS(vethNS, peerIP+":0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	go Serve(ln)
+
+	benchPingPong(b, func() (net.Conn, error) { return net.Dial("tcp", ln.Addr().String()) })
+}
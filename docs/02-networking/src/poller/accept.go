@@ -0,0 +1,13 @@
+//go:build linux || dragonfly || freebsd || netbsd || openbsd
+
+package poller
+
+import "syscall"
+
+// Accept takes one pending connection off the listening socket lfd and
+// returns it non-blocking and close-on-exec, ready to Add. accept4 sets
+// both flags in the same syscall. Accept returns EAGAIN when nothing is
+// pending.
+func Accept(lfd int) (int, syscall.Sockaddr, error) {
+	return syscall.Accept4(lfd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+}
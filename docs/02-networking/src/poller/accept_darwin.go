@@ -0,0 +1,25 @@
+package poller
+
+import "syscall"
+
+// Accept takes one pending connection off the listening socket lfd and
+// returns it non-blocking and close-on-exec, ready to Add. macOS has no
+// accept4, so this takes three syscalls, and holds ForkLock so that a
+// concurrent fork cannot inherit the fd before it is close-on-exec.
+// Accept returns EAGAIN when nothing is pending.
+func Accept(lfd int) (int, syscall.Sockaddr, error) {
+	syscall.ForkLock.RLock()
+	fd, sa, err := syscall.Accept(lfd)
+	if err == nil {
+		syscall.CloseOnExec(fd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return -1, nil, err
+	}
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return -1, nil, err
+	}
+	return fd, sa, nil
+}
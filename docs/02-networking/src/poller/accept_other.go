@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !plan9
+
+package poller
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// Accept is not implemented where there is no poller to Add the
+// connection to. Plan 9 has no sockets, so it has no Accept at all.
+func Accept(lfd int) (int, syscall.Sockaddr, error) {
+	return -1, nil, fmt.Errorf("poller: accept on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
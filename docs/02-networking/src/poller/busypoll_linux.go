@@ -0,0 +1,21 @@
+//go:build linux
+
+package poller
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetBusyPoll sets SO_BUSY_POLL on fd: a blocking read, or a poll of the
+// socket, spins on the NIC's receive queue for up to d before it sleeps.
+// It takes effect only for sockets whose packets arrive on a queue with
+// NAPI, that is, from a real NIC: loopback and veth traffic never has a
+// queue to poll. Raising the value above net.core.busy_read needs
+// CAP_NET_ADMIN. Sockets in an epoll set are busy-polled by epoll_wait
+// only when net.core.busy_poll is set, and only for the queue of the
+// most recently ready socket.
+func SetBusyPoll(fd int, d time.Duration) error {
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_BUSY_POLL, int(d/time.Microsecond))
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package poller
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// SetBusyPoll needs SO_BUSY_POLL, which only Linux has.
+func SetBusyPoll(fd int, d time.Duration) error {
+	return fmt.Errorf("poller: no SO_BUSY_POLL on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
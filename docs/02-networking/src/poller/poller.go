@@ -0,0 +1,172 @@
+// Package poller is a small readiness API over epoll on Linux and kqueue on
+// macOS and the BSDs, for event loops that manage raw file descriptors
+// themselves.
+//
+// A Poller maps each registered fd to a Callback. Wait blocks until some of
+// them are ready and calls their callbacks on the calling goroutine:
+//
+//	p, err := poller.New()
+//	...
+//	p.Add(fd, poller.Read, func(fd int, ev poller.Event) {
+//		// read until EAGAIN, or once if level-triggered
+//	})
+//	for {
+//		if _, err := p.Wait(-1); err != nil {
+//			...
+//		}
+//	}
+//
+// The two kernels differ in ways a loop has to know about. epoll reports
+// one event per fd with every direction that is ready; kqueue has a filter
+// per direction and reports them as separate events, so a callback may run
+// twice for one fd in one Wait. Edge-triggered interest is EPOLLET on
+// Linux and EV_CLEAR on kqueue. Both report a peer that closed its end as
+// Hangup along with Read, and one that reset the connection as Error as
+// well; the error itself is the socket's SO_ERROR.
+//
+// OneShot hands an fd to one handler at a time. Once reported, the fd stays
+// registered but silent until Mod re-arms it, so a loop can pass the event
+// to another goroutine and let that goroutine re-arm the fd when it is
+// done, without a second event for the same fd reaching another one in
+// the meantime. It is EPOLLONESHOT on Linux. On kqueue it is EV_ONESHOT,
+// which applies to each filter on its own: an fd armed for Read and Write
+// can still be reported once for each.
+//
+// A listening socket is registered like any other fd: it reports Read while
+// connections are queued, and Accept takes them off it already non-blocking.
+package poller
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/bitset"
+)
+
+// Event is a set of readiness conditions, or of the ones to watch for.
+type Event uint32
+
+const (
+	Read    Event = 1 << iota // data to read, a pending connection or EOF
+	Write                     // room in the send buffer
+	Hangup                    // the peer closed its end; reported, never requested
+	Error                     // an error is pending on the fd; reported, never requested
+	Edge                      // interest only: report changes, not levels
+	OneShot                   // interest only: report once, then nothing until Mod re-arms the fd
+)
+
+// MaxEvents is how many events one Wait collects.
+const MaxEvents = 256
+
+// ErrNotRegistered is returned by Mod and Del for an fd Add never saw.
+var ErrNotRegistered = errors.New("poller: fd not registered")
+
+// Callback handles the readiness of fd. It runs on the goroutine calling
+// Wait and may call Add, Mod and Del, including Del for its own fd.
+type Callback func(fd int, ev Event)
+
+// Poller watches file descriptors for readiness.
+//
+// Add, Mod and Del may be called from any goroutine, so another goroutine
+// can accept connections and register them while the loop waits. Wait
+// must only be called from one goroutine at a time.
+type Poller interface {
+	// Add registers fd with interest and the callback for its events.
+	Add(fd int, interest Event, cb Callback) error
+	// Mod replaces fd's interest, and re-arms a OneShot fd.
+	Mod(fd int, interest Event) error
+	// Del unregisters fd. Events for it already collected by the Wait in
+	// progress are dropped, even if the fd number is reused meanwhile.
+	// Callers must still expect spurious events, as with any non-blocking
+	// fd: a read or write that returns EAGAIN is not an error.
+	Del(fd int) error
+	// Wait waits up to timeout, or forever if it is negative, calls the
+	// callbacks of the ready fds, and returns how many events the kernel
+	// reported.
+	// A wait interrupted by a signal returns 0 and no error.
+	Wait(timeout time.Duration) (int, error)
+	// Close releases the kernel object. It does not close registered fds.
+	Close() error
+}
+
+// New returns the Poller for this platform. On platforms with neither
+// epoll nor kqueue it returns an error wrapping errors.ErrUnsupported.
+func New() (Poller, error) { return newPoller() }
+
+// table maps fds to callbacks for the platform pollers.
+type table struct {
+	mu      sync.Mutex
+	entries []entry    // indexed by fd
+	dead    bitset.Set // fds removed since the last wait returned
+	anyDead bool
+}
+
+type entry struct {
+	cb       Callback
+	interest Event
+}
+
+func (t *table) add(fd int, interest Event, cb Callback) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if fd >= len(t.entries) {
+		grown := make([]entry, max(fd+1, 2*len(t.entries)))
+		copy(grown, t.entries)
+		t.entries = grown
+	}
+	t.entries[fd] = entry{cb: cb, interest: interest}
+}
+
+// set replaces fd's interest and returns the previous one.
+func (t *table) set(fd int, interest Event) (Event, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if fd < 0 || fd >= len(t.entries) || t.entries[fd].cb == nil {
+		return 0, ErrNotRegistered
+	}
+	prev := t.entries[fd].interest
+	t.entries[fd].interest = interest
+	return prev, nil
+}
+
+// remove forgets fd and returns its interest. Events for the fd are
+// dropped until the next wait returns.
+func (t *table) remove(fd int) (Event, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if fd < 0 || fd >= len(t.entries) || t.entries[fd].cb == nil {
+		return 0, ErrNotRegistered
+	}
+	prev := t.entries[fd].interest
+	t.entries[fd] = entry{}
+	t.dead.Add(fd)
+	t.anyDead = true
+	return prev, nil
+}
+
+// collected is called when the kernel has returned a batch of events. The
+// fds removed before it are not in the batch; the ones removed while it is
+// dispatched may be, and may already belong to a new registration, so
+// lookup drops their events. A removal that races the wait itself can let
+// one event reach the fd's next registration: a spurious event, which a
+// non-blocking callback answers with EAGAIN, where dropping it could lose
+// the first edge of the new one.
+func (t *table) collected() {
+	t.mu.Lock()
+	if t.anyDead {
+		t.dead.Clear()
+		t.anyDead = false
+	}
+	t.mu.Unlock()
+}
+
+// lookup returns fd's callback, or nil if the event is stale.
+func (t *table) lookup(fd int) Callback {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if fd >= len(t.entries) || (t.anyDead && t.dead.Has(fd)) {
+		return nil
+	}
+	return t.entries[fd].cb
+}
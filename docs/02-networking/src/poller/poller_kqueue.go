@@ -0,0 +1,144 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package poller
+
+import (
+	"syscall"
+	"time"
+)
+
+type kqueue struct {
+	fd      int
+	events  []syscall.Kevent_t
+	changes []syscall.Kevent_t // scratch for Add, Mod and Del, under mu
+	table
+}
+
+func newPoller() (Poller, error) {
+	fd, err := syscall.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	return &kqueue{fd: fd, events: make([]syscall.Kevent_t, MaxEvents)}, nil
+}
+
+// apply moves fd's filters from interest prev to next: one change per
+// filter to add, update or delete. kqueue has no single call that replaces
+// an fd's interest the way EPOLL_CTL_MOD does.
+func (p *kqueue) apply(fd int, prev, next Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.changes = p.changes[:0]
+	for _, f := range []struct {
+		ev     Event
+		filter int
+	}{{Read, syscall.EVFILT_READ}, {Write, syscall.EVFILT_WRITE}} {
+		var k syscall.Kevent_t
+		switch {
+		case next&f.ev != 0:
+			flags := syscall.EV_ADD | syscall.EV_ENABLE
+			if next&Edge != 0 {
+				flags |= syscall.EV_CLEAR
+			}
+			if next&OneShot != 0 {
+				flags |= syscall.EV_ONESHOT
+			}
+			syscall.SetKevent(&k, fd, f.filter, flags)
+		case prev&f.ev != 0:
+			syscall.SetKevent(&k, fd, f.filter, syscall.EV_DELETE)
+		default:
+			continue
+		}
+		p.changes = append(p.changes, k)
+	}
+	if len(p.changes) == 0 {
+		return nil
+	}
+	if prev&OneShot != 0 {
+		// A one-shot filter that fired is gone already, and deleting it
+		// fails with ENOENT. Kevent stops at the first change that fails,
+		// so each change goes on its own.
+		for i := range p.changes {
+			_, err := syscall.Kevent(p.fd, p.changes[i:i+1], nil, nil)
+			if err != nil && (err != syscall.ENOENT || p.changes[i].Flags&syscall.EV_DELETE == 0) {
+				return err
+			}
+		}
+		return nil
+	}
+	_, err := syscall.Kevent(p.fd, p.changes, nil, nil)
+	return err
+}
+
+// Add stores the callback before registering fd, so an event that arrives
+// at once finds it.
+func (p *kqueue) Add(fd int, interest Event, cb Callback) error {
+	p.add(fd, interest, cb)
+	if err := p.apply(fd, 0, interest); err != nil {
+		p.remove(fd)
+		return err
+	}
+	return nil
+}
+
+func (p *kqueue) Mod(fd int, interest Event) error {
+	prev, err := p.set(fd, interest)
+	if err != nil {
+		return err
+	}
+	return p.apply(fd, prev, interest)
+}
+
+func (p *kqueue) Del(fd int) error {
+	prev, err := p.remove(fd)
+	if err != nil {
+		return err
+	}
+	return p.apply(fd, prev, 0)
+}
+
+// Wait reports each filter as its own event: a socket that is readable and
+// writable takes two events and two callbacks.
+func (p *kqueue) Wait(timeout time.Duration) (int, error) {
+	var ts *syscall.Timespec
+	if timeout >= 0 {
+		t := syscall.NsecToTimespec(int64(timeout))
+		ts = &t
+	}
+	n, err := syscall.Kevent(p.fd, nil, p.events, ts)
+	if err == syscall.EINTR {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	p.collected()
+	for i := range n {
+		k := &p.events[i]
+		fd := int(k.Ident)
+		var ev Event
+		switch {
+		case k.Flags&syscall.EV_ERROR != 0:
+			ev = Error
+		case k.Filter == syscall.EVFILT_READ:
+			ev = Read
+		case k.Filter == syscall.EVFILT_WRITE:
+			ev = Write
+		}
+		// EV_EOF carries the socket's pending error, such as
+		// ECONNRESET, in Fflags.
+		if k.Flags&syscall.EV_EOF != 0 {
+			ev |= Hangup | Read
+			if k.Fflags != 0 {
+				ev |= Error
+			}
+		}
+		if cb := p.lookup(fd); cb != nil {
+			cb(fd, ev)
+		}
+	}
+	return n, nil
+}
+
+func (p *kqueue) Close() error { return syscall.Close(p.fd) }
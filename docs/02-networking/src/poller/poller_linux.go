@@ -0,0 +1,111 @@
+//go:build linux
+
+package poller
+
+import (
+	"syscall"
+	"time"
+)
+
+// epollET is syscall.EPOLLET, which the syscall package declares as a
+// negative int, as a uint32 event flag.
+const epollET = 1 << 31
+
+type epoll struct {
+	fd     int
+	events []syscall.EpollEvent
+	table
+}
+
+func newPoller() (Poller, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &epoll{fd: fd, events: make([]syscall.EpollEvent, MaxEvents)}, nil
+}
+
+// toEpoll translates interest. Read interest includes EPOLLRDHUP, so a
+// peer's FIN is reported as Hangup and not only as a readable fd.
+func toEpoll(fd int, interest Event) *syscall.EpollEvent {
+	ev := &syscall.EpollEvent{Fd: int32(fd)}
+	if interest&Read != 0 {
+		ev.Events |= syscall.EPOLLIN | syscall.EPOLLRDHUP
+	}
+	if interest&Write != 0 {
+		ev.Events |= syscall.EPOLLOUT
+	}
+	if interest&Edge != 0 {
+		ev.Events |= epollET
+	}
+	if interest&OneShot != 0 {
+		ev.Events |= syscall.EPOLLONESHOT
+	}
+	return ev
+}
+
+func fromEpoll(events uint32) Event {
+	var ev Event
+	if events&syscall.EPOLLIN != 0 {
+		ev |= Read
+	}
+	if events&syscall.EPOLLOUT != 0 {
+		ev |= Write
+	}
+	if events&(syscall.EPOLLRDHUP|syscall.EPOLLHUP) != 0 {
+		ev |= Hangup | Read
+	}
+	if events&syscall.EPOLLERR != 0 {
+		ev |= Error
+	}
+	return ev
+}
+
+// Add stores the callback before registering fd, so an event that arrives
+// at once finds it.
+func (p *epoll) Add(fd int, interest Event, cb Callback) error {
+	p.add(fd, interest, cb)
+	if err := syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_ADD, fd, toEpoll(fd, interest)); err != nil {
+		p.remove(fd)
+		return err
+	}
+	return nil
+}
+
+func (p *epoll) Mod(fd int, interest Event) error {
+	if _, err := p.set(fd, interest); err != nil {
+		return err
+	}
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_MOD, fd, toEpoll(fd, interest))
+}
+
+func (p *epoll) Del(fd int) error {
+	if _, err := p.remove(fd); err != nil {
+		return err
+	}
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+func (p *epoll) Wait(timeout time.Duration) (int, error) {
+	msec := -1
+	if timeout >= 0 {
+		msec = int((timeout + time.Millisecond - 1) / time.Millisecond)
+	}
+	n, err := syscall.EpollWait(p.fd, p.events, msec)
+	if err == syscall.EINTR {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	p.collected()
+	for i := range n {
+		fd := int(p.events[i].Fd)
+		if cb := p.lookup(fd); cb != nil {
+			cb(fd, fromEpoll(p.events[i].Events))
+		}
+	}
+	return n, nil
+}
+
+func (p *epoll) Close() error { return syscall.Close(p.fd) }
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package poller
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+func newPoller() (Poller, error) {
+	return nil, fmt.Errorf("poller: no epoll or kqueue on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+}
@@ -0,0 +1,312 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package poller
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// pair returns a non-blocking unix socketpair, closed at the end of the
+// test.
+func pair(t *testing.T) (a, b int) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range fds {
+		syscall.SetNonblock(fd, true)
+	}
+	t.Cleanup(func() {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+	})
+	return fds[0], fds[1]
+}
+
+func newPollerT(t *testing.T) Poller {
+	t.Helper()
+	p, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// wait empties seen and runs one Wait, whose callbacks fill it in.
+func wait(t *testing.T, p Poller, seen map[int]Event, timeout time.Duration) {
+	t.Helper()
+	clear(seen)
+	if _, err := p.Wait(timeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	p := newPollerT(t)
+	a, b := pair(t)
+	seen := map[int]Event{}
+	record := func(fd int, ev Event) { seen[fd] |= ev }
+	if err := p.Add(a, Read, record); err != nil {
+		t.Fatal(err)
+	}
+
+	wait(t, p, seen, 10*time.Millisecond)
+	if len(seen) != 0 {
+		t.Fatalf("events %v before anything was written", seen)
+	}
+	syscall.Write(b, []byte("x"))
+	wait(t, p, seen, time.Second)
+	if seen[a]&Read == 0 {
+		t.Fatalf("events %v, want Read on %d", seen, a)
+	}
+
+	// Level-triggered: still readable until read.
+	wait(t, p, seen, time.Second)
+	if seen[a]&Read == 0 {
+		t.Fatalf("events %v, want Read again while unread", seen)
+	}
+
+	if err := p.Mod(a, Read|Write); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, p, seen, time.Second)
+	if seen[a] != Read|Write {
+		t.Fatalf("events %v, want Read|Write", seen)
+	}
+
+	if err := p.Del(a); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, p, seen, 10*time.Millisecond)
+	if len(seen) != 0 {
+		t.Fatalf("events %v after Del", seen)
+	}
+	if err := p.Mod(a, Read); err != ErrNotRegistered {
+		t.Errorf("Mod after Del: %v, want ErrNotRegistered", err)
+	}
+	if err := p.Del(a); err != ErrNotRegistered {
+		t.Errorf("second Del: %v, want ErrNotRegistered", err)
+	}
+}
+
+func TestEdge(t *testing.T) {
+	p := newPollerT(t)
+	a, b := pair(t)
+	seen := map[int]Event{}
+	if err := p.Add(a, Read|Edge, func(fd int, ev Event) { seen[fd] |= ev }); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Write(b, []byte("x"))
+	wait(t, p, seen, time.Second)
+	if seen[a]&Read == 0 {
+		t.Fatalf("events %v, want Read", seen)
+	}
+	// Nothing was read, but nothing new arrived either.
+	wait(t, p, seen, 10*time.Millisecond)
+	if len(seen) != 0 {
+		t.Fatalf("events %v without a new edge", seen)
+	}
+	syscall.Write(b, []byte("y"))
+	wait(t, p, seen, time.Second)
+	if seen[a]&Read == 0 {
+		t.Fatalf("events %v, want Read for new data", seen)
+	}
+}
+
+func TestHangup(t *testing.T) {
+	p := newPollerT(t)
+	a, b := pair(t)
+	seen := map[int]Event{}
+	if err := p.Add(a, Read, func(fd int, ev Event) { seen[fd] |= ev }); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Shutdown(b, syscall.SHUT_WR)
+	wait(t, p, seen, time.Second)
+	if seen[a] != Read|Hangup {
+		t.Fatalf("events %v, want Read|Hangup", seen)
+	}
+}
+
+// TestOneShot checks that a OneShot fd is reported once while it stays
+// readable, and again after Mod re-arms it.
+func TestOneShot(t *testing.T) {
+	p := newPollerT(t)
+	a, b := pair(t)
+	seen := map[int]Event{}
+	if err := p.Add(a, Read|OneShot, func(fd int, ev Event) { seen[fd] |= ev }); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Write(b, []byte("x"))
+	wait(t, p, seen, time.Second)
+	if seen[a] != Read {
+		t.Fatalf("first wait: events %v, want Read", seen[a])
+	}
+	wait(t, p, seen, 10*time.Millisecond)
+	if len(seen) != 0 {
+		t.Fatalf("before re-arming: events %v, want none", seen)
+	}
+	if err := p.Mod(a, Read|OneShot); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, p, seen, time.Second)
+	if seen[a] != Read {
+		t.Fatalf("after re-arming: events %v, want Read", seen[a])
+	}
+	if err := p.Del(a); err != nil {
+		t.Errorf("Del after the event fired: %v", err)
+	}
+}
+
+// TestReset checks that a connection the peer reset is reported as an
+// Error, and one it only shut down for writing is not.
+func TestReset(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accept := func() (net.Conn, int) {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		s, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		var fd int
+		rc, _ := s.(*net.TCPConn).SyscallConn()
+		rc.Control(func(f uintptr) { fd = int(f) })
+		return c, fd
+	}
+
+	p := newPollerT(t)
+	seen := map[int]Event{}
+	record := func(fd int, ev Event) { seen[fd] |= ev }
+	half, halfFd := accept()
+	reset, resetFd := accept()
+	for _, fd := range []int{halfFd, resetFd} {
+		if err := p.Add(fd, Read, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	half.(*net.TCPConn).CloseWrite()
+	reset.(*net.TCPConn).SetLinger(0)
+	reset.Close()
+	time.Sleep(10 * time.Millisecond) // both delivered before the wait
+	wait(t, p, seen, time.Second)
+	if ev := seen[halfFd]; ev != Read|Hangup {
+		t.Errorf("half-closed: events %v, want Read|Hangup", ev)
+	}
+	if ev := seen[resetFd]; ev&(Error|Hangup) != Error|Hangup {
+		t.Errorf("reset: events %v, want Error and Hangup", ev)
+	}
+	soErr, err := syscall.GetsockoptInt(resetFd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err != nil || syscall.Errno(soErr) != syscall.ECONNRESET {
+		t.Errorf("SO_ERROR = %v, %v; want ECONNRESET", syscall.Errno(soErr), err)
+	}
+}
+
+// TestAccept registers a listening socket, accepts the connection it
+// reports and checks that the connection comes non-blocking.
+func TestAccept(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var lfd int
+	rc, _ := ln.(*net.TCPListener).SyscallConn()
+	rc.Control(func(f uintptr) { lfd = int(f) })
+
+	p := newPollerT(t)
+	seen := map[int]Event{}
+	if err := p.Add(lfd, Read, func(fd int, ev Event) { seen[fd] |= ev }); err != nil {
+		t.Fatal(err)
+	}
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	wait(t, p, seen, time.Second)
+	if seen[lfd] != Read {
+		t.Fatalf("listener: events %v, want Read", seen[lfd])
+	}
+
+	fd, sa, err := Accept(lfd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	if got, ok := sa.(*syscall.SockaddrInet4); !ok || got.Port != c.LocalAddr().(*net.TCPAddr).Port {
+		t.Errorf("peer %#v, want %v", sa, c.LocalAddr())
+	}
+	if _, err := syscall.Read(fd, make([]byte, 1)); err != syscall.EAGAIN {
+		t.Errorf("read with nothing sent: %v, want EAGAIN", err)
+	}
+	if _, _, err := Accept(lfd); err != syscall.EAGAIN {
+		t.Errorf("accept with nothing queued: %v, want EAGAIN", err)
+	}
+}
+
+// TestDelDuringWait checks that a callback that unregisters another fd
+// keeps that fd's already collected event from being delivered.
+func TestDelDuringWait(t *testing.T) {
+	p := newPollerT(t)
+	a1, b1 := pair(t)
+	a2, b2 := pair(t)
+	calls := 0
+	cb := func(fd int, ev Event) {
+		calls++
+		other := a2
+		if fd == a2 {
+			other = a1
+		}
+		if err := p.Del(other); err != nil {
+			t.Errorf("Del(%d): %v", other, err)
+		}
+	}
+	for _, fd := range []int{a1, a2} {
+		if err := p.Add(fd, Read, cb); err != nil {
+			t.Fatal(err)
+		}
+	}
+	syscall.Write(b1, []byte("x"))
+	syscall.Write(b2, []byte("x"))
+	time.Sleep(10 * time.Millisecond) // both ready before the wait
+	if n, err := p.Wait(time.Second); err != nil || n != 2 {
+		t.Fatalf("Wait: %d events, %v; want both", n, err)
+	}
+	if calls != 1 {
+		t.Errorf("%d callbacks, want 1: the first unregistered the second", calls)
+	}
+}
+
+func BenchmarkWait(b *testing.B) {
+	p, err := New()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer p.Close()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+	// A level-triggered writable fd is ready on every Wait.
+	p.Add(fds[0], Write, func(int, Event) {})
+	for b.Loop() {
+		if n, err := p.Wait(-1); n != 1 || err != nil {
+			b.Fatal(n, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package poller
+
+import "time"
+
+// Spinner waits the way a latency-sensitive loop does: it polls with a
+// zero timeout for up to Spin, and blocks only if nothing arrived by
+// then. An event that arrives while the loop spins is picked up without
+// the sleep and wakeup of a blocking Wait, which on Linux cost several
+// microseconds each. The price is a core kept busy for Spin after every
+// quiet moment, whether anything arrives or not. On a machine with fewer
+// idle cores than spinning loops, the spinner takes the CPU from the
+// threads it is waiting for, and latency gets worse instead of better.
+//
+// The counters are for tuning Spin: a Hit rate near zero means it is
+// shorter than the gaps between events and only burns CPU.
+type Spinner struct {
+	Spin time.Duration
+
+	Polls   uint64 // zero-timeout Waits
+	Hits    uint64 // Waits that found events while spinning
+	Blocked uint64 // Waits that spun for Spin, found nothing and blocked
+}
+
+// Wait waits like p.Wait(timeout), spinning first. It must be called
+// from the goroutine that owns p's Waits.
+func (s *Spinner) Wait(p Poller, timeout time.Duration) (int, error) {
+	if s.Spin <= 0 || timeout == 0 {
+		return p.Wait(timeout)
+	}
+	start := time.Now()
+	spin := s.Spin
+	if timeout > 0 && timeout < spin {
+		spin = timeout
+	}
+	for {
+		n, err := p.Wait(0)
+		s.Polls++
+		if err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			s.Hits++
+			return n, nil
+		}
+		if time.Since(start) >= spin {
+			break
+		}
+	}
+	if timeout > 0 {
+		if timeout -= time.Since(start); timeout <= 0 {
+			return 0, nil
+		}
+	}
+	s.Blocked++
+	return p.Wait(timeout)
+}
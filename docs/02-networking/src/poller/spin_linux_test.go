@@ -0,0 +1,85 @@
+package poller
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/internal/threadcpu"
+)
+
+// BenchmarkSpinRoundTrip sends a byte to a peer thread that echoes it,
+// and waits for the echo with a blocking Wait or a Spinner. It reports
+// the round trip's median and 99th percentile, and the CPU the waiting
+// thread used per round trip: what spinning buys and what it costs.
+// The spinning thread and the peer need a core each for spinning to pay;
+// with fewer, the spinner holds the core the echo has to be written on.
+func BenchmarkSpinRoundTrip(b *testing.B) {
+	for _, spin := range []time.Duration{0, 5 * time.Microsecond, 20 * time.Microsecond, 200 * time.Microsecond} {
+		b.Run(fmt.Sprintf("spin=%v", spin), func(b *testing.B) {
+			benchSpin(b, spin)
+		})
+	}
+}
+
+func benchSpin(b *testing.B, spin time.Duration) {
+	p, err := New()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer p.Close()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	syscall.SetNonblock(fds[0], true)
+
+	// The peer blocks in read on a thread of its own, as a client process
+	// would, and exits when the loop closes its end.
+	go func() {
+		runtime.LockOSThread()
+		defer syscall.Close(fds[1])
+		var c [1]byte
+		for {
+			if n, _ := syscall.Read(fds[1], c[:]); n <= 0 {
+				return
+			}
+			syscall.Write(fds[1], c[:])
+		}
+	}()
+
+	got := false
+	p.Add(fds[0], Read, func(fd int, ev Event) {
+		var c [1]byte
+		if n, _ := syscall.Read(fd, c[:]); n == 1 {
+			got = true
+		}
+	})
+	s := &Spinner{Spin: spin}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var lat []time.Duration
+	var cpu time.Duration
+	for b.Loop() {
+		before := threadcpu.Now(b)
+		start := time.Now()
+		syscall.Write(fds[0], []byte{1})
+		for got = false; !got; {
+			if _, err := s.Wait(p, -1); err != nil {
+				b.Fatal(err)
+			}
+		}
+		lat = append(lat, time.Since(start))
+		cpu += threadcpu.Now(b) - before
+	}
+	slices.Sort(lat)
+	b.ReportMetric(float64(lat[len(lat)/2].Nanoseconds()), "p50-ns")
+	b.ReportMetric(float64(lat[len(lat)*99/100].Nanoseconds()), "p99-ns")
+	b.ReportMetric(float64(cpu.Nanoseconds())/float64(b.N), "cpu-ns/op")
+	b.ReportMetric(float64(s.Hits)/float64(max(s.Hits+s.Blocked, 1)), "hit-rate")
+}
@@ -0,0 +1,64 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package poller
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSpinner(t *testing.T) {
+	p := newPollerT(t)
+	a, b := pair(t)
+	got := 0
+	p.Add(a, Read, func(fd int, ev Event) {
+		var buf [8]byte
+		n, _ := syscall.Read(fd, buf[:])
+		got += n
+	})
+	s := &Spinner{Spin: time.Millisecond}
+
+	// Ready before the Wait: the first poll finds it.
+	syscall.Write(b, []byte{1})
+	if n, err := s.Wait(p, -1); n != 1 || err != nil || got != 1 {
+		t.Fatalf("ready fd: %d events, %v, %d bytes", n, err, got)
+	}
+	if s.Polls != 1 || s.Hits != 1 || s.Blocked != 0 {
+		t.Errorf("ready fd: %+v", *s)
+	}
+
+	// Nothing arrives: it spins for Spin, then blocks until the timeout.
+	*s = Spinner{Spin: time.Millisecond}
+	start := time.Now()
+	if n, err := s.Wait(p, 20*time.Millisecond); n != 0 || err != nil {
+		t.Fatalf("idle: %d events, %v", n, err)
+	}
+	if took := time.Since(start); took < 20*time.Millisecond {
+		t.Errorf("idle Wait returned after %v, before its timeout", took)
+	}
+	if s.Polls < 2 || s.Hits != 0 || s.Blocked != 1 {
+		t.Errorf("idle: %+v", *s)
+	}
+
+	// Arrives after the spin: the blocking Wait gets it.
+	*s = Spinner{Spin: time.Millisecond}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		syscall.Write(b, []byte{2})
+	}()
+	if n, err := s.Wait(p, -1); n != 1 || err != nil || got != 2 {
+		t.Fatalf("late event: %d events, %v, %d bytes", n, err, got)
+	}
+	if s.Hits != 0 || s.Blocked != 1 {
+		t.Errorf("late event: %+v", *s)
+	}
+
+	// A timeout shorter than Spin bounds the spin.
+	*s = Spinner{Spin: time.Second}
+	start = time.Now()
+	s.Wait(p, 5*time.Millisecond)
+	if took := time.Since(start); took > 500*time.Millisecond {
+		t.Errorf("spun for %v with a 5ms timeout", took)
+	}
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// dialControl returns a Dialer.Control that restricts the socket's
+// ephemeral ports to [lo, hi] when lo is set, and with noPort defers the
+// port choice of a socket bound to a source IP to connect, so that each
+// source IP gets the whole range to every destination.
+func dialControl(lo, hi uint16, noPort bool) (func(network, address string, c syscall.RawConn) error, error) {
+	if lo == 0 && !noPort {
+		return nil, nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			if lo != 0 {
+				// IP_LOCAL_PORT_RANGE packs the bounds as hi<<16 | lo.
+				serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_LOCAL_PORT_RANGE, int(hi)<<16|int(lo))
+			}
+			if serr == nil && noPort {
+				serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_BIND_ADDRESS_NO_PORT, 1)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}, nil
+}
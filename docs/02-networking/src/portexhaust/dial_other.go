@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+func dialControl(lo, hi uint16, noPort bool) (func(network, address string, c syscall.RawConn) error, error) {
+	if lo == 0 && !noPort {
+		return nil, nil
+	}
+	return nil, errors.New("-ports and -mode sources need Linux")
+}
@@ -0,0 +1,112 @@
+// Command portexhaust runs a client out of ephemeral ports and then shows
+// what keeps it from happening. Each request opens a connection to an echo
+// server, asks for a reply of -reply bytes, reads it and closes the
+// connection, at -rate requests per second for -duration:
+//
+//	go run ./portexhaust -mode close -ports 40000-40255
+//
+// The side that closes a TCP connection first keeps it in TIME_WAIT, and
+// the connection's local port with it, for 60 seconds. Linux lets a new
+// connection to the same server take over such a port only with
+// net.ipv4.tcp_tw_reuse, and by default only on loopback and after a
+// second. A client dialling faster than its ports free up gets EADDRNOTAVAIL
+// from connect. -ports shrinks the client's ephemeral range with
+// IP_LOCAL_PORT_RANGE (Linux 6.3+), so this takes seconds instead of a
+// production-sized load.
+//
+// -mode picks the client:
+//
+//	close          close normally; the client holds TIME_WAIT (the failure)
+//	pool           reuse connections through the connpool package
+//	sources        spread connections over -sources loopback IPs, each
+//	               with its own port range (IP_BIND_ADDRESS_NO_PORT)
+//	linger         close with SO_LINGER 0, which sends RST and skips
+//	               TIME_WAIT; the server sees a reset instead of EOF
+//	server-linger  the server closes first, with SO_LINGER 0, right after
+//	               writing the reply; the unsent part of a large reply is
+//	               discarded (try -reply 1048576)
+//
+// Every second it prints the requests that succeeded, connects that failed
+// with EADDRNOTAVAIL, replies cut short and other errors.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var modes = []string{"close", "pool", "sources", "linger", "server-linger"}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.Mode, "mode", "close", "Client behavior: "+strings.Join(modes, ", "))
+	flag.StringVar(&cfg.Addr, "addr", "", "Server address (default: an in-process server on 127.0.0.1)")
+	flag.IntVar(&cfg.Rate, "rate", 2000, "Requests per second")
+	flag.DurationVar(&cfg.Duration, "duration", 10*time.Second, "Test duration")
+	flag.IntVar(&cfg.Reply, "reply", 64, "Reply size in bytes")
+	flag.StringVar(&cfg.Ports, "ports", "", "Client ephemeral port range LO-HI (default: the system range)")
+	flag.IntVar(&cfg.Sources, "sources", 8, "Loopback source IPs for -mode sources")
+	flag.IntVar(&cfg.MaxIdle, "max-idle", 64, "Idle connections kept for -mode pool")
+	flag.Parse()
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	var srv *server
+	if cfg.Addr == "" {
+		var err error
+		if srv, err = listen(cfg.Mode == "server-linger"); err != nil {
+			log.Fatal(err)
+		}
+		defer srv.Close()
+		cfg.Addr = srv.Addr()
+	}
+	if b, err := os.ReadFile("/proc/sys/net/ipv4/tcp_tw_reuse"); err == nil {
+		fmt.Printf("net.ipv4.tcp_tw_reuse=%s (0 off, 1 on, 2 loopback only)\n", strings.TrimSpace(string(b)))
+	}
+
+	res, err := run(ctx, cfg, func(s second) {
+		fmt.Printf("t=%-3d ok=%-6d connect_fail=%-6d truncated=%-6d other=%d\n",
+			s.T, s.OK, s.ConnectFail, s.Truncated, s.Other)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("total: ok=%d connect_fail=%d truncated=%d other=%d\n",
+		res.OK, res.ConnectFail, res.Truncated, res.Other)
+	for kind, n := range res.Errors {
+		fmt.Printf("error %q x%d\n", kind, n)
+	}
+	if res.Pool != nil {
+		fmt.Printf("pool: dials=%d reuses=%d\n", res.Pool.Dials, res.Pool.Reuses)
+	}
+	if srv != nil {
+		eof, resets := srv.Closes()
+		fmt.Printf("server: connections ended by EOF=%d by reset=%d\n", eof, resets)
+	}
+}
+
+// errKind reduces an error to a short label, as loadgen does.
+func errKind(err error) string {
+	if errors.Is(err, syscall.EADDRNOTAVAIL) {
+		return "EADDRNOTAVAIL"
+	}
+	msg := err.Error()
+	if i := strings.LastIndex(msg, ": "); i >= 0 {
+		msg = msg[i+2:]
+	}
+	return msg
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// runMode runs mode against an in-process server from a 32-port range of
+// its own, so that the TIME_WAIT one test leaves behind does not fail the
+// next.
+func runMode(t *testing.T, mode, ports string, reply int) (*result, *server) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("runs for seconds")
+	}
+	cfg := config{Mode: mode, Rate: 500, Duration: 1500 * time.Millisecond,
+		Reply: reply, Ports: ports, Sources: 4, MaxIdle: 16}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := listen(mode == "server-linger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Addr = srv.Addr()
+	res, err := run(context.Background(), cfg, func(second) {})
+	if err != nil {
+		srv.Close()
+		t.Skipf("dial setup: %v", err)
+	}
+	srv.Close()
+	if res.OK == 0 && res.Errors[syscall.ENOPROTOOPT.Error()] > 0 {
+		t.Skip("kernel lacks IP_LOCAL_PORT_RANGE")
+	}
+	t.Logf("%s: %+v errors=%v", mode, res.counts, res.Errors)
+	return res, srv
+}
+
+func TestClose(t *testing.T) {
+	res, _ := runMode(t, "close", "40000-40031", 64)
+	if res.ConnectFail == 0 {
+		t.Errorf("32 ports at 500/s: no connect failures")
+	}
+}
+
+func TestPool(t *testing.T) {
+	res, _ := runMode(t, "pool", "40032-40063", 64)
+	if res.ConnectFail+res.Truncated+res.Other > 0 {
+		t.Errorf("pool: %+v", res.counts)
+	}
+	if res.Pool == nil || res.Pool.Reuses == 0 {
+		t.Errorf("pool: no reuse: %+v", res.Pool)
+	}
+}
+
+func TestLinger(t *testing.T) {
+	res, srv := runMode(t, "linger", "40064-40095", 64)
+	if res.ConnectFail+res.Truncated+res.Other > 0 {
+		t.Errorf("linger: %+v", res.counts)
+	}
+	if eof, resets := srv.Closes(); resets == 0 || eof > 0 {
+		t.Errorf("linger: server saw %d EOFs and %d resets, want only resets", eof, resets)
+	}
+}
+
+func TestServerLinger(t *testing.T) {
+	res, _ := runMode(t, "server-linger", "40096-40127", 1<<20)
+	if res.Truncated == 0 {
+		t.Errorf("server-linger with 1MB replies: nothing truncated: %+v", res.counts)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	good := config{Mode: "close", Rate: 1, Duration: time.Second, Sources: 1, MaxIdle: 1}
+	for _, ports := range []string{"", "1-1", "40000-40255"} {
+		c := good
+		c.Ports = ports
+		if err := c.validate(); err != nil {
+			t.Errorf("ports %q: %v", ports, err)
+		}
+	}
+	for _, ports := range []string{"40000", "0-10", "20-10", "1-70000", "a-b"} {
+		c := good
+		c.Ports = ports
+		if err := c.validate(); err == nil {
+			t.Errorf("ports %q: no error", ports)
+		}
+	}
+	c := good
+	c.Mode = "nope"
+	if err := c.validate(); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
+
+func TestErrKind(t *testing.T) {
+	err := &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.EADDRNOTAVAIL}}
+	if got := errKind(err); got != "EADDRNOTAVAIL" {
+		t.Errorf("errKind(%v) = %q", err, got)
+	}
+	err = &net.OpError{Op: "read", Net: "tcp", Err: &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}}
+	if got := errKind(err); got != "connection reset by peer" {
+		t.Errorf("errKind(%v) = %q", err, got)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/connpool"
+)
+
+// config is one run, as set by the flags.
+type config struct {
+	Mode     string
+	Addr     string
+	Rate     int
+	Duration time.Duration
+	Reply    int
+	Ports    string // LO-HI, or empty for the system range
+	Sources  int
+	MaxIdle  int
+}
+
+func (c *config) validate() error {
+	switch {
+	case !slices.Contains(modes, c.Mode):
+		return fmt.Errorf("unknown -mode %q", c.Mode)
+	case c.Rate <= 0 || c.Duration <= 0:
+		return errors.New("-rate and -duration must be positive")
+	case c.Reply < 0 || c.Reply > maxReply:
+		return fmt.Errorf("-reply must be between 0 and %d", maxReply)
+	case c.Sources < 1 || c.Sources > 254:
+		return errors.New("-sources must be between 1 and 254")
+	case c.MaxIdle < 1:
+		return errors.New("-max-idle must be positive")
+	}
+	_, _, err := parsePorts(c.Ports)
+	return err
+}
+
+// parsePorts parses LO-HI; an empty string is the zero range.
+func parsePorts(s string) (lo, hi uint16, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	l, h, ok := strings.Cut(s, "-")
+	a, err1 := strconv.ParseUint(l, 10, 16)
+	b, err2 := strconv.ParseUint(h, 10, 16)
+	if !ok || err1 != nil || err2 != nil || a == 0 || a > b {
+		return 0, 0, fmt.Errorf("bad -ports %q, want LO-HI", s)
+	}
+	return uint16(a), uint16(b), nil
+}
+
+// outcome classifies one request.
+type outcome int
+
+const (
+	ok          outcome = iota
+	connectFail         // EADDRNOTAVAIL: no free local port
+	truncated           // the connection ended before the whole reply arrived
+	other
+)
+
+// counts tallies outcomes.
+type counts struct {
+	OK, ConnectFail, Truncated, Other int64
+}
+
+func (c *counts) add(o outcome) {
+	switch o {
+	case ok:
+		c.OK++
+	case connectFail:
+		c.ConnectFail++
+	case truncated:
+		c.Truncated++
+	default:
+		c.Other++
+	}
+}
+
+// second is the outcomes of requests that finished in second T of the run.
+type second struct {
+	T int
+	counts
+}
+
+// result is a whole run.
+type result struct {
+	counts
+	Errors map[string]int
+	Pool   *connpool.Stats // -mode pool
+}
+
+// client makes requests the way cfg.Mode says.
+type client struct {
+	cfg     config
+	dialers []*net.Dialer
+	next    atomic.Uint64
+	pool    *connpool.Pool
+	req     [8]byte
+}
+
+func newClient(cfg config) (*client, error) {
+	lo, hi, _ := parsePorts(cfg.Ports)
+	sources := 1
+	if cfg.Mode == "sources" {
+		sources = cfg.Sources
+	}
+	control, err := dialControl(lo, hi, cfg.Mode == "sources")
+	if err != nil {
+		return nil, err
+	}
+	c := &client{cfg: cfg}
+	for i := range sources {
+		d := &net.Dialer{Timeout: 2 * time.Second, Control: control}
+		if cfg.Mode == "sources" {
+			// All of 127.0.0.0/8 is loopback on Linux, so these need no setup.
+			d.LocalAddr = &net.TCPAddr{IP: netip.AddrFrom4([4]byte{127, 0, 0, byte(i + 1)}).AsSlice()}
+		}
+		c.dialers = append(c.dialers, d)
+	}
+	if cfg.Mode == "pool" {
+		c.pool = connpool.New(c.dial, cfg.MaxIdle)
+	}
+	binary.BigEndian.PutUint64(c.req[:], uint64(cfg.Reply))
+	return c, nil
+}
+
+// dial opens a connection from the next source address.
+func (c *client) dial(ctx context.Context) (net.Conn, error) {
+	d := c.dialers[(c.next.Add(1)-1)%uint64(len(c.dialers))]
+	return d.DialContext(ctx, "tcp", c.cfg.Addr)
+}
+
+// do runs one request.
+func (c *client) do(ctx context.Context) (outcome, error) {
+	var conn net.Conn
+	var err error
+	if c.pool != nil {
+		conn, err = c.pool.Get(ctx)
+	} else {
+		conn, err = c.dial(ctx)
+	}
+	if err != nil {
+		if errors.Is(err, syscall.EADDRNOTAVAIL) {
+			return connectFail, err
+		}
+		return other, err
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(c.req[:]); err != nil {
+		conn.Close()
+		return other, err
+	}
+	n, err := io.CopyN(io.Discard, conn, int64(c.cfg.Reply))
+	if err != nil {
+		conn.Close()
+		if n < int64(c.cfg.Reply) && (errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)) {
+			return truncated, fmt.Errorf("got %d of %d bytes: %w", n, c.cfg.Reply, err)
+		}
+		return other, err
+	}
+
+	switch c.cfg.Mode {
+	case "pool":
+		conn.SetDeadline(time.Time{})
+		c.pool.Put(conn)
+	case "linger":
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	default:
+		conn.Close()
+	}
+	return ok, nil
+}
+
+func (c *client) close() {
+	if c.pool != nil {
+		c.pool.Close()
+	}
+}
+
+// run sends cfg.Rate requests per second for cfg.Duration on an open-loop
+// schedule and calls onSecond with each second's outcomes.
+func run(ctx context.Context, cfg config, onSecond func(second)) (*result, error) {
+	c, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	res := &result{Errors: make(map[string]int)}
+	var mu sync.Mutex
+	var window counts
+	var wg sync.WaitGroup
+	record := func(o outcome, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		window.add(o)
+		res.add(o)
+		if err != nil {
+			res.Errors[errKind(err)]++
+		}
+	}
+
+	start := time.Now()
+	tick := time.NewTicker(time.Millisecond)
+	defer tick.Stop()
+	report := time.NewTicker(time.Second)
+	defer report.Stop()
+	t := 0
+	flush := func() {
+		mu.Lock()
+		w := window
+		window = counts{}
+		mu.Unlock()
+		t++
+		onSecond(second{T: t, counts: w})
+	}
+
+	for started := int64(0); ctx.Err() == nil; {
+		select {
+		case <-ctx.Done():
+			continue
+		case <-report.C:
+			flush()
+		case <-tick.C:
+			due := int64(time.Since(start).Seconds() * float64(cfg.Rate))
+			for ; started < due; started++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					// Requests in flight at the end run to completion.
+					record(c.do(context.WithoutCancel(ctx)))
+				}()
+			}
+		}
+	}
+	wg.Wait()
+	flush() // requests that finished after the last full second
+	if c.pool != nil {
+		s := c.pool.Stats()
+		res.Pool = &s
+	}
+	return res, nil
+}
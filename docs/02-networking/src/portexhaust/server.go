@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// maxReply bounds the reply a client may ask for.
+const maxReply = 64 << 20
+
+// server answers each 8-byte big-endian size with that many bytes, and
+// counts how its connections ended.
+type server struct {
+	ln          net.Listener
+	linger      bool // close with SO_LINGER 0 after the first reply
+	wg          sync.WaitGroup
+	eof, resets atomic.Int64
+}
+
+func listen(linger bool) (*server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &server{ln: ln, linger: linger}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+	return s, nil
+}
+
+func (s *server) Addr() string { return s.ln.Addr().String() }
+
+// Close stops accepting and waits for open connections to end.
+func (s *server) Close() error {
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
+
+// Closes returns how many connections ended with a clean EOF and how many
+// with ECONNRESET.
+func (s *server) Closes() (eof, resets int64) { return s.eof.Load(), s.resets.Load() }
+
+func (s *server) serve(conn net.Conn) {
+	defer conn.Close()
+	var req [8]byte
+	zeros := make([]byte, 64<<10)
+	for {
+		if _, err := io.ReadFull(conn, req[:]); err != nil {
+			switch {
+			case errors.Is(err, io.EOF):
+				s.eof.Add(1)
+			case errors.Is(err, syscall.ECONNRESET):
+				s.resets.Add(1)
+			}
+			return
+		}
+		n := binary.BigEndian.Uint64(req[:])
+		if n > maxReply {
+			return
+		}
+		for n > 0 {
+			chunk := zeros[:min(n, uint64(len(zeros)))]
+			if _, err := conn.Write(chunk); err != nil {
+				return
+			}
+			n -= uint64(len(chunk))
+		}
+		if s.linger {
+			// Return as soon as the reply is written, without waiting for
+			// the client to read it, and leave no TIME_WAIT behind.
+			conn.(*net.TCPConn).SetLinger(0)
+			return
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package pow2
+
+import "testing"
+
+var sink int
+
+// ringLen is read through a variable so the compiler cannot see it is a
+// power of two, which is the situation in any ring sized at run time.
+var ringLen = 1 << 12
+
+// BenchmarkIndex advances a cursor around a ring the way a queue or timing
+// wheel does and reads the slot it lands on.
+func BenchmarkIndex(b *testing.B) {
+	ring := make([]int, ringLen)
+	for i := range ring {
+		ring[i] = i
+	}
+
+	b.Run("modulo", func(b *testing.B) {
+		n, sum := len(ring), 0
+		for i := 0; b.Loop(); i++ {
+			sum += ring[i%n]
+		}
+		sink = sum
+	})
+	b.Run("modulo-const", func(b *testing.B) {
+		// A constant power-of-two divisor is turned into a mask by the
+		// compiler, plus a fix-up for negative i.
+		const n = 1 << 12
+		sum := 0
+		for i := 0; b.Loop(); i++ {
+			sum += ring[i%n]
+		}
+		sink = sum
+	})
+	b.Run("mask", func(b *testing.B) {
+		m, sum := MaskFor(len(ring)), 0
+		for i := 0; b.Loop(); i++ {
+			sum += ring[m.Index(i)]
+		}
+		sink = sum
+	})
+}
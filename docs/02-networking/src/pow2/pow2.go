@@ -0,0 +1,47 @@
+// Package pow2 sizes rings and pools to powers of two so they can be
+// indexed with a mask. i % n compiles to a division when n is only known at
+// run time, tens of cycles on most cores; i & (n-1) is one instruction and,
+// unlike %, never yields a negative index.
+package pow2
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// NextPow2 returns the smallest power of two that is at least n. It
+// returns 1 for n <= 1.
+func NextPow2(n int) int {
+	return 1 << Log2Ceil(n)
+}
+
+// Log2Ceil returns the base-2 logarithm of NextPow2(n).
+func Log2Ceil(n int) int {
+	if n <= 1 {
+		return 0
+	}
+	return bits.Len(uint(n - 1))
+}
+
+// IsPow2 reports whether n is a positive power of two.
+func IsPow2(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+// Mask maps any integer onto [0, n) for a power-of-two n.
+type Mask uint
+
+// MaskFor returns the mask for a ring of n slots. It panics if n is not a
+// power of two; size the ring with NextPow2 first.
+func MaskFor(n int) Mask {
+	if !IsPow2(n) {
+		panic(fmt.Sprintf("pow2: ring size %d is not a power of two", n))
+	}
+	return Mask(n - 1)
+}
+
+// Len returns the number of slots the mask covers.
+func (m Mask) Len() int { return int(m) + 1 }
+
+// Index returns i modulo Len, for any i including negative ones.
+func (m Mask) Index(i int) int { return i & int(m) }
@@ -0,0 +1,50 @@
+package pow2
+
+import "testing"
+
+func TestNextPow2(t *testing.T) {
+	for _, tc := range []struct{ n, want, log int }{
+		{-5, 1, 0}, {0, 1, 0}, {1, 1, 0}, {2, 2, 1}, {3, 4, 2},
+		{4, 4, 2}, {5, 8, 3}, {1000, 1024, 10}, {1 << 20, 1 << 20, 20},
+		{1<<20 + 1, 1 << 21, 21},
+	} {
+		if got := NextPow2(tc.n); got != tc.want {
+			t.Errorf("NextPow2(%d) = %d, want %d", tc.n, got, tc.want)
+		}
+		if got := Log2Ceil(tc.n); got != tc.log {
+			t.Errorf("Log2Ceil(%d) = %d, want %d", tc.n, got, tc.log)
+		}
+	}
+}
+
+func TestIsPow2(t *testing.T) {
+	for n := -2; n <= 1<<12; n++ {
+		want := n > 0 && NextPow2(n) == n
+		if IsPow2(n) != want {
+			t.Fatalf("IsPow2(%d) = %v", n, !want)
+		}
+	}
+}
+
+func TestMask(t *testing.T) {
+	m := MaskFor(8)
+	if m.Len() != 8 {
+		t.Fatalf("Len = %d, want 8", m.Len())
+	}
+	for i := range 100 {
+		if got := m.Index(i); got != i%8 {
+			t.Fatalf("Index(%d) = %d, want %d", i, got, i%8)
+		}
+	}
+	// Counting down past zero keeps wrapping, where % would go negative.
+	if got := m.Index(-1); got != 7 {
+		t.Fatalf("Index(-1) = %d, want 7", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("MaskFor(12) did not panic")
+		}
+	}()
+	MaskFor(12)
+}
@@ -0,0 +1,304 @@
+//go:build linux
+
+// Command prioinv shows priority inversion on a Go mutex, and two ways to
+// share state that a latency-critical thread cannot be held up by.
+//
+// Three threads share one CPU. A critical thread, SCHED_FIFO by default,
+// reads shared state every -period. A low-priority thread at nice 19
+// updates it every -gap, spending -hold of CPU on each update. -hogs
+// threads at nice 0 spin. With a mutex the update runs inside the lock.
+// Once the hogs preempt the low-priority thread there, the critical thread
+// waits for a thread that gets a sliver of the CPU, however high its own
+// priority: the kernel cannot see who holds a sync.Mutex, so it cannot
+// lend the holder the waiter's priority, as a PI futex would. The other
+// modes move the update out of anything the critical thread waits on:
+//
+//	mutex     the update runs under a sync.Mutex the reader also takes
+//	snapshot  the writer builds a new copy and publishes it with one atomic
+//	          store; the reader loads the pointer
+//	queue     the writer computes the change and sends it on a channel; the
+//	          reader applies what is queued to its own copy, without blocking
+//
+// Every thread is pinned to -cpu, and GOMAXPROCS is raised to give each a
+// P, so that the kernel, not the Go scheduler, decides who runs:
+//
+//	go run ./prioinv -modes mutex,snapshot,queue -hogs 0,2
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	modesF   = flag.String("modes", "mutex,snapshot,queue", "Comma separated ways to share the state, one run each")
+	hogsF    = flag.String("hogs", "0,2", "Comma separated numbers of nice 0 CPU hogs, one run each")
+	cpu      = flag.Int("cpu", 0, "CPU every thread is pinned to")
+	hold     = flag.Duration("hold", 500*time.Microsecond, "CPU the low-priority thread spends on each update")
+	gap      = flag.Duration("gap", time.Millisecond, "Sleep between the low-priority thread's updates")
+	period   = flag.Duration("period", time.Millisecond, "Interval between the critical thread's reads")
+	duration = flag.Duration("duration", 5*time.Second, "Measurement time per run")
+	nice     = flag.Int("nice", 19, "Nice value of the low-priority thread")
+	fifo     = flag.Int("fifo", 10, "SCHED_FIFO priority of the critical thread (0 leaves it SCHED_OTHER at nice 0)")
+)
+
+// state is what the low-priority thread maintains and the critical thread
+// reads: a version and a table, say of routes or limits.
+type state struct {
+	version uint64
+	table   [64]uint64
+}
+
+// change is one update to a state, computed by the low-priority thread.
+type change struct {
+	version uint64
+	slot    int
+	value   uint64
+}
+
+func (s *state) apply(c change) {
+	s.version, s.table[c.slot] = c.version, c.value
+}
+
+// shared is one way for the low-priority thread to update a state that the
+// critical thread reads. update is called by one writer, read by one
+// reader; read returns the version it saw.
+type shared interface {
+	update(compute func() change)
+	read() uint64
+}
+
+func newShared(mode string) (shared, error) {
+	switch mode {
+	case "mutex":
+		return &mutexShared{}, nil
+	case "snapshot":
+		s := &snapshotShared{}
+		s.p.Store(&state{})
+		return s, nil
+	case "queue":
+		return &queueShared{ch: make(chan change, 1024)}, nil
+	}
+	return nil, fmt.Errorf("unknown mode %q: want mutex, snapshot or queue", mode)
+}
+
+// mutexShared computes and applies each change with the lock held, as code
+// that guards a structure with a mutex usually ends up doing.
+type mutexShared struct {
+	mu sync.Mutex
+	s  state
+}
+
+func (m *mutexShared) update(compute func() change) {
+	m.mu.Lock()
+	m.s.apply(compute())
+	m.mu.Unlock()
+}
+
+func (m *mutexShared) read() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.s.version
+}
+
+// snapshotShared is copy on write: the writer never changes a state the
+// reader can see, so the reader needs no lock. Each update copies the
+// state, which is cheap for 520 bytes and not for a large map.
+type snapshotShared struct {
+	p atomic.Pointer[state]
+}
+
+func (m *snapshotShared) update(compute func() change) {
+	c := compute()
+	next := *m.p.Load()
+	next.apply(c)
+	m.p.Store(&next)
+}
+
+func (m *snapshotShared) read() uint64 { return m.p.Load().version }
+
+// queueShared hands changes to the reader, which owns the only copy of the
+// state. The channel has a lock of its own, but it is held for the length
+// of a copy, not of an update, and a reader that finds the queue empty
+// does not take it.
+type queueShared struct {
+	ch chan change
+	s  state // the reader's
+}
+
+func (m *queueShared) update(compute func() change) { m.ch <- compute() }
+
+func (m *queueShared) read() uint64 {
+	for {
+		select {
+		case c := <-m.ch:
+			m.s.apply(c)
+		default:
+			return m.s.version
+		}
+	}
+}
+
+// pinThread locks the calling goroutine to its thread, pins the thread to
+// cpu and runs setup on it. The goroutine must not unlock: its thread, with
+// the changed affinity and priority, exits with it.
+func pinThread(cpu int, setup func() error) error {
+	runtime.LockOSThread()
+	var set unix.CPUSet
+	set.Set(cpu)
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		return err
+	}
+	return setup()
+}
+
+func setNice(n int) func() error {
+	return func() error { return unix.Setpriority(unix.PRIO_PROCESS, unix.Gettid(), n) }
+}
+
+func setFIFO(prio int) func() error {
+	return func() error {
+		if prio == 0 {
+			return nil
+		}
+		return unix.SchedSetAttr(0, &unix.SchedAttr{Size: unix.SizeofSchedAttr, Policy: unix.SCHED_FIFO, Priority: uint32(prio)}, 0)
+	}
+}
+
+// spin burns d of CPU.
+func spin(d time.Duration) {
+	for start := time.Now(); time.Since(start) < d; {
+	}
+}
+
+// result is one run: how long each of the critical thread's reads took.
+type result struct {
+	mode    string
+	hogs    int
+	reads   []time.Duration
+	updates int
+}
+
+// run measures one mode next to hogs spinning threads.
+func run(mode string, hogs int) (*result, error) {
+	sh, err := newShared(mode)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		stop sync.WaitGroup
+		done atomic.Bool
+		errc = make(chan error, hogs+2)
+		r    = &result{mode: mode, hogs: hogs}
+	)
+	for range hogs {
+		stop.Add(1)
+		go func() {
+			defer stop.Done()
+			if err := pinThread(*cpu, setNice(0)); err != nil {
+				errc <- fmt.Errorf("hog: %w", err)
+				return
+			}
+			for !done.Load() {
+			}
+		}()
+	}
+	stop.Add(1)
+	go func() {
+		defer stop.Done()
+		if err := pinThread(*cpu, setNice(*nice)); err != nil {
+			errc <- fmt.Errorf("low-priority thread: %w", err)
+			return
+		}
+		for v := uint64(1); !done.Load(); v++ {
+			sh.update(func() change {
+				spin(*hold)
+				return change{version: v, slot: int(v % 64), value: v}
+			})
+			r.updates++
+			time.Sleep(*gap)
+		}
+	}()
+
+	crit := make(chan struct{})
+	go func() {
+		defer close(crit)
+		if err := pinThread(*cpu, setFIFO(*fifo)); err != nil {
+			errc <- fmt.Errorf("critical thread (SCHED_FIFO needs CAP_SYS_NICE; -fifo 0 runs it without): %w", err)
+			return
+		}
+		start := time.Now()
+		next := start
+		for time.Since(start) < *duration {
+			next = next.Add(*period)
+			time.Sleep(time.Until(next))
+			t0 := time.Now()
+			sh.read()
+			r.reads = append(r.reads, time.Since(t0))
+		}
+	}()
+	<-crit
+	done.Store(true)
+	stop.Wait()
+	select {
+	case err := <-errc:
+		return nil, err
+	default:
+	}
+	return r, nil
+}
+
+func (r *result) print() {
+	slices.Sort(r.reads)
+	q := func(p float64) time.Duration { return r.reads[min(int(p*float64(len(r.reads))), len(r.reads)-1)] }
+	var waited time.Duration
+	for _, d := range r.reads {
+		waited += d
+	}
+	fmt.Printf("%-9s %4d %8d %6d %10v %10v %10v %7.2f%%\n", r.mode, r.hogs, r.updates, len(r.reads),
+		q(0.5), q(0.99), r.reads[len(r.reads)-1], 100*waited.Seconds()/duration.Seconds())
+}
+
+func parseInts(s string) ([]int, error) {
+	var out []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bad count %q", f)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func main() {
+	flag.Parse()
+	hogs, err := parseInts(*hogsF)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// A P for every pinned thread and one to spare: with fewer, a hog
+	// holding a P keeps the other threads off it whatever their priority.
+	runtime.GOMAXPROCS(slices.Max(hogs) + 3)
+
+	fmt.Printf("%-9s %4s %8s %6s %10s %10s %10s %8s\n", "mode", "hogs", "updates", "reads", "read p50", "read p99", "read max", "waiting")
+	for _, h := range hogs {
+		for _, mode := range strings.Split(*modesF, ",") {
+			r, err := run(strings.TrimSpace(mode), h)
+			if err != nil {
+				log.Fatal(err)
+			}
+			r.print()
+		}
+	}
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package main
+
+import "testing"
+
+// TestShared checks that every mode shows the reader each update, in order,
+// whether it reads after every update or after many.
+func TestShared(t *testing.T) {
+	for _, mode := range []string{"mutex", "snapshot", "queue"} {
+		sh, err := newShared(mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v uint64
+		for range 3 {
+			for range 100 {
+				v++
+				sh.update(func() change { return change{version: v, slot: int(v % 64), value: v} })
+			}
+			if got := sh.read(); got != v {
+				t.Errorf("%s: read version %d, want %d", mode, got, v)
+			}
+		}
+	}
+	if _, err := newShared("rwmutex"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
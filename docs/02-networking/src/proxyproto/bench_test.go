@@ -0,0 +1,37 @@
+package proxyproto
+
+import (
+	"net/netip"
+	"testing"
+)
+
+var sinkHeader Header
+
+// BenchmarkParse parses one header per iteration, which a server behind a
+// load balancer does for every accepted connection.
+func BenchmarkParse(b *testing.B) {
+	h4 := Header{Version: 1, Command: Proxy, Network: "tcp4",
+		Source: netip.MustParseAddrPort("192.0.2.1:56324"), Dst: netip.MustParseAddrPort("198.51.100.7:443")}
+	h6 := Header{Version: 1, Command: Proxy, Network: "tcp6",
+		Source: netip.MustParseAddrPort("[2001:db8::1]:1000"), Dst: netip.MustParseAddrPort("[2001:db8::2]:80")}
+	for _, tc := range []struct {
+		name string
+		in   []byte
+	}{
+		{"v1/tcp4", h4.AppendV1(nil)},
+		{"v1/tcp6", h6.AppendV1(nil)},
+		{"v2/tcp4", h4.AppendV2(nil)},
+		{"v2/tcp6", h6.AppendV2(nil)},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				h, _, err := Parse(tc.in)
+				if err != nil {
+					b.Fatal(err)
+				}
+				sinkHeader = h
+			}
+		})
+	}
+}
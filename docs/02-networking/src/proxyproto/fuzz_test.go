@@ -0,0 +1,64 @@
+package proxyproto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// FuzzParse checks that Parse never panics or over-reads, and that every
+// header it accepts survives being written out and parsed again.
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"PROXY TCP4 192.0.2.1 198.51.100.7 56324 443\r\n",
+		"PROXY TCP6 2001:db8::1 2001:db8::2 1000 80\r\nGET / HTTP/1.1\r\n",
+		"PROXY UNKNOWN\r\n",
+		"PROXY TCP4 1.1.1.1 2.2.2.2 65536 1\r\n",
+		"PROXY TCP4 1.1.1.1",
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c\xc0\x00\x02\x01\xc6\x33\x64\x07\xdc\x04\x01\xbb",
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x21\x00\x28" + string(make([]byte, 40)),
+		"\r\n\r\n\x00\r\nQUIT\n\x20\x00\x00\x00",
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x03abc",
+		"GET / HTTP/1.1\r\n",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		h, n, err := Parse(b)
+		if err != nil {
+			if n != 0 {
+				t.Fatalf("error %v with %d bytes consumed", err, n)
+			}
+			return
+		}
+		if n <= 0 || n > len(b) {
+			t.Fatalf("consumed %d of %d bytes", n, len(b))
+		}
+		// A header is self-delimiting: trailing data must not change it.
+		h2, n2, err := Parse(append(bytes.Clone(b[:n]), "trailing"...))
+		if err != nil || h2 != h || n2 != n {
+			t.Fatalf("header changed with trailing data: %+v %d %v", h2, n2, err)
+		}
+
+		var out []byte
+		if h.Version == 1 {
+			out = h.AppendV1(nil)
+		} else {
+			out = h.AppendV2(nil)
+		}
+		h3, n3, err := Parse(out)
+		if err != nil || n3 != len(out) {
+			t.Fatalf("re-encoded header %q: %d bytes, %v", out, n3, err)
+		}
+		if h3 != h {
+			t.Fatalf("round trip: got %+v, want %+v", h3, h)
+		}
+		// Every strict prefix of a header is incomplete, never invalid.
+		for i := 1; i < len(out); i++ {
+			if _, _, err := Parse(out[:i]); !errors.Is(err, ErrIncomplete) {
+				t.Fatalf("prefix %q: %v", out[:i], err)
+			}
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Listener accepts connections that start with a PROXY protocol header.
+// The header is read lazily on the first Read or RemoteAddr call, so a slow
+// client cannot hold up Accept.
+type Listener struct {
+	net.Listener
+	// Timeout bounds how long reading the header may take. Zero means
+	// no limit.
+	Timeout time.Duration
+	// Optional accepts connections without a header, as when a server is
+	// reachable both directly and through a proxy. Only enable it when
+	// untrusted clients cannot reach the port: anyone could claim any
+	// source address.
+	Optional bool
+}
+
+// Accept implements net.Listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: c, timeout: l.Timeout, optional: l.Optional}, nil
+}
+
+// Conn is a connection whose RemoteAddr comes from the PROXY header.
+type Conn struct {
+	net.Conn
+	timeout  time.Duration
+	optional bool
+
+	once sync.Once
+	r    *bufio.Reader // holds bytes peeked past the header
+	hdr  Header
+	err  error
+}
+
+func (c *Conn) readHeader() {
+	if c.timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+	c.r = bufio.NewReader(c.Conn)
+	// Look at everything already buffered, or wait for one more byte.
+	for n := 1; ; n = max(c.r.Buffered(), n+1) {
+		b, err := c.r.Peek(n)
+		h, used, perr := Parse(b)
+		switch {
+		case perr == nil:
+			c.hdr = h
+			c.r.Discard(used)
+			return
+		case errors.Is(perr, ErrNotProxy) && c.optional:
+			return
+		case !errors.Is(perr, ErrIncomplete):
+			c.err = perr
+			return
+		case errors.Is(err, bufio.ErrBufferFull):
+			c.err = ErrInvalid // TLVs larger than we are willing to buffer
+			return
+		case err != nil:
+			c.err = err
+			return
+		}
+	}
+}
+
+// Header returns the parsed header. Without a header (Optional listeners
+// only) it returns a zero Header.
+func (c *Conn) Header() (Header, error) {
+	c.once.Do(c.readHeader)
+	return c.hdr, c.err
+}
+
+// Read implements net.Conn.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	// Once the peeked bytes are drained, skip the extra copy.
+	if c.r.Buffered() > 0 {
+		return c.r.Read(p)
+	}
+	return c.Conn.Read(p)
+}
+
+// RemoteAddr returns the client address from the header, or the address of
+// the peer if the header carried none.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	switch c.hdr.Network {
+	case "tcp4", "tcp6":
+		return net.TCPAddrFromAddrPort(c.hdr.Source)
+	case "udp4", "udp6":
+		return net.UDPAddrFromAddrPort(c.hdr.Source)
+	}
+	return c.Conn.RemoteAddr()
+}
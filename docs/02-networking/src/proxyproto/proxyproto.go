@@ -0,0 +1,254 @@
+// Package proxyproto parses the PROXY protocol header (versions 1 and 2)
+// that load balancers such as HAProxy and AWS NLB prepend to a connection
+// to pass on the original client address.
+//
+// Parse works on a byte slice and never reads past the header, so it can run
+// on data peeked from a connection; Listener wraps a net.Listener and
+// replaces RemoteAddr with the address from the header.
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"strconv"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/netaddr"
+)
+
+var (
+	// ErrIncomplete means the input is a valid prefix of a header; read
+	// more and parse again.
+	ErrIncomplete = errors.New("proxyproto: incomplete header")
+	// ErrNotProxy means the input does not start with a PROXY header.
+	ErrNotProxy = errors.New("proxyproto: not a PROXY protocol header")
+	// ErrInvalid means the header is malformed.
+	ErrInvalid = errors.New("proxyproto: invalid header")
+)
+
+// Command is the v2 command. Version 1 headers are always Proxy.
+type Command byte
+
+const (
+	Local Command = 0x0 // health check from the proxy itself; no addresses
+	Proxy Command = 0x1 // relayed connection
+)
+
+// Header is a parsed PROXY protocol header.
+type Header struct {
+	Version int
+	Command Command
+	// Network is "tcp4", "tcp6", "udp4", "udp6" or "" when the proxy did
+	// not pass addresses (v1 UNKNOWN, v2 LOCAL or AF_UNSPEC/AF_UNIX).
+	Network     string
+	Source, Dst netip.AddrPort
+}
+
+const (
+	v1Prefix = "PROXY "
+	v1MaxLen = 107 // including CRLF, from the spec
+	v2Header = 16
+)
+
+var v2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Parse parses the header at the start of b and returns it with the number
+// of bytes it occupies.
+func Parse(b []byte) (Header, int, error) {
+	switch {
+	case len(b) == 0:
+		return Header{}, 0, ErrIncomplete
+	case hasPrefix(b, v2Sig):
+		if len(b) < len(v2Sig) {
+			return Header{}, 0, ErrIncomplete
+		}
+		return parseV2(b)
+	case hasPrefix(b, []byte(v1Prefix)):
+		if len(b) < len(v1Prefix) {
+			return Header{}, 0, ErrIncomplete
+		}
+		return parseV1(b)
+	default:
+		return Header{}, 0, ErrNotProxy
+	}
+}
+
+// hasPrefix reports whether b and prefix agree on their common length, so a
+// short b can still be recognised.
+func hasPrefix(b, prefix []byte) bool {
+	n := min(len(b), len(prefix))
+	return n > 0 && bytes.Equal(b[:n], prefix[:n])
+}
+
+func parseV1(b []byte) (Header, int, error) {
+	end := bytes.Index(b[:min(len(b), v1MaxLen)], []byte("\r\n"))
+	if end < 0 {
+		if len(b) >= v1MaxLen {
+			return Header{}, 0, ErrInvalid
+		}
+		return Header{}, 0, ErrIncomplete
+	}
+	// The fields go in an array: bytes.Split would allocate a slice for
+	// them on every connection.
+	var fields [5][]byte
+	nf, rest, more := 0, b[len(v1Prefix):end], true
+	for ; more && nf < len(fields); nf++ {
+		fields[nf], rest, more = bytes.Cut(rest, []byte(" "))
+	}
+	h := Header{Version: 1, Command: Proxy}
+	switch string(fields[0]) {
+	case "UNKNOWN":
+		// Everything after UNKNOWN is to be ignored.
+		return h, end + 2, nil
+	case "TCP4":
+		h.Network = "tcp4"
+	case "TCP6":
+		h.Network = "tcp6"
+	default:
+		return Header{}, 0, ErrInvalid
+	}
+	if nf != len(fields) || more {
+		return Header{}, 0, ErrInvalid
+	}
+	src, err1 := parseV1Addr(fields[1], fields[3], h.Network)
+	dst, err2 := parseV1Addr(fields[2], fields[4], h.Network)
+	if err1 != nil || err2 != nil {
+		return Header{}, 0, ErrInvalid
+	}
+	h.Source, h.Dst = src, dst
+	return h, end + 2, nil
+}
+
+func parseV1Addr(ip, port []byte, network string) (netip.AddrPort, error) {
+	addr, err := netaddr.ParseAddr(ip)
+	if err != nil || addr.Zone() != "" || addr.Is4() != (network == "tcp4") {
+		return netip.AddrPort{}, ErrInvalid
+	}
+	// The spec forbids leading zeros and signs.
+	if len(port) == 0 || len(port) > 5 || (port[0] == '0' && len(port) > 1) || port[0] < '0' || port[0] > '9' {
+		return netip.AddrPort{}, ErrInvalid
+	}
+	p, err := strconv.ParseUint(string(port), 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, ErrInvalid
+	}
+	return netip.AddrPortFrom(addr, uint16(p)), nil
+}
+
+func parseV2(b []byte) (Header, int, error) {
+	if len(b) < v2Header {
+		return Header{}, 0, ErrIncomplete
+	}
+	verCmd, fam := b[12], b[13]
+	if verCmd>>4 != 2 {
+		return Header{}, 0, ErrInvalid
+	}
+	h := Header{Version: 2, Command: Command(verCmd & 0xf)}
+	if h.Command != Local && h.Command != Proxy {
+		return Header{}, 0, ErrInvalid
+	}
+	n := v2Header + int(binary.BigEndian.Uint16(b[14:16]))
+	if len(b) < n {
+		return Header{}, 0, ErrIncomplete
+	}
+	body := b[v2Header:n]
+
+	var ipLen int
+	switch fam >> 4 {
+	case 0x0, 0x3: // AF_UNSPEC, AF_UNIX: no usable addresses
+	case 0x1:
+		ipLen = 4
+	case 0x2:
+		ipLen = 16
+	default:
+		return Header{}, 0, ErrInvalid
+	}
+	// The names are constants: building them from the protocol and the
+	// family would allocate a string per header.
+	var net4, net6 string
+	switch fam & 0xf {
+	case 0x0:
+	case 0x1:
+		net4, net6 = "tcp4", "tcp6"
+	case 0x2:
+		net4, net6 = "udp4", "udp6"
+	default:
+		return Header{}, 0, ErrInvalid
+	}
+
+	// LOCAL connections keep the real endpoints; the block is skipped.
+	if h.Command == Local || ipLen == 0 || net4 == "" {
+		return h, n, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return Header{}, 0, ErrInvalid
+	}
+	src, _ := netip.AddrFromSlice(body[:ipLen])
+	dst, _ := netip.AddrFromSlice(body[ipLen : 2*ipLen])
+	ports := body[2*ipLen:]
+	if ipLen == 4 {
+		h.Network = net4
+	} else {
+		h.Network = net6
+	}
+	h.Source = netip.AddrPortFrom(src, binary.BigEndian.Uint16(ports[0:2]))
+	h.Dst = netip.AddrPortFrom(dst, binary.BigEndian.Uint16(ports[2:4]))
+	// Anything after the addresses is TLVs, which we skip.
+	return h, n, nil
+}
+
+// AppendV1 appends h in the text format. Only tcp4 and tcp6 have addresses
+// in v1; any other network is written as UNKNOWN.
+func (h Header) AppendV1(dst []byte) []byte {
+	dst = append(dst, v1Prefix...)
+	switch h.Network {
+	case "tcp4":
+		dst = append(dst, "TCP4 "...)
+	case "tcp6":
+		dst = append(dst, "TCP6 "...)
+	default:
+		return append(dst, "UNKNOWN\r\n"...)
+	}
+	dst = h.Source.Addr().AppendTo(dst)
+	dst = append(dst, ' ')
+	dst = h.Dst.Addr().AppendTo(dst)
+	dst = append(dst, ' ')
+	dst = strconv.AppendUint(dst, uint64(h.Source.Port()), 10)
+	dst = append(dst, ' ')
+	dst = strconv.AppendUint(dst, uint64(h.Dst.Port()), 10)
+	return append(dst, "\r\n"...)
+}
+
+// AppendV2 appends h in the binary format.
+func (h Header) AppendV2(dst []byte) []byte {
+	dst = append(dst, v2Sig...)
+	dst = append(dst, 0x20|byte(h.Command&0xf))
+	var fam byte
+	switch h.Network {
+	case "tcp4":
+		fam = 0x11
+	case "udp4":
+		fam = 0x12
+	case "tcp6":
+		fam = 0x21
+	case "udp6":
+		fam = 0x22
+	}
+	if h.Command == Local {
+		fam = 0
+	}
+	dst = append(dst, fam)
+	if fam == 0 {
+		return binary.BigEndian.AppendUint16(dst, 0)
+	}
+	src, d := h.Source.Addr(), h.Dst.Addr()
+	if fam>>4 == 1 {
+		src, d = src.Unmap(), d.Unmap()
+	}
+	dst = binary.BigEndian.AppendUint16(dst, uint16(2*src.BitLen()/8+4))
+	dst = append(dst, src.AsSlice()...)
+	dst = append(dst, d.AsSlice()...)
+	dst = binary.BigEndian.AppendUint16(dst, h.Source.Port())
+	return binary.BigEndian.AppendUint16(dst, h.Dst.Port())
+}
@@ -0,0 +1,93 @@
+package proxyproto
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tcp4 := Header{Version: 1, Command: Proxy, Network: "tcp4",
+		Source: netip.MustParseAddrPort("192.0.2.1:56324"), Dst: netip.MustParseAddrPort("198.51.100.7:443")}
+	tcp6 := Header{Version: 2, Command: Proxy, Network: "tcp6",
+		Source: netip.MustParseAddrPort("[2001:db8::1]:1000"), Dst: netip.MustParseAddrPort("[2001:db8::2]:80")}
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  Header
+		n     int
+		err   error
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.0.2.1 198.51.100.7 56324 443\r\nGET /"), tcp4, 45, nil},
+		{"v1 unknown", []byte("PROXY UNKNOWN ignored stuff\r\n"), Header{Version: 1, Command: Proxy}, 29, nil},
+		{"v1 partial", []byte("PROXY TCP4 192.0.2.1"), Header{}, 0, ErrIncomplete},
+		{"v1 prefix only", []byte("PRO"), Header{}, 0, ErrIncomplete},
+		{"v1 no crlf", append([]byte("PROXY TCP4 "), make([]byte, 120)...), Header{}, 0, ErrInvalid},
+		{"v1 leading zero port", []byte("PROXY TCP4 192.0.2.1 198.51.100.7 056324 443\r\n"), Header{}, 0, ErrInvalid},
+		{"v1 family mismatch", []byte("PROXY TCP6 192.0.2.1 198.51.100.7 1 2\r\n"), Header{}, 0, ErrInvalid},
+		{"v2 tcp6", tcp6.AppendV2(nil), tcp6, 52, nil},
+		{"v2 local", Header{Command: Local}.AppendV2(nil), Header{Version: 2, Command: Local}, 16, nil},
+		{"v2 truncated", tcp6.AppendV2(nil)[:40], Header{}, 0, ErrIncomplete},
+		{"v2 bad version", append(append([]byte{}, v2Sig...), 0x11, 0x11, 0, 0), Header{}, 0, ErrInvalid},
+		{"http", []byte("GET / HTTP/1.1\r\n"), Header{}, 0, ErrNotProxy},
+		{"empty", nil, Header{}, 0, ErrIncomplete},
+	}
+	for _, tt := range tests {
+		h, n, err := Parse(tt.input)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if h != tt.want || n != tt.n {
+			t.Errorf("%s: got %+v (%d bytes), want %+v (%d bytes)", tt.name, h, n, tt.want, tt.n)
+		}
+	}
+}
+
+func TestListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := &Listener{Listener: ln, Timeout: time.Second}
+	defer pl.Close()
+
+	go func() {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Write([]byte("PROXY TCP4 203.0.113.9 127.0.0.1 4000 80\r\nhello"))
+	}()
+
+	c, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got := c.RemoteAddr().String(); got != "203.0.113.9:4000" {
+		t.Errorf("RemoteAddr = %s", got)
+	}
+	body, err := io.ReadAll(c)
+	if err != nil || string(body) != "hello" {
+		t.Errorf("body = %q, %v", body, err)
+	}
+}
+
+func TestConnHeaderOnly(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := &Conn{Conn: server, timeout: time.Second}
+	go client.Write([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 1000 80\r\n"))
+
+	// The header is complete without any payload behind it, so this must
+	// not wait for more data.
+	if got := c.RemoteAddr().String(); got != "[2001:db8::1]:1000" {
+		t.Fatalf("RemoteAddr = %s", got)
+	}
+}
@@ -3,30 +3,154 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/quic-go/quic-go"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/admin"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/drain"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/soak"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/srvconfig"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/telemetry"
+)
+
+// -control serves the drain protocol used by the load generator and the
+// admin knobs.
+var controlAddr = flag.String("control", "localhost:9102", "Address for the drain protocol and the admin knobs")
+
+// receivedLog samples the per-stream "Received" line, which at load test
+// rates costs more than the stream itself.
+var receivedLog = admin.NewSampler(1)
+
+// cfg is the UDP address to serve on, shared with the other servers (see
+// the srvconfig package); several servers on different ports make the
+// backends for udplb. -idle is QUIC's idle timeout, which the peers
+// negotiate down to the lower of their two; 0 keeps quic-go's 30 seconds.
+// quic-go raises the socket's receive buffer to 7 MiB itself, so the
+// socket buffer flags are left out.
+var cfg = srvconfig.Register(flag.CommandLine, srvconfig.Config{
+	Addr:        "localhost:4242",
+	IdleTimeout: 30 * time.Second,
+}, srvconfig.Addr|srvconfig.IdleTimeout)
+
+// Soak mode, as in echo-net-trace.go: snapshot the process while it runs
+// for hours and flag steady growth.
+var (
+	soakDir      = flag.String("soak", "", "Soak mode: write snapshots and heap profiles to this directory and flag steady growth")
+	soakInterval = flag.Duration("soak-interval", time.Minute, "Time between soak snapshots")
+)
+
+// streamLatency holds the time from accepting a stream to closing it,
+// for the last 4096 streams.
+var streamLatency = telemetry.NewRing(4096)
+
+var (
+	ctl = drain.New()
+
+	connsMu sync.Mutex
+	conns   = make(map[quic.Connection]struct{})
 )
 
 func main() {
+	flag.Parse()
+	if err := cfg.Apply(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	var mon *soak.Monitor
+	if *soakDir != "" {
+		mon = soak.New(soak.Config{Interval: *soakInterval, Dir: *soakDir, Latency: streamLatency})
+		go func() {
+			if err := mon.Run(context.Background()); err != nil {
+				log.Printf("soak: %v", err)
+			}
+		}()
+	}
+
+	adm := admin.New()
+	adm.Handle("/drain", ctl)
+	adm.Knob("gc_percent", "GOGC; -1 turns the GC off", admin.GCPercent())
+	adm.Knob("log_received", "log one received stream in this many (0 for none)", receivedLog)
+	go func() {
+		if err := adm.ListenAndServe(*controlAddr); err != nil {
+			log.Printf("control listener: %v", err)
+		}
+	}()
+
 	// quic-server-init-start
-	listener, err := quic.ListenAddr("localhost:4242", generateTLSConfig(), nil)
+	listener, err := quic.ListenAddr(cfg.Addr, generateTLSConfig(), &quic.Config{MaxIdleTimeout: cfg.IdleTimeout})
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Println("QUIC server listening on localhost:4242")
+	fmt.Println("QUIC server listening on", cfg.Addr)
+	// Closing the listener stops new handshakes but leaves established
+	// connections alone, so they can finish their streams.
+	ctl.OnDrain(func() { listener.Close() })
 
 	for {
 		conn, err := listener.Accept(context.Background())
+		if errors.Is(err, quic.ErrServerClosed) {
+			break
+		}
 		if err != nil {
 			log.Println("Accept error:", err)
 			continue
 		}
-		go handleConn(conn)
+		// Tracked before the goroutine starts, so that a drain that
+		// begins right after this Accept still closes the connection.
+		untrack := track(conn)
+		go func() {
+			defer untrack()
+			handleConn(conn)
+		}()
 	}
 	// quic-server-init-end
+	drainConns()
+	if mon != nil {
+		for _, d := range mon.Drifts() {
+			log.Printf("soak: still drifting at exit: %v", d)
+		}
+	}
+}
+
+// track registers conn so drainConns can close it once its streams are done.
+func track(conn quic.Connection) (untrack func()) {
+	connsMu.Lock()
+	conns[conn] = struct{}{}
+	connsMu.Unlock()
+	return func() {
+		connsMu.Lock()
+		delete(conns, conn)
+		connsMu.Unlock()
+	}
+}
+
+// drainConns waits up to the drain grace period for open streams to finish,
+// then closes every remaining connection. Plain QUIC has no GOAWAY, so a
+// stream the client opens just before the close still fails; the load
+// generator reports those as failed in-flight requests.
+func drainConns() {
+	ctx, cancel := context.WithTimeout(context.Background(), ctl.Grace)
+	defer cancel()
+	if d, err := ctl.Wait(ctx); err != nil {
+		log.Printf("Drain grace period expired with %d streams open", ctl.Status().Active)
+	} else {
+		log.Printf("Streams drained in %v", d)
+	}
+
+	connsMu.Lock()
+	n := len(conns)
+	for conn := range conns {
+		conn.CloseWithError(0, "draining")
+	}
+	connsMu.Unlock()
+	log.Printf("Closed %d connections", n)
+	time.Sleep(100 * time.Millisecond) // let the CONNECTION_CLOSE frames go out
 }
 
 func handleConn(conn quic.Connection) {
@@ -39,11 +163,14 @@ func handleConn(conn quic.Connection) {
 			return
 		}
 
-		go func(s quic.Stream) {
+		ctl.Acquire()
+		go func(s quic.Stream, start time.Time) {
+			defer ctl.Release()
+			defer func() { streamLatency.Record(time.Since(start)) }()
 			defer s.Close()
 
 			data, err := io.ReadAll(s)
-			if len(data) > 0 {
+			if len(data) > 0 && receivedLog.Sample() {
 			    log.Printf("Received: %s", string(data))
 			}
 			if err != nil && err != io.EOF {
@@ -51,7 +178,7 @@ func handleConn(conn quic.Connection) {
 			        log.Println("read error:", err)
 			    }
 			}
-		}(stream)
+		}(stream, time.Now())
 	}
 	// quic-server-handle-end
 }
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mutexBucket is a token bucket built the way golang.org/x/time/rate's
+// Limiter is: a float token count and the time it was last updated, under
+// a mutex, advanced by the elapsed time on every call. It is the baseline
+// the lock-free bucket is measured against; xtimerate_test.go measures the
+// real Limiter where that module is available.
+type mutexBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *mutexBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(b.last)
+	b.last = now
+	b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type allower interface{ Allow() bool }
+
+// xtimerate is set by xtimerate_test.go when built with that tag.
+var xtimerate func() allower
+
+type limiter struct {
+	name string
+	new  func() allower
+}
+
+// limiters returns the shared limiters, each refilling at 1e12/s so the
+// benchmark measures the cost of a decision and never runs dry.
+func limiters() []limiter {
+	const rate, burst = 1e12, 1000
+	ls := []limiter{
+		{"atomic", func() allower { return NewTokenBucket(rate, burst) }},
+		{"mutex", func() allower { return &mutexBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()} }},
+	}
+	if xtimerate != nil {
+		ls = append(ls, limiter{"xtimerate", xtimerate})
+	}
+	return ls
+}
+
+func BenchmarkAllow(b *testing.B) {
+	b.Run("local", func(b *testing.B) {
+		l := NewLocal(1e12, 1000)
+		for b.Loop() {
+			l.Allow()
+		}
+	})
+	for _, lim := range limiters() {
+		b.Run(lim.name, func(b *testing.B) {
+			l := lim.new()
+			for b.Loop() {
+				l.Allow()
+			}
+		})
+	}
+}
+
+// BenchmarkAllowParallel shares one limiter between 1, 8 and 64 goroutines
+// per CPU.
+func BenchmarkAllowParallel(b *testing.B) {
+	for _, lim := range limiters() {
+		for _, p := range []int{1, 8, 64} {
+			b.Run(fmt.Sprintf("%s/goroutines=%dxCPU", lim.name, p), func(b *testing.B) {
+				l := lim.new()
+				b.SetParallelism(p)
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						l.Allow()
+					}
+				})
+			})
+		}
+	}
+}
+
+// BenchmarkPerKey spreads the calls over 1024 client addresses.
+func BenchmarkPerKey(b *testing.B) {
+	l := NewPerKey[netip.Addr](1e12, 1000)
+	addrs := make([]netip.Addr, 1024)
+	for i := range addrs {
+		addrs[i] = netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)})
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			l.Allow(addrs[i%len(addrs)])
+			i++
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+)
+
+// LeakyBucket paces requests to a steady rate. Where a TokenBucket lets a
+// burst through at once and then refuses, a LeakyBucket queues it: each
+// request is given the next free slot, one interval after the previous
+// one, and waits for it. The queue is bounded; a request whose slot is
+// more than queue intervals away is refused.
+//
+// Use it in front of something that wants an even load, such as a
+// downstream with a fixed rate or a link that drops bursts; use a
+// TokenBucket where a burst is fine and waiting is not.
+type LeakyBucket struct {
+	p      params
+	next   atomic.Int64 // when the next free slot starts
+	denied atomic.Int64
+}
+
+// NewLeakyBucket returns a bucket releasing rate requests per second with
+// up to queue of them waiting.
+func NewLeakyBucket(rate float64, queue int) *LeakyBucket {
+	return &LeakyBucket{p: newParams(rate, max(queue, 1))}
+}
+
+// Reserve takes the next free slot and returns how long until it starts,
+// or false if the queue is full. A reserved slot is used up whether or not
+// the caller waits for it.
+func (b *LeakyBucket) Reserve() (time.Duration, bool) { return b.reserveAt(b.p.now()) }
+
+// SetClock makes the bucket tell time, and Wait wait, by c, such as a
+// clock.Fake in a test. Call it before the bucket is shared.
+func (b *LeakyBucket) SetClock(c clock.Clock) { b.p.setClock(c) }
+
+func (b *LeakyBucket) reserveAt(now int64) (time.Duration, bool) {
+	for {
+		next := b.next.Load()
+		slot := max(next, now)
+		if slot-now >= b.p.tolerance {
+			b.denied.Add(1)
+			return 0, false
+		}
+		if b.next.CompareAndSwap(next, slot+b.p.interval) {
+			return time.Duration(slot - now), true
+		}
+	}
+}
+
+// Wait blocks until the caller's slot starts. It returns ErrLimited at
+// once if the queue is full, and ctx's error if ctx ends first, in which
+// case the slot goes unused.
+func (b *LeakyBucket) Wait(ctx context.Context) error {
+	d, ok := b.Reserve()
+	if !ok {
+		return ErrLimited
+	}
+	if d == 0 {
+		return ctx.Err()
+	}
+	t := clock.Or(b.p.clock).NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Denied returns how many requests found the queue full.
+func (b *LeakyBucket) Denied() int64 { return b.denied.Load() }
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"net"
+	"net/netip"
+	"sync/atomic"
+)
+
+// Listener limits how fast connections are accepted, per client address,
+// from new addresses, and in total. A connection over any limit is reset
+// as soon as it is accepted, and Accept moves on to the next one, so
+// callers only ever see the connections they are allowed to serve.
+// Accepted connections are returned unwrapped, so callers can still reach
+// the *net.TCPConn.
+type Listener struct {
+	net.Listener
+	perIP    *PerKey[netip.Addr]
+	global   *TokenBucket
+	known    AddrSet
+	fresh    *TokenBucket
+	rejected atomic.Int64
+}
+
+// AddrSet is a set of client addresses, such as a bloom.Window, which
+// forgets them after a while in a fixed amount of memory. Add reports
+// whether the address was new.
+type AddrSet interface {
+	Add(netip.Addr) bool
+	Contains(netip.Addr) bool
+}
+
+// NewListener wraps ln. Either limiter may be nil to leave that limit off.
+func NewListener(ln net.Listener, perIP *PerKey[netip.Addr], global *TokenBucket) *Listener {
+	return &Listener{Listener: ln, perIP: perIP, global: global}
+}
+
+// LimitNew admits connections from addresses not in known at most as fast
+// as fresh allows, and records the addresses it admits. Addresses in known
+// skip that limit, so under a flood of connections from many new addresses
+// the clients that were served recently still get in. It returns l.
+func (l *Listener) LimitNew(known AddrSet, fresh *TokenBucket) *Listener {
+	l.known, l.fresh = known, fresh
+	return l
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.allow(c) {
+			return c, nil
+		}
+		l.rejected.Add(1)
+		reject(c)
+	}
+}
+
+// allow checks the per-address limit first, so a client over its own
+// limit does not use up the new-client or global ones.
+func (l *Listener) allow(c net.Conn) bool {
+	a, ok := remoteAddr(c)
+	if ok && l.perIP != nil && !l.perIP.Allow(a) {
+		return false
+	}
+	if ok && l.known != nil && !l.known.Contains(a) {
+		if !l.fresh.Allow() {
+			return false
+		}
+		l.known.Add(a)
+	}
+	return l.global == nil || l.global.Allow()
+}
+
+// Rejected returns how many connections were accepted and reset.
+func (l *Listener) Rejected() int64 { return l.rejected.Load() }
+
+func remoteAddr(c net.Conn) (netip.Addr, bool) {
+	a, ok := c.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	return a.AddrPort().Addr().Unmap(), true
+}
+
+// reject closes c with a reset rather than a FIN: the client learns at
+// once, and the server keeps no TIME_WAIT entry for a connection it never
+// served.
+func reject(c net.Conn) {
+	if tc, ok := c.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	c.Close()
+}
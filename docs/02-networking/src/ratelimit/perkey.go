@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+)
+
+// sweepEvery is how often PerKey drops the buckets that have refilled.
+const sweepEvery = time.Minute
+
+// PerKey keeps a TokenBucket per key, such as one per client address, all
+// with the same rate and burst. Looking up a known key does not lock, so
+// concurrent requests for different keys do not contend.
+//
+// A bucket that has refilled is no different from a new one, so PerKey
+// forgets it: once a minute, the Allow that notices the time sweeps them
+// out, and memory stays proportional to the keys active in the last burst
+// window rather than all keys ever seen.
+type PerKey[K comparable] struct {
+	p         params
+	buckets   sync.Map // K -> *TokenBucket
+	n         atomic.Int64
+	lastSweep atomic.Int64
+	denied    atomic.Int64
+}
+
+// NewPerKey returns a limiter giving each key rate tokens per second and
+// bursts of up to burst.
+func NewPerKey[K comparable](rate float64, burst int) *PerKey[K] {
+	l := &PerKey[K]{p: newParams(rate, burst)}
+	l.lastSweep.Store(nanotime())
+	return l
+}
+
+// Allow takes one of key's tokens and reports whether it had one.
+func (l *PerKey[K]) Allow(key K) bool { return l.allowAt(key, l.p.now()) }
+
+// SetClock makes the limiter and its buckets tell time by c, such as a
+// clock.Fake in a test. Call it before the limiter is shared.
+func (l *PerKey[K]) SetClock(c clock.Clock) {
+	l.p.setClock(c)
+	l.lastSweep.Store(l.p.now())
+}
+
+func (l *PerKey[K]) allowAt(key K, now int64) bool {
+	if last := l.lastSweep.Load(); now-last > int64(sweepEvery) && l.lastSweep.CompareAndSwap(last, now) {
+		l.sweep(now)
+	}
+	v, ok := l.buckets.Load(key)
+	if !ok {
+		var loaded bool
+		v, loaded = l.buckets.LoadOrStore(key, &TokenBucket{p: l.p})
+		if !loaded {
+			l.n.Add(1)
+		}
+	}
+	if !v.(*TokenBucket).allowAt(now, 1) {
+		l.denied.Add(1)
+		return false
+	}
+	return true
+}
+
+// sweep drops the buckets that are full at now. A request racing the
+// removal of its key's bucket may take a token from the dropped bucket,
+// letting that key one request past its limit once.
+func (l *PerKey[K]) sweep(now int64) {
+	l.buckets.Range(func(k, v any) bool {
+		if v.(*TokenBucket).tat.Load() <= now && l.buckets.CompareAndDelete(k, v) {
+			l.n.Add(-1)
+		}
+		return true
+	})
+}
+
+// Len returns how many keys have a bucket.
+func (l *PerKey[K]) Len() int { return int(l.n.Load()) }
+
+// Denied returns how many requests have been refused, over all keys.
+func (l *PerKey[K]) Denied() int64 { return l.denied.Load() }
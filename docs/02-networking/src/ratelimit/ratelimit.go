@@ -0,0 +1,162 @@
+// Package ratelimit limits how often something may happen: requests on a
+// connection, new connections from one address, or anything else counted
+// against a rate.
+//
+// All the limiters here run the generic cell rate algorithm (GCRA), which
+// is a token bucket kept as a single number: the theoretical arrival time
+// (TAT) at which the bucket will be full again. A request is allowed if
+// taking its tokens would not push the TAT more than burst intervals past
+// now. With one word of state, the shared TokenBucket is a compare-and-swap
+// loop instead of a mutex around a token count and a timestamp:
+//
+//	global := ratelimit.NewTokenBucket(10_000, 100) // 10k/s, bursts of 100
+//	if !global.Allow() {
+//		// reject
+//	}
+//
+// Local is the same bucket without atomics, for state owned by one
+// goroutine such as a connection's handler. LeakyBucket paces instead of
+// rejecting: it tells each request how long to wait for its slot. PerKey
+// keeps one bucket per key, and Listener uses it to cap how fast each
+// client address may open connections.
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+)
+
+// ErrLimited is returned when a request would exceed its limit.
+var ErrLimited = errors.New("ratelimit: limit exceeded")
+
+// epoch anchors the monotonic nanosecond clock the buckets count in, so
+// their state fits in an int64 and the zero TAT means "full".
+var epoch = time.Now()
+
+func nanotime() int64 { return int64(time.Since(epoch)) }
+
+// params are the rate and burst of a bucket in clock units, and the clock
+// itself when it is not the system's.
+type params struct {
+	interval  int64 // nanoseconds per token
+	tolerance int64 // burst * interval: how far the TAT may run ahead of now
+
+	clock  clock.Clock // nil: nanotime
+	origin time.Time   // clock's time at nanosecond 0
+}
+
+// setClock makes p count from c's present time.
+func (p *params) setClock(c clock.Clock) {
+	p.clock, p.origin = c, c.Now()
+}
+
+// now returns the current time in clock units.
+func (p *params) now() int64 {
+	if p.clock == nil {
+		return nanotime()
+	}
+	return int64(p.clock.Now().Sub(p.origin))
+}
+
+func newParams(rate float64, burst int) params {
+	if !(rate > 0) || burst < 1 {
+		panic("ratelimit: rate and burst must be positive")
+	}
+	interval := int64(math.Max(1, math.Round(float64(time.Second)/rate)))
+	return params{interval: interval, tolerance: int64(burst) * interval}
+}
+
+// next returns the TAT after n tokens are taken at now from a bucket whose
+// TAT is tat, and whether the bucket had them.
+func (p params) next(tat, now int64, n int) (int64, bool) {
+	t := max(tat, now) + int64(n)*p.interval
+	return t, t-now <= p.tolerance
+}
+
+// TokenBucket is a token bucket safe for concurrent use. Allow costs one
+// atomic load and, when the request is allowed, one compare-and-swap; it
+// never blocks.
+type TokenBucket struct {
+	p      params
+	tat    atomic.Int64
+	denied atomic.Int64
+}
+
+// NewTokenBucket returns a full bucket refilled at rate tokens per second
+// and holding up to burst tokens.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{p: newParams(rate, burst)}
+}
+
+// Allow takes one token and reports whether there was one.
+func (b *TokenBucket) Allow() bool { return b.AllowN(1) }
+
+// AllowN takes n tokens if the bucket has them all, and reports whether it
+// did.
+func (b *TokenBucket) AllowN(n int) bool { return b.allowAt(b.p.now(), n) }
+
+func (b *TokenBucket) allowAt(now int64, n int) bool {
+	for {
+		tat := b.tat.Load()
+		t, ok := b.p.next(tat, now, n)
+		if !ok {
+			b.denied.Add(1)
+			return false
+		}
+		if b.tat.CompareAndSwap(tat, t) {
+			return true
+		}
+	}
+}
+
+// Denied returns how many requests the bucket has refused.
+func (b *TokenBucket) Denied() int64 { return b.denied.Load() }
+
+// SetClock makes the bucket tell time by c, such as a clock.Fake in a
+// test. Call it before the bucket is shared.
+func (b *TokenBucket) SetClock(c clock.Clock) { b.p.setClock(c) }
+
+// Local is a token bucket for one goroutine, such as the handler of a
+// single connection. It is the TokenBucket algorithm without atomics and
+// must not be shared.
+type Local struct {
+	p   params
+	tat int64
+}
+
+// NewLocal returns a full bucket refilled at rate tokens per second and
+// holding up to burst tokens.
+func NewLocal(rate float64, burst int) Local { return Local{p: newParams(rate, burst)} }
+
+// Allow takes one token and reports whether there was one.
+func (b *Local) Allow() bool { return b.AllowN(1) }
+
+// AllowN takes n tokens if the bucket has them all, and reports whether it
+// did.
+func (b *Local) AllowN(n int) bool { return b.allowAt(b.p.now(), n) }
+
+// SetClock makes the bucket tell time by c, such as a clock.Fake in a
+// test.
+func (b *Local) SetClock(c clock.Clock) { b.p.setClock(c) }
+
+func (b *Local) allowAt(now int64, n int) bool {
+	t, ok := b.p.next(b.tat, now, n)
+	if ok {
+		b.tat = t
+	}
+	return ok
+}
+
+// Until returns how long until the bucket holds n tokens, or 0 if it
+// holds them now. It takes none. A pacer sleeps for it and then takes
+// them with AllowN; n must not exceed the burst, which the bucket never
+// holds more than.
+func (b *Local) Until(n int) time.Duration { return b.untilAt(b.p.now(), n) }
+
+func (b *Local) untilAt(now int64, n int) time.Duration {
+	return time.Duration(max(b.tat+int64(n)*b.p.interval-b.p.tolerance-now, 0))
+}
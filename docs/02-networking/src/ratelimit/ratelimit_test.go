@@ -0,0 +1,331 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+)
+
+const ms = int64(time.Millisecond)
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(1000, 10) // a token per millisecond
+	now := 100 * ms
+	for i := range 10 {
+		if !b.allowAt(now, 1) {
+			t.Fatalf("request %d of a burst of 10 denied", i)
+		}
+	}
+	if b.allowAt(now, 1) {
+		t.Fatal("11th request of the burst allowed")
+	}
+	if !b.allowAt(now+ms, 1) || b.allowAt(now+ms, 1) {
+		t.Fatal("want exactly one token back after one interval")
+	}
+	if !b.allowAt(now+20*ms, 10) || b.allowAt(now+20*ms, 1) {
+		t.Fatal("want a full bucket of 10 after a long pause, and no more")
+	}
+	if b.allowAt(now+100*ms, 11) {
+		t.Fatal("AllowN above the burst allowed")
+	}
+	if got := b.Denied(); got != 4 {
+		t.Errorf("Denied %d, want 4", got)
+	}
+}
+
+// TestTokenBucketConcurrent checks that the CAS loop hands out exactly the
+// burst when many goroutines race for it.
+func TestTokenBucketConcurrent(t *testing.T) {
+	b := NewTokenBucket(1, 500)
+	now := 100 * ms
+	var mu sync.Mutex
+	allowed := 0
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n := 0
+			for range 100 {
+				if b.allowAt(now, 1) {
+					n++
+				}
+			}
+			mu.Lock()
+			allowed += n
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if allowed != 500 {
+		t.Errorf("allowed %d of 5000, want the burst of 500", allowed)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	b := NewLocal(100, 2) // a token per 10ms
+	now := 100 * ms
+	if !b.allowAt(now, 1) || !b.allowAt(now, 1) || b.allowAt(now, 1) {
+		t.Fatal("want a burst of 2")
+	}
+	if b.allowAt(now+9*ms, 1) {
+		t.Fatal("token back before its interval")
+	}
+	if !b.allowAt(now+10*ms, 1) {
+		t.Fatal("no token after one interval")
+	}
+}
+
+func TestLocalUntil(t *testing.T) {
+	b := NewLocal(100, 4) // a token per 10ms
+	now := 100 * ms
+	if d := b.untilAt(now, 4); d != 0 {
+		t.Fatalf("full bucket: %v until 4 tokens", d)
+	}
+	b.allowAt(now, 3)
+	if d := b.untilAt(now, 1); d != 0 {
+		t.Fatalf("%v until the token left", d)
+	}
+	if d := b.untilAt(now, 3); d != 20*time.Millisecond {
+		t.Fatalf("%v until 3 tokens with 1 left, want 20ms", d)
+	}
+	if d := b.untilAt(now+15*ms, 3); d != 5*time.Millisecond {
+		t.Fatalf("%v until 3 tokens 15ms on, want 5ms", d)
+	}
+	if !b.allowAt(now+20*ms, 3) {
+		t.Fatal("3 tokens not there when Until said")
+	}
+}
+
+func TestLeakyBucket(t *testing.T) {
+	b := NewLeakyBucket(100, 3) // a slot per 10ms, three queued
+	now := 100 * ms
+	for i, want := range []time.Duration{0, 10 * time.Millisecond, 20 * time.Millisecond} {
+		if d, ok := b.reserveAt(now); !ok || d != want {
+			t.Fatalf("request %d: %v, %v; want %v", i, d, ok, want)
+		}
+	}
+	if _, ok := b.reserveAt(now); ok {
+		t.Fatal("fourth request fit in a queue of 3")
+	}
+	if d, ok := b.reserveAt(now + 15*ms); !ok || d != 15*time.Millisecond {
+		t.Fatalf("after 15ms: %v, %v; want the slot at 30ms", d, ok)
+	}
+}
+
+func TestLeakyWait(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	b := NewLeakyBucket(50, 2) // a slot per 20ms
+	b.SetClock(clk)
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- b.Wait(context.Background()) }()
+	clk.BlockUntil(1)
+	clk.Advance(19 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("second request done after 19ms (%v), want it 20ms after the first", err)
+	default:
+	}
+	clk.Advance(time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.Wait(ctx); err != context.Canceled {
+		t.Errorf("Wait with a canceled context: %v", err)
+	}
+	b.next.Store(b.p.now() + int64(time.Hour)) // the queue is full for an hour
+	if err := b.Wait(context.Background()); err != ErrLimited {
+		t.Errorf("Wait on a full queue: %v, want ErrLimited", err)
+	}
+}
+
+// TestClock runs the token buckets on a fake clock through their exported
+// methods: a burst, a refusal, and a token back after exactly one
+// interval.
+func TestClock(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	shared := NewTokenBucket(100, 2) // a token per 10ms
+	shared.SetClock(clk)
+	local := NewLocal(100, 2)
+	local.SetClock(clk)
+	perKey := NewPerKey[string](100, 2)
+	perKey.SetClock(clk)
+	buckets := map[string]func() bool{
+		"TokenBucket": shared.Allow,
+		"Local":       local.Allow,
+		"PerKey":      func() bool { return perKey.Allow("a") },
+	}
+	for name, allow := range buckets {
+		if !allow() || !allow() || allow() {
+			t.Errorf("%s: want a burst of 2", name)
+		}
+	}
+	clk.Advance(9 * time.Millisecond)
+	for name, allow := range buckets {
+		if allow() {
+			t.Errorf("%s: token back after 9ms", name)
+		}
+	}
+	clk.Advance(time.Millisecond)
+	for name, allow := range buckets {
+		if !allow() || allow() {
+			t.Errorf("%s: want one token back after 10ms", name)
+		}
+	}
+}
+
+func TestPerKey(t *testing.T) {
+	l := NewPerKey[string](1000, 2)
+	now := 100 * ms
+	l.lastSweep.Store(now)
+	for _, k := range []string{"a", "a", "b", "b"} {
+		if !l.allowAt(k, now) {
+			t.Fatalf("%s denied within its burst", k)
+		}
+	}
+	if l.allowAt("a", now) || l.allowAt("b", now) {
+		t.Fatal("burst exceeded")
+	}
+	if !l.allowAt("c", now) {
+		t.Fatal("a new key shares the others' limit")
+	}
+	if l.Len() != 3 || l.Denied() != 2 {
+		t.Fatalf("Len %d Denied %d, want 3 and 2", l.Len(), l.Denied())
+	}
+
+	// Past the sweep interval, the refilled buckets are dropped and only
+	// the key just used remains.
+	if !l.allowAt("a", now+int64(sweepEvery)+ms) {
+		t.Fatal("a still limited a minute later")
+	}
+	if l.Len() != 1 {
+		t.Errorf("Len %d after the sweep, want 1", l.Len())
+	}
+}
+
+func TestListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := NewListener(inner, NewPerKey[netip.Addr](0.001, 3), nil)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	// Connections are accepted in order, so the first three get through.
+	// The other two are reset, which the client sees on its first read, or
+	// already on connect if the reset beats Dial's check of the socket.
+	reset := 0
+	for i := range 5 {
+		c, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			reset++
+			continue
+		}
+		defer c.Close()
+		if i < 3 {
+			s := <-accepted
+			if _, ok := s.(*net.TCPConn); !ok {
+				t.Errorf("accepted a %T, want the *net.TCPConn", s)
+			}
+			s.Close()
+			continue
+		}
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := c.Read(make([]byte, 1)); err != nil && !errors.Is(err, io.EOF) {
+			reset++
+		}
+	}
+	if reset != 2 || ln.Rejected() != 2 {
+		t.Errorf("%d clients reset, %d rejected; want 2", reset, ln.Rejected())
+	}
+	select {
+	case c := <-accepted:
+		t.Errorf("accepted %v past the limit", c.RemoteAddr())
+	default:
+	}
+}
+
+// forgetful is an AddrSet that never remembers, so every client is new.
+type forgetful struct{}
+
+func (forgetful) Add(netip.Addr) bool      { return true }
+func (forgetful) Contains(netip.Addr) bool { return false }
+
+// mapSet is an AddrSet that remembers every address.
+type mapSet map[netip.Addr]bool
+
+func (m mapSet) Add(a netip.Addr) bool {
+	added := !m[a]
+	m[a] = true
+	return added
+}
+
+func (m mapSet) Contains(a netip.Addr) bool { return m[a] }
+
+// TestListenerLimitNew lets one new client in: with a set that remembers
+// it, its later connections skip the limit; with one that does not, they
+// are reset.
+func TestListenerLimitNew(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		known  AddrSet
+		served int
+	}{{"remembered", mapSet{}, 4}, {"forgotten", forgetful{}, 1}} {
+		inner, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln := NewListener(inner, nil, nil).LimitNew(tc.known, NewTokenBucket(0.001, 1))
+		served := make(chan int)
+		go func() {
+			n := 0
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					served <- n
+					return
+				}
+				n++
+				c.Close()
+			}
+		}()
+		for range 4 {
+			c, err := net.Dial("tcp", inner.Addr().String())
+			if err != nil {
+				continue // reset before Dial returned
+			}
+			c.SetReadDeadline(time.Now().Add(time.Second))
+			c.Read(make([]byte, 1)) // until the server closes or resets it
+			c.Close()
+		}
+		ln.Close()
+		if n := <-served; n != tc.served || ln.Rejected() != int64(4-tc.served) {
+			t.Errorf("%s: served %d, rejected %d; want %d served", tc.name, n, ln.Rejected(), tc.served)
+		}
+	}
+}
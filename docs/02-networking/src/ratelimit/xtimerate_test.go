@@ -0,0 +1,15 @@
+//go:build xtimerate
+
+// This file adds golang.org/x/time/rate to the benchmarks. The module is
+// not a dependency of the guide; to run it:
+//
+//	go get golang.org/x/time/rate
+//	go test -tags xtimerate -bench Allow ./ratelimit
+
+package ratelimit
+
+import "golang.org/x/time/rate"
+
+func init() {
+	xtimerate = func() allower { return rate.NewLimiter(1e12, 1000) }
+}
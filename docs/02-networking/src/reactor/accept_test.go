@@ -0,0 +1,257 @@
+//go:build linux
+
+package reactor
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// dialAndReset opens n connections to sa from several goroutines. Each client
+// closes with SO_LINGER{1, 0} so the test leaves no TIME_WAIT sockets behind.
+func dialAndReset(tb testing.TB, sa *syscall.SockaddrInet4, n int) *sync.WaitGroup {
+	const dialers = 4
+	var wg sync.WaitGroup
+	for w := 0; w < dialers; w++ {
+		count := n / dialers
+		if w < n%dialers {
+			count++
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Connect writes the raw address into its argument, so each
+			// dialer needs its own copy.
+			sa := *sa
+			for i := 0; i < count; i++ {
+				fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+				if err != nil {
+					tb.Error(err)
+					return
+				}
+				if err := syscall.Connect(fd, &sa); err != nil {
+					syscall.Close(fd)
+					tb.Error(err)
+					return
+				}
+				syscall.SetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER, &syscall.Linger{Onoff: 1, Linger: 0})
+				syscall.Close(fd)
+			}
+		}()
+	}
+	return &wg
+}
+
+func loopbackSockaddr(addr net.Addr) *syscall.SockaddrInet4 {
+	return &syscall.SockaddrInet4{Port: addr.(*net.TCPAddr).Port, Addr: [4]byte{127, 0, 0, 1}}
+}
+
+// BenchmarkAccept_Listener measures the path echo-epoll.go used to get a
+// raw non-blocking fd before it accepted in its own loop: net.Listener.Accept, SyscallConn, Control and
+// SetNonblock.
+func BenchmarkAccept_Listener(b *testing.B) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+
+	wg := dialAndReset(b, loopbackSockaddr(ln.Addr()), b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn, err := ln.Accept()
+		if err != nil {
+			b.Fatal(err)
+		}
+		rawConn, err := conn.(*net.TCPConn).SyscallConn()
+		if err != nil {
+			b.Fatal(err)
+		}
+		var fd int
+		rawConn.Control(func(f uintptr) { fd = int(f) })
+		if err := syscall.SetNonblock(fd, true); err != nil {
+			b.Fatal(err)
+		}
+		conn.Close()
+	}
+	b.StopTimer()
+	wg.Wait()
+}
+
+// BenchmarkAccept_Accept4 measures accepting from a raw listening socket
+// registered in epoll, one accept4(SOCK_NONBLOCK|SOCK_CLOEXEC) per wake.
+func BenchmarkAccept_Accept4(b *testing.B) {
+	lfd, err := listenTCP("127.0.0.1:0", maxListenerBacklog(), false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer syscall.Close(lfd)
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer syscall.Close(epfd)
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(lfd)}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, lfd, &ev); err != nil {
+		b.Fatal(err)
+	}
+	sa, err := syscall.Getsockname(lfd)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	wg := dialAndReset(b, loopbackSockaddr(fromSockaddr(sa)), b.N)
+	events := make([]syscall.EpollEvent, 1)
+	b.ResetTimer()
+	for accepted := 0; accepted < b.N; {
+		if _, err := syscall.EpollWait(epfd, events, -1); err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			b.Fatal(err)
+		}
+		fd, _, err := accept(lfd)
+		if err == syscall.EAGAIN {
+			continue
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		syscall.Close(fd)
+		accepted++
+	}
+	b.StopTimer()
+	wg.Wait()
+}
+
+// countOpens signals done once n connections have been opened, and
+// records when that happened.
+type countOpens struct {
+	n, opened atomic.Int64
+	last      time.Time
+	done      chan struct{}
+}
+
+func (h *countOpens) OnOpen(c *Conn) {
+	if h.opened.Add(1) == h.n.Load() {
+		h.last = time.Now()
+		close(h.done)
+	}
+}
+func (h *countOpens) OnData(c *Conn, data []byte) {}
+func (h *countOpens) OnClose(c *Conn, err error)  {}
+
+func TestAcceptBatch(t *testing.T) {
+	const n = 200
+	for _, batch := range []int{1, 64} {
+		t.Run(fmt.Sprintf("batch=%d", batch), func(t *testing.T) {
+			h := &countOpens{done: make(chan struct{})}
+			h.n.Store(n)
+			l := startLoop(t, h, Config{AcceptBatch: batch})
+			dialAndReset(t, loopbackSockaddr(l.Addr()), n).Wait()
+			select {
+			case <-h.done:
+			case <-time.After(10 * time.Second):
+				t.Fatalf("opened %d of %d connections", h.opened.Load(), n)
+			}
+			st := l.Stats()
+			if st.Accepts != n {
+				t.Fatalf("Accepts = %d, want %d", st.Accepts, n)
+			}
+			if batch == 1 && st.AcceptWakes < n {
+				t.Fatalf("AcceptWakes = %d for %d single accepts", st.AcceptWakes, n)
+			}
+		})
+	}
+}
+
+// dialHeld opens n connections to sa and leaves them open; the handshakes
+// complete into the listener's accept queue whether or not anything is
+// accepting. resetAll closes them with RST.
+func dialHeld(tb testing.TB, sa *syscall.SockaddrInet4, n int) []int {
+	fds := make([]int, 0, n)
+	for range n {
+		fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+		if err != nil {
+			resetAll(fds)
+			tb.Fatal(err)
+		}
+		if err := syscall.Connect(fd, sa); err != nil {
+			syscall.Close(fd)
+			resetAll(fds)
+			tb.Fatal(err)
+		}
+		fds = append(fds, fd)
+	}
+	return fds
+}
+
+func resetAll(fds []int) {
+	for _, fd := range fds {
+		syscall.SetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER, &syscall.Linger{Onoff: 1, Linger: 0})
+		syscall.Close(fd)
+	}
+}
+
+// BenchmarkAcceptStorm measures how fast a Loop drains a storm of 1000
+// connections that are already queued on its listener when it starts,
+// taking one per listener wake or up to 64.
+//
+// With busy=200, the 200 connections at the head of the queue have each
+// sent 64KB, which the loop reads 64 bytes at a time, so every wake also
+// carries up to 200 read events, as it would for a loop already serving
+// traffic when the storm hits. With one accept per wake, the storm then
+// drains at one connection per full loop iteration.
+//
+// The clock runs from Run to the last OnOpen. That leaves out the dialing,
+// and the time the benchmark goroutine takes to be scheduled afterwards,
+// which on a single CPU can be milliseconds while the loop sits in
+// EpollWait.
+func BenchmarkAcceptStorm(b *testing.B) {
+	const storm = 1000
+	for _, busy := range []int{0, 200} {
+		for _, batch := range []int{1, 64} {
+			b.Run(fmt.Sprintf("busy=%d/batch=%d", busy, batch), func(b *testing.B) {
+				var elapsed time.Duration
+				var accepts, wakes uint64
+				payload := make([]byte, 64<<10)
+				for range b.N {
+					h := &countOpens{done: make(chan struct{})}
+					h.n.Store(int64(busy + storm))
+					l, err := Listen("127.0.0.1:0", h, Config{AcceptBatch: batch, ReadBufferSize: 64})
+					if err != nil {
+						b.Fatal(err)
+					}
+					sa := loopbackSockaddr(l.Addr())
+					fds := dialHeld(b, sa, busy)
+					for _, fd := range fds {
+						if n, err := syscall.Write(fd, payload); n != len(payload) {
+							b.Fatalf("wrote %d of %d bytes: %v", n, len(payload), err)
+						}
+					}
+					fds = append(fds, dialHeld(b, sa, storm)...)
+					done := make(chan error, 1)
+					start := time.Now()
+					go func() { done <- l.Run() }()
+					<-h.done
+					elapsed += h.last.Sub(start)
+					st := l.Stats()
+					accepts += st.Accepts
+					wakes += st.AcceptWakes
+					l.Close()
+					if err := <-done; err != nil {
+						b.Fatal(err)
+					}
+					resetAll(fds)
+				}
+				b.ReportMetric(float64(elapsed.Nanoseconds())/float64(b.N*(busy+storm)), "ns/conn")
+				b.ReportMetric(float64(accepts)/float64(wakes), "accepts/wake")
+			})
+		}
+	}
+}
@@ -0,0 +1,117 @@
+//go:build linux
+
+package reactor
+
+import (
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// Conn is a connection owned by a Loop. Its methods must only be called from
+// Handler callbacks, i.e. on the loop goroutine.
+type Conn struct {
+	fd     int
+	loop   *Loop
+	remote netip.AddrPort
+	out    []byte // bytes accepted by Write but not yet taken by the kernel
+	closed bool
+
+	state      connState
+	prev, next *Conn // links in the Loop list for state
+	priority   Priority
+	weight     int  // write scheduler share; 0 means 1
+	scheduled  bool // in the Loop's writeq
+
+	// Context is free for the Handler to attach per-connection state.
+	Context any
+}
+
+// Loop returns the loop that owns c, for Submit.
+func (c *Conn) Loop() *Loop { return c.loop }
+
+// Fd returns the underlying socket descriptor.
+func (c *Conn) Fd() int { return c.fd }
+
+// RemoteAddrPort returns the peer address.
+func (c *Conn) RemoteAddrPort() netip.AddrPort { return c.remote }
+
+// RemoteAddr returns the peer address as a net.Addr, which allocates.
+// The loop keeps the address as a value, so accepting a connection does
+// not allocate one for handlers that never ask.
+func (c *Conn) RemoteAddr() net.Addr { return net.TCPAddrFromAddrPort(c.remote) }
+
+// Write sends p or queues whatever the socket does not accept right now.
+// Queued bytes are flushed when epoll reports the socket as writable, so a
+// short write never loses data and never blocks the loop. With
+// Config.WriteQuantum set, Write sends at most one share of p and leaves
+// the rest to the write scheduler.
+func (c *Conn) Write(p []byte) error {
+	if c.closed || c.state == stateClosing {
+		return ErrClosed
+	}
+	if len(c.out) > 0 {
+		c.out = append(c.out, p...)
+		return nil
+	}
+	now := p
+	if c.loop.cfg.WriteQuantum > 0 {
+		now = p[:min(len(p), c.share())]
+	}
+	n, err := syscall.Write(c.fd, now)
+	if err != nil && err != syscall.EAGAIN {
+		return opError(opWrite, err)
+	}
+	if n < 0 {
+		n = 0
+	}
+	if n == len(p) {
+		return nil
+	}
+	c.out = append(c.out, p[n:]...)
+	c.loop.setState(c, stateActive)
+	if n == len(now) {
+		// The socket took all it was offered; the rest waits for its turn.
+		c.loop.schedule(c)
+		return nil
+	}
+	return c.loop.watchWrite(c, true)
+}
+
+// Buffered returns the number of bytes waiting to be written.
+func (c *Conn) Buffered() int { return len(c.out) }
+
+// Close closes the connection once all queued output has been written.
+// OnClose is called at that point, which may be before Close returns.
+func (c *Conn) Close() error {
+	if c.closed || c.state == stateClosing {
+		return ErrClosed
+	}
+	if len(c.out) > 0 {
+		c.loop.setState(c, stateClosing)
+		return nil
+	}
+	c.loop.closeConn(c, nil)
+	return nil
+}
+
+// flush writes queued output after an EPOLLOUT event.
+func (c *Conn) flush() error {
+	for len(c.out) > 0 {
+		n, err := syscall.Write(c.fd, c.out)
+		if err == syscall.EAGAIN {
+			return nil
+		}
+		if err != nil {
+			return opError(opWrite, err)
+		}
+		c.out = c.out[n:]
+	}
+	c.out = nil
+	if c.state == stateClosing {
+		c.loop.closeConn(c, nil)
+		return nil
+	}
+	c.loop.setState(c, stateIdle)
+	return c.loop.watchWrite(c, false)
+}
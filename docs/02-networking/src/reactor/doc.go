@@ -0,0 +1,17 @@
+// Package reactor is a small single-threaded epoll event loop for Linux.
+//
+// It is the library form of echo-epoll.go: one goroutine owns an epoll
+// instance, the listening socket and every accepted connection, and calls a
+// Handler for each readiness event. Nothing here is safe for use from other
+// goroutines unless stated otherwise; Loop.Submit is how other goroutines
+// get work, such as a write, run on the loop.
+//
+// The loop measures itself: Loop.Stats reports events per wake, the time
+// each iteration spends in handlers and how often it exceeds
+// Config.Budget, and Loop.Publish exports the same through expvar. A
+// watchdog goroutine flags an iteration that is still running past its
+// budget, which almost always means a handler made a blocking call, and
+// reports the callback and stack it is stuck in. Package reactortest turns
+// that into test failures and adds loopcheck, a source check for blocking
+// calls in handlers.
+package reactor
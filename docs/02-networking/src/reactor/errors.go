@@ -0,0 +1,69 @@
+//go:build linux
+
+package reactor
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Errors from a failed system call on a connection say which call failed:
+// errors.Is(err, ErrRead) tells a failed read from a failed write, and
+// errors.Is(err, syscall.ECONNRESET) and friends see through to the errno.
+// Handlers get them in OnClose and from Conn.Write.
+var (
+	ErrRead     = errors.New("reactor: read")
+	ErrWrite    = errors.New("reactor: write")
+	ErrEpollCtl = errors.New("reactor: epoll_ctl")
+)
+
+// The loop can fail the same way thousands of times per second, e.g. when a
+// load balancer resets every idle connection at once, so these errors are
+// never built per event: opError returns a sentinel from a table filled at
+// init, one per call and errno. Nothing outside the package can reach its
+// fields, so sharing it is as safe as sharing io.EOF. Building the error per
+// event with fmt.Errorf costs an allocation each time (see
+// BenchmarkErrorPath).
+type opErr struct {
+	op    error
+	errno syscall.Errno
+}
+
+func (e *opErr) Error() string { return e.op.Error() + ": " + e.errno.Error() }
+
+func (e *opErr) Unwrap() error { return e.errno }
+
+func (e *opErr) Is(target error) bool { return target == e.op }
+
+type op uint8
+
+const (
+	opRead op = iota
+	opWrite
+	opEpollCtl
+	numOps
+)
+
+var opSentinels = [numOps]error{ErrRead, ErrWrite, ErrEpollCtl}
+
+// maxErrno bounds the preallocated table; Linux errnos stay well below it.
+const maxErrno = 256
+
+var opErrors [numOps][maxErrno]opErr
+
+func init() {
+	for o := range opErrors {
+		for e := range opErrors[o] {
+			opErrors[o][e] = opErr{op: opSentinels[o], errno: syscall.Errno(e)}
+		}
+	}
+}
+
+// opError wraps a syscall error from o without allocating. Other errors and
+// nil pass through unchanged.
+func opError(o op, err error) error {
+	if errno, ok := err.(syscall.Errno); ok && errno < maxErrno {
+		return &opErrors[o][errno]
+	}
+	return err
+}
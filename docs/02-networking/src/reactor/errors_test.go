@@ -0,0 +1,96 @@
+//go:build linux
+
+package reactor
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOpError(t *testing.T) {
+	err := opError(opRead, syscall.ECONNRESET)
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("errors.Is(%v, ECONNRESET) = false", err)
+	}
+	if !errors.Is(err, ErrRead) || errors.Is(err, ErrWrite) {
+		t.Fatalf("errors.Is(%v, ErrRead) = false or errors.Is(%v, ErrWrite) = true", err, err)
+	}
+	if got, want := err.Error(), "reactor: read: "+syscall.ECONNRESET.Error(); got != want {
+		t.Fatalf("Error() = %q, want %q", got, want)
+	}
+	if opError(opWrite, nil) != nil {
+		t.Fatal("opError(nil) != nil")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { err = opError(opWrite, syscall.EPIPE) }); allocs != 0 {
+		t.Fatalf("opError allocates %v times per call", allocs)
+	}
+}
+
+// closeRecorder reports the error each connection was closed with.
+type closeRecorder struct {
+	echoHandler
+	closed chan error
+}
+
+func (h closeRecorder) OnClose(c *Conn, err error) { h.closed <- err }
+
+func TestResetReportsReadError(t *testing.T) {
+	h := closeRecorder{closed: make(chan error, 1)}
+	l := startLoop(t, h, Config{})
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unread data plus SO_LINGER{1, 0} turns Close into a RST.
+	conn.Write([]byte("x"))
+	conn.(*net.TCPConn).SetLinger(0)
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case err := <-h.closed:
+		if !errors.Is(err, ErrRead) || !errors.Is(err, syscall.ECONNRESET) {
+			t.Fatalf("OnClose err = %v, want ErrRead with ECONNRESET", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnClose not called")
+	}
+}
+
+// errSink keeps error values alive so the compiler cannot drop them.
+var errSink error
+
+// BenchmarkErrorPath compares ways of reporting a failed read with context
+// at event rates where the error path is hot.
+func BenchmarkErrorPath(b *testing.B) {
+	var errno error = syscall.ECONNRESET
+	b.Run("errno", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			errSink = errno
+		}
+	})
+	b.Run("table", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			errSink = opError(opRead, errno)
+		}
+	})
+	b.Run("struct", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			errSink = &opErr{op: ErrRead, errno: errno.(syscall.Errno)}
+		}
+	})
+	b.Run("fmt.Errorf", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			errSink = fmt.Errorf("reactor: read: %w", errno)
+		}
+	})
+}
@@ -0,0 +1,140 @@
+//go:build linux
+
+package reactor
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Group is a set of Loops that share one port through SO_REUSEPORT. Each
+// loop has its own listening socket, epoll instance and goroutine, and the
+// kernel picks the loop for every new connection by hashing its address,
+// so no loop ever touches another's connections and nothing is shared
+// between them on the hot path.
+//
+// The spread is per connection, not per byte: a few busy connections that
+// hash to the same loop leave it as loaded as a single loop would be, and
+// a loop that stops calling accept keeps its share of new connections
+// queued.
+//
+// The hash also ignores where a connection's packets are received. With
+// Config.Pin and Config.Steer the group moves each connection to the loop
+// on the CPU that receives them; see Steering.
+type Group struct {
+	loops []*Loop
+	byCPU []*Loop // the first loop pinned to each CPU
+	steer Steering
+	once  sync.Once
+}
+
+// ListenGroup binds n loops to addr with SO_REUSEPORT. newHandler is called
+// once per loop, so a handler's state is only ever used by the goroutine
+// of the loop it belongs to. If addr has port 0, the first loop picks the
+// port and the rest join it. Call Run to start serving.
+func ListenGroup(addr string, n int, newHandler func(i int) Handler, cfg Config) (*Group, error) {
+	cfg.ReusePort = true
+	if cfg.Steer == SteerIncomingCPU && !cfg.Pin {
+		return nil, errors.New("reactor: SteerIncomingCPU needs Pin")
+	}
+	var cpus []int
+	if cfg.Pin {
+		var err error
+		if cpus, err = allowedCPUs(); err != nil {
+			return nil, err
+		}
+	}
+	g := &Group{steer: cfg.Steer}
+	for i := range max(n, 1) {
+		l, err := Listen(addr, newHandler(i), cfg)
+		if err != nil {
+			for _, l := range g.loops {
+				l.release()
+			}
+			return nil, err
+		}
+		if i == 0 {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				l.release()
+				return nil, err
+			}
+			addr = net.JoinHostPort(host, strconv.Itoa(l.Addr().(*net.TCPAddr).Port))
+		}
+		g.loops = append(g.loops, l)
+		if cfg.Steer != SteerNone {
+			l.group = g
+		}
+		if cfg.Pin {
+			g.pin(l, cpus[i%len(cpus)])
+		}
+	}
+	return g, nil
+}
+
+// pin assigns l to cpu, to be pinned when it runs. Under SteerIncomingCPU
+// the listener of the first loop on cpu is marked with it, so that the
+// kernel's SO_REUSEPORT lookup prefers it for connections received there;
+// a kernel that does not honour the mark still gets them steered after
+// accept.
+func (g *Group) pin(l *Loop, cpu int) {
+	l.cpu = cpu
+	if cpu >= len(g.byCPU) {
+		g.byCPU = append(g.byCPU, make([]*Loop, cpu+1-len(g.byCPU))...)
+	}
+	if g.byCPU[cpu] != nil {
+		return
+	}
+	g.byCPU[cpu] = l
+	if g.steer == SteerIncomingCPU {
+		syscall.SetsockoptInt(l.lfd, syscall.SOL_SOCKET, unix.SO_INCOMING_CPU, cpu)
+	}
+}
+
+// Loops returns the group's loops, in the order newHandler saw them.
+func (g *Group) Loops() []*Loop { return g.loops }
+
+// Addr returns the listening address the loops share.
+func (g *Group) Addr() net.Addr { return g.loops[0].Addr() }
+
+// Run runs every loop on its own goroutine until Close is called or one of
+// them fails, which closes the rest. It returns the first error.
+func (g *Group) Run() error {
+	errs := make([]error, len(g.loops))
+	var wg sync.WaitGroup
+	for i, l := range g.loops {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errs[i] = l.Run(); errs[i] != nil {
+				g.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops every loop. It is safe to call from any goroutine.
+func (g *Group) Close() error {
+	err := ErrClosed
+	g.once.Do(func() {
+		err = nil
+		for _, l := range g.loops {
+			if e := l.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
@@ -0,0 +1,73 @@
+//go:build linux
+
+package reactor
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// loopHandler echoes and counts the connections its own loop opened. Its
+// counter is not atomic: only its loop's goroutine may touch it.
+type loopHandler struct {
+	echoHandler
+	opened int
+}
+
+func (h *loopHandler) OnOpen(c *Conn) { h.opened++ }
+
+func TestGroup(t *testing.T) {
+	const loops, conns = 4, 200
+	handlers := make([]*loopHandler, loops)
+	g, err := ListenGroup("127.0.0.1:0", loops, func(i int) Handler {
+		handlers[i] = &loopHandler{}
+		return handlers[i]
+	}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- g.Run() }()
+
+	for _, l := range g.Loops() {
+		if l.Addr().String() != g.Addr().String() {
+			t.Fatalf("loop bound %v, group %v", l.Addr(), g.Addr())
+		}
+	}
+	for range conns {
+		conn, err := net.Dial("tcp", g.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte("ping\n")); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := bufio.NewReader(conn).ReadString('\n'); err != nil || got != "ping\n" {
+			t.Fatalf("echo %q, %v", got, err)
+		}
+		conn.Close()
+	}
+
+	g.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	var total int
+	for i, l := range g.Loops() {
+		st := l.Stats()
+		t.Logf("loop %d: %d accepts", i, st.Accepts)
+		if st.Accepts == 0 || int(st.Accepts) != handlers[i].opened {
+			t.Errorf("loop %d: %d accepts, its handler opened %d", i, st.Accepts, handlers[i].opened)
+		}
+		total += handlers[i].opened
+	}
+	if total != conns {
+		t.Errorf("opened %d connections, dialed %d", total, conns)
+	}
+	if err := g.Close(); err != ErrClosed {
+		t.Errorf("second Close: %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+package reactor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/budget"
+)
+
+// TestLatencyBudget holds the event loop to a p99 budget under half its
+// measured capacity, so a change that delays writes or wakeups shows up
+// as a failing test.
+func TestLatencyBudget(t *testing.T) {
+	l := startLoop(t, echoHandler{}, Config{})
+	res := budget.Run(t, budget.Line(l.Addr().String(), 64), budget.Load{})
+	budget.Check(t, res, 20*time.Millisecond)
+}
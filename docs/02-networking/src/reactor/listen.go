@@ -0,0 +1,96 @@
+//go:build linux
+
+package reactor
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/netaddr"
+	"golang.org/x/sys/unix"
+)
+
+// maxListenerBacklog returns net.core.somaxconn, like the net package does.
+// syscall.SOMAXCONN is a stale 128 that overflows the accept queue under
+// any connection storm.
+func maxListenerBacklog() int {
+	data, err := os.ReadFile("/proc/sys/net/core/somaxconn")
+	if err != nil {
+		return syscall.SOMAXCONN
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || n <= 0 {
+		return syscall.SOMAXCONN
+	}
+	return n
+}
+
+// listenTCP creates a non-blocking listening socket without going through
+// the net package, so the fd is never registered with the Go runtime poller.
+// reusePort must be set before bind: the kernel only lets a socket join a
+// port whose sockets all had SO_REUSEPORT set, by the same user, when they
+// were bound.
+func listenTCP(addr string, backlog int, reusePort bool) (int, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return -1, err
+	}
+	family, sa := toSockaddr(tcpAddr)
+
+	fd, err := syscall.Socket(family, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if reusePort {
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+			syscall.Close(fd)
+			return -1, err
+		}
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	if err := syscall.Listen(fd, backlog); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+func toSockaddr(addr *net.TCPAddr) (int, syscall.Sockaddr) {
+	if ip4 := addr.IP.To4(); ip4 != nil || addr.IP == nil {
+		sa := &syscall.SockaddrInet4{Port: addr.Port}
+		copy(sa.Addr[:], ip4)
+		return syscall.AF_INET, sa
+	}
+	sa := &syscall.SockaddrInet6{Port: addr.Port}
+	copy(sa.Addr[:], addr.IP.To16())
+	return syscall.AF_INET6, sa
+}
+
+func fromSockaddr(sa syscall.Sockaddr) net.Addr {
+	ap, ok := netaddr.FromSockaddr(sa)
+	if !ok {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(ap)
+}
+
+// accept takes one pending connection off the listen queue.
+//
+// accept4 with SOCK_NONBLOCK|SOCK_CLOEXEC returns a socket that is ready for
+// the event loop in a single syscall. The net.Listener path echo-epoll.go
+// used to take needs Accept (which also registers the fd with the runtime
+// poller and sets TCP_NODELAY), then SyscallConn/Control and SetNonblock
+// (fcntl F_GETFL + F_SETFL) before the fd can be added to epoll.
+func accept(lfd int) (int, syscall.Sockaddr, error) {
+	return syscall.Accept4(lfd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+}
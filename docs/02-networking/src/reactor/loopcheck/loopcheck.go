@@ -0,0 +1,217 @@
+// Package loopcheck finds blocking calls in event loop handlers by reading
+// their source.
+//
+// Any method named OnOpen, OnData or OnClose is taken to be a
+// reactor.Handler callback, and its body is searched for calls that can
+// block: file system access, sleeping, dialing and DNS lookups, HTTP
+// requests, console output and channel operations outside a select with a
+// default case. The check is syntactic and only sees direct calls, so it
+// is fast and needs no type information; blocking buried in helper
+// functions is left to the reactor's runtime watchdog (Config.OnStall).
+//
+// A call that is known to be safe, e.g. a send on a channel with spare
+// capacity by construction, can be annotated with a //loopcheck:ok comment
+// on the same line.
+package loopcheck
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Finding is one blocking call in a handler.
+type Finding struct {
+	Pos    token.Position
+	Method string // handler method, e.g. "(*server).OnData"
+	Call   string // the offending call or operation
+	Reason string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s blocks the event loop (%s)", f.Pos, f.Method, f.Call, f.Reason)
+}
+
+// callbacks are the Handler methods that run on the loop goroutine.
+var callbacks = map[string]bool{"OnOpen": true, "OnData": true, "OnClose": true}
+
+// blocking maps import path and function name to why the call blocks.
+var blocking = map[string]map[string]string{
+	"os": reasons("file I/O",
+		"Open", "OpenFile", "Create", "ReadFile", "WriteFile", "ReadDir", "Stat", "Lstat",
+		"Remove", "RemoveAll", "Rename", "Mkdir", "MkdirAll", "Truncate", "Chmod", "Chown"),
+	"io/ioutil": reasons("file I/O", "ReadFile", "WriteFile", "ReadAll", "ReadDir", "TempFile", "TempDir"),
+	"io":        reasons("blocking read", "ReadAll", "ReadFull", "ReadAtLeast", "Copy", "CopyN", "CopyBuffer"),
+	"time":      reasons("sleep", "Sleep"),
+	"net": reasons("dial or DNS lookup",
+		"Dial", "DialTimeout", "DialTCP", "DialUDP", "DialUnix", "LookupHost", "LookupIP",
+		"LookupAddr", "LookupCNAME", "LookupMX", "LookupTXT", "LookupSRV", "LookupPort",
+		"ResolveTCPAddr", "ResolveUDPAddr", "ResolveIPAddr"),
+	"net/http": reasons("HTTP request", "Get", "Post", "Head", "PostForm"),
+	"fmt":      reasons("console write", "Print", "Printf", "Println"),
+	"log":      reasons("log write", "Print", "Printf", "Println", "Fatal", "Fatalf", "Fatalln", "Panic", "Panicf", "Panicln"),
+}
+
+func reasons(why string, names ...string) map[string]string {
+	m := make(map[string]string, len(names))
+	for _, n := range names {
+		m[n] = why
+	}
+	return m
+}
+
+// Dir checks the non-test Go files in dir.
+func Dir(dir string) ([]Finding, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	var out []Finding
+	for _, name := range names {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		f, err := parser.ParseFile(fset, name, src, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, File(fset, f)...)
+	}
+	return out, nil
+}
+
+// File checks one parsed file. It must have been parsed with comments for
+// //loopcheck:ok annotations to be honored.
+func File(fset *token.FileSet, f *ast.File) []Finding {
+	c := checker{fset: fset, imports: map[string]string{}, ok: map[int]bool{}}
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		name := path[strings.LastIndex(path, "/")+1:]
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		c.imports[name] = path
+	}
+	for _, cg := range f.Comments {
+		for _, cm := range cg.List {
+			if strings.HasPrefix(cm.Text, "//loopcheck:ok") {
+				c.ok[fset.Position(cm.Pos()).Line] = true
+			}
+		}
+	}
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil || fn.Body == nil || !callbacks[fn.Name.Name] {
+			continue
+		}
+		c.method = recvName(fn) + "." + fn.Name.Name
+		c.walk(fn.Body)
+	}
+	sort.Slice(c.out, func(i, j int) bool { return c.out[i].Pos.Offset < c.out[j].Pos.Offset })
+	return c.out
+}
+
+func recvName(fn *ast.FuncDecl) string {
+	switch t := fn.Recv.List[0].Type.(type) {
+	case *ast.StarExpr:
+		if id, ok := t.X.(*ast.Ident); ok {
+			return "(*" + id.Name + ")"
+		}
+	case *ast.Ident:
+		return t.Name
+	}
+	return "?"
+}
+
+type checker struct {
+	fset    *token.FileSet
+	imports map[string]string // local name -> import path
+	ok      map[int]bool      // lines annotated //loopcheck:ok
+	method  string
+	out     []Finding
+}
+
+func (c *checker) report(n ast.Node, call, reason string) {
+	pos := c.fset.Position(n.Pos())
+	if c.ok[pos.Line] {
+		return
+	}
+	c.out = append(c.out, Finding{Pos: pos, Method: c.method, Call: call, Reason: reason})
+}
+
+// walk inspects everything that runs on the loop goroutine: it skips go
+// statements and function literals that are not called on the spot.
+func (c *checker) walk(body ast.Node) {
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.GoStmt:
+			return false
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			if lit, ok := n.Fun.(*ast.FuncLit); ok {
+				c.walk(lit.Body)
+			}
+			c.call(n)
+		case *ast.SendStmt:
+			c.report(n, "channel send", "may wait for a receiver")
+		case *ast.UnaryExpr:
+			if n.Op == token.ARROW {
+				c.report(n, "channel receive", "may wait for a sender")
+			}
+		case *ast.SelectStmt:
+			c.selectStmt(n)
+			return false
+		}
+		return true
+	})
+}
+
+func (c *checker) call(call *ast.CallExpr) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return
+	}
+	path, ok := c.imports[pkg.Name]
+	if !ok || pkg.Obj != nil { // a local variable shadowing the import
+		return
+	}
+	if why, ok := blocking[path][sel.Sel.Name]; ok {
+		c.report(call, pkg.Name+"."+sel.Sel.Name, why)
+	}
+}
+
+// selectStmt reports a select that has no default case; the channel
+// operations in its cases are part of the select and are not reported on
+// their own.
+func (c *checker) selectStmt(s *ast.SelectStmt) {
+	hasDefault := false
+	for _, cl := range s.Body.List {
+		if cl.(*ast.CommClause).Comm == nil {
+			hasDefault = true
+		}
+	}
+	if !hasDefault {
+		c.report(s, "select without default", "may wait for a channel")
+	}
+	for _, cl := range s.Body.List {
+		for _, stmt := range cl.(*ast.CommClause).Body {
+			c.walk(stmt)
+		}
+	}
+}
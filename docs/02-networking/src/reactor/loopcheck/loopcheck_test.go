@@ -0,0 +1,44 @@
+package loopcheck
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	findings, err := Dir("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Collect "// want <call>" annotations by line.
+	want := map[int]string{}
+	f, err := os.Open(filepath.Join("testdata", "handlers.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		if _, call, ok := strings.Cut(sc.Text(), "// want "); ok {
+			want[line] = call
+		}
+	}
+
+	for _, fd := range findings {
+		if want[fd.Pos.Line] != fd.Call {
+			t.Errorf("unexpected finding %v", fd)
+			continue
+		}
+		delete(want, fd.Pos.Line)
+	}
+	for line, call := range want {
+		t.Errorf("handlers.go:%d: %s not reported", line, call)
+	}
+	if len(findings) > 0 && !strings.Contains(findings[0].String(), "(*server).OnOpen: log.Printf blocks the event loop") {
+		t.Errorf("finding format: %v", findings[0])
+	}
+}
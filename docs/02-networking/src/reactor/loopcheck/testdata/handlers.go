@@ -0,0 +1,61 @@
+// Package handlers holds reactor handlers for the loopcheck test. Lines
+// that must be reported end in a "want" comment naming the call.
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/reactor"
+)
+
+type server struct {
+	events chan string
+	cache  map[string][]byte
+}
+
+func (s *server) OnOpen(c *reactor.Conn) {
+	log.Printf("open %v", c.RemoteAddr()) // want log.Printf
+	s.events <- "open"                    // want channel send
+	s.events <- "counted"                 //loopcheck:ok buffered, never full
+}
+
+func (s *server) OnData(c *reactor.Conn, data []byte) {
+	b, err := os.ReadFile("/etc/motd") // want os.ReadFile
+	if err != nil {
+		time.Sleep(time.Millisecond) // want time.Sleep
+	}
+	c.Write(b)
+
+	select { // want select without default
+	case s.events <- "data":
+	case <-time.After(time.Second):
+	}
+	select {
+	case s.events <- "data":
+	default:
+	}
+
+	go func() {
+		http.Get("http://example.com") // runs on its own goroutine
+	}()
+	later := func() { os.Remove("/tmp/x") } // not called here
+	_ = later
+	func() {
+		fmt.Println("inline") // want fmt.Println
+	}()
+	defer func() { <-s.events }() // want channel receive
+}
+
+func (s *server) OnClose(c *reactor.Conn, err error) {
+	os := struct{ Create func(string) }{} // shadows the import
+	os.Create("fine")
+}
+
+// helper is not a callback; blocking here is only caught at run time.
+func (s *server) helper() {
+	os.ReadFile("/etc/hosts")
+}
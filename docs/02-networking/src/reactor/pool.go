@@ -0,0 +1,60 @@
+//go:build linux
+
+package reactor
+
+import "sync"
+
+// Pool is a fixed set of worker goroutines that handlers hand work to
+// when it is too slow to run on the loop.
+//
+// A loop runs every handler call to completion, one after another. That
+// is the cheapest way to serve a message: no queue, no other goroutine to
+// wake, the data still in cache. It is also why a handler must not block:
+// while one message takes a millisecond, every other connection of the
+// loop waits a millisecond. Handing the message to a pool frees the loop
+// at once, at the price of a channel send, a worker wakeup and a Submit
+// to bring the reply back: a few microseconds with idle cores, about 20
+// when the worker shares the loop's only one. Which is cheaper
+// depends on the service time, and on whether it is spent on a CPU the
+// loop could not use anyway or waiting on something else; BenchmarkProcessing
+// measures where the two cross.
+//
+// The workers take messages from one queue, so two messages of the same
+// connection can complete out of order. A protocol that allows several
+// requests in flight on a connection needs to number them, or the
+// handler has to stop reading until the reply is written.
+type Pool struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+}
+
+// NewPool starts workers goroutines, at least one, behind a queue of
+// queue jobs.
+func NewPool(workers, queue int) *Pool {
+	p := &Pool{jobs: make(chan func(), max(queue, 0))}
+	for range max(workers, 1) {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for fn := range p.jobs {
+				fn()
+			}
+		}()
+	}
+	return p
+}
+
+// Go runs fn on a worker. While every worker is busy and the queue is
+// full it blocks, which from a handler blocks the loop: the loop stops
+// taking events, and clients' data waits in the kernel. That is the
+// backpressure a bounded pool is for, but the watchdog counts it as a
+// stall when it outlasts the budget. fn must not touch a Conn except
+// through WriteAsync, or Submit to its loop.
+func (p *Pool) Go(fn func()) { p.jobs <- fn }
+
+// Close stops taking jobs and waits for the queued ones to finish. Go must
+// not be called after it.
+func (p *Pool) Close() {
+	close(p.jobs)
+	p.wg.Wait()
+}
@@ -0,0 +1,94 @@
+//go:build linux
+
+package reactor
+
+import "syscall"
+
+// Priority is a connection's service tier within one loop wake.
+type Priority uint8
+
+const (
+	// PriorityHigh is for latency-critical connections: health checks,
+	// control traffic, interactive clients.
+	PriorityHigh Priority = iota
+	// PriorityNormal is where every connection starts.
+	PriorityNormal
+	// PriorityLow is for bulk transfers that can wait a wake.
+	PriorityLow
+
+	numPriorities
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityNormal:
+		return "normal"
+	case PriorityLow:
+		return "low"
+	}
+	return "unknown"
+}
+
+// SetPriority moves c to tier p. EpollWait returns ready connections in no
+// useful order, so without tiers a latency-critical connection that wakes
+// together with a hundred bulk ones may wait for all their handlers. With
+// tiers, each wake serves high, then normal, then low; the order within a
+// tier is kept. Until some connection leaves PriorityNormal the loop skips
+// the sorting entirely.
+func (c *Conn) SetPriority(p Priority) {
+	if p >= numPriorities || p == c.priority || c.closed {
+		return
+	}
+	switch {
+	case c.priority == PriorityNormal:
+		c.loop.prioritized++
+	case p == PriorityNormal:
+		c.loop.prioritized--
+	}
+	c.priority = p
+}
+
+// Priority returns c's tier.
+func (c *Conn) Priority() Priority { return c.priority }
+
+// dispatch handles one event and reports whether the loop was closed.
+func (l *Loop) dispatch(ev *syscall.EpollEvent) bool {
+	switch fd := int(ev.Fd); fd {
+	case l.lfd:
+		l.accept()
+	case l.wakefd:
+		if l.closing.Load() {
+			l.shutdown()
+			return true
+		}
+		l.runTasks()
+	default:
+		l.serve(fd, ev.Events)
+	}
+	return false
+}
+
+// dispatchTiered handles the first n events tier by tier. The listener and
+// the wake eventfd count as normal.
+func (l *Loop) dispatchTiered(n int) bool {
+	for t := range l.tiers {
+		l.tiers[t] = l.tiers[t][:0]
+	}
+	for i := range n {
+		p := PriorityNormal
+		if fd := int(l.events[i].Fd); fd < len(l.conns) && l.conns[fd] != nil {
+			p = l.conns[fd].priority
+		}
+		l.tiers[p] = append(l.tiers[p], int32(i))
+	}
+	for _, tier := range l.tiers {
+		for _, i := range tier {
+			if l.dispatch(&l.events[i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
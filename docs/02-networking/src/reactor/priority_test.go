@@ -0,0 +1,145 @@
+//go:build linux
+
+package reactor
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mixedHandler echoes everything. Connections that start with 'H' are
+// latency critical; with tiered set they are moved to PriorityHigh. Other
+// connections are bulk and cost work per event, standing in for parsing or
+// compression.
+type mixedHandler struct {
+	tiered bool
+	work   time.Duration
+}
+
+func (mixedHandler) OnOpen(c *Conn) {}
+
+func (h mixedHandler) OnData(c *Conn, data []byte) {
+	if c.Context == nil {
+		c.Context = data[0] == 'H'
+		if c.Context.(bool) && h.tiered {
+			c.SetPriority(PriorityHigh)
+		}
+	}
+	if !c.Context.(bool) {
+		for end := time.Now().Add(h.work); time.Now().Before(end); {
+		}
+	}
+	c.Write(data)
+}
+
+func (mixedHandler) OnClose(c *Conn, err error) {}
+
+// prioritizedProbe reports the loop's count of prioritized connections
+// after each callback, from the loop goroutine that owns it.
+type prioritizedProbe struct {
+	mixedHandler
+	counts chan int
+}
+
+func (h prioritizedProbe) OnData(c *Conn, data []byte) {
+	h.mixedHandler.OnData(c, data)
+	h.counts <- c.loop.prioritized
+}
+
+func (h prioritizedProbe) OnClose(c *Conn, err error) { h.counts <- c.loop.prioritized }
+
+func TestSetPriority(t *testing.T) {
+	h := prioritizedProbe{mixedHandler{tiered: true}, make(chan int, 4)}
+	l := startLoop(t, h, Config{})
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("Hello"))
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-h.counts; got != 1 {
+		t.Fatalf("prioritized = %d after SetPriority, want 1", got)
+	}
+	conn.Close()
+	if got := <-h.counts; got != 0 {
+		t.Fatalf("prioritized = %d after close, want 0", got)
+	}
+}
+
+// BenchmarkPriority measures round trips of one latency-critical connection
+// while 64 bulk connections keep the loop busy, with events served in
+// kernel order (fifo) and by tier (tiered).
+func BenchmarkPriority(b *testing.B) {
+	const bulkConns = 64
+	for _, tiered := range []bool{false, true} {
+		name := "fifo"
+		if tiered {
+			name = "tiered"
+		}
+		b.Run(name, func(b *testing.B) {
+			// Give the loop its own P so client goroutines do not queue
+			// behind it in the Go scheduler.
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(max(2, runtime.GOMAXPROCS(0))))
+			l := startLoop(b, mixedHandler{tiered: tiered, work: 100 * time.Microsecond}, Config{})
+
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			for range bulkConns {
+				conn, err := net.Dial("tcp", l.Addr().String())
+				if err != nil {
+					b.Fatal(err)
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer conn.Close()
+					msg := make([]byte, 512)
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						if _, err := conn.Write(msg); err != nil {
+							return
+						}
+						if _, err := io.ReadFull(conn, msg); err != nil {
+							return
+						}
+					}
+				}()
+			}
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+			ping := []byte("H")
+			rtts := make([]time.Duration, 0, b.N)
+			for b.Loop() {
+				start := time.Now()
+				conn.Write(ping)
+				if _, err := io.ReadFull(conn, ping); err != nil {
+					b.Fatal(err)
+				}
+				rtts = append(rtts, time.Since(start))
+				time.Sleep(200 * time.Microsecond)
+			}
+			close(stop)
+			wg.Wait()
+
+			sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+			b.ReportMetric(float64(rtts[len(rtts)/2].Microseconds()), "p50-µs")
+			b.ReportMetric(float64(rtts[len(rtts)*99/100].Microseconds()), "p99-µs")
+		})
+	}
+}
@@ -0,0 +1,385 @@
+//go:build linux
+
+package reactor
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/bitset"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/netaddr"
+	"golang.org/x/sys/unix"
+)
+
+// ErrClosed is returned when operating on a closed connection or loop.
+var ErrClosed = errors.New("reactor: closed")
+
+// Handler receives connection events. All methods run on the loop goroutine
+// and must not block: a slow handler stalls every connection in the loop.
+type Handler interface {
+	// OnOpen is called once a connection has been accepted and registered.
+	OnOpen(c *Conn)
+	// OnData is called with the bytes read from c. data is only valid until
+	// OnData returns.
+	OnData(c *Conn, data []byte)
+	// OnClose is called once when c is closed; err is nil for a clean EOF or
+	// an explicit Close, and wraps ErrRead, ErrWrite or ErrEpollCtl when a
+	// system call failed.
+	OnClose(c *Conn, err error)
+}
+
+// Config tunes a Loop. Zero values select the defaults.
+type Config struct {
+	Backlog        int // listen backlog, defaults to net.core.somaxconn
+	MaxEvents      int // events returned per EpollWait, defaults to 256
+	ReadBufferSize int // shared read buffer, defaults to 64KiB
+
+	// AcceptBatch caps the connections accepted per listener readiness
+	// event; defaults to 64. The loop accepts until EAGAIN or the cap, so
+	// a connection storm costs one wake per batch instead of one per
+	// connection, while the cap keeps a storm from starving established
+	// connections of the loop. 1 accepts a single connection per wake.
+	AcceptBatch int
+
+	// ReusePort sets SO_REUSEPORT on the listening socket, so that several
+	// loops, in this process or others, can bind the same port and the
+	// kernel spreads new connections across their accept queues. Group
+	// sets it for every loop it starts.
+	ReusePort bool
+
+	// Budget is how long one loop iteration may take before it counts as
+	// an overrun; defaults to 10ms. A watchdog goroutine checks every
+	// Budget/2 and calls OnStall, if set, once per iteration that is still
+	// running past the budget, with the callback and stack it is stuck in.
+	// OnStall runs on the watchdog goroutine.
+	Budget  time.Duration
+	OnStall func(Stall)
+
+	// WriteQuantum turns on the write scheduler: each wake, every
+	// connection with output queued writes at most WriteQuantum bytes
+	// times its weight, in turn, so a bulk transfer cannot hold the loop
+	// while small responses wait. 0, the default, flushes a writable
+	// connection until the kernel stops taking bytes. See Conn.SetWeight.
+	WriteQuantum int
+
+	// Pin locks each loop of a Group to its own OS thread and that thread
+	// to one CPU: loop i to the i-th CPU the process may run on, wrapping
+	// around when there are more loops than CPUs. Steer picks a loop for
+	// each accepted connection other than the one that accepted it; see
+	// Steering. Both only apply to a Group.
+	Pin   bool
+	Steer Steering
+}
+
+func (c *Config) setDefaults() {
+	if c.Backlog <= 0 {
+		c.Backlog = maxListenerBacklog()
+	}
+	if c.MaxEvents <= 0 {
+		c.MaxEvents = 256
+	}
+	if c.ReadBufferSize <= 0 {
+		c.ReadBufferSize = 64 << 10
+	}
+	if c.AcceptBatch <= 0 {
+		c.AcceptBatch = 64
+	}
+	if c.Budget <= 0 {
+		c.Budget = 10 * time.Millisecond
+	}
+}
+
+// Loop is a single-threaded event loop serving one listening socket.
+type Loop struct {
+	cfg     Config
+	handler Handler
+	epfd    int
+	lfd     int
+	wakefd  int        // eventfd used by Close and Submit to interrupt EpollWait
+	wakeMu  sync.Mutex // guards tasks and orders writes to wakefd with release
+	tasks   []func()   // submitted, waiting for the loop
+	spare   []func()   // the last batch run, reused for the next
+	addr    net.Addr
+	events  []syscall.EpollEvent
+	readBuf []byte
+	conns   []*Conn // indexed by fd; a slice beats a map on this hot path
+	lists   [numStates]connList
+	closing atomic.Bool
+	stats   loopStats
+
+	prioritized int                    // connections outside PriorityNormal
+	tiers       [numPriorities][]int32 // event indexes per tier, reused every wake
+
+	stale  bitset.Set // fds closed during the current wake
+	nstale int        // closes since stale was last cleared
+
+	writeq []*Conn // connections waiting for a write round
+
+	cpu   int    // pinned CPU, or -1
+	group *Group // nil for a Loop on its own
+}
+
+// Listen binds addr and prepares a Loop. Call Run to start serving.
+func Listen(addr string, h Handler, cfg Config) (*Loop, error) {
+	cfg.setDefaults()
+	l := &Loop{cfg: cfg, handler: h, epfd: -1, lfd: -1, wakefd: -1, cpu: -1}
+	l.stats.epoch = time.Now()
+	for i := range l.lists {
+		l.lists[i].init()
+	}
+
+	var err error
+	if l.epfd, err = syscall.EpollCreate1(syscall.EPOLL_CLOEXEC); err != nil {
+		return nil, err
+	}
+	if l.lfd, err = listenTCP(addr, cfg.Backlog, cfg.ReusePort); err != nil {
+		l.release()
+		return nil, err
+	}
+	if l.wakefd, err = unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC); err != nil {
+		l.release()
+		return nil, err
+	}
+	for _, fd := range []int{l.lfd, l.wakefd} {
+		ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+		if err := syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+			l.release()
+			return nil, err
+		}
+	}
+	sa, err := syscall.Getsockname(l.lfd)
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	l.addr = fromSockaddr(sa)
+	l.events = make([]syscall.EpollEvent, cfg.MaxEvents)
+	for t := range l.tiers {
+		l.tiers[t] = make([]int32, 0, cfg.MaxEvents)
+	}
+	l.readBuf = make([]byte, cfg.ReadBufferSize)
+	return l, nil
+}
+
+// Addr returns the listening address.
+func (l *Loop) Addr() net.Addr { return l.addr }
+
+// Close stops Run and closes every connection. It is safe to call from any
+// goroutine.
+func (l *Loop) Close() error {
+	if !l.closing.CompareAndSwap(false, true) {
+		return ErrClosed
+	}
+	l.wakeMu.Lock()
+	defer l.wakeMu.Unlock()
+	if l.wakefd < 0 {
+		return nil // Run already returned
+	}
+	return l.wake()
+}
+
+// Run serves events until Close is called. It must be called at most once.
+func (l *Loop) Run() error {
+	defer l.release()
+	if err := l.pin(); err != nil {
+		return err
+	}
+	l.stats.goid = goid()
+	done := make(chan struct{})
+	defer close(done)
+	go l.watchdog(done)
+
+	for {
+		timeout := -1
+		if len(l.writeq) > 0 {
+			timeout = 0 // writes are pending; only look for new events
+		}
+		n, err := syscall.EpollWait(l.epfd, l.events, timeout)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return err
+		}
+		l.stats.begin(n)
+		if l.iterate(n) {
+			return nil
+		}
+	}
+}
+
+// iterate handles the n events EpollWait returned, then runs the write
+// scheduler, and reports whether the loop was closed. The iteration is
+// recorded however it ends, including the one that closes the loop.
+func (l *Loop) iterate(n int) (closed bool) {
+	defer l.stats.end(l.cfg.Budget)
+	l.clearStale()
+	if l.prioritized > 0 {
+		closed = l.dispatchTiered(n)
+	} else {
+		for i := 0; i < n && !closed; i++ {
+			closed = l.dispatch(&l.events[i])
+		}
+	}
+	if closed {
+		return true
+	}
+	if len(l.writeq) > 0 {
+		l.writeRound()
+	}
+	return false
+}
+
+// accept takes up to AcceptBatch queued connections per listener readiness
+// event. Anything left over keeps the level-triggered listener readable for
+// the next wake.
+func (l *Loop) accept() {
+	l.stats.acceptWakes.Add(1)
+	for range l.cfg.AcceptBatch {
+		fd, sa, err := accept(l.lfd)
+		if err == syscall.ECONNABORTED {
+			continue // the peer gave up while queued; try the next one
+		}
+		if err != nil {
+			// EAGAIN: the queue is empty. EMFILE/ENFILE: retried on the
+			// next wake.
+			return
+		}
+		remote, _ := netaddr.FromSockaddr(sa)
+		l.stats.accepts.Add(1)
+		if l.group != nil {
+			if to := l.group.target(l, fd); to != l {
+				l.handoff(to, fd, remote)
+				continue
+			}
+		}
+		if _, err := l.add(fd, remote); err != nil {
+			syscall.Close(fd)
+		}
+	}
+}
+
+func (l *Loop) setConn(fd int, c *Conn) {
+	if fd >= len(l.conns) {
+		grown := make([]*Conn, max(fd+1, 2*len(l.conns)))
+		copy(grown, l.conns)
+		l.conns = grown
+	}
+	l.conns[fd] = c
+}
+
+// clearStale forgets the fds closed during the previous wake. The events
+// just returned by EpollWait were collected after those closes, so they
+// are all current.
+func (l *Loop) clearStale() {
+	if l.nstale > 0 {
+		l.stale.Clear()
+		l.nstale = 0
+	}
+}
+
+func (l *Loop) serve(fd int, events uint32) {
+	if fd >= len(l.conns) || l.conns[fd] == nil {
+		return
+	}
+	if l.nstale > 0 && l.stale.Has(fd) {
+		// The connection this event was for is gone and accept has
+		// already handed its fd number to a new one.
+		return
+	}
+	c := l.conns[fd]
+
+	if events&syscall.EPOLLOUT != 0 {
+		if l.cfg.WriteQuantum > 0 {
+			// Writable again: stop watching and wait for the next round.
+			l.schedule(c)
+			if err := l.watchWrite(c, false); err != nil {
+				l.closeConn(c, err)
+				return
+			}
+		} else if err := c.flush(); err != nil {
+			l.closeConn(c, err)
+			return
+		}
+	}
+	if events&(syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+		l.read(c)
+	}
+}
+
+func (l *Loop) read(c *Conn) {
+	n, err := syscall.Read(c.fd, l.readBuf)
+	switch {
+	case err == syscall.EAGAIN:
+		return
+	case err != nil:
+		l.closeConn(c, opError(opRead, err))
+	case n == 0:
+		l.closeConn(c, nil)
+	case c.state == stateClosing:
+		// Draining output before close; input is discarded.
+	default:
+		if c.state == stateIdle {
+			l.setState(c, stateIdle)
+		}
+		prev := l.enter(cbData)
+		l.handler.OnData(c, l.readBuf[:n])
+		l.leave(prev)
+	}
+}
+
+// watchWrite toggles EPOLLOUT interest for c.
+func (l *Loop) watchWrite(c *Conn, on bool) error {
+	events := uint32(syscall.EPOLLIN | syscall.EPOLLRDHUP)
+	if on {
+		events |= syscall.EPOLLOUT
+	}
+	ev := syscall.EpollEvent{Events: events, Fd: int32(c.fd)}
+	return opError(opEpollCtl, syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_MOD, c.fd, &ev))
+}
+
+func (l *Loop) closeConn(c *Conn, err error) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	// Closing the fd removes it from the epoll set as long as it is not
+	// shared; EPOLL_CTL_DEL first keeps that true even after a fork.
+	syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, c.fd, nil)
+	syscall.Close(c.fd)
+	l.conns[c.fd] = nil
+	// The kernel reuses the lowest free fd, so a later accept in this
+	// wake may get c.fd back while events for c are still queued.
+	l.stale.Add(c.fd)
+	l.nstale++
+	l.lists[c.state].remove(c)
+	if c.priority != PriorityNormal {
+		l.prioritized--
+	}
+	prev := l.enter(cbClose)
+	l.handler.OnClose(c, err)
+	l.leave(prev)
+}
+
+func (l *Loop) shutdown() {
+	for s := range l.lists {
+		for c := l.lists[s].back(); c != nil; c = l.lists[s].back() {
+			l.closeConn(c, ErrClosed)
+		}
+	}
+}
+
+func (l *Loop) release() {
+	l.wakeMu.Lock()
+	defer l.wakeMu.Unlock()
+	for _, fd := range []int{l.lfd, l.wakefd, l.epfd} {
+		if fd >= 0 {
+			syscall.Close(fd)
+		}
+	}
+	l.lfd, l.wakefd, l.epfd = -1, -1, -1
+}
@@ -0,0 +1,47 @@
+//go:build linux
+
+// Package reactortest catches handlers that block the reactor's event loop,
+// statically and at run time:
+//
+//	func TestServer(t *testing.T) {
+//		reactortest.CheckSource(t, ".")
+//		cfg := reactor.Config{}
+//		reactortest.NoBlocking(t, &cfg, 5*time.Millisecond)
+//		l, err := reactor.Listen("127.0.0.1:0", newServer(), cfg)
+//		...
+//	}
+package reactortest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/reactor"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/reactor/loopcheck"
+)
+
+// NoBlocking sets cfg's budget and stall hook so that any loop iteration
+// still running after budget fails tb with the callback and stack it was
+// stuck in. Keep budget well above the slowest legitimate iteration: under
+// the race detector or on a loaded CI machine a busy loop iteration can
+// take milliseconds.
+func NoBlocking(tb testing.TB, cfg *reactor.Config, budget time.Duration) {
+	tb.Helper()
+	cfg.Budget = budget
+	cfg.OnStall = func(s reactor.Stall) {
+		tb.Errorf("event loop blocked for %v in %s (budget %v):\n%s", s.Running, s.Callback, budget, s.Stack)
+	}
+}
+
+// CheckSource fails tb for every blocking call that loopcheck finds in the
+// handler methods of the Go files in dir.
+func CheckSource(tb testing.TB, dir string) {
+	tb.Helper()
+	findings, err := loopcheck.Dir(dir)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, f := range findings {
+		tb.Error(f)
+	}
+}
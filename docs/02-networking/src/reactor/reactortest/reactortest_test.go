@@ -0,0 +1,110 @@
+//go:build linux
+
+package reactortest
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/reactor"
+)
+
+// recorder is a testing.TB that keeps failures instead of reporting them.
+type recorder struct {
+	testing.TB
+	mu   sync.Mutex
+	errs []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Error(args ...any) { r.Errorf("%s", fmt.Sprint(args...)) }
+
+func (r *recorder) failures() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.errs...)
+}
+
+// fileHandler does the classic mistake: file I/O on the loop.
+type fileHandler struct{ path string }
+
+func (fileHandler) OnOpen(c *reactor.Conn) {}
+func (h fileHandler) OnData(c *reactor.Conn, data []byte) {
+	// Stand in for a slow disk: a FIFO with no writer blocks open(2).
+	f, err := os.Open(h.path)
+	if err == nil {
+		f.Close()
+	}
+	c.Write(data)
+}
+func (fileHandler) OnClose(c *reactor.Conn, err error) {}
+
+func TestNoBlocking(t *testing.T) {
+	fifo := t.TempDir() + "/slow"
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Skip(err)
+	}
+
+	rec := &recorder{TB: t}
+	cfg := reactor.Config{}
+	NoBlocking(rec, &cfg, 20*time.Millisecond)
+	l, err := reactor.Listen("127.0.0.1:0", fileHandler{path: fifo}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- l.Run() }()
+	defer func() { l.Close(); <-done }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("x"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rec.failures()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Unblock the handler by opening the FIFO's write end.
+	if w, err := os.OpenFile(fifo, os.O_WRONLY, 0); err == nil {
+		w.Close()
+	}
+
+	got := rec.failures()
+	if len(got) != 1 {
+		t.Fatalf("got %d failures, want 1: %q", len(got), got)
+	}
+	if !strings.Contains(got[0], "in OnData") || !strings.Contains(got[0], "fileHandler.OnData") {
+		t.Fatalf("failure does not name the handler:\n%s", got[0])
+	}
+}
+
+func TestCheckSource(t *testing.T) {
+	rec := &recorder{TB: t}
+	CheckSource(rec, "../loopcheck/testdata")
+	if len(rec.failures()) == 0 {
+		t.Fatal("no findings for the loopcheck test handlers")
+	}
+	// This package's own handler is exactly what loopcheck looks for,
+	// but it lives in a _test.go file, which CheckSource skips.
+	rec = &recorder{TB: t}
+	CheckSource(rec, ".")
+	if got := rec.failures(); len(got) != 0 {
+		t.Fatalf("unexpected findings: %q", got)
+	}
+}
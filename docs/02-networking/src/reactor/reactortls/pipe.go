@@ -0,0 +1,137 @@
+//go:build linux
+
+package reactortls
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/reactor"
+)
+
+// errWouldBlock is what the pipe's Read returns when it has nothing left
+// after the handshake. crypto/tls treats a temporary net.Error as a
+// pause, not a failure: it keeps what it has read of the record and
+// returns the error, and the next Read picks up from there.
+var errWouldBlock net.Error = wouldBlock{}
+
+type wouldBlock struct{}
+
+func (wouldBlock) Error() string   { return "reactortls: no more input" }
+func (wouldBlock) Timeout() bool   { return true }
+func (wouldBlock) Temporary() bool { return true }
+
+// pipe is the net.Conn under tls.Conn. During the handshake the loop
+// feeds it and the handshake goroutine reads it, blocking while it is
+// empty, and writes through Conn.WriteAsync. Once the connection is
+// inline, all three happen on the loop and nothing blocks.
+type pipe struct {
+	raw *reactor.Conn
+
+	mu      sync.Mutex
+	cond    sync.Cond
+	in      []byte // ciphertext not yet read by tls.Conn
+	inline  bool
+	shut    bool // the loop closed the connection, or the handshake gave up
+	failure error
+}
+
+func newPipe(raw *reactor.Conn) *pipe {
+	p := &pipe{raw: raw}
+	p.cond.L = &p.mu
+	return p
+}
+
+// feed appends ciphertext the loop has read.
+func (p *pipe) feed(data []byte) {
+	p.mu.Lock()
+	p.in = append(p.in, data...)
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+func (p *pipe) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.in) == 0 && !p.shut && !p.inline {
+		p.cond.Wait()
+	}
+	if len(p.in) == 0 {
+		if p.shut {
+			return 0, io.EOF
+		}
+		return 0, errWouldBlock
+	}
+	n := copy(b, p.in)
+	// Shift rather than reslice, so the buffer's capacity is reused
+	// instead of growing with every record.
+	p.in = p.in[:copy(p.in, p.in[n:])]
+	return n, nil
+}
+
+func (p *pipe) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	inline, shut := p.inline, p.shut
+	p.mu.Unlock()
+	switch {
+	case shut:
+		return 0, net.ErrClosed
+	case inline:
+		if err := p.raw.Write(b); err != nil {
+			return 0, err
+		}
+	default:
+		if err := p.raw.WriteAsync(b); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Close is called by tls.Conn: from Conn.Close on the loop, or from the
+// handshake's context watcher when it times out.
+func (p *pipe) Close() error {
+	p.mu.Lock()
+	inline := p.inline
+	p.mu.Unlock()
+	if inline {
+		return p.raw.Close()
+	}
+	p.close() // the handshake goroutine gets io.EOF and reports back
+	return nil
+}
+
+// close wakes a blocked handshake for good.
+func (p *pipe) close() {
+	p.mu.Lock()
+	p.shut = true
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+func (p *pipe) setInline() {
+	p.mu.Lock()
+	p.inline = true
+	p.mu.Unlock()
+}
+
+// fail records why the connection is being closed, for OnClose.
+func (p *pipe) fail(err error) {
+	p.mu.Lock()
+	p.failure = err
+	p.mu.Unlock()
+}
+
+func (p *pipe) err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.failure
+}
+
+func (p *pipe) LocalAddr() net.Addr              { return nil }
+func (p *pipe) RemoteAddr() net.Addr             { return p.raw.RemoteAddr() }
+func (p *pipe) SetDeadline(time.Time) error      { return nil }
+func (p *pipe) SetReadDeadline(time.Time) error  { return nil }
+func (p *pipe) SetWriteDeadline(time.Time) error { return nil }
@@ -0,0 +1,217 @@
+//go:build linux
+
+// Package reactortls serves TLS from a reactor.Loop.
+//
+// crypto/tls is written for blocking connections: tls.Conn reads from a
+// net.Conn until it has a whole record and writes until the record is
+// out. A readiness loop cannot block, so the package puts a pipe in
+// between, the way OpenSSL users put a memory BIO: the loop feeds the
+// ciphertext it reads into the pipe, tls.Conn reads records out of it,
+// and whatever tls.Conn writes goes to the socket through reactor.Conn.
+//
+// After the handshake the records are processed inline, on the loop.
+// When the pipe runs dry in the middle of a record, its Read returns a
+// temporary net.Error; tls.Conn keeps the partial record and returns, and
+// the next OnData carries on where it stopped. The handshake cannot work
+// that way, because tls.Conn remembers any handshake error for good, so
+// each handshake runs on a goroutine of its own that blocks on the pipe
+// while the loop fills it. That also keeps the handshake's public key
+// operations, hundreds of microseconds each, from stalling every other
+// connection in the loop. Once the handshake is done the goroutine hands
+// the connection back with Loop.Submit and exits.
+package reactortls
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/reactor"
+)
+
+// Handler receives the plaintext side of TLS connections. Like
+// reactor.Handler, its methods run on the loop goroutine and must not
+// block.
+type Handler interface {
+	// OnOpen is called once the handshake has completed.
+	OnOpen(c *Conn)
+	// OnData is called with decrypted bytes, valid until OnData returns.
+	OnData(c *Conn, data []byte)
+	// OnClose is called once for every connection OnOpen saw; err is nil
+	// for a close_notify, a clean EOF or an explicit Close.
+	OnClose(c *Conn, err error)
+}
+
+// Config configures a Server. Zero values select the defaults.
+type Config struct {
+	// HandshakeTimeout bounds a handshake; defaults to 10s. A client that
+	// connects and sends nothing holds a goroutine until then.
+	HandshakeTimeout time.Duration
+}
+
+// Server is a reactor.Handler that terminates TLS and passes the
+// plaintext on to a Handler. It holds a buffer used by one loop at a
+// time, so a reactor.Group needs one Server per loop.
+type Server struct {
+	tls *tls.Config
+	cfg Config
+	h   Handler
+	buf []byte // plaintext, shared by the loop's connections
+
+	handshakes, failed atomic.Uint64
+	active             atomic.Int64
+}
+
+// Stats count a Server's handshakes.
+type Stats struct {
+	Handshakes      uint64 // completed
+	HandshakeErrors uint64 // failed, timed out or cut off by the client
+	Active          int    // running now, each on a goroutine
+}
+
+// NewServer returns a Server that terminates TLS with tlsCfg and passes
+// the plaintext to h.
+func NewServer(tlsCfg *tls.Config, h Handler, cfg Config) *Server {
+	if cfg.HandshakeTimeout <= 0 {
+		cfg.HandshakeTimeout = 10 * time.Second
+	}
+	return &Server{tls: tlsCfg, cfg: cfg, h: h, buf: make([]byte, 16<<10)}
+}
+
+// Stats returns s's counters. It is safe to call from any goroutine.
+func (s *Server) Stats() Stats {
+	return Stats{
+		Handshakes:      s.handshakes.Load(),
+		HandshakeErrors: s.failed.Load(),
+		Active:          int(s.active.Load()),
+	}
+}
+
+// Conn is the plaintext side of one TLS connection. Its methods, like
+// reactor.Conn's, must only be called on the loop goroutine.
+type Conn struct {
+	raw  *reactor.Conn
+	tls  *tls.Conn
+	pipe *pipe
+	srv  *Server
+	open bool // OnOpen has been called and OnClose has not
+	done bool // Close has been called, or the loop closed the connection
+
+	// Context is free for the Handler to attach per-connection state.
+	Context any
+}
+
+// Raw returns the underlying connection, for its address and stats. Its
+// Write would bypass TLS.
+func (c *Conn) Raw() *reactor.Conn { return c.raw }
+
+// ConnectionState returns the negotiated parameters.
+func (c *Conn) ConnectionState() tls.ConnectionState { return c.tls.ConnectionState() }
+
+// Write encrypts p and sends it, or queues what the socket does not take
+// now, like reactor.Conn.Write. Each Write is at least one record.
+func (c *Conn) Write(p []byte) error {
+	_, err := c.tls.Write(p)
+	return err
+}
+
+// Close sends close_notify and closes the connection once its output has
+// been written. Input after Close is discarded.
+func (c *Conn) Close() error {
+	if c.done {
+		return reactor.ErrClosed
+	}
+	c.done = true
+	return c.tls.Close()
+}
+
+// OnOpen starts the handshake.
+func (s *Server) OnOpen(raw *reactor.Conn) {
+	p := newPipe(raw)
+	c := &Conn{raw: raw, pipe: p, srv: s}
+	c.tls = tls.Server(p, s.tls)
+	raw.Context = c
+	s.active.Add(1)
+	go c.handshake()
+}
+
+// handshake runs on its own goroutine, and hands the result back to the
+// loop.
+func (c *Conn) handshake() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.srv.cfg.HandshakeTimeout)
+	err := c.tls.HandshakeContext(ctx)
+	cancel()
+	c.srv.active.Add(-1)
+	if err != nil {
+		c.srv.failed.Add(1)
+	} else {
+		c.srv.handshakes.Add(1)
+	}
+	c.raw.Loop().Submit(func() { c.handshakeDone(err) })
+}
+
+// handshakeDone switches c to inline processing, on the loop.
+func (c *Conn) handshakeDone(err error) {
+	if c.done {
+		return // the loop closed the connection meanwhile
+	}
+	if err != nil {
+		// tls.Conn has queued an alert, if it had one to send.
+		c.raw.Close()
+		return
+	}
+	c.pipe.setInline()
+	c.open = true
+	c.srv.h.OnOpen(c)
+	// Application data that arrived with the client's Finished.
+	c.srv.decrypt(c)
+}
+
+// OnData feeds ciphertext to the pipe and, after the handshake, decrypts
+// every whole record it completes.
+func (s *Server) OnData(raw *reactor.Conn, data []byte) {
+	c := raw.Context.(*Conn)
+	c.pipe.feed(data)
+	if c.open {
+		s.decrypt(c)
+	}
+}
+
+func (s *Server) decrypt(c *Conn) {
+	for !c.done {
+		n, err := c.tls.Read(s.buf)
+		if n > 0 {
+			s.h.OnData(c, s.buf[:n])
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, errWouldBlock):
+			return
+		case err == io.EOF: // close_notify
+			c.raw.Close()
+			return
+		default:
+			c.pipe.fail(err)
+			c.raw.Close()
+			return
+		}
+	}
+}
+
+// OnClose tells a handshake still in progress to give up, and the Handler
+// that the connection is gone.
+func (s *Server) OnClose(raw *reactor.Conn, err error) {
+	c := raw.Context.(*Conn)
+	if err == nil {
+		err = c.pipe.err()
+	}
+	c.done = true
+	c.pipe.close()
+	if c.open {
+		c.open = false
+		s.h.OnClose(c, err)
+	}
+}
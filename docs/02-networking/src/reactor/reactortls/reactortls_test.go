@@ -0,0 +1,202 @@
+//go:build linux
+
+package reactortls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/internal/testcert"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/reactor"
+)
+
+// echo writes back what it receives and reports closes.
+type echo struct{ closed chan error }
+
+func (echo) OnOpen(c *Conn)              {}
+func (echo) OnData(c *Conn, data []byte) { c.Write(data) }
+func (e echo) OnClose(c *Conn, err error) {
+	if e.closed != nil {
+		e.closed <- err
+	}
+}
+
+// serve runs a TLS echo loop for the duration of the test and returns
+// its address and the client configuration that trusts it.
+func serve(tb testing.TB, cfg Config, closed chan error) (*Server, string, *tls.Config) {
+	tb.Helper()
+	cert, _ := testcert.Must(tb)
+	srv := NewServer(&tls.Config{Certificates: []tls.Certificate{cert}}, echo{closed}, cfg)
+	l, err := reactor.Listen("127.0.0.1:0", srv, reactor.Config{})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- l.Run() }()
+	tb.Cleanup(func() {
+		l.Close()
+		<-done
+	})
+	roots := x509.NewCertPool()
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	roots.AddCert(leaf)
+	return srv, l.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "localhost"}
+}
+
+func TestEcho(t *testing.T) {
+	closed := make(chan error, 1)
+	srv, addr, client := serve(t, Config{}, closed)
+	conn, err := tls.Dial("tcp", addr, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// 100KiB spans several records, and TCP splits those anywhere, so the
+	// loop sees records cut in the middle.
+	for _, size := range []int{5, 100 << 10} {
+		msg := bytes.Repeat([]byte("0123456789"), size/10+1)[:size]
+		go conn.Write(msg)
+		got := make([]byte, size)
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("%d-byte echo differs", size)
+		}
+	}
+	if v := conn.ConnectionState().Version; v != tls.VersionTLS13 {
+		t.Errorf("negotiated %x", v)
+	}
+
+	conn.Close() // close_notify
+	if err := <-closed; err != nil {
+		t.Errorf("OnClose: %v", err)
+	}
+	if st := srv.Stats(); st.Handshakes != 1 || st.HandshakeErrors != 0 || st.Active != 0 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestHandshakeFailure(t *testing.T) {
+	srv, addr, _ := serve(t, Config{HandshakeTimeout: 100 * time.Millisecond}, nil)
+	for _, hello := range []string{"GET / HTTP/1.1\r\n\r\n", ""} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write([]byte(hello))
+		// The server answers garbage with an alert and silence with a
+		// timeout, and closes either way.
+		if _, err := io.ReadAll(conn); err != nil {
+			t.Errorf("after %q: %v", hello, err)
+		}
+		conn.Close()
+	}
+	if st := srv.Stats(); st.HandshakeErrors != 2 || st.Handshakes != 0 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+// goroutineEcho is the blocking equivalent: crypto/tls on net.Conn, one
+// goroutine per connection.
+func goroutineEcho(tb testing.TB) (string, *tls.Config) {
+	cert, _ := testcert.Must(tb)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 16<<10)
+				for {
+					n, err := c.Read(buf)
+					if err != nil {
+						return
+					}
+					if _, err := c.Write(buf[:n]); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	roots := x509.NewCertPool()
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	roots.AddCert(leaf)
+	return ln.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "localhost"}
+}
+
+func servers(b *testing.B) map[string]func() (string, *tls.Config) {
+	return map[string]func() (string, *tls.Config){
+		"reactor": func() (string, *tls.Config) {
+			_, addr, cfg := serve(b, Config{}, nil)
+			return addr, cfg
+		},
+		"goroutine": func() (string, *tls.Config) { return goroutineEcho(b) },
+	}
+}
+
+// BenchmarkEcho times round trips of size bytes over one established TLS
+// connection.
+func BenchmarkEcho(b *testing.B) {
+	for _, name := range []string{"goroutine", "reactor"} {
+		for _, size := range []int{64, 16 << 10} {
+			b.Run(fmt.Sprintf("%s/size=%d", name, size), func(b *testing.B) {
+				addr, cfg := servers(b)[name]()
+				conn, err := tls.Dial("tcp", addr, cfg)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer conn.Close()
+				msg := make([]byte, size)
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				for b.Loop() {
+					if _, err := conn.Write(msg); err != nil {
+						b.Fatal(err)
+					}
+					if _, err := io.ReadFull(conn, msg); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkHandshake times a full TLS 1.3 handshake and one round trip on
+// a new connection, without resumption.
+func BenchmarkHandshake(b *testing.B) {
+	for _, name := range []string{"goroutine", "reactor"} {
+		b.Run(name, func(b *testing.B) {
+			addr, cfg := servers(b)[name]()
+			msg := []byte("x")
+			for b.Loop() {
+				conn, err := tls.Dial("tcp", addr, cfg)
+				if err != nil {
+					b.Fatal(err)
+				}
+				conn.Write(msg)
+				if _, err := io.ReadFull(conn, msg); err != nil {
+					b.Fatal(err)
+				}
+				conn.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+//go:build linux
+
+package reactor
+
+import "syscall"
+
+// The write scheduler shares the loop's writes between connections when
+// Config.WriteQuantum is set. Without it, a connection that becomes
+// writable is flushed until the kernel says EAGAIN, and the kernel's send
+// buffer for a fast local peer can take megabytes: while one bulk
+// transfer fills it, every small response due in the same wake waits.
+//
+// With a quantum, a connection writes at most quantum×weight bytes per
+// round, and the rest waits in writeq for the next one. It is deficit
+// round robin with bytes for packets: since a write can be cut anywhere,
+// no connection ever carries a deficit into the next round. The loop
+// runs one round per wake and polls instead of blocking while writeq is
+// not empty, so a bulk transfer proceeds between wakes at the speed the
+// loop has left over, not in one go.
+
+// SetWeight sets c's share of the write scheduler: each round c may write
+// w times Config.WriteQuantum bytes. The default is 1. It has no effect
+// without a quantum.
+func (c *Conn) SetWeight(w int) {
+	c.weight = max(w, 1)
+}
+
+// Weight returns c's write scheduler weight.
+func (c *Conn) Weight() int { return max(c.weight, 1) }
+
+// share is what c may write in one round.
+func (c *Conn) share() int { return c.loop.cfg.WriteQuantum * c.Weight() }
+
+// schedule puts c, which has output queued and a socket that took the
+// last write in full, in line for the next round.
+func (l *Loop) schedule(c *Conn) {
+	if !c.scheduled {
+		c.scheduled = true
+		l.writeq = append(l.writeq, c)
+	}
+}
+
+// writeRound gives every connection in writeq one share of writes. A
+// connection that empties its queue leaves writeq; one the kernel stops
+// taking leaves it to wait for EPOLLOUT; the rest stay, in order, for the
+// next round.
+func (l *Loop) writeRound() {
+	l.stats.writeRounds.Add(1)
+	// A handler called from here, OnClose, may schedule other connections;
+	// they are appended past n and join from the next round.
+	n, keep := len(l.writeq), 0
+	for i := range n {
+		c := l.writeq[i]
+		l.writeq[i] = nil
+		if c.closed {
+			continue
+		}
+		more, err := c.writeShare()
+		switch {
+		case err != nil:
+			c.scheduled = false
+			l.closeConn(c, err)
+		case more:
+			l.stats.writeYields.Add(1)
+			l.writeq[keep] = c
+			keep++
+		default:
+			c.scheduled = false
+		}
+	}
+	keep += copy(l.writeq[keep:], l.writeq[n:])
+	clear(l.writeq[keep:])
+	l.writeq = l.writeq[:keep]
+}
+
+// writeShare writes up to c's share of its queued output and reports
+// whether c should stay in writeq. When the output is gone it finishes
+// what flush would: a pending Close, or the move back to idle.
+func (c *Conn) writeShare() (bool, error) {
+	n, err := syscall.Write(c.fd, c.out[:min(len(c.out), c.share())])
+	if err == syscall.EAGAIN {
+		return false, c.loop.watchWrite(c, true)
+	}
+	if err != nil {
+		return false, opError(opWrite, err)
+	}
+	c.out = c.out[n:]
+	if len(c.out) > 0 {
+		return true, nil
+	}
+	c.out = nil
+	if c.state == stateClosing {
+		c.loop.closeConn(c, nil)
+		return false, nil
+	}
+	c.loop.setState(c, stateIdle)
+	return false, nil
+}
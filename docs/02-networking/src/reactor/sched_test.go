@@ -0,0 +1,163 @@
+//go:build linux
+
+package reactor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// downloadHandler answers a request starting with 'B' with a bulk
+// response of size bytes, and echoes anything else. With closeAfter set it
+// closes a connection right after queueing the bulk response.
+type downloadHandler struct {
+	resp       []byte
+	weight     int
+	closeAfter bool
+}
+
+func (downloadHandler) OnOpen(c *Conn) {}
+
+func (h downloadHandler) OnData(c *Conn, data []byte) {
+	if data[0] != 'B' {
+		c.Write(data)
+		return
+	}
+	if h.weight > 0 {
+		c.SetWeight(h.weight)
+	}
+	c.Write(h.resp)
+	if h.closeAfter {
+		c.Close()
+	}
+}
+
+func (downloadHandler) OnClose(c *Conn, err error) {}
+
+func payload(n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(i * 7)
+	}
+	return p
+}
+
+func TestWriteScheduler(t *testing.T) {
+	resp := payload(8 << 20)
+	for _, closeAfter := range []bool{false, true} {
+		t.Run(fmt.Sprintf("close=%v", closeAfter), func(t *testing.T) {
+			l := startLoop(t, downloadHandler{resp: resp, weight: 2, closeAfter: closeAfter}, Config{WriteQuantum: 16 << 10})
+			var wg sync.WaitGroup
+			for range 3 {
+				conn, err := net.Dial("tcp", l.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					conn.Write([]byte("B"))
+					got := make([]byte, len(resp))
+					if _, err := io.ReadFull(conn, got); err != nil {
+						t.Error(err)
+						return
+					}
+					if !bytes.Equal(got, resp) {
+						t.Error("response corrupted")
+					}
+					if !closeAfter {
+						return
+					}
+					if n, err := conn.Read(got[:1]); err != io.EOF {
+						t.Errorf("read %d bytes, %v after the response; want EOF", n, err)
+					}
+				}()
+			}
+			wg.Wait()
+			if st := l.Stats(); st.WriteRounds == 0 || st.WriteYields == 0 {
+				t.Errorf("scheduler unused: %d rounds, %d yields", st.WriteRounds, st.WriteYields)
+			}
+		})
+	}
+}
+
+// BenchmarkWriteFairness measures round trips of one interactive
+// connection while 8 others download 4MiB responses as fast as they can
+// read them, with writes flushed until EAGAIN (quantum=0) and shared out
+// by the write scheduler. bulk-MB/s is what the downloads got meanwhile.
+func BenchmarkWriteFairness(b *testing.B) {
+	const bulkConns = 8
+	resp := payload(4 << 20)
+	for _, quantum := range []int{0, 16 << 10, 64 << 10} {
+		b.Run(fmt.Sprintf("quantum=%d", quantum), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(max(2, runtime.GOMAXPROCS(0))))
+			l := startLoop(b, downloadHandler{resp: resp}, Config{WriteQuantum: quantum})
+
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			var downloaded atomic.Int64
+			for range bulkConns {
+				conn, err := net.Dial("tcp", l.Addr().String())
+				if err != nil {
+					b.Fatal(err)
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer conn.Close()
+					buf := make([]byte, len(resp))
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						if _, err := conn.Write([]byte("B")); err != nil {
+							return
+						}
+						if _, err := io.ReadFull(conn, buf); err != nil {
+							return
+						}
+						downloaded.Add(int64(len(buf)))
+					}
+				}()
+			}
+
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+			ping := []byte("H")
+			rtts := make([]time.Duration, 0, b.N)
+			begin := time.Now()
+			for b.Loop() {
+				start := time.Now()
+				conn.Write(ping)
+				if _, err := io.ReadFull(conn, ping); err != nil {
+					b.Fatal(err)
+				}
+				rtts = append(rtts, time.Since(start))
+				time.Sleep(200 * time.Microsecond)
+			}
+			elapsed := time.Since(begin)
+			close(stop)
+			wg.Wait()
+
+			sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+			b.ReportMetric(float64(rtts[len(rtts)/2].Microseconds()), "p50-µs")
+			b.ReportMetric(float64(rtts[len(rtts)*99/100].Microseconds()), "p99-µs")
+			b.ReportMetric(float64(downloaded.Load())/elapsed.Seconds()/1e6, "bulk-MB/s")
+		})
+	}
+}
@@ -0,0 +1,71 @@
+//go:build linux
+
+package reactor
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Stall describes a loop iteration that the watchdog caught running past
+// Config.Budget.
+type Stall struct {
+	Running  time.Duration // how long the iteration had been running
+	Callback string        // Handler method or "Submit" running at the time, or "" for the loop itself
+	Stack    []byte        // the loop goroutine's stack when the watchdog looked
+}
+
+// callback identifies the Handler method the loop is inside.
+type callback uint32
+
+const (
+	cbNone callback = iota
+	cbOpen
+	cbData
+	cbClose
+	cbSubmit
+)
+
+var callbackNames = [...]string{"", "OnOpen", "OnData", "OnClose", "Submit"}
+
+// enter and leave bracket a Handler call so the watchdog can say which
+// callback a stall happened in. Callbacks nest when a handler closes a
+// connection, so leave restores what enter replaced.
+func (l *Loop) enter(cb callback) callback {
+	return callback(l.stats.callback.Swap(uint32(cb)))
+}
+
+func (l *Loop) leave(prev callback) { l.stats.callback.Store(uint32(prev)) }
+
+// goid returns the calling goroutine's ID from its stack header,
+// "goroutine 123 [running]:".
+func goid() uint64 {
+	var buf [64]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	id, _, _ := bytes.Cut(b, []byte(" "))
+	n, _ := strconv.ParseUint(string(id), 10, 64)
+	return n
+}
+
+// goroutineStack returns the stack of goroutine id. It dumps every
+// goroutine, which stops the world, so it is only called once per stall.
+func goroutineStack(id uint64) []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	prefix := []byte("goroutine " + strconv.FormatUint(id, 10) + " ")
+	for g := range bytes.SplitSeq(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(g, prefix) {
+			return g
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+//go:build linux
+
+package reactor
+
+// connState tracks where a connection is in its lifecycle. Each state owns
+// an intrusive list, so moving a connection between states is four pointer
+// writes with no allocation and no hashing, no matter how many connections
+// the loop holds.
+type connState uint8
+
+const (
+	// stateIdle: nothing queued for output, waiting for the peer. The list
+	// is kept in most-recently-active order, so the tail is the
+	// connection that has been quiet the longest.
+	stateIdle connState = iota
+	// stateActive: output is queued and the loop is waiting for EPOLLOUT.
+	stateActive
+	// stateClosing: Close was called with output still queued; the
+	// connection is closed as soon as the queue drains.
+	stateClosing
+
+	numStates
+)
+
+func (s connState) String() string {
+	switch s {
+	case stateIdle:
+		return "idle"
+	case stateActive:
+		return "active"
+	case stateClosing:
+		return "closing"
+	}
+	return "unknown"
+}
+
+// connList is a doubly-linked list threaded through Conn.prev/next. The
+// zero value is not usable; call init first.
+type connList struct {
+	root Conn // sentinel, only prev and next are used
+	len  int
+}
+
+func (ls *connList) init() {
+	ls.root.next = &ls.root
+	ls.root.prev = &ls.root
+	ls.len = 0
+}
+
+func (ls *connList) pushFront(c *Conn) {
+	c.prev = &ls.root
+	c.next = ls.root.next
+	ls.root.next.prev = c
+	ls.root.next = c
+	ls.len++
+}
+
+func (ls *connList) remove(c *Conn) {
+	c.prev.next = c.next
+	c.next.prev = c.prev
+	c.prev, c.next = nil, nil
+	ls.len--
+}
+
+// back returns the least recently pushed connection, or nil.
+func (ls *connList) back() *Conn {
+	if ls.len == 0 {
+		return nil
+	}
+	return ls.root.prev
+}
+
+// setState moves c to the front of the list for s. Calling it with the
+// current state just marks c as most recently active.
+func (l *Loop) setState(c *Conn, s connState) {
+	l.lists[c.state].remove(c)
+	c.state = s
+	l.lists[s].pushFront(c)
+}
+
+// ConnCounts reports how many connections are idle, waiting for their
+// output to drain, and closing.
+func (l *Loop) ConnCounts() (idle, active, closing int) {
+	return l.lists[stateIdle].len, l.lists[stateActive].len, l.lists[stateClosing].len
+}
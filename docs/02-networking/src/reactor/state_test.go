@@ -0,0 +1,94 @@
+//go:build linux
+
+package reactor
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/pow2"
+)
+
+const (
+	stateBenchConns = 100_000
+	stateBenchOps   = 1 << 16 // transitions replayed in a loop; a power of two
+)
+
+func TestConnList(t *testing.T) {
+	var ls connList
+	ls.init()
+	conns := make([]Conn, 3)
+	for i := range conns {
+		ls.pushFront(&conns[i])
+	}
+	if ls.len != 3 || ls.back() != &conns[0] {
+		t.Fatalf("len=%d back=%p, want 3 and %p", ls.len, ls.back(), &conns[0])
+	}
+	ls.remove(&conns[0])
+	ls.remove(&conns[2])
+	if ls.len != 1 || ls.back() != &conns[1] {
+		t.Fatalf("len=%d back=%p, want 1 and %p", ls.len, ls.back(), &conns[1])
+	}
+	ls.remove(&conns[1])
+	if ls.back() != nil {
+		t.Fatal("list should be empty")
+	}
+}
+
+// transitions returns a fixed random sequence of (conn index, new state)
+// pairs so both benchmarks do identical work.
+func transitions(n int) ([]int, []connState) {
+	r := rand.New(rand.NewPCG(1, 2))
+	idx := make([]int, n)
+	st := make([]connState, n)
+	for i := range idx {
+		idx[i] = r.IntN(stateBenchConns)
+		st[i] = connState(r.IntN(int(numStates)))
+	}
+	return idx, st
+}
+
+// BenchmarkConnState_List moves connections between intrusive lists.
+func BenchmarkConnState_List(b *testing.B) {
+	l := &Loop{}
+	for i := range l.lists {
+		l.lists[i].init()
+	}
+	conns := make([]Conn, stateBenchConns)
+	for i := range conns {
+		l.lists[stateIdle].pushFront(&conns[i])
+	}
+	idx, st := transitions(stateBenchOps)
+	m := pow2.MaskFor(len(idx))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := m.Index(i)
+		l.setState(&conns[idx[j]], st[j])
+	}
+}
+
+// BenchmarkConnState_Map keeps one set per state, the usual first attempt.
+func BenchmarkConnState_Map(b *testing.B) {
+	var sets [numStates]map[*Conn]struct{}
+	for i := range sets {
+		sets[i] = make(map[*Conn]struct{})
+	}
+	conns := make([]Conn, stateBenchConns)
+	for i := range conns {
+		sets[stateIdle][&conns[i]] = struct{}{}
+	}
+	idx, st := transitions(stateBenchOps)
+	m := pow2.MaskFor(len(idx))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := m.Index(i)
+		c := &conns[idx[j]]
+		delete(sets[c.state], c)
+		c.state = st[j]
+		sets[c.state][c] = struct{}{}
+	}
+}
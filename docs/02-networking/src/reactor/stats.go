@@ -0,0 +1,180 @@
+//go:build linux
+
+package reactor
+
+import (
+	"expvar"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// wakeBuckets covers 1 to MaxEvents events per wake in powers of two.
+	wakeBuckets = 16
+	// iterBuckets covers iteration times from under 1µs to over ~1s in
+	// powers of two microseconds.
+	iterBuckets = 22
+)
+
+// Stats describes how the loop has been spending its time. A loop that is
+// healthy at high load takes many events per wake and finishes each
+// iteration well inside its budget; long iterations mean something in a
+// handler blocks, and every connection in the loop waits for it.
+type Stats struct {
+	Wakes  uint64 `json:"wakes"`  // EpollWait calls that returned events
+	Events uint64 `json:"events"` // events handled
+
+	// EventsPerWake[i] counts wakes that returned [2^i, 2^(i+1)) events.
+	EventsPerWake []uint64 `json:"events_per_wake"`
+
+	Busy         time.Duration `json:"busy_ns"`          // time spent handling events
+	MaxIteration time.Duration `json:"max_iteration_ns"` // longest single iteration
+	// IterationTime[i] counts iterations shorter than 2^i µs; the last
+	// bucket holds everything longer.
+	IterationTime []uint64 `json:"iteration_us"`
+
+	Accepts     uint64 `json:"accepts"`      // connections accepted
+	AcceptWakes uint64 `json:"accept_wakes"` // listener readiness events handled
+
+	Budget   time.Duration `json:"budget_ns"`
+	Overruns uint64        `json:"overruns"` // iterations that took longer than Budget
+	Stalls   uint64        `json:"stalls"`   // overruns the watchdog caught while still running
+
+	// With Config.WriteQuantum set: rounds of the write scheduler, and
+	// the times a connection used its share with output left over.
+	WriteRounds uint64 `json:"write_rounds"`
+	WriteYields uint64 `json:"write_yields"`
+
+	Submits uint64 `json:"submits"` // functions run for Submit
+
+	// With Config.Steer set: connections this loop accepted and handed
+	// to another loop of its Group, and those it took over from the rest.
+	// Accepts counts the first and not the second.
+	HandedOff uint64 `json:"handed_off"`
+	Adopted   uint64 `json:"adopted"`
+}
+
+// loopStats is written by the loop goroutine only. The fields are atomic so
+// Stats and the watchdog can read them from other goroutines; with a single
+// writer the atomics never contend.
+type loopStats struct {
+	wakes, events atomic.Uint64
+	perWake       [wakeBuckets]atomic.Uint64
+	busy, maxIter atomic.Int64
+	iterTime      [iterBuckets]atomic.Uint64
+	overruns      atomic.Uint64
+	stalls        atomic.Uint64
+	accepts       atomic.Uint64
+	acceptWakes   atomic.Uint64
+	writeRounds   atomic.Uint64
+	writeYields   atomic.Uint64
+	submits       atomic.Uint64
+	handedOff     atomic.Uint64
+	adopted       atomic.Uint64
+
+	// iterStart is the monotonic start of the running iteration relative
+	// to epoch, or 0 while the loop sits in EpollWait.
+	iterStart atomic.Int64
+	epoch     time.Time
+
+	callback atomic.Uint32 // Handler method the loop is inside
+	goid     uint64        // loop goroutine, set before the watchdog starts
+}
+
+// begin marks the start of an iteration that handles n events. An
+// iteration with none only runs the write scheduler and is not a wake.
+func (s *loopStats) begin(n int) {
+	s.iterStart.Store(int64(time.Since(s.epoch)) | 1) // never 0
+	if n == 0 {
+		return
+	}
+	s.wakes.Add(1)
+	s.events.Add(uint64(n))
+	s.perWake[min(max(bits.Len(uint(n))-1, 0), wakeBuckets-1)].Add(1)
+}
+
+// end records the iteration started by begin and reports whether it ran
+// over budget.
+func (s *loopStats) end(budget time.Duration) {
+	d := int64(time.Since(s.epoch)) - s.iterStart.Load()
+	s.iterStart.Store(0)
+	s.busy.Add(d)
+	if d > s.maxIter.Load() {
+		s.maxIter.Store(d)
+	}
+	s.iterTime[min(bits.Len64(uint64(d/1e3)), iterBuckets-1)].Add(1)
+	if budget > 0 && d > int64(budget) {
+		s.overruns.Add(1)
+	}
+}
+
+// Stats returns a snapshot of the loop's counters. It is safe to call from
+// any goroutine.
+func (l *Loop) Stats() Stats {
+	s := &l.stats
+	st := Stats{
+		Wakes:         s.wakes.Load(),
+		Events:        s.events.Load(),
+		EventsPerWake: make([]uint64, wakeBuckets),
+		Busy:          time.Duration(s.busy.Load()),
+		MaxIteration:  time.Duration(s.maxIter.Load()),
+		IterationTime: make([]uint64, iterBuckets),
+		Accepts:       s.accepts.Load(),
+		AcceptWakes:   s.acceptWakes.Load(),
+		Budget:        l.cfg.Budget,
+		Overruns:      s.overruns.Load(),
+		Stalls:        s.stalls.Load(),
+		WriteRounds:   s.writeRounds.Load(),
+		WriteYields:   s.writeYields.Load(),
+		Submits:       s.submits.Load(),
+		HandedOff:     s.handedOff.Load(),
+		Adopted:       s.adopted.Load(),
+	}
+	for i := range s.perWake {
+		st.EventsPerWake[i] = s.perWake[i].Load()
+	}
+	for i := range s.iterTime {
+		st.IterationTime[i] = s.iterTime[i].Load()
+	}
+	return st
+}
+
+// Publish exports Stats under name through expvar, so any program that
+// serves http.DefaultServeMux shows them at /debug/vars. Like
+// expvar.Publish it panics if name is already taken.
+func (l *Loop) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return l.Stats() }))
+}
+
+// watchdog flags an iteration that is still running past the budget. The
+// loop itself only notices an overrun once the iteration ends, which for a
+// handler stuck in a blocking call may be never.
+func (l *Loop) watchdog(done <-chan struct{}) {
+	budget := l.cfg.Budget
+	t := time.NewTicker(budget / 2)
+	defer t.Stop()
+	var flagged int64 // iterStart of the last iteration reported
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		start := l.stats.iterStart.Load()
+		if start == 0 || start == flagged {
+			continue
+		}
+		if d := time.Since(l.stats.epoch) - time.Duration(start); d > budget {
+			flagged = start
+			l.stats.stalls.Add(1)
+			if l.cfg.OnStall != nil {
+				l.cfg.OnStall(Stall{
+					Running:  d,
+					Callback: callbackNames[l.stats.callback.Load()],
+					Stack:    goroutineStack(l.stats.goid),
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+//go:build linux
+
+package reactor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func sum(counts []uint64) (n uint64) {
+	for _, c := range counts {
+		n += c
+	}
+	return n
+}
+
+func TestStats(t *testing.T) {
+	l := startLoop(t, echoHandler{}, Config{})
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for range 10 {
+		conn.Write([]byte("ping\n"))
+		if _, err := r.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st := l.Stats()
+	// accept plus one read per message at least.
+	if st.Wakes < 11 || st.Events < st.Wakes {
+		t.Fatalf("wakes %d events %d, want at least 11 wakes", st.Wakes, st.Events)
+	}
+	if got := sum(st.EventsPerWake); got != st.Wakes {
+		t.Errorf("EventsPerWake sums to %d, want %d", got, st.Wakes)
+	}
+	// The last iteration may still be running.
+	if got := sum(st.IterationTime); got != st.Wakes && got+1 != st.Wakes {
+		t.Errorf("IterationTime sums to %d, want %d", got, st.Wakes)
+	}
+	if st.Overruns != 0 || st.MaxIteration <= 0 || st.MaxIteration > st.Busy {
+		t.Errorf("unexpected timing: %+v", st)
+	}
+}
+
+func TestStatsAfterClose(t *testing.T) {
+	l, err := Listen("127.0.0.1:0", echoHandler{}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- l.Run() }()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("ping\n"))
+	if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	// The iteration that saw Close is recorded like any other.
+	st := l.Stats()
+	if got := sum(st.IterationTime); got != st.Wakes {
+		t.Errorf("IterationTime sums to %d after Close, want %d", got, st.Wakes)
+	}
+	if l.stats.iterStart.Load() != 0 {
+		t.Error("iteration still marked as running after Run returned")
+	}
+}
+
+// sleepHandler blocks the loop in OnData, the mistake the watchdog exists
+// to catch.
+type sleepHandler struct {
+	echoHandler
+	d time.Duration
+}
+
+func (h sleepHandler) OnData(c *Conn, data []byte) {
+	time.Sleep(h.d)
+	c.Write(data)
+}
+
+func TestWatchdog(t *testing.T) {
+	stalls := make(chan Stall, 1)
+	l := startLoop(t, sleepHandler{d: 100 * time.Millisecond}, Config{
+		Budget:  10 * time.Millisecond,
+		OnStall: func(s Stall) { stalls <- s },
+	})
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("x"))
+
+	select {
+	case s := <-stalls:
+		if s.Running <= 10*time.Millisecond {
+			t.Errorf("stall after %v, want more than the budget", s.Running)
+		}
+		if s.Callback != "OnData" {
+			t.Errorf("stall in %q, want OnData", s.Callback)
+		}
+		if !bytes.Contains(s.Stack, []byte("sleepHandler.OnData")) {
+			t.Errorf("stall stack does not show the blocking handler:\n%s", s.Stack)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchdog did not flag the blocked iteration")
+	}
+	// The stall is reported while OnData is still sleeping.
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	// The reply is written from inside OnData, so the iteration may not
+	// have been recorded yet when it arrives.
+	st := l.Stats()
+	for deadline := time.Now().Add(time.Second); st.Overruns == 0 && time.Now().Before(deadline); st = l.Stats() {
+		time.Sleep(time.Millisecond)
+	}
+	if st.Stalls != 1 || st.Overruns < 1 || st.MaxIteration < 100*time.Millisecond {
+		t.Errorf("stalls %d overruns %d max %v, want 1, >=1, >=100ms", st.Stalls, st.Overruns, st.MaxIteration)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	l := startLoop(t, echoHandler{}, Config{})
+	name := fmt.Sprintf("reactor_%p", l) // expvar names are global
+	l.Publish(name)
+
+	var st Stats
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Budget != 10*time.Millisecond || len(st.EventsPerWake) != wakeBuckets {
+		t.Fatalf("published stats %+v", st)
+	}
+}
@@ -0,0 +1,138 @@
+//go:build linux
+
+package reactor
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Steering is how a Group picks the loop that serves a connection one of
+// its loops accepted.
+//
+// A packet is received on one CPU: the one its NIC queue interrupts, or
+// the one RPS sends it to, or on loopback the sender's own. The protocol
+// work runs there and then wakes the thread waiting on the socket. If that
+// thread is a loop pinned to the same CPU, the wakeup is local and the data
+// is still in its cache; on any other CPU every packet costs an
+// inter-processor interrupt and cache misses on the socket and its data.
+// The kernel records the CPU as SO_INCOMING_CPU.
+type Steering int
+
+const (
+	// SteerNone keeps a connection on the loop that accepted it, the one
+	// whose listener SO_REUSEPORT picked by hashing the connection's
+	// address. Which CPU receives its packets does not enter into it.
+	SteerNone Steering = iota
+	// SteerIncomingCPU hands a connection to the loop pinned to its
+	// SO_INCOMING_CPU, and sets each listener's SO_INCOMING_CPU to its
+	// loop's CPU, so that Linux 6.1 and later pick the matching listener
+	// to begin with and a handoff is the exception. It needs Config.Pin.
+	SteerIncomingCPU
+	// SteerRandom hands a connection to a loop chosen at random. It is
+	// the baseline SteerIncomingCPU is measured against: the locality of
+	// any assignment that ignores the receiving CPU, plus a handoff for
+	// most connections.
+	SteerRandom
+)
+
+func (s Steering) String() string {
+	switch s {
+	case SteerNone:
+		return "none"
+	case SteerIncomingCPU:
+		return "incoming-cpu"
+	case SteerRandom:
+		return "random"
+	}
+	return fmt.Sprintf("Steering(%d)", int(s))
+}
+
+// Set parses "none", "incoming-cpu" or "random", so that a Steering can be
+// a flag.Value.
+func (s *Steering) Set(v string) error {
+	switch v {
+	case "none":
+		*s = SteerNone
+	case "incoming-cpu":
+		*s = SteerIncomingCPU
+	case "random":
+		*s = SteerRandom
+	default:
+		return fmt.Errorf("reactor: unknown steering %q, want none, incoming-cpu or random", v)
+	}
+	return nil
+}
+
+// CPU returns the CPU the loop is pinned to, or -1.
+func (l *Loop) CPU() int { return l.cpu }
+
+// pin locks the calling goroutine to its thread and the thread to the
+// loop's CPU. The thread is never unlocked: when Run returns, it exits with
+// the goroutine rather than going back to the scheduler with a one-CPU
+// affinity.
+func (l *Loop) pin() error {
+	if l.cpu < 0 {
+		return nil
+	}
+	runtime.LockOSThread()
+	var set unix.CPUSet
+	set.Set(l.cpu)
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		return fmt.Errorf("reactor: pin to CPU %d: %w", l.cpu, err)
+	}
+	return nil
+}
+
+// allowedCPUs returns the CPUs the process may run on, in order.
+func allowedCPUs() ([]int, error) {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		return nil, err
+	}
+	var cpus []int
+	for i := 0; len(cpus) < set.Count(); i++ {
+		if set.IsSet(i) {
+			cpus = append(cpus, i)
+		}
+	}
+	return cpus, nil
+}
+
+// target returns the loop that should serve fd, which from accepted.
+func (g *Group) target(from *Loop, fd int) *Loop {
+	switch g.steer {
+	case SteerIncomingCPU:
+		cpu, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_INCOMING_CPU)
+		if err == nil && cpu >= 0 && cpu < len(g.byCPU) && g.byCPU[cpu] != nil && from.cpu != cpu {
+			return g.byCPU[cpu]
+		}
+	case SteerRandom:
+		return g.loops[rand.IntN(len(g.loops))]
+	}
+	return from
+}
+
+// handoff passes a connection l accepted to another loop of its group.
+// It costs l a Submit and the target one wake of its eventfd, once per
+// connection; the packets that follow go straight to the target. If the
+// target closes first, the connection is dropped with its queued work.
+func (l *Loop) handoff(to *Loop, fd int, remote netip.AddrPort) {
+	err := to.Submit(func() {
+		if _, err := to.add(fd, remote); err != nil {
+			syscall.Close(fd)
+			return
+		}
+		to.stats.adopted.Add(1)
+	})
+	if err != nil {
+		syscall.Close(fd)
+		return
+	}
+	l.stats.handedOff.Add(1)
+}
@@ -0,0 +1,259 @@
+//go:build linux
+
+package reactor
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// localHandler echoes and counts, at OnOpen, the connections whose packets
+// are received on its loop's CPU. Its counters are only touched by its
+// loop's goroutine.
+type localHandler struct {
+	echoHandler
+	opened, local int
+}
+
+func (h *localHandler) OnOpen(c *Conn) {
+	h.opened++
+	cpu, err := unix.GetsockoptInt(c.Fd(), unix.SOL_SOCKET, unix.SO_INCOMING_CPU)
+	if err == nil && cpu == c.Loop().CPU() {
+		h.local++
+	}
+}
+
+// startGroup runs a Group of one loop per CPU the process may run on, at
+// least two, for the duration of the test.
+func startGroup(tb testing.TB, cfg Config) (*Group, []*localHandler) {
+	tb.Helper()
+	cpus, err := allowedCPUs()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	n := max(len(cpus), 2)
+	handlers := make([]*localHandler, n)
+	g, err := ListenGroup("127.0.0.1:0", n, func(i int) Handler {
+		handlers[i] = &localHandler{}
+		return handlers[i]
+	}, cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- g.Run() }()
+	tb.Cleanup(func() {
+		g.Close()
+		if err := <-done; err != nil {
+			tb.Errorf("Run: %v", err)
+		}
+	})
+	return g, handlers
+}
+
+// pinThread locks the calling goroutine to its thread and the thread to
+// cpu. On loopback a client's packets are received on the CPU it sends
+// them from, so a pinned client fixes its connection's SO_INCOMING_CPU.
+// The goroutine must not unlock: its thread exits with it.
+func pinThread(tb testing.TB, cpu int) {
+	runtime.LockOSThread()
+	var set unix.CPUSet
+	set.Set(cpu)
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		tb.Error(err)
+	}
+}
+
+// echoOnce dials addr, has one message echoed and returns the connection.
+func echoOnce(addr string) (net.Conn, error) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	msg := []byte("ping\n")
+	if _, err := c.Write(msg); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if _, err := io.ReadFull(c, msg); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func TestSteerIncomingCPU(t *testing.T) {
+	const perCPU = 20
+	g, handlers := startGroup(t, Config{Pin: true, Steer: SteerIncomingCPU})
+	cpus, _ := allowedCPUs()
+	var conns []net.Conn
+	for _, cpu := range cpus {
+		errc := make(chan error, 1)
+		go func() {
+			pinThread(t, cpu)
+			for range perCPU {
+				c, err := echoOnce(g.Addr().String())
+				if err != nil {
+					errc <- err
+					return
+				}
+				conns = append(conns, c)
+			}
+			errc <- nil
+		}()
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+
+	var opened, local int
+	var handed, adopted uint64
+	flushGroup(t, g, func() {
+		for i, l := range g.Loops() {
+			opened += handlers[i].opened
+			local += handlers[i].local
+			st := l.Stats()
+			handed += st.HandedOff
+			adopted += st.Adopted
+		}
+	})
+	if want := perCPU * len(cpus); opened != want || local != want {
+		t.Errorf("%d connections opened, %d on their receiving CPU; want %d", opened, local, want)
+	}
+	if handed != adopted {
+		t.Errorf("handed off %d, adopted %d", handed, adopted)
+	}
+	for i, l := range g.Loops() {
+		if want := cpus[i%len(cpus)]; l.CPU() != want {
+			t.Errorf("loop %d on CPU %d, want %d", i, l.CPU(), want)
+		}
+	}
+}
+
+func TestSteerRandom(t *testing.T) {
+	const conns = 100
+	g, handlers := startGroup(t, Config{Steer: SteerRandom})
+	for range conns {
+		c, err := echoOnce(g.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+	}
+	var handed, adopted, accepts uint64
+	var opened int
+	flushGroup(t, g, func() {
+		for i, l := range g.Loops() {
+			st := l.Stats()
+			handed += st.HandedOff
+			adopted += st.Adopted
+			accepts += st.Accepts
+			opened += handlers[i].opened
+		}
+	})
+	if accepts != conns || opened != conns {
+		t.Errorf("accepted %d, opened %d; want %d", accepts, opened, conns)
+	}
+	if handed != adopted || handed == 0 {
+		t.Errorf("handed off %d, adopted %d", handed, adopted)
+	}
+	for _, l := range g.Loops() {
+		if l.CPU() != -1 {
+			t.Errorf("loop pinned to CPU %d without Pin", l.CPU())
+		}
+	}
+}
+
+func TestSteerNeedsPin(t *testing.T) {
+	if _, err := ListenGroup("127.0.0.1:0", 2, func(int) Handler { return echoHandler{} }, Config{Steer: SteerIncomingCPU}); err == nil {
+		t.Fatal("SteerIncomingCPU without Pin accepted")
+	}
+	var s Steering
+	if err := s.Set("incoming-cpu"); err != nil || s != SteerIncomingCPU || s.String() != "incoming-cpu" {
+		t.Errorf("Set(incoming-cpu): %v, %v", s, err)
+	}
+	if err := s.Set("hash"); err == nil {
+		t.Error("Set(hash) accepted")
+	}
+}
+
+// flushGroup runs read once every loop of g has run what was submitted to
+// it so far, so that handoffs in flight have landed and handler counters
+// are safe to read.
+func flushGroup(tb testing.TB, g *Group, read func()) {
+	tb.Helper()
+	for _, l := range g.Loops() {
+		done := make(chan struct{})
+		if err := l.Submit(func() { close(done) }); err != nil {
+			tb.Fatal(err)
+		}
+		<-done
+	}
+	read()
+}
+
+// BenchmarkSteering measures echo round trips over loopback from clients
+// pinned one to each CPU, against a Group with a loop pinned to each CPU,
+// by how connections are assigned to loops. Under incoming-cpu a client
+// talks to the loop on its own CPU; under random and none mostly to
+// another one, and every message and reply crosses CPUs. local% is the
+// share of connections served on the CPU that receives their packets.
+func BenchmarkSteering(b *testing.B) {
+	cpus, err := allowedCPUs()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if len(cpus) < 2 {
+		b.Skip("needs two CPUs: with one, every connection is local")
+	}
+	for _, steer := range []Steering{SteerNone, SteerRandom, SteerIncomingCPU} {
+		b.Run(steer.String(), func(b *testing.B) {
+			g, handlers := startGroup(b, Config{Pin: true, Steer: steer})
+			var next atomic.Int64
+			b.SetParallelism(1)
+			b.RunParallel(func(pb *testing.PB) {
+				pinThread(b, cpus[int(next.Add(1)-1)%len(cpus)])
+				c, err := echoOnce(g.Addr().String())
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				defer c.Close()
+				c.SetDeadline(time.Time{})
+				msg := make([]byte, 64)
+				for pb.Next() {
+					if _, err := c.Write(msg); err != nil {
+						b.Error(err)
+						return
+					}
+					if _, err := io.ReadFull(c, msg); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.StopTimer()
+			var opened, local int
+			var handed uint64
+			flushGroup(b, g, func() {
+				for i, l := range g.Loops() {
+					opened += handlers[i].opened
+					local += handlers[i].local
+					handed += l.Stats().HandedOff
+				}
+			})
+			b.ReportMetric(100*float64(local)/float64(max(opened, 1)), "local%")
+			b.ReportMetric(float64(handed)/float64(max(opened, 1)), "handoffs/conn")
+		})
+	}
+}
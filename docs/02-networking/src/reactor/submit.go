@@ -0,0 +1,116 @@
+//go:build linux
+
+package reactor
+
+import (
+	"net/netip"
+	"syscall"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/netaddr"
+)
+
+// Submit runs fn on the loop goroutine during its next wake, after the
+// functions submitted before it. It is safe to call from any goroutine,
+// and it is how other goroutines get anything done in the loop: a Conn
+// or the loop's state touched from outside races the loop, and a result
+// computed elsewhere, say by a worker pool or a backend call, has to come
+// back this way to be written. fn runs like a Handler callback and must
+// not block.
+//
+// The loop is woken through the same eventfd that Close uses, once per
+// batch: while functions are queued and not yet taken, Submit only
+// appends. Submit returns ErrClosed once Close has been called; functions
+// still queued then are dropped.
+func (l *Loop) Submit(fn func()) error {
+	l.wakeMu.Lock()
+	defer l.wakeMu.Unlock()
+	if l.wakefd < 0 || l.closing.Load() {
+		return ErrClosed
+	}
+	l.tasks = append(l.tasks, fn)
+	if len(l.tasks) > 1 {
+		return nil // the loop has been woken and not yet taken the queue
+	}
+	return l.wake()
+}
+
+// wake makes the wake eventfd readable. wakeMu must be held.
+func (l *Loop) wake() error {
+	var one = [8]byte{1}
+	_, err := syscall.Write(l.wakefd, one[:])
+	if err == syscall.EAGAIN {
+		return nil // the counter is saturated, so it is readable anyway
+	}
+	return err
+}
+
+// runTasks resets the wake eventfd and runs what was submitted. The
+// eventfd is read before the queue is taken, so a Submit that lands after
+// the swap wakes the loop again rather than waiting for an unrelated
+// event.
+func (l *Loop) runTasks() {
+	var buf [8]byte
+	syscall.Read(l.wakefd, buf[:])
+	l.wakeMu.Lock()
+	tasks := l.tasks
+	l.tasks = l.spare[:0]
+	l.wakeMu.Unlock()
+
+	prev := l.enter(cbSubmit)
+	for i, fn := range tasks {
+		fn()
+		tasks[i] = nil
+	}
+	l.leave(prev)
+	l.stats.submits.Add(uint64(len(tasks)))
+	l.spare = tasks[:0]
+}
+
+// WriteAsync copies p and writes it to c from the loop goroutine. It is
+// safe to call from any goroutine, as long as c came from this loop. If
+// c is closed by then, p is dropped; if the write fails, c is closed with
+// the error, which OnClose receives.
+func (c *Conn) WriteAsync(p []byte) error {
+	b := append([]byte(nil), p...)
+	return c.loop.Submit(func() {
+		if err := c.Write(b); err != nil && err != ErrClosed {
+			c.loop.closeConn(c, err)
+		}
+	})
+}
+
+// Add registers fd, a connected stream socket the loop takes ownership
+// of, and calls OnOpen for it. It must be called on the loop goroutine;
+// another goroutine, one that dialed a backend for instance, hands the
+// fd over with
+//
+//	l.Submit(func() {
+//		if _, err := l.Add(fd); err != nil {
+//			syscall.Close(fd)
+//		}
+//	})
+func (l *Loop) Add(fd int) (*Conn, error) {
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		return nil, err
+	}
+	var remote netip.AddrPort
+	if sa, err := syscall.Getpeername(fd); err == nil {
+		remote, _ = netaddr.FromSockaddr(sa)
+	}
+	return l.add(fd, remote)
+}
+
+// add registers a non-blocking fd with epoll and opens a Conn for it.
+func (l *Loop) add(fd int, remote netip.AddrPort) (*Conn, error) {
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(fd)}
+	if err := syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		return nil, opError(opEpollCtl, err)
+	}
+	c := &Conn{fd: fd, loop: l, remote: remote, state: stateIdle, priority: PriorityNormal}
+	l.lists[stateIdle].pushFront(c)
+	l.setConn(fd, c)
+	prev := l.enter(cbOpen)
+	l.handler.OnOpen(c)
+	l.leave(prev)
+	return c, nil
+}
@@ -0,0 +1,130 @@
+// Package readguard protects servers from slow clients (slowloris-style
+// attacks) that keep connections open by trickling bytes just fast enough to
+// never trip a plain read deadline.
+//
+// A connection is either idle, between messages, or busy receiving one. Idle
+// connections are bounded by IdleTimeout only. Once the first byte of a
+// message arrives, the message has to complete within MessageTimeout and,
+// after a grace period, arrive at no less than MinRate bytes per second.
+// Per-read deadlines catch stalled reads; a Reaper catches the rest.
+package readguard
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+)
+
+// Policy bounds how slowly a client may send. Zero fields disable the
+// corresponding check.
+type Policy struct {
+	IdleTimeout     time.Duration // max wait for the first byte of the next message
+	ProgressTimeout time.Duration // max wait for the next byte inside a message
+	MessageTimeout  time.Duration // max time from the first to the last byte of a message
+	MinRate         int           // min bytes per second while a message is in progress
+	Grace           time.Duration // MinRate is not enforced during the first Grace of a message
+
+	// Clock times messages and drives the Reaper; nil means the system
+	// clock. Read deadlines are kept by the kernel and always use it.
+	Clock clock.Clock
+}
+
+// violation reports why a busy connection should be dropped, or "".
+func (p *Policy) violation(elapsed time.Duration, received int64) string {
+	if p.MessageTimeout > 0 && elapsed > p.MessageTimeout {
+		return "message timeout"
+	}
+	if p.MinRate > 0 && elapsed > p.Grace && elapsed > 0 {
+		if float64(received)/elapsed.Seconds() < float64(p.MinRate) {
+			return "below min rate"
+		}
+	}
+	return ""
+}
+
+// Conn tracks read progress on a wrapped connection.
+type Conn struct {
+	net.Conn
+	policy    *Policy
+	reaper    *Reaper
+	deadlines bool
+
+	msgStart atomic.Int64 // unix nanos of the current message's first byte, 0 when idle
+	msgBytes atomic.Int64
+	closed   atomic.Bool
+}
+
+// Wrap returns c with the policy applied. When deadlines is true every Read
+// sets its own read deadline (IdleTimeout or ProgressTimeout); leave it false
+// when the server manages deadlines itself, as net/http does. r may be nil.
+func Wrap(c net.Conn, p *Policy, r *Reaper, deadlines bool) *Conn {
+	gc := &Conn{Conn: c, policy: p, reaper: r, deadlines: deadlines}
+	if r != nil {
+		r.add(gc)
+	}
+	return gc
+}
+
+// Read reads from the connection, bounding the wait for the next byte.
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.deadlines {
+		timeout := c.policy.IdleTimeout
+		if c.msgStart.Load() != 0 {
+			timeout = c.policy.ProgressTimeout
+		}
+		if timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if c.msgStart.Load() == 0 {
+			c.msgStart.Store(clock.Or(c.policy.Clock).Now().UnixNano())
+		}
+		c.msgBytes.Add(int64(n))
+	}
+	return n, err
+}
+
+// MessageDone marks the end of a message; the connection is idle until the
+// next byte arrives. Call it only when no partial message is buffered, e.g.
+// when bufio.Reader.Buffered() is 0.
+func (c *Conn) MessageDone() {
+	c.msgStart.Store(0)
+	c.msgBytes.Store(0)
+}
+
+// Close closes the connection and stops tracking it.
+func (c *Conn) Close() error {
+	if c.closed.Swap(true) {
+		return net.ErrClosed
+	}
+	if c.reaper != nil {
+		c.reaper.remove(c)
+	}
+	return c.Conn.Close()
+}
+
+// listener wraps every accepted connection.
+type listener struct {
+	net.Listener
+	policy    *Policy
+	reaper    *Reaper
+	deadlines bool
+}
+
+// Listen wraps ln so that every accepted connection is guarded by p and
+// tracked by r. See Wrap for deadlines.
+func Listen(ln net.Listener, p *Policy, r *Reaper, deadlines bool) net.Listener {
+	return &listener{Listener: ln, policy: p, reaper: r, deadlines: deadlines}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(c, l.policy, l.reaper, l.deadlines), nil
+}
@@ -0,0 +1,137 @@
+package readguard
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+)
+
+func TestReaperClosesSlowMessage(t *testing.T) {
+	p := &Policy{MinRate: 100, Grace: time.Second, MessageTimeout: 10 * time.Second}
+	r := NewReaper(p)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := Wrap(server, p, r, false)
+
+	go client.Write([]byte("abc"))
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(0, c.msgStart.Load())
+	r.check(start.Add(500 * time.Millisecond)) // within grace
+	if r.Reaped() != 0 {
+		t.Fatal("connection reaped during grace period")
+	}
+	r.check(start.Add(2 * time.Second)) // 3 bytes in 2s is below 100 B/s
+	if r.Reaped() != 1 || r.Tracked() != 0 {
+		t.Fatalf("reaped=%d tracked=%d, want 1 and 0", r.Reaped(), r.Tracked())
+	}
+	if _, err := c.Read(buf); err == nil {
+		t.Fatal("read on reaped connection succeeded")
+	}
+}
+
+func TestReaperIgnoresIdle(t *testing.T) {
+	p := &Policy{MinRate: 100, MessageTimeout: time.Second}
+	r := NewReaper(p)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := Wrap(server, p, r, false)
+	defer c.Close()
+
+	go client.Write([]byte("line\n"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	c.MessageDone()
+
+	r.check(time.Now().Add(time.Hour))
+	if r.Reaped() != 0 {
+		t.Fatal("idle connection reaped")
+	}
+}
+
+// TestReaperRun runs the Reaper on a fake clock: a message that has
+// trickled for 9s of a 10s MessageTimeout survives the tick, and the tick
+// after the timeout closes it.
+func TestReaperRun(t *testing.T) {
+	// Not the epoch: a message start of 0 means idle.
+	clk := clock.NewFake(time.Unix(1e9, 0))
+	p := &Policy{MessageTimeout: 10 * time.Second, Clock: clk}
+	r := NewReaper(p)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx, 3*time.Second)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := Wrap(server, p, r, false)
+	go client.Write([]byte("a"))
+	if _, err := c.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	clk.BlockUntil(1)
+	for range 3 {
+		clk.Advance(3 * time.Second)
+	}
+	if r.Reaped() != 0 {
+		t.Fatal("reaped before the message timeout")
+	}
+	clk.Advance(3 * time.Second)
+	// The reaper closes the server side, which the client sees as EOF.
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("client read %v, want EOF from the reaped connection", err)
+	}
+	if r.Reaped() != 1 {
+		t.Errorf("reaped %d, want 1", r.Reaped())
+	}
+}
+
+func TestProgressTimeout(t *testing.T) {
+	p := &Policy{IdleTimeout: time.Minute, ProgressTimeout: 20 * time.Millisecond}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	c := Wrap(server, p, nil, true)
+	defer c.Close()
+
+	go client.Write([]byte("a"))
+	buf := make([]byte, 1)
+	if _, err := c.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	// A message is in progress, so the next byte must come within 20ms.
+	_, err := c.Read(buf)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("got %v, want timeout", err)
+	}
+}
+
+func TestPolicyViolation(t *testing.T) {
+	p := &Policy{MinRate: 10, Grace: time.Second, MessageTimeout: 5 * time.Second}
+	tests := []struct {
+		elapsed  time.Duration
+		received int64
+		want     string
+	}{
+		{500 * time.Millisecond, 0, ""},
+		{2 * time.Second, 100, ""},
+		{2 * time.Second, 5, "below min rate"},
+		{6 * time.Second, 1000, "message timeout"},
+	}
+	for _, tt := range tests {
+		if got := p.violation(tt.elapsed, tt.received); got != tt.want {
+			t.Errorf("violation(%v, %d) = %q, want %q", tt.elapsed, tt.received, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package readguard
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+)
+
+// Reaper periodically closes connections that violate the policy while a
+// message is in progress. Unlike a read deadline it also catches handlers
+// that are not currently blocked in Read.
+type Reaper struct {
+	policy *Policy
+	mu     sync.Mutex
+	conns  map[*Conn]struct{}
+	reaped atomic.Int64
+}
+
+// NewReaper returns a Reaper enforcing p. Call Run to start it.
+func NewReaper(p *Policy) *Reaper {
+	return &Reaper{policy: p, conns: make(map[*Conn]struct{})}
+}
+
+func (r *Reaper) add(c *Conn) {
+	r.mu.Lock()
+	r.conns[c] = struct{}{}
+	r.mu.Unlock()
+}
+
+func (r *Reaper) remove(c *Conn) {
+	r.mu.Lock()
+	delete(r.conns, c)
+	r.mu.Unlock()
+}
+
+// Tracked returns the number of connections currently tracked.
+func (r *Reaper) Tracked() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// Reaped returns how many connections the Reaper has closed so far.
+func (r *Reaper) Reaped() int64 { return r.reaped.Load() }
+
+// Run checks all connections every interval of the policy's clock until
+// ctx is done.
+func (r *Reaper) Run(ctx context.Context, interval time.Duration) {
+	ticker := clock.Or(r.policy.Clock).NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C():
+			r.check(now)
+		}
+	}
+}
+
+// check closes every busy connection that violates the policy at now.
+func (r *Reaper) check(now time.Time) {
+	var victims []*Conn
+	r.mu.Lock()
+	for c := range r.conns {
+		start := c.msgStart.Load()
+		if start == 0 {
+			continue
+		}
+		elapsed := now.Sub(time.Unix(0, start))
+		if r.policy.violation(elapsed, c.msgBytes.Load()) != "" {
+			victims = append(victims, c)
+		}
+	}
+	r.mu.Unlock()
+
+	// Close outside the lock: Close calls back into remove.
+	for _, c := range victims {
+		if c.Close() == nil {
+			r.reaped.Add(1)
+		}
+	}
+}
+
+// HTTPConnState is an http.Server.ConnState hook that marks the end of each
+// request on connections accepted through Listen, so keep-alive idle time is
+// not counted against the rate limits.
+func (r *Reaper) HTTPConnState(c net.Conn, state http.ConnState) {
+	gc, ok := c.(*Conn)
+	if !ok {
+		return
+	}
+	if state == http.StateIdle {
+		gc.MessageDone()
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"slices"
+	"time"
+)
+
+// flow is the client side of one captured TCP connection.
+type flow struct {
+	Client, Server netip.AddrPort
+	Start, End     time.Time // SYN (or first segment) and last segment, either direction
+	Msgs           []message
+
+	segs []segment // client to server, in capture order
+	fin  bool
+}
+
+// message is one write to replay. At is when its bytes were first
+// captured, or when the bytes ahead of them were if that is later.
+type message struct {
+	At   time.Time
+	Data []byte
+	// Method is the request method for -proto http, so that the reply to
+	// a HEAD is read without a body.
+	Method string
+}
+
+// capture is a pcap file reduced to the flows to one server port.
+type capture struct {
+	Flows     []*flow
+	Start     time.Time
+	Gaps      int // stream holes: packets the capture dropped or truncated
+	Truncated int
+}
+
+// duration is the time from the first connection to the last segment.
+func (c *capture) duration() time.Duration {
+	var end time.Time
+	for _, f := range c.Flows {
+		if f.End.After(end) {
+			end = f.End
+		}
+	}
+	return end.Sub(c.Start)
+}
+
+// peak returns the most connections the capture had open at once.
+func (c *capture) peak() int {
+	type edge struct {
+		at time.Time
+		d  int
+	}
+	edges := make([]edge, 0, 2*len(c.Flows))
+	for _, f := range c.Flows {
+		edges = append(edges, edge{f.Start, 1}, edge{f.End, -1})
+	}
+	slices.SortFunc(edges, func(a, b edge) int {
+		return cmp.Or(a.at.Compare(b.at), cmp.Compare(b.d, a.d)) // opens first
+	})
+	n, peak := 0, 0
+	for _, e := range edges {
+		n += e.d
+		peak = max(peak, n)
+	}
+	return peak
+}
+
+// serverPort guesses the server from the capture: the port most SYNs were
+// sent to. It returns 0 if the capture holds no handshakes.
+func serverPort(segs []segment) uint16 {
+	counts := make(map[uint16]int)
+	for _, s := range segs {
+		if s.Flags&(flagSYN|flagACK) == flagSYN {
+			counts[s.Dst.Port()]++
+		}
+	}
+	var port uint16
+	for p, n := range counts {
+		if n > counts[port] || n == counts[port] && p < port {
+			port = p
+		}
+	}
+	return port
+}
+
+// assemble groups the segments to port into flows and rebuilds each
+// client's byte stream from sequence numbers, so that retransmissions are
+// sent once and reordered segments in order.
+func assemble(segs []segment, port uint16) *capture {
+	type key struct{ client, server netip.AddrPort }
+	open := make(map[key]*flow)
+	c := &capture{}
+	for _, s := range segs {
+		k, toServer := key{s.Src, s.Dst}, true
+		if s.Src.Port() == port {
+			k, toServer = key{s.Dst, s.Src}, false
+		} else if s.Dst.Port() != port {
+			continue
+		}
+		f := open[k]
+		// A SYN on a port pair that has finished is a new connection.
+		if f == nil || toServer && s.Flags&flagSYN != 0 && (f.fin || len(f.segs) > 0) {
+			f = &flow{Client: k.client, Server: k.server, Start: s.At}
+			open[k] = f
+			c.Flows = append(c.Flows, f)
+		}
+		f.End = s.At
+		if toServer {
+			if s.Flags&(flagFIN|flagRST) != 0 {
+				f.fin = true
+			}
+			f.segs = append(f.segs, s)
+		}
+	}
+	if len(c.Flows) > 0 {
+		c.Start = c.Flows[0].Start
+	}
+	for _, f := range c.Flows {
+		gaps, truncated := f.reassemble()
+		c.Gaps += gaps
+		c.Truncated += truncated
+	}
+	return c
+}
+
+// reassemble turns f.segs into messages in stream order, one per run of
+// new bytes in a segment. Each byte is timed by its first transmission, a
+// retransmission adds nothing, and bytes missing from the capture are
+// skipped and counted as a gap.
+func (f *flow) reassemble() (gaps, truncated int) {
+	if len(f.segs) == 0 {
+		return 0, 0
+	}
+	// Sequence numbers relative to the first byte, unwrapped: the SYN
+	// consumes one, and a capture that started mid-connection begins at
+	// the first sequence number seen.
+	base := f.segs[0].Seq
+	if f.segs[0].Flags&flagSYN != 0 {
+		base++
+	}
+	type piece struct {
+		start int64
+		at    time.Time
+		data  []byte
+		trunc bool
+	}
+	var pieces []piece
+	var seen ranges
+	for _, s := range f.segs {
+		start := int64(int32(s.Seq - base))
+		for _, r := range seen.add(start, start+int64(len(s.Payload))) {
+			pieces = append(pieces, piece{r[0], s.At, s.Payload[r[0]-start : r[1]-start], s.Truncated})
+		}
+	}
+	slices.SortFunc(pieces, func(a, b piece) int { return cmp.Compare(a.start, b.start) })
+
+	var next int64
+	var last time.Time
+	for i, p := range pieces {
+		if i > 0 && p.start > next {
+			gaps++
+		}
+		next = p.start + int64(len(p.data))
+		if p.trunc {
+			truncated++
+			continue
+		}
+		// Bytes cannot be written before the ones ahead of them in the
+		// stream, however early they were first captured.
+		if p.at.After(last) {
+			last = p.at
+		}
+		f.Msgs = append(f.Msgs, message{At: last, Data: p.data})
+	}
+	f.segs = nil
+	return gaps, truncated
+}
+
+// ranges is a set of disjoint half-open byte ranges, sorted.
+type ranges [][2]int64
+
+// add inserts [lo, hi) and returns the parts of it that were not yet in
+// the set.
+func (rs *ranges) add(lo, hi int64) [][2]int64 {
+	var fresh [][2]int64
+	at := lo
+	for _, r := range *rs {
+		if r[1] <= at || r[0] >= hi {
+			continue
+		}
+		if r[0] > at {
+			fresh = append(fresh, [2]int64{at, r[0]})
+		}
+		at = max(at, r[1])
+	}
+	if at < hi {
+		fresh = append(fresh, [2]int64{at, hi})
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+	merged := append(*rs, [2]int64{lo, hi})
+	slices.SortFunc(merged, func(a, b [2]int64) int { return cmp.Compare(a[0], b[0]) })
+	out := merged[:1]
+	for _, r := range merged[1:] {
+		if top := &out[len(out)-1]; r[0] <= top[1] {
+			top[1] = max(top[1], r[1])
+		} else {
+			out = append(out, r)
+		}
+	}
+	*rs = out
+	return fresh
+}
+
+// splitHTTP regroups f's messages into one per HTTP request. A request is
+// sent at the time its first byte was captured.
+func (f *flow) splitHTTP() error {
+	var stream []byte
+	var offsets []int // where each captured message starts in stream
+	for _, m := range f.Msgs {
+		offsets = append(offsets, len(stream))
+		stream = append(stream, m.Data...)
+	}
+	r := bytes.NewReader(stream)
+	br := bufio.NewReader(r)
+	var reqs []message
+	for pos := 0; pos < len(stream); {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break // the capture ended mid-request
+			}
+			return fmt.Errorf("%v: request at byte %d: %w", f.Client, pos, err)
+		}
+		if _, err := io.Copy(io.Discard, req.Body); err != nil {
+			break
+		}
+		end := len(stream) - r.Len() - br.Buffered()
+		i, found := slices.BinarySearch(offsets, pos)
+		if !found {
+			i--
+		}
+		reqs = append(reqs, message{At: f.Msgs[i].At, Data: stream[pos:end], Method: req.Method})
+		pos = end
+	}
+	f.Msgs = reqs
+	return nil
+}
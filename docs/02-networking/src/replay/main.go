@@ -0,0 +1,142 @@
+// Command replay reads a packet capture of clients talking to one of the
+// servers in this directory and replays the clients' side against a live
+// server, with the captured timing or a multiple of it. Synthetic load
+// sends the same message at a fixed rate on every connection; real
+// clients connect in bursts, sit idle, pipeline, and mix small and large
+// writes, and an optimization tuned on one can disappoint on the other.
+//
+//	sudo tcpdump -i lo -s 0 -w echo.pcap 'tcp port 9000'
+//	go run ./replay -speed 4 echo.pcap
+//
+// Every client connection in the capture is opened at its captured offset
+// and each write is sent when it was captured, divided by -speed (0 sends
+// everything at once). Writes are rebuilt from TCP sequence numbers, so
+// retransmissions are sent once and packets the capture dropped are
+// reported as gaps.
+//
+// -proto echo (the default) expects every byte back, which fits
+// echo-net-trace.go with any codec. -proto http regroups the writes into
+// requests and reads one response per request, for net-app.go:
+//
+//	go run ./replay -proto http -addr 127.0.0.1:8080 net-app.pcap
+//
+// Latency is measured from when a write was due, not from when it went
+// out, so a server slow enough to hold the replay up still pays for it.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"time"
+)
+
+func main() {
+	var opt options
+	flag.StringVar(&opt.Addr, "addr", "", "Server to replay against (default: the captured server address)")
+	flag.StringVar(&opt.Proto, "proto", "echo", "Server protocol: echo or http")
+	flag.Float64Var(&opt.Speed, "speed", 1, "Replay this many times faster than captured (0 = no delays)")
+	flag.DurationVar(&opt.Timeout, "timeout", 5*time.Second, "Wait for replies after a connection's last write")
+	port := flag.Uint("port", 0, "Server port in the capture (default: the port most SYNs went to)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: replay [flags] capture.pcap\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || opt.Proto != "echo" && opt.Proto != "http" || opt.Speed < 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c, err := load(flag.Arg(0), uint16(*port), opt.Proto)
+	if err != nil {
+		log.Fatal(err)
+	}
+	msgs, bytes := 0, 0
+	for _, f := range c.Flows {
+		msgs += len(f.Msgs)
+		for _, m := range f.Msgs {
+			bytes += len(m.Data)
+		}
+	}
+	fmt.Printf("capture  flows=%d peak_conns=%d messages=%d bytes=%d duration=%v\n",
+		len(c.Flows), c.peak(), msgs, bytes, c.duration().Round(time.Millisecond))
+	if c.Gaps > 0 || c.Truncated > 0 {
+		fmt.Printf("warning: %d gaps and %d truncated packets in the capture; capture with -s 0 and a larger -B\n", c.Gaps, c.Truncated)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	res := replay(ctx, c, opt)
+	fmt.Printf("replay   conns=%d dial_errors=%d sent=%d replies=%d elapsed=%v\n",
+		res.Conns, res.DialErrors, res.Sent, res.Replies, res.Elapsed.Round(time.Millisecond))
+	for kind, n := range res.Errors {
+		fmt.Printf("error    %s: %d\n", kind, n)
+	}
+	printLatency("rtt", res.RTT)
+	printLatency("late", res.Late)
+}
+
+// load reads the capture at path and reduces it to the flows to port.
+func load(path string, port uint16, proto string) (*capture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pr, err := newPcapReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var segs []segment
+	for {
+		s, err := pr.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		segs = append(segs, s)
+	}
+	if port == 0 {
+		if port = serverPort(segs); port == 0 {
+			return nil, fmt.Errorf("%s: no connection handshakes; pass -port", path)
+		}
+	}
+	c := assemble(segs, port)
+	if len(c.Flows) == 0 {
+		return nil, fmt.Errorf("%s: no TCP traffic to port %d", path, port)
+	}
+	if proto == "http" {
+		for _, f := range c.Flows {
+			if err := f.splitHTTP(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+// printLatency prints samples in loadgen's format, so the two can be
+// compared line by line.
+func printLatency(name string, samples []time.Duration) {
+	if len(samples) == 0 {
+		fmt.Printf("%-8s no samples\n", name)
+		return
+	}
+	slices.Sort(samples)
+	fmt.Printf("%-8s n=%d p50=%v p90=%v p99=%v p999=%v max=%v\n", name, len(samples),
+		percentile(samples, 0.50), percentile(samples, 0.90),
+		percentile(samples, 0.99), percentile(samples, 0.999), samples[len(samples)-1])
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// Link types this reader understands: what tcpdump writes on Linux
+// (Ethernet, or Linux cooked capture with -i any), BSD loopback, and raw
+// IP.
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkSLL      = 113
+	linkIPv4     = 228
+	linkIPv6     = 229
+	linkSLL2     = 276
+)
+
+// TCP flags.
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagRST = 0x04
+	flagACK = 0x10
+)
+
+// segment is one captured TCP segment.
+type segment struct {
+	At       time.Time
+	Src, Dst netip.AddrPort
+	Seq      uint32
+	Flags    uint8
+	Payload  []byte
+	// Truncated is set when the snapshot length cut off part of the
+	// payload; the segment's bytes are then missing from the stream.
+	Truncated bool
+}
+
+// pcapReader reads the classic libpcap format. pcapng, the default of
+// Wireshark and dumpcap, is not supported; tcpdump -w writes classic pcap,
+// and editcap -F pcap converts.
+type pcapReader struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+	nano  bool
+	link  uint32
+	hdr   [16]byte
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+	var h [24]byte
+	if _, err := io.ReadFull(br, h[:]); err != nil {
+		return nil, fmt.Errorf("pcap header: %w", err)
+	}
+	p := &pcapReader{r: br}
+	switch magic := binary.LittleEndian.Uint32(h[:4]); magic {
+	case 0xa1b2c3d4:
+		p.order = binary.LittleEndian
+	case 0xa1b23c4d:
+		p.order, p.nano = binary.LittleEndian, true
+	case 0xd4c3b2a1:
+		p.order = binary.BigEndian
+	case 0x4d3cb2a1:
+		p.order, p.nano = binary.BigEndian, true
+	case 0x0a0d0d0a:
+		return nil, errors.New("pcapng is not supported; convert with editcap -F pcap")
+	default:
+		return nil, fmt.Errorf("not a pcap file (magic %#x)", magic)
+	}
+	p.link = p.order.Uint32(h[20:]) & 0x0fffffff // the top bits carry FCS info
+	switch p.link {
+	case linkNull, linkEthernet, linkRaw, linkSLL, linkIPv4, linkIPv6, linkSLL2:
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", p.link)
+	}
+	return p, nil
+}
+
+// next returns the next TCP segment, skipping packets that are not TCP
+// over IPv4 or IPv6. It returns io.EOF at the end of the file.
+func (p *pcapReader) next() (segment, error) {
+	for {
+		if _, err := io.ReadFull(p.r, p.hdr[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = errors.New("pcap: truncated record header")
+			}
+			return segment{}, err
+		}
+		sec, frac := p.order.Uint32(p.hdr[0:]), p.order.Uint32(p.hdr[4:])
+		incl := p.order.Uint32(p.hdr[8:])
+		if incl > 1<<18 {
+			return segment{}, fmt.Errorf("pcap: record of %d bytes", incl)
+		}
+		data := make([]byte, incl)
+		if _, err := io.ReadFull(p.r, data); err != nil {
+			return segment{}, fmt.Errorf("pcap: truncated record: %w", err)
+		}
+		if !p.nano {
+			frac *= 1000
+		}
+		s, ok := p.decode(data)
+		if !ok {
+			continue
+		}
+		s.At = time.Unix(int64(sec), int64(frac))
+		return s, nil
+	}
+}
+
+// decode parses the link, IP and TCP headers of one packet.
+func (p *pcapReader) decode(b []byte) (segment, bool) {
+	var ethertype uint16
+	switch p.link {
+	case linkNull:
+		if len(b) < 4 {
+			return segment{}, false
+		}
+		// The address family is in the capturing host's byte order.
+		af := binary.LittleEndian.Uint32(b)
+		if af > 0xffff {
+			af = binary.BigEndian.Uint32(b)
+		}
+		switch af {
+		case 2:
+			ethertype = 0x0800
+		case 10, 24, 28, 30:
+			ethertype = 0x86dd
+		}
+		b = b[4:]
+	case linkEthernet:
+		if len(b) < 14 {
+			return segment{}, false
+		}
+		ethertype, b = binary.BigEndian.Uint16(b[12:]), b[14:]
+		for ethertype == 0x8100 && len(b) >= 4 { // 802.1Q VLAN tags
+			ethertype, b = binary.BigEndian.Uint16(b[2:]), b[4:]
+		}
+	case linkSLL:
+		if len(b) < 16 {
+			return segment{}, false
+		}
+		ethertype, b = binary.BigEndian.Uint16(b[14:]), b[16:]
+	case linkSLL2:
+		if len(b) < 20 {
+			return segment{}, false
+		}
+		ethertype, b = binary.BigEndian.Uint16(b[0:]), b[20:]
+	case linkRaw, linkIPv4, linkIPv6:
+		if len(b) > 0 {
+			switch b[0] >> 4 {
+			case 4:
+				ethertype = 0x0800
+			case 6:
+				ethertype = 0x86dd
+			}
+		}
+	}
+
+	var src, dst netip.Addr
+	var tcp []byte
+	var tcpLen int // TCP header and payload, as sent
+	switch ethertype {
+	case 0x0800:
+		if len(b) < 20 || b[0]>>4 != 4 || b[9] != 6 {
+			return segment{}, false
+		}
+		ihl := int(b[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(b[2:]))
+		if binary.BigEndian.Uint16(b[6:])&0x3fff != 0 {
+			return segment{}, false // a fragment
+		}
+		if ihl < 20 || len(b) < ihl || total < ihl {
+			return segment{}, false
+		}
+		src, dst = netip.AddrFrom4([4]byte(b[12:16])), netip.AddrFrom4([4]byte(b[16:20]))
+		tcp, tcpLen = b[ihl:], total-ihl
+	case 0x86dd:
+		if len(b) < 40 || b[0]>>4 != 6 || b[6] != 6 {
+			return segment{}, false // not TCP, or behind extension headers
+		}
+		src, dst = netip.AddrFrom16([16]byte(b[8:24])), netip.AddrFrom16([16]byte(b[24:40]))
+		tcp, tcpLen = b[40:], int(binary.BigEndian.Uint16(b[4:]))
+	default:
+		return segment{}, false
+	}
+	if len(tcp) < 20 {
+		return segment{}, false
+	}
+	off := int(tcp[12]>>4) * 4
+	if off < 20 || len(tcp) < off || tcpLen < off {
+		return segment{}, false
+	}
+	s := segment{
+		Src:   netip.AddrPortFrom(src.Unmap(), binary.BigEndian.Uint16(tcp[0:])),
+		Dst:   netip.AddrPortFrom(dst.Unmap(), binary.BigEndian.Uint16(tcp[2:])),
+		Seq:   binary.BigEndian.Uint32(tcp[4:]),
+		Flags: tcp[13],
+	}
+	want := tcpLen - off
+	if end := min(len(tcp), tcpLen); end-off < want {
+		s.Truncated = true
+		s.Payload = make([]byte, want) // keeps the length; the bytes are lost
+	} else {
+		s.Payload = tcp[off:end]
+	}
+	return s, true
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// options control a replay.
+type options struct {
+	Addr    string        // server to replay against; the captured one if empty
+	Proto   string        // echo or http
+	Speed   float64       // time compression; 0 sends as fast as possible
+	Timeout time.Duration // wait for replies after a connection's last write
+}
+
+// result is what the replay recorded.
+type result struct {
+	Conns, DialErrors int
+	Sent, Replies     int
+	Errors            map[string]int
+	// RTT runs from when a message was due, not when it was written, so a
+	// server that holds the replayer up is not excused the wait.
+	RTT []time.Duration
+	// Late is how far behind schedule each write went out.
+	Late    []time.Duration
+	Elapsed time.Duration
+}
+
+// replay opens every flow of c at its captured offset, scaled by
+// opt.Speed, and writes its messages on the same schedule.
+func replay(ctx context.Context, c *capture, opt options) *result {
+	res := &result{Errors: make(map[string]int)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	base := time.Now()
+	due := func(at time.Time) time.Time {
+		if opt.Speed <= 0 {
+			return base
+		}
+		return base.Add(time.Duration(float64(at.Sub(c.Start)) / opt.Speed))
+	}
+	for _, f := range c.Flows {
+		if len(f.Msgs) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fr := replayFlow(ctx, f, opt, due)
+			mu.Lock()
+			defer mu.Unlock()
+			res.merge(fr)
+		}()
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(base)
+	return res
+}
+
+func (r *result) merge(o *result) {
+	r.Conns += o.Conns
+	r.DialErrors += o.DialErrors
+	r.Sent += o.Sent
+	r.Replies += o.Replies
+	for k, n := range o.Errors {
+		r.Errors[k] += n
+	}
+	r.RTT = append(r.RTT, o.RTT...)
+	r.Late = append(r.Late, o.Late...)
+}
+
+func replayFlow(ctx context.Context, f *flow, opt options, due func(time.Time) time.Time) *result {
+	res := &result{Errors: make(map[string]int)}
+	if !sleepUntil(ctx, due(f.Start)) {
+		return res
+	}
+	addr := opt.Addr
+	if addr == "" {
+		addr = f.Server.String()
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		res.DialErrors++
+		res.Errors[errKind(err)]++
+		return res
+	}
+	defer conn.Close()
+	res.Conns++
+
+	// The writer hands each message's due time to the reader, which
+	// matches replies to messages in order.
+	sent := make(chan message, len(f.Msgs))
+	replies := make(chan *result, 1)
+	go func() { replies <- readReplies(conn, opt.Proto, sent) }()
+
+	for _, m := range f.Msgs {
+		at := due(m.At)
+		if !sleepUntil(ctx, at) {
+			break
+		}
+		if _, err := conn.Write(m.Data); err != nil {
+			res.Errors[errKind(err)]++
+			break
+		}
+		res.Late = append(res.Late, time.Since(at))
+		res.Sent++
+		m.At = at
+		sent <- m
+	}
+	close(sent)
+	conn.SetReadDeadline(time.Now().Add(opt.Timeout))
+	res.merge(<-replies)
+	return res
+}
+
+// readReplies reads one reply per message received on sent. An echo
+// server returns exactly the bytes written, however it frames them; an
+// HTTP server returns one response per request, in order.
+func readReplies(conn net.Conn, proto string, sent <-chan message) *result {
+	res := &result{Errors: make(map[string]int)}
+	br := bufio.NewReaderSize(conn, 64<<10)
+	var buf []byte
+	for m := range sent {
+		var err error
+		switch proto {
+		case "http":
+			var resp *http.Response
+			if resp, err = http.ReadResponse(br, &http.Request{Method: m.Method}); err == nil {
+				_, err = io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		default:
+			if cap(buf) < len(m.Data) {
+				buf = make([]byte, len(m.Data))
+			}
+			_, err = io.ReadFull(br, buf[:len(m.Data)])
+		}
+		if err != nil {
+			// sent holds every message, so the writer never waits on
+			// a reader that gave up.
+			res.Errors[errKind(err)]++
+			return res
+		}
+		res.RTT = append(res.RTT, time.Since(m.At))
+		res.Replies++
+	}
+	return res
+}
+
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// errKind shortens an error to its last part, as loadgen does, so that
+// errors from different connections are counted together.
+func errKind(err error) string {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "timeout"
+	}
+	msg := err.Error()
+	if i := strings.LastIndex(msg, ": "); i >= 0 {
+		msg = msg[i+2:]
+	}
+	return msg
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pcapWriter builds captures for the tests in the classic format.
+type pcapWriter struct {
+	buf  bytes.Buffer
+	link uint32
+}
+
+func newPcapWriter(link uint32) *pcapWriter {
+	w := &pcapWriter{link: link}
+	h := make([]byte, 24)
+	binary.LittleEndian.PutUint32(h[0:], 0xa1b23c4d) // nanosecond timestamps
+	binary.LittleEndian.PutUint16(h[4:], 2)
+	binary.LittleEndian.PutUint16(h[6:], 4)
+	binary.LittleEndian.PutUint32(h[16:], 65535)
+	binary.LittleEndian.PutUint32(h[20:], link)
+	w.buf.Write(h)
+	return w
+}
+
+// packet writes a TCP segment from src to dst at t.
+func (w *pcapWriter) packet(t time.Time, src, dst string, seq uint32, flags uint8, payload string) {
+	s, d := netip.MustParseAddrPort(src), netip.MustParseAddrPort(dst)
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], s.Port())
+	binary.BigEndian.PutUint16(tcp[2:], d.Port())
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	tcp = append(tcp, payload...)
+
+	var ip []byte
+	ethertype := uint16(0x0800)
+	if s.Addr().Is4() {
+		ip = make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+		ip[9] = 6
+		a, b := s.Addr().As4(), d.Addr().As4()
+		copy(ip[12:], a[:])
+		copy(ip[16:], b[:])
+	} else {
+		ethertype = 0x86dd
+		ip = make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = 6
+		a, b := s.Addr().As16(), d.Addr().As16()
+		copy(ip[8:], a[:])
+		copy(ip[24:], b[:])
+	}
+	var link []byte
+	switch w.link {
+	case linkEthernet:
+		link = make([]byte, 14)
+		binary.BigEndian.PutUint16(link[12:], ethertype)
+	case linkSLL:
+		link = make([]byte, 16)
+		binary.BigEndian.PutUint16(link[14:], ethertype)
+	case linkNull:
+		link = make([]byte, 4)
+		binary.LittleEndian.PutUint32(link, map[uint16]uint32{0x0800: 2, 0x86dd: 30}[ethertype])
+	}
+	frame := append(append(link, ip...), tcp...)
+
+	rec := make([]byte, 16)
+	binary.LittleEndian.PutUint32(rec[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(t.Nanosecond()))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(len(frame)))
+	w.buf.Write(rec)
+	w.buf.Write(frame)
+}
+
+func readAll(t *testing.T, r io.Reader) []segment {
+	t.Helper()
+	pr, err := newPcapReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var segs []segment
+	for {
+		s, err := pr.next()
+		if err == io.EOF {
+			return segs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		segs = append(segs, s)
+	}
+}
+
+var t0 = time.Unix(1700000000, 0)
+
+func at(ms float64) time.Time { return t0.Add(time.Duration(ms * float64(time.Millisecond))) }
+
+func TestDecode(t *testing.T) {
+	for _, tc := range []struct {
+		link     uint32
+		src, dst string
+	}{
+		{linkEthernet, "10.0.0.1:40000", "10.0.0.2:9000"},
+		{linkSLL, "10.0.0.1:40000", "10.0.0.2:9000"},
+		{linkNull, "[2001:db8::1]:40000", "[2001:db8::2]:9000"},
+	} {
+		w := newPcapWriter(tc.link)
+		w.packet(at(1.5), tc.src, tc.dst, 7, flagACK, "hello\n")
+		segs := readAll(t, &w.buf)
+		if len(segs) != 1 {
+			t.Fatalf("link %d: %d segments", tc.link, len(segs))
+		}
+		s := segs[0]
+		if s.Src.String() != tc.src || s.Dst.String() != tc.dst || s.Seq != 7 || string(s.Payload) != "hello\n" || !s.At.Equal(at(1.5)) {
+			t.Errorf("link %d: decoded %+v", tc.link, s)
+		}
+	}
+	if _, err := newPcapReader(strings.NewReader("\x0a\x0d\x0d\x0a" + strings.Repeat("\x00", 20))); err == nil || !strings.Contains(err.Error(), "pcapng") {
+		t.Errorf("pcapng: %v", err)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	const cli, cli2, srv = "10.0.0.1:40000", "10.0.0.1:40001", "10.0.0.2:9000"
+	w := newPcapWriter(linkEthernet)
+	w.packet(at(0), cli, srv, 99, flagSYN, "")
+	w.packet(at(0.1), srv, cli, 500, flagSYN|flagACK, "")
+	w.packet(at(1), cli, srv, 100, flagACK, "one\n")
+	w.packet(at(1.2), srv, cli, 501, flagACK, "one\n") // the echo
+	w.packet(at(2), cli, srv, 108, flagACK, "three\n") // overtakes "two"
+	w.packet(at(2.1), cli, srv, 104, flagACK, "two\n")
+	w.packet(at(3), cli, srv, 100, flagACK, "one\ntw") // a retransmission
+	w.packet(at(4), cli, srv, 115, flagACK, "our\n")   // "f" was not captured
+	w.packet(at(5), cli, srv, 119, flagFIN|flagACK, "")
+	// A second client, caught mid-connection.
+	w.packet(at(4.5), cli2, srv, 7000, flagACK, "late\n")
+	// The first port reused for a new connection.
+	w.packet(at(7), cli, srv, 9000, flagSYN, "")
+	w.packet(at(8), cli, srv, 9001, flagACK, "again\n")
+
+	segs := readAll(t, &w.buf)
+	if p := serverPort(segs); p != 9000 {
+		t.Fatalf("server port %d", p)
+	}
+	c := assemble(segs, 9000)
+	if len(c.Flows) != 3 || c.Gaps != 1 {
+		t.Fatalf("%d flows, %d gaps; want 3, 1", len(c.Flows), c.Gaps)
+	}
+	// Stream order, each message no earlier than the bytes before it.
+	want := []struct {
+		at   time.Time
+		data string
+	}{{at(1), "one\n"}, {at(2.1), "two\n"}, {at(2.1), "three\n"}, {at(4), "our\n"}}
+	if msgs := c.Flows[0].Msgs; len(msgs) != len(want) {
+		t.Errorf("messages %+v", msgs)
+	} else {
+		for i, w := range want {
+			if string(msgs[i].Data) != w.data || !msgs[i].At.Equal(w.at) {
+				t.Errorf("message %d: %q at %v, want %q at %v", i, msgs[i].Data, msgs[i].At.Sub(t0), w.data, w.at.Sub(t0))
+			}
+		}
+	}
+	if f := c.Flows[0]; !f.Start.Equal(at(0)) || !f.End.Equal(at(5)) {
+		t.Errorf("flow spans %v to %v", f.Start.Sub(t0), f.End.Sub(t0))
+	}
+	if m := c.Flows[1].Msgs; len(m) != 1 || string(m[0].Data) != "late\n" {
+		t.Errorf("mid-connection flow: %+v", m)
+	}
+	if m := c.Flows[2].Msgs; len(m) != 1 || string(m[0].Data) != "again\n" || !c.Flows[2].Start.Equal(at(7)) {
+		t.Errorf("reused port: %+v", c.Flows[2])
+	}
+	if p := c.peak(); p != 2 {
+		t.Errorf("peak %d connections, want 2", p)
+	}
+}
+
+func TestSplitHTTP(t *testing.T) {
+	f := &flow{Msgs: []message{
+		{At: at(1), Data: []byte("POST /upload HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nab")},
+		{At: at(2), Data: []byte("cde")},
+		{At: at(3), Data: []byte("HEAD /fast HTTP/1.1\r\nHost: x\r\n\r\nGET /slow HTTP/1.1\r\nHo")},
+		{At: at(4), Data: []byte("st: x\r\n\r\n")},
+	}}
+	if err := f.splitHTTP(); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		at     time.Time
+		method string
+		prefix string
+	}{{at(1), "POST", "POST /upload"}, {at(3), "HEAD", "HEAD /fast"}, {at(3), "GET", "GET /slow"}}
+	if len(f.Msgs) != len(want) {
+		t.Fatalf("%d requests, want %d", len(f.Msgs), len(want))
+	}
+	for i, w := range want {
+		m := f.Msgs[i]
+		if !m.At.Equal(w.at) || m.Method != w.method || !strings.HasPrefix(string(m.Data), w.prefix) {
+			t.Errorf("request %d: %v %s %q", i, m.At.Sub(t0), m.Method, m.Data)
+		}
+	}
+	if !strings.HasSuffix(string(f.Msgs[0].Data), "\r\n\r\nabcde") {
+		t.Errorf("request body not kept: %q", f.Msgs[0].Data)
+	}
+}
+
+func echoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestReplayEcho(t *testing.T) {
+	const srv = "10.0.0.2:9000"
+	w := newPcapWriter(linkEthernet)
+	for i, cli := range []string{"10.0.0.1:40000", "10.0.0.1:40001"} {
+		w.packet(at(float64(i)*50), cli, srv, 0, flagSYN, "")
+		for j := range 5 {
+			w.packet(at(float64(i)*50+float64(j)*20+1), cli, srv, uint32(1+8*j), flagACK, "message\n")
+		}
+	}
+	path := filepath.Join(t.TempDir(), "echo.pcap")
+	if err := os.WriteFile(path, w.buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := load(path, 0, "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The capture spans 131ms; at double speed the replay takes about 65.
+	res := replay(context.Background(), c, options{Addr: echoServer(t), Proto: "echo", Speed: 2, Timeout: time.Second})
+	if res.Conns != 2 || res.Sent != 10 || res.Replies != 10 || len(res.Errors) != 0 {
+		t.Fatalf("result %+v", res)
+	}
+	if res.Elapsed < 60*time.Millisecond || res.Elapsed > time.Second {
+		t.Errorf("replay took %v, want about 65ms", res.Elapsed)
+	}
+
+	res = replay(context.Background(), c, options{Addr: echoServer(t), Proto: "echo", Speed: 0, Timeout: time.Second})
+	if res.Replies != 10 || res.Elapsed > 50*time.Millisecond {
+		t.Errorf("-speed 0: %d replies in %v", res.Replies, res.Elapsed)
+	}
+}
+
+func TestReplayHTTP(t *testing.T) {
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, "ok from "+r.URL.Path)
+	}))
+	defer hs.Close()
+
+	const cli, srv = "10.0.0.1:40000", "10.0.0.2:8080"
+	w := newPcapWriter(linkEthernet)
+	w.packet(at(0), cli, srv, 0, flagSYN, "")
+	reqs := "GET /a HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"HEAD /b HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"POST /c HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\nabc"
+	w.packet(at(1), cli, srv, 1, flagACK, reqs)
+	c := assemble(readAll(t, &w.buf), 8080)
+	if err := c.Flows[0].splitHTTP(); err != nil {
+		t.Fatal(err)
+	}
+
+	res := replay(context.Background(), c, options{Addr: hs.Listener.Addr().String(), Proto: "http", Speed: 1, Timeout: time.Second})
+	if res.Sent != 3 || res.Replies != 3 || len(res.Errors) != 0 {
+		t.Fatalf("result %+v", res)
+	}
+}
@@ -0,0 +1,79 @@
+// Command retrystorm shows client retries turning a short overload into a
+// lasting one, and what a retry budget and a circuit breaker from the
+// breaker package do about it.
+//
+// It starts an HTTP server that can serve -workers requests at a time,
+// each taking -service, so 400 requests per second with the defaults.
+// Requests beyond that queue, and the server serves a request even after
+// its client has given up on it. A client offers -rate requests per second
+// with a -timeout on each attempt, and raises that to -spike for
+// -spike-for starting at -spike-at:
+//
+//	go run ./retrystorm -mode retry
+//
+// -mode picks the client:
+//
+//	none     no retries
+//	retry    up to -retries retries of every failed attempt
+//	budget   the same, within a retry budget of -budget retries per request
+//	breaker  the budget plus a circuit breaker
+//
+// Every second it prints the requests offered and how they ended, and the
+// attempts that reached the server, the ones it served in time, the ones
+// it served for nobody (wasted) and its queue. With plain retries the
+// server is still failing long after the spike ends.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.Mode, "mode", "retry", "Client behavior: "+strings.Join(modes, ", "))
+	flag.IntVar(&cfg.Rate, "rate", 300, "Requests per second")
+	flag.IntVar(&cfg.Spike, "spike", 600, "Requests per second during the spike (0 = no spike)")
+	flag.DurationVar(&cfg.SpikeAt, "spike-at", 3*time.Second, "When the spike starts")
+	flag.DurationVar(&cfg.SpikeFor, "spike-for", 2*time.Second, "How long the spike lasts")
+	flag.DurationVar(&cfg.Duration, "duration", 15*time.Second, "Test duration")
+	flag.IntVar(&cfg.Workers, "workers", 4, "Requests the server serves at once")
+	flag.DurationVar(&cfg.Service, "service", 10*time.Millisecond, "Time the server spends on a request")
+	flag.DurationVar(&cfg.Timeout, "timeout", 100*time.Millisecond, "Client timeout per attempt")
+	flag.IntVar(&cfg.Retries, "retries", 3, "Retries after a failed attempt")
+	flag.Float64Var(&cfg.Budget, "budget", 0.1, "Retries allowed per request for -mode budget and breaker")
+	flag.Parse()
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	capacity := float64(cfg.Workers) / cfg.Service.Seconds()
+	fmt.Printf("mode=%s capacity=%.0f/s rate=%d/s spike=%d/s at %v for %v\n",
+		cfg.Mode, capacity, cfg.Rate, cfg.Spike, cfg.SpikeAt, cfg.SpikeFor)
+	var total second
+	tr, err := run(ctx, cfg, func(s second) {
+		fmt.Printf("t=%-3d offered=%-5d ok=%-5d failed=%-5d rejected=%-5d | attempts=%-5d served=%-5d wasted=%-5d queue=%d\n",
+			s.T, s.Offered, s.OK, s.Failed, s.Rejected, s.Attempts, s.Served, s.Wasted, s.Queue)
+		total.Offered += s.Offered
+		total.OK += s.OK
+		total.Attempts += s.Attempts
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	st := tr.Stats()
+	fmt.Printf("total: offered=%d ok=%d attempts=%d (%.2f per request) retries=%d denied=%d rejected=%d\n",
+		total.Offered, total.OK, total.Attempts, float64(total.Attempts)/float64(max(total.Offered, 1)),
+		st.Retries, st.Denied, st.Rejected)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDue(t *testing.T) {
+	cfg := config{Rate: 100, Spike: 300, SpikeAt: time.Second, SpikeFor: 2 * time.Second}
+	for _, c := range []struct {
+		t    time.Duration
+		want int64
+	}{
+		{500 * time.Millisecond, 50},
+		{time.Second, 100},
+		{2 * time.Second, 400},
+		{3 * time.Second, 700},
+		{4 * time.Second, 800},
+	} {
+		if got := cfg.due(c.t); got != c.want {
+			t.Errorf("due(%v) = %d, want %d", c.t, got, c.want)
+		}
+	}
+}
+
+// TestRetryStorm checks the experiment's claim on a small scale: after a
+// spike, plain retries keep the server failing while the breaker lets it
+// recover.
+func TestRetryStorm(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs for seconds")
+	}
+	cfg := config{Rate: 100, Spike: 300, SpikeAt: time.Second, SpikeFor: time.Second,
+		Duration: 4 * time.Second, Workers: 2, Service: 10 * time.Millisecond,
+		Timeout: 50 * time.Millisecond, Retries: 3, Budget: 0.1}
+	last := func(mode string) second {
+		c := cfg
+		c.Mode = mode
+		if err := c.validate(); err != nil {
+			t.Fatal(err)
+		}
+		var s second
+		if _, err := run(context.Background(), c, func(sec second) { s = sec }); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%s: last second %+v", mode, s)
+		return s
+	}
+	if s := last("retry"); s.OK*2 > s.Offered {
+		t.Errorf("retry: %d of %d ok two seconds after the spike, want the server still failing", s.OK, s.Offered)
+	}
+	if s := last("breaker"); s.OK*10 < s.Offered*9 {
+		t.Errorf("breaker: %d of %d ok two seconds after the spike, want it recovered", s.OK, s.Offered)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/breaker"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/internal/openloop"
+)
+
+var modes = []string{"none", "retry", "budget", "breaker"}
+
+// config is one run, as set by the flags.
+type config struct {
+	Mode     string
+	Rate     int           // requests per second outside the spike
+	Spike    int           // requests per second during it
+	SpikeAt  time.Duration // when the spike starts
+	SpikeFor time.Duration
+	Duration time.Duration
+	Workers  int
+	Service  time.Duration
+	Timeout  time.Duration // per attempt
+	Retries  int
+	Budget   float64 // retry budget ratio for -mode budget and breaker
+}
+
+func (c *config) validate() error {
+	switch {
+	case !slices.Contains(modes, c.Mode):
+		return fmt.Errorf("unknown -mode %q", c.Mode)
+	case c.Rate <= 0 || c.Spike < 0 || c.Duration <= 0:
+		return errors.New("-rate and -duration must be positive")
+	case c.Workers <= 0 || c.Service <= 0 || c.Timeout <= 0:
+		return errors.New("-workers, -service and -timeout must be positive")
+	case c.Retries < 0 || c.Budget < 0:
+		return errors.New("-retries and -budget must not be negative")
+	}
+	return nil
+}
+
+// due returns how many requests should have started by t: Rate per
+// second, and Spike per second between SpikeAt and SpikeAt+SpikeFor.
+func (c *config) due(t time.Duration) int64 {
+	n := t.Seconds() * float64(c.Rate)
+	if c.Spike > 0 && t > c.SpikeAt {
+		in := min(t, c.SpikeAt+c.SpikeFor) - c.SpikeAt
+		n += in.Seconds() * float64(c.Spike-c.Rate)
+	}
+	return int64(n)
+}
+
+// second is one line of the report. Offered counts the requests the
+// client started in second T, and OK, Failed and Rejected those that
+// finished in it, after any retries; a rejected request was refused by
+// the open breaker without an attempt.
+// Attempts, Served and Wasted are the server's counts for the second: the
+// attempts that reached it, and those it served, for a client still
+// waiting or for one that had given up. Queue is its backlog at the end.
+type second struct {
+	T                               int
+	Offered, OK, Failed, Rejected   int64
+	Attempts, Served, Wasted, Queue int64
+}
+
+// client builds the http.Client for cfg.Mode.
+func client(cfg config) (*http.Client, *breaker.Transport) {
+	base := &http.Transport{
+		// The server writes nothing until the request has been served, so
+		// this is the timeout of a whole attempt.
+		ResponseHeaderTimeout: cfg.Timeout,
+		MaxIdleConns:          4096,
+		MaxIdleConnsPerHost:   4096,
+	}
+	tr := &breaker.Transport{Base: base}
+	switch cfg.Mode {
+	case "retry":
+		tr.Retries = cfg.Retries
+	case "budget":
+		tr.Retries = cfg.Retries
+		tr.Budget = breaker.NewRetryBudget(cfg.Budget, 10)
+	case "breaker":
+		tr.Retries = cfg.Retries
+		tr.Budget = breaker.NewRetryBudget(cfg.Budget, 10)
+		tr.Breaker = breaker.New(breaker.Config{Window: time.Second, OpenFor: 500 * time.Millisecond})
+	}
+	return &http.Client{Transport: tr}, tr
+}
+
+// run offers load to a fresh server for cfg.Duration, rounded up to whole
+// seconds, and calls onSecond once a second.
+func run(ctx context.Context, cfg config, onSecond func(second)) (*breaker.Transport, error) {
+	srv, err := listen(cfg.Workers, cfg.Service)
+	if err != nil {
+		return nil, err
+	}
+	defer srv.Close()
+	c, tr := client(cfg)
+	defer c.CloseIdleConnections()
+	url := srv.URL()
+
+	seconds := int((cfg.Duration + time.Second - 1) / time.Second)
+	var mu sync.Mutex
+	var cur second
+	do := func() {
+		resp, err := c.Get(url)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case errors.Is(err, breaker.ErrOpen):
+			cur.Rejected++
+		case err != nil || resp.StatusCode != http.StatusOK:
+			cur.Failed++
+		default:
+			cur.OK++
+		}
+	}
+
+	var last second // server counters at the previous report
+	openloop.Run(ctx, seconds, cfg.due, do, func(t int, offered int64) {
+		mu.Lock()
+		s := cur
+		cur = second{}
+		mu.Unlock()
+		s.T, s.Offered = t, offered
+		s.Attempts = srv.arrivals.Load()
+		s.Served = srv.served.Load()
+		s.Wasted = srv.wasted.Load()
+		s.Queue = srv.queued.Load()
+		onSecond(second{
+			T: s.T, Offered: s.Offered, OK: s.OK, Failed: s.Failed, Rejected: s.Rejected,
+			Attempts: s.Attempts - last.Attempts, Served: s.Served - last.Served,
+			Wasted: s.Wasted - last.Wasted, Queue: s.Queue,
+		})
+		last = s
+	})
+	return tr, nil
+}
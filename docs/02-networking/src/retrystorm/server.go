@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// server is an HTTP service with fixed capacity: workers requests at a
+// time, each taking service. Requests beyond that wait in an unbounded
+// queue, and a request whose client has given up is still served when its
+// turn comes, as by a server that never checks. That wasted work is what
+// keeps an overloaded server overloaded.
+type server struct {
+	srv     *http.Server
+	ln      net.Listener
+	slots   chan struct{}
+	service time.Duration
+	stop    chan struct{}
+
+	arrivals, served, wasted, queued atomic.Int64
+}
+
+func listen(workers int, service time.Duration) (*server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &server{ln: ln, slots: make(chan struct{}, workers), service: service, stop: make(chan struct{})}
+	s.srv = &http.Server{Handler: s}
+	go s.srv.Serve(ln)
+	return s, nil
+}
+
+func (s *server) URL() string { return "http://" + s.ln.Addr().String() + "/" }
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.arrivals.Add(1)
+	s.queued.Add(1)
+	select {
+	case s.slots <- struct{}{}:
+	case <-s.stop:
+		return
+	}
+	s.queued.Add(-1)
+	time.Sleep(s.service)
+	<-s.slots
+	if r.Context().Err() != nil {
+		s.wasted.Add(1) // the client timed out while this waited
+		return
+	}
+	s.served.Add(1)
+	w.Write([]byte("ok\n"))
+}
+
+// Close drops the queue and closes every connection.
+func (s *server) Close() error {
+	close(s.stop)
+	return s.srv.Close()
+}
@@ -0,0 +1,235 @@
+package route
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/workload"
+)
+
+var sinkInt int
+
+// BenchmarkLookup looks up host names the way a front end does once per
+// accepted connection, cycling through the keys in random order so the
+// branch predictor cannot learn the sequence. Misses are names of the same
+// shape that are not routed.
+func BenchmarkLookup(b *testing.B) {
+	for _, n := range []int{16, 256, 4096} {
+		routes := hosts(n)
+		sorted := NewSorted(routes)
+		perfect, err := NewPerfect(routes)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		keys := make([]string, 0, 1024)
+		for k := range routes {
+			keys = append(keys, k)
+		}
+		for len(keys) < 1024 {
+			keys = append(keys, keys[:min(len(keys), 1024-len(keys))]...)
+		}
+		workload.Rand(1, 0).Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+		misses := make([]string, len(keys))
+		for i, k := range keys {
+			misses[i] = "x" + k
+		}
+		bufs := make([][]byte, len(keys))
+		for i, k := range keys {
+			bufs[i] = []byte(k)
+		}
+
+		for _, set := range []struct {
+			name string
+			keys []string
+		}{{"hit", keys}, {"miss", misses}} {
+			ks := set.keys
+			b.Run(fmt.Sprintf("n=%d/%s/map", n, set.name), func(b *testing.B) {
+				i := 0
+				for b.Loop() {
+					v := routes[ks[i&1023]]
+					sinkInt += v
+					i++
+				}
+			})
+			b.Run(fmt.Sprintf("n=%d/%s/sorted", n, set.name), func(b *testing.B) {
+				i := 0
+				for b.Loop() {
+					v, _ := sorted.Lookup(ks[i&1023])
+					sinkInt += v
+					i++
+				}
+			})
+			b.Run(fmt.Sprintf("n=%d/%s/perfect", n, set.name), func(b *testing.B) {
+				i := 0
+				for b.Loop() {
+					v, _ := perfect.Lookup(ks[i&1023])
+					sinkInt += v
+					i++
+				}
+			})
+		}
+
+		// The key usually arrives as bytes in the read buffer (SNI,
+		// Host header).
+		b.Run(fmt.Sprintf("n=%d/bytes/map", n), func(b *testing.B) {
+			i := 0
+			for b.Loop() {
+				v := routes[string(bufs[i&1023])]
+				sinkInt += v
+				i++
+			}
+		})
+		b.Run(fmt.Sprintf("n=%d/bytes/perfect", n), func(b *testing.B) {
+			i := 0
+			for b.Loop() {
+				v, _ := perfect.LookupBytes(bufs[i&1023])
+				sinkInt += v
+				i++
+			}
+		})
+	}
+}
+
+// BenchmarkLookupSkew looks up keys with realistic popularity instead of
+// cycling through all of them: a few hot host names take most of the
+// connections. Hot entries stay in the CPU caches, so a table far larger
+// than the caches can still look up at in-cache speed. The key sequence
+// is seeded, so every run and every table sees the same one.
+func BenchmarkLookupSkew(b *testing.B) {
+	const draws = 1 << 16
+	for _, n := range []int{4096, 1 << 18} {
+		routes := hosts(n)
+		names := make([]string, 0, n)
+		for k := range routes {
+			names = append(names, k)
+		}
+		slices.Sort(names) // map order differs run to run
+		perfect, err := NewPerfect(routes)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, theta := range []float64{0, 0.99} {
+			r := workload.Rand(1, 0)
+			next := func() int { return r.IntN(n) }
+			if theta > 0 {
+				z := workload.NewZipf(uint64(n), theta)
+				next = func() int { return int(z.Next(r)) }
+			}
+			keys := make([]string, draws)
+			for i := range keys {
+				keys[i] = names[next()]
+			}
+			b.Run(fmt.Sprintf("n=%d/theta=%v/map", n, theta), func(b *testing.B) {
+				i := 0
+				for b.Loop() {
+					sinkInt += routes[keys[i&(draws-1)]]
+					i++
+				}
+			})
+			b.Run(fmt.Sprintf("n=%d/theta=%v/perfect", n, theta), func(b *testing.B) {
+				i := 0
+				for b.Loop() {
+					v, _ := perfect.Lookup(keys[i&(draws-1)])
+					sinkInt += v
+					i++
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkBuild reports what each table costs to build at startup; B/op
+// approximates its memory footprint.
+func BenchmarkBuild(b *testing.B) {
+	routes := hosts(4096)
+	b.Run("map", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			m := make(map[string]int, len(routes))
+			for k, v := range routes {
+				m[k] = v
+			}
+		}
+	})
+	b.Run("sorted", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			NewSorted(routes)
+		}
+	})
+	b.Run("perfect", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := NewPerfect(routes); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkFront routes one connection per op through Front over loopback
+// TCP: the client dials, sends a request head for one of 4096 hosts and
+// reads the backend's reply to the close. The three tables differ only in
+// the lookup, which is what their difference in BenchmarkLookup is worth
+// on the accept path.
+func BenchmarkFront(b *testing.B) {
+	routes := hosts(4096)
+	handlers := make(map[string]Handler, len(routes))
+	reply := []byte("HTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n")
+	for k := range routes {
+		handlers[k] = func(c net.Conn) {
+			c.Write(reply)
+			c.Close()
+		}
+	}
+	sorted := NewSorted(handlers)
+	perfect, err := NewPerfect(handlers)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var reqs [][]byte
+	for k := range routes {
+		reqs = append(reqs, []byte("GET / HTTP/1.1\r\nHost: "+k+"\r\n\r\n"))
+	}
+	workload.Rand(1, 0).Shuffle(len(reqs), func(i, j int) { reqs[i], reqs[j] = reqs[j], reqs[i] })
+
+	for _, tc := range []struct {
+		name   string
+		lookup func([]byte) (Handler, bool)
+	}{
+		{"map", func(h []byte) (Handler, bool) { v, ok := handlers[string(h)]; return v, ok }},
+		{"sorted", func(h []byte) (Handler, bool) { return sorted.Lookup(string(h)) }},
+		{"perfect", perfect.LookupBytes},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer ln.Close()
+			go (&Front{Lookup: tc.lookup}).Serve(ln)
+			buf := make([]byte, 256)
+			i := 0
+			for b.Loop() {
+				c, err := net.Dial("tcp", ln.Addr().String())
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := c.Write(reqs[i%len(reqs)]); err != nil {
+					b.Fatal(err)
+				}
+				n, err := io.ReadFull(c, buf[:len(reply)])
+				c.Close()
+				if err != nil || !bytes.Equal(buf[:n], reply) {
+					b.Fatalf("reply %q: %v", buf[:n], err)
+				}
+				i++
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package route
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"time"
+)
+
+// Handler serves a connection Front has routed to it. Reads return the
+// request head Front read first, then the rest of what the client sends.
+type Handler func(net.Conn)
+
+// MaxHead is the most of a request head Front reads to find its Host
+// header.
+const MaxHead = 4 << 10
+
+var (
+	errHead   = errors.New("route: no Host header in the request head")
+	hostField = []byte("host:")
+	headEnd   = []byte("\r\n\r\n")
+
+	misdirected = []byte("HTTP/1.1 421 Misdirected Request\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+)
+
+// Front is a demultiplexing front end for HTTP/1: it routes every
+// connection it accepts by the Host header of the connection's first
+// request, looked up once per connection in a table that Lookup reads,
+// such as a Perfect's LookupBytes. The host is matched as the client sent
+// it, less any port. A connection whose host has no route gets a 421 and
+// is closed.
+type Front struct {
+	Lookup  func(host []byte) (Handler, bool)
+	Timeout time.Duration // to read the request head; 5s
+}
+
+// Serve accepts connections on ln and routes each on a goroutine of its
+// own, until Accept fails.
+func (f *Front) Serve(ln net.Listener) error {
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go f.route(conn, timeout)
+	}
+}
+
+func (f *Front) route(conn net.Conn, timeout time.Duration) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	r := bufio.NewReaderSize(conn, MaxHead)
+	host, err := peekHost(r)
+	var h Handler
+	ok := false
+	if err == nil {
+		h, ok = f.Lookup(host)
+	}
+	if !ok {
+		conn.Write(misdirected)
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	h(&headConn{conn, r})
+}
+
+// peekHost returns the Host header's value, without the port, from the
+// request head buffered in r. It reads until the head is complete but
+// consumes nothing, and the value it returns is only valid until r is
+// read.
+func peekHost(r *bufio.Reader) ([]byte, error) {
+	var head []byte
+	for {
+		b, _ := r.Peek(r.Buffered())
+		if i := bytes.Index(b, headEnd); i >= 0 {
+			head = b[:i+2]
+			break
+		}
+		if len(b) == r.Size() {
+			return nil, errHead
+		}
+		if _, err := r.Peek(len(b) + 1); err != nil {
+			return nil, err
+		}
+	}
+	// Skip the request line.
+	i := bytes.IndexByte(head, '\n')
+	for head = head[i+1:]; len(head) > 0; {
+		i := bytes.IndexByte(head, '\n')
+		line := bytes.TrimRight(head[:i], "\r")
+		head = head[i+1:]
+		if len(line) < len(hostField) || !bytes.EqualFold(line[:len(hostField)], hostField) {
+			continue
+		}
+		host := bytes.TrimSpace(line[len(hostField):])
+		if i := bytes.LastIndexByte(host, ':'); i >= 0 && bytes.IndexByte(host[i:], ']') < 0 {
+			host = host[:i]
+		}
+		return host, nil
+	}
+	return nil, errHead
+}
+
+// headConn reads what Front buffered before reading from the connection.
+type headConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *headConn) Read(p []byte) (int, error) { return c.r.Read(p) }
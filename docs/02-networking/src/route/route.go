@@ -0,0 +1,187 @@
+// Package route maps protocol or host names to backends for a
+// demultiplexing front end. Routes are known at startup and read on every
+// accepted connection, so the tables here are built once and never
+// modified: a sorted array searched by bisection, and a minimal perfect
+// hash. Both are compared with a plain map in BenchmarkLookup, and in
+// BenchmarkFront behind Front, a front end that routes HTTP/1 connections
+// by their Host header.
+package route
+
+import (
+	"errors"
+	"hash/maphash"
+	"slices"
+	"sort"
+)
+
+// Sorted is a read-only table searched by binary search. It has no hashing
+// cost and no per-entry overhead, and with a few dozen routes it touches
+// fewer cache lines than a map.
+type Sorted[V any] struct {
+	keys   []string
+	values []V
+}
+
+// NewSorted builds a Sorted table from routes.
+func NewSorted[V any](routes map[string]V) *Sorted[V] {
+	t := &Sorted[V]{keys: make([]string, 0, len(routes))}
+	for k := range routes {
+		t.keys = append(t.keys, k)
+	}
+	sort.Strings(t.keys)
+	t.values = make([]V, len(t.keys))
+	for i, k := range t.keys {
+		t.values[i] = routes[k]
+	}
+	return t
+}
+
+// Lookup returns the value for key.
+func (t *Sorted[V]) Lookup(key string) (V, bool) {
+	if i, ok := slices.BinarySearch(t.keys, key); ok {
+		return t.values[i], true
+	}
+	var zero V
+	return zero, false
+}
+
+// Len returns the number of routes.
+func (t *Sorted[V]) Len() int { return len(t.keys) }
+
+// Perfect is a read-only table with a minimal perfect hash: n keys occupy
+// exactly n slots and every lookup hashes once, reads one displacement and
+// compares one key. It uses the hash-and-displace construction: keys are
+// grouped into buckets by their hash, and each bucket gets a displacement
+// that moves all its keys to free slots.
+type Perfect[V any] struct {
+	seed   maphash.Seed
+	disp   []uint32 // displacement per bucket
+	keys   []string // by slot
+	values []V      // by slot
+}
+
+// bucketLoad is the average number of keys per bucket. Larger loads make
+// the table smaller and the construction slower.
+const bucketLoad = 4
+
+// NewPerfect builds a Perfect table from routes. Construction takes
+// expected linear time. If some bucket finds no displacement, it starts
+// over with a fresh seed, so in practice it does not fail.
+func NewPerfect[V any](routes map[string]V) (*Perfect[V], error) {
+	keys := make([]string, 0, len(routes))
+	for k := range routes {
+		keys = append(keys, k)
+	}
+	for range 8 {
+		t := &Perfect[V]{seed: maphash.MakeSeed()}
+		if t.build(keys) {
+			t.values = make([]V, len(t.keys))
+			for i, k := range t.keys {
+				t.values[i] = routes[k]
+			}
+			return t, nil
+		}
+	}
+	return nil, errors.New("route: no perfect hash found")
+}
+
+// reduce maps x onto [0, n) with a multiply instead of a division.
+func reduce(x uint32, n int) int {
+	return int((uint64(x) * uint64(n)) >> 32)
+}
+
+// slot returns the slot of a key with hash h under displacement d.
+func slot(h uint64, d uint32, n int) int {
+	// Mix d into the low half so every displacement gives an
+	// independent-looking position (the murmur3 finalizer).
+	x := h ^ (uint64(d) * 0x9e3779b97f4a7c15)
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	return reduce(uint32(x), n)
+}
+
+func (t *Perfect[V]) build(keys []string) bool {
+	n := len(keys)
+	nb := max((n+bucketLoad-1)/bucketLoad, 1)
+	t.disp = make([]uint32, nb)
+	t.keys = make([]string, n)
+	if n == 0 {
+		return true
+	}
+
+	hashes := make([]uint64, n)
+	buckets := make([][]int, nb)
+	for i, k := range keys {
+		hashes[i] = maphash.String(t.seed, k)
+		b := reduce(uint32(hashes[i]>>32), nb)
+		buckets[b] = append(buckets[b], i)
+	}
+	// Place the largest buckets first, while most slots are free.
+	order := make([]int, nb)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return len(buckets[order[i]]) > len(buckets[order[j]]) })
+
+	used := make([]bool, n)
+	slots := make([]int, 0, 16)
+	for _, b := range order {
+		if len(buckets[b]) == 0 {
+			break
+		}
+	search:
+		for d := uint32(0); d < 1<<20; d++ {
+			slots = slots[:0]
+			for _, i := range buckets[b] {
+				s := slot(hashes[i], d, n)
+				if used[s] || slices.Contains(slots, s) {
+					continue search
+				}
+				slots = append(slots, s)
+			}
+			t.disp[b] = d
+			for j, i := range buckets[b] {
+				used[slots[j]] = true
+				t.keys[slots[j]] = keys[i]
+			}
+			break
+		}
+		if len(slots) != len(buckets[b]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Lookup returns the value for key.
+func (t *Perfect[V]) Lookup(key string) (V, bool) {
+	var zero V
+	if len(t.keys) == 0 {
+		return zero, false
+	}
+	h := maphash.String(t.seed, key)
+	s := slot(h, t.disp[reduce(uint32(h>>32), len(t.disp))], len(t.keys))
+	if t.keys[s] != key {
+		return zero, false
+	}
+	return t.values[s], true
+}
+
+// LookupBytes is Lookup for a key still in a read buffer, such as a Host
+// header or TLS server name; it does not allocate.
+func (t *Perfect[V]) LookupBytes(key []byte) (V, bool) {
+	var zero V
+	if len(t.keys) == 0 {
+		return zero, false
+	}
+	h := maphash.Bytes(t.seed, key)
+	s := slot(h, t.disp[reduce(uint32(h>>32), len(t.disp))], len(t.keys))
+	if t.keys[s] != string(key) {
+		return zero, false
+	}
+	return t.values[s], true
+}
+
+// Len returns the number of routes.
+func (t *Perfect[V]) Len() int { return len(t.keys) }
@@ -0,0 +1,127 @@
+package route
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// hosts returns n distinct host names shaped like real virtual hosts.
+func hosts(n int) map[string]int {
+	m := make(map[string]int, n)
+	for i := range n {
+		m[fmt.Sprintf("svc-%d.eu-west-%d.internal.example.com", i, i%3)] = i
+	}
+	return m
+}
+
+type table interface {
+	Lookup(string) (int, bool)
+	Len() int
+}
+
+func TestTables(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 7, 100, 5000} {
+		routes := hosts(n)
+		p, err := NewPerfect(routes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, tab := range map[string]table{"sorted": NewSorted(routes), "perfect": p} {
+			if tab.Len() != n {
+				t.Errorf("%s n=%d: Len = %d", name, n, tab.Len())
+			}
+			for k, want := range routes {
+				if got, ok := tab.Lookup(k); !ok || got != want {
+					t.Fatalf("%s n=%d: Lookup(%q) = %d, %v, want %d", name, n, k, got, ok, want)
+				}
+			}
+			for _, miss := range []string{"", "example.com", "svc-0.eu-west-1.internal.example.com"} {
+				if _, ok := tab.Lookup(miss); ok {
+					t.Errorf("%s n=%d: Lookup(%q) found a route", name, n, miss)
+				}
+			}
+		}
+		for k, want := range routes {
+			if got, ok := p.LookupBytes([]byte(k)); !ok || got != want {
+				t.Fatalf("n=%d: LookupBytes(%q) = %d, %v", n, k, got, ok)
+			}
+		}
+	}
+}
+
+func TestPerfectIsMinimal(t *testing.T) {
+	p, err := NewPerfect(hosts(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.keys) != 1000 || len(p.disp) != 250 {
+		t.Fatalf("%d slots and %d buckets for 1000 keys, want 1000 and 250", len(p.keys), len(p.disp))
+	}
+}
+
+func TestLookupBytesDoesNotAllocate(t *testing.T) {
+	p, _ := NewPerfect(hosts(100))
+	key := []byte("svc-42.eu-west-0.internal.example.com")
+	if allocs := testing.AllocsPerRun(100, func() { p.LookupBytes(key) }); allocs != 0 {
+		t.Fatalf("LookupBytes allocates %v times", allocs)
+	}
+}
+
+// TestFront routes connections by host through a Perfect table and checks
+// each backend reads the request from its first byte.
+func TestFront(t *testing.T) {
+	backend := func(name string) Handler {
+		return func(c net.Conn) {
+			defer c.Close()
+			line, err := bufio.NewReader(c).ReadString('\n')
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(c, "%s %s", name, line)
+		}
+	}
+	p, err := NewPerfect(map[string]Handler{"a.example.com": backend("a"), "[::1]": backend("v6")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go (&Front{Lookup: p.LookupBytes}).Serve(ln)
+
+	for _, tc := range []struct{ req, want string }{
+		{"GET /x HTTP/1.1\r\nHost: a.example.com\r\n\r\n", "a GET /x HTTP/1.1\r\n"},
+		{"GET /y HTTP/1.1\r\nAccept: */*\r\nhOST:a.example.com:8080 \r\n\r\n", "a GET /y HTTP/1.1\r\n"},
+		{"GET / HTTP/1.1\r\nHost: [::1]:80\r\n\r\n", "v6 GET / HTTP/1.1\r\n"},
+		{"GET / HTTP/1.1\r\nHost: b.example.com\r\n\r\n", string(misdirected)},
+		{"GET / HTTP/1.1\r\n\r\n", string(misdirected)},
+		{"GET / HTTP/1.1\r\nHost: a.example.com\r\n" + strings.Repeat("X: y\r\n", MaxHead/6) + "\r\n", string(misdirected)},
+	} {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		// The head arrives in pieces, as it may off a real network.
+		for i := 0; i < len(tc.req); i += 7 {
+			c.Write([]byte(tc.req[i:min(i+7, len(tc.req))]))
+		}
+		got, err := io.ReadAll(c)
+		c.Close()
+		if err != nil && !errors.Is(err, syscall.ECONNRESET) {
+			t.Fatalf("%q: %v", tc.req, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.req, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"runtime/metrics"
+)
+
+// subHist returns a-b for two readings of the same cumulative histogram.
+func subHist(a, b *metrics.Float64Histogram) *metrics.Float64Histogram {
+	d := &metrics.Float64Histogram{Buckets: a.Buckets, Counts: make([]uint64, len(a.Counts))}
+	for i := range a.Counts {
+		d.Counts[i] = a.Counts[i] - b.Counts[i]
+	}
+	return d
+}
+
+// quantile returns the upper bound of the bucket holding quantile q, or 0
+// for an empty histogram. The last bucket is unbounded, so its lower bound
+// is returned instead.
+func quantile(h *metrics.Float64Histogram, q float64) float64 {
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	var seen uint64
+	for i, c := range h.Counts {
+		seen += c
+		if seen >= rank {
+			if hi := h.Buckets[i+1]; !math.IsInf(hi, 1) {
+				return hi
+			}
+			return h.Buckets[i]
+		}
+	}
+	return h.Buckets[len(h.Buckets)-1]
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"math"
+	"runtime/metrics"
+	"testing"
+)
+
+func TestQuantile(t *testing.T) {
+	h := &metrics.Float64Histogram{
+		Buckets: []float64{0, 1, 2, 4, math.Inf(1)},
+		Counts:  []uint64{50, 40, 9, 1},
+	}
+	for _, tt := range []struct {
+		q    float64
+		want float64
+	}{{0.5, 1}, {0.9, 2}, {0.99, 4}, {1, 4}} {
+		if got := quantile(h, tt.q); got != tt.want {
+			t.Errorf("quantile(%v) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+	if got := quantile(&metrics.Float64Histogram{Buckets: h.Buckets, Counts: make([]uint64, 4)}, 0.5); got != 0 {
+		t.Errorf("quantile of empty histogram = %v, want 0", got)
+	}
+}
+
+func TestSubHist(t *testing.T) {
+	a := &metrics.Float64Histogram{Buckets: []float64{0, 1, 2}, Counts: []uint64{5, 7}}
+	b := &metrics.Float64Histogram{Buckets: []float64{0, 1, 2}, Counts: []uint64{2, 7}}
+	d := subHist(a, b)
+	if d.Counts[0] != 3 || d.Counts[1] != 0 {
+		t.Fatalf("subHist counts = %v, want [3 0]", d.Counts)
+	}
+}
@@ -0,0 +1,167 @@
+// Command schedpressure shows how a crowded run queue delays a
+// goroutine-per-connection echo server even when the CPU is mostly idle.
+//
+// For each k in -k the command starts a child process running the echo
+// server next to k goroutines per P that all wake on the same -period tick
+// and spin for -work. The parent then drives the server with -conns
+// connections at a low rate and prints CPU utilization and scheduling
+// latency (runtime/metrics /sched/latencies:seconds) from the child next to
+// the round-trip percentiles seen by the client:
+//
+//	go run ./schedpressure -k 0,1,4,16,64 -work 50us -period 10ms
+//
+// Utilization grows only as k*work/period, but a connection goroutine that
+// becomes ready right after a tick queues behind every pressure goroutine on
+// its P. With -trace each child also writes an execution trace; the
+// scheduler latency profile in go tool trace shows the same queueing.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ks          = flag.String("k", "0,1,4,16,64", "Comma separated pressure goroutines per P, one run each")
+	work        = flag.Duration("work", 50*time.Microsecond, "CPU burned by each pressure goroutine per tick")
+	period      = flag.Duration("period", 10*time.Millisecond, "Tick period of the pressure goroutines")
+	conns       = flag.Int("conns", 50, "Client connections")
+	interval    = flag.Duration("interval", 5*time.Millisecond, "Delay between messages on each connection")
+	duration    = flag.Duration("duration", 5*time.Second, "Measurement time per run")
+	tracePrefix = flag.String("trace", "", "Write an execution trace per run to <prefix>-k<k>.out")
+	child       = flag.Int("child", -1, "Internal: run the server side with this k")
+)
+
+// measure starts the server for k in a child process, drives it and prints
+// one result row.
+func measure(exe string, k int) error {
+	cmd := exec.Command(exe, append(os.Args[1:len(os.Args):len(os.Args)], "-child", strconv.Itoa(k))...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+
+	out := bufio.NewScanner(stdout)
+	if !out.Scan() {
+		return fmt.Errorf("k=%d: server did not report its address", k)
+	}
+	rtts, err := drive(out.Text())
+	if err != nil {
+		return err
+	}
+
+	stdin.Close()
+	if !out.Scan() {
+		return fmt.Errorf("k=%d: server did not report", k)
+	}
+	var util, sched50, sched99 float64
+	if _, err := fmt.Sscan(out.Text(), &util, &sched50, &sched99); err != nil {
+		return fmt.Errorf("k=%d: %v", k, err)
+	}
+
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	pct := func(q float64) time.Duration { return rtts[int(q*float64(len(rtts)-1))] }
+	sec := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)).Round(time.Microsecond) }
+	fmt.Printf("%4d %6.1f %10v %10v %10v %10v %10v\n", k, util, sec(sched50), sec(sched99),
+		pct(0.5).Round(time.Microsecond), pct(0.99).Round(time.Microsecond), rtts[len(rtts)-1].Round(time.Microsecond))
+	return nil
+}
+
+// drive sends a line every -interval on each of -conns connections for
+// -duration and returns every round trip.
+func drive(addr string) ([]time.Duration, error) {
+	var (
+		mu   sync.Mutex
+		rtts []time.Duration
+		wg   sync.WaitGroup
+	)
+	clients := make([]net.Conn, *conns)
+	for i := range clients {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		defer c.Close()
+		clients[i] = c
+	}
+
+	deadline := time.Now().Add(*duration)
+	msg := []byte(strings.Repeat("x", 63) + "\n")
+	for i, c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Spread the connections over one interval.
+			time.Sleep(*interval * time.Duration(i) / time.Duration(len(clients)))
+			r := bufio.NewReader(c)
+			var local []time.Duration
+			for time.Now().Before(deadline) {
+				start := time.Now()
+				if _, err := c.Write(msg); err != nil {
+					break
+				}
+				if _, err := r.ReadSlice('\n'); err != nil {
+					break
+				}
+				local = append(local, time.Since(start))
+				time.Sleep(*interval)
+			}
+			mu.Lock()
+			rtts = append(rtts, local...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if len(rtts) == 0 {
+		return nil, fmt.Errorf("no round trips completed")
+	}
+	return rtts, nil
+}
+
+func main() {
+	flag.Parse()
+	if *child >= 0 {
+		server(*child)
+		return
+	}
+
+	var levels []int
+	for _, s := range strings.Split(*ks, ",") {
+		k, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || k < 0 {
+			log.Fatalf("invalid -k value %q", s)
+		}
+		levels = append(levels, k)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%4s %6s %10s %10s %10s %10s %10s\n", "k/P", "cpu%", "sched p50", "sched p99", "rtt p50", "rtt p99", "rtt max")
+	for _, k := range levels {
+		if err := measure(exe, k); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
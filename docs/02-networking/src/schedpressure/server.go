@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"runtime/trace"
+	"time"
+)
+
+// pressure keeps k goroutines per P that all become runnable on the same
+// period tick and then burn work of CPU each. Average utilization is
+// k*work/period, so with small work the CPU stays mostly idle while every
+// tick puts k goroutines in front of anything else that wakes up.
+func pressure(k int, work, period time.Duration) {
+	n := k * runtime.GOMAXPROCS(0)
+	start := time.Now().Truncate(period).Add(period)
+	for range n {
+		go func() {
+			time.Sleep(time.Until(start))
+			t := time.NewTicker(period)
+			for range t.C {
+				spin(work)
+			}
+		}()
+	}
+}
+
+// spin burns CPU for d without blocking or allocating.
+func spin(d time.Duration) {
+	for end := time.Now().Add(d); time.Now().Before(end); {
+	}
+}
+
+// echo is the goroutine-per-connection line echo server of echo-net.go.
+func echo(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadSlice('\n')
+				if err != nil {
+					return
+				}
+				if _, err := conn.Write(line); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// window is a runtime/metrics view of one measurement interval.
+type window struct {
+	s []metrics.Sample
+}
+
+var windowMetrics = []string{
+	"/sched/latencies:seconds",
+	"/cpu/classes/total:cpu-seconds",
+	"/cpu/classes/idle:cpu-seconds",
+}
+
+// readWindow forces a GC first: the runtime only folds CPU time into the
+// /cpu/classes metrics at the end of a GC cycle.
+func readWindow() window {
+	runtime.GC()
+	w := window{s: make([]metrics.Sample, len(windowMetrics))}
+	for i, name := range windowMetrics {
+		w.s[i].Name = name
+	}
+	metrics.Read(w.s)
+	return w
+}
+
+// report prints CPU utilization and scheduling latency percentiles for the
+// interval between from and to: "util p50 p99", in percent and seconds.
+func report(out io.Writer, from, to window) {
+	lat := subHist(to.s[0].Value.Float64Histogram(), from.s[0].Value.Float64Histogram())
+	total := to.s[1].Value.Float64() - from.s[1].Value.Float64()
+	idle := to.s[2].Value.Float64() - from.s[2].Value.Float64()
+	util := 0.0
+	if total > 0 {
+		util = 100 * (total - idle) / total
+	}
+	fmt.Fprintf(out, "%f %g %g\n", util, quantile(lat, 0.5), quantile(lat, 0.99))
+}
+
+// server runs the child side of one measurement: it prints its address,
+// serves until stdin is closed and then prints its report.
+func server(k int) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go echo(ln)
+	if k > 0 {
+		pressure(k, *work, *period)
+	}
+	if *tracePrefix != "" {
+		f, err := os.Create(fmt.Sprintf("%s-k%d.out", *tracePrefix, k))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		if err := trace.Start(f); err != nil {
+			log.Fatal(err)
+		}
+		defer trace.Stop()
+	}
+
+	// Let the pressure goroutines reach their first tick.
+	time.Sleep(2 * *period)
+	fmt.Println(ln.Addr())
+	from := readWindow()
+	io.Copy(io.Discard, os.Stdin)
+	report(os.Stdout, from, readWindow())
+}
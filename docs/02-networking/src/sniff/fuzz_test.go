@@ -0,0 +1,51 @@
+package sniff
+
+import (
+	"errors"
+	"testing"
+)
+
+// FuzzDetect checks that Detect never panics, decides within MaxPrefix
+// bytes, and never changes its mind once it has decided.
+func FuzzDetect(f *testing.F) {
+	for _, seed := range []string{
+		"\x16\x03\x01\x00\xa5\x01\x00\x00\xa1\x03\x03",
+		"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"POST /upload HTTP/1.0\r\n",
+		"PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n\x00\x00\x12\x04",
+		"PROXY TCP4 192.0.2.1 198.51.100.7 56324 443\r\n",
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c",
+		"SSH-2.0-Go\r\n",
+		"PUT",
+		"\x00\x00\x00\x10",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		p, err := Detect(b)
+		if err != nil {
+			if !errors.Is(err, ErrNeedMore) || p != Unknown {
+				t.Fatalf("Detect = %v, %v", p, err)
+			}
+			if len(b) >= MaxPrefix {
+				t.Fatalf("undecided after %d bytes", len(b))
+			}
+			return
+		}
+		// The decision must already hold for the shortest prefix that
+		// produced one, and for every extension.
+		for i := 1; i <= len(b); i++ {
+			q, err := Detect(b[:i])
+			if err == nil {
+				if q != p {
+					t.Fatalf("prefix of %d bytes detected %v, full input %v", i, q, p)
+				}
+				break
+			}
+		}
+		if q, err := Detect(append(b[:len(b):len(b)], 0xff)); err != nil || q != p {
+			t.Fatalf("extending input changed %v to %v, %v", p, q, err)
+		}
+	})
+}
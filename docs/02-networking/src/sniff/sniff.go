@@ -0,0 +1,156 @@
+// Package sniff identifies the protocol of a connection from its first
+// bytes, so one port can serve several protocols (TLS, plain HTTP, HTTP/2
+// with prior knowledge, PROXY protocol, SSH) and hand each connection to
+// the right server.
+package sniff
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"time"
+)
+
+// Protocol is a detected protocol.
+type Protocol int
+
+const (
+	Unknown Protocol = iota
+	TLS
+	HTTP1
+	HTTP2 // cleartext HTTP/2 with prior knowledge
+	ProxyV1
+	ProxyV2
+	SSH
+)
+
+var names = [...]string{"unknown", "tls", "http1", "http2", "proxy-v1", "proxy-v2", "ssh"}
+
+func (p Protocol) String() string {
+	if p < 0 || int(p) >= len(names) {
+		return "invalid"
+	}
+	return names[p]
+}
+
+// ErrNeedMore means the prefix matches more than one protocol, or is a
+// strict prefix of a signature; read more bytes and detect again.
+var ErrNeedMore = errors.New("sniff: need more data")
+
+// MaxPrefix is the most bytes Detect needs to reach a decision.
+const MaxPrefix = 24
+
+var (
+	http2Preface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+	proxyV1      = []byte("PROXY ")
+	proxyV2      = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	sshPrefix    = []byte("SSH-")
+	httpMethods  = [][]byte{
+		[]byte("GET "), []byte("HEAD "), []byte("POST "), []byte("PUT "), []byte("DELETE "),
+		[]byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "), []byte("TRACE "),
+	}
+)
+
+// Detect identifies the protocol from the first bytes of a connection. It
+// returns ErrNeedMore while the answer could still change; once it returns a
+// protocol, appending bytes never changes the result. Unknown is returned as
+// soon as no signature can match.
+func Detect(b []byte) (Protocol, error) {
+	if len(b) == 0 {
+		return Unknown, ErrNeedMore
+	}
+	more := false
+	check := func(sig []byte) bool {
+		n := min(len(b), len(sig))
+		if !bytes.Equal(b[:n], sig[:n]) {
+			return false
+		}
+		if n < len(sig) {
+			more = true
+			return false
+		}
+		return true
+	}
+
+	// TLS record header: handshake content type, then a 3.x version.
+	if b[0] == 0x16 {
+		if len(b) < 3 {
+			return Unknown, ErrNeedMore
+		}
+		if b[1] == 3 && b[2] <= 4 {
+			return TLS, nil
+		}
+		return Unknown, nil
+	}
+	switch {
+	case check(http2Preface):
+		return HTTP2, nil
+	case check(proxyV1):
+		return ProxyV1, nil
+	case check(proxyV2):
+		return ProxyV2, nil
+	case check(sshPrefix):
+		return SSH, nil
+	}
+	// "PRI " is also the prefix of the HTTP/2 preface, so methods are
+	// checked last.
+	for _, m := range httpMethods {
+		if check(m) {
+			return HTTP1, nil
+		}
+	}
+	if more {
+		return Unknown, ErrNeedMore
+	}
+	return Unknown, nil
+}
+
+// Conn is a connection whose first bytes were read for detection. Reads
+// return those bytes first.
+type Conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Read implements net.Conn.
+func (c *Conn) Read(p []byte) (int, error) {
+	if c.r != nil && c.r.Buffered() > 0 {
+		return c.r.Read(p)
+	}
+	c.r = nil
+	return c.Conn.Read(p)
+}
+
+// Peek reads from conn until Detect can decide, waiting at most timeout
+// (zero means no limit), and returns the protocol with a Conn that replays
+// the bytes it read. A client that sends fewer bytes than a signature and
+// then waits for the server, as SSH servers expect, is reported as Unknown
+// when the timeout expires.
+func Peek(conn net.Conn, timeout time.Duration) (Protocol, *Conn, error) {
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	c := &Conn{Conn: conn, r: bufio.NewReaderSize(conn, 64)}
+	for n := 1; ; {
+		b, err := c.r.Peek(n)
+		p, derr := Detect(b)
+		if derr == nil {
+			return p, c, nil
+		}
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				return Unknown, c, nil
+			}
+			return Unknown, c, err
+		}
+		if len(b) >= MaxPrefix {
+			break
+		}
+		// Look at everything already buffered, or wait for one more byte.
+		n = min(max(c.r.Buffered(), len(b)+1), MaxPrefix)
+	}
+	return Unknown, c, nil
+}
@@ -0,0 +1,71 @@
+package sniff
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Protocol
+		err   error
+	}{
+		{"\x16\x03\x01\x02\x00\x01", TLS, nil},
+		{"\x16\x03", Unknown, ErrNeedMore},
+		{"\x16\x09\x01", Unknown, nil},
+		{"GET / HTTP/1.1\r\n", HTTP1, nil},
+		{"OPTIONS * HTTP/1.1\r\n", HTTP1, nil},
+		{"PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", HTTP2, nil},
+		{"PRI * HTTP/2.0\r\n", Unknown, ErrNeedMore},
+		{"P", Unknown, ErrNeedMore},
+		{"PROXY TCP4 ", ProxyV1, nil},
+		{"\r\n\r\n\x00\r\nQUIT\n\x21", ProxyV2, nil},
+		{"SSH-2.0-OpenSSH_9.6\r\n", SSH, nil},
+		{"get / HTTP/1.1\r\n", Unknown, nil},
+		{"", Unknown, ErrNeedMore},
+	}
+	for _, tt := range tests {
+		p, err := Detect([]byte(tt.input))
+		if p != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("Detect(%q) = %v, %v; want %v, %v", tt.input, p, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestPeekReplaysPrefix(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		client.Write([]byte("GE"))
+		client.Write([]byte("T /index.html HTTP/1.1\r\n\r\n"))
+		client.Close()
+	}()
+
+	p, c, err := Peek(server, time.Second)
+	if err != nil || p != HTTP1 {
+		t.Fatalf("Peek = %v, %v", p, err)
+	}
+	body, err := io.ReadAll(c)
+	if err != nil || string(body) != "GET /index.html HTTP/1.1\r\n\r\n" {
+		t.Fatalf("replayed %q, %v", body, err)
+	}
+}
+
+func TestPeekTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("P"))
+
+	p, c, err := Peek(server, 20*time.Millisecond)
+	if err != nil || p != Unknown {
+		t.Fatalf("Peek = %v, %v; want unknown after timeout", p, err)
+	}
+	go client.Write([]byte("ING\n"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "PING\n" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package soak
+
+import "os"
+
+// countFDs returns the number of open file descriptors, not counting the
+// one used to list them.
+func countFDs() int {
+	d, err := os.Open("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return -1
+	}
+	return len(names) - 1
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package soak
+
+// countFDs is Linux only; elsewhere the fds metric is left out.
+func countFDs() int { return -1 }
@@ -0,0 +1,288 @@
+// Package soak watches a server over hours of steady load for the slow
+// leaks that a benchmark run never lasts long enough to see: a goroutine
+// left behind per failed connection, a map that only grows, an fd that is
+// never closed, a latency percentile that creeps up as some structure
+// degrades.
+//
+// A Monitor snapshots goroutines, open file descriptors, the live heap and
+// optionally latency percentiles from a telemetry.Ring at a fixed
+// interval. It appends every snapshot to soak.csv, writes a heap profile
+// every few snapshots so any two can be diffed with go tool pprof
+// -diff_base, and runs a trend test over a sliding window of each metric.
+// A metric is flagged when it rises steadily through the window and at
+// more than a minimum fraction of its level per hour, which separates a
+// leak from a noisy plateau.
+package soak
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/metrics"
+	"runtime/pprof"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/telemetry"
+)
+
+// Config controls a Monitor. Zero fields take the defaults noted.
+type Config struct {
+	Interval  time.Duration // between snapshots; 1m
+	Dir       string        // soak.csv and heap profiles; nothing is written if empty
+	HeapEvery int           // snapshots between heap profiles; 10
+	Window    int           // snapshots the trend test looks at; 30
+	Warmup    int           // snapshots ignored while load ramps up; 5
+	MinGrowth float64       // hourly growth, relative to the window's median, to flag; 0.05
+	MinZ      float64       // Mann-Kendall z-score to flag; 2.33 (1% level)
+
+	// Latency, if set, adds its p50 and p99 to each snapshot.
+	Latency *telemetry.Ring
+
+	// Logf reports drifts as they start and stop; log.Printf if nil.
+	Logf func(format string, args ...any)
+}
+
+// Snapshot is one reading of the process.
+type Snapshot struct {
+	At         time.Time
+	Goroutines int
+	FDs        int    // -1 where the platform cannot count them
+	HeapLive   uint64 // live heap bytes
+	HeapObj    uint64 // live heap objects
+	P50, P99   time.Duration
+}
+
+// Drift is a metric growing steadily through the window.
+type Drift struct {
+	Metric  string
+	Z       float64 // Mann-Kendall z-score
+	PerHour float64 // Theil-Sen slope, in the metric's unit
+	Growth  float64 // PerHour relative to the window's median
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s growing %+.3g/h (%+.1f%%/h, z=%.1f)", d.Metric, d.PerHour, 100*d.Growth, d.Z)
+}
+
+// metric is one tracked column of a Snapshot. ok reports whether the
+// snapshot has a value for it.
+type metric struct {
+	name string
+	get  func(*Snapshot) (v float64, ok bool)
+}
+
+var tracked = []metric{
+	{"goroutines", func(s *Snapshot) (float64, bool) { return float64(s.Goroutines), true }},
+	{"fds", func(s *Snapshot) (float64, bool) { return float64(s.FDs), s.FDs >= 0 }},
+	{"heap_live_bytes", func(s *Snapshot) (float64, bool) { return float64(s.HeapLive), true }},
+	{"heap_objects", func(s *Snapshot) (float64, bool) { return float64(s.HeapObj), true }},
+	{"p50_us", func(s *Snapshot) (float64, bool) { return float64(s.P50) / 1e3, s.P50 > 0 }},
+	{"p99_us", func(s *Snapshot) (float64, bool) { return float64(s.P99) / 1e3, s.P99 > 0 }},
+}
+
+// Monitor takes snapshots and flags drifting metrics. Observe and Drifts
+// are safe for concurrent use.
+type Monitor struct {
+	cfg Config
+
+	mu       sync.Mutex
+	seen     int        // snapshots observed, including warmup
+	window   []Snapshot // the last cfg.Window snapshots after warmup
+	drifting map[string]Drift
+	samples  []time.Duration
+}
+
+// New returns a Monitor for cfg. Call Run to start it.
+func New(cfg Config) *Monitor {
+	cfg.Interval = cmp.Or(cfg.Interval, time.Minute)
+	cfg.HeapEvery = cmp.Or(cfg.HeapEvery, 10)
+	cfg.Window = max(cmp.Or(cfg.Window, 30), 3)
+	cfg.Warmup = cmp.Or(cfg.Warmup, 5)
+	cfg.MinGrowth = cmp.Or(cfg.MinGrowth, 0.05)
+	cfg.MinZ = cmp.Or(cfg.MinZ, 2.33)
+	if cfg.Logf == nil {
+		cfg.Logf = log.Printf
+	}
+	return &Monitor{cfg: cfg, drifting: make(map[string]Drift)}
+}
+
+// Run snapshots the process every interval until ctx is done. It returns
+// early only if it cannot write to cfg.Dir.
+func (m *Monitor) Run(ctx context.Context) error {
+	var csv *os.File
+	if m.cfg.Dir != "" {
+		if err := os.MkdirAll(m.cfg.Dir, 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filepath.Join(m.cfg.Dir, "soak.csv"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		csv = f
+		writeHeader(csv)
+	}
+
+	tick := time.NewTicker(m.cfg.Interval)
+	defer tick.Stop()
+	for n := 0; ; n++ {
+		s := m.Take()
+		if csv != nil {
+			if err := writeRow(csv, &s); err != nil {
+				return err
+			}
+			if n%m.cfg.HeapEvery == 0 {
+				if err := writeHeap(filepath.Join(m.cfg.Dir, fmt.Sprintf("heap-%04d.pb.gz", n))); err != nil {
+					return err
+				}
+			}
+		}
+		m.Observe(s)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+		}
+	}
+}
+
+var heapMetrics = []string{"/gc/heap/live:bytes", "/gc/heap/objects:objects"}
+
+// Take reads the process's current state. It runs a GC first: between
+// cycles the heap only grows, and a window shorter than the GC period
+// would see that sawtooth as a leak. Once a minute the cost is noise.
+func (m *Monitor) Take() Snapshot {
+	runtime.GC()
+	ms := make([]metrics.Sample, len(heapMetrics))
+	for i, name := range heapMetrics {
+		ms[i].Name = name
+	}
+	metrics.Read(ms)
+	s := Snapshot{
+		At:         time.Now(),
+		Goroutines: runtime.NumGoroutine(),
+		FDs:        countFDs(),
+		HeapLive:   ms[0].Value.Uint64(),
+		HeapObj:    ms[1].Value.Uint64(),
+	}
+	if m.cfg.Latency != nil {
+		m.mu.Lock()
+		m.samples = m.cfg.Latency.Snapshot(m.samples[:0])
+		q := telemetry.Quantiles(m.samples, 0.5, 0.99)
+		m.mu.Unlock()
+		s.P50, s.P99 = q[0], q[1]
+	}
+	return s
+}
+
+// Observe adds s to the window, logs metrics that started or stopped
+// drifting, and returns the ones drifting now.
+func (m *Monitor) Observe(s Snapshot) []Drift {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seen++
+	if m.seen <= m.cfg.Warmup {
+		return nil
+	}
+	if len(m.window) == m.cfg.Window {
+		m.window = slices.Delete(m.window, 0, 1)
+	}
+	m.window = append(m.window, s)
+	if len(m.window) < m.cfg.Window {
+		return nil
+	}
+
+	for _, mt := range tracked {
+		d, ok := m.trend(mt)
+		_, was := m.drifting[mt.name]
+		switch {
+		case ok:
+			if !was {
+				m.cfg.Logf("soak: %v", d)
+			}
+			m.drifting[mt.name] = d
+		case was:
+			m.cfg.Logf("soak: %s stopped growing", mt.name)
+			delete(m.drifting, mt.name)
+		}
+	}
+	return m.driftsLocked()
+}
+
+// trend tests one metric over the window.
+func (m *Monitor) trend(mt metric) (Drift, bool) {
+	at := make([]time.Time, 0, len(m.window))
+	ys := make([]float64, 0, len(m.window))
+	for i := range m.window {
+		if v, ok := mt.get(&m.window[i]); ok {
+			at = append(at, m.window[i].At)
+			ys = append(ys, v)
+		}
+	}
+	if len(ys) < m.cfg.Window {
+		return Drift{}, false
+	}
+	d := Drift{Metric: mt.name, Z: mannKendall(ys), PerHour: senSlope(at, ys)}
+	if med := median(ys); med > 0 {
+		d.Growth = d.PerHour / med
+	}
+	return d, d.Z >= m.cfg.MinZ && d.Growth >= m.cfg.MinGrowth
+}
+
+// Drifts returns the metrics drifting as of the last snapshot.
+func (m *Monitor) Drifts() []Drift {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.driftsLocked()
+}
+
+func (m *Monitor) driftsLocked() []Drift {
+	out := make([]Drift, 0, len(m.drifting))
+	for _, d := range m.drifting {
+		out = append(out, d)
+	}
+	slices.SortFunc(out, func(a, b Drift) int { return cmp.Compare(a.Metric, b.Metric) })
+	return out
+}
+
+func writeHeader(w io.Writer) {
+	fmt.Fprint(w, "unix_s")
+	for _, mt := range tracked {
+		fmt.Fprintf(w, ",%s", mt.name)
+	}
+	fmt.Fprintln(w)
+}
+
+// writeRow appends s to the CSV, leaving missing values empty.
+func writeRow(w io.Writer, s *Snapshot) error {
+	fmt.Fprintf(w, "%d", s.At.Unix())
+	for _, mt := range tracked {
+		if v, ok := mt.get(s); ok {
+			fmt.Fprintf(w, ",%g", v)
+		} else {
+			fmt.Fprint(w, ",")
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+func writeHeap(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := pprof.Lookup("heap").WriteTo(f, 0); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,154 @@
+package soak
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMannKendall(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	noise := make([]float64, 40)
+	for i := range noise {
+		noise[i] = 100 + rng.NormFloat64()*10
+	}
+	leak := make([]float64, 40)
+	for i := range leak {
+		leak[i] = 100 + float64(i)/2 + rng.NormFloat64()*3
+	}
+	for _, tc := range []struct {
+		name   string
+		xs     []float64
+		lo, hi float64
+	}{
+		{"rising", []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 3, math.Inf(1)},
+		{"falling", []float64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, math.Inf(-1), -3},
+		{"flat", []float64{5, 5, 5, 5, 5, 5}, 0, 0},
+		{"steps", []float64{100, 100, 100, 101, 101, 101, 102, 102, 102, 103, 103, 103}, 3, math.Inf(1)},
+		{"noise", noise, -2.33, 2.33},
+		{"noisy leak", leak, 2.33, math.Inf(1)},
+	} {
+		if z := mannKendall(tc.xs); z < tc.lo || z > tc.hi {
+			t.Errorf("%s: z = %.2f, want in [%g, %g]", tc.name, z, tc.lo, tc.hi)
+		}
+	}
+}
+
+func TestSenSlope(t *testing.T) {
+	base := time.Unix(0, 0)
+	var at []time.Time
+	var ys []float64
+	for i := range 11 {
+		at = append(at, base.Add(time.Duration(i)*6*time.Minute))
+		ys = append(ys, 50+5*float64(i)) // 5 per 6 minutes
+	}
+	ys[3] = 1e6 // a spike a least-squares fit would chase
+	if got := senSlope(at, ys); math.Abs(got-50) > 1e-9 {
+		t.Errorf("slope %v/h, want 50/h", got)
+	}
+}
+
+// series feeds m one snapshot per minute from gen and returns the drifts
+// after the last one.
+func series(m *Monitor, start, n int, gen func(i int) Snapshot) []Drift {
+	var drifts []Drift
+	for i := start; i < start+n; i++ {
+		s := gen(i)
+		s.At = time.Unix(0, 0).Add(time.Duration(i) * time.Minute)
+		drifts = m.Observe(s)
+	}
+	return drifts
+}
+
+func TestObserve(t *testing.T) {
+	var logs []string
+	m := New(Config{Window: 20, Warmup: 5, Logf: func(format string, args ...any) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}})
+	rng := rand.New(rand.NewPCG(3, 4))
+	leaking := true
+	gen := func(i int) Snapshot {
+		g := 200 + rng.IntN(5) // connection churn
+		if leaking {
+			g += i / 2 // one goroutine left behind every other minute
+		}
+		return Snapshot{
+			Goroutines: g,
+			FDs:        -1,
+			HeapLive:   uint64(8<<20 + rng.IntN(1<<20)), // GC sawtooth, no trend
+			HeapObj:    uint64(50000 + rng.IntN(2000)),
+		}
+	}
+
+	if d := series(m, 0, 5, gen); d != nil {
+		t.Fatalf("drifts during warmup: %v", d)
+	}
+	d := series(m, 5, 40, gen)
+	if len(d) != 1 || d[0].Metric != "goroutines" {
+		t.Fatalf("drifts %v, want goroutines only", d)
+	}
+	if d[0].PerHour < 20 || d[0].PerHour > 40 {
+		t.Errorf("goroutines growing %.1f/h, want about 30/h", d[0].PerHour)
+	}
+	if len(logs) != 1 || !strings.Contains(logs[0], "goroutines growing") {
+		t.Errorf("logs %q", logs)
+	}
+
+	// The leak is fixed: once the window holds only the plateau the
+	// drift clears.
+	leaking = false
+	if d := series(m, 45, 20, func(i int) Snapshot { s := gen(i); s.Goroutines += 22; return s }); len(d) != 0 {
+		t.Errorf("drifts %v after the leak stopped", d)
+	}
+	if n := len(logs); n != 2 || !strings.Contains(logs[1], "goroutines stopped growing") {
+		t.Errorf("logs %q", logs)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	m := New(Config{Interval: 5 * time.Millisecond, Dir: dir, HeapEvery: 2, Logf: t.Logf})
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Millisecond)
+	defer cancel()
+	if err := m.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "soak.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < 3 || lines[0] != "unix_s,goroutines,fds,heap_live_bytes,heap_objects,p50_us,p99_us" {
+		t.Fatalf("soak.csv:\n%s", data)
+	}
+	if got := strings.Count(lines[1], ","); got != 6 {
+		t.Errorf("row %q has %d commas, want 6", lines[1], got)
+	}
+	profiles, _ := filepath.Glob(filepath.Join(dir, "heap-*.pb.gz"))
+	if want := (len(lines) - 1 + 1) / 2; len(profiles) != want {
+		t.Errorf("%d heap profiles for %d snapshots, want %d", len(profiles), len(lines)-1, want)
+	}
+}
+
+func TestCountFDs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fd counting is Linux only")
+	}
+	before := countFDs()
+	f, err := os.Open(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if got := countFDs(); got != before+1 {
+		t.Errorf("%d fds after opening one, had %d", got, before)
+	}
+}
@@ -0,0 +1,84 @@
+package soak
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// mannKendall returns the Mann-Kendall trend statistic of xs as a z-score.
+// It only looks at whether each later point is above or below each earlier
+// one, so a GC cycle or a burst of load that moves a few points a long way
+// counts no more than one that moves them a little. Ties, common for
+// integer gauges such as goroutine counts, reduce the variance as in the
+// standard correction. Under "no trend" z is roughly standard normal, so
+// z > 2.33 means growth at the 1% level.
+func mannKendall(xs []float64) float64 {
+	n := len(xs)
+	if n < 3 {
+		return 0
+	}
+	s := 0
+	for i := range n - 1 {
+		for j := i + 1; j < n; j++ {
+			switch {
+			case xs[j] > xs[i]:
+				s++
+			case xs[j] < xs[i]:
+				s--
+			}
+		}
+	}
+
+	v := float64(n*(n-1)*(2*n+5)) / 18
+	sorted := slices.Clone(xs)
+	slices.Sort(sorted)
+	for i := 0; i < n; {
+		j := i
+		for j < n && sorted[j] == sorted[i] {
+			j++
+		}
+		if t := j - i; t > 1 {
+			v -= float64(t*(t-1)*(2*t+5)) / 18
+		}
+		i = j
+	}
+	if v <= 0 {
+		return 0 // every value equal
+	}
+	switch {
+	case s > 0:
+		return float64(s-1) / math.Sqrt(v)
+	case s < 0:
+		return float64(s+1) / math.Sqrt(v)
+	}
+	return 0
+}
+
+// senSlope returns the Theil-Sen estimate of how fast ys changes per hour:
+// the median of the slopes between every pair of points. Like the trend
+// test it shrugs off outliers that a least-squares fit would chase.
+func senSlope(at []time.Time, ys []float64) float64 {
+	var slopes []float64
+	for i := range ys {
+		for j := i + 1; j < len(ys); j++ {
+			if dt := at[j].Sub(at[i]).Hours(); dt > 0 {
+				slopes = append(slopes, (ys[j]-ys[i])/dt)
+			}
+		}
+	}
+	return median(slopes)
+}
+
+func median(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	xs = slices.Clone(xs)
+	slices.Sort(xs)
+	n := len(xs)
+	if n%2 == 1 {
+		return xs[n/2]
+	}
+	return (xs[n/2-1] + xs[n/2]) / 2
+}
@@ -0,0 +1,217 @@
+//go:build linux
+
+// Command sockbuf compares kernel-autotuned TCP buffers with buffers pinned
+// through SO_RCVBUF/SO_SNDBUF across a range of round-trip times.
+//
+// A single TCP flow can never go faster than window/RTT, and the window is
+// bounded by the receive buffer. Pinning a buffer disables autotuning for
+// that socket, so a value that looks generous on a LAN caps throughput at
+// bdp = buffer/RTT once the path gets longer. Run as root; RTTs are applied
+// to loopback with netem:
+//
+//	go run ./sockbuf -rtts 0,1ms,10ms,50ms,100ms -bufs auto,64k,256k,1m,4m
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/netem"
+)
+
+var (
+	dev      = flag.String("dev", "lo", "Interface to shape with netem")
+	rttList  = flag.String("rtts", "0,1ms,10ms,50ms,100ms", "Comma separated RTTs to simulate")
+	bufList  = flag.String("bufs", "auto,64k,256k,1m,4m", "Comma separated buffer sizes; auto leaves autotuning on")
+	duration = flag.Duration("duration", 5*time.Second, "Transfer time per measurement")
+	loss     = flag.Float64("loss", 0, "Packet loss in percent applied together with the delay")
+)
+
+// result is one measurement.
+type result struct {
+	throughput float64 // bits per second
+	rcvbuf     int     // receiver SO_RCVBUF at the end of the transfer, as reported by the kernel
+	sndbuf     int     // sender SO_SNDBUF at the end of the transfer
+}
+
+// bufferControl sets SO_RCVBUF and SO_SNDBUF before the socket connects or
+// listens; set later, the window scale has already been negotiated.
+func bufferControl(size int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if size <= 0 {
+			return nil
+		}
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			if serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, size); serr != nil {
+				return
+			}
+			serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, size)
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}
+}
+
+func sockopt(conn net.Conn, opt int) int {
+	rc, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		return -1
+	}
+	v := -1
+	rc.Control(func(fd uintptr) {
+		v, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, opt)
+	})
+	return v
+}
+
+// measure runs one bulk transfer over loopback with the given buffer size
+// (0 means autotuned) on both ends. Cancelling ctx cuts the transfer short.
+func measure(ctx context.Context, size int, d time.Duration) (result, error) {
+	lc := net.ListenConfig{Control: bufferControl(size)}
+	ln, err := lc.Listen(ctx, "tcp", "127.0.0.1:0")
+	if err != nil {
+		return result{}, err
+	}
+	defer ln.Close()
+
+	type recv struct {
+		bytes  int64
+		rcvbuf int
+		err    error
+	}
+	done := make(chan recv, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- recv{err: err}
+			return
+		}
+		defer conn.Close()
+		n, err := io.Copy(io.Discard, conn)
+		done <- recv{bytes: n, rcvbuf: sockopt(conn, syscall.SO_RCVBUF), err: err}
+	}()
+
+	dialer := net.Dialer{Control: bufferControl(size)}
+	conn, err := dialer.DialContext(ctx, "tcp", ln.Addr().String())
+	if err != nil {
+		return result{}, err
+	}
+	chunk := make([]byte, 256<<10)
+	start := time.Now()
+	conn.SetWriteDeadline(start.Add(d))
+	stop := context.AfterFunc(ctx, func() { conn.SetWriteDeadline(time.Now()) })
+	defer stop()
+	for {
+		if _, err := conn.Write(chunk); err != nil {
+			break
+		}
+	}
+	sndbuf := sockopt(conn, syscall.SO_SNDBUF)
+	conn.Close()
+
+	r := <-done
+	if r.err != nil {
+		return result{}, r.err
+	}
+	if err := ctx.Err(); err != nil {
+		return result{}, err
+	}
+	elapsed := time.Since(start)
+	return result{
+		throughput: float64(r.bytes*8) / elapsed.Seconds(),
+		rcvbuf:     r.rcvbuf,
+		sndbuf:     sndbuf,
+	}, nil
+}
+
+func main() {
+	flag.Parse()
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if err := run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run measures every buffer size at every RTT. It returns when done, on
+// the first error or when ctx is cancelled, and in every case takes the
+// netem qdisc off the device before it does.
+func run(ctx context.Context) error {
+	rtts, err := parseDurations(*rttList)
+	if err != nil {
+		return err
+	}
+	bufs, err := parseSizes(*bufList)
+	if err != nil {
+		return err
+	}
+	shaping := false
+	for _, rtt := range rtts {
+		shaping = shaping || rtt > 0
+	}
+	if shaping {
+		if err := netem.Available(); err != nil {
+			return err
+		}
+		defer netem.Clear(*dev)
+	}
+
+	fmt.Printf("tcp_rmem: %s\ntcp_wmem: %s\n\n", sysctl("net/ipv4/tcp_rmem"), sysctl("net/ipv4/tcp_wmem"))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "rtt\tbuffer\tceiling Mbit/s\tmeasured Mbit/s\trcvbuf\tsndbuf\t")
+	for _, rtt := range rtts {
+		if rtt > 0 {
+			cfg := netem.ForRTT(rtt)
+			cfg.Loss = *loss
+			// Leave room for a full high-BDP window in the netem queue.
+			cfg.Limit = 100000
+			if _, err := netem.Apply(*dev, cfg); err != nil {
+				return err
+			}
+		} else if shaping {
+			netem.Clear(*dev)
+		}
+		for _, size := range bufs {
+			r, err := measure(ctx, size, *duration)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(tw, "%v\t%s\t%s\t%.0f\t%s\t%s\t\n", rtt, formatSize(size),
+				ceiling(size, rtt), r.throughput/1e6, formatSize(r.rcvbuf), formatSize(r.sndbuf))
+		}
+		tw.Flush()
+	}
+	return nil
+}
+
+// ceiling is the window/RTT bound for a pinned buffer. Linux doubles the
+// requested SO_RCVBUF and, with the default tcp_adv_win_scale, offers about
+// half of that as window, so the usable window is roughly the requested size.
+func ceiling(size int, rtt time.Duration) string {
+	if size <= 0 || rtt <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f", float64(size*8)/rtt.Seconds()/1e6)
+}
+
+func sysctl(name string) string {
+	data, err := os.ReadFile("/proc/sys/" + name)
+	if err != nil {
+		return "n/a"
+	}
+	return strings.Join(strings.Fields(string(data)), " ")
+}
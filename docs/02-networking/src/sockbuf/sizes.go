@@ -0,0 +1,73 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseSizes parses "auto,64k,1m" into byte counts; auto becomes 0.
+func parseSizes(list string) ([]int, error) {
+	var out []int
+	for _, s := range strings.Split(list, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" {
+			continue
+		}
+		if s == "auto" {
+			out = append(out, 0)
+			continue
+		}
+		mult := 1
+		switch {
+		case strings.HasSuffix(s, "k"):
+			mult, s = 1<<10, strings.TrimSuffix(s, "k")
+		case strings.HasSuffix(s, "m"):
+			mult, s = 1<<20, strings.TrimSuffix(s, "m")
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("bad buffer size %q", s)
+		}
+		out = append(out, n*mult)
+	}
+	return out, nil
+}
+
+// parseDurations parses "0,1ms,50ms"; a bare 0 means no added delay.
+func parseDurations(list string) ([]time.Duration, error) {
+	var out []time.Duration
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if s == "0" {
+			out = append(out, 0)
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+func formatSize(n int) string {
+	switch {
+	case n == 0:
+		return "auto"
+	case n < 0:
+		return "?"
+	case n%(1<<20) == 0:
+		return strconv.Itoa(n>>20) + "M"
+	case n%(1<<10) == 0:
+		return strconv.Itoa(n>>10) + "K"
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,47 @@
+//go:build linux
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSizes(t *testing.T) {
+	got, err := parseSizes("auto, 64k,1M,1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{0, 64 << 10, 1 << 20, 1000}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	if _, err := parseSizes("12q"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestParseDurations(t *testing.T) {
+	got, err := parseDurations("0,10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != 0 || got[1] != 10*time.Millisecond {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestCeiling(t *testing.T) {
+	// 1MB over 100ms: 8 Mbit / 0.1s = ~84 Mbit/s
+	if got := ceiling(1<<20, 100*time.Millisecond); got != "84" {
+		t.Fatalf("ceiling = %s, want 84", got)
+	}
+	if got := ceiling(0, time.Millisecond); got != "-" {
+		t.Fatalf("ceiling for autotuned = %s, want -", got)
+	}
+}
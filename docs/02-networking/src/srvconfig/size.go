@@ -0,0 +1,42 @@
+package srvconfig
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Size is a byte count as a flag value: a plain number, or one with a k or
+// m suffix for KiB and MiB.
+type Size int
+
+func (s *Size) String() string {
+	if s == nil {
+		return "0"
+	}
+	switch n := int(*s); {
+	case n != 0 && n%(1<<20) == 0:
+		return strconv.Itoa(n>>20) + "m"
+	case n != 0 && n%(1<<10) == 0:
+		return strconv.Itoa(n>>10) + "k"
+	default:
+		return strconv.Itoa(n)
+	}
+}
+
+func (s *Size) Set(v string) error {
+	v = strings.ToLower(strings.TrimSpace(v))
+	mult := 1
+	switch {
+	case strings.HasSuffix(v, "k"):
+		mult, v = 1<<10, strings.TrimSuffix(v, "k")
+	case strings.HasSuffix(v, "m"):
+		mult, v = 1<<20, strings.TrimSuffix(v, "m")
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return fmt.Errorf("bad size %q", v)
+	}
+	*s = Size(n * mult)
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build unix
+
+package srvconfig
+
+import "syscall"
+
+func setsockoptInt(fd uintptr, opt, v int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, opt, v)
+}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package srvconfig
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	c := &Config{Addr: "127.0.0.1:0", SocketRecv: 64 << 10, SocketSend: 32 << 10}
+	ln, err := c.Listen("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	rc, err := ln.(interface {
+		SyscallConn() (syscall.RawConn, error)
+	}).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Control(func(fd uintptr) {
+		// Linux reports twice what was set, for its bookkeeping; other
+		// systems report the value itself.
+		for opt, want := range map[int]int{syscall.SO_RCVBUF: c.SocketRecv, syscall.SO_SNDBUF: c.SocketSend} {
+			got, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want && got != 2*want {
+				t.Errorf("option %d is %d, want %d", opt, got, want)
+			}
+		}
+	})
+}
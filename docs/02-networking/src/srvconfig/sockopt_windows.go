@@ -0,0 +1,7 @@
+package srvconfig
+
+import "syscall"
+
+func setsockoptInt(fd uintptr, opt, v int) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, opt, v)
+}
@@ -0,0 +1,227 @@
+// Package srvconfig is the configuration the example servers share: where
+// they listen, how big their buffers are, how long a connection may sit
+// idle, how many connections they hold open, and how many threads and
+// event loops they run.
+//
+// Each server registers the flags for the settings it has, with its own
+// defaults, and Apply fills in every flag not given on the command line
+// from the environment: -read-buffer from SRV_READ_BUFFER, -codec from
+// SRV_CODEC. Two servers run side by side on different -addr values, and a
+// benchmark script sweeps a parameter by exporting it, without editing the
+// code.
+package srvconfig
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/connlimit"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/debugsrv"
+)
+
+// Config holds the shared settings. The zero value of a setting keeps the
+// default of whatever it configures, except for Addr and ReadBuffer,
+// which a server always sets.
+type Config struct {
+	// Addr is the address to listen on, host:port. An empty host listens
+	// on every address.
+	Addr string
+
+	// ReadBuffer is the size of a read buffer, and the most one read
+	// takes.
+	ReadBuffer int
+
+	// SocketRecv and SocketSend set SO_RCVBUF and SO_SNDBUF on the
+	// listening socket, which accepted sockets inherit. Zero leaves the
+	// kernel's autotuning on; a value pins the buffer and turns it off
+	// for that socket, as the sockbuf command measures.
+	SocketRecv, SocketSend int
+
+	// IdleTimeout closes a connection that has sent nothing for this long.
+	IdleTimeout time.Duration
+
+	// WriteTimeout fails a write the peer has not made room for in this
+	// long, for servers whose writes block.
+	WriteTimeout time.Duration
+
+	// Procs sets GOMAXPROCS.
+	Procs int
+
+	// Reactors is the number of event loops, for servers that have them.
+	Reactors int
+
+	// MaxConns caps the connections open at once, and OverLimit is what
+	// happens to the ones past it (see the connlimit package). Zero
+	// accepts every connection.
+	MaxConns  int
+	OverLimit connlimit.Mode
+
+	// DebugAddr serves pprof, expvar and runtime/metrics on a separate
+	// listener (see the debugsrv package). Empty serves nothing.
+	DebugAddr string
+
+	fields Field
+}
+
+// Field selects the settings a server registers flags for. Procs and
+// DebugAddr are always registered: every server has a GOMAXPROCS and a
+// profile worth taking.
+type Field uint
+
+const (
+	Addr          Field = 1 << iota // -addr
+	ReadBuffer                      // -read-buffer
+	SocketBuffers                   // -so-rcvbuf and -so-sndbuf
+	IdleTimeout                     // -idle
+	WriteTimeout                    // -write-timeout
+	Reactors                        // -reactors
+	MaxConns                        // -max-conns and -over-limit
+)
+
+// EnvPrefix starts the name of every environment variable Apply reads.
+const EnvPrefix = "SRV_"
+
+// EnvVar returns the environment variable for the flag name: the name
+// upper-cased, with dashes turned into underscores, after EnvPrefix.
+func EnvVar(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Register defines the flags for fields on fs, with def's values as their
+// defaults, and returns the Config they set. Call Apply once fs is parsed.
+func Register(fs *flag.FlagSet, def Config, fields Field) *Config {
+	c := def
+	c.fields = fields
+	if fields&Addr != 0 {
+		fs.StringVar(&c.Addr, "addr", def.Addr, "Address to listen on, host:port; an empty host listens on every address")
+	}
+	if fields&ReadBuffer != 0 {
+		fs.Var((*Size)(&c.ReadBuffer), "read-buffer", "Size of a read buffer, and the most one read takes, e.g. 4096 or 64k")
+	}
+	if fields&SocketBuffers != 0 {
+		fs.Var((*Size)(&c.SocketRecv), "so-rcvbuf", "SO_RCVBUF for the listener and the sockets it accepts (0 leaves autotuning on)")
+		fs.Var((*Size)(&c.SocketSend), "so-sndbuf", "SO_SNDBUF for the listener and the sockets it accepts (0 leaves autotuning on)")
+	}
+	if fields&IdleTimeout != 0 {
+		fs.DurationVar(&c.IdleTimeout, "idle", def.IdleTimeout, "Close connections that send nothing for this long (0 disables)")
+	}
+	if fields&WriteTimeout != 0 {
+		fs.DurationVar(&c.WriteTimeout, "write-timeout", def.WriteTimeout, "Fail a write the client makes no room for in this long (0 disables)")
+	}
+	fs.IntVar(&c.Procs, "procs", def.Procs, "GOMAXPROCS (0 leaves the runtime's choice)")
+	fs.StringVar(&c.DebugAddr, "debug-addr", def.DebugAddr, debugsrv.Usage)
+	if fields&Reactors != 0 {
+		fs.IntVar(&c.Reactors, "reactors", def.Reactors, "Event loops, each with its own poller and listening socket")
+	}
+	if fields&MaxConns != 0 {
+		fs.IntVar(&c.MaxConns, "max-conns", def.MaxConns, "Connections open at once (0 is unlimited)")
+		fs.Var(&c.OverLimit, "over-limit", "At -max-conns: pause (stop accepting; clients wait in the listen backlog) or reject (accept and close with a busy reply)")
+	}
+	return &c
+}
+
+// Apply runs once fs has been parsed. It sets every flag on fs that the
+// command line left alone, the server's own as well as the shared ones,
+// from its environment variable, checks the result, sets GOMAXPROCS, and
+// starts the debug listener.
+// The command line wins over the environment, which wins over the
+// defaults.
+func (c *Config) Apply(fs *flag.FlagSet) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] {
+			return
+		}
+		if v, ok := os.LookupEnv(EnvVar(f.Name)); ok {
+			if err := fs.Set(f.Name, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s=%q: %w", EnvVar(f.Name), v, err))
+			}
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	if err := c.check(); err != nil {
+		return err
+	}
+	if c.Procs > 0 {
+		runtime.GOMAXPROCS(c.Procs)
+	}
+	if c.DebugAddr != "" {
+		return debugsrv.Listen(c.DebugAddr)
+	}
+	return nil
+}
+
+func (c *Config) check() error {
+	switch {
+	case c.fields&Addr != 0 && c.Addr == "":
+		return errors.New("srvconfig: -addr is empty")
+	case c.fields&ReadBuffer != 0 && c.ReadBuffer <= 0:
+		return fmt.Errorf("srvconfig: -read-buffer %d is not positive", c.ReadBuffer)
+	case c.SocketRecv < 0 || c.SocketSend < 0:
+		return errors.New("srvconfig: negative socket buffer size")
+	case c.IdleTimeout < 0 || c.WriteTimeout < 0:
+		return errors.New("srvconfig: negative timeout")
+	case c.Procs < 0:
+		return fmt.Errorf("srvconfig: -procs %d is negative", c.Procs)
+	case c.fields&Reactors != 0 && c.Reactors < 1:
+		return fmt.Errorf("srvconfig: -reactors %d, need at least one", c.Reactors)
+	case c.MaxConns < 0:
+		return fmt.Errorf("srvconfig: -max-conns %d is negative", c.MaxConns)
+	}
+	return nil
+}
+
+// Control sets the socket buffers on a socket before it binds, as a
+// net.ListenConfig or net.Dialer Control function. The window scale is
+// negotiated in the handshake, so a buffer set later cannot raise it.
+func (c *Config) Control(network, address string, rc syscall.RawConn) error {
+	var serr error
+	if err := rc.Control(func(fd uintptr) { serr = c.SetBuffers(fd) }); err != nil {
+		return err
+	}
+	return serr
+}
+
+// SetBuffers sets SO_RCVBUF and SO_SNDBUF on fd, those that are not zero,
+// for servers that open their sockets themselves.
+func (c *Config) SetBuffers(fd uintptr) error {
+	if c.SocketRecv > 0 {
+		if err := setsockoptInt(fd, syscall.SO_RCVBUF, c.SocketRecv); err != nil {
+			return fmt.Errorf("SO_RCVBUF %d: %w", c.SocketRecv, err)
+		}
+	}
+	if c.SocketSend > 0 {
+		if err := setsockoptInt(fd, syscall.SO_SNDBUF, c.SocketSend); err != nil {
+			return fmt.Errorf("SO_SNDBUF %d: %w", c.SocketSend, err)
+		}
+	}
+	return nil
+}
+
+// Listen listens on c.Addr with the socket buffers set.
+func (c *Config) Listen(network string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: c.Control}
+	return lc.Listen(context.Background(), network, c.Addr)
+}
+
+// Limit wraps ln in a connlimit.Listener for MaxConns, one that sends
+// reply to the connections it rejects, or returns ln as it is if MaxConns
+// is zero.
+func (c *Config) Limit(ln net.Listener, reply []byte) net.Listener {
+	if c.MaxConns == 0 {
+		return ln
+	}
+	return connlimit.NewListener(ln, connlimit.New(c.MaxConns), c.OverLimit, reply)
+}
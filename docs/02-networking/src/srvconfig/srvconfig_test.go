@@ -0,0 +1,171 @@
+package srvconfig
+
+import (
+	"flag"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/connlimit"
+)
+
+var def = Config{Addr: ":9000", ReadBuffer: 4096, IdleTimeout: 5 * time.Minute, Reactors: 1}
+
+func parse(t *testing.T, fields Field, args ...string) (*Config, *flag.FlagSet, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	c := Register(fs, def, fields)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return c, fs, c.Apply(fs)
+}
+
+func TestPrecedence(t *testing.T) {
+	t.Setenv("SRV_ADDR", ":9100")
+	t.Setenv("SRV_READ_BUFFER", "64k")
+	t.Setenv("SRV_IDLE", "30s")
+	c, _, err := parse(t, Addr|ReadBuffer|IdleTimeout, "-idle", "1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Addr != ":9100" || c.ReadBuffer != 64<<10 {
+		t.Errorf("environment not applied: %+v", c)
+	}
+	if c.IdleTimeout != time.Minute {
+		t.Errorf("idle %v; the command line should win over SRV_IDLE", c.IdleTimeout)
+	}
+	if c.Reactors != 1 {
+		t.Errorf("reactors %d, want the default", c.Reactors)
+	}
+}
+
+func TestUnregistered(t *testing.T) {
+	t.Setenv("SRV_REACTORS", "4")
+	c, fs, err := parse(t, Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.Lookup("reactors") != nil || fs.Lookup("read-buffer") != nil {
+		t.Error("flags registered for fields not asked for")
+	}
+	if fs.Lookup("procs") == nil {
+		t.Error("no -procs")
+	}
+	if c.Reactors != 1 {
+		t.Errorf("reactors %d from an unregistered flag", c.Reactors)
+	}
+}
+
+// The server's own flags take their environment variables too.
+func TestServerFlags(t *testing.T) {
+	t.Setenv("SRV_CODEC", "jsonl")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	codec := fs.String("codec", "", "")
+	c := Register(fs, def, Addr)
+	fs.Parse(nil)
+	if err := c.Apply(fs); err != nil {
+		t.Fatal(err)
+	}
+	if *codec != "jsonl" {
+		t.Errorf("codec %q", *codec)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, tc := range []struct{ env, val, want string }{
+		{"SRV_READ_BUFFER", "12q", "SRV_READ_BUFFER"},
+		{"SRV_READ_BUFFER", "0", "read-buffer"},
+		{"SRV_IDLE", "soon", "SRV_IDLE"},
+		{"SRV_REACTORS", "0", "reactors"},
+		{"SRV_PROCS", "-1", "procs"},
+		{"SRV_MAX_CONNS", "-5", "max-conns"},
+		{"SRV_OVER_LIMIT", "drop", "SRV_OVER_LIMIT"},
+	} {
+		t.Run(tc.env+"="+tc.val, func(t *testing.T) {
+			t.Setenv(tc.env, tc.val)
+			_, _, err := parse(t, ReadBuffer|IdleTimeout|Reactors|MaxConns)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error %v, want one naming %s", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	t.Setenv("SRV_MAX_CONNS", "100")
+	t.Setenv("SRV_OVER_LIMIT", "reject")
+	c, _, err := parse(t, MaxConns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.MaxConns != 100 || c.OverLimit != connlimit.Reject {
+		t.Errorf("max-conns %d, over-limit %v", c.MaxConns, c.OverLimit)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if _, ok := c.Limit(ln, nil).(*connlimit.Listener); !ok {
+		t.Error("listener not limited")
+	}
+	if c.MaxConns = 0; c.Limit(ln, nil) != ln {
+		t.Error("listener wrapped with no limit")
+	}
+}
+
+func TestProcs(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	if _, _, err := parse(t, 0, "-procs", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if n := runtime.GOMAXPROCS(0); n != 3 {
+		t.Errorf("GOMAXPROCS %d", n)
+	}
+}
+
+func TestDebugAddr(t *testing.T) {
+	defer runtime.SetMutexProfileFraction(runtime.SetMutexProfileFraction(-1))
+	defer runtime.SetBlockProfileRate(0)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// A port in use fails Apply rather than a goroutine later.
+	if _, _, err := parse(t, 0, "-debug-addr", ln.Addr().String()); err == nil {
+		t.Error("Apply succeeded on a busy -debug-addr")
+	}
+
+	t.Setenv("SRV_DEBUG_ADDR", "127.0.0.1:0")
+	c, _, err := parse(t, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DebugAddr != "127.0.0.1:0" {
+		t.Errorf("debug-addr %q", c.DebugAddr)
+	}
+}
+
+func TestSize(t *testing.T) {
+	for in, want := range map[string]int{"4096": 4096, "64k": 64 << 10, "2M": 2 << 20, "0": 0} {
+		var s Size
+		if err := s.Set(in); err != nil || int(s) != want {
+			t.Errorf("Set(%q) = %d, %v; want %d", in, s, err, want)
+		}
+	}
+	for _, in := range []string{"", "k", "-1", "1g"} {
+		var s Size
+		if err := s.Set(in); err == nil {
+			t.Errorf("Set(%q) accepted", in)
+		}
+	}
+	if s := Size(64 << 10); s.String() != "64k" {
+		t.Errorf("String %q", s.String())
+	}
+}
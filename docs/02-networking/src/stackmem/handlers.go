@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// frameSize is the stack each level of deep uses.
+const frameSize = 1024
+
+// deep recurses depth times with a frameSize local array per frame and runs
+// f at the bottom, forcing the goroutine stack to grow to roughly
+// depth*frameSize. It stands in for deeply nested decoding, a TLS
+// handshake or a chain of middleware.
+//
+//go:noinline
+func deep(depth int, f func()) byte {
+	var pad [frameSize]byte
+	pad[depth%frameSize] = byte(depth)
+	if depth <= 0 {
+		f()
+		return pad[0]
+	}
+	return deep(depth-1, f) + pad[depth%frameSize]
+}
+
+// handler serves one connection. ready is called once the handler has
+// reached its steady state, just before it blocks in Read.
+type handler func(conn net.Conn, depth int, ready func())
+
+var handlers = map[string]handler{
+	// shallow never grows its stack.
+	"shallow": func(conn net.Conn, _ int, ready func()) {
+		echo(conn, ready)
+	},
+	// burst grows the stack once at the start of the connection and
+	// then returns to a shallow read loop, so the GC can shrink the
+	// stack back down.
+	"burst": func(conn net.Conn, depth int, ready func()) {
+		deep(depth, func() {})
+		echo(conn, ready)
+	},
+	// blocked waits for data at the bottom of the deep call chain: the
+	// stack is in use the whole time and can never shrink.
+	"blocked": func(conn net.Conn, depth int, ready func()) {
+		deep(depth, func() { echo(conn, ready) })
+	},
+}
+
+// echo is the steady-state read loop shared by all handlers.
+func echo(conn net.Conn, ready func()) {
+	defer conn.Close()
+	buf := make([]byte, 512)
+	ready()
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// serve runs h on every connection from ln, counting handlers that have
+// reached steady state in ready.
+func serve(ln net.Listener, h handler, depth int, ready *atomic.Int64) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go h(conn, depth, func() { ready.Add(1) })
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stackPerConn runs n pipe connections through h and returns the stack
+// bytes per connection once they have settled and after gcs GC cycles.
+func stackPerConn(t *testing.T, h handler, n, gcs int) (settled, collected float64) {
+	t.Helper()
+	runtime.GC()
+	base := readSample().stacks
+
+	var ready atomic.Int64
+	clients := make([]net.Conn, n)
+	for i := range clients {
+		c, s := net.Pipe()
+		clients[i] = c
+		go h(s, 32, func() { ready.Add(1) })
+	}
+	defer func() {
+		for _, c := range clients {
+			c.Close()
+		}
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for ready.Load() < int64(n) {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d handlers ready", ready.Load(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	settled = float64(readSample().stacks-base) / float64(n)
+	for range gcs {
+		runtime.GC()
+	}
+	collected = float64(readSample().stacks-base) / float64(n)
+	return settled, collected
+}
+
+func TestStackShrink(t *testing.T) {
+	const n, gcs = 500, 5
+
+	settled, collected := stackPerConn(t, handlers["burst"], n, gcs)
+	t.Logf("burst: %.0f B/conn settled, %.0f B/conn after %d GCs", settled, collected, gcs)
+	if settled < 32*frameSize/2 {
+		t.Errorf("burst stacks did not grow: %.0f B/conn", settled)
+	}
+	if collected > settled/4 {
+		t.Errorf("burst stacks did not shrink: %.0f -> %.0f B/conn", settled, collected)
+	}
+
+	settled, collected = stackPerConn(t, handlers["blocked"], n, gcs)
+	t.Logf("blocked: %.0f B/conn settled, %.0f B/conn after %d GCs", settled, collected, gcs)
+	if collected < 32*frameSize {
+		t.Errorf("blocked stacks shrank below the live call chain: %.0f B/conn", collected)
+	}
+}
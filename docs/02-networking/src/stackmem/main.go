@@ -0,0 +1,189 @@
+// Command stackmem measures how much goroutine stack memory a
+// goroutine-per-connection server holds at steady state, and whether stacks
+// that grew during a connection's lifetime are given back.
+//
+// Three handlers serve the same echo protocol:
+//
+//	shallow  read loop only; stacks stay at the initial size
+//	burst    one deep call chain at connection start, then the read loop
+//	blocked  the read loop runs at the bottom of the deep call chain
+//
+// The GC shrinks a stack by half when a goroutine uses less than a quarter
+// of it, so burst stacks come back down over a few GC cycles while blocked
+// stacks cannot. Each handler runs in a fresh child process so the numbers
+// do not leak into each other:
+//
+//	go run ./stackmem -conns 100000 -depth 64
+//
+// TCP needs two file descriptors per connection; when the limit cannot be
+// raised, -net pipe uses in-memory connections instead.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	mode      = flag.String("mode", "all", "Handler: shallow, burst, blocked or all")
+	conns     = flag.Int("conns", 100000, "Number of connections")
+	depth     = flag.Int("depth", 64, "Call depth of the deep handlers, in 1 KiB frames")
+	network   = flag.String("net", "tcp", "Transport: tcp (loopback) or pipe (net.Pipe)")
+	gcCycles  = flag.Int("gc", 5, "Forced GC cycles after the connections settle")
+	header    = flag.Bool("header", true, "Print the column header")
+	perListen = 25000 // connections per listener, to stay inside one ephemeral port range
+)
+
+// sample is a snapshot of the stack related runtime metrics.
+type sample struct {
+	stacks     uint64 // /memory/classes/heap/stacks:bytes
+	startSize  uint64 // /gc/stack/starting-size:bytes
+	goroutines uint64 // /sched/goroutines:goroutines
+}
+
+var metricNames = []string{
+	"/memory/classes/heap/stacks:bytes",
+	"/gc/stack/starting-size:bytes",
+	"/sched/goroutines:goroutines",
+}
+
+func readSample() sample {
+	s := make([]metrics.Sample, len(metricNames))
+	for i, name := range metricNames {
+		s[i].Name = name
+	}
+	metrics.Read(s)
+	return sample{stacks: s[0].Value.Uint64(), startSize: s[1].Value.Uint64(), goroutines: s[2].Value.Uint64()}
+}
+
+// connect opens n connections to h and returns the client ends, which are
+// held without goroutines so that only server stacks are measured.
+func connect(h handler, n int, ready *atomic.Int64) ([]net.Conn, error) {
+	clients := make([]net.Conn, n)
+	if *network == "pipe" {
+		for i := range clients {
+			c, s := net.Pipe()
+			clients[i] = c
+			go h(s, *depth, func() { ready.Add(1) })
+		}
+		return clients, nil
+	}
+
+	var lns []net.Listener
+	for i := 0; i < n; i += perListen {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		lns = append(lns, ln)
+		go serve(ln, h, *depth, ready)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		next    atomic.Int64
+		errOnce sync.Once
+		dialErr error
+	)
+	for range 64 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= n {
+					return
+				}
+				c, err := net.Dial("tcp", lns[i/perListen].Addr().String())
+				if err != nil {
+					errOnce.Do(func() { dialErr = err })
+					return
+				}
+				clients[i] = c
+			}
+		}()
+	}
+	wg.Wait()
+	return clients, dialErr
+}
+
+// run measures one handler in the current process.
+func run(name string) {
+	h, ok := handlers[name]
+	if !ok {
+		log.Fatalf("unknown mode %q", name)
+	}
+	if *network == "tcp" {
+		if got := raiseFileLimit(uint64(2**conns + 1000)); got < uint64(2**conns+100) {
+			log.Fatalf("file descriptor limit %d is too low for %d TCP connections; use -net pipe", got, *conns)
+		}
+	}
+
+	runtime.GC()
+	base := readSample()
+
+	var ready atomic.Int64
+	start := time.Now()
+	clients, err := connect(h, *conns, &ready)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	for ready.Load() < int64(*conns) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	setup := time.Since(start)
+
+	settled := readSample()
+	runtime.GC()
+	oneGC := readSample()
+	for i := 1; i < *gcCycles; i++ {
+		runtime.GC()
+	}
+	final := readSample()
+
+	perConn := func(s sample) float64 {
+		return float64(s.stacks-base.stacks) / float64(*conns) / 1024
+	}
+	fmt.Printf("%-8s %8d %10.1f %10.1f %10.1f %10.1f %10d %8v\n", name, *conns,
+		float64(settled.stacks-base.stacks)/(1<<20), perConn(settled), perConn(oneGC), perConn(final),
+		final.startSize, setup.Round(time.Millisecond))
+
+	for _, c := range clients {
+		c.Close()
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if *header {
+		fmt.Printf("%-8s %8s %10s %10s %10s %10s %10s %8s\n", "mode", "conns", "stacks MiB", "KiB/conn", "after 1 GC", fmt.Sprintf("after %d GC", *gcCycles), "start size", "setup")
+	}
+	if *mode != "all" {
+		run(*mode)
+		return
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, name := range []string{"shallow", "burst", "blocked"} {
+		args := append(os.Args[1:len(os.Args):len(os.Args)], "-mode", name, "-header=false")
+		cmd := exec.Command(exe, args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+	}
+}
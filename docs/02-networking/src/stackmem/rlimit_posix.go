@@ -0,0 +1,25 @@
+//go:build linux || darwin
+
+package main
+
+import "syscall"
+
+// raiseFileLimit lifts RLIMIT_NOFILE to want if allowed, else as far as the
+// hard limit permits, and returns the resulting soft limit.
+func raiseFileLimit(want uint64) uint64 {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		return 0
+	}
+	if lim.Cur >= want {
+		return lim.Cur
+	}
+	// Raising the hard limit needs CAP_SYS_RESOURCE; try it first.
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &syscall.Rlimit{Cur: want, Max: max(want, lim.Max)}); err == nil {
+		return want
+	}
+	lim.Cur = lim.Max
+	syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lim)
+	syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim)
+	return lim.Cur
+}
@@ -0,0 +1,238 @@
+// Command stress runs the tests of the concurrent packages over and over
+// under different GOMAXPROCS values and runtime settings, to shake out
+// ordering assumptions that one run with the defaults never exercises.
+//
+// The race detector finds unsynchronized accesses in the interleavings
+// that happen to run. It says nothing about a test, or the code under
+// it, that relies on a goroutine being scheduled before another one, on
+// a spin-wait being preempted, or on a collection not landing between
+// two steps. Those pass on a developer's machine and fail once in a few
+// hundred CI runs. stress makes the unlikely interleavings likely: it
+// builds each package's test binary once, then runs it for every
+// combination of a GOMAXPROCS value and a profile, with the test order
+// shuffled by a seed it reports.
+//
+//	go run ./stress -race -count 5
+//	go run ./stress -pkgs ./reactor/... -procs 1,2cpu -profiles nopreempt,gc -duration 10m
+//
+// The profiles are plain (no changes), nopreempt (asyncpreemptoff=1),
+// gc (GOGC=1) and stw (stop-the-world collections). -hog keeps some CPUs
+// busy in the harness itself, so the tests' threads are preempted by the
+// OS as well. Each failure is logged with a go test command that repeats
+// it, and its output is kept in -logs.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultPkgs are the packages whose tests run goroutines against each
+// other: the event loops, the lock-free rings and free lists, and
+// everything driven by timers.
+const defaultPkgs = "./reactor/...,./poller,./mirror,./telemetry,./bufpool,./timingwheel,./clock,./ratelimit,./connmgr,./readguard,./conclimit,./batchrpc,./drain"
+
+type options struct {
+	count   int
+	run     string
+	short   bool
+	race    bool
+	timeout time.Duration
+}
+
+var (
+	pkgsF     = flag.String("pkgs", defaultPkgs, "Comma-separated package patterns")
+	procsF    = flag.String("procs", "1,2,4,2cpu", "Comma-separated GOMAXPROCS values; cpu and 2cpu are the CPU count and twice it")
+	profilesF = flag.String("profiles", "all", "Comma-separated profiles: plain, nopreempt, gc, stw, or all")
+	count     = flag.Int("count", 3, "Runs of each test per cell (-test.count)")
+	run       = flag.String("run", "", "Run only tests matching this regexp (-test.run)")
+	short     = flag.Bool("short", false, "Pass -test.short")
+	race      = flag.Bool("race", false, "Build the test binaries with the race detector, which also randomizes the scheduler's run queues")
+	timeout   = flag.Duration("timeout", 2*time.Minute, "Per-cell timeout (-test.timeout); a hang fails the cell with every goroutine's stack")
+	jobs      = flag.Int("jobs", 1, "Cells run at once")
+	hog       = flag.Int("hog", 0, "Goroutines spinning in the harness while the tests run")
+	duration  = flag.Duration("duration", 0, "Repeat the matrix with new seeds until this much time has passed (0 runs it once)")
+	seed      = flag.Int64("seed", 0, "Seed for the shuffle seeds (0 picks one)")
+	logs      = flag.String("logs", "", "Directory for failed cells' output (default: a new temporary directory)")
+)
+
+// pkg is a package with tests and its compiled test binary.
+type pkg struct {
+	name string // import path
+	dir  string
+	bin  string
+}
+
+func main() {
+	flag.Parse()
+	procs, err := parseProcs(*procsF)
+	if err != nil {
+		log.Fatal(err)
+	}
+	profs, err := parseProfiles(*profilesF)
+	if err != nil {
+		log.Fatal(err)
+	}
+	o := options{count: *count, run: *run, short: *short, race: *race, timeout: *timeout}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	if *logs == "" {
+		if *logs, err = os.MkdirTemp("", "stress-"); err != nil {
+			log.Fatal(err)
+		}
+	}
+	bins, err := os.MkdirTemp("", "stress-bin-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(bins)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	pkgs, err := build(ctx, strings.Split(*pkgsF, ","), bins, o.race)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d packages x %d GOMAXPROCS x %d profiles, seed %d, failures logged to %s",
+		len(pkgs), len(procs), len(profs), *seed, *logs)
+
+	if *hog > 0 {
+		stop := spin(*hog)
+		defer stop()
+	}
+
+	rng := rand.New(rand.NewPCG(uint64(*seed), 0))
+	res := newResults()
+	start := time.Now()
+	for round := 1; ctx.Err() == nil; round++ {
+		var cells []cell
+		for _, p := range pkgs {
+			for _, n := range procs {
+				for _, pr := range profs {
+					cells = append(cells, cell{pkg: p, prof: pr, procs: n, seed: rng.Int64N(1 << 31)})
+				}
+			}
+		}
+		runCells(ctx, cells, o, res)
+		if *duration == 0 || time.Since(start) >= *duration {
+			break
+		}
+		log.Printf("round %d done after %v, %d failures so far", round, time.Since(start).Round(time.Second), res.failures())
+	}
+	res.print(os.Stdout, profs, procs)
+	if res.failures() > 0 {
+		os.Exit(1)
+	}
+}
+
+// build lists the packages matching patterns that have tests and compiles
+// a test binary for each into dir.
+func build(ctx context.Context, patterns []string, dir string, race bool) ([]pkg, error) {
+	cmd := exec.CommandContext(ctx, "go", append([]string{"list", "-json=ImportPath,Dir,TestGoFiles,XTestGoFiles"}, patterns...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %w", err)
+	}
+	var pkgs []pkg
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var p struct {
+			ImportPath, Dir           string
+			TestGoFiles, XTestGoFiles []string
+		}
+		if err := dec.Decode(&p); err != nil {
+			return nil, err
+		}
+		if len(p.TestGoFiles)+len(p.XTestGoFiles) == 0 {
+			continue
+		}
+		bin := filepath.Join(dir, strings.ReplaceAll(p.ImportPath, "/", "_")+".test")
+		args := []string{"test", "-c", "-o", bin}
+		if race {
+			args = append(args, "-race")
+		}
+		cmd := exec.CommandContext(ctx, "go", append(args, p.ImportPath)...)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("building %s: %w", p.ImportPath, err)
+		}
+		pkgs = append(pkgs, pkg{name: p.ImportPath, dir: p.Dir, bin: bin})
+	}
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("no packages with tests match %s", strings.Join(patterns, ","))
+	}
+	return pkgs, nil
+}
+
+// runCells runs cells, *jobs at a time, in each package's directory as go
+// test would.
+func runCells(ctx context.Context, cells []cell, o options, res *results) {
+	sem := make(chan struct{}, max(*jobs, 1))
+	var wg sync.WaitGroup
+	for _, c := range cells {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			cmd := exec.CommandContext(ctx, c.pkg.bin, c.args(o)...)
+			cmd.Dir = c.pkg.dir
+			cmd.Env = append(os.Environ(), c.env()...)
+			start := time.Now()
+			out, err := cmd.CombinedOutput()
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				res.add(c, "", time.Since(start))
+				return
+			}
+			v := verdict(out)
+			path := filepath.Join(*logs, fmt.Sprintf("%s-%d-%s-%d.log", filepath.Base(c.pkg.bin), c.procs, c.prof.name, c.seed))
+			if werr := os.WriteFile(path, out, 0o644); werr != nil {
+				log.Println(werr)
+			}
+			res.add(c, v, time.Since(start))
+			log.Printf("%s: %s\n\t%s\n\toutput: %s", v, c, c.repro(o), path)
+		}()
+	}
+	wg.Wait()
+}
+
+// spin keeps n goroutines busy until the returned func is called.
+func spin(n int) (stop func()) {
+	done := make(chan struct{})
+	for range n {
+		go func() {
+			for x := uint64(1); ; x = x*6364136223846793005 + 1 {
+				if x&0xffff == 0 {
+					select {
+					case <-done:
+						return
+					default:
+					}
+				}
+			}
+		}()
+	}
+	return func() { close(done) }
+}
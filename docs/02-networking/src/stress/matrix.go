@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// profile is a set of runtime settings a test binary runs under, each
+// changing where goroutines stop and in what order they resume.
+type profile struct {
+	name string
+	env  []string
+	what string
+}
+
+var profiles = []profile{
+	{"plain", nil, "the runtime's defaults"},
+	// Without async preemption a goroutine is stopped only at a function
+	// call or a blocking operation. A spin-wait that expects another
+	// goroutine to make progress on the same P hangs, and interleavings
+	// that preemption made likely become rare.
+	{"nopreempt", []string{"GODEBUG=asyncpreemptoff=1"}, "asyncpreemptoff=1"},
+	// A collection on almost every allocation. Mark assists stop
+	// allocating goroutines at arbitrary points, and each cycle's stop
+	// the world parks every P at once.
+	{"gc", []string{"GOGC=1"}, "GOGC=1"},
+	// Non-concurrent collections that stop the world for the whole mark
+	// and sweep, often: long pauses in the middle of every handoff.
+	{"stw", []string{"GOGC=10", "GODEBUG=gcstoptheworld=2"}, "GOGC=10 gcstoptheworld=2"},
+}
+
+// parseProfiles returns the profiles named in a comma-separated list, or
+// all of them for "all".
+func parseProfiles(s string) ([]profile, error) {
+	if s == "all" {
+		return profiles, nil
+	}
+	var out []profile
+	for name := range strings.SplitSeq(s, ",") {
+		i := slices.IndexFunc(profiles, func(p profile) bool { return p.name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown profile %q", name)
+		}
+		out = append(out, profiles[i])
+	}
+	return out, nil
+}
+
+// parseProcs parses a comma-separated list of GOMAXPROCS values. "cpu"
+// is the number of CPUs and "2cpu" twice that, more Ps than the threads
+// can run at once, so the OS preempts them too.
+func parseProcs(s string) ([]int, error) {
+	var out []int
+	for f := range strings.SplitSeq(s, ",") {
+		var n int
+		switch f {
+		case "cpu":
+			n = runtime.NumCPU()
+		case "2cpu":
+			n = 2 * runtime.NumCPU()
+		default:
+			var err error
+			if n, err = strconv.Atoi(f); err != nil || n <= 0 {
+				return nil, fmt.Errorf("bad GOMAXPROCS %q", f)
+			}
+		}
+		if !slices.Contains(out, n) {
+			out = append(out, n)
+		}
+	}
+	return out, nil
+}
+
+// cell is one package run under one profile and GOMAXPROCS.
+type cell struct {
+	pkg   pkg
+	prof  profile
+	procs int
+	seed  int64 // for -test.shuffle
+}
+
+func (c cell) String() string {
+	return fmt.Sprintf("%s GOMAXPROCS=%d %s shuffle=%d", c.pkg.name, c.procs, c.prof.name, c.seed)
+}
+
+// env returns the variables to add to the harness's environment.
+func (c cell) env() []string {
+	return append([]string{"GOMAXPROCS=" + strconv.Itoa(c.procs)}, c.prof.env...)
+}
+
+// args returns the test binary's flags.
+func (c cell) args(o options) []string {
+	a := []string{
+		"-test.count=" + strconv.Itoa(o.count),
+		"-test.shuffle=" + strconv.FormatInt(c.seed, 10),
+		"-test.timeout=" + o.timeout.String(),
+	}
+	if o.run != "" {
+		a = append(a, "-test.run="+o.run)
+	}
+	if o.short {
+		a = append(a, "-test.short")
+	}
+	return a
+}
+
+// repro returns a go test command that runs the cell again on its own.
+func (c cell) repro(o options) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "cd %s && %s go test", c.pkg.dir, strings.Join(c.env(), " "))
+	if o.race {
+		b.WriteString(" -race")
+	}
+	fmt.Fprintf(&b, " -count %d -shuffle %d -timeout %s", o.count, c.seed, o.timeout)
+	if o.run != "" {
+		fmt.Fprintf(&b, " -run '%s'", o.run)
+	}
+	if o.short {
+		b.WriteString(" -short")
+	}
+	b.WriteString(" .")
+	return b.String()
+}
+
+// verdict classifies a failed run from its output.
+func verdict(out []byte) string {
+	s := string(out)
+	switch {
+	case strings.Contains(s, "WARNING: DATA RACE"):
+		return "race"
+	case strings.Contains(s, "panic: test timed out"):
+		return "timeout"
+	case strings.Contains(s, "fatal error: all goroutines are asleep"):
+		return "deadlock"
+	case strings.Contains(s, "\npanic: "), strings.HasPrefix(s, "panic: "):
+		return "panic"
+	}
+	return "fail"
+}
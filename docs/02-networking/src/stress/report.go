@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// key identifies a cell without its seed, so every round adds to the
+// same tally.
+type key struct {
+	pkg   string
+	prof  string
+	procs int
+}
+
+type tally struct {
+	runs     int
+	verdicts map[string]int // failure kind -> count
+	took     time.Duration
+}
+
+type results struct {
+	mu    sync.Mutex
+	cells map[key]*tally
+	pkgs  []string // in the order first seen
+}
+
+func newResults() *results {
+	return &results{cells: make(map[key]*tally)}
+}
+
+// add records one run of c; verdict is empty if it passed.
+func (r *results) add(c cell, verdict string, took time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := key{c.pkg.name, c.prof.name, c.procs}
+	t := r.cells[k]
+	if t == nil {
+		t = &tally{verdicts: make(map[string]int)}
+		r.cells[k] = t
+		if !slices.Contains(r.pkgs, k.pkg) {
+			r.pkgs = append(r.pkgs, k.pkg)
+		}
+	}
+	t.runs++
+	t.took += took
+	if verdict != "" {
+		t.verdicts[verdict]++
+	}
+}
+
+func (r *results) failures() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, t := range r.cells {
+		for _, v := range t.verdicts {
+			n += v
+		}
+	}
+	return n
+}
+
+// print writes a row per package and a column per GOMAXPROCS and profile.
+// A cell shows "ok" with its runs, or its failures by kind, such as
+// "2/5 race".
+func (r *results) print(w io.Writer, profs []profile, procs []int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprint(tw, "package")
+	for _, n := range procs {
+		for _, p := range profs {
+			fmt.Fprintf(tw, "\tP=%d %s", n, p.name)
+		}
+	}
+	fmt.Fprintln(tw)
+	for _, name := range r.pkgs {
+		fmt.Fprint(tw, shortName(name))
+		for _, n := range procs {
+			for _, p := range profs {
+				fmt.Fprintf(tw, "\t%s", r.cells[key{name, p.name, n}])
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+}
+
+func (t *tally) String() string {
+	if t == nil {
+		return "-"
+	}
+	if len(t.verdicts) == 0 {
+		return fmt.Sprintf("ok x%d", t.runs)
+	}
+	var kinds []string
+	for v, n := range t.verdicts {
+		kinds = append(kinds, fmt.Sprintf("%d/%d %s", n, t.runs, v))
+	}
+	slices.Sort(kinds)
+	return strings.Join(kinds, ",")
+}
+
+// shortName drops the module path from an import path.
+func shortName(path string) string {
+	if i := strings.Index(path, "/src/"); i >= 0 {
+		return path[i+len("/src/"):]
+	}
+	return path
+}
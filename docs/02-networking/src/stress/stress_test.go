@@ -0,0 +1,92 @@
+package main
+
+import (
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProcs(t *testing.T) {
+	got, err := parseProcs("1,cpu,2,2cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := runtime.NumCPU()
+	want := slices.Compact([]int{1, n, 2, 2 * n})
+	if !slices.Equal(got, want) {
+		t.Errorf("parseProcs = %v, want %v", got, want)
+	}
+	for _, bad := range []string{"0", "x", "1,,2"} {
+		if _, err := parseProcs(bad); err == nil {
+			t.Errorf("parseProcs(%q): no error", bad)
+		}
+	}
+}
+
+func TestParseProfiles(t *testing.T) {
+	all, err := parseProfiles("all")
+	if err != nil || len(all) != len(profiles) {
+		t.Fatalf("all = %d profiles, %v", len(all), err)
+	}
+	got, err := parseProfiles("gc,nopreempt")
+	if err != nil || len(got) != 2 || got[0].name != "gc" || got[1].name != "nopreempt" {
+		t.Fatalf("parseProfiles = %v, %v", got, err)
+	}
+	if _, err := parseProfiles("plain,fast"); err == nil {
+		t.Error("unknown profile accepted")
+	}
+}
+
+// TestRepro checks that a failed cell's command sets the same environment
+// and flags as the run that failed.
+func TestRepro(t *testing.T) {
+	c := cell{pkg: pkg{name: "x/reactor", dir: "/src/reactor"}, prof: profiles[3], procs: 2, seed: 42}
+	o := options{count: 5, run: "TestEcho", race: true, timeout: time.Minute}
+	got := c.repro(o)
+	want := "cd /src/reactor && GOMAXPROCS=2 GOGC=10 GODEBUG=gcstoptheworld=2 go test -race -count 5 -shuffle 42 -timeout 1m0s -run 'TestEcho' ."
+	if got != want {
+		t.Errorf("repro:\n got %s\nwant %s", got, want)
+	}
+	args := strings.Join(c.args(o), " ")
+	if want := "-test.count=5 -test.shuffle=42 -test.timeout=1m0s -test.run=TestEcho"; args != want {
+		t.Errorf("args = %s, want %s", args, want)
+	}
+}
+
+func TestVerdict(t *testing.T) {
+	for out, want := range map[string]string{
+		"==================\nWARNING: DATA RACE\nWrite at ...":             "race",
+		"panic: test timed out after 2m0s\n\trunning tests:":               "timeout",
+		"fatal error: all goroutines are asleep - deadlock!":               "deadlock",
+		"--- FAIL: TestX (0.00s)\npanic: index out of range [recovered]\n": "panic",
+		"--- FAIL: TestX (0.00s)\n    x_test.go:10: got 1, want 2\nFAIL\n": "fail",
+	} {
+		if got := verdict([]byte(out)); got != want {
+			t.Errorf("verdict(%q) = %s, want %s", out, got, want)
+		}
+	}
+}
+
+func TestResults(t *testing.T) {
+	r := newResults()
+	p := pkg{name: "github.com/x/docs/02-networking/src/mirror"}
+	c := cell{pkg: p, prof: profiles[1], procs: 1}
+	r.add(c, "", time.Second)
+	r.add(c, "race", time.Second)
+	r.add(c, "race", time.Second)
+	r.add(cell{pkg: p, prof: profiles[0], procs: 1}, "", time.Second)
+	if r.failures() != 2 {
+		t.Errorf("failures = %d, want 2", r.failures())
+	}
+	var b strings.Builder
+	r.print(&b, profiles[:2], []int{1, 4})
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("table:\n%s", b.String())
+	}
+	if f := strings.Fields(lines[1]); !slices.Equal(f, []string{"mirror", "ok", "x1", "2/3", "race", "-", "-"}) {
+		t.Errorf("row %q", lines[1])
+	}
+}
@@ -0,0 +1,104 @@
+package telemetry
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// histogram is what a channel-based collector typically feeds: log2
+// buckets updated by a single consumer goroutine.
+type histogram struct {
+	buckets [64]uint64
+}
+
+func (h *histogram) add(d time.Duration) { h.buckets[bits.Len64(uint64(d))]++ }
+
+// collector drains a channel of samples into a histogram on its own
+// goroutine, the usual alternative to a ring.
+type collector struct {
+	ch   chan time.Duration
+	hist histogram
+	done sync.WaitGroup
+}
+
+func newCollector(buf int) *collector {
+	c := &collector{ch: make(chan time.Duration, buf)}
+	c.done.Add(1)
+	go func() {
+		defer c.done.Done()
+		for d := range c.ch {
+			c.hist.add(d)
+		}
+	}()
+	return c
+}
+
+func (c *collector) close() {
+	close(c.ch)
+	c.done.Wait()
+}
+
+// BenchmarkRecord measures what a handler pays to record one latency
+// sample. Run it with -cpu 1,2,4,8 to see how each option scales with
+// concurrent writers.
+//
+//   - ring: Ring.Record.
+//   - chan: a blocking send to a buffered channel; the handler waits
+//     whenever the consumer falls behind.
+//   - chan-drop: a non-blocking send that discards the sample when the
+//     buffer is full; dropped/op reports the fraction lost.
+func BenchmarkRecord(b *testing.B) {
+	sample := 150 * time.Microsecond
+
+	b.Run("ring", func(b *testing.B) {
+		r := NewRing(4096)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				r.Record(sample)
+			}
+		})
+	})
+	b.Run("chan", func(b *testing.B) {
+		c := newCollector(4096)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				c.ch <- sample
+			}
+		})
+		b.StopTimer()
+		c.close()
+	})
+	b.Run("chan-drop", func(b *testing.B) {
+		c := newCollector(4096)
+		var dropped atomic.Int64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				select {
+				case c.ch <- sample:
+				default:
+					dropped.Add(1)
+				}
+			}
+		})
+		b.StopTimer()
+		c.close()
+		b.ReportMetric(float64(dropped.Load())/float64(b.N), "dropped/op")
+	})
+}
+
+// BenchmarkSnapshot is the reader's side: copying a full ring and
+// computing three percentiles, done once per reporting interval.
+func BenchmarkSnapshot(b *testing.B) {
+	r := NewRing(4096)
+	for i := range r.Len() {
+		r.Record(time.Duration(i * 997 % 5000))
+	}
+	var buf []time.Duration
+	for b.Loop() {
+		buf = r.Snapshot(buf[:0])
+		Quantiles(buf, 0.5, 0.99, 0.999)
+	}
+}
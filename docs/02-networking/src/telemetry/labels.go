@@ -0,0 +1,103 @@
+package telemetry
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"runtime/pprof"
+	"strconv"
+	"sync/atomic"
+)
+
+// sizeClasses bound the "size" label. A handful of values keeps the
+// profile groups large enough to compare; exact sizes would give every
+// sample its own group.
+var sizeClasses = [...]struct {
+	max  int64
+	name string
+}{
+	{64, "<=64B"},
+	{1 << 10, "<=1KiB"},
+	{16 << 10, "<=16KiB"},
+	{256 << 10, "<=256KiB"},
+	{math.MaxInt64, ">256KiB"},
+}
+
+const numSizeClasses = len(sizeClasses)
+
+func sizeClass(n int64) int {
+	for i, c := range sizeClasses {
+		if n <= c.max {
+			return i
+		}
+	}
+	return numSizeClasses - 1
+}
+
+// SizeClass returns the "size" label value for an n-byte message. Negative
+// sizes, such as an unknown Content-Length, are reported as "unknown".
+func SizeClass(n int64) string {
+	if n < 0 {
+		return "unknown"
+	}
+	return sizeClasses[sizeClass(n)].name
+}
+
+var connIDs atomic.Uint64
+
+// NextConnID returns a process-unique value for the "conn" label.
+func NextConnID() string {
+	return strconv.FormatUint(connIDs.Add(1), 10)
+}
+
+// Labeler sets pprof labels on a connection's goroutine as it moves
+// between message sizes, so CPU profiles can be split by connection and
+// by size class. pprof.Do per message would allocate a label set and a
+// context every time; Labeler builds one context per size class on first
+// use and afterwards only swaps a pointer, and only when the class
+// changes.
+type Labeler struct {
+	base context.Context
+	ctxs [numSizeClasses]context.Context
+	cur  int
+}
+
+// NewLabeler labels the calling goroutine with the key/value pairs in
+// base, typically "conn" and an ID from NextConnID. It must be used from
+// that goroutine only.
+func NewLabeler(ctx context.Context, base ...string) *Labeler {
+	l := &Labeler{base: pprof.WithLabels(ctx, pprof.Labels(base...)), cur: -1}
+	pprof.SetGoroutineLabels(l.base)
+	return l
+}
+
+// Message adds the size class of an n-byte message to the goroutine's
+// labels until the next call.
+func (l *Labeler) Message(n int) {
+	c := sizeClass(int64(n))
+	if c == l.cur {
+		return
+	}
+	if l.ctxs[c] == nil {
+		l.ctxs[c] = pprof.WithLabels(l.base, pprof.Labels("size", sizeClasses[c].name))
+	}
+	pprof.SetGoroutineLabels(l.ctxs[c])
+	l.cur = c
+}
+
+// ConnContext is an http.Server.ConnContext hook that gives every
+// connection a "conn" label, inherited by its requests.
+func ConnContext(ctx context.Context, _ net.Conn) context.Context {
+	return pprof.WithLabels(ctx, pprof.Labels("conn", NextConnID()))
+}
+
+// LabelHTTP runs each request under pprof labels for its connection (when
+// the server uses ConnContext) and the size class of its body.
+func LabelHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pprof.Do(r.Context(), pprof.Labels("size", SizeClass(r.ContentLength)), func(ctx context.Context) {
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+}
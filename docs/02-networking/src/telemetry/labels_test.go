@@ -0,0 +1,97 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"strings"
+	"testing"
+)
+
+func TestSizeClass(t *testing.T) {
+	for _, tc := range []struct {
+		n    int64
+		want string
+	}{
+		{-1, "unknown"}, {0, "<=64B"}, {64, "<=64B"}, {65, "<=1KiB"},
+		{16 << 10, "<=16KiB"}, {256<<10 + 1, ">256KiB"},
+	} {
+		if got := SizeClass(tc.n); got != tc.want {
+			t.Errorf("SizeClass(%d) = %q, want %q", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestLabeler(t *testing.T) {
+	l := NewLabeler(context.Background(), "conn", "7")
+	l.Message(10)
+	first := l.ctxs[0]
+	l.Message(5000)
+	l.Message(20)
+	if l.ctxs[0] != first {
+		t.Fatal("context for a size class was rebuilt")
+	}
+	for c, ctx := range l.ctxs {
+		if ctx == nil {
+			continue
+		}
+		conn, _ := pprof.Label(ctx, "conn")
+		size, _ := pprof.Label(ctx, "size")
+		if conn != "7" || size != sizeClasses[c].name {
+			t.Errorf("class %d labels conn=%q size=%q", c, conn, size)
+		}
+	}
+	if allocs := testing.AllocsPerRun(100, func() {
+		l.Message(10)
+		l.Message(5000)
+	}); allocs != 0 {
+		t.Errorf("switching between built classes allocates %v times", allocs)
+	}
+	pprof.SetGoroutineLabels(context.Background())
+}
+
+func TestLabelHTTP(t *testing.T) {
+	var conn, size string
+	h := LabelHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _ = pprof.Label(r.Context(), "conn")
+		size, _ = pprof.Label(r.Context(), "size")
+	}))
+	r := httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("x", 100)))
+	r = r.WithContext(ConnContext(r.Context(), nil))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if conn == "" || size != "<=1KiB" {
+		t.Fatalf("labels conn=%q size=%q", conn, size)
+	}
+}
+
+// BenchmarkLabels compares relabeling per message with pprof.Do against
+// Labeler, for a connection whose messages alternate between two size
+// classes (the worst case for Labeler) and one whose size is steady.
+func BenchmarkLabels(b *testing.B) {
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels("conn", "1"))
+	for _, tc := range []struct {
+		name string
+		sz   [2]int
+	}{{"steady", [2]int{100, 100}}, {"alternating", [2]int{100, 5000}}} {
+		sz := tc.sz
+		b.Run(tc.name+"/pprof.Do", func(b *testing.B) {
+			b.ReportAllocs()
+			i := 0
+			for b.Loop() {
+				pprof.Do(ctx, pprof.Labels("size", SizeClass(int64(sz[i&1]))), func(context.Context) {})
+				i++
+			}
+		})
+		b.Run(tc.name+"/Labeler", func(b *testing.B) {
+			l := NewLabeler(context.Background(), "conn", "1")
+			b.ReportAllocs()
+			i := 0
+			for b.Loop() {
+				l.Message(sz[i&1])
+				i++
+			}
+		})
+	}
+	pprof.SetGoroutineLabels(context.Background())
+}
@@ -0,0 +1,86 @@
+// Package telemetry collects latency samples on the hot path of the
+// example servers without slowing it down.
+//
+// Ring keeps the last N samples in a fixed array. Recording is one atomic
+// add and one atomic store, with no lock and no loop, so a writer finishes
+// in a bounded number of steps no matter what other writers or the reader
+// are doing. A background goroutine snapshots the ring and computes
+// percentiles over whatever it holds. Old samples are overwritten rather
+// than queued, so a slow reader costs accuracy, never latency.
+//
+// Labeler, ConnContext and LabelHTTP attach pprof labels for the
+// connection and the message size class to handler goroutines, so a CPU
+// profile can be split along those dimensions; the labelprof command
+// groups a profile's samples by label.
+package telemetry
+
+import (
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/pow2"
+)
+
+// Ring is a fixed-size overwrite ring of durations. It is safe for
+// concurrent use by any number of writers and readers.
+type Ring struct {
+	mask  pow2.Mask
+	slots []atomic.Int64
+
+	// Every writer reads the fields above and writes next. A full line
+	// of padding keeps them on different cache lines wherever the Ring
+	// is allocated.
+	_    [64]byte
+	next atomic.Uint64
+}
+
+// NewRing returns a ring holding the last n samples, with n rounded up to
+// a power of two.
+func NewRing(n int) *Ring {
+	n = pow2.NextPow2(n)
+	return &Ring{mask: pow2.MaskFor(n), slots: make([]atomic.Int64, n)}
+}
+
+// Len returns the ring's capacity.
+func (r *Ring) Len() int { return r.mask.Len() }
+
+// Record stores d, overwriting the oldest sample once the ring is full.
+func (r *Ring) Record(d time.Duration) {
+	// Zero marks an empty slot, so the smallest sample kept is 1ns.
+	i := r.next.Add(1) - 1
+	r.slots[r.mask.Index(int(i))].Store(int64(max(d, 1)))
+}
+
+// Count returns the number of samples recorded since the ring was created,
+// including ones that have since been overwritten.
+func (r *Ring) Count() uint64 { return r.next.Load() }
+
+// Snapshot appends the samples currently in the ring to dst and returns
+// it. Samples are in slot order, not time order. Writers are not stopped,
+// so a slot being rewritten during the copy yields either its old or its
+// new value; every value returned was recorded at some point.
+func (r *Ring) Snapshot(dst []time.Duration) []time.Duration {
+	for i := range r.slots {
+		if v := r.slots[i].Load(); v != 0 {
+			dst = append(dst, time.Duration(v))
+		}
+	}
+	return dst
+}
+
+// Quantiles sorts samples in place and returns the value at each quantile
+// q in [0, 1], using the nearest-rank method. It returns zeros for an
+// empty slice.
+func Quantiles(samples []time.Duration, qs ...float64) []time.Duration {
+	out := make([]time.Duration, len(qs))
+	if len(samples) == 0 {
+		return out
+	}
+	slices.Sort(samples)
+	for i, q := range qs {
+		k := int(q*float64(len(samples))+0.5) - 1
+		out[i] = samples[min(max(k, 0), len(samples)-1)]
+	}
+	return out
+}
@@ -0,0 +1,161 @@
+package telemetry
+
+import (
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRing(t *testing.T) {
+	r := NewRing(5)
+	if r.Len() != 8 {
+		t.Fatalf("Len = %d, want 8", r.Len())
+	}
+	if got := r.Snapshot(nil); len(got) != 0 {
+		t.Fatalf("empty ring snapshot = %v", got)
+	}
+
+	r.Record(0) // kept as 1ns, not lost as an empty slot
+	for i := 2; i <= 4; i++ {
+		r.Record(time.Duration(i))
+	}
+	got := r.Snapshot(nil)
+	slices.Sort(got)
+	if want := []time.Duration{1, 2, 3, 4}; !slices.Equal(got, want) {
+		t.Fatalf("snapshot = %v, want %v", got, want)
+	}
+
+	for i := 5; i <= 20; i++ {
+		r.Record(time.Duration(i))
+	}
+	got = r.Snapshot(got[:0])
+	slices.Sort(got)
+	if want := []time.Duration{13, 14, 15, 16, 17, 18, 19, 20}; !slices.Equal(got, want) {
+		t.Fatalf("after wrap snapshot = %v, want %v", got, want)
+	}
+	if r.Count() != 20 {
+		t.Fatalf("Count = %d, want 20", r.Count())
+	}
+}
+
+// TestRingConcurrent checks, under the race detector, that writers and a
+// reader can run together and that every snapshotted value is one that
+// was recorded.
+func TestRingConcurrent(t *testing.T) {
+	r := NewRing(64)
+	const writers, perWriter = 4, 10_000
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perWriter {
+				r.Record(time.Duration(w*perWriter + i + 1))
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var buf []time.Duration
+	for {
+		buf = r.Snapshot(buf[:0])
+		for _, d := range buf {
+			if d < 1 || d > writers*perWriter {
+				t.Fatalf("snapshot holds %d, which was never recorded", d)
+			}
+		}
+		select {
+		case <-done:
+			if r.Count() != writers*perWriter {
+				t.Fatalf("Count = %d, want %d", r.Count(), writers*perWriter)
+			}
+			return
+		default:
+		}
+	}
+}
+
+// TestRingModel records random runs of samples, snapshotting at random
+// points, and checks each snapshot against the model: the last Len()
+// samples recorded, with 0 kept as 1ns.
+func TestRingModel(t *testing.T) {
+	for seed := range uint64(100) {
+		rnd := rand.New(rand.NewPCG(seed, 1))
+		r := NewRing(1 + rnd.IntN(16))
+		var model []time.Duration
+		for step := range 500 {
+			if rnd.IntN(8) > 0 {
+				d := time.Duration(rnd.IntN(1000))
+				r.Record(d)
+				model = append(model, max(d, 1))
+				continue
+			}
+			got := r.Snapshot(nil)
+			want := slices.Clone(model[max(len(model)-r.Len(), 0):])
+			slices.Sort(got)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Fatalf("seed %d, %d slots, step %d: snapshot %v, want %v", seed, r.Len(), step, got, want)
+			}
+			if r.Count() != uint64(len(model)) {
+				t.Fatalf("seed %d: Count = %d, want %d", seed, r.Count(), len(model))
+			}
+		}
+	}
+}
+
+// TestRingConcurrentProperty races writers of distinct samples over random
+// ring sizes. Once they are done, the ring must be full of distinct
+// recorded samples. Which ones is up to the scheduler: a writer that
+// claimed a slot a lap earlier may store after the one that claimed it
+// last.
+func TestRingConcurrentProperty(t *testing.T) {
+	for seed := range uint64(10) {
+		rnd := rand.New(rand.NewPCG(seed, 2))
+		r := NewRing(1 + rnd.IntN(128))
+		writers, perWriter := 1+rnd.IntN(8), 1+rnd.IntN(5000)
+		var wg sync.WaitGroup
+		for w := range writers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range perWriter {
+					r.Record(time.Duration(w*perWriter + i + 1))
+				}
+			}()
+		}
+		wg.Wait()
+
+		total := writers * perWriter
+		got := r.Snapshot(nil)
+		if want := min(total, r.Len()); len(got) != want {
+			t.Fatalf("seed %d: %d samples in a ring of %d after %d records", seed, len(got), r.Len(), total)
+		}
+		slices.Sort(got)
+		for i, d := range got {
+			if d < 1 || d > time.Duration(total) || i > 0 && d == got[i-1] {
+				t.Fatalf("seed %d: snapshot holds %d, recorded once or never", seed, d)
+			}
+		}
+	}
+}
+
+func TestQuantiles(t *testing.T) {
+	var samples []time.Duration
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i))
+	}
+	got := Quantiles(samples, 0, 0.5, 0.99, 1)
+	if want := []time.Duration{1, 50, 99, 100}; !slices.Equal(got, want) {
+		t.Fatalf("Quantiles = %v, want %v", got, want)
+	}
+	if got := Quantiles(nil, 0.5); got[0] != 0 {
+		t.Fatalf("Quantiles(nil) = %v", got)
+	}
+}
@@ -0,0 +1,294 @@
+package timingwheel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	deadlineConns = 10000
+	idleTimeout   = 5 * time.Minute // what echo-net.go sets before every read
+)
+
+type connPair struct {
+	client, server net.Conn
+}
+
+// openPairs opens up to n loopback TCP connections, stopping early if the
+// file descriptor limit is reached.
+func openPairs(b *testing.B, n int) []connPair {
+	b.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+
+	pairs := make([]connPair, 0, n)
+	for len(pairs) < n {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			break
+		}
+		s, err := ln.Accept()
+		if err != nil {
+			c.Close()
+			break
+		}
+		pairs = append(pairs, connPair{c, s})
+	}
+	if len(pairs) < 100 {
+		b.Skipf("only %d connections could be opened", len(pairs))
+	}
+	if len(pairs) < n {
+		b.Logf("file descriptor limit reached: using %d connections instead of %d", len(pairs), n)
+	}
+	b.Cleanup(func() {
+		for _, p := range pairs {
+			p.client.Close()
+			p.server.Close()
+		}
+	})
+	return pairs
+}
+
+// idleConn is the wheel-based alternative to a read deadline: reads only
+// record the wheel's tick, and the wheel checks the connection lazily once
+// per timeout, rescheduling itself if there has been activity since.
+type idleConn struct {
+	net.Conn
+	w     *Wheel
+	last  atomic.Int64 // wheel tick of the last read
+	timer *Timer
+}
+
+func trackIdle(w *Wheel, c net.Conn, timeout time.Duration) *idleConn {
+	ic := &idleConn{Conn: c, w: w}
+	ic.touch()
+	ic.timer = w.AfterFunc(timeout, func() {
+		idle := time.Duration(w.Now()-ic.last.Load()) * w.Tick()
+		if idle < timeout {
+			ic.timer.Reset(timeout - idle)
+			return
+		}
+		ic.Conn.Close()
+	})
+	return ic
+}
+
+func (c *idleConn) touch() { c.last.Store(c.w.Now()) }
+
+// BenchmarkReadDeadline measures one 64-byte read on one of 10k open
+// connections with no deadline, with SetReadDeadline before every read,
+// and with idle tracking on a timing wheel.
+func BenchmarkReadDeadline(b *testing.B) {
+	pairs := openPairs(b, deadlineConns)
+	msg := make([]byte, 64)
+
+	run := func(b *testing.B, before func(i int)) {
+		var next atomic.Int64
+		procs := runtime.GOMAXPROCS(0)
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			// Each goroutine owns every procs-th connection.
+			g := int(next.Add(1) - 1)
+			buf := make([]byte, len(msg))
+			i := g
+			for pb.Next() {
+				p := pairs[i]
+				if _, err := p.client.Write(msg); err != nil {
+					b.Error(err)
+					return
+				}
+				before(i)
+				if _, err := io.ReadFull(p.server, buf); err != nil {
+					b.Error(err)
+					return
+				}
+				if i += procs; i >= len(pairs) {
+					i = g
+				}
+			}
+		})
+		b.ReportMetric(float64(len(pairs)), "conns")
+	}
+
+	b.Run("none", func(b *testing.B) {
+		run(b, func(int) {})
+	})
+
+	b.Run("per-read", func(b *testing.B) {
+		// Arm every connection so the runtime timer heaps hold 10k entries.
+		for _, p := range pairs {
+			p.server.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+		defer func() {
+			for _, p := range pairs {
+				p.server.SetReadDeadline(time.Time{})
+			}
+		}()
+		run(b, func(i int) {
+			pairs[i].server.SetReadDeadline(time.Now().Add(idleTimeout))
+		})
+	})
+
+	b.Run("wheel", func(b *testing.B) {
+		w := New(time.Second, 512)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go w.Run(ctx)
+		idle := make([]*idleConn, len(pairs))
+		for i, p := range pairs {
+			idle[i] = trackIdle(w, p.server, idleTimeout)
+		}
+		defer func() {
+			for _, ic := range idle {
+				ic.timer.Stop()
+			}
+		}()
+		run(b, func(i int) { idle[i].touch() })
+	})
+}
+
+// BenchmarkDeadlineUpdate isolates the per-read bookkeeping from the I/O:
+// re-arming a runtime timer through SetReadDeadline versus storing a
+// timestamp for the wheel.
+func BenchmarkDeadlineUpdate(b *testing.B) {
+	pairs := openPairs(b, deadlineConns)
+
+	b.Run("SetReadDeadline", func(b *testing.B) {
+		for _, p := range pairs {
+			p.server.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+		defer func() {
+			for _, p := range pairs {
+				p.server.SetReadDeadline(time.Time{})
+			}
+		}()
+		b.ReportAllocs()
+		i := 0
+		for b.Loop() {
+			pairs[i].server.SetReadDeadline(time.Now().Add(idleTimeout))
+			if i++; i == len(pairs) {
+				i = 0
+			}
+		}
+	})
+
+	b.Run("wheel", func(b *testing.B) {
+		w := New(time.Second, 512)
+		idle := make([]*idleConn, len(pairs))
+		for i, p := range pairs {
+			idle[i] = trackIdle(w, p.server, idleTimeout)
+		}
+		b.ReportAllocs()
+		i := 0
+		for b.Loop() {
+			idle[i].touch()
+			if i++; i == len(idle) {
+				i = 0
+			}
+		}
+	})
+}
+
+// BenchmarkWheelExpire measures the lazy check the wheel performs once per
+// timeout for every connection that was active in the meantime.
+func BenchmarkWheelExpire(b *testing.B) {
+	w := New(time.Millisecond, 512)
+	var fired int
+	for range deadlineConns {
+		var t *Timer
+		t = w.AfterFunc(time.Millisecond, func() {
+			fired++
+			t.Reset(time.Millisecond)
+		})
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		w.Advance(1)
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(fired), "ns/timer")
+}
+
+// BenchmarkConnDeadlines compares two ways of keeping a read deadline and
+// a write deadline for each of many connections, without the I/O: a
+// time.Timer per deadline, which lives in the runtime's timer heap of
+// its P and is re-sorted on every Reset, and a Deadline on a
+// Hierarchical wheel, which only moves its timer when the deadline comes
+// earlier. "read" pushes a connection's read deadline out, as every read
+// does; "write" arms the write deadline and clears it, as a reply that
+// is queued and then flushed does.
+func BenchmarkConnDeadlines(b *testing.B) {
+	for _, conns := range []int{10_000, 100_000} {
+		b.Run(fmt.Sprintf("conns=%d/timer", conns), func(b *testing.B) {
+			read := make([]*time.Timer, conns)
+			write := make([]*time.Timer, conns)
+			for i := range conns {
+				read[i] = time.AfterFunc(idleTimeout, func() {})
+				write[i] = time.AfterFunc(idleTimeout, func() {})
+				write[i].Stop()
+			}
+			defer func() {
+				for i := range conns {
+					read[i].Stop()
+				}
+			}()
+			benchDeadlines(b, conns,
+				func(i int) { read[i].Reset(idleTimeout) },
+				func(i int) { write[i].Reset(time.Second); write[i].Stop() })
+		})
+		b.Run(fmt.Sprintf("conns=%d/hierarchical", conns), func(b *testing.B) {
+			h := NewHierarchical(10*time.Millisecond, 64, 4)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go h.Run(ctx)
+			read := make([]*Deadline, conns)
+			write := make([]*Deadline, conns)
+			for i := range conns {
+				read[i] = h.NewDeadline(func() {})
+				read[i].Set(idleTimeout)
+				write[i] = h.NewDeadline(func() {})
+			}
+			defer func() {
+				for i := range conns {
+					read[i].Stop()
+					write[i].Stop()
+				}
+			}()
+			benchDeadlines(b, conns,
+				func(i int) { read[i].Set(idleTimeout) },
+				func(i int) { write[i].Set(time.Second); write[i].Clear() })
+		})
+	}
+}
+
+func benchDeadlines(b *testing.B, conns int, read, write func(i int)) {
+	b.Run("read", func(b *testing.B) {
+		b.ReportAllocs()
+		i := 0
+		for b.Loop() {
+			read(i)
+			if i++; i == conns {
+				i = 0
+			}
+		}
+	})
+	b.Run("write", func(b *testing.B) {
+		b.ReportAllocs()
+		i := 0
+		for b.Loop() {
+			write(i)
+			if i++; i == conns {
+				i = 0
+			}
+		}
+	})
+}
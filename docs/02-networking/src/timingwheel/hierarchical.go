@@ -0,0 +1,300 @@
+package timingwheel
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/pow2"
+)
+
+// Hierarchical is a timing wheel of several levels, like a clock with
+// hands for seconds, minutes and hours. Level 0 has one slot per tick;
+// each slot of level k spans a whole rotation of level k-1. A timer goes
+// into the lowest level whose span reaches its expiry, and when the level
+// below comes round to it, it cascades down, until it fires from level 0
+// on its own tick.
+//
+// A single-level Wheel has to choose between a fine tick and a long
+// reach: timers further out than one rotation share slots with nearer
+// ones, and every pass over a slot skips them again. With L levels of S
+// slots a Hierarchical wheel reaches S^L ticks, and a timer is touched at
+// most once per level before it fires. 64 slots of 10ms on four levels
+// cover 46 hours, in 256 slots.
+type Hierarchical struct {
+	tick time.Duration
+	bits int // log2 of the slots per level
+
+	mu      sync.Mutex
+	now     atomic.Int64 // ticks advanced so far; written under mu
+	levels  [][][]*HTimer
+	mask    pow2.Mask
+	pending int
+	due     []*HTimer // scratch for Advance
+}
+
+// HTimer is a callback scheduled on a Hierarchical wheel.
+type HTimer struct {
+	h     *Hierarchical
+	at    int64 // expiry tick
+	level int
+	slot  int // -1 when not scheduled
+	idx   int // position within the slot
+	f     func()
+}
+
+// NewHierarchical returns a wheel with the given tick and levels, each of
+// slots slots rounded up to a power of two.
+func NewHierarchical(tick time.Duration, slots, levels int) *Hierarchical {
+	if tick <= 0 || slots <= 1 || levels <= 0 {
+		panic("timingwheel: tick and levels must be positive and slots above 1")
+	}
+	slots = pow2.NextPow2(slots)
+	h := &Hierarchical{tick: tick, bits: pow2.Log2Ceil(slots), mask: pow2.MaskFor(slots)}
+	h.levels = make([][][]*HTimer, levels)
+	for k := range h.levels {
+		h.levels[k] = make([][]*HTimer, slots)
+	}
+	return h
+}
+
+// Tick returns the wheel's resolution.
+func (h *Hierarchical) Tick() time.Duration { return h.tick }
+
+// Now returns the number of ticks the wheel has advanced.
+func (h *Hierarchical) Now() int64 { return h.now.Load() }
+
+// AfterFunc schedules f to run on the wheel's goroutine once d has elapsed,
+// rounded up to a whole tick.
+func (h *Hierarchical) AfterFunc(d time.Duration, f func()) *HTimer {
+	t := &HTimer{h: h, slot: -1, f: f}
+	h.mu.Lock()
+	t.at = h.now.Load() + h.ticks(d)
+	h.place(t)
+	h.mu.Unlock()
+	return t
+}
+
+// Stop unschedules t. It reports whether t was pending.
+func (t *HTimer) Stop() bool {
+	t.h.mu.Lock()
+	defer t.h.mu.Unlock()
+	return t.h.remove(t)
+}
+
+// Reset reschedules t to fire after d. It reports whether t was pending.
+func (t *HTimer) Reset(d time.Duration) bool {
+	h := t.h
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	pending := h.remove(t)
+	t.at = h.now.Load() + h.ticks(d)
+	h.place(t)
+	return pending
+}
+
+// ticks converts d to whole ticks, at least one.
+func (h *Hierarchical) ticks(d time.Duration) int64 {
+	return max(int64((d+h.tick-1)/h.tick), 1)
+}
+
+// place puts t into the lowest level at which its expiry and the current
+// tick differ only in that level's digit and below: the level whose slot
+// for t comes round before the one above cascades again. The top level
+// takes anything less than a rotation of its own away; a timer beyond
+// that waits in the top slot furthest from now and is placed again when
+// it cascades.
+func (h *Hierarchical) place(t *HTimer) {
+	now := h.now.Load()
+	top := len(h.levels) - 1
+	k := 0
+	for k < top && t.at>>(h.bits*(k+1)) != now>>(h.bits*(k+1)) {
+		k++
+	}
+	digit := t.at >> (h.bits * k)
+	if k == top && digit-now>>(h.bits*k) >= int64(h.mask.Len()) {
+		digit = now>>(h.bits*k) - 1 // reached last in the rotation
+	}
+	t.level, t.slot = k, h.mask.Index(int(digit))
+	s := h.levels[k][t.slot]
+	t.idx = len(s)
+	h.levels[k][t.slot] = append(s, t)
+	h.pending++
+}
+
+func (h *Hierarchical) remove(t *HTimer) bool {
+	if t.slot < 0 {
+		return false
+	}
+	s := h.levels[t.level][t.slot]
+	last := len(s) - 1
+	s[t.idx] = s[last]
+	s[t.idx].idx = t.idx
+	s[last] = nil
+	h.levels[t.level][t.slot] = s[:last]
+	t.slot = -1
+	h.pending--
+	return true
+}
+
+// Advance moves the wheel forward by n ticks and runs every timer that
+// expired, in the calling goroutine and without holding the wheel's lock,
+// so callbacks may start, stop or reset timers. The callbacks run once
+// the wheel has reached its new tick, as with Wheel, so a timer reset
+// from one counts from there. Advance visits every tick while timers are
+// pending, so a caller that fell far behind pays for each tick it
+// skipped.
+func (h *Hierarchical) Advance(n int) {
+	h.mu.Lock()
+	due := h.due[:0]
+	h.due = nil
+	now := h.now.Load()
+	target := now + int64(n)
+	for now < target {
+		if h.pending == 0 {
+			now = target
+			break
+		}
+		now++
+		h.now.Store(now)
+		// Higher levels first: what a level cascades may land in the
+		// slot of the level below that is due on this same tick.
+		for k := len(h.levels) - 1; k > 0; k-- {
+			if now&(1<<(h.bits*k)-1) == 0 {
+				h.cascade(k, h.mask.Index(int(now>>(h.bits*k))))
+			}
+		}
+		slot := h.mask.Index(int(now))
+		s := h.levels[0][slot]
+		h.levels[0][slot] = s[:0]
+		for i, t := range s {
+			s[i] = nil
+			t.slot = -1
+			h.pending--
+			if t.at > now {
+				h.place(t) // parked beyond the reach of a one-level wheel
+				continue
+			}
+			due = append(due, t)
+		}
+	}
+	h.now.Store(now)
+	h.mu.Unlock()
+
+	for i, t := range due {
+		t.f()
+		due[i] = nil
+	}
+	h.mu.Lock()
+	h.due = due
+	h.mu.Unlock()
+}
+
+// cascade places every timer of a slot of level k again, one level down
+// or more.
+func (h *Hierarchical) cascade(k, slot int) {
+	s := h.levels[k][slot]
+	h.levels[k][slot] = s[:0]
+	for i, t := range s {
+		s[i] = nil
+		t.slot = -1
+		h.pending--
+		h.place(t)
+	}
+}
+
+// Len returns the number of pending timers.
+func (h *Hierarchical) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.pending
+}
+
+// Run advances the wheel in real time until ctx is done.
+func (h *Hierarchical) Run(ctx context.Context) { h.RunClock(ctx, clock.Real) }
+
+// RunClock advances the wheel a tick at a time on c's ticker until ctx is
+// done.
+func (h *Hierarchical) RunClock(ctx context.Context, c clock.Clock) {
+	ticker := c.NewTicker(h.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			h.Advance(1)
+		}
+	}
+}
+
+// Deadline is a connection's read or write deadline on a Hierarchical
+// wheel, moved on every read or write. Moving it later, by far the common
+// case, only records the new expiry: the timer underneath stays where it
+// is, and when it comes due early it re-arms itself for the time left.
+// Only a deadline moved earlier than its timer reschedules it, so a busy
+// connection costs one timer visit per timeout rather than a heap update
+// per operation, which is what SetReadDeadline on a net.Conn pays.
+type Deadline struct {
+	at int64 // expiry tick, 0 when cleared; under the wheel's lock
+	t  *HTimer
+	f  func()
+}
+
+// NewDeadline returns a cleared deadline that calls f on the wheel's
+// goroutine when it passes.
+func (h *Hierarchical) NewDeadline(f func()) *Deadline {
+	d := &Deadline{f: f}
+	d.t = &HTimer{h: h, slot: -1, f: d.fire}
+	return d
+}
+
+// Set moves the deadline to timeout from now, rounded up to a tick.
+func (d *Deadline) Set(timeout time.Duration) {
+	h := d.t.h
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	d.at = h.now.Load() + h.ticks(timeout)
+	if d.t.slot < 0 || d.t.at > d.at {
+		h.remove(d.t)
+		d.t.at = d.at
+		h.place(d.t)
+	}
+}
+
+// Clear disarms the deadline. Its timer stays scheduled until it comes
+// due and finds nothing to do, unless Set re-arms it first.
+func (d *Deadline) Clear() {
+	d.t.h.mu.Lock()
+	d.at = 0
+	d.t.h.mu.Unlock()
+}
+
+// Stop disarms the deadline and unschedules its timer, for a connection
+// that is closing.
+func (d *Deadline) Stop() {
+	h := d.t.h
+	h.mu.Lock()
+	d.at = 0
+	h.remove(d.t)
+	h.mu.Unlock()
+}
+
+func (d *Deadline) fire() {
+	h := d.t.h
+	h.mu.Lock()
+	switch {
+	case d.at == 0:
+		h.mu.Unlock()
+	case d.at > h.now.Load():
+		d.t.at = d.at
+		h.place(d.t)
+		h.mu.Unlock()
+	default:
+		d.at = 0
+		h.mu.Unlock()
+		d.f()
+	}
+}
@@ -0,0 +1,111 @@
+package timingwheel
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+// TestHierarchicalFires checks every timer fires on its own tick, for
+// expiries within the first level, across every level, and beyond the
+// wheel's reach.
+func TestHierarchicalFires(t *testing.T) {
+	for _, levels := range []int{1, 2, 3} {
+		h := NewHierarchical(time.Millisecond, 8, levels) // reaches 8, 64, 512 ticks
+		r := rand.New(rand.NewPCG(1, uint64(levels)))
+		h.Advance(5) // start off a rotation boundary
+		fired := make(map[int64]int64)
+		want := make(map[int64]int64)
+		for i := range int64(500) {
+			ticks := 1 + r.Int64N(1200)
+			want[i] = h.Now() + ticks
+			h.AfterFunc(time.Duration(ticks)*time.Millisecond, func() { fired[i] = h.Now() })
+			if i%7 == 0 {
+				h.Advance(1) // a tick at a time, so callbacks see their own tick
+			}
+		}
+		for h.Len() > 0 {
+			h.Advance(1)
+		}
+		for i, at := range want {
+			if fired[i] != at {
+				t.Fatalf("%d levels: timer %d fired at tick %d, want %d", levels, i, fired[i], at)
+			}
+		}
+	}
+}
+
+func TestHierarchicalStopReset(t *testing.T) {
+	h := NewHierarchical(time.Millisecond, 4, 3)
+	var fired []string
+	a := h.AfterFunc(50*time.Millisecond, func() { fired = append(fired, "a") })
+	b := h.AfterFunc(50*time.Millisecond, func() { fired = append(fired, "b") })
+	if !a.Stop() || a.Stop() {
+		t.Fatal("Stop must report pending exactly once")
+	}
+	if !b.Reset(3 * time.Millisecond) {
+		t.Fatal("Reset of a pending timer reported not pending")
+	}
+	h.Advance(2)
+	if len(fired) != 0 {
+		t.Fatalf("fired %v at tick 2", fired)
+	}
+	h.Advance(1)
+	if len(fired) != 1 || h.Len() != 0 {
+		t.Fatalf("fired %v, %d pending", fired, h.Len())
+	}
+	// A callback may schedule timers.
+	var c *HTimer
+	c = h.AfterFunc(time.Millisecond, func() {
+		if fired = append(fired, "c"); len(fired) < 4 {
+			c.Reset(20 * time.Millisecond)
+		}
+	})
+	for range 100 {
+		h.Advance(1)
+	}
+	if len(fired) != 4 {
+		t.Fatalf("fired %v", fired)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	h := NewHierarchical(time.Millisecond, 8, 2)
+	var fired []int64
+	d := h.NewDeadline(func() { fired = append(fired, h.Now()) })
+
+	// Moving it later leaves the timer where it was; it re-arms there.
+	d.Set(10 * time.Millisecond)
+	h.Advance(5)
+	d.Set(10 * time.Millisecond)
+	if d.t.at != 10 {
+		t.Fatalf("timer moved to %d by a later deadline", d.t.at)
+	}
+	for range 14 {
+		h.Advance(1)
+	}
+	if len(fired) != 1 || fired[0] != 15 {
+		t.Fatalf("fired at %v, want 15", fired)
+	}
+
+	// Moving it earlier moves the timer.
+	d.Set(30 * time.Millisecond)
+	d.Set(2 * time.Millisecond)
+	h.Advance(2)
+	if len(fired) != 2 || fired[1] != 21 {
+		t.Fatalf("fired at %v, want 21 second", fired)
+	}
+
+	// A cleared deadline does not fire; its timer drains away.
+	d.Set(3 * time.Millisecond)
+	d.Clear()
+	h.Advance(10)
+	if len(fired) != 2 || h.Len() != 0 {
+		t.Fatalf("cleared deadline fired at %v, %d pending", fired, h.Len())
+	}
+	d.Set(3 * time.Millisecond)
+	d.Stop()
+	if h.Len() != 0 {
+		t.Fatal("Stop left the timer scheduled")
+	}
+}
@@ -0,0 +1,177 @@
+// Package timingwheel implements a hashed timing wheel: timers are kept in
+// a ring of slots, one per tick, so starting and stopping a timer is O(1)
+// and advancing the wheel touches only the timers in the current slot.
+//
+// A wheel trades precision for cost. Timers fire on the first tick at or
+// after their expiry, which is fine for idle and I/O timeouts measured in
+// seconds and far cheaper than one runtime timer per connection.
+//
+// Wheel has a single level. Hierarchical stacks several, so a fine tick
+// still reaches hours, and its Deadline is a per-connection read or
+// write deadline that is cheap to move on every operation.
+package timingwheel
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/pow2"
+)
+
+// Wheel is a single-level hashed timing wheel. Timers further out than one
+// rotation share slots with nearer ones and are skipped until their tick
+// comes round.
+type Wheel struct {
+	tick time.Duration
+
+	mu    sync.Mutex
+	now   atomic.Int64 // ticks advanced so far; written under mu
+	slots [][]*Timer
+	mask  pow2.Mask
+	due   []*Timer // scratch for Advance
+}
+
+// Timer is a callback scheduled on a Wheel.
+type Timer struct {
+	w    *Wheel
+	at   int64 // expiry tick
+	slot int   // -1 when not scheduled
+	idx  int   // position within the slot
+	f    func()
+}
+
+// New returns a wheel with the given tick and number of slots, rounded up
+// to a power of two so a tick maps to its slot with a mask. One rotation
+// spans tick*slots.
+func New(tick time.Duration, slots int) *Wheel {
+	if tick <= 0 || slots <= 0 {
+		panic("timingwheel: tick and slots must be positive")
+	}
+	slots = pow2.NextPow2(slots)
+	return &Wheel{tick: tick, slots: make([][]*Timer, slots), mask: pow2.MaskFor(slots)}
+}
+
+// Tick returns the wheel's resolution.
+func (w *Wheel) Tick() time.Duration { return w.tick }
+
+// Now returns the number of ticks the wheel has advanced. It is a cheap,
+// coarse clock: recording Now on every read instead of calling time.Now
+// keeps per-read bookkeeping to a single atomic load and store.
+func (w *Wheel) Now() int64 { return w.now.Load() }
+
+// AfterFunc schedules f to run on the wheel's goroutine once d has elapsed,
+// rounded up to a whole tick.
+func (w *Wheel) AfterFunc(d time.Duration, f func()) *Timer {
+	t := &Timer{w: w, slot: -1, f: f}
+	w.mu.Lock()
+	w.add(t, d)
+	w.mu.Unlock()
+	return t
+}
+
+// Stop unschedules t. It reports whether t was pending.
+func (t *Timer) Stop() bool {
+	t.w.mu.Lock()
+	defer t.w.mu.Unlock()
+	return t.w.remove(t)
+}
+
+// Reset reschedules t to fire after d. It reports whether t was pending.
+func (t *Timer) Reset(d time.Duration) bool {
+	t.w.mu.Lock()
+	defer t.w.mu.Unlock()
+	pending := t.w.remove(t)
+	t.w.add(t, d)
+	return pending
+}
+
+func (w *Wheel) add(t *Timer, d time.Duration) {
+	ticks := int64((d + w.tick - 1) / w.tick)
+	t.at = w.now.Load() + max(ticks, 1)
+	t.slot = w.mask.Index(int(t.at))
+	t.idx = len(w.slots[t.slot])
+	w.slots[t.slot] = append(w.slots[t.slot], t)
+}
+
+func (w *Wheel) remove(t *Timer) bool {
+	if t.slot < 0 {
+		return false
+	}
+	s := w.slots[t.slot]
+	last := len(s) - 1
+	s[t.idx] = s[last]
+	s[t.idx].idx = t.idx
+	s[last] = nil
+	w.slots[t.slot] = s[:last]
+	t.slot = -1
+	return true
+}
+
+// Advance moves the wheel forward by n ticks and runs every timer that
+// expired, in the calling goroutine and without holding the wheel's lock,
+// so callbacks may start, stop or reset timers.
+func (w *Wheel) Advance(n int) {
+	w.mu.Lock()
+	due := w.due[:0]
+	w.due = nil
+	now := w.now.Load()
+	target := now + int64(n)
+	// Visiting more than one rotation of slots would only revisit them.
+	for range min(n, len(w.slots)) {
+		now++
+		w.now.Store(now)
+		slot := w.mask.Index(int(now))
+		s := w.slots[slot]
+		for i := 0; i < len(s); {
+			if t := s[i]; t.at <= target {
+				w.remove(t)
+				due = append(due, t)
+				s = w.slots[slot]
+				continue
+			}
+			i++
+		}
+	}
+	w.now.Store(target)
+	w.mu.Unlock()
+
+	for i, t := range due {
+		t.f()
+		due[i] = nil
+	}
+	w.mu.Lock()
+	w.due = due
+	w.mu.Unlock()
+}
+
+// Len returns the number of pending timers.
+func (w *Wheel) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := 0
+	for _, s := range w.slots {
+		n += len(s)
+	}
+	return n
+}
+
+// Run advances the wheel in real time until ctx is done.
+func (w *Wheel) Run(ctx context.Context) { w.RunClock(ctx, clock.Real) }
+
+// RunClock advances the wheel a tick at a time on c's ticker until ctx is
+// done. With a clock.Fake, the timers fire as the test advances the clock.
+func (w *Wheel) RunClock(ctx context.Context, c clock.Clock) {
+	ticker := c.NewTicker(w.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			w.Advance(1)
+		}
+	}
+}
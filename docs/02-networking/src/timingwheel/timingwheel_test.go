@@ -0,0 +1,111 @@
+package timingwheel
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/clock"
+)
+
+func TestWheelFires(t *testing.T) {
+	w := New(10*time.Millisecond, 8)
+	var fired []int
+	for _, d := range []int{5, 10, 30, 75, 200} { // 200ms is more than one rotation
+		w.AfterFunc(time.Duration(d)*time.Millisecond, func() { fired = append(fired, d) })
+	}
+
+	w.Advance(1)
+	if want := []int{5, 10}; !slices.Equal(fired, want) {
+		t.Fatalf("after 1 tick fired %v, want %v", fired, want)
+	}
+	w.Advance(7)
+	if want := []int{5, 10, 30, 75}; !slices.Equal(fired, want) {
+		t.Fatalf("after 8 ticks fired %v, want %v", fired, want)
+	}
+	w.Advance(11)
+	if len(fired) != 4 {
+		t.Fatalf("200ms timer fired early at tick 19")
+	}
+	w.Advance(1)
+	if len(fired) != 5 || w.Len() != 0 {
+		t.Fatalf("fired %v, %d pending", fired, w.Len())
+	}
+}
+
+func TestWheelStopReset(t *testing.T) {
+	w := New(time.Millisecond, 4)
+	var fired int
+	a := w.AfterFunc(2*time.Millisecond, func() { fired++ })
+	b := w.AfterFunc(2*time.Millisecond, func() { fired++ })
+	c := w.AfterFunc(2*time.Millisecond, func() { fired++ })
+
+	if !a.Stop() || a.Stop() {
+		t.Fatal("Stop must report pending exactly once")
+	}
+	if !b.Reset(10 * time.Millisecond) {
+		t.Fatal("Reset of pending timer reported not pending")
+	}
+	w.Advance(2)
+	if fired != 1 {
+		t.Fatalf("fired %d timers at tick 2, want 1", fired)
+	}
+	if c.Stop() {
+		t.Fatal("Stop of fired timer reported pending")
+	}
+	w.Advance(100)
+	if fired != 2 {
+		t.Fatalf("fired %d timers, want 2", fired)
+	}
+}
+
+func TestWheelRescheduleFromCallback(t *testing.T) {
+	w := New(time.Millisecond, 4)
+	var n int
+	var tm *Timer
+	tm = w.AfterFunc(time.Millisecond, func() {
+		if n++; n < 3 {
+			tm.Reset(time.Millisecond)
+		}
+	})
+	for range 5 {
+		w.Advance(1)
+	}
+	if n != 3 {
+		t.Fatalf("callback ran %d times, want 3", n)
+	}
+}
+
+// TestRunClock drives Run from a fake clock: each timer fires on the tick
+// the test advances to. Advancing a tick at a time and waiting for its
+// timer keeps the ticks from being dropped.
+func TestRunClock(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	w := New(time.Second, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.RunClock(ctx, clk)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	fired := make(chan time.Duration, 3)
+	for _, d := range []time.Duration{3 * time.Second, time.Second, 2 * time.Second} {
+		w.AfterFunc(d, func() { fired <- d })
+	}
+	clk.BlockUntil(1)
+	for tick := 1; tick <= 3; tick++ {
+		clk.Advance(time.Second)
+		if d, want := <-fired, time.Duration(tick)*time.Second; d != want {
+			t.Fatalf("tick %d fired the %v timer, want %v", tick, d, want)
+		}
+	}
+	if w.Now() != 3 || w.Len() != 0 {
+		t.Errorf("wheel at tick %d with %d pending, want 3 and 0", w.Now(), w.Len())
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"flag"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/debugsrv"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/internal/testcert"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/tlsrecord"
+)
+
+var (
+	addr      = flag.String("addr", ":9443", "Listen address")
+	mode      = flag.String("mode", "adaptive", "Record sizing: go (crypto/tls default), large (16KB), small (1400B) or adaptive")
+	certFile  = flag.String("cert", "", "Certificate file (self-signed if empty)")
+	keyFile   = flag.String("key", "", "Key file")
+	debugAddr = flag.String("debug-addr", "", debugsrv.Usage)
+)
+
+// handle answers each "<n>\n" request line with n bytes.
+func handle(conn *tls.Conn) {
+	defer conn.Close()
+
+	var out io.Writer = conn
+	switch *mode {
+	case "adaptive":
+		out = tlsrecord.NewWriter(conn)
+	case "small":
+		w := tlsrecord.NewWriter(conn)
+		w.BoostAfter = math.MaxInt
+		out = w
+	}
+
+	reader := bufio.NewReader(conn)
+	payload := []byte(strings.Repeat("x", 1<<20))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(line))
+		if err != nil || n < 0 {
+			return
+		}
+		for n > 0 {
+			chunk := min(n, len(payload))
+			if _, err := out.Write(payload[:chunk]); err != nil {
+				log.Printf("Write failed (%s): %v", conn.RemoteAddr(), err)
+				return
+			}
+			n -= chunk
+		}
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *debugAddr != "" {
+		if err := debugsrv.Listen(*debugAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	cert, err := loadCert()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		// Anything but "go" sizes records itself and needs crypto/tls to
+		// turn each Write into exactly one record.
+		DynamicRecordSizingDisabled: *mode != "go",
+	}
+
+	ln, err := tls.Listen("tcp", *addr, cfg)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	log.Printf("TLS server listening on %s, record sizing: %s", *addr, *mode)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Printf("Accept error: %v", err)
+			continue
+		}
+		go handle(conn.(*tls.Conn))
+	}
+}
+
+func loadCert() (tls.Certificate, error) {
+	if *certFile != "" {
+		return tls.LoadX509KeyPair(*certFile, *keyFile)
+	}
+	cert, _, err := testcert.New()
+	return cert, err
+}
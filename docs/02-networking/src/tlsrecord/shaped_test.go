@@ -0,0 +1,37 @@
+package tlsrecord
+
+import (
+	"net"
+	"time"
+)
+
+// shapedConn paces writes to a fixed bandwidth in MSS-sized segments, a
+// crude stand-in for a slow access link. Loopback delivers a 16KB record
+// instantly, which hides exactly the effect being measured.
+type shapedConn struct {
+	net.Conn
+	bytesPerSec int
+	next        time.Time
+}
+
+const mss = 1448
+
+func (c *shapedConn) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		seg := p[:min(mss, len(p))]
+		now := time.Now()
+		if c.next.Before(now) {
+			c.next = now
+		}
+		c.next = c.next.Add(time.Duration(len(seg)) * time.Second / time.Duration(c.bytesPerSec))
+		time.Sleep(time.Until(c.next))
+		n, err := c.Conn.Write(seg)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
@@ -0,0 +1,81 @@
+// Package tlsrecord controls TLS record sizes from the application side.
+//
+// A TLS record can only be decrypted once it has been received in full. With
+// 16KB records the client waits for ~12 TCP segments before it sees the first
+// byte, which hurts time to first byte on a cold or lossy path. With records
+// that fit one segment each byte is usable on arrival, but every record costs
+// a header, an AEAD tag, a seal call and, in crypto/tls, a write syscall.
+//
+// Writer starts every burst with small records and switches to full-size
+// records once BoostAfter bytes have gone out, the same dynamic record
+// sizing production web servers use. crypto/tls does something similar on
+// its own, but only once per connection: after the first 128KB records stay
+// large forever, even after the connection has been idle and the congestion
+// window has collapsed. Writer restarts with small records after IdleReset.
+package tlsrecord
+
+import (
+	"io"
+	"time"
+)
+
+const (
+	// SmallRecord fits one 1460-byte MSS segment after TCP timestamps (12
+	// bytes) and TLS 1.3 AES-GCM overhead (5 header + 1 type + 16 tag),
+	// with a little slack for other ciphers.
+	SmallRecord = 1400
+	// LargeRecord is the TLS maximum plaintext size.
+	LargeRecord = 16 << 10
+)
+
+// Writer splits writes into record-sized chunks. It should wrap a *tls.Conn
+// configured with DynamicRecordSizingDisabled, so that each chunk becomes
+// exactly one record. Writer is not safe for concurrent use.
+type Writer struct {
+	w io.Writer
+
+	Small      int           // record size at the start of a burst
+	Large      int           // record size once boosted
+	BoostAfter int           // bytes sent in small records before boosting
+	IdleReset  time.Duration // idle time after which a new burst starts small; 0 never resets
+
+	sent int
+	last time.Time
+}
+
+// NewWriter returns a Writer with nginx-like defaults: 1400-byte records for
+// the first 64KB of a burst and a 1s idle reset.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:          w,
+		Small:      SmallRecord,
+		Large:      LargeRecord,
+		BoostAfter: 64 << 10,
+		IdleReset:  time.Second,
+	}
+}
+
+// Write writes p as a sequence of record-sized writes.
+func (w *Writer) Write(p []byte) (int, error) {
+	now := time.Now()
+	if w.IdleReset > 0 && now.Sub(w.last) >= w.IdleReset {
+		w.sent = 0
+	}
+	w.last = now
+
+	total := 0
+	for len(p) > 0 {
+		size := w.Large
+		if w.sent < w.BoostAfter {
+			size = min(w.Small, w.BoostAfter-w.sent)
+		}
+		n, err := w.w.Write(p[:min(size, len(p))])
+		total += n
+		w.sent += n
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
@@ -0,0 +1,194 @@
+package tlsrecord
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/internal/testcert"
+)
+
+// sizeRecorder records the size of every Write.
+type sizeRecorder struct{ sizes []int }
+
+func (r *sizeRecorder) Write(p []byte) (int, error) {
+	r.sizes = append(r.sizes, len(p))
+	return len(p), nil
+}
+
+func TestWriterBoost(t *testing.T) {
+	rec := &sizeRecorder{}
+	w := NewWriter(rec)
+	w.Small, w.Large, w.BoostAfter = 10, 100, 25
+
+	w.Write(make([]byte, 250))
+	want := []int{10, 10, 5, 100, 100, 25}
+	if len(rec.sizes) != len(want) {
+		t.Fatalf("writes = %v, want %v", rec.sizes, want)
+	}
+	for i := range want {
+		if rec.sizes[i] != want[i] {
+			t.Fatalf("writes = %v, want %v", rec.sizes, want)
+		}
+	}
+}
+
+func TestWriterIdleReset(t *testing.T) {
+	rec := &sizeRecorder{}
+	w := NewWriter(rec)
+	w.Small, w.Large, w.BoostAfter, w.IdleReset = 10, 100, 10, time.Millisecond
+
+	w.Write(make([]byte, 110)) // 10 small + 100 large
+	time.Sleep(5 * time.Millisecond)
+	w.Write(make([]byte, 20)) // idle: starts small again
+	if got := rec.sizes[len(rec.sizes)-2]; got != 10 {
+		t.Fatalf("first write after idle = %d, want 10 (writes %v)", got, rec.sizes)
+	}
+}
+
+// record size strategies under test
+const (
+	modeGo       = "go"       // crypto/tls dynamic sizing, never resets
+	modeLarge    = "large"    // DynamicRecordSizingDisabled, 16KB records
+	modeSmall    = "small"    // 1400-byte records throughout
+	modeAdaptive = "adaptive" // Writer: small at burst start, then large
+)
+
+// serve answers "<n>\n" requests with n bytes using the given strategy.
+func serve(tb testing.TB, mode string, bytesPerSec int) string {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { ln.Close() })
+
+	cert, _ := testcert.Must(tb)
+	cfg := &tls.Config{
+		Certificates:                []tls.Certificate{cert},
+		DynamicRecordSizingDisabled: mode != modeGo,
+	}
+	go func() {
+		for {
+			raw, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if bytesPerSec > 0 {
+				raw = &shapedConn{Conn: raw, bytesPerSec: bytesPerSec}
+			}
+			go func() {
+				conn := tls.Server(raw, cfg)
+				defer conn.Close()
+
+				var out io.Writer = conn
+				switch mode {
+				case modeAdaptive:
+					w := NewWriter(conn)
+					// Every response is its own burst.
+					w.IdleReset = time.Nanosecond
+					out = w
+				case modeSmall:
+					w := NewWriter(conn)
+					w.BoostAfter = math.MaxInt
+					out = w
+				}
+
+				r := bufio.NewReader(conn)
+				payload := make([]byte, 0)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					n, _ := strconv.Atoi(line[:len(line)-1])
+					if cap(payload) < n {
+						payload = make([]byte, n)
+					}
+					if _, err := out.Write(payload[:n]); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func dialTLS(tb testing.TB, addr string) *tls.Conn {
+	tb.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// warmUp pushes the connection past crypto/tls's 128KB boost threshold, as
+// happens to any long-lived connection.
+func warmUp(tb testing.TB, conn *tls.Conn) {
+	tb.Helper()
+	const n = 256 << 10
+	io.WriteString(conn, strconv.Itoa(n)+"\n")
+	if _, err := io.ReadFull(conn, make([]byte, n)); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// BenchmarkTTFB measures time to the first decrypted byte of a 64KB response
+// on a warm connection over a 20 Mbit/s link.
+func BenchmarkTTFB(b *testing.B) {
+	const size = 64 << 10
+	for _, mode := range []string{modeGo, modeLarge, modeAdaptive} {
+		b.Run(mode, func(b *testing.B) {
+			conn := dialTLS(b, serve(b, mode, 20e6/8))
+			warmUp(b, conn)
+			req := []byte(strconv.Itoa(size) + "\n")
+			first := make([]byte, 1)
+			rest := make([]byte, size-1)
+
+			var ttfb time.Duration
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				conn.Write(req)
+				if _, err := io.ReadFull(conn, first); err != nil {
+					b.Fatal(err)
+				}
+				ttfb += time.Since(start)
+				if _, err := io.ReadFull(conn, rest); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(ttfb.Microseconds())/float64(b.N), "ttfb_us")
+		})
+	}
+}
+
+// BenchmarkThroughput measures bulk transfer of 4MB responses over unshaped
+// loopback, where per-record overhead is all that differs.
+func BenchmarkThroughput(b *testing.B) {
+	const size = 4 << 20
+	for _, mode := range []string{modeSmall, modeLarge, modeAdaptive} {
+		b.Run(mode, func(b *testing.B) {
+			conn := dialTLS(b, serve(b, mode, 0))
+			req := []byte(strconv.Itoa(size) + "\n")
+			buf := make([]byte, size)
+
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				conn.Write(req)
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
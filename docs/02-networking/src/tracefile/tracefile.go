@@ -0,0 +1,189 @@
+// Package tracefile writes a runtime/trace execution trace to disk, in one
+// file or rotated into a new one by size or age, so that a server can
+// trace for hours without filling the disk:
+//
+//	rec, err := tracefile.Start(tracefile.Config{Path: "trace.out", MaxSize: 64 << 20, Keep: 4})
+//	...
+//	defer rec.Stop()
+//
+// A rotation stops the trace and starts a new one in the next file, so
+// each file opens in go tool trace on its own. A task or region that
+// spans a rotation is cut in two: the earlier file holds its start and
+// the later one its end.
+package tracefile
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime/trace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config says where the trace goes and when to rotate it.
+type Config struct {
+	// Path is the trace file. With rotation, the files are Path.1,
+	// Path.2 and so on, and Path itself is not written.
+	Path string
+
+	// MaxSize rotates once the current file has grown to this many bytes,
+	// and MaxAge once it has been written for this long. Zero disables
+	// either; with both zero the trace goes to Path until Stop.
+	MaxSize int64
+	MaxAge  time.Duration
+
+	// Keep is how many rotated files to keep, the newest; older ones are
+	// removed. Zero keeps them all.
+	Keep int
+}
+
+// checkEvery is how often the size and age are checked. The runtime
+// writes the trace in batches of a few tens of kilobytes, so a file ends
+// up to one interval's worth of tracing past MaxSize.
+const checkEvery = 100 * time.Millisecond
+
+// Recorder is a running trace. Its methods are safe to call concurrently.
+type Recorder struct {
+	cfg Config
+
+	mu      sync.Mutex
+	f       *os.File
+	written atomic.Int64
+	started time.Time
+	seq     int
+	files   []string // rotated files kept on disk, oldest first
+	stopped bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// Start starts tracing into the first file. It fails if a trace is
+// already running, as runtime/trace allows one at a time.
+func Start(cfg Config) (*Recorder, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("tracefile: empty path")
+	}
+	if cfg.MaxSize < 0 || cfg.MaxAge < 0 || cfg.Keep < 0 {
+		return nil, errors.New("tracefile: negative limit")
+	}
+	r := &Recorder{cfg: cfg, stop: make(chan struct{}), done: make(chan struct{})}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	if !r.rotates() {
+		close(r.done)
+		return r, nil
+	}
+	go r.watch()
+	return r, nil
+}
+
+func (r *Recorder) rotates() bool { return r.cfg.MaxSize > 0 || r.cfg.MaxAge > 0 }
+
+// open creates the next file and starts tracing into it.
+func (r *Recorder) open() error {
+	name := r.cfg.Path
+	if r.rotates() {
+		r.seq++
+		name = fmt.Sprintf("%s.%d", r.cfg.Path, r.seq)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("tracefile: %w", err)
+	}
+	r.written.Store(0)
+	if err := trace.Start(counter{f, &r.written}); err != nil {
+		f.Close()
+		os.Remove(name)
+		return fmt.Errorf("tracefile: %w", err)
+	}
+	r.f, r.started = f, time.Now()
+	r.files = append(r.files, name)
+	return nil
+}
+
+// close stops the trace and closes its file.
+func (r *Recorder) close() error {
+	trace.Stop()
+	return r.f.Close()
+}
+
+func (r *Recorder) watch() {
+	defer close(r.done)
+	tick := time.NewTicker(checkEvery)
+	defer tick.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-tick.C:
+		}
+		r.mu.Lock()
+		due := (r.cfg.MaxSize > 0 && r.written.Load() >= r.cfg.MaxSize) ||
+			(r.cfg.MaxAge > 0 && time.Since(r.started) >= r.cfg.MaxAge)
+		if due && !r.stopped {
+			if err := r.rotate(); err != nil {
+				// Tracing stays off; the server goes on.
+				log.Printf("tracefile: %v", err)
+				r.stopped = true
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// rotate closes the current file, starts the next and removes the oldest
+// beyond Keep. It runs with mu held.
+func (r *Recorder) rotate() error {
+	if err := r.close(); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	for r.cfg.Keep > 0 && len(r.files) > r.cfg.Keep {
+		os.Remove(r.files[0])
+		r.files = r.files[1:]
+	}
+	return nil
+}
+
+// Files returns the trace files on disk, oldest first; the last is the
+// one being written until Stop.
+func (r *Recorder) Files() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.files...)
+}
+
+// Stop ends the trace and closes the current file. Calls after the first
+// do nothing.
+func (r *Recorder) Stop() error {
+	var err error
+	r.mu.Lock()
+	if !r.stopped {
+		r.stopped = true
+		err = r.close()
+	}
+	r.mu.Unlock()
+	r.stopOnce.Do(func() { close(r.stop) })
+	<-r.done
+	return err
+}
+
+// counter counts the bytes written to a trace file.
+type counter struct {
+	f *os.File
+	n *atomic.Int64
+}
+
+func (c counter) Write(p []byte) (int, error) {
+	n, err := c.f.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
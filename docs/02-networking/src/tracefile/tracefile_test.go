@@ -0,0 +1,123 @@
+package tracefile
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime/trace"
+	"testing"
+	"time"
+)
+
+// busy makes trace events until stop is closed.
+func busy(stop <-chan struct{}) {
+	ctx, task := trace.NewTask(context.Background(), "busy")
+	defer task.End()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		trace.WithRegion(ctx, "tick", func() { time.Sleep(50 * time.Microsecond) })
+	}
+}
+
+// checkTrace fails t unless name starts with a trace header.
+func checkTrace(t *testing.T, name string) {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte("go 1.")) {
+		t.Errorf("%s: no trace header in %.16q", name, b)
+	}
+}
+
+func TestSingleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.out")
+	rec, err := Start(Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	go busy(stop)
+	time.Sleep(20 * time.Millisecond)
+	close(stop)
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Stop(); err != nil {
+		t.Errorf("second Stop: %v", err)
+	}
+	if files := rec.Files(); len(files) != 1 || files[0] != path {
+		t.Fatalf("files %q, want just %s", files, path)
+	}
+	checkTrace(t, path)
+}
+
+func TestRotate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  Config
+	}{
+		{"size", Config{MaxSize: 1, Keep: 2}},
+		{"age", Config{MaxAge: time.Millisecond, Keep: 2}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tc.cfg.Path = filepath.Join(dir, "trace.out")
+			rec, err := Start(tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stop := make(chan struct{})
+			go busy(stop)
+			deadline := time.Now().Add(10 * time.Second)
+			for time.Now().Before(deadline) {
+				time.Sleep(checkEvery)
+				rec.mu.Lock()
+				n := rec.seq
+				rec.mu.Unlock()
+				if n >= 4 {
+					break
+				}
+			}
+			close(stop)
+			if err := rec.Stop(); err != nil {
+				t.Fatal(err)
+			}
+			files := rec.Files()
+			if len(files) != 2 {
+				t.Fatalf("kept %q, want 2 files", files)
+			}
+			onDisk, _ := filepath.Glob(tc.cfg.Path + ".*")
+			if len(onDisk) != 2 {
+				t.Errorf("on disk %q, want 2 files", onDisk)
+			}
+			if _, err := os.Stat(tc.cfg.Path); !os.IsNotExist(err) {
+				t.Errorf("%s written with rotation on", tc.cfg.Path)
+			}
+			for _, f := range files {
+				checkTrace(t, f)
+			}
+		})
+	}
+}
+
+func TestStartTwice(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := Start(Config{Path: filepath.Join(dir, "a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Stop()
+	if _, err := Start(Config{Path: filepath.Join(dir, "b")}); err == nil {
+		t.Error("a second trace started")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b")); !os.IsNotExist(err) {
+		t.Error("the failed trace left its file")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package transport
+
+import (
+	"errors"
+	"net"
+)
+
+func socketpair() (client, server net.Conn, err error) {
+	return nil, nil, errors.ErrUnsupported
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package transport
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// socketpair returns the two ends of an AF_UNIX stream socketpair, wrapped
+// so the runtime poller drives them like any other socket.
+func socketpair() (client, server net.Conn, err error) {
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, nil, os.NewSyscallError("socketpair", err)
+	}
+	if client, err = fileConn(fds[0], "client"); err != nil {
+		syscall.Close(fds[1])
+		return nil, nil, err
+	}
+	if server, err = fileConn(fds[1], "server"); err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	return client, server, nil
+}
+
+// fileConn turns fd into a net.Conn. net.FileConn dups the descriptor, so
+// the original is closed either way.
+func fileConn(fd int, name string) (net.Conn, error) {
+	f := os.NewFile(uintptr(fd), "socketpair-"+name)
+	defer f.Close()
+	return net.FileConn(f)
+}
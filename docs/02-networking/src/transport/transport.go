@@ -0,0 +1,72 @@
+// Package transport connects the two ends of an in-process benchmark over
+// one of three transports, so the cost of a protocol can be separated from
+// the cost of the kernel carrying it:
+//
+//	pipe  net.Pipe: a synchronous in-memory pipe, no kernel at all
+//	unix  a socketpair(2): kernel buffers and wakeups, no network stack
+//	tcp   loopback TCP: the full stack, as in the other benchmarks
+//
+// The difference between pipe and unix is the price of a syscall-backed
+// socket; the difference between unix and tcp is the price of TCP/IP on
+// loopback. Each end is a plain net.Conn, so the codec and handler code
+// under test runs unchanged:
+//
+//	client, server, err := transport.Pair("unix")
+//	go pingpong.ServeConn(server)
+//
+// A net.Pipe write blocks until the reader has taken every byte, so a
+// client that writes a whole batch before reading needs its own writer
+// goroutine, as it would with a batch larger than the socket buffers.
+package transport
+
+import (
+	"fmt"
+	"net"
+)
+
+// Names lists the transports, from least to most kernel involvement.
+var Names = []string{"pipe", "unix", "tcp"}
+
+// Pair returns two connected ends of the named transport. Closing either
+// end makes reads on the other return io.EOF.
+func Pair(name string) (client, server net.Conn, err error) {
+	switch name {
+	case "pipe":
+		client, server = net.Pipe()
+		return client, server, nil
+	case "unix":
+		return socketpair()
+	case "tcp":
+		return loopback()
+	}
+	return nil, nil, fmt.Errorf("transport: unknown transport %q", name)
+}
+
+// loopback connects two ends through a listener on 127.0.0.1 that is
+// closed once they are.
+func loopback() (client, server net.Conn, err error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			conn = nil
+		}
+		accepted <- conn
+	}()
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		ln.Close()
+		<-accepted
+		return nil, nil, err
+	}
+	if server = <-accepted; server == nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("transport: accept on %v failed", ln.Addr())
+	}
+	return client, server, nil
+}
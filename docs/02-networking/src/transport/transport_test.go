@@ -0,0 +1,47 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestPair(t *testing.T) {
+	for _, name := range Names {
+		t.Run(name, func(t *testing.T) {
+			client, server, err := Pair(name)
+			if errors.Is(err, errors.ErrUnsupported) {
+				t.Skip(err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			// Echo one message each way, then check that closing the
+			// server ends the client's stream.
+			go func() {
+				defer server.Close()
+				buf := make([]byte, 5)
+				if _, err := io.ReadFull(server, buf); err != nil {
+					t.Error(err)
+					return
+				}
+				server.Write(buf)
+			}()
+			if _, err := client.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(client)
+			if err != nil || string(got) != "hello" {
+				t.Fatalf("read %q, %v; want hello", got, err)
+			}
+		})
+	}
+}
+
+func TestPairUnknown(t *testing.T) {
+	if _, _, err := Pair("carrier-pigeon"); err == nil {
+		t.Fatal("Pair accepted an unknown transport")
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/hashring"
+)
+
+// maxDatagram is the largest datagram relayed whole. QUIC packets are
+// sized to the path MTU, so anything larger is not QUIC; it is truncated.
+const maxDatagram = 2048
+
+var (
+	hashes = []string{"ring", "rendezvous", "modulo"}
+	keys   = []string{"addr", "cid"}
+)
+
+type config struct {
+	Listen   string
+	Backends []string
+	Hash     string        // one of hashes
+	Key      string        // one of keys
+	CIDLen   int           // length of short-header connection IDs
+	Idle     time.Duration // a flow idle this long is forgotten
+}
+
+func (c config) validate() error {
+	if !slices.Contains(hashes, c.Hash) {
+		return fmt.Errorf("unknown hash %q", c.Hash)
+	}
+	if !slices.Contains(keys, c.Key) {
+		return fmt.Errorf("unknown key %q", c.Key)
+	}
+	if c.CIDLen < 0 || c.CIDLen > maxCIDLen {
+		return fmt.Errorf("connection ID length %d not in [0, %d]", c.CIDLen, maxCIDLen)
+	}
+	if c.Idle <= 0 {
+		return errors.New("idle timeout must be positive")
+	}
+	return nil
+}
+
+// pool is one set of backends with its picker. A change of backends
+// builds a new pool and swaps it in whole.
+type pool struct {
+	picker hashring.Picker
+	addrs  map[string]*net.UDPAddr
+}
+
+func newPool(hash string, backends []string) (*pool, error) {
+	p := &pool{addrs: make(map[string]*net.UDPAddr, len(backends))}
+	for _, b := range backends {
+		a, err := net.ResolveUDPAddr("udp", b)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := p.addrs[b]; dup {
+			return nil, fmt.Errorf("backend %s listed twice", b)
+		}
+		p.addrs[b] = a
+	}
+	switch hash {
+	case "ring":
+		p.picker = hashring.NewRing(backends, 0)
+	case "rendezvous":
+		p.picker = hashring.NewRendezvous(backends)
+	default:
+		p.picker = hashring.NewModulo(backends)
+	}
+	return p, nil
+}
+
+// flow is the traffic from one client address. It has its own socket to
+// its backend, so the backend sees one peer per client and its replies
+// can be told apart.
+type flow struct {
+	client  netip.AddrPort
+	backend string
+	up      *net.UDPConn
+	last    atomic.Int64 // unix nanoseconds of the last packet either way
+}
+
+// route is a connection ID learned from a backend's handshake packet.
+type route struct {
+	backend string
+	last    atomic.Int64
+}
+
+// balancer relays datagrams between clients and backends, picking the
+// backend for each datagram by hashing its key: the client address, or
+// the QUIC Destination Connection ID.
+//
+// Hashing the connection ID alone does not work for real QUIC servers.
+// The client's first packets carry an ID the client made up; the server
+// then picks its own, which hashes somewhere else. So in cid mode the
+// balancer also reads the Source Connection ID of the backends' long
+// header replies and remembers which backend chose it. The QUIC-LB draft
+// avoids the table by having servers encode their identity in the IDs
+// they choose.
+type balancer struct {
+	cfg  config
+	ln   *net.UDPConn
+	pool atomic.Pointer[pool]
+
+	mu    sync.Mutex
+	flows map[netip.AddrPort]*flow
+
+	learned sync.Map // string(connection ID) -> *route
+
+	// key holds the client address while pick hashes it. It is only
+	// used by serve's goroutine. A local array would escape to the heap
+	// through the Picker interface, one allocation per packet.
+	key [32]byte
+
+	packets, moved, routes atomic.Int64
+}
+
+func newBalancer(cfg config) (*balancer, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	p, err := newPool(cfg.Hash, cfg.Backends)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.ListenPacket("udp", cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	b := &balancer{cfg: cfg, ln: ln.(*net.UDPConn), flows: make(map[netip.AddrPort]*flow)}
+	b.pool.Store(p)
+	return b, nil
+}
+
+// Addr returns the address clients send to.
+func (b *balancer) Addr() net.Addr { return b.ln.LocalAddr() }
+
+// setBackends replaces the backends. Flows whose key now hashes to another
+// backend move on their next packet.
+func (b *balancer) setBackends(backends []string) error {
+	p, err := newPool(b.cfg.Hash, backends)
+	if err != nil {
+		return err
+	}
+	b.pool.Store(p)
+	return nil
+}
+
+// Backends returns the current backends.
+func (b *balancer) Backends() []string { return b.pool.Load().picker.Nodes() }
+
+// pick returns the backend for a datagram from client.
+func (b *balancer) pick(p *pool, pkt []byte, client netip.AddrPort) string {
+	if b.cfg.Key == "cid" {
+		if id, ok := dcid(pkt, b.cfg.CIDLen); ok {
+			if v, ok := b.learned.Load(string(id)); ok {
+				r := v.(*route)
+				// A learned route to a backend that was removed is no
+				// use: the connection is gone with it.
+				if _, ok := p.addrs[r.backend]; ok {
+					r.last.Store(time.Now().UnixNano())
+					return r.backend
+				}
+				b.learned.Delete(string(id))
+			}
+			return p.picker.Pick(id)
+		}
+		// Not QUIC: fall back to the address.
+	}
+	// The binary form, 4 or 16 bytes and the port, is cheaper to build
+	// than the text form and hashes fewer bytes.
+	key, _ := client.AppendBinary(b.key[:0])
+	return p.picker.Pick(key)
+}
+
+// serve relays datagrams until Close.
+func (b *balancer) serve() error {
+	go b.sweep()
+	buf := make([]byte, maxDatagram)
+	for {
+		n, client, err := b.ln.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		b.packets.Add(1)
+		p := b.pool.Load()
+		backend := b.pick(p, buf[:n], client)
+		if backend == "" {
+			continue // no backends
+		}
+		f, err := b.flow(p, client, backend)
+		if err != nil {
+			log.Printf("udplb: %s: %v", backend, err)
+			continue
+		}
+		f.last.Store(time.Now().UnixNano())
+		f.up.Write(buf[:n])
+	}
+}
+
+// flow returns the client's flow to backend, replacing a flow to another
+// backend.
+func (b *balancer) flow(p *pool, client netip.AddrPort, backend string) (*flow, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.flows == nil {
+		return nil, net.ErrClosed
+	}
+	f := b.flows[client]
+	if f != nil && f.backend == backend {
+		return f, nil
+	}
+	if f != nil {
+		// The key hashes elsewhere now. Whatever state the old backend
+		// had for this client is lost to it.
+		f.up.Close()
+		delete(b.flows, client)
+		b.moved.Add(1)
+	}
+	up, err := net.DialUDP("udp", nil, p.addrs[backend])
+	if err != nil {
+		return nil, err
+	}
+	f = &flow{client: client, backend: backend, up: up}
+	b.flows[client] = f
+	go b.relay(f)
+	return f, nil
+}
+
+// relay sends the backend's replies on a flow back to its client, learning
+// the connection IDs the backend chooses on the way.
+func (b *balancer) relay(f *flow) {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, err := f.up.Read(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				// ICMP unreachable from a backend that is down, say;
+				// the client will retransmit.
+				continue
+			}
+			return
+		}
+		now := time.Now().UnixNano()
+		f.last.Store(now)
+		if b.cfg.Key == "cid" {
+			// Learn every ID, even one that hashes to this backend
+			// already: it may not after the backends change.
+			if id, ok := scid(buf[:n]); ok && len(id) > 0 {
+				r := &route{backend: f.backend}
+				r.last.Store(now)
+				if _, loaded := b.learned.LoadOrStore(string(id), r); !loaded {
+					b.routes.Add(1)
+				}
+			}
+		}
+		b.ln.WriteToUDPAddrPort(buf[:n], f.client)
+	}
+}
+
+// sweep forgets flows and learned routes idle for longer than the idle
+// timeout, closing their sockets.
+func (b *balancer) sweep() {
+	t := time.NewTicker(b.cfg.Idle / 2)
+	defer t.Stop()
+	for range t.C {
+		cutoff := time.Now().Add(-b.cfg.Idle).UnixNano()
+		b.mu.Lock()
+		if b.flows == nil {
+			b.mu.Unlock()
+			return
+		}
+		for c, f := range b.flows {
+			if f.last.Load() < cutoff {
+				f.up.Close()
+				delete(b.flows, c)
+			}
+		}
+		b.mu.Unlock()
+		b.learned.Range(func(k, v any) bool {
+			if v.(*route).last.Load() < cutoff {
+				b.learned.Delete(k)
+			}
+			return true
+		})
+	}
+}
+
+// stats is a snapshot of the balancer's counters.
+type stats struct {
+	Packets, Moved, Learned int64
+	Flows                   map[string]int // per backend
+}
+
+func (b *balancer) stats() stats {
+	s := stats{Packets: b.packets.Load(), Moved: b.moved.Load(), Learned: b.routes.Load(), Flows: map[string]int{}}
+	for _, be := range b.Backends() {
+		s.Flows[be] = 0
+	}
+	b.mu.Lock()
+	for _, f := range b.flows {
+		s.Flows[f.backend]++
+	}
+	b.mu.Unlock()
+	return s
+}
+
+// Close stops serve and closes every flow.
+func (b *balancer) Close() error {
+	err := b.ln.Close()
+	b.mu.Lock()
+	for _, f := range b.flows {
+		f.up.Close()
+	}
+	b.flows = nil
+	b.mu.Unlock()
+	return err
+}
+
+// backendsKnob is the admin knob that changes the backends at run time, a
+// comma-separated list.
+type backendsKnob struct{ b *balancer }
+
+func (k backendsKnob) String() string { return strings.Join(k.b.Backends(), ",") }
+
+func (k backendsKnob) Parse(s string) (func(), error) {
+	backends := splitList(s)
+	p, err := newPool(k.b.cfg.Hash, backends)
+	if err != nil {
+		return nil, err
+	}
+	return func() { k.b.pool.Store(p) }, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
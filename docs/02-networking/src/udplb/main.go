@@ -0,0 +1,104 @@
+// Command udplb is a UDP load balancer for QUIC servers. It picks a
+// backend for every datagram by hashing a key with one of the hashring
+// package's pickers, so the same flow keeps reaching the same backend
+// without a shared table of flows:
+//
+//	go run ./udplb -backends localhost:4242,localhost:4243,localhost:4244 -key cid
+//
+// -key addr hashes the client's address and port, like an L4 balancer.
+// -key cid hashes the QUIC Destination Connection ID instead, so a client
+// whose address changes (NAT rebinding, a move from Wi-Fi to LTE) stays on
+// its backend. -hash picks ring, rendezvous or modulo.
+//
+// The backends can be changed while it runs, through the admin knob on
+// the control address:
+//
+//	curl -X POST 'localhost:9103/knobs?backends=localhost:4242,localhost:4243'
+//
+// Each flow whose key now hashes elsewhere moves to its new backend on its
+// next datagram, and the old backend's connection is lost. -stats prints
+// how many flows each backend has and how many moved.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/admin"
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/debugsrv"
+)
+
+func main() {
+	var cfg config
+	var backends string
+	flag.StringVar(&cfg.Listen, "listen", ":4433", "UDP address clients send to")
+	flag.StringVar(&backends, "backends", "localhost:4242", "Backend UDP addresses, comma separated")
+	flag.StringVar(&cfg.Hash, "hash", "ring", "Backend picker: "+strings.Join(hashes, ", "))
+	flag.StringVar(&cfg.Key, "key", "cid", "What to hash: "+strings.Join(keys, ", "))
+	flag.IntVar(&cfg.CIDLen, "cid-len", 4, "Length of the connection IDs the backends choose (quic-go uses 4)")
+	flag.DurationVar(&cfg.Idle, "idle", 30*time.Second, "Forget a flow after this long without packets")
+	control := flag.String("control", "localhost:9103", "Control address for the admin knobs")
+	every := flag.Duration("stats", 0, "Print flows per backend at this interval (0 disables)")
+	debugAddr := flag.String("debug-addr", "", debugsrv.Usage)
+	flag.Parse()
+	if *debugAddr != "" {
+		if err := debugsrv.Listen(*debugAddr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	cfg.Backends = splitList(backends)
+
+	b, err := newBalancer(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	log.Printf("udplb: %s -> %s (hash=%s key=%s)", b.Addr(), strings.Join(cfg.Backends, ","), cfg.Hash, cfg.Key)
+
+	adm := admin.New()
+	adm.Knob("backends", "backend UDP addresses, comma separated", backendsKnob{b})
+	go func() {
+		if err := adm.ListenAndServe(*control); err != nil {
+			log.Printf("control listener: %v", err)
+		}
+	}()
+	if *every > 0 {
+		go printStats(b, *every)
+	}
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		b.Close()
+	}()
+	if err := b.serve(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func printStats(b *balancer, interval time.Duration) {
+	var last stats
+	for range time.Tick(interval) {
+		s := b.stats()
+		names := make([]string, 0, len(s.Flows))
+		for be := range s.Flows {
+			names = append(names, be)
+		}
+		slices.Sort(names)
+		var flows strings.Builder
+		for _, be := range names {
+			fmt.Fprintf(&flows, " %s=%d", be, s.Flows[be])
+		}
+		fmt.Printf("packets/s=%.0f moved=%d learned=%d flows:%s\n",
+			float64(s.Packets-last.Packets)/interval.Seconds(), s.Moved-last.Moved, s.Learned-last.Learned, flows.String())
+		last = s
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+// QUIC's invariant header fields (RFC 8999), the part of the header every
+// version keeps in the clear. A long header, used during the handshake,
+// carries both connection IDs with their lengths:
+//
+//	1 byte  flags, high bit set
+//	4 bytes version
+//	1 byte  DCID length, then the Destination Connection ID
+//	1 byte  SCID length, then the Source Connection ID
+//
+// A short header, used afterwards, carries only the DCID and not its
+// length: the endpoint that chose the ID knows it, and so must a load
+// balancer.
+const (
+	longHeader = 0x80
+	maxCIDLen  = 20 // in QUIC version 1
+)
+
+// dcid returns the Destination Connection ID of a QUIC packet, taking
+// short-header IDs to be cidLen bytes long. ok is false if p is too short
+// to hold one.
+func dcid(p []byte, cidLen int) (id []byte, ok bool) {
+	if len(p) == 0 {
+		return nil, false
+	}
+	if p[0]&longHeader == 0 {
+		if len(p) < 1+cidLen {
+			return nil, false
+		}
+		return p[1 : 1+cidLen], true
+	}
+	if len(p) < 6 {
+		return nil, false
+	}
+	n := int(p[5])
+	if n > maxCIDLen || len(p) < 6+n {
+		return nil, false
+	}
+	return p[6 : 6+n], true
+}
+
+// scid returns the Source Connection ID of a long-header packet: the ID
+// its sender wants to be addressed by from now on. ok is false for short
+// headers and truncated packets.
+func scid(p []byte) (id []byte, ok bool) {
+	if len(p) < 6 || p[0]&longHeader == 0 {
+		return nil, false
+	}
+	off := 6 + int(p[5])
+	if len(p) <= off {
+		return nil, false
+	}
+	n := int(p[off])
+	if n > maxCIDLen || len(p) < off+1+n {
+		return nil, false
+	}
+	return p[off+1 : off+1+n], true
+}
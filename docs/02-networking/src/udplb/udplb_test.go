@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// backend is a stand-in for a QUIC server. It answers a long-header
+// packet with a long header that carries a connection ID of its own
+// choosing, as a server's handshake reply does, and any other packet with
+// a short header. Both replies end in the backend's address, so the test
+// can tell who answered.
+func backend(t *testing.T) string {
+	t.Helper()
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	name := c.LocalAddr().String()
+	go func() {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, from, err := c.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var reply []byte
+			if n > 0 && buf[0]&longHeader != 0 {
+				id := make([]byte, 4)
+				rand.Read(id)
+				reply = append([]byte{0xc0, 0, 0, 0, 1, 0, byte(len(id))}, id...)
+			} else {
+				reply = []byte{0x40}
+			}
+			c.WriteTo(append(append(reply, '|'), name...), from)
+		}
+	}()
+	return name
+}
+
+func startLB(t *testing.T, cfg config) *balancer {
+	t.Helper()
+	cfg.Listen = "127.0.0.1:0"
+	cfg.Idle = time.Minute
+	b, err := newBalancer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	go b.serve()
+	return b
+}
+
+func dial(t *testing.T, b *balancer) *net.UDPConn {
+	t.Helper()
+	c, err := net.DialUDP("udp", nil, b.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// exchange sends pkt and returns the reply's header and the backend that
+// sent it.
+func exchange(t *testing.T, c *net.UDPConn, pkt []byte) (hdr []byte, from string) {
+	t.Helper()
+	if _, err := c.Write(pkt); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, maxDatagram)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.LastIndexByte(buf[:n], '|')
+	return buf[:i], string(buf[i+1 : n])
+}
+
+// TestAddrMove removes one of three backends under the address key. With
+// a ring only the clients of the removed backend move; with modulo, most
+// clients do.
+func TestAddrMove(t *testing.T) {
+	backends := []string{backend(t), backend(t), backend(t)}
+	moved := map[string]int{}
+	for _, hash := range []string{"ring", "modulo"} {
+		b := startLB(t, config{Backends: backends, Hash: hash, Key: "addr"})
+		clients := make([]*net.UDPConn, 100)
+		first := make([]string, len(clients))
+		for i := range clients {
+			clients[i] = dial(t, b)
+			_, first[i] = exchange(t, clients[i], []byte("hello"))
+		}
+		if err := b.setBackends(backends[1:]); err != nil {
+			t.Fatal(err)
+		}
+		onRemoved := 0
+		for i, c := range clients {
+			_, now := exchange(t, c, []byte("again"))
+			if now != first[i] {
+				moved[hash]++
+			}
+			if first[i] == backends[0] {
+				onRemoved++
+			} else if hash == "ring" && now != first[i] {
+				t.Errorf("ring: a client of %s moved to %s", first[i], now)
+			}
+		}
+		if got := b.stats().Moved; got != int64(moved[hash]) {
+			t.Errorf("%s: balancer counted %d moves, clients saw %d", hash, got, moved[hash])
+		}
+		t.Logf("%s: %d of %d clients moved, %d were on the removed backend", hash, moved[hash], len(clients), onRemoved)
+	}
+	if moved["modulo"] <= moved["ring"] {
+		t.Errorf("modulo moved %d clients, ring %d", moved["modulo"], moved["ring"])
+	}
+}
+
+// TestCIDMigration checks that under the connection ID key a client stays
+// with its backend when its address changes, and when another backend is
+// removed, although the ID the backend chose hashes anywhere.
+func TestCIDMigration(t *testing.T) {
+	backends := []string{backend(t), backend(t), backend(t)}
+	b := startLB(t, config{Backends: backends, Hash: "ring", Key: "cid", CIDLen: 4})
+	type conn struct {
+		cid     []byte
+		backend string
+	}
+	var conns []conn
+	for range 30 {
+		// An Initial: the client's made-up 8-byte DCID and its own SCID.
+		initial := []byte{0xc0, 0, 0, 0, 1, 8}
+		initial = append(initial, make([]byte, 8)...)
+		rand.Read(initial[6:14])
+		initial = append(initial, 4, 'c', 'l', 'n', 't')
+		hdr, from := exchange(t, dial(t, b), initial)
+		id, ok := scid(hdr)
+		if !ok || len(id) != 4 {
+			t.Fatalf("reply %x has no server connection ID", hdr)
+		}
+		conns = append(conns, conn{bytes.Clone(id), from})
+	}
+	check := func(when string) {
+		for _, c := range conns {
+			if c.backend == backends[2] {
+				continue
+			}
+			// A short header from a new address: NAT rebinding.
+			_, from := exchange(t, dial(t, b), append([]byte{0x40}, c.cid...))
+			if from != c.backend {
+				t.Errorf("%s: connection %x on %s reached %s", when, c.cid, c.backend, from)
+			}
+		}
+	}
+	check("after rebinding")
+	if err := b.setBackends(backends[:2]); err != nil {
+		t.Fatal(err)
+	}
+	check("after removing a backend")
+	if s := b.stats(); s.Learned != int64(len(conns)) {
+		t.Errorf("learned %d connection IDs, want %d", s.Learned, len(conns))
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	long := []byte{0xc0, 0, 0, 0, 1, 2, 'd', 'd', 3, 's', 's', 's', 'x'}
+	if id, ok := dcid(long, 4); !ok || string(id) != "dd" {
+		t.Errorf("long dcid = %q, %v", id, ok)
+	}
+	if id, ok := scid(long); !ok || string(id) != "sss" {
+		t.Errorf("long scid = %q, %v", id, ok)
+	}
+	short := []byte{0x40, 'a', 'b', 'c', 'd', 'x'}
+	if id, ok := dcid(short, 4); !ok || string(id) != "abcd" {
+		t.Errorf("short dcid = %q, %v", id, ok)
+	}
+	if _, ok := scid(short); ok {
+		t.Error("short header has an scid")
+	}
+	for _, p := range [][]byte{nil, {0x40, 'a'}, long[:7], {0xc0, 0, 0, 0, 1, 21}} {
+		if _, ok := dcid(p, 4); ok {
+			t.Errorf("dcid(%x) accepted a truncated packet", p)
+		}
+	}
+	for _, p := range [][]byte{long[:8], long[:10], {0xc0, 0, 0, 0, 1, 0, 21}} {
+		if _, ok := scid(p); ok {
+			t.Errorf("scid(%x) accepted a truncated packet", p)
+		}
+	}
+}
+
+// BenchmarkPick picks the backend for a datagram by its client address,
+// the work serve does for every packet in addr mode.
+func BenchmarkPick(b *testing.B) {
+	p, err := newPool("ring", []string{"10.0.0.1:4433", "10.0.0.2:4433", "10.0.0.3:4433"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	lb := &balancer{cfg: config{Key: "addr"}}
+	pkt := make([]byte, 1200)
+	for _, client := range []string{"192.0.2.1:50123", "[2001:db8::1]:50123"} {
+		from := netip.MustParseAddrPort(client)
+		b.Run(client, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				lb.pick(p, pkt, from)
+			}
+		})
+	}
+}
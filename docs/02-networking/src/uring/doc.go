@@ -0,0 +1,28 @@
+// Package uring is a minimal io_uring binding for Linux in pure Go, with
+// just enough of the interface for a socket server: accept, recv, send,
+// close and nop.
+//
+// io_uring replaces readiness with completion. Instead of asking the kernel
+// which sockets are ready and then making one syscall per read and write,
+// as an epoll loop does, the program writes the operations it wants into a
+// submission queue (SQ) shared with the kernel, and reads their results
+// from a completion queue (CQ). One io_uring_enter call submits any number
+// of operations and waits for any number of completions:
+//
+//	r, err := uring.New(1024)
+//	...
+//	sqe := r.SQE()
+//	sqe.PrepRecv(fd, buf, 0)
+//	sqe.UserData = id
+//	r.SubmitAndWait(1)
+//	r.Completions(func(c uring.CQE) {
+//		// c.UserData is id, c.Res the byte count or -errno
+//	})
+//
+// Buffers handed to an operation belong to the kernel until its completion
+// has been read: the caller must keep them alive and unchanged until then.
+// Go's garbage collector does not move heap objects, so a []byte that is
+// still referenced stays where the kernel expects it.
+//
+// A Ring is not safe for concurrent use.
+package uring
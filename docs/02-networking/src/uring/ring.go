@@ -0,0 +1,183 @@
+//go:build linux
+
+package uring
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Offsets of the regions in the ring fd, for mmap. The CQ ring shares
+// the SQ ring's mapping (IORING_FEAT_SINGLE_MMAP).
+const (
+	offSQRing = 0
+	offSQEs   = 0x10000000
+)
+
+const (
+	featSingleMmap = 1 << 0 // SQ and CQ rings share one mapping (5.4)
+	enterGetEvents = 1 << 0
+)
+
+// params is struct io_uring_params.
+type params struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFD uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  sqOffsets
+	cqOff                                                                  cqOffsets
+}
+
+type sqOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type cqOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// CQE is a completion: the UserData of the operation and its result, a
+// byte count, an fd or a negated errno depending on the operation.
+type CQE struct {
+	UserData uint64
+	Res      int32
+	Flags    uint32
+}
+
+// Err returns the error a negative Res encodes, or nil.
+func (c CQE) Err() error {
+	if c.Res < 0 {
+		return syscall.Errno(-c.Res)
+	}
+	return nil
+}
+
+// Ring is an io_uring instance with its queues mapped into memory.
+type Ring struct {
+	fd   int
+	mem  []byte // the SQ and CQ rings
+	sqeM []byte // the SQE array
+
+	sqHead, sqTail *uint32 // the kernel advances head; we advance tail
+	sqMask         uint32
+	sqes           []SQE
+	tail           uint32 // tail including SQEs not yet published
+	unsubmitted    uint32
+
+	cqHead, cqTail *uint32 // the kernel advances tail; we advance head
+	cqMask         uint32
+	cqes           []CQE
+}
+
+// New sets up a ring with room for entries submissions, rounded up to a
+// power of two by the kernel, and twice as many completions. It needs
+// Linux 5.6 for the socket operations; where io_uring is missing or
+// disabled, it returns the kernel's error (ENOSYS or EPERM).
+func New(entries uint32) (*Ring, error) {
+	var p params
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %w", errno)
+	}
+	r := &Ring{fd: int(fd)}
+	if p.features&featSingleMmap == 0 {
+		r.Close()
+		return nil, errors.New("uring: kernel too old (needs IORING_FEAT_SINGLE_MMAP)")
+	}
+	size := max(p.sqOff.array+p.sqEntries*4, p.cqOff.cqes+p.cqEntries*uint32(unsafe.Sizeof(CQE{})))
+	mem, err := unix.Mmap(r.fd, offSQRing, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("mmap rings: %w", err)
+	}
+	r.mem = mem
+	sqeM, err := unix.Mmap(r.fd, offSQEs, int(p.sqEntries)*int(unsafe.Sizeof(SQE{})), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("mmap sqes: %w", err)
+	}
+	r.sqeM = sqeM
+
+	u32 := func(off uint32) *uint32 { return (*uint32)(unsafe.Pointer(&mem[off])) }
+	r.sqHead, r.sqTail, r.sqMask = u32(p.sqOff.head), u32(p.sqOff.tail), *u32(p.sqOff.ringMask)
+	r.sqes = unsafe.Slice((*SQE)(unsafe.Pointer(&sqeM[0])), p.sqEntries)
+	r.cqHead, r.cqTail, r.cqMask = u32(p.cqOff.head), u32(p.cqOff.tail), *u32(p.cqOff.ringMask)
+	r.cqes = unsafe.Slice((*CQE)(unsafe.Pointer(&mem[p.cqOff.cqes])), p.cqEntries)
+	r.tail = *r.sqTail
+
+	// The SQ ring holds indexes into the SQE array. Slot i always holds
+	// SQE i, so the array is filled once and SQE hands out entries in
+	// ring order.
+	array := unsafe.Slice(u32(p.sqOff.array), p.sqEntries)
+	for i := range array {
+		array[i] = uint32(i)
+	}
+	return r, nil
+}
+
+// SQE returns the next free submission entry, zeroed, or nil if the queue
+// is full, in which case Submit makes room. The entry goes to the kernel
+// with the next Submit or SubmitAndWait.
+func (r *Ring) SQE() *SQE {
+	if r.tail-atomic.LoadUint32(r.sqHead) == uint32(len(r.sqes)) {
+		return nil
+	}
+	sqe := &r.sqes[r.tail&r.sqMask]
+	*sqe = SQE{}
+	r.tail++
+	r.unsubmitted++
+	return sqe
+}
+
+// Submit hands the new entries to the kernel without waiting, and returns
+// how many it took.
+func (r *Ring) Submit() (int, error) { return r.enter(0, 0) }
+
+// SubmitAndWait submits the new entries and waits until at least n
+// completions are ready. A wait interrupted by a signal returns with what
+// was submitted and no error.
+func (r *Ring) SubmitAndWait(n uint32) (int, error) { return r.enter(n, enterGetEvents) }
+
+func (r *Ring) enter(wait, flags uint32) (int, error) {
+	atomic.StoreUint32(r.sqTail, r.tail)
+	n, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(r.unsubmitted), uintptr(wait), uintptr(flags), 0, 0)
+	if errno == unix.EINTR {
+		return 0, nil
+	}
+	if errno != 0 {
+		return 0, fmt.Errorf("io_uring_enter: %w", errno)
+	}
+	r.unsubmitted -= uint32(n)
+	return int(n), nil
+}
+
+// Completions calls fn for every completion ready now, in order, then
+// releases their slots, and returns how many there were. fn may get new
+// SQEs but must not call Completions.
+func (r *Ring) Completions(fn func(CQE)) int {
+	head := *r.cqHead
+	tail := atomic.LoadUint32(r.cqTail)
+	for i := head; i != tail; i++ {
+		fn(r.cqes[i&r.cqMask])
+	}
+	atomic.StoreUint32(r.cqHead, tail)
+	return int(tail - head)
+}
+
+// Close unmaps the queues and closes the ring. Operations still in flight
+// are canceled by the kernel.
+func (r *Ring) Close() error {
+	if r.sqeM != nil {
+		unix.Munmap(r.sqeM)
+	}
+	if r.mem != nil {
+		unix.Munmap(r.mem)
+	}
+	return unix.Close(r.fd)
+}
@@ -0,0 +1,157 @@
+//go:build linux
+
+package uring
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// newRingT returns a ring closed at the end of the test, and skips where
+// io_uring is missing or disabled.
+func newRingT(t testing.TB, entries uint32) *Ring {
+	t.Helper()
+	r, err := New(entries)
+	if errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EPERM) {
+		t.Skipf("io_uring unavailable: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// wait submits and collects n completions.
+func wait(t *testing.T, r *Ring, n int) []CQE {
+	t.Helper()
+	var got []CQE
+	for len(got) < n {
+		if _, err := r.SubmitAndWait(1); err != nil {
+			t.Fatal(err)
+		}
+		r.Completions(func(c CQE) { got = append(got, c) })
+	}
+	return got
+}
+
+func TestLayout(t *testing.T) {
+	if s := unsafe.Sizeof(SQE{}); s != 64 {
+		t.Errorf("SQE is %d bytes, want 64", s)
+	}
+	if s := unsafe.Sizeof(CQE{}); s != 16 {
+		t.Errorf("CQE is %d bytes, want 16", s)
+	}
+	if s := unsafe.Sizeof(params{}); s != 120 {
+		t.Errorf("params is %d bytes, want 120", s)
+	}
+}
+
+func TestNop(t *testing.T) {
+	r := newRingT(t, 4)
+	// Submit more than the queue holds, in rounds.
+	for round := range 3 {
+		for i := range 4 {
+			sqe := r.SQE()
+			if sqe == nil {
+				t.Fatalf("round %d: queue full after %d entries", round, i)
+			}
+			sqe.PrepNop()
+			sqe.UserData = uint64(round*10 + i)
+		}
+		if r.SQE() != nil {
+			t.Fatal("a fifth entry fit in a queue of 4")
+		}
+		got := wait(t, r, 4)
+		for i, c := range got {
+			if c.UserData != uint64(round*10+i) || c.Res != 0 {
+				t.Errorf("round %d: completion %d is %+v", round, i, c)
+			}
+		}
+	}
+}
+
+func TestRecvSend(t *testing.T) {
+	r := newRingT(t, 8)
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	buf := make([]byte, 64)
+	sqe := r.SQE()
+	sqe.PrepRecv(fds[0], buf, 0)
+	sqe.UserData = 1
+	if _, err := r.Submit(); err != nil {
+		t.Fatal(err)
+	}
+	// The recv is pending in the kernel until the send gives it data.
+	sqe = r.SQE()
+	sqe.PrepSend(fds[1], []byte("hello"), 0)
+	sqe.UserData = 2
+	res := map[uint64]int32{}
+	for _, c := range wait(t, r, 2) {
+		res[c.UserData] = c.Res
+	}
+	if res[1] != 5 || res[2] != 5 || string(buf[:5]) != "hello" {
+		t.Fatalf("recv %d %q, send %d", res[1], buf[:max(res[1], 0)], res[2])
+	}
+
+	sqe = r.SQE()
+	sqe.PrepClose(fds[1])
+	sqe = r.SQE()
+	sqe.PrepRecv(fds[0], buf, 0)
+	sqe.UserData = 3
+	for _, c := range wait(t, r, 2) {
+		if c.UserData == 3 && c.Res != 0 {
+			t.Errorf("recv after the peer closed: %d, want EOF", c.Res)
+		}
+	}
+}
+
+func TestAccept(t *testing.T) {
+	r := newRingT(t, 8)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sqe := r.SQE()
+	sqe.PrepAccept(int(f.Fd()), unix.SOCK_CLOEXEC)
+	if _, err := r.Submit(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cqe := wait(t, r, 1)[0]
+	if err := cqe.Err(); err != nil {
+		t.Fatal(err)
+	}
+	unix.Close(int(cqe.Res))
+}
+
+func BenchmarkNop(b *testing.B) {
+	r := newRingT(b, 64)
+	for b.Loop() {
+		r.SQE().PrepNop()
+		if _, err := r.SubmitAndWait(1); err != nil {
+			b.Fatal(err)
+		}
+		r.Completions(func(CQE) {})
+	}
+}
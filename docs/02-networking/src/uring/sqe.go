@@ -0,0 +1,64 @@
+//go:build linux
+
+package uring
+
+import "unsafe"
+
+// Operation codes, from enum io_uring_op.
+const (
+	opNop    = 0
+	opAccept = 13
+	opClose  = 19
+	opSend   = 26
+	opRecv   = 27
+)
+
+// SQE is a submission entry, struct io_uring_sqe. Fill it with one of the
+// Prep methods and set UserData to find the completion again.
+type SQE struct {
+	Opcode      uint8
+	Flags       uint8
+	IOPrio      uint16
+	FD          int32
+	Off         uint64
+	Addr        uint64
+	Len         uint32
+	OpFlags     uint32 // msg_flags, accept_flags and the like
+	UserData    uint64
+	BufIndex    uint16
+	Personality uint16
+	SpliceFDIn  int32
+	Addr3       uint64
+	_           uint64
+}
+
+// PrepNop prepares an operation that completes at once with Res 0.
+func (s *SQE) PrepNop() { s.Opcode = opNop }
+
+// PrepAccept prepares accept4(fd, NULL, NULL, flags). Res is the new
+// connection's fd.
+func (s *SQE) PrepAccept(fd int, flags uint32) {
+	s.Opcode, s.FD, s.OpFlags = opAccept, int32(fd), flags
+}
+
+// PrepRecv prepares recv(fd, buf, flags). Res is the byte count, 0 at
+// EOF. buf must stay alive and untouched until the completion.
+func (s *SQE) PrepRecv(fd int, buf []byte, flags uint32) {
+	s.Opcode, s.FD, s.Addr, s.Len, s.OpFlags = opRecv, int32(fd), addr(buf), uint32(len(buf)), flags
+}
+
+// PrepSend prepares send(fd, buf, flags). Res is the byte count, which
+// may be short. buf must stay alive until the completion.
+func (s *SQE) PrepSend(fd int, buf []byte, flags uint32) {
+	s.Opcode, s.FD, s.Addr, s.Len, s.OpFlags = opSend, int32(fd), addr(buf), uint32(len(buf)), flags
+}
+
+// PrepClose prepares close(fd).
+func (s *SQE) PrepClose(fd int) { s.Opcode, s.FD = opClose, int32(fd) }
+
+func addr(b []byte) uint64 {
+	if len(b) == 0 {
+		return 0
+	}
+	return uint64(uintptr(unsafe.Pointer(&b[0])))
+}
@@ -0,0 +1,243 @@
+//go:build linux
+
+// Package vnet builds a small virtual network on one Linux machine for
+// tests, so that experiments with several replicas (hedging, load
+// balancing, connection migration) run under go test against the kernel's
+// real TCP and UDP stacks, with a different latency to each replica, and
+// see the same network on every run.
+//
+// New creates a network namespace for the client and one for each host,
+// joins each host to the client with its own veth pair, and shapes both
+// ends of the pair with netem:
+//
+//	n := vnet.New(t, vnet.Link{RTT: 2 * time.Millisecond}, vnet.Link{RTT: 20 * time.Millisecond})
+//	ln, err := n.Hosts[1].Listen("tcp", ":8080")
+//	...
+//	conn, err := n.Client.Dial("tcp", netip.AddrPortFrom(n.Hosts[1].Addr, 8080).String())
+//
+// Sockets belong to the namespace they were created in, so only Listen and
+// Dial have to run there; reads and writes work from any goroutine. The
+// client forwards between its links, so hosts reach each other as well,
+// across both their links. Nothing is added to the test's own namespace,
+// which keeps networks built by tests running in parallel, in one package
+// or several, apart: every Net uses the same addresses.
+//
+// New needs root. It skips the test without it, and when a Link asks for
+// shaping that the kernel cannot do, as on a kernel built without
+// sch_netem.
+package vnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/astavonin/go-optimization-guide/docs/02-networking/src/netem"
+)
+
+// Link shapes the path between the client and one host. Zero fields are
+// left out, so the zero Link is a veth pair with no added delay.
+type Link struct {
+	RTT    time.Duration // added round trip, half in each direction
+	Jitter time.Duration // +/- variation in each direction
+	Loss   float64       // packet loss in percent, in each direction
+	Rate   string        // bandwidth limit in each direction, in tc syntax, e.g. "100mbit"
+	Limit  int           // packets queued behind Rate before the rest are dropped; netem's default is 1000
+}
+
+func (l Link) netem() netem.Config {
+	return netem.Config{Delay: l.RTT / 2, Jitter: l.Jitter, Loss: l.Loss, Rate: l.Rate, Limit: l.Limit}
+}
+
+func (l Link) shaped() bool { return l != Link{} }
+
+// Host is a network namespace on a Net.
+type Host struct {
+	Name    string     // the namespace, under /run/netns
+	Addr    netip.Addr // the host's address; the zero Addr for the client, which has one per link
+	Gateway netip.Addr // the client's address on the host's link
+}
+
+// Net is a client and the hosts linked to it.
+type Net struct {
+	Client *Host
+	Hosts  []*Host
+}
+
+// prefix starts the name of every namespace this package creates.
+const prefix = "vnet"
+
+var seq atomic.Uint64
+
+// New builds a Net with one host per link and deletes it when tb ends.
+// Hosts[i] is 10.0.i.2, reached through links[i]; the client is 10.0.i.1 on
+// that link.
+func New(tb testing.TB, links ...Link) *Net {
+	tb.Helper()
+	if os.Geteuid() != 0 {
+		tb.Skip("vnet: needs root to create network namespaces")
+	}
+	if len(links) > 256 {
+		tb.Fatalf("vnet: %d links; at most 256", len(links))
+	}
+	removeStale()
+
+	name := fmt.Sprintf("%s%d-%d", prefix, os.Getpid(), seq.Add(1))
+	n := &Net{Client: &Host{Name: name + "-c"}}
+	tb.Cleanup(func() {
+		for _, h := range append(n.Hosts, n.Client) {
+			exec.Command("ip", "netns", "del", h.Name).Run()
+		}
+	})
+	must := func(err error) {
+		tb.Helper()
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	must(ip("netns", "add", n.Client.Name))
+	must(ip("-n", n.Client.Name, "link", "set", "lo", "up"))
+	must(n.Client.Do(func() error { return os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0) }))
+
+	for i, l := range links {
+		h := &Host{
+			Name:    name + "-h" + strconv.Itoa(i),
+			Addr:    netip.AddrFrom4([4]byte{10, 0, byte(i), 2}),
+			Gateway: netip.AddrFrom4([4]byte{10, 0, byte(i), 1}),
+		}
+		n.Hosts = append(n.Hosts, h)
+		// Both ends are created in their namespaces, so no device ever
+		// appears in the test's own.
+		dev := "h" + strconv.Itoa(i)
+		must(ip("netns", "add", h.Name))
+		must(ip("link", "add", dev, "netns", n.Client.Name, "type", "veth", "peer", "name", "eth0", "netns", h.Name))
+		must(ip("-n", n.Client.Name, "addr", "add", h.Gateway.String()+"/24", "dev", dev))
+		must(ip("-n", n.Client.Name, "link", "set", dev, "up"))
+		must(ip("-n", h.Name, "addr", "add", h.Addr.String()+"/24", "dev", "eth0"))
+		must(ip("-n", h.Name, "link", "set", "eth0", "up"))
+		must(ip("-n", h.Name, "link", "set", "lo", "up"))
+		must(ip("-n", h.Name, "route", "add", "default", "via", h.Gateway.String()))
+		if !l.shaped() {
+			continue
+		}
+		// Each end shapes what it sends: the client's end the requests,
+		// the host's end the replies.
+		for _, end := range []struct{ ns, dev string }{{n.Client.Name, dev}, {h.Name, "eth0"}} {
+			if err := netem.ApplyNS(end.ns, end.dev, l.netem()); err != nil {
+				tb.Skipf("vnet: cannot shape link %d: %v", i, err)
+			}
+		}
+	}
+	return n
+}
+
+// Do runs f on a thread in h's namespace. Sockets f creates stay in the
+// namespace after it returns. The thread is switched back afterwards, or
+// discarded if switching back fails.
+func (h *Host) Do(f func() error) error {
+	done := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		orig, err := os.Open("/proc/thread-self/ns/net")
+		if err != nil {
+			done <- err
+			return
+		}
+		defer orig.Close()
+		target, err := os.Open("/run/netns/" + h.Name)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer target.Close()
+		if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+			done <- fmt.Errorf("vnet: entering %s: %w", h.Name, err)
+			return
+		}
+		err = f()
+		if unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET) == nil {
+			runtime.UnlockOSThread()
+		}
+		done <- err
+	}()
+	return <-done
+}
+
+// Listen announces on the local network address in h's namespace, as
+// net.Listen does.
+func (h *Host) Listen(network, address string) (ln net.Listener, err error) {
+	err = h.Do(func() error {
+		ln, err = net.Listen(network, address)
+		return err
+	})
+	return ln, err
+}
+
+// ListenPacket is net.ListenPacket in h's namespace.
+func (h *Host) ListenPacket(network, address string) (pc net.PacketConn, err error) {
+	err = h.Do(func() error {
+		pc, err = net.ListenPacket(network, address)
+		return err
+	})
+	return pc, err
+}
+
+// DialContext connects from h's namespace. It fits
+// http.Transport.DialContext, so an HTTP client can run in a namespace
+// too. The address should be an IP address: names are looked up from
+// h's namespace, which has no resolver.
+func (h *Host) DialContext(ctx context.Context, network, address string) (conn net.Conn, err error) {
+	err = h.Do(func() error {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, network, address)
+		return err
+	})
+	return conn, err
+}
+
+// Dial is DialContext without a context.
+func (h *Host) Dial(network, address string) (net.Conn, error) {
+	return h.DialContext(context.Background(), network, address)
+}
+
+func ip(args ...string) error {
+	out, err := exec.Command("ip", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ip %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// removeStale deletes the namespaces left behind by test binaries that
+// died before their cleanup ran. The process ID in a name tells whether
+// its owner still runs.
+func removeStale() {
+	entries, err := os.ReadDir("/run/netns")
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		pid, _, ok := strings.Cut(strings.TrimPrefix(e.Name(), prefix), "-")
+		if !ok || !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+		if _, err := os.Stat("/proc/" + pid); errors.Is(err, os.ErrNotExist) {
+			exec.Command("ip", "netns", "del", e.Name()).Run()
+		}
+	}
+}
@@ -0,0 +1,102 @@
+//go:build linux
+
+package vnet
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// echo echoes every connection accepted on ln until ln is closed.
+func echo(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			io.Copy(c, c)
+		}()
+	}
+}
+
+func roundTrip(t *testing.T, h *Host, to netip.AddrPort) {
+	t.Helper()
+	c, err := h.Dial("tcp", to.String())
+	if err != nil {
+		t.Fatalf("%s to %v: %v", h.Name, to, err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4)
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("%s to %v: read %q, %v", h.Name, to, buf, err)
+	}
+}
+
+func TestNet(t *testing.T) {
+	n := New(t, Link{}, Link{})
+	ln, err := n.Hosts[0].Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go echo(ln)
+	to := netip.AddrPortFrom(n.Hosts[0].Addr, uint16(ln.Addr().(*net.TCPAddr).Port))
+
+	roundTrip(t, n.Client, to)
+	// Through the client, which forwards between the links.
+	roundTrip(t, n.Hosts[1], to)
+
+	pc, err := n.Hosts[1].ListenPacket("udp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	c, err := n.Client.Dial("udp", netip.AddrPortFrom(n.Hosts[1].Addr, uint16(pc.LocalAddr().(*net.UDPAddr).Port)).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("x"))
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, from, err := pc.ReadFrom(make([]byte, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := from.(*net.UDPAddr).AddrPort().Addr().Unmap(); got != n.Hosts[1].Gateway {
+		t.Errorf("datagram from %v, want the client's %v", got, n.Hosts[1].Gateway)
+	}
+}
+
+// TestRTT checks that each link adds its own round trip: a TCP handshake
+// takes one.
+func TestRTT(t *testing.T) {
+	rtts := []time.Duration{5 * time.Millisecond, 40 * time.Millisecond}
+	n := New(t, Link{RTT: rtts[0]}, Link{RTT: rtts[1]})
+	for i, h := range n.Hosts {
+		ln, err := h.Listen("tcp", ":0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		to := netip.AddrPortFrom(h.Addr, uint16(ln.Addr().(*net.TCPAddr).Port)).String()
+		start := time.Now()
+		c, err := n.Client.Dial("tcp", to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		took := time.Since(start)
+		c.Close()
+		if took < rtts[i] || took > rtts[i]+20*time.Millisecond {
+			t.Errorf("connect to host %d took %v, want about %v", i, took, rtts[i])
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Package workload generates reproducible pseudo-random load for the load
+// generator and the benchmarks: message sizes and think times drawn from
+// configurable distributions, and keys drawn with Zipfian popularity.
+//
+// Every draw takes an explicitly seeded *rand.Rand. Give each connection or
+// goroutine its own stream from Rand, and a run draws the same values as
+// the last one with the same seed, however the scheduler interleaves the
+// goroutines:
+//
+//	sizes, _ := workload.ParseSize("lognormal:256,1")
+//	r := workload.Rand(seed, uint64(connID))
+//	n := sizes.Int(r)
+package workload
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rand returns stream number stream of seed. Streams of the same seed are
+// independent of each other.
+func Rand(seed, stream uint64) *rand.Rand {
+	return rand.New(rand.NewPCG(seed, stream))
+}
+
+// Dist is a distribution over non-negative values, parsed from a spec:
+//
+//	fixed:V              always V (a bare V means the same)
+//	uniform:LO-HI        uniform in [LO, HI]
+//	exp:MEAN             exponential; as a think time, Poisson arrivals
+//	lognormal:MEDIAN,S   log-normal with shape S; most values near MEDIAN, a long right tail
+//	pareto:MIN,A         Pareto with tail index A; heavier tails as A falls toward 1
+//	choice:V=W,V=W,...   V with probability proportional to W
+//
+// Values are byte counts for ParseSize and durations such as 100ms for
+// ParseDuration.
+type Dist struct {
+	spec string
+	kind string
+	a, b float64
+	vals []float64 // choice
+	cum  []float64 // choice, cumulative weights ending at 1
+}
+
+// ParseSize parses a distribution of sizes in bytes.
+func ParseSize(spec string) (*Dist, error) {
+	return parse(spec, func(s string) (float64, error) {
+		n, err := strconv.ParseUint(s, 10, 31)
+		return float64(n), err
+	})
+}
+
+// ParseDuration parses a distribution of durations.
+func ParseDuration(spec string) (*Dist, error) {
+	return parse(spec, func(s string) (float64, error) {
+		d, err := time.ParseDuration(s)
+		if d < 0 {
+			err = errors.New("negative duration")
+		}
+		return float64(d), err
+	})
+}
+
+func parse(spec string, value func(string) (float64, error)) (*Dist, error) {
+	kind, args, ok := strings.Cut(spec, ":")
+	if !ok {
+		kind, args = "fixed", spec
+	}
+	d := &Dist{spec: spec, kind: kind}
+	bad := func(err error) (*Dist, error) {
+		return nil, fmt.Errorf("workload: %q: %w", spec, err)
+	}
+	// shape parses a dimensionless parameter that must be positive.
+	shape := func(s string) (float64, error) {
+		v, err := strconv.ParseFloat(s, 64)
+		if err == nil && !(v > 0) {
+			err = errors.New("shape must be positive")
+		}
+		return v, err
+	}
+	var err error
+	switch kind {
+	case "fixed", "exp":
+		d.a, err = value(args)
+	case "uniform":
+		lo, hi, ok := strings.Cut(args, "-")
+		if !ok {
+			return bad(errors.New("want uniform:LO-HI"))
+		}
+		if d.a, err = value(lo); err == nil {
+			d.b, err = value(hi)
+		}
+		if err == nil && d.b < d.a {
+			err = errors.New("HI below LO")
+		}
+	case "lognormal", "pareto":
+		scale, s, ok := strings.Cut(args, ",")
+		if !ok {
+			return bad(fmt.Errorf("want %s:SCALE,SHAPE", kind))
+		}
+		if d.a, err = value(scale); err == nil {
+			d.b, err = shape(s)
+		}
+	case "choice":
+		var sum float64
+		for _, item := range strings.Split(args, ",") {
+			v, w, ok := strings.Cut(item, "=")
+			if !ok {
+				return bad(errors.New("want choice:V=W,..."))
+			}
+			x, err := value(v)
+			if err != nil {
+				return bad(err)
+			}
+			wt, err := shape(w)
+			if err != nil {
+				return bad(err)
+			}
+			sum += wt
+			d.vals = append(d.vals, x)
+			d.cum = append(d.cum, sum)
+		}
+		for i := range d.cum {
+			d.cum[i] /= sum
+		}
+		d.cum[len(d.cum)-1] = 1
+	default:
+		return bad(errors.New("unknown distribution"))
+	}
+	if err != nil {
+		return bad(err)
+	}
+	return d, nil
+}
+
+func (d *Dist) String() string { return d.spec }
+
+// Float draws one value.
+func (d *Dist) Float(r *rand.Rand) float64 {
+	switch d.kind {
+	case "exp":
+		return d.a * r.ExpFloat64()
+	case "uniform":
+		return d.a + (d.b-d.a)*r.Float64()
+	case "lognormal":
+		return d.a * math.Exp(d.b*r.NormFloat64())
+	case "pareto":
+		// Inverse transform; 1-Float64 is in (0, 1], so never divides by 0.
+		return d.a / math.Pow(1-r.Float64(), 1/d.b)
+	case "choice":
+		i, _ := slices.BinarySearch(d.cum, r.Float64())
+		return d.vals[min(i, len(d.vals)-1)]
+	default:
+		return d.a
+	}
+}
+
+// Int draws a size, rounded to the nearest byte and capped at MaxInt32.
+func (d *Dist) Int(r *rand.Rand) int {
+	return int(min(math.Round(d.Float(r)), math.MaxInt32))
+}
+
+// Duration draws a duration.
+func (d *Dist) Duration(r *rand.Rand) time.Duration {
+	return time.Duration(min(d.Float(r), math.MaxInt64))
+}
+
+// Mean returns the distribution's expected value; +Inf for a Pareto
+// distribution with a tail index of 1 or less.
+func (d *Dist) Mean() float64 {
+	switch d.kind {
+	case "uniform":
+		return (d.a + d.b) / 2
+	case "lognormal":
+		return d.a * math.Exp(d.b*d.b/2)
+	case "pareto":
+		if d.b <= 1 {
+			return math.Inf(1)
+		}
+		return d.a * d.b / (d.b - 1)
+	case "choice":
+		var m, prev float64
+		for i, c := range d.cum {
+			m += d.vals[i] * (c - prev)
+			prev = c
+		}
+		return m
+	default:
+		return d.a
+	}
+}
+
+// Zipf draws ranks in [0, n) where rank k is drawn with probability
+// proportional to 1/(k+1)^theta, so rank 0 is the most popular key. theta
+// is the skew: 0.99, as in YCSB, sends about half of the draws to the top
+// 0.1% of a million keys. Unlike rand.Zipf, theta may be 1 or below,
+// where most measured key popularity falls.
+//
+// Draws are exact and take constant expected time, by rejection-inversion
+// (Hörmann and Derflinger, "Rejection-inversion to generate variates from
+// monotone discrete distributions", 1996).
+type Zipf struct {
+	n     uint64
+	theta float64
+	// Precomputed bounds of the inversion: hX1 and hN are H(1.5)-1 and
+	// H(n+0.5); s is the acceptance shortcut.
+	hX1, hN, s float64
+}
+
+// NewZipf returns a Zipf over n keys. It panics unless n > 0 and theta > 0.
+func NewZipf(n uint64, theta float64) *Zipf {
+	if n == 0 || !(theta > 0) {
+		panic(fmt.Sprintf("workload: NewZipf(%d, %v): need n > 0 and theta > 0", n, theta))
+	}
+	z := &Zipf{n: n, theta: theta}
+	z.hX1 = z.hIntegral(1.5) - 1
+	z.hN = z.hIntegral(float64(n) + 0.5)
+	z.s = 2 - z.hIntegralInverse(z.hIntegral(2.5)-z.h(2))
+	return z
+}
+
+// N returns the number of keys.
+func (z *Zipf) N() uint64 { return z.n }
+
+// Next draws a rank.
+func (z *Zipf) Next(r *rand.Rand) uint64 {
+	for {
+		u := z.hN + r.Float64()*(z.hX1-z.hN)
+		x := z.hIntegralInverse(u)
+		k := min(max(math.Floor(x+0.5), 1), float64(z.n))
+		if k-x <= z.s || u >= z.hIntegral(k+0.5)-z.h(k) {
+			return uint64(k) - 1
+		}
+	}
+}
+
+// h is the unnormalized density, x^-theta, over ranks counted from 1.
+func (z *Zipf) h(x float64) float64 { return math.Exp(-z.theta * math.Log(x)) }
+
+// hIntegral is an antiderivative of h, (x^(1-theta) - 1) / (1-theta),
+// written to stay accurate as theta approaches 1.
+func (z *Zipf) hIntegral(x float64) float64 {
+	lx := math.Log(x)
+	return expm1x((1-z.theta)*lx) * lx
+}
+
+func (z *Zipf) hIntegralInverse(x float64) float64 {
+	t := max(x*(1-z.theta), -1)
+	return math.Exp(log1px(t) * x)
+}
+
+// log1px is log(1+x)/x and expm1x is (exp(x)-1)/x, with their limits at 0.
+func log1px(x float64) float64 {
+	if math.Abs(x) > 1e-8 {
+		return math.Log1p(x) / x
+	}
+	return 1 - x*(0.5-x*(1.0/3-0.25*x))
+}
+
+func expm1x(x float64) float64 {
+	if math.Abs(x) > 1e-8 {
+		return math.Expm1(x) / x
+	}
+	return 1 + x*0.5*(1+x/3*(1+0.25*x))
+}
@@ -0,0 +1,116 @@
+package workload
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRandStreams(t *testing.T) {
+	draw := func(seed, stream uint64) []uint64 {
+		r := Rand(seed, stream)
+		out := make([]uint64, 8)
+		for i := range out {
+			out[i] = r.Uint64()
+		}
+		return out
+	}
+	if !slices.Equal(draw(1, 7), draw(1, 7)) {
+		t.Error("same seed and stream drew different values")
+	}
+	if slices.Equal(draw(1, 7), draw(1, 8)) || slices.Equal(draw(1, 7), draw(2, 7)) {
+		t.Error("different streams drew the same values")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"", "x", "fixed:-1", "uniform:10", "uniform:10-5", "lognormal:256",
+		"lognormal:256,0", "pareto:64,-1", "choice:64", "choice:64=0", "zipf:1",
+	} {
+		if d, err := ParseSize(spec); err == nil {
+			t.Errorf("ParseSize(%q) = %v, want an error", spec, d)
+		}
+	}
+	if _, err := ParseDuration("exp:-1s"); err == nil {
+		t.Error("negative duration accepted")
+	}
+}
+
+// TestDist checks each distribution's sample mean against Mean and, where
+// the distribution is bounded, its range.
+func TestDist(t *testing.T) {
+	for _, tc := range []struct {
+		spec   string
+		lo, hi float64
+	}{
+		{"64", 64, 64},
+		{"fixed:64", 64, 64},
+		{"uniform:32-512", 32, 512},
+		{"exp:256", 0, math.Inf(1)},
+		{"lognormal:256,0.5", 0, math.Inf(1)},
+		{"pareto:64,3", 64, math.Inf(1)},
+		{"choice:64=9,4096=1", 64, 4096},
+	} {
+		d, err := ParseSize(tc.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := Rand(1, 2)
+		const n = 200000
+		var sum float64
+		for range n {
+			v := d.Float(r)
+			if v < tc.lo || v > tc.hi {
+				t.Fatalf("%s: drew %v outside [%v, %v]", tc.spec, v, tc.lo, tc.hi)
+			}
+			sum += v
+		}
+		if mean := sum / n; math.Abs(mean-d.Mean()) > 0.02*d.Mean() {
+			t.Errorf("%s: sample mean %.1f, Mean %.1f", tc.spec, mean, d.Mean())
+		}
+	}
+
+	d, err := ParseDuration("uniform:50ms-150ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := d.Duration(Rand(1, 1)); v < 50*time.Millisecond || v > 150*time.Millisecond {
+		t.Errorf("duration %v", v)
+	}
+	if m := d.Mean(); time.Duration(m) != 100*time.Millisecond {
+		t.Errorf("mean %v", time.Duration(m))
+	}
+}
+
+func TestZipf(t *testing.T) {
+	for _, theta := range []float64{0.5, 0.99, 1, 1.5} {
+		z := NewZipf(1000, theta)
+		var norm float64
+		for k := 1; k <= 1000; k++ {
+			norm += math.Pow(float64(k), -theta)
+		}
+		prob := func(k int) float64 { return math.Pow(float64(k+1), -theta) / norm }
+
+		r := Rand(3, 4)
+		counts := make([]int, z.N())
+		const n = 1000000
+		for range n {
+			counts[z.Next(r)]++
+		}
+		for _, k := range []int{0, 1, 2, 9, 99} {
+			if got, want := float64(counts[k])/n, prob(k); math.Abs(got-want) > 0.05*want+0.0002 {
+				t.Errorf("theta=%v: rank %d drawn %.4f, want %.4f", theta, k, got, want)
+			}
+		}
+		var top, want float64
+		for k := range 100 {
+			top += float64(counts[k]) / n
+			want += prob(k)
+		}
+		if math.Abs(top-want) > 0.01 {
+			t.Errorf("theta=%v: top 100 ranks drawn %.3f, want %.3f", theta, top, want)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+// Package zerocopy sends on TCP sockets with Linux's MSG_ZEROCOPY, which
+// transmits straight from the caller's pages instead of copying them into
+// socket buffers first.
+//
+// A plain write copies every byte into the kernel before it returns, and
+// for payloads of hundreds of kilobytes that copy is most of the CPU a
+// send costs. With SO_ZEROCOPY set on the socket, a send flagged
+// MSG_ZEROCOPY pins the pages instead, and the NIC reads them by DMA. The
+// catch is that the send returns before the data has left: the buffer
+// must not be changed or reused until the kernel reports the send
+// complete, which it does by queueing a notification on the socket's
+// error queue. Reading those notifications is the caller's job, and a
+// socket whose notifications are never read stops sending with ENOBUFS.
+//
+//	s, err := zerocopy.NewSender(fd) // sets SO_ZEROCOPY
+//	err = s.SendAll(payload)         // payload now belongs to the kernel
+//	err = s.Wait()                   // and now it is back
+//
+// Pinning and notifying cost more than copying a few kilobytes, so
+// Sender writes payloads under its MinSize the ordinary way. Traffic
+// that stays on the host, over loopback or to a container on the same
+// machine, is copied anyway when it is delivered: Stats.Copied counts the
+// completions where the kernel says so, and on those the notifications
+// were pure overhead.
+package zerocopy
@@ -0,0 +1,10 @@
+package zerocopy
+
+// Stats count a Sender's sends and notifications.
+type Stats struct {
+	ZeroCopy  uint64 // sends flagged MSG_ZEROCOPY
+	Plain     uint64 // sends under MinSize, written by copying
+	Completed uint64 // zero-copy sends the kernel has released
+	Copied    uint64 // of those, sends the kernel copied after all
+	Reaps     uint64 // notifications read; the kernel merges adjacent ones
+}
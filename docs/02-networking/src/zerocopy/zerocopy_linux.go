@@ -0,0 +1,175 @@
+//go:build linux
+
+package zerocopy
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Sender sends on one TCP socket with MSG_ZEROCOPY and keeps track of the
+// sends the kernel has not yet released. It works on blocking and
+// non-blocking sockets alike, and is not safe for concurrent use.
+type Sender struct {
+	fd int
+
+	// MinSize is the smallest payload sent zero-copy; smaller ones are
+	// written the ordinary way. NewSender sets it to 10KiB, about where
+	// the kernel's own guidance puts the break-even point.
+	MinSize int
+
+	sent  uint64 // zero-copy sends, each of which gets a notification
+	stats Stats
+	oob   []byte
+}
+
+// NewSender sets SO_ZEROCOPY on fd and returns a Sender for it. Kernels
+// before 4.14, and sockets other than TCP and UDP, refuse the option.
+func NewSender(fd int) (*Sender, error) {
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ZEROCOPY, 1); err != nil {
+		return nil, err
+	}
+	return &Sender{fd: fd, MinSize: 10 << 10, oob: make([]byte, 128)}, nil
+}
+
+// Send sends p in one sendmsg call and returns the bytes the kernel took.
+// If p was sent zero-copy, p[:n] must stay unchanged until Pending drops
+// to the count before this call; Wait is the simple way to be sure.
+// ENOBUFS means too many sends are waiting for their notifications (the
+// limit is net.core.optmem_max), and Reap or Wait makes room.
+func (s *Sender) Send(p []byte) (int, error) {
+	return s.send(p, len(p) >= s.MinSize)
+}
+
+func (s *Sender) send(p []byte, zerocopy bool) (int, error) {
+	if !zerocopy {
+		n, err := syscall.Write(s.fd, p)
+		if n > 0 {
+			s.stats.Plain++
+		}
+		return max(n, 0), err
+	}
+	n, err := unix.SendmsgN(s.fd, p, nil, nil, unix.MSG_ZEROCOPY)
+	if err != nil {
+		return 0, err
+	}
+	// The kernel numbers every zero-copy send that took bytes, however
+	// few, and will notify each.
+	s.sent++
+	s.stats.ZeroCopy++
+	return n, nil
+}
+
+// SendAll sends all of p, waiting for room in the socket buffer and for
+// notifications as it needs to. p belongs to the kernel until Wait
+// returns. Whether p goes zero-copy is decided once, by its whole
+// length, so the tail of a partial send is not written by copying.
+func (s *Sender) SendAll(p []byte) error {
+	zerocopy := len(p) >= s.MinSize
+	for len(p) > 0 {
+		n, err := s.send(p, zerocopy)
+		switch err {
+		case nil:
+			p = p[n:]
+		case syscall.EAGAIN:
+			if err := s.poll(unix.POLLOUT); err != nil {
+				return err
+			}
+		case syscall.ENOBUFS:
+			if err := s.waitOne(); err != nil {
+				return err
+			}
+		case syscall.EINTR:
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Pending returns the number of zero-copy sends the kernel still holds.
+func (s *Sender) Pending() int { return int(s.sent - s.stats.Completed) }
+
+// Stats returns s's counters.
+func (s *Sender) Stats() Stats { return s.stats }
+
+// Reap reads the notifications queued on the socket's error queue
+// without blocking and returns the number of sends they completed.
+func (s *Sender) Reap() (int, error) {
+	done := 0
+	for {
+		_, oobn, _, _, err := unix.Recvmsg(s.fd, nil, s.oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+		if err == syscall.EAGAIN {
+			return done, nil
+		}
+		if err != nil {
+			return done, err
+		}
+		msgs, err := unix.ParseSocketControlMessage(s.oob[:oobn])
+		if err != nil {
+			return done, err
+		}
+		for _, m := range msgs {
+			if !isRecvErr(m.Header) || len(m.Data) < int(unsafe.Sizeof(unix.SockExtendedErr{})) {
+				continue
+			}
+			ee := (*unix.SockExtendedErr)(unsafe.Pointer(&m.Data[0]))
+			if ee.Origin != unix.SO_EE_ORIGIN_ZEROCOPY || ee.Errno != 0 {
+				continue
+			}
+			// One notification covers the sends numbered Info to Data.
+			n := uint64(ee.Data-ee.Info) + 1
+			s.stats.Reaps++
+			s.stats.Completed += n
+			if ee.Code&unix.SO_EE_CODE_ZEROCOPY_COPIED != 0 {
+				s.stats.Copied += n
+			}
+			done += int(n)
+		}
+	}
+}
+
+func isRecvErr(h unix.Cmsghdr) bool {
+	return h.Level == unix.SOL_IP && h.Type == unix.IP_RECVERR ||
+		h.Level == unix.SOL_IPV6 && h.Type == unix.IPV6_RECVERR
+}
+
+// Wait blocks until the kernel has released every zero-copy send, after
+// which their buffers may be reused.
+func (s *Sender) Wait() error {
+	for s.Pending() > 0 {
+		if err := s.waitOne(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitOne reaps, and if nothing was queued, waits for the error queue.
+func (s *Sender) waitOne() error {
+	n, err := s.Reap()
+	if n > 0 || err != nil {
+		return err
+	}
+	// A non-empty error queue makes the socket report POLLERR, which
+	// poll returns whatever was asked for.
+	return s.poll(0)
+}
+
+func (s *Sender) poll(events int16) error {
+	fds := []unix.PollFd{{Fd: int32(s.fd), Events: events}}
+	for {
+		_, err := unix.Poll(fds, -1)
+		switch {
+		case err == syscall.EINTR:
+		case err != nil:
+			return err
+		case fds[0].Revents&unix.POLLNVAL != 0:
+			return syscall.EBADF
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package zerocopy
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+var errNoZeroCopy = fmt.Errorf("zerocopy: no MSG_ZEROCOPY on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+
+// Sender needs MSG_ZEROCOPY, which only Linux has; NewSender fails
+// everywhere else.
+type Sender struct {
+	MinSize int
+}
+
+func NewSender(fd int) (*Sender, error) { return nil, errNoZeroCopy }
+
+func (s *Sender) Send(p []byte) (int, error) { return 0, errNoZeroCopy }
+func (s *Sender) SendAll(p []byte) error     { return errNoZeroCopy }
+func (s *Sender) Pending() int               { return 0 }
+func (s *Sender) Stats() Stats               { return Stats{} }
+func (s *Sender) Reap() (int, error)         { return 0, errNoZeroCopy }
+func (s *Sender) Wait() error                { return nil }